@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/igvaquero18/smarthome/api"
+	"github.com/igvaquero18/smarthome/controller"
+	"github.com/igvaquero18/smarthome/utils"
+	"github.com/labstack/echo/v4"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+const (
+	awsRegionEnv         = "SMARTHOME_AWS_REGION"
+	verboseEnv           = "SMARTHOME_VERBOSE"
+	corsOriginsEnv       = "SMARTHOME_CORS_ORIGINS"
+	dynamoDBEndpointEnv  = "SMARTHOME_DYNAMODB_ENDPOINT"
+	dynamoDBAuthTableEnv = "SMARTHOME_DYNAMODB_AUTH_TABLE"
+)
+
+const (
+	awsRegionFlag         = "aws.region"
+	verboseFlag           = "logging.verbose"
+	corsOriginsFlag       = "cors.origins"
+	dynamoDBEndpointFlag  = "aws.dynamodb.endpoint"
+	dynamoDBAuthTableFlag = "aws.dynamodb.tables.auth"
+)
+
+var (
+	c     controller.SmartHomeInterface
+	sugar *zap.SugaredLogger
+)
+
+// Response is of type APIGatewayProxyResponse since we're leveraging the
+// AWS Lambda Proxy Request functionality (default behavior)
+//
+// https://serverless.com/framework/docs/providers/aws/events/apigateway/#lambda-proxy-integration
+type Response events.APIGatewayProxyResponse
+
+func init() {
+	viper.SetDefault(awsRegionFlag, "us-east-3")
+	viper.SetDefault(verboseFlag, false)
+	viper.SetDefault(corsOriginsFlag, "")
+	viper.SetDefault(dynamoDBEndpointFlag, "")
+	viper.SetDefault(dynamoDBAuthTableFlag, controller.DefaultAuthTable)
+	viper.BindEnv(awsRegionFlag, awsRegionEnv)
+	viper.BindEnv(verboseFlag, verboseEnv)
+	viper.BindEnv(corsOriginsFlag, corsOriginsEnv)
+	viper.BindEnv(dynamoDBEndpointFlag, dynamoDBEndpointEnv)
+	viper.BindEnv(dynamoDBAuthTableFlag, dynamoDBAuthTableEnv)
+
+	var err error
+	sugar, err = utils.InitSugaredLogger(viper.GetBool(verboseFlag))
+
+	if err != nil {
+		fmt.Printf("error when initializing logger: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	region := viper.GetString(awsRegionFlag)
+	dynamoDBEndpoint := viper.GetString(dynamoDBEndpointFlag)
+
+	sugar.Infow("creating DynamoDB client", "region", region, "url", dynamoDBEndpoint)
+	dynamoClient, err := utils.InitDynamoClient(region, dynamoDBEndpoint)
+	if err != nil {
+		sugar.Fatalw("error creating DynamoDB client", "error", err.Error())
+	}
+
+	c = controller.NewSmartHome(
+		controller.SetLogger(sugar),
+		controller.SetDynamoDBClient(dynamoClient),
+		controller.SetConfig(&controller.SmartHomeConfig{
+			AuthTable: viper.GetString(dynamoDBAuthTableFlag),
+		}),
+	)
+}
+
+// Handler is our lambda handler invoked by the `lambda.Start` function call
+func Handler(request events.APIGatewayProxyRequest) (Response, error) {
+	headers := map[string]string{}
+	if viper.GetString(corsOriginsFlag) != "" {
+		headers["Access-Control-Allow-Origin"] = viper.GetString(corsOriginsFlag)
+	}
+
+	params := new(api.InvitationRequest)
+
+	if err := json.Unmarshal([]byte(request.Body), &params); err != nil {
+		return errorResponse(request, headers, echo.NewHTTPError(
+			http.StatusBadRequest,
+			fmt.Sprintf("Invalid payload: %s", err.Error()),
+		)), nil
+	}
+	if params.Role == "" {
+		params.Role = controller.RoleUser
+	}
+
+	expiresIn := controller.DefaultInvitationExpiration
+	if params.ExpiresIn != "" {
+		var err error
+		expiresIn, err = time.ParseDuration(params.ExpiresIn)
+		if err != nil || expiresIn <= 0 {
+			return errorResponse(request, headers, echo.NewHTTPError(
+				http.StatusBadRequest,
+				fmt.Sprintf("Invalid expiration %s", params.ExpiresIn),
+			)), nil
+		}
+	}
+
+	// Invitations created through API Gateway are attributed to its API key
+	createdBy := "apikey:" + request.RequestContext.Identity.APIKeyID
+	invitation, code, err := c.CreateInvitation(createdBy, params.Role, time.Now().Add(expiresIn))
+	if err != nil {
+		return errorResponse(request, headers, api.NewHTTPError(err, "Error creating invitation")), nil
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"code":       code,
+		"invitation": invitation,
+	})
+	if err != nil {
+		return errorResponse(request, headers, api.NewHTTPError(err, "Error encoding invitation")), nil
+	}
+
+	headers["Content-Type"] = "application/json"
+	return Response{
+		Body:       string(body),
+		StatusCode: http.StatusCreated,
+		Headers:    headers,
+	}, nil
+}
+
+// errorResponse returns the response for an error, logging internal errors
+func errorResponse(request events.APIGatewayProxyRequest, headers map[string]string, err error) Response {
+	response := Response(api.LambdaErrorResponse(err, request.RequestContext.RequestID, headers))
+	if response.StatusCode == http.StatusInternalServerError {
+		sugar.Errorw("error handling request", "request_id", request.RequestContext.RequestID, "error", err.Error())
+	}
+	return response
+}
+
+func main() {
+	lambda.Start(Handler)
+}
@@ -10,10 +10,13 @@ import (
 	"github.com/igvaquero18/smarthome/controller"
 	"github.com/igvaquero18/smarthome/utils"
 	"github.com/spf13/viper"
+	"go.uber.org/zap"
 )
 
 const (
 	jwtSecretEnv            = "SMARTHOME_JWT_SECRET"
+	jwtPrivateKeyEnv        = "SMARTHOME_JWT_PRIVATE_KEY"
+	jwtVerificationKeysEnv  = "SMARTHOME_JWT_VERIFICATION_KEYS"
 	awsRegionEnv            = "SMARTHOME_AWS_REGION"
 	verboseEnv              = "SMARTHOME_VERBOSE"
 	corsOriginsEnv          = "SMARTHOME_CORS_ORIGINS"
@@ -23,6 +26,8 @@ const (
 
 const (
 	jwtSecretFlag            = "server.jwt.secret"
+	jwtPrivateKeyFlag        = "server.jwt.private_key"
+	jwtVerificationKeysFlag  = "server.jwt.verification_keys"
 	awsRegionFlag            = "aws.region"
 	verboseFlag              = "logging.verbose"
 	corsOriginsFlag          = "cors.origins"
@@ -36,14 +41,20 @@ const (
 // https://serverless.com/framework/docs/providers/aws/events/apigateway/#lambda-proxy-integration
 type Response events.APIGatewayProxyResponse
 
+var sugar *zap.SugaredLogger
+
 func init() {
 	viper.SetDefault(jwtSecretFlag, "")
+	viper.SetDefault(jwtPrivateKeyFlag, "")
+	viper.SetDefault(jwtVerificationKeysFlag, "")
 	viper.SetDefault(awsRegionFlag, "us-east-3")
 	viper.SetDefault(verboseFlag, false)
 	viper.SetDefault(corsOriginsFlag, "")
 	viper.SetDefault(dynamoDBEndpointFlag, "")
 	viper.SetDefault(dynamoDBControlTableFlag, controller.DefaultControlPlaneTable)
 	viper.BindEnv(jwtSecretFlag, jwtSecretEnv)
+	viper.BindEnv(jwtPrivateKeyFlag, jwtPrivateKeyEnv)
+	viper.BindEnv(jwtVerificationKeysFlag, jwtVerificationKeysEnv)
 	viper.BindEnv(awsRegionFlag, awsRegionEnv)
 	viper.BindEnv(verboseFlag, verboseEnv)
 	viper.BindEnv(corsOriginsFlag, corsOriginsEnv)
@@ -58,7 +69,8 @@ func Handler(request events.APIGatewayProxyRequest) (Response, error) {
 		headers["Access-Control-Allow-Origin"] = viper.GetString(corsOriginsFlag)
 	}
 
-	sugar, err := utils.InitSugaredLogger(viper.GetBool(verboseFlag))
+	var err error
+	sugar, err = utils.InitSugaredLogger(viper.GetBool(verboseFlag))
 
 	if err != nil {
 		return Response{
@@ -74,11 +86,10 @@ func Handler(request events.APIGatewayProxyRequest) (Response, error) {
 	sugar.Infow("creating DynamoDB client", "region", region, "url", dynamoDBEndpoint)
 	dynamoClient, err := utils.InitDynamoClient(region, dynamoDBEndpoint)
 	if err != nil {
-		return Response{
-			StatusCode: http.StatusInternalServerError,
-			Body:       fmt.Sprintf("Internal Server Error: %s", err.Error()),
-			Headers:    headers,
-		}, fmt.Errorf("Error when creating DynamoDB client: %w", err)
+		return errorResponse(request, headers, api.NewHTTPError(
+			err,
+			"Error when creating DynamoDB client",
+		)), nil
 	}
 
 	var c controller.SmartHomeInterface = controller.NewSmartHome(
@@ -89,41 +100,40 @@ func Handler(request events.APIGatewayProxyRequest) (Response, error) {
 		}),
 	)
 
-	if err := utils.ValidateTokenFromHeader(request.Headers["Authorization"], viper.GetString(jwtSecretFlag)); err != nil {
-		return Response{
-			Body:       fmt.Sprintf("Authentication failure: %s", err.Error()),
-			StatusCode: http.StatusForbidden,
-			Headers:    headers,
-		}, nil
+	keys, err := utils.LoadKeySet(
+		viper.GetString(jwtSecretFlag),
+		viper.GetString(jwtPrivateKeyFlag),
+		utils.SplitList(viper.GetString(jwtVerificationKeysFlag)),
+	)
+	if err != nil {
+		return errorResponse(request, headers, api.NewHTTPError(err, "Error when loading JWT keys")), nil
+	}
+
+	if err := api.AuthorizeLambdaRequest(request.Headers, keys, c, controller.ScopeRoomsWrite); err != nil {
+		return errorResponse(request, headers, err), nil
 	}
 
 	room := request.PathParameters["room"]
 
 	if !api.ValidRoom(room).IsValid() {
-		return Response{
-			Body:       "Invalid room name",
-			StatusCode: http.StatusBadRequest,
-			Headers:    headers,
-		}, nil
+		return errorResponse(request, headers, api.NewInvalidRoomError(room)), nil
 	}
 
-	if room == "all" {
-		for _, r := range api.ValidRooms {
-			if err := c.DeleteRoomOptions(r); err != nil {
-				return Response{
-					StatusCode: http.StatusInternalServerError,
-					Body:       fmt.Sprintf("Internal Server Error: %s", err.Error()),
-					Headers:    headers,
-				}, fmt.Errorf("Error when deleting room %s: %w", r, err)
-			}
+	version, err := api.ParseIfMatch(room, api.HeaderValue(request.Headers, api.HeaderIfMatch))
+	if err != nil {
+		return errorResponse(request, headers, err), nil
+	}
+
+	if room == api.AllRooms {
+		if err := api.DeleteRoomsOptions(c, api.EveryRoom()); err != nil {
+			return errorResponse(request, headers, err), nil
 		}
 	} else {
-		if err := c.DeleteRoomOptions(room); err != nil {
-			return Response{
-				StatusCode: http.StatusInternalServerError,
-				Body:       fmt.Sprintf("Internal Server Error: %s", err.Error()),
-				Headers:    headers,
-			}, fmt.Errorf("Error when deleting room %s: %w", room, err)
+		if err := c.DeleteRoomOptions(room, version); err != nil {
+			return errorResponse(request, headers, api.NewHTTPError(
+				err,
+				fmt.Sprintf("Error when deleting room %s", room),
+			)), nil
 		}
 	}
 
@@ -134,6 +144,15 @@ func Handler(request events.APIGatewayProxyRequest) (Response, error) {
 	}, nil
 }
 
+// errorResponse returns the response for an error, logging internal errors
+func errorResponse(request events.APIGatewayProxyRequest, headers map[string]string, err error) Response {
+	response := Response(api.LambdaErrorResponse(err, request.RequestContext.RequestID, headers))
+	if response.StatusCode == http.StatusInternalServerError {
+		sugar.Errorw("error handling request", "request_id", request.RequestContext.RequestID, "error", err.Error())
+	}
+	return response
+}
+
 func main() {
 	lambda.Start(Handler)
 }
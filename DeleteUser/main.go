@@ -11,6 +11,7 @@ import (
 	"github.com/igvaquero18/smarthome/api"
 	"github.com/igvaquero18/smarthome/controller"
 	"github.com/igvaquero18/smarthome/utils"
+	"github.com/labstack/echo/v4"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
 )
@@ -54,7 +55,8 @@ func init() {
 	viper.BindEnv(dynamoDBEndpointFlag, dynamoDBEndpointEnv)
 	viper.BindEnv(dynamoDBAuthTableFlag, dynamoDBAuthTableEnv)
 
-	sugar, err := utils.InitSugaredLogger(viper.GetBool(verboseFlag))
+	var err error
+	sugar, err = utils.InitSugaredLogger(viper.GetBool(verboseFlag))
 
 	if err != nil {
 		fmt.Printf("error when initializing logger: %s\n", err.Error())
@@ -89,19 +91,17 @@ func Handler(request events.APIGatewayProxyRequest) (Response, error) {
 	authParams := new(api.Auth)
 
 	if err := json.Unmarshal([]byte(request.Body), &authParams); err != nil {
-		return Response{
-			Body:       fmt.Sprintf("Invalid payload: %s", err.Error()),
-			StatusCode: http.StatusBadRequest,
-			Headers:    headers,
-		}, nil
+		return errorResponse(request, headers, echo.NewHTTPError(
+			http.StatusBadRequest,
+			fmt.Sprintf("Invalid payload: %s", err.Error()),
+		)), nil
 	}
 
 	if err := c.DeleteUser(authParams.Username); err != nil {
-		return Response{
-			Body:       fmt.Sprintf("Error when deleting user from DynamoDB: %s", err.Error()),
-			StatusCode: http.StatusInternalServerError,
-			Headers:    headers,
-		}, nil
+		return errorResponse(request, headers, api.NewHTTPError(
+			err,
+			"Error when deleting user from DynamoDB",
+		)), nil
 	}
 
 	return Response{
@@ -111,6 +111,15 @@ func Handler(request events.APIGatewayProxyRequest) (Response, error) {
 	}, nil
 }
 
+// errorResponse returns the response for an error, logging internal errors
+func errorResponse(request events.APIGatewayProxyRequest, headers map[string]string, err error) Response {
+	response := Response(api.LambdaErrorResponse(err, request.RequestContext.RequestID, headers))
+	if response.StatusCode == http.StatusInternalServerError {
+		sugar.Errorw("error handling request", "request_id", request.RequestContext.RequestID, "error", err.Error())
+	}
+	return response
+}
+
 func main() {
 	lambda.Start(Handler)
 }
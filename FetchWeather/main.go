@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/igvaquero18/smarthome/controller"
+	"github.com/igvaquero18/smarthome/utils"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+const (
+	awsRegionEnv            = "SMARTHOME_AWS_REGION"
+	verboseEnv              = "SMARTHOME_VERBOSE"
+	dynamoDBEndpointEnv     = "SMARTHOME_DYNAMODB_ENDPOINT"
+	dynamoDBOutsideEnv      = "SMARTHOME_DYNAMODB_TEMPERATURE_OUTSIDE_TABLE"
+	weatherProviderEnv      = "SMARTHOME_WEATHER_PROVIDER"
+	weatherLatitudeEnv      = "SMARTHOME_WEATHER_LATITUDE"
+	weatherLongitudeEnv     = "SMARTHOME_WEATHER_LONGITUDE"
+	weatherURLEnv           = "SMARTHOME_WEATHER_URL"
+	weatherForecastHoursEnv = "SMARTHOME_WEATHER_FORECAST_HOURS"
+	weatherFileEnv          = "SMARTHOME_WEATHER_FILE"
+)
+
+const (
+	awsRegionFlag            = "aws.region"
+	verboseFlag              = "logging.verbose"
+	dynamoDBEndpointFlag     = "aws.dynamodb.endpoint"
+	dynamoDBOutsideFlag      = "aws.dynamodb.tables.outside"
+	weatherProviderFlag      = "weather.provider"
+	weatherLatitudeFlag      = "weather.latitude"
+	weatherLongitudeFlag     = "weather.longitude"
+	weatherURLFlag           = "weather.url"
+	weatherForecastHoursFlag = "weather.forecast_hours"
+	weatherFileFlag          = "weather.file"
+)
+
+var (
+	c        controller.SmartHomeInterface
+	provider utils.WeatherProvider
+	sugar    *zap.SugaredLogger
+)
+
+func init() {
+	viper.SetDefault(awsRegionFlag, "us-east-3")
+	viper.SetDefault(verboseFlag, false)
+	viper.SetDefault(dynamoDBEndpointFlag, "")
+	viper.SetDefault(dynamoDBOutsideFlag, controller.DefaultTempOutsideTable)
+	viper.SetDefault(weatherProviderFlag, utils.WeatherProviderOpenMeteo)
+	viper.SetDefault(weatherURLFlag, utils.DefaultOpenMeteoURL)
+	viper.SetDefault(weatherForecastHoursFlag, utils.DefaultForecastHours)
+	viper.BindEnv(awsRegionFlag, awsRegionEnv)
+	viper.BindEnv(verboseFlag, verboseEnv)
+	viper.BindEnv(dynamoDBEndpointFlag, dynamoDBEndpointEnv)
+	viper.BindEnv(dynamoDBOutsideFlag, dynamoDBOutsideEnv)
+	viper.BindEnv(weatherProviderFlag, weatherProviderEnv)
+	viper.BindEnv(weatherLatitudeFlag, weatherLatitudeEnv)
+	viper.BindEnv(weatherLongitudeFlag, weatherLongitudeEnv)
+	viper.BindEnv(weatherURLFlag, weatherURLEnv)
+	viper.BindEnv(weatherForecastHoursFlag, weatherForecastHoursEnv)
+	viper.BindEnv(weatherFileFlag, weatherFileEnv)
+
+	var err error
+	sugar, err = utils.InitSugaredLogger(viper.GetBool(verboseFlag))
+
+	if err != nil {
+		fmt.Printf("error when initializing logger: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	provider, err = utils.NewWeatherProvider(utils.WeatherProviderConfig{
+		Provider:      viper.GetString(weatherProviderFlag),
+		Latitude:      viper.GetFloat64(weatherLatitudeFlag),
+		Longitude:     viper.GetFloat64(weatherLongitudeFlag),
+		URL:           viper.GetString(weatherURLFlag),
+		ForecastHours: viper.GetInt(weatherForecastHoursFlag),
+		Location:      viper.GetString(weatherFileFlag),
+	})
+	if err != nil {
+		sugar.Fatalw("invalid weather provider", "error", err.Error())
+	}
+
+	region := viper.GetString(awsRegionFlag)
+	dynamoDBEndpoint := viper.GetString(dynamoDBEndpointFlag)
+
+	sugar.Infow("creating DynamoDB client", "region", region, "url", dynamoDBEndpoint)
+	dynamoClient, err := utils.InitDynamoClient(region, dynamoDBEndpoint)
+	if err != nil {
+		sugar.Fatalw("error creating DynamoDB client", "error", err.Error())
+	}
+
+	c = controller.NewSmartHome(
+		controller.SetLogger(sugar),
+		controller.SetDynamoDBClient(dynamoClient),
+		controller.SetConfig(&controller.SmartHomeConfig{
+			TempOutsideTable: viper.GetString(dynamoDBOutsideFlag),
+		}),
+	)
+}
+
+// Handler is our lambda handler invoked by the `lambda.Start` function call,
+// on the schedule of the function
+func Handler(ctx context.Context, event events.CloudWatchEvent) error {
+	result, err := c.FetchWeather(provider, time.Now())
+	if err != nil {
+		sugar.Errorw("error fetching weather", "provider", provider.Name(), "event_id", event.ID, "error", err.Error())
+		return err
+	}
+	sugar.Infow("fetched weather", "provider", provider.Name(), "observations", result.Observations, "forecasts", result.Forecasts)
+	return nil
+}
+
+func main() {
+	lambda.Start(Handler)
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
@@ -11,25 +12,49 @@ import (
 	"github.com/igvaquero18/smarthome/api"
 	"github.com/igvaquero18/smarthome/controller"
 	"github.com/igvaquero18/smarthome/utils"
+	"github.com/labstack/echo/v4"
 	"github.com/spf13/viper"
+	"go.uber.org/zap"
 )
 
 const (
-	jwtSecretEnv            = "SMARTHOME_JWT_SECRET"
-	awsRegionEnv            = "SMARTHOME_AWS_REGION"
-	verboseEnv              = "SMARTHOME_VERBOSE"
-	corsOriginsEnv          = "SMARTHOME_CORS_ORIGINS"
-	dynamoDBEndpointEnv     = "SMARTHOME_DYNAMODB_ENDPOINT"
-	dynamoDBControlTableEnv = "SMARTHOME_DYNAMODB_CONTROL_PLANE_TABLE"
+	jwtSecretEnv             = "SMARTHOME_JWT_SECRET"
+	jwtPrivateKeyEnv         = "SMARTHOME_JWT_PRIVATE_KEY"
+	jwtVerificationKeysEnv   = "SMARTHOME_JWT_VERIFICATION_KEYS"
+	awsRegionEnv             = "SMARTHOME_AWS_REGION"
+	verboseEnv               = "SMARTHOME_VERBOSE"
+	corsOriginsEnv           = "SMARTHOME_CORS_ORIGINS"
+	dynamoDBEndpointEnv      = "SMARTHOME_DYNAMODB_ENDPOINT"
+	dynamoDBControlTableEnv  = "SMARTHOME_DYNAMODB_CONTROL_PLANE_TABLE"
+	dynamoDBAuthTableEnv     = "SMARTHOME_DYNAMODB_AUTH_TABLE"
+	dynamoDBOutsideTableEnv  = "SMARTHOME_DYNAMODB_TEMPERATURE_OUTSIDE_TABLE"
+	safetyMinThresholdEnv    = "SMARTHOME_SAFETY_MIN_THRESHOLD"
+	safetyMaxThresholdEnv    = "SMARTHOME_SAFETY_MAX_THRESHOLD"
+	safetyMinHysteresisEnv   = "SMARTHOME_SAFETY_MIN_HYSTERESIS"
+	safetyFrostProtectionEnv = "SMARTHOME_SAFETY_FROST_PROTECTION"
+	safetyRoomsEnv           = "SMARTHOME_SAFETY_ROOMS"
+	thresholdPrecisionEnv    = "SMARTHOME_THRESHOLD_PRECISION"
+	weatherCompensationEnv   = "SMARTHOME_WEATHER_COMPENSATION_ROOMS"
 )
 
 const (
-	jwtSecretFlag            = "server.jwt.secret"
-	awsRegionFlag            = "aws.region"
-	verboseFlag              = "logging.verbose"
-	corsOriginsFlag          = "cors.origins"
-	dynamoDBEndpointFlag     = "aws.dynamodb.endpoint"
-	dynamoDBControlTableFlag = "aws.dynamodb.tables.control"
+	jwtSecretFlag             = "server.jwt.secret"
+	jwtPrivateKeyFlag         = "server.jwt.private_key"
+	jwtVerificationKeysFlag   = "server.jwt.verification_keys"
+	awsRegionFlag             = "aws.region"
+	verboseFlag               = "logging.verbose"
+	corsOriginsFlag           = "cors.origins"
+	dynamoDBEndpointFlag      = "aws.dynamodb.endpoint"
+	dynamoDBControlTableFlag  = "aws.dynamodb.tables.control"
+	dynamoDBAuthTableFlag     = "aws.dynamodb.tables.auth"
+	dynamoDBOutsideTableFlag  = "aws.dynamodb.tables.outside"
+	safetyMinThresholdFlag    = "safety.min_threshold"
+	safetyMaxThresholdFlag    = "safety.max_threshold"
+	safetyMinHysteresisFlag   = "safety.min_hysteresis"
+	safetyFrostProtectionFlag = "safety.frost_protection"
+	safetyRoomsFlag           = "safety.rooms"
+	thresholdPrecisionFlag    = "thresholds.precision"
+	weatherCompensationFlag   = "weather_compensation.rooms"
 )
 
 // Response is of type APIGatewayProxyResponse since we're leveraging the
@@ -38,19 +63,79 @@ const (
 // https://serverless.com/framework/docs/providers/aws/events/apigateway/#lambda-proxy-integration
 type Response events.APIGatewayProxyResponse
 
+var sugar *zap.SugaredLogger
+
 func init() {
 	viper.SetDefault(jwtSecretFlag, "")
+	viper.SetDefault(jwtPrivateKeyFlag, "")
+	viper.SetDefault(jwtVerificationKeysFlag, "")
 	viper.SetDefault(awsRegionFlag, "us-east-3")
 	viper.SetDefault(verboseFlag, false)
 	viper.SetDefault(corsOriginsFlag, "")
 	viper.SetDefault(dynamoDBEndpointFlag, "")
 	viper.SetDefault(dynamoDBControlTableFlag, controller.DefaultControlPlaneTable)
+	viper.SetDefault(dynamoDBAuthTableFlag, controller.DefaultAuthTable)
+	viper.SetDefault(dynamoDBOutsideTableFlag, controller.DefaultTempOutsideTable)
+	viper.SetDefault(safetyMinThresholdFlag, controller.DefaultMinThreshold)
+	viper.SetDefault(safetyMaxThresholdFlag, controller.DefaultMaxThreshold)
+	viper.SetDefault(safetyMinHysteresisFlag, 0)
+	viper.SetDefault(safetyFrostProtectionFlag, controller.DefaultFrostProtection)
+	viper.SetDefault(safetyRoomsFlag, "")
+	viper.SetDefault(thresholdPrecisionFlag, controller.DefaultThresholdPrecision)
+	viper.SetDefault(weatherCompensationFlag, "")
 	viper.BindEnv(jwtSecretFlag, jwtSecretEnv)
+	viper.BindEnv(jwtPrivateKeyFlag, jwtPrivateKeyEnv)
+	viper.BindEnv(jwtVerificationKeysFlag, jwtVerificationKeysEnv)
 	viper.BindEnv(awsRegionFlag, awsRegionEnv)
 	viper.BindEnv(verboseFlag, verboseEnv)
 	viper.BindEnv(corsOriginsFlag, corsOriginsEnv)
 	viper.BindEnv(dynamoDBEndpointFlag, dynamoDBEndpointEnv)
 	viper.BindEnv(dynamoDBControlTableFlag, dynamoDBControlTableEnv)
+	viper.BindEnv(dynamoDBAuthTableFlag, dynamoDBAuthTableEnv)
+	viper.BindEnv(dynamoDBOutsideTableFlag, dynamoDBOutsideTableEnv)
+	viper.BindEnv(safetyMinThresholdFlag, safetyMinThresholdEnv)
+	viper.BindEnv(safetyMaxThresholdFlag, safetyMaxThresholdEnv)
+	viper.BindEnv(safetyMinHysteresisFlag, safetyMinHysteresisEnv)
+	viper.BindEnv(safetyFrostProtectionFlag, safetyFrostProtectionEnv)
+	viper.BindEnv(safetyRoomsFlag, safetyRoomsEnv)
+	viper.BindEnv(thresholdPrecisionFlag, thresholdPrecisionEnv)
+	viper.BindEnv(weatherCompensationFlag, weatherCompensationEnv)
+}
+
+// weatherCompensation is the weather compensation of a room as given in JSON,
+// e.g. {"window": "1h", "curve": [{"outside": 0, "shift": 1}]}
+type weatherCompensation struct {
+	Window string                         `json:"window"`
+	Curve  []controller.CompensationPoint `json:"curve"`
+}
+
+// readWeatherCompensation reads the weather compensation of the rooms that
+// have one, as a JSON object by room
+func readWeatherCompensation() (map[string]controller.WeatherCompensation, error) {
+	compensation := map[string]controller.WeatherCompensation{}
+	rooms := viper.GetString(weatherCompensationFlag)
+	if rooms == "" {
+		return compensation, nil
+	}
+	policies := map[string]weatherCompensation{}
+	if err := json.Unmarshal([]byte(rooms), &policies); err != nil {
+		return nil, err
+	}
+	for room, p := range policies {
+		policy := controller.WeatherCompensation{Curve: p.Curve}
+		if p.Window != "" {
+			window, err := time.ParseDuration(p.Window)
+			if err != nil {
+				return nil, fmt.Errorf("invalid window of room %s: %w", room, err)
+			}
+			policy.Window = window
+		}
+		if err := policy.Validate(); err != nil {
+			return nil, fmt.Errorf("room %s: %w", room, err)
+		}
+		compensation[room] = policy
+	}
+	return compensation, nil
 }
 
 // Handler is our lambda handler invoked by the `lambda.Start` function call
@@ -58,9 +143,11 @@ func Handler(request events.APIGatewayProxyRequest) (Response, error) {
 	headers := map[string]string{}
 	if viper.GetString(corsOriginsFlag) != "" {
 		headers["Access-Control-Allow-Origin"] = viper.GetString(corsOriginsFlag)
+		headers["Access-Control-Expose-Headers"] = api.HeaderETag
 	}
 
-	sugar, err := utils.InitSugaredLogger(viper.GetBool(verboseFlag))
+	var err error
+	sugar, err = utils.InitSugaredLogger(viper.GetBool(verboseFlag))
 
 	if err != nil {
 		return Response{
@@ -76,79 +163,88 @@ func Handler(request events.APIGatewayProxyRequest) (Response, error) {
 	sugar.Infow("creating DynamoDB client", "region", region, "url", dynamoDBEndpoint)
 	dynamoClient, err := utils.InitDynamoClient(region, dynamoDBEndpoint)
 	if err != nil {
-		return Response{
-			StatusCode: http.StatusInternalServerError,
-			Body:       fmt.Sprintf("Internal Server Error: %s", err.Error()),
-			Headers:    headers,
-		}, fmt.Errorf("Error when creating DynamoDB client: %w", err)
+		return errorResponse(request, headers, api.NewHTTPError(
+			err,
+			"Error when creating DynamoDB client",
+		)), nil
+	}
+
+	// The safety limits give the frost protection of the rooms, which are
+	// heated up to it even if they're disabled
+	safety := controller.SafetyPolicy{
+		SafetyLimits: controller.SafetyLimits{
+			MinThreshold:    float32(viper.GetFloat64(safetyMinThresholdFlag)),
+			MaxThreshold:    float32(viper.GetFloat64(safetyMaxThresholdFlag)),
+			MinHysteresis:   float32(viper.GetFloat64(safetyMinHysteresisFlag)),
+			FrostProtection: float32(viper.GetFloat64(safetyFrostProtectionFlag)),
+		},
+	}
+	if rooms := viper.GetString(safetyRoomsFlag); rooms != "" {
+		if err := json.Unmarshal([]byte(rooms), &safety.Rooms); err != nil {
+			return errorResponse(request, headers, api.NewHTTPError(err, "Invalid safety limits of the rooms")), nil
+		}
+	}
+	if err := safety.Validate(); err != nil {
+		return errorResponse(request, headers, api.NewHTTPError(err, "Invalid safety limits")), nil
+	}
+
+	thresholdPrecision := float32(viper.GetFloat64(thresholdPrecisionFlag))
+	if err := controller.ValidateThresholdPrecision(thresholdPrecision); err != nil {
+		return errorResponse(request, headers, api.NewHTTPError(err, "Invalid threshold precision")), nil
+	}
+
+	// The weather compensation shifts the thresholds the rooms are heated
+	// with, which are returned along with their own
+	compensation, err := readWeatherCompensation()
+	if err != nil {
+		return errorResponse(request, headers, api.NewHTTPError(err, "Invalid weather compensation of the rooms")), nil
 	}
 
 	var c controller.SmartHomeInterface = controller.NewSmartHome(
 		controller.SetLogger(sugar),
 		controller.SetDynamoDBClient(dynamoClient),
 		controller.SetConfig(&controller.SmartHomeConfig{
-			ControlPlaneTable: viper.GetString(dynamoDBControlTableFlag),
+			ControlPlaneTable:   viper.GetString(dynamoDBControlTableFlag),
+			AuthTable:           viper.GetString(dynamoDBAuthTableFlag),
+			TempOutsideTable:    viper.GetString(dynamoDBOutsideTableFlag),
+			Safety:              safety,
+			ThresholdPrecision:  thresholdPrecision,
+			WeatherCompensation: compensation,
 		}),
 	)
 
-	if err := utils.ValidateTokenFromHeader(request.Headers["Authorization"], viper.GetString(jwtSecretFlag)); err != nil {
-		return Response{
-			Body:       fmt.Sprintf("Authentication failure: %s", err.Error()),
-			StatusCode: http.StatusForbidden,
-			Headers:    headers,
-		}, nil
+	keys, err := utils.LoadKeySet(
+		viper.GetString(jwtSecretFlag),
+		viper.GetString(jwtPrivateKeyFlag),
+		utils.SplitList(viper.GetString(jwtVerificationKeysFlag)),
+	)
+	if err != nil {
+		return errorResponse(request, headers, api.NewHTTPError(err, "Error when loading JWT keys")), nil
+	}
+
+	if err := api.AuthorizeLambdaRequest(request.Headers, keys, c, controller.ScopeRoomsRead); err != nil {
+		return errorResponse(request, headers, err), nil
 	}
 
 	room := request.PathParameters["room"]
 
 	if !api.ValidRoom(room).IsValid() {
-		return Response{
-			Body:       "Invalid room name",
-			StatusCode: http.StatusBadRequest,
-			Headers:    headers,
-		}, nil
+		return errorResponse(request, headers, api.NewInvalidRoomError(room)), nil
 	}
 
-	if room == "all" {
-		rooms := utils.AllButOne(api.ValidRooms, "all")
-		roomOpts := []api.RoomOptions{}
-		for _, roomName := range rooms {
-			item, err := c.GetRoomOptions(roomName)
-			if err != nil {
-				return Response{
-					Body:       fmt.Sprintf("Internal Server Error: %s", err.Error()),
-					StatusCode: http.StatusInternalServerError,
-					Headers:    headers,
-				}, fmt.Errorf("Error getting item from DynamoDB: %w", err)
-			}
-			if item == nil {
-				continue
-			}
-			roomOpt := api.RoomOptions{Name: roomName}
-			if err = attributevalue.UnmarshalMap(item, &roomOpt); err != nil {
-				return Response{
-					StatusCode: http.StatusInternalServerError,
-					Body:       fmt.Sprintf("Internal Server error: %s", err.Error()),
-					Headers:    headers,
-				}, fmt.Errorf("Error unmarshalling DynamoDB item: %w", err)
-			}
-			roomOpts = append(roomOpts, roomOpt)
-		}
+	unit, err := api.TemperatureUnit(c, request.QueryStringParameters["unit"], api.LambdaTokenSubject(request.Headers, keys))
+	if err != nil {
+		return errorResponse(request, headers, err), nil
+	}
 
-		if len(roomOpts) == 0 {
-			return Response{
-				Body:       "No rooms were found",
-				StatusCode: http.StatusNotFound,
-				Headers:    headers,
-			}, nil
+	if room == api.AllRooms {
+		roomOpts, err := api.ListRoomOptions(c, api.EveryRoom())
+		if err != nil {
+			return errorResponse(request, headers, err), nil
 		}
-		body, err := json.Marshal(roomOpts)
+		body, err := json.Marshal(api.RoomOptionsInUnit(api.WithEffectiveThresholds(c, roomOpts), unit))
 		if err != nil {
-			return Response{
-				StatusCode: http.StatusInternalServerError,
-				Body:       fmt.Sprintf("Internal Server Error: %s", err.Error()),
-				Headers:    headers,
-			}, fmt.Errorf("error marshalling response: %w", err)
+			return errorResponse(request, headers, api.NewHTTPError(err, "Error marshalling response")), nil
 		}
 		return Response{
 			Body:       string(body),
@@ -159,37 +255,34 @@ func Handler(request events.APIGatewayProxyRequest) (Response, error) {
 
 	item, err := c.GetRoomOptions(room)
 	if err != nil {
-		return Response{
-			Body:       fmt.Sprintf("Internal Server Error: %s", err.Error()),
-			StatusCode: http.StatusInternalServerError,
-			Headers:    headers,
-		}, fmt.Errorf("error getting item from DynamoDB: %w", err)
+		return errorResponse(request, headers, api.NewHTTPError(err, "Error getting item from DynamoDB")), nil
 	}
 
 	if item == nil {
-		return Response{
-			Body:       fmt.Sprintf("Room %s not found", room),
-			StatusCode: http.StatusNotFound,
-			Headers:    headers,
-		}, nil
+		return errorResponse(request, headers, echo.NewHTTPError(
+			http.StatusNotFound,
+			fmt.Sprintf("Room %s not found", room),
+		)), nil
 	}
 
 	roomOpt := api.RoomOptions{Name: room}
 	if err = attributevalue.UnmarshalMap(item, &roomOpt); err != nil {
-		return Response{
-			StatusCode: http.StatusInternalServerError,
-			Body:       fmt.Sprintf("Internal Server Error: %s", err.Error()),
-			Headers:    headers,
-		}, fmt.Errorf("Error unmarshalling DynamoDB item: %w", err)
+		return errorResponse(request, headers, api.NewHTTPError(
+			err,
+			"Error unmarshalling DynamoDB item",
+		)), nil
 	}
+	roomOpt.Effective = c.EffectiveThresholds(controller.RoomThresholds{
+		Room: room, Enabled: roomOpt.Enabled, ThresholdOn: roomOpt.ThresholdOn, ThresholdOff: roomOpt.ThresholdOff,
+	})[0]
 
-	body, err := json.Marshal(roomOpt)
+	body, err := json.Marshal(roomOpt.InUnit(unit))
 	if err != nil {
-		return Response{
-			Body:       fmt.Sprintf("Internal Server Error: %s", err.Error()),
-			StatusCode: http.StatusInternalServerError,
-			Headers:    headers,
-		}, fmt.Errorf("error marshalling response: %w", err)
+		return errorResponse(request, headers, api.NewHTTPError(err, "Error marshalling response")), nil
+	}
+
+	if roomOpt.Version > 0 {
+		headers[api.HeaderETag] = api.ETag(roomOpt.Version)
 	}
 
 	return Response{
@@ -199,6 +292,15 @@ func Handler(request events.APIGatewayProxyRequest) (Response, error) {
 	}, nil
 }
 
+// errorResponse returns the response for an error, logging internal errors
+func errorResponse(request events.APIGatewayProxyRequest, headers map[string]string, err error) Response {
+	response := Response(api.LambdaErrorResponse(err, request.RequestContext.RequestID, headers))
+	if response.StatusCode == http.StatusInternalServerError {
+		sugar.Errorw("error handling request", "request_id", request.RequestContext.RequestID, "error", err.Error())
+	}
+	return response
+}
+
 func main() {
 	lambda.Start(Handler)
 }
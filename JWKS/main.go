@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/igvaquero18/smarthome/api"
+	"github.com/igvaquero18/smarthome/utils"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+const (
+	jwtPrivateKeyEnv       = "SMARTHOME_JWT_PRIVATE_KEY"
+	jwtVerificationKeysEnv = "SMARTHOME_JWT_VERIFICATION_KEYS"
+	verboseEnv             = "SMARTHOME_VERBOSE"
+	corsOriginsEnv         = "SMARTHOME_CORS_ORIGINS"
+)
+
+const (
+	jwtPrivateKeyFlag       = "server.jwt.private_key"
+	jwtVerificationKeysFlag = "server.jwt.verification_keys"
+	verboseFlag             = "logging.verbose"
+	corsOriginsFlag         = "cors.origins"
+)
+
+var (
+	keys  *utils.KeySet
+	sugar *zap.SugaredLogger
+)
+
+// Response is of type APIGatewayProxyResponse since we're leveraging the
+// AWS Lambda Proxy Request functionality (default behavior)
+//
+// https://serverless.com/framework/docs/providers/aws/events/apigateway/#lambda-proxy-integration
+type Response events.APIGatewayProxyResponse
+
+func init() {
+	viper.SetDefault(jwtPrivateKeyFlag, "")
+	viper.SetDefault(jwtVerificationKeysFlag, "")
+	viper.SetDefault(verboseFlag, false)
+	viper.SetDefault(corsOriginsFlag, "")
+	viper.BindEnv(jwtPrivateKeyFlag, jwtPrivateKeyEnv)
+	viper.BindEnv(jwtVerificationKeysFlag, jwtVerificationKeysEnv)
+	viper.BindEnv(verboseFlag, verboseEnv)
+	viper.BindEnv(corsOriginsFlag, corsOriginsEnv)
+
+	var err error
+	sugar, err = utils.InitSugaredLogger(viper.GetBool(verboseFlag))
+
+	if err != nil {
+		fmt.Printf("error when initializing logger: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	keys, err = utils.LoadKeySet(
+		"",
+		viper.GetString(jwtPrivateKeyFlag),
+		utils.SplitList(viper.GetString(jwtVerificationKeysFlag)),
+	)
+	if err != nil {
+		sugar.Fatalw("error loading JWT keys", "error", err.Error())
+	}
+}
+
+// Handler is our lambda handler invoked by the `lambda.Start` function call
+func Handler(request events.APIGatewayProxyRequest) (Response, error) {
+	headers := map[string]string{
+		"Content-Type": "application/json",
+	}
+	if viper.GetString(corsOriginsFlag) != "" {
+		headers["Access-Control-Allow-Origin"] = viper.GetString(corsOriginsFlag)
+	}
+
+	body, err := json.Marshal(keys.JWKS())
+	if err != nil {
+		return errorResponse(request, headers, api.NewHTTPError(err, "Error marshalling response")), nil
+	}
+
+	return Response{
+		Body:       string(body),
+		StatusCode: http.StatusOK,
+		Headers:    headers,
+	}, nil
+}
+
+// errorResponse returns the response for an error, logging internal errors
+func errorResponse(request events.APIGatewayProxyRequest, headers map[string]string, err error) Response {
+	response := Response(api.LambdaErrorResponse(err, request.RequestContext.RequestID, headers))
+	if response.StatusCode == http.StatusInternalServerError {
+		sugar.Errorw("error handling request", "request_id", request.RequestContext.RequestID, "error", err.Error())
+	}
+	return response
+}
+
+func main() {
+	lambda.Start(Handler)
+}
@@ -3,8 +3,10 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"math"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/aws/aws-lambda-go/events"
@@ -13,33 +15,39 @@ import (
 	"github.com/igvaquero18/smarthome/api"
 	"github.com/igvaquero18/smarthome/controller"
 	"github.com/igvaquero18/smarthome/utils"
+	"github.com/labstack/echo/v4"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
 )
 
 const (
 	jwtSecretEnv         = "SMARTHOME_JWT_SECRET"
+	jwtPrivateKeyEnv     = "SMARTHOME_JWT_PRIVATE_KEY"
 	jwtExpirationEnv     = "SMARTHOME_JWT_EXPIRATION"
 	awsRegionEnv         = "SMARTHOME_AWS_REGION"
 	verboseEnv           = "SMARTHOME_VERBOSE"
 	corsOriginsEnv       = "SMARTHOME_CORS_ORIGINS"
 	dynamoDBEndpointEnv  = "SMARTHOME_DYNAMODB_ENDPOINT"
 	dynamoDBAuthTableEnv = "SMARTHOME_DYNAMODB_AUTH_TABLE"
+	dynamoDBAttemptsEnv  = "SMARTHOME_DYNAMODB_LOGIN_ATTEMPTS_TABLE"
 )
 
 const (
 	jwtSecretFlag         = "server.jwt.secret"
+	jwtPrivateKeyFlag     = "server.jwt.private_key"
 	jwtExpirationFlag     = "server.jwt.expiration"
 	awsRegionFlag         = "aws.region"
 	verboseFlag           = "logging.verbose"
 	corsOriginsFlag       = "cors.origins"
 	dynamoDBEndpointFlag  = "aws.dynamodb.endpoint"
 	dynamoDBAuthTableFlag = "aws.dynamodb.tables.auth"
+	dynamoDBAttemptsFlag  = "aws.dynamodb.tables.login_attempts"
 )
 
 var (
 	c          controller.SmartHomeInterface
 	sugar      *zap.SugaredLogger
+	keys       *utils.KeySet
 	expiration time.Duration
 )
 
@@ -56,15 +64,20 @@ func init() {
 	viper.SetDefault(corsOriginsFlag, "")
 	viper.SetDefault(dynamoDBEndpointFlag, "")
 	viper.SetDefault(dynamoDBAuthTableFlag, controller.DefaultAuthTable)
+	viper.SetDefault(jwtPrivateKeyFlag, "")
+	viper.SetDefault(dynamoDBAttemptsFlag, controller.DefaultLoginAttemptsTable)
 	viper.BindEnv(jwtSecretFlag, jwtSecretEnv)
+	viper.BindEnv(jwtPrivateKeyFlag, jwtPrivateKeyEnv)
 	viper.BindEnv(jwtExpirationFlag, jwtExpirationEnv)
 	viper.BindEnv(awsRegionFlag, awsRegionEnv)
 	viper.BindEnv(verboseFlag, verboseEnv)
 	viper.BindEnv(corsOriginsFlag, corsOriginsEnv)
 	viper.BindEnv(dynamoDBEndpointFlag, dynamoDBEndpointEnv)
 	viper.BindEnv(dynamoDBAuthTableFlag, dynamoDBAuthTableEnv)
+	viper.BindEnv(dynamoDBAttemptsFlag, dynamoDBAttemptsEnv)
 
-	sugar, err := utils.InitSugaredLogger(viper.GetBool(verboseFlag))
+	var err error
+	sugar, err = utils.InitSugaredLogger(viper.GetBool(verboseFlag))
 
 	if err != nil {
 		fmt.Printf("error when initializing logger: %s\n", err.Error())
@@ -86,11 +99,17 @@ func init() {
 		sugar.Fatalw("invalid parameters for the JWT expiration time", "expiration", viper.GetString(jwtExpirationFlag))
 	}
 
+	keys, err = utils.LoadKeySet(viper.GetString(jwtSecretFlag), viper.GetString(jwtPrivateKeyFlag), nil)
+	if err != nil {
+		sugar.Fatalw("error loading JWT keys", "error", err.Error())
+	}
+
 	c = controller.NewSmartHome(
 		controller.SetLogger(sugar),
 		controller.SetDynamoDBClient(dynamoClient),
 		controller.SetConfig(&controller.SmartHomeConfig{
-			AuthTable: viper.GetString(dynamoDBAuthTableFlag),
+			AuthTable:          viper.GetString(dynamoDBAuthTableFlag),
+			LoginAttemptsTable: viper.GetString(dynamoDBAttemptsFlag),
 		}),
 	)
 }
@@ -105,33 +124,73 @@ func Handler(request events.APIGatewayProxyRequest) (Response, error) {
 	authParams := new(api.Auth)
 
 	if err := json.Unmarshal([]byte(request.Body), &authParams); err != nil {
-		return Response{
-			Body:       fmt.Sprintf("No valid username or password provided: %s", err.Error()),
-			StatusCode: http.StatusBadRequest,
-			Headers:    headers,
-		}, nil
+		return errorResponse(request, headers, echo.NewHTTPError(
+			http.StatusBadRequest,
+			fmt.Sprintf("No valid username or password provided: %s", err.Error()),
+		)), nil
 	}
 
-	if err := c.Authenticate(authParams.Username, authParams.Password); err != nil {
-		return Response{
-			Body:       fmt.Sprintf("Wrong username or password: %s", err.Error()),
-			StatusCode: http.StatusForbidden,
-			Headers:    headers,
-		}, nil
+	ip := request.RequestContext.Identity.SourceIP
+	wait, err := c.LoginAllowed(authParams.Username, ip)
+	if err != nil {
+		return errorResponse(request, headers, api.NewHTTPError(
+			err,
+			"Error checking failed login attempts",
+		)), nil
+	}
+	if wait > 0 {
+		headers["Retry-After"] = strconv.Itoa(int(math.Ceil(wait.Seconds())))
+		return errorResponse(request, headers, echo.NewHTTPError(
+			http.StatusTooManyRequests,
+			api.ErrTooManyLoginAttempts,
+		)), nil
 	}
 
-	token := jwt.New(jwt.SigningMethodHS256)
-	claims := token.Claims.(jwt.MapClaims)
-	claims["sub"] = authParams.Username
-	claims["exp"] = time.Now().Add(expiration).Unix()
+	var user *controller.User
+	err = c.Authenticate(authParams.Username, authParams.Password)
+	if err == nil {
+		user, err = c.GetUser(authParams.Username)
+	}
+	if err != nil || user == nil {
+		if recordErr := c.RecordLoginFailure(authParams.Username, ip); recordErr != nil {
+			sugar.Errorw("error recording failed login attempt", "error", recordErr.Error())
+		}
+		return errorResponse(request, headers, echo.NewHTTPError(
+			http.StatusForbidden,
+			api.ErrWrongCredentials,
+		)), nil
+	}
 
-	t, err := token.SignedString([]byte(viper.GetString(jwtSecretFlag)))
+	if user.MFAEnabled {
+		if authParams.OTP == "" {
+			return errorResponse(request, headers, echo.NewHTTPError(
+				http.StatusUnauthorized,
+				api.ErrMFARequired,
+			)), nil
+		}
+		if err := c.VerifyMFA(authParams.Username, authParams.OTP); err != nil {
+			if recordErr := c.RecordLoginFailure(authParams.Username, ip); recordErr != nil {
+				sugar.Errorw("error recording failed login attempt", "error", recordErr.Error())
+			}
+			return errorResponse(request, headers, echo.NewHTTPError(
+				http.StatusForbidden,
+				api.ErrWrongCredentials,
+			)), nil
+		}
+	}
+
+	if err := c.ResetLoginFailures(authParams.Username); err != nil {
+		sugar.Errorw("error resetting failed login attempts", "error", err.Error())
+	}
+
+	t, err := keys.Sign(jwt.MapClaims{
+		utils.TypeClaim: utils.AccessToken,
+		"sub":           user.Username,
+		"role":          user.Role,
+		"exp":           time.Now().Add(expiration).Unix(),
+	})
 	if err != nil {
-		return Response{
-			Body:       fmt.Sprintf("error signing token: %s", err.Error()),
-			StatusCode: http.StatusInternalServerError,
-			Headers:    headers,
-		}, fmt.Errorf("error signing token: %w", err)
+		return errorResponse(request, headers, api.NewHTTPError(err, "Error signing token")), nil
 	}
 
 	body, err := json.Marshal(map[string]string{
@@ -139,11 +198,7 @@ func Handler(request events.APIGatewayProxyRequest) (Response, error) {
 	})
 
 	if err != nil {
-		return Response{
-			Body:       fmt.Sprintf("Error marshalling token: %s", err.Error()),
-			StatusCode: http.StatusInternalServerError,
-			Headers:    headers,
-		}, fmt.Errorf("Error marshalling token: %w", err)
+		return errorResponse(request, headers, api.NewHTTPError(err, "Error marshalling token")), nil
 	}
 
 	return Response{
@@ -153,6 +208,15 @@ func Handler(request events.APIGatewayProxyRequest) (Response, error) {
 	}, nil
 }
 
+// errorResponse returns the response for an error, logging internal errors
+func errorResponse(request events.APIGatewayProxyRequest, headers map[string]string, err error) Response {
+	response := Response(api.LambdaErrorResponse(err, request.RequestContext.RequestID, headers))
+	if response.StatusCode == http.StatusInternalServerError {
+		sugar.Errorw("error handling request", "request_id", request.RequestContext.RequestID, "error", err.Error())
+	}
+	return response
+}
+
 func main() {
 	lambda.Start(Handler)
 }
@@ -11,31 +11,51 @@ import (
 	"github.com/igvaquero18/smarthome/api"
 	"github.com/igvaquero18/smarthome/controller"
 	"github.com/igvaquero18/smarthome/utils"
+	"github.com/labstack/echo/v4"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
 )
 
 const (
-	jwtSecretEnv            = "SMARTHOME_JWT_SECRET"
-	awsRegionEnv            = "SMARTHOME_AWS_REGION"
-	verboseEnv              = "SMARTHOME_VERBOSE"
-	corsOriginsEnv          = "SMARTHOME_CORS_ORIGINS"
-	dynamoDBEndpointEnv     = "SMARTHOME_DYNAMODB_ENDPOINT"
-	dynamoDBControlTableEnv = "SMARTHOME_DYNAMODB_CONTROL_PLANE_TABLE"
+	jwtSecretEnv             = "SMARTHOME_JWT_SECRET"
+	jwtPrivateKeyEnv         = "SMARTHOME_JWT_PRIVATE_KEY"
+	jwtVerificationKeysEnv   = "SMARTHOME_JWT_VERIFICATION_KEYS"
+	awsRegionEnv             = "SMARTHOME_AWS_REGION"
+	verboseEnv               = "SMARTHOME_VERBOSE"
+	corsOriginsEnv           = "SMARTHOME_CORS_ORIGINS"
+	dynamoDBEndpointEnv      = "SMARTHOME_DYNAMODB_ENDPOINT"
+	dynamoDBControlTableEnv  = "SMARTHOME_DYNAMODB_CONTROL_PLANE_TABLE"
+	dynamoDBAuthTableEnv     = "SMARTHOME_DYNAMODB_AUTH_TABLE"
+	safetyMinThresholdEnv    = "SMARTHOME_SAFETY_MIN_THRESHOLD"
+	safetyMaxThresholdEnv    = "SMARTHOME_SAFETY_MAX_THRESHOLD"
+	safetyMinHysteresisEnv   = "SMARTHOME_SAFETY_MIN_HYSTERESIS"
+	safetyFrostProtectionEnv = "SMARTHOME_SAFETY_FROST_PROTECTION"
+	safetyRoomsEnv           = "SMARTHOME_SAFETY_ROOMS"
+	thresholdPrecisionEnv    = "SMARTHOME_THRESHOLD_PRECISION"
 )
 
 const (
-	jwtSecretFlag            = "server.jwt.secret"
-	awsRegionFlag            = "aws.region"
-	verboseFlag              = "logging.verbose"
-	corsOriginsFlag          = "cors.origins"
-	dynamoDBEndpointFlag     = "aws.dynamodb.endpoint"
-	dynamoDBControlTableFlag = "aws.dynamodb.tables.control"
+	jwtSecretFlag             = "server.jwt.secret"
+	jwtPrivateKeyFlag         = "server.jwt.private_key"
+	jwtVerificationKeysFlag   = "server.jwt.verification_keys"
+	awsRegionFlag             = "aws.region"
+	verboseFlag               = "logging.verbose"
+	corsOriginsFlag           = "cors.origins"
+	dynamoDBEndpointFlag      = "aws.dynamodb.endpoint"
+	dynamoDBControlTableFlag  = "aws.dynamodb.tables.control"
+	dynamoDBAuthTableFlag     = "aws.dynamodb.tables.auth"
+	safetyMinThresholdFlag    = "safety.min_threshold"
+	safetyMaxThresholdFlag    = "safety.max_threshold"
+	safetyMinHysteresisFlag   = "safety.min_hysteresis"
+	safetyFrostProtectionFlag = "safety.frost_protection"
+	safetyRoomsFlag           = "safety.rooms"
+	thresholdPrecisionFlag    = "thresholds.precision"
 )
 
 var (
 	c     controller.SmartHomeInterface
 	sugar *zap.SugaredLogger
+	keys  *utils.KeySet
 )
 
 // Response is of type APIGatewayProxyResponse since we're leveraging the
@@ -46,19 +66,38 @@ type Response events.APIGatewayProxyResponse
 
 func init() {
 	viper.SetDefault(jwtSecretFlag, "")
+	viper.SetDefault(jwtPrivateKeyFlag, "")
+	viper.SetDefault(jwtVerificationKeysFlag, "")
 	viper.SetDefault(awsRegionFlag, "us-east-3")
 	viper.SetDefault(verboseFlag, false)
 	viper.SetDefault(corsOriginsFlag, "")
 	viper.SetDefault(dynamoDBEndpointFlag, "")
 	viper.SetDefault(dynamoDBControlTableFlag, controller.DefaultControlPlaneTable)
+	viper.SetDefault(dynamoDBAuthTableFlag, controller.DefaultAuthTable)
+	viper.SetDefault(safetyMinThresholdFlag, controller.DefaultMinThreshold)
+	viper.SetDefault(safetyMaxThresholdFlag, controller.DefaultMaxThreshold)
+	viper.SetDefault(safetyMinHysteresisFlag, 0)
+	viper.SetDefault(safetyFrostProtectionFlag, controller.DefaultFrostProtection)
+	viper.SetDefault(safetyRoomsFlag, "")
+	viper.SetDefault(thresholdPrecisionFlag, controller.DefaultThresholdPrecision)
 	viper.BindEnv(jwtSecretFlag, jwtSecretEnv)
+	viper.BindEnv(jwtPrivateKeyFlag, jwtPrivateKeyEnv)
+	viper.BindEnv(jwtVerificationKeysFlag, jwtVerificationKeysEnv)
 	viper.BindEnv(awsRegionFlag, awsRegionEnv)
 	viper.BindEnv(verboseFlag, verboseEnv)
 	viper.BindEnv(corsOriginsFlag, corsOriginsEnv)
 	viper.BindEnv(dynamoDBEndpointFlag, dynamoDBEndpointEnv)
 	viper.BindEnv(dynamoDBControlTableFlag, dynamoDBControlTableEnv)
+	viper.BindEnv(dynamoDBAuthTableFlag, dynamoDBAuthTableEnv)
+	viper.BindEnv(safetyMinThresholdFlag, safetyMinThresholdEnv)
+	viper.BindEnv(safetyMaxThresholdFlag, safetyMaxThresholdEnv)
+	viper.BindEnv(safetyMinHysteresisFlag, safetyMinHysteresisEnv)
+	viper.BindEnv(safetyFrostProtectionFlag, safetyFrostProtectionEnv)
+	viper.BindEnv(safetyRoomsFlag, safetyRoomsEnv)
+	viper.BindEnv(thresholdPrecisionFlag, thresholdPrecisionEnv)
 
-	sugar, err := utils.InitSugaredLogger(viper.GetBool(verboseFlag))
+	var err error
+	sugar, err = utils.InitSugaredLogger(viper.GetBool(verboseFlag))
 
 	if err != nil {
 		fmt.Printf("error when initializing logger: %s\n", err.Error())
@@ -74,11 +113,46 @@ func init() {
 		sugar.Fatalw("error creating DynamoDB client", "error", err.Error())
 	}
 
+	keys, err = utils.LoadKeySet(
+		viper.GetString(jwtSecretFlag),
+		viper.GetString(jwtPrivateKeyFlag),
+		utils.SplitList(viper.GetString(jwtVerificationKeysFlag)),
+	)
+	if err != nil {
+		sugar.Fatalw("error loading JWT keys", "error", err.Error())
+	}
+
+	// The overrides of the safety limits of the rooms are a JSON object such
+	// as {"kids": {"max_threshold": 24}}
+	safety := controller.SafetyPolicy{
+		SafetyLimits: controller.SafetyLimits{
+			MinThreshold:    float32(viper.GetFloat64(safetyMinThresholdFlag)),
+			MaxThreshold:    float32(viper.GetFloat64(safetyMaxThresholdFlag)),
+			MinHysteresis:   float32(viper.GetFloat64(safetyMinHysteresisFlag)),
+			FrostProtection: float32(viper.GetFloat64(safetyFrostProtectionFlag)),
+		},
+	}
+	if rooms := viper.GetString(safetyRoomsFlag); rooms != "" {
+		if err := json.Unmarshal([]byte(rooms), &safety.Rooms); err != nil {
+			sugar.Fatalw("invalid safety limits of the rooms", "error", err.Error())
+		}
+	}
+	if err := safety.Validate(); err != nil {
+		sugar.Fatalw("invalid safety limits", "error", err.Error())
+	}
+	thresholdPrecision := float32(viper.GetFloat64(thresholdPrecisionFlag))
+	if err := controller.ValidateThresholdPrecision(thresholdPrecision); err != nil {
+		sugar.Fatalw("invalid threshold precision", "error", err.Error())
+	}
+
 	c = controller.NewSmartHome(
 		controller.SetLogger(sugar),
 		controller.SetDynamoDBClient(dynamoClient),
 		controller.SetConfig(&controller.SmartHomeConfig{
-			ControlPlaneTable: viper.GetString(dynamoDBControlTableFlag),
+			ControlPlaneTable:  viper.GetString(dynamoDBControlTableFlag),
+			AuthTable:          viper.GetString(dynamoDBAuthTableFlag),
+			Safety:             safety,
+			ThresholdPrecision: thresholdPrecision,
 		}),
 	)
 }
@@ -88,58 +162,60 @@ func Handler(request events.APIGatewayProxyRequest) (Response, error) {
 	headers := map[string]string{}
 	if viper.GetString(corsOriginsFlag) != "" {
 		headers["Access-Control-Allow-Origin"] = viper.GetString(corsOriginsFlag)
+		headers["Access-Control-Expose-Headers"] = api.HeaderETag
 	}
 
-	if err := utils.ValidateTokenFromHeader(request.Headers["Authorization"], viper.GetString(jwtSecretFlag)); err != nil {
-		return Response{
-			Body:       fmt.Sprintf("Authentication failure: %s", err.Error()),
-			StatusCode: http.StatusForbidden,
-			Headers:    headers,
-		}, nil
+	if err := api.AuthorizeLambdaRequest(request.Headers, keys, c, controller.ScopeRoomsWrite); err != nil {
+		return errorResponse(request, headers, err), nil
 	}
 
 	room := request.PathParameters["room"]
 
 	if !api.ValidRoom(room).IsValid() {
-		return Response{
-			Body:       "Invalid room name",
-			StatusCode: http.StatusBadRequest,
-			Headers:    headers,
-		}, nil
+		return errorResponse(request, headers, api.NewInvalidRoomError(room)), nil
 	}
 
-	r := new(api.RoomOptions)
+	version, err := api.ParseIfMatch(room, api.HeaderValue(request.Headers, api.HeaderIfMatch))
+	if err != nil {
+		return errorResponse(request, headers, err), nil
+	}
 
-	if err := json.Unmarshal([]byte(request.Body), &r); err != nil {
-		return Response{
-			Body:       err.Error(),
-			StatusCode: http.StatusBadRequest,
-			Headers:    headers,
-		}, nil
+	unit, err := api.TemperatureUnit(c, request.QueryStringParameters["unit"], api.LambdaTokenSubject(request.Headers, keys))
+	if err != nil {
+		return errorResponse(request, headers, err), nil
 	}
 
-	if r.ThresholdOn > r.ThresholdOff {
-		return Response{
-			Body:       "threshold_on should be lower or equal to threshold_off",
-			StatusCode: http.StatusBadRequest,
-			Headers:    headers,
-		}, nil
+	if request.HTTPMethod == http.MethodPatch {
+		return patchRoomOptions(request, headers, room, version, unit), nil
 	}
 
-	rooms := []string{room}
+	r := new(api.RoomOptions)
 
-	if room == "all" {
-		rooms = utils.AllButOne(api.ValidRooms, "all")
+	if err := json.Unmarshal([]byte(request.Body), &r); err != nil {
+		return errorResponse(request, headers, echo.NewHTTPError(http.StatusBadRequest, err.Error())), nil
+	}
+
+	if r.ThresholdOn > r.ThresholdOff {
+		return errorResponse(request, headers, echo.NewHTTPError(
+			http.StatusBadRequest,
+			"threshold_on should be lower or equal to threshold_off",
+		)), nil
 	}
+	*r = r.ToCelsius(unit)
 
-	for _, roomName := range rooms {
-		if err := c.SetRoomOptions(roomName, r.Enabled, r.ThresholdOn, r.ThresholdOff); err != nil {
-			return Response{
-				Body:       fmt.Sprintf("Internal Server Error: %s", err.Error()),
-				StatusCode: http.StatusInternalServerError,
-				Headers:    headers,
-			}, fmt.Errorf("error setting room options for room %s: %w", roomName, err)
+	if room == api.AllRooms {
+		if err := api.SetRoomsOptions(c, api.EveryRoom(), *r); err != nil {
+			return errorResponse(request, headers, err), nil
 		}
+	} else {
+		newVersion, err := c.SetRoomOptions(room, r.Enabled, r.ThresholdOn, r.ThresholdOff, version)
+		if err != nil {
+			return errorResponse(request, headers, api.NewHTTPError(
+				err,
+				fmt.Sprintf("Error setting room options for room %s", room),
+			)), nil
+		}
+		headers[api.HeaderETag] = api.ETag(newVersion)
 	}
 
 	return Response{
@@ -149,6 +225,48 @@ func Handler(request events.APIGatewayProxyRequest) (Response, error) {
 	}, nil
 }
 
+// patchRoomOptions updates some of the options of a room with a JSON merge
+// patch in the unit, if they have the version required by the If-Match header
+func patchRoomOptions(request events.APIGatewayProxyRequest, headers map[string]string, room string, version int64, unit controller.TemperatureUnit) Response {
+	patch, err := api.ParseRoomOptionsPatch(
+		room,
+		api.HeaderValue(request.Headers, echo.HeaderContentType),
+		[]byte(request.Body),
+	)
+	if err != nil {
+		return errorResponse(request, headers, err)
+	}
+
+	options, err := api.UpdateRoomOptions(c, room, api.PatchToCelsius(patch, unit), version)
+	if err != nil {
+		return errorResponse(request, headers, err)
+	}
+	if room != api.AllRooms {
+		headers[api.HeaderETag] = api.ETag(options[0].Version)
+	}
+
+	body, err := json.Marshal(api.NewPatchRoomOptionsResponse(room, api.RoomOptionsInUnit(options, unit)))
+	if err != nil {
+		return errorResponse(request, headers, api.NewHTTPError(err, "Error marshalling response"))
+	}
+
+	headers[echo.HeaderContentType] = echo.MIMEApplicationJSON
+	return Response{
+		Body:       string(body),
+		StatusCode: http.StatusOK,
+		Headers:    headers,
+	}
+}
+
+// errorResponse returns the response for an error, logging internal errors
+func errorResponse(request events.APIGatewayProxyRequest, headers map[string]string, err error) Response {
+	response := Response(api.LambdaErrorResponse(err, request.RequestContext.RequestID, headers))
+	if response.StatusCode == http.StatusInternalServerError {
+		sugar.Errorw("error handling request", "request_id", request.RequestContext.RequestID, "error", err.Error())
+	}
+	return response
+}
+
 func main() {
 	lambda.Start(Handler)
 }
@@ -11,8 +11,10 @@ import (
 	"github.com/igvaquero18/smarthome/api"
 	"github.com/igvaquero18/smarthome/controller"
 	"github.com/igvaquero18/smarthome/utils"
+	"github.com/labstack/echo/v4"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
 )
 
 const (
@@ -21,6 +23,9 @@ const (
 	corsOriginsEnv       = "SMARTHOME_CORS_ORIGINS"
 	dynamoDBEndpointEnv  = "SMARTHOME_DYNAMODB_ENDPOINT"
 	dynamoDBAuthTableEnv = "SMARTHOME_DYNAMODB_AUTH_TABLE"
+	passwordMinLengthEnv = "SMARTHOME_PASSWORD_MIN_LENGTH"
+	passwordBreachedEnv  = "SMARTHOME_PASSWORD_BREACHED_LIST"
+	bcryptCostEnv        = "SMARTHOME_BCRYPT_COST"
 )
 
 const (
@@ -29,6 +34,9 @@ const (
 	corsOriginsFlag       = "cors.origins"
 	dynamoDBEndpointFlag  = "aws.dynamodb.endpoint"
 	dynamoDBAuthTableFlag = "aws.dynamodb.tables.auth"
+	passwordMinLengthFlag = "password.min_length"
+	passwordBreachedFlag  = "password.breached_list"
+	bcryptCostFlag        = "password.bcrypt_cost"
 )
 
 var (
@@ -48,13 +56,20 @@ func init() {
 	viper.SetDefault(corsOriginsFlag, "")
 	viper.SetDefault(dynamoDBEndpointFlag, "")
 	viper.SetDefault(dynamoDBAuthTableFlag, controller.DefaultAuthTable)
+	viper.SetDefault(passwordMinLengthFlag, controller.DefaultPasswordMinLength)
+	viper.SetDefault(passwordBreachedFlag, "")
+	viper.SetDefault(bcryptCostFlag, bcrypt.DefaultCost)
 	viper.BindEnv(awsRegionFlag, awsRegionEnv)
 	viper.BindEnv(verboseFlag, verboseEnv)
 	viper.BindEnv(corsOriginsFlag, corsOriginsEnv)
 	viper.BindEnv(dynamoDBEndpointFlag, dynamoDBEndpointEnv)
 	viper.BindEnv(dynamoDBAuthTableFlag, dynamoDBAuthTableEnv)
+	viper.BindEnv(passwordMinLengthFlag, passwordMinLengthEnv)
+	viper.BindEnv(passwordBreachedFlag, passwordBreachedEnv)
+	viper.BindEnv(bcryptCostFlag, bcryptCostEnv)
 
-	sugar, err := utils.InitSugaredLogger(viper.GetBool(verboseFlag))
+	var err error
+	sugar, err = utils.InitSugaredLogger(viper.GetBool(verboseFlag))
 
 	if err != nil {
 		fmt.Printf("error when initializing logger: %s\n", err.Error())
@@ -70,11 +85,21 @@ func init() {
 		sugar.Fatalw("error creating DynamoDB client", "error", err.Error())
 	}
 
+	policy := controller.PasswordPolicy{MinLength: viper.GetInt(passwordMinLengthFlag)}
+	if breached := viper.GetString(passwordBreachedFlag); breached != "" {
+		policy.Breached, err = utils.LoadPasswordList(breached)
+		if err != nil {
+			sugar.Fatalw("error loading breached passwords", "error", err.Error())
+		}
+	}
+
 	c = controller.NewSmartHome(
 		controller.SetLogger(sugar),
 		controller.SetDynamoDBClient(dynamoClient),
 		controller.SetConfig(&controller.SmartHomeConfig{
-			AuthTable: viper.GetString(dynamoDBAuthTableFlag),
+			AuthTable:      viper.GetString(dynamoDBAuthTableFlag),
+			PasswordPolicy: policy,
+			BcryptCost:     viper.GetInt(bcryptCostFlag),
 		}),
 	)
 }
@@ -89,19 +114,17 @@ func Handler(request events.APIGatewayProxyRequest) (Response, error) {
 	authParams := new(api.Auth)
 
 	if err := json.Unmarshal([]byte(request.Body), &authParams); err != nil {
-		return Response{
-			Body:       fmt.Sprintf("No valid username or password provided: %s", err.Error()),
-			StatusCode: http.StatusBadRequest,
-			Headers:    headers,
-		}, nil
+		return errorResponse(request, headers, echo.NewHTTPError(
+			http.StatusBadRequest,
+			fmt.Sprintf("No valid username or password provided: %s", err.Error()),
+		)), nil
 	}
 
 	if err := c.SetCredentials(authParams.Username, authParams.Password); err != nil {
-		return Response{
-			Body:       fmt.Sprintf("Error saving the credentials in the database: %s", err.Error()),
-			StatusCode: http.StatusInternalServerError,
-			Headers:    headers,
-		}, fmt.Errorf("Error saving the credentials in the database: %w", err)
+		return errorResponse(request, headers, api.NewHTTPError(
+			err,
+			"Error saving the credentials in the database",
+		)), nil
 	}
 
 	return Response{
@@ -111,6 +134,15 @@ func Handler(request events.APIGatewayProxyRequest) (Response, error) {
 	}, nil
 }
 
+// errorResponse returns the response for an error, logging internal errors
+func errorResponse(request events.APIGatewayProxyRequest, headers map[string]string, err error) Response {
+	response := Response(api.LambdaErrorResponse(err, request.RequestContext.RequestID, headers))
+	if response.StatusCode == http.StatusInternalServerError {
+		sugar.Errorw("error handling request", "request_id", request.RequestContext.RequestID, "error", err.Error())
+	}
+	return response
+}
+
 func main() {
 	lambda.Start(Handler)
 }
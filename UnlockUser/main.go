@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/igvaquero18/smarthome/api"
+	"github.com/igvaquero18/smarthome/controller"
+	"github.com/igvaquero18/smarthome/utils"
+	"github.com/labstack/echo/v4"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+const (
+	awsRegionEnv        = "SMARTHOME_AWS_REGION"
+	verboseEnv          = "SMARTHOME_VERBOSE"
+	corsOriginsEnv      = "SMARTHOME_CORS_ORIGINS"
+	dynamoDBEndpointEnv = "SMARTHOME_DYNAMODB_ENDPOINT"
+	dynamoDBAttemptsEnv = "SMARTHOME_DYNAMODB_LOGIN_ATTEMPTS_TABLE"
+)
+
+const (
+	awsRegionFlag        = "aws.region"
+	verboseFlag          = "logging.verbose"
+	corsOriginsFlag      = "cors.origins"
+	dynamoDBEndpointFlag = "aws.dynamodb.endpoint"
+	dynamoDBAttemptsFlag = "aws.dynamodb.tables.login_attempts"
+)
+
+var (
+	c     controller.SmartHomeInterface
+	sugar *zap.SugaredLogger
+)
+
+// Response is of type APIGatewayProxyResponse since we're leveraging the
+// AWS Lambda Proxy Request functionality (default behavior)
+//
+// https://serverless.com/framework/docs/providers/aws/events/apigateway/#lambda-proxy-integration
+type Response events.APIGatewayProxyResponse
+
+func init() {
+	viper.SetDefault(awsRegionFlag, "us-east-3")
+	viper.SetDefault(verboseFlag, false)
+	viper.SetDefault(corsOriginsFlag, "")
+	viper.SetDefault(dynamoDBEndpointFlag, "")
+	viper.SetDefault(dynamoDBAttemptsFlag, controller.DefaultLoginAttemptsTable)
+	viper.BindEnv(awsRegionFlag, awsRegionEnv)
+	viper.BindEnv(verboseFlag, verboseEnv)
+	viper.BindEnv(corsOriginsFlag, corsOriginsEnv)
+	viper.BindEnv(dynamoDBEndpointFlag, dynamoDBEndpointEnv)
+	viper.BindEnv(dynamoDBAttemptsFlag, dynamoDBAttemptsEnv)
+
+	var err error
+	sugar, err = utils.InitSugaredLogger(viper.GetBool(verboseFlag))
+
+	if err != nil {
+		fmt.Printf("error when initializing logger: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	region := viper.GetString(awsRegionFlag)
+	dynamoDBEndpoint := viper.GetString(dynamoDBEndpointFlag)
+
+	sugar.Infow("creating DynamoDB client", "region", region, "url", dynamoDBEndpoint)
+	dynamoClient, err := utils.InitDynamoClient(region, dynamoDBEndpoint)
+	if err != nil {
+		sugar.Fatalw("error creating DynamoDB client", "error", err.Error())
+	}
+
+	c = controller.NewSmartHome(
+		controller.SetLogger(sugar),
+		controller.SetDynamoDBClient(dynamoClient),
+		controller.SetConfig(&controller.SmartHomeConfig{
+			LoginAttemptsTable: viper.GetString(dynamoDBAttemptsFlag),
+		}),
+	)
+}
+
+// Handler is our lambda handler invoked by the `lambda.Start` function call
+func Handler(request events.APIGatewayProxyRequest) (Response, error) {
+	headers := map[string]string{}
+	if viper.GetString(corsOriginsFlag) != "" {
+		headers["Access-Control-Allow-Origin"] = viper.GetString(corsOriginsFlag)
+	}
+
+	authParams := new(api.Auth)
+
+	if err := json.Unmarshal([]byte(request.Body), &authParams); err != nil {
+		return errorResponse(request, headers, echo.NewHTTPError(
+			http.StatusBadRequest,
+			fmt.Sprintf("Invalid payload: %s", err.Error()),
+		)), nil
+	}
+
+	if err := c.ResetLoginFailures(authParams.Username); err != nil {
+		return errorResponse(request, headers, api.NewHTTPError(err, "Error when unlocking user")), nil
+	}
+
+	return Response{
+		Body:       "Successfully unlocked user",
+		StatusCode: http.StatusOK,
+		Headers:    headers,
+	}, nil
+}
+
+// errorResponse returns the response for an error, logging internal errors
+func errorResponse(request events.APIGatewayProxyRequest, headers map[string]string, err error) Response {
+	response := Response(api.LambdaErrorResponse(err, request.RequestContext.RequestID, headers))
+	if response.StatusCode == http.StatusInternalServerError {
+		sugar.Errorw("error handling request", "request_id", request.RequestContext.RequestID, "error", err.Error())
+	}
+	return response
+}
+
+func main() {
+	lambda.Start(Handler)
+}
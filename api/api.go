@@ -1,23 +1,29 @@
 package api
 
 import (
+	"sync"
 	"time"
 
 	"github.com/igvaquero18/smarthome/controller"
+	"github.com/igvaquero18/smarthome/utils"
 )
 
 // Client is the API client for SmartHome
 type Client struct {
 	Config JWTConfig
+	OIDC   *OIDCConfig
 	controller.SmartHomeInterface
+	mu sync.RWMutex
 }
 
 // JWTConfig is the configuration of the JWT parameters.
 // This includes the JWT secret and the duration for the JWT
-// before it expires.
+// before it expires. If Keys is set, it takes precedence over
+// the JWT secret for signing and verifying tokens.
 type JWTConfig struct {
 	JWTSecret     string
 	JWTExpiration time.Duration
+	Keys          *utils.KeySet
 }
 
 // NewClient returns a new SmartHome API Client
@@ -27,3 +33,24 @@ func NewClient(config JWTConfig, smartHome controller.SmartHomeInterface) *Clien
 		SmartHomeInterface: smartHome,
 	}
 }
+
+// SetJWTExpiration changes the expiration of the tokens issued from now on.
+// It is safe to call it while the client is serving requests.
+func (cl *Client) SetJWTExpiration(expiration time.Duration) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	cl.Config.JWTExpiration = expiration
+}
+
+func (cl *Client) jwtExpiration() time.Duration {
+	cl.mu.RLock()
+	defer cl.mu.RUnlock()
+	return cl.Config.JWTExpiration
+}
+
+func (cl *Client) keys() *utils.KeySet {
+	if cl.Config.Keys != nil {
+		return cl.Config.Keys
+	}
+	return utils.NewHMACKeySet(cl.Config.JWTSecret)
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// APIKeyRequest is the payload for creating an API key. ExpiresIn is a
+// duration such as "720h"; if empty, the key never expires.
+type APIKeyRequest struct {
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	ExpiresIn string   `json:"expires_in,omitempty"`
+}
+
+// CreateAPIKey creates an API key for the logged in user. The key is only
+// returned in this response.
+func (cl *Client) CreateAPIKey(c echo.Context) error {
+	owner, err := tokenSubject(c)
+	if err != nil {
+		return err
+	}
+	params := new(APIKeyRequest)
+	if err := json.NewDecoder(c.Request().Body).Decode(&params); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid payload: %s", err.Error()))
+	}
+	if params.Name == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid payload: a name is required")
+	}
+
+	var expiresAt time.Time
+	if params.ExpiresIn != "" {
+		expiresIn, err := time.ParseDuration(params.ExpiresIn)
+		if err != nil || expiresIn <= 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid expiration %s", params.ExpiresIn))
+		}
+		expiresAt = time.Now().Add(expiresIn)
+	}
+
+	key, token, err := cl.SmartHomeInterface.CreateAPIKey(owner, params.Name, params.Scopes, expiresAt)
+	if err != nil {
+		return NewHTTPError(err, "Error creating API key")
+	}
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"key":     token,
+		"api_key": key,
+	})
+}
+
+// ListAPIKeys returns the API keys of the logged in user, without the keys themselves
+func (cl *Client) ListAPIKeys(c echo.Context) error {
+	owner, err := tokenSubject(c)
+	if err != nil {
+		return err
+	}
+	keys, err := cl.SmartHomeInterface.ListAPIKeys(owner)
+	if err != nil {
+		return NewHTTPError(err, "Error listing API keys")
+	}
+	return c.JSON(http.StatusOK, keys)
+}
+
+// DeleteAPIKey revokes an API key of the logged in user
+func (cl *Client) DeleteAPIKey(c echo.Context) error {
+	owner, err := tokenSubject(c)
+	if err != nil {
+		return err
+	}
+	id := c.Param("id")
+	if err := cl.SmartHomeInterface.DeleteAPIKey(owner, id); err != nil {
+		return NewHTTPError(err, "Error deleting API key")
+	}
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "Successfully deleted API key",
+		"id":      id,
+	})
+}
@@ -0,0 +1,210 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/igvaquero18/smarthome/controller"
+	"github.com/igvaquero18/smarthome/utils"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateAPIKey(t *testing.T) {
+	user := &jwt.Token{Claims: jwt.MapClaims{"sub": "admin"}, Valid: true}
+	testCases := []struct {
+		name         string
+		body         string
+		expectedCode int
+	}{
+		{
+			name:         "Valid key",
+			body:         `{"name": "thermometer", "scopes": ["readings:write"], "expires_in": "720h"}`,
+			expectedCode: http.StatusCreated,
+		},
+		{
+			name:         "Missing name",
+			body:         `{"scopes": ["readings:write"]}`,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Invalid expiration",
+			body:         `{"name": "thermometer", "scopes": ["readings:write"], "expires_in": "forever"}`,
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			ctx := &baseMockContext{User: user, Body: tc.body}
+			err := NewClient(JWTConfig{}, &mockSmartHome{}).CreateAPIKey(ctx)
+			if tc.expectedCode != http.StatusCreated {
+				httpErr, ok := err.(*echo.HTTPError)
+				if assert.True(tt, ok) {
+					assert.Equal(tt, tc.expectedCode, httpErr.Code)
+				}
+				return
+			}
+			assert.NoError(tt, err)
+			payload := ctx.GetJSONPayload().(map[string]interface{})
+			assert.Equal(tt, "shk_1_secret", payload["key"])
+		})
+	}
+}
+
+func TestAPIKeyMiddleware(t *testing.T) {
+	keys := utils.NewHMACKeySet("secret")
+	jwtToken, _ := keys.Sign(jwt.MapClaims{utils.TypeClaim: utils.AccessToken, "sub": "admin"})
+	sh := &mockSmartHome{APIKeys: map[string]*controller.APIKey{
+		"thermometer": {ID: "1", Scopes: []string{controller.ScopeReadingsWrite}},
+		"dashboard":   {ID: "2", Scopes: []string{controller.ScopeRoomsRead}},
+	}}
+
+	testCases := []struct {
+		name         string
+		apiKey       string
+		jwt          string
+		expectedCode int
+	}{
+		{
+			name:         "API key with the scope",
+			apiKey:       "dashboard",
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "API key without the scope",
+			apiKey:       "thermometer",
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "Unknown API key",
+			apiKey:       "unknown",
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "JWT token",
+			jwt:          jwtToken,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "No credentials",
+			expectedCode: http.StatusUnauthorized,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			e := echo.New()
+			g := e.Group("", APIKey(sh.AuthenticateAPIKey), JWT(keys, APIKeyAuthenticated))
+			g.GET("/room", func(c echo.Context) error {
+				return c.NoContent(http.StatusOK)
+			}, RequireScope(controller.ScopeRoomsRead))
+
+			req := httptest.NewRequest(http.MethodGet, "/room", nil)
+			if tc.apiKey != "" {
+				req.Header.Set(APIKeyHeader, tc.apiKey)
+			}
+			if tc.jwt != "" {
+				req.Header.Set(echo.HeaderAuthorization, "Bearer "+tc.jwt)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			assert.Equal(tt, tc.expectedCode, rec.Code)
+		})
+	}
+}
+
+func TestAuthorizeLambdaRequest(t *testing.T) {
+	keys := utils.NewHMACKeySet("secret")
+	jwtToken, _ := keys.Sign(jwt.MapClaims{utils.TypeClaim: utils.AccessToken, "sub": "admin"})
+	sh := &mockSmartHome{APIKeys: map[string]*controller.APIKey{
+		"thermometer": {ID: "1", Scopes: []string{controller.ScopeReadingsWrite}},
+	}}
+
+	testCases := []struct {
+		name         string
+		headers      map[string]string
+		scope        string
+		expectedCode int
+	}{
+		{
+			name:         "API key with the scope",
+			headers:      map[string]string{"x-api-key": "thermometer"},
+			scope:        controller.ScopeReadingsWrite,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "API key without the scope",
+			headers:      map[string]string{"X-API-Key": "thermometer"},
+			scope:        controller.ScopeRoomsWrite,
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "Invalid API key",
+			headers:      map[string]string{"X-API-Key": "unknown"},
+			scope:        controller.ScopeRoomsWrite,
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "JWT token",
+			headers:      map[string]string{"Authorization": "Bearer " + jwtToken},
+			scope:        controller.ScopeRoomsWrite,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "No credentials",
+			headers:      map[string]string{},
+			scope:        controller.ScopeRoomsWrite,
+			expectedCode: http.StatusForbidden,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			err := AuthorizeLambdaRequest(tc.headers, keys, sh, tc.scope)
+			if tc.expectedCode == http.StatusOK {
+				assert.NoError(tt, err)
+			} else {
+				assert.Equal(tt, tc.expectedCode, StatusCode(err))
+			}
+		})
+	}
+}
+
+func TestLambdaTokenSubject(t *testing.T) {
+	keys := utils.NewHMACKeySet("secret")
+	jwtToken, _ := keys.Sign(jwt.MapClaims{utils.TypeClaim: utils.AccessToken, "sub": "admin"})
+
+	testCases := []struct {
+		name     string
+		headers  map[string]string
+		keys     *utils.KeySet
+		expected string
+	}{
+		{
+			name:     "JWT token",
+			headers:  map[string]string{"authorization": "Bearer " + jwtToken},
+			keys:     keys,
+			expected: "admin",
+		},
+		{
+			name:    "API key",
+			headers: map[string]string{"X-API-Key": "thermometer", "Authorization": "Bearer " + jwtToken},
+			keys:    keys,
+		},
+		{
+			name:    "Invalid token",
+			headers: map[string]string{"Authorization": "Bearer token"},
+			keys:    keys,
+		},
+		{
+			name:    "Authentication disabled",
+			headers: map[string]string{"Authorization": "Bearer " + jwtToken},
+			keys:    utils.NewHMACKeySet(""),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			assert.Equal(tt, tc.expected, LambdaTokenSubject(tc.headers, tc.keys))
+		})
+	}
+}
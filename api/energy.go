@@ -0,0 +1,81 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/igvaquero18/smarthome/controller"
+	"github.com/labstack/echo/v4"
+)
+
+// DefaultEnergyRange is the range energy usage is estimated for when the from
+// query parameter isn't set
+const DefaultEnergyRange = 7 * 24 * time.Hour
+
+// HeatingRequest is the payload reporting that the heater of a room has been
+// switched on or off. The time defaults to that of the request.
+type HeatingRequest struct {
+	Room    string     `json:"room"`
+	Heating *bool      `json:"heating"`
+	Time    *time.Time `json:"time,omitempty"`
+}
+
+// RecordHeating records a heating transition reported by the controller of
+// the heater of a room
+func (cl *Client) RecordHeating(c echo.Context) error {
+	params := new(HeatingRequest)
+	if err := json.NewDecoder(c.Request().Body).Decode(&params); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid payload: %s", err.Error()))
+	}
+	if params.Room == AllRooms || !ValidRoom(params.Room).IsValid() {
+		return NewInvalidRoomError(params.Room)
+	}
+	if params.Heating == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid payload: heating is required")
+	}
+
+	transition := controller.HeatingTransition{Room: params.Room, Time: time.Now().UTC(), Heating: *params.Heating}
+	if params.Time != nil {
+		transition.Time = params.Time.UTC()
+	}
+	if err := cl.SmartHomeInterface.RecordHeating(transition); err != nil {
+		return NewHTTPError(err, "Error recording heating transition")
+	}
+	return c.JSON(http.StatusCreated, transition)
+}
+
+// GetEnergy estimates the energy used by the heaters of the rooms between the
+// from and to query parameters, which default to the last 7 days, by day or
+// by week. The rooms are those of the room query parameters, or every room if
+// none is set.
+func (cl *Client) GetEnergy(c echo.Context) error {
+	to, err := parseTimeParam(c, "to", time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	from, err := parseTimeParam(c, "from", to.Add(-DefaultEnergyRange))
+	if err != nil {
+		return err
+	}
+	period := controller.EnergyPeriod(c.QueryParam("period"))
+	if period == "" {
+		period = controller.EnergyDaily
+	}
+	rooms := c.QueryParams()["room"]
+	if len(rooms) == 0 {
+		rooms = EveryRoom()
+	}
+	for _, room := range rooms {
+		if room == AllRooms || !ValidRoom(room).IsValid() {
+			return NewInvalidRoomError(room)
+		}
+	}
+
+	report, err := cl.SmartHomeInterface.EnergyUsage(rooms, period, from, to)
+	if err != nil {
+		return NewHTTPError(err, "Error estimating energy usage")
+	}
+	return c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,166 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/igvaquero18/smarthome/controller"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordHeating(t *testing.T) {
+	testCases := []struct {
+		name         string
+		body         string
+		smartHome    *mockSmartHome
+		expectedCode int
+		expected     *controller.HeatingTransition
+	}{
+		{
+			name:         "Heater switched on",
+			body:         `{"room": "bedroom", "heating": true, "time": "2021-01-01T07:00:00+01:00"}`,
+			smartHome:    &mockSmartHome{},
+			expectedCode: http.StatusCreated,
+			expected:     &controller.HeatingTransition{Room: "bedroom", Time: time.Date(2021, 1, 1, 6, 0, 0, 0, time.UTC), Heating: true},
+		},
+		{
+			name:         "Heater switched off now",
+			body:         `{"room": "livingroom", "heating": false}`,
+			smartHome:    &mockSmartHome{},
+			expectedCode: http.StatusCreated,
+			expected:     &controller.HeatingTransition{Room: "livingroom", Heating: false},
+		},
+		{
+			name:         "Missing state",
+			body:         `{"room": "bedroom"}`,
+			smartHome:    &mockSmartHome{},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Invalid room",
+			body:         `{"room": "attic", "heating": true}`,
+			smartHome:    &mockSmartHome{},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Every room",
+			body:         `{"room": "all", "heating": true}`,
+			smartHome:    &mockSmartHome{},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Invalid payload",
+			body:         `{"room": "bedroom", "heating": "on"}`,
+			smartHome:    &mockSmartHome{},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Error recording",
+			body:         `{"room": "bedroom", "heating": true}`,
+			smartHome:    &mockSmartHome{Err: errors.New("unexpected error")},
+			expectedCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			ctx := &baseMockContext{Body: tc.body}
+			err := NewClient(JWTConfig{}, tc.smartHome).RecordHeating(ctx)
+			if tc.expectedCode != http.StatusCreated {
+				assert.Error(tt, err)
+				assert.Equal(tt, tc.expectedCode, StatusCode(err))
+				return
+			}
+			assert.NoError(tt, err)
+			transition, ok := ctx.GetJSONPayload().(controller.HeatingTransition)
+			if assert.True(tt, ok) && assert.Len(tt, tc.smartHome.Heating, 1) {
+				assert.Equal(tt, transition, tc.smartHome.Heating[0])
+				if tc.expected.Time.IsZero() {
+					assert.WithinDuration(tt, time.Now(), transition.Time, time.Minute)
+					transition.Time = time.Time{}
+				}
+				assert.Equal(tt, *tc.expected, transition)
+			}
+		})
+	}
+}
+
+func TestGetEnergy(t *testing.T) {
+	from := time.Date(2021, 1, 4, 0, 0, 0, 0, time.UTC)
+	sh := &mockSmartHome{Heating: []controller.HeatingTransition{
+		{Room: "bedroom", Time: from.Add(6 * time.Hour), Heating: true},
+		{Room: "bedroom", Time: from.Add(8 * time.Hour), Heating: false},
+	}}
+	testCases := []struct {
+		name            string
+		query           url.Values
+		smartHome       *mockSmartHome
+		expectedCode    int
+		expectedRooms   []string
+		expectedRuntime int64
+	}{
+		{
+			name:            "Every room by day",
+			query:           url.Values{"from": {from.Format(time.RFC3339)}, "to": {from.AddDate(0, 0, 2).Format(time.RFC3339)}},
+			smartHome:       sh,
+			expectedCode:    http.StatusOK,
+			expectedRooms:   []string{"bedroom", "livingroom"},
+			expectedRuntime: 7200,
+		},
+		{
+			name:            "A room by week",
+			query:           url.Values{"from": {from.Format(time.RFC3339)}, "to": {from.AddDate(0, 0, 14).Format(time.RFC3339)}, "period": {"week"}, "room": {"livingroom"}},
+			smartHome:       sh,
+			expectedCode:    http.StatusOK,
+			expectedRooms:   []string{"livingroom"},
+			expectedRuntime: 0,
+		},
+		{
+			name:         "Invalid period",
+			query:        url.Values{"period": {"month"}},
+			smartHome:    sh,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Invalid room",
+			query:        url.Values{"room": {"attic"}},
+			smartHome:    sh,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Invalid time",
+			query:        url.Values{"from": {"yesterday"}},
+			smartHome:    sh,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Error estimating",
+			query:        url.Values{},
+			smartHome:    &mockSmartHome{Err: errors.New("unexpected error")},
+			expectedCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			ctx := &baseMockContext{Query: tc.query}
+			err := NewClient(JWTConfig{}, tc.smartHome).GetEnergy(ctx)
+			if tc.expectedCode != http.StatusOK {
+				assert.Error(tt, err)
+				assert.Equal(tt, tc.expectedCode, StatusCode(err))
+				return
+			}
+			assert.NoError(tt, err)
+			report, ok := ctx.GetJSONPayload().(*controller.EnergyReport)
+			if assert.True(tt, ok) {
+				rooms := []string{}
+				for _, room := range report.Rooms {
+					rooms = append(rooms, room.Room)
+				}
+				assert.Equal(tt, tc.expectedRooms, rooms)
+				assert.Equal(tt, tc.expectedRuntime, report.RuntimeSeconds)
+			}
+		})
+	}
+}
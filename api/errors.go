@@ -0,0 +1,145 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/igvaquero18/smarthome/controller"
+	"github.com/labstack/echo/v4"
+)
+
+// ErrorResponse is the body of every error response of the API, whether it's
+// served by the serve command or by the Lambda functions
+type ErrorResponse struct {
+	// Code is a machine-readable code of the error, such as not_found
+	Code string `json:"code"`
+
+	// Message describes the error for humans
+	Message string `json:"message"`
+
+	// Details are additional information about the error, if any
+	Details map[string]interface{} `json:"details,omitempty"`
+
+	// RequestID identifies the request in the logs
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// statusCodes maps the kinds of the controller errors to HTTP statuses
+var statusCodes = []struct {
+	kind   error
+	status int
+}{
+	{kind: controller.ErrNotFound, status: http.StatusNotFound},
+	{kind: controller.ErrConflict, status: http.StatusConflict},
+	{kind: controller.ErrValidation, status: http.StatusBadRequest},
+	{kind: controller.ErrUnauthorized, status: http.StatusUnauthorized},
+	{kind: controller.ErrForbidden, status: http.StatusForbidden},
+	{kind: controller.ErrPreconditionFailed, status: http.StatusPreconditionFailed},
+}
+
+// StatusCode returns the HTTP status of an error: the status of echo errors,
+// the status of the kind of controller errors, or 500 for any other error.
+func StatusCode(err error) int {
+	if he, ok := err.(*echo.HTTPError); ok {
+		return he.Code
+	}
+	for _, s := range statusCodes {
+		if errors.Is(err, s.kind) {
+			return s.status
+		}
+	}
+	return http.StatusInternalServerError
+}
+
+// ErrorCode returns the machine-readable code of an HTTP status, which is
+// its status text in snake case, e.g. too_many_requests
+func ErrorCode(status int) string {
+	text := http.StatusText(status)
+	if text == "" {
+		return "unknown_error"
+	}
+	if status == http.StatusInternalServerError {
+		return "internal_error"
+	}
+	return strings.ReplaceAll(strings.ToLower(strings.NewReplacer("'", "", "-", " ").Replace(text)), " ", "_")
+}
+
+// NewHTTPError returns the echo error of an error returned by the controller.
+// Errors of the controller kinds get the status of their kind, while any
+// other error is an internal error, described by message.
+func NewHTTPError(err error, message string) *echo.HTTPError {
+	status := StatusCode(err)
+	if status == http.StatusInternalServerError {
+		return &echo.HTTPError{Code: status, Message: fmt.Sprintf("%s: %s", message, err.Error()), Internal: err}
+	}
+	return &echo.HTTPError{Code: status, Message: err.Error(), Internal: err}
+}
+
+// NewErrorResponse returns the status and body of the response for an error
+func NewErrorResponse(err error, requestID string) (int, ErrorResponse) {
+	status := StatusCode(err)
+	response := ErrorResponse{Code: ErrorCode(status), Message: http.StatusText(status), RequestID: requestID}
+
+	cause := err
+	if he, ok := err.(*echo.HTTPError); ok {
+		switch m := he.Message.(type) {
+		case string:
+			response.Message = m
+		case error:
+			response.Message = m.Error()
+		case nil:
+		default:
+			response.Message = fmt.Sprint(m)
+		}
+		cause = he.Internal
+	} else if status != http.StatusInternalServerError {
+		// The message of internal errors is not meant for the users of the API
+		response.Message = err.Error()
+	}
+
+	var controllerErr *controller.Error
+	if cause != nil && errors.As(cause, &controllerErr) {
+		response.Details = controllerErr.Details
+	}
+	return status, response
+}
+
+// HTTPErrorHandler is an echo HTTP error handler that writes errors as an ErrorResponse
+func HTTPErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+	status, response := NewErrorResponse(err, requestID(c))
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(status)
+	} else {
+		err = c.JSON(status, response)
+	}
+	if err != nil {
+		c.Logger().Error(err)
+	}
+}
+
+// LambdaErrorResponse returns the response of a Lambda function for an
+// error, including the headers passed as a parameter
+func LambdaErrorResponse(err error, requestID string, headers map[string]string) events.APIGatewayProxyResponse {
+	status, response := NewErrorResponse(err, requestID)
+	body, _ := json.Marshal(response)
+	h := map[string]string{echo.HeaderContentType: echo.MIMEApplicationJSON}
+	for k, v := range headers {
+		h[k] = v
+	}
+	return events.APIGatewayProxyResponse{StatusCode: status, Body: string(body), Headers: h}
+}
+
+// requestID returns the ID of the request, set by the echo RequestID middleware
+func requestID(c echo.Context) string {
+	if id := c.Response().Header().Get(echo.HeaderXRequestID); id != "" {
+		return id
+	}
+	return c.Request().Header.Get(echo.HeaderXRequestID)
+}
@@ -0,0 +1,137 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/igvaquero18/smarthome/controller"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewErrorResponse(t *testing.T) {
+	testCases := []struct {
+		name             string
+		err              error
+		expectedStatus   int
+		expectedResponse ErrorResponse
+	}{
+		{
+			name:           "Echo error",
+			err:            echo.NewHTTPError(http.StatusTooManyRequests, ErrTooManyLoginAttempts),
+			expectedStatus: http.StatusTooManyRequests,
+			expectedResponse: ErrorResponse{
+				Code:    "too_many_requests",
+				Message: ErrTooManyLoginAttempts,
+			},
+		},
+		{
+			name:           "Controller error",
+			err:            controller.ErrUserExists,
+			expectedStatus: http.StatusConflict,
+			expectedResponse: ErrorResponse{
+				Code:    "conflict",
+				Message: "the user already exists",
+			},
+		},
+		{
+			name:           "Wrapped controller error",
+			err:            fmt.Errorf("error deleting API key: %w", controller.NewNotFoundError("API key 1 not found")),
+			expectedStatus: http.StatusNotFound,
+			expectedResponse: ErrorResponse{
+				Code:    "not_found",
+				Message: "error deleting API key: API key 1 not found",
+			},
+		},
+		{
+			name:           "Controller error with details",
+			err:            NewInvalidRoomError("kitchen"),
+			expectedStatus: http.StatusBadRequest,
+			expectedResponse: ErrorResponse{
+				Code:    "bad_request",
+				Message: "Invalid room name kitchen. Valid rooms: [all bedroom livingroom]",
+				Details: map[string]interface{}{"valid_rooms": []string{"all", "bedroom", "livingroom"}},
+			},
+		},
+		{
+			name:           "Controller error converted by the handler",
+			err:            NewHTTPError(controller.ErrWeakPassword, "Error changing the password"),
+			expectedStatus: http.StatusBadRequest,
+			expectedResponse: ErrorResponse{
+				Code:    "bad_request",
+				Message: "the password doesn't comply with the password policy",
+			},
+		},
+		{
+			name:           "Internal error converted by the handler",
+			err:            NewHTTPError(errors.New("connection refused"), "Error changing the password"),
+			expectedStatus: http.StatusInternalServerError,
+			expectedResponse: ErrorResponse{
+				Code:    "internal_error",
+				Message: "Error changing the password: connection refused",
+			},
+		},
+		{
+			name:           "Internal error",
+			err:            errors.New("connection refused"),
+			expectedStatus: http.StatusInternalServerError,
+			expectedResponse: ErrorResponse{
+				Code:    "internal_error",
+				Message: "Internal Server Error",
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			status, response := NewErrorResponse(tc.err, "")
+			assert.Equal(tt, tc.expectedStatus, status)
+			assert.Equal(tt, tc.expectedResponse, response)
+		})
+	}
+}
+
+func TestHTTPErrorHandler(t *testing.T) {
+	e := echo.New()
+	e.HTTPErrorHandler = HTTPErrorHandler
+	e.GET("/v1/user", func(c echo.Context) error {
+		return NewHTTPError(controller.NewNotFoundError("user %s not found", "bob"), "Error getting the user")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/user", nil)
+	req.Header.Set(echo.HeaderXRequestID, "1234")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	response := ErrorResponse{}
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Equal(t, echo.MIMEApplicationJSONCharsetUTF8, rec.Header().Get(echo.HeaderContentType))
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	assert.Equal(t, ErrorResponse{Code: "not_found", Message: "user bob not found", RequestID: "1234"}, response)
+
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/unknown", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.JSONEq(t, `{"code": "not_found", "message": "Not Found"}`, rec.Body.String())
+}
+
+func TestLambdaErrorResponse(t *testing.T) {
+	response := LambdaErrorResponse(
+		controller.ErrInvalidAPIKey,
+		"c6af9ac6-7b61-11e6-9a41-93e8deadbeef",
+		map[string]string{"Access-Control-Allow-Origin": "*"},
+	)
+	assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+	assert.Equal(t, map[string]string{
+		echo.HeaderContentType:        echo.MIMEApplicationJSON,
+		"Access-Control-Allow-Origin": "*",
+	}, response.Headers)
+	assert.JSONEq(t, `{
+		"code": "unauthorized",
+		"message": "invalid API key",
+		"request_id": "c6af9ac6-7b61-11e6-9a41-93e8deadbeef"
+	}`, response.Body)
+}
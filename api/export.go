@@ -0,0 +1,378 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/igvaquero18/smarthome/controller"
+	"github.com/igvaquero18/smarthome/utils"
+	"github.com/labstack/echo/v4"
+)
+
+// ExportFormat is the format of the files data is exported to
+type ExportFormat string
+
+const (
+	// ExportCSV exports comma-separated values, with a header row
+	ExportCSV ExportFormat = "csv"
+
+	// ExportNDJSON exports one JSON object per line
+	ExportNDJSON ExportFormat = "ndjson"
+
+	// ExportParquet exports an Apache Parquet file
+	ExportParquet ExportFormat = "parquet"
+)
+
+// Kinds of the records of an export
+const (
+	// ExportKindReading is a bucket of temperature readings
+	ExportKindReading = "reading"
+
+	// ExportKindSetting is a change of the options of a room
+	ExportKindSetting = "setting"
+)
+
+// DefaultExportRange is the range of the data exported when the from query
+// parameter isn't set
+const DefaultExportRange = 30 * 24 * time.Hour
+
+// DefaultExportResolution is the resolution of the readings exported when the
+// resolution query parameter isn't set
+const DefaultExportResolution = controller.ResolutionHourly
+
+// ContentType returns the media type of the files of the format
+func (f ExportFormat) ContentType() string {
+	switch f {
+	case ExportNDJSON:
+		return "application/x-ndjson"
+	case ExportParquet:
+		return "application/vnd.apache.parquet"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// ExportQuery is the data to export: the readings of the rooms and of the
+// outside temperature, and the changes of the settings of the rooms, between
+// From and To
+type ExportQuery struct {
+	From       time.Time
+	To         time.Time
+	Resolution controller.Resolution
+	Format     ExportFormat
+	Rooms      []string
+	Unit       controller.TemperatureUnit
+}
+
+// Validate checks the format, the resolution and the range of the query
+func (q ExportQuery) Validate() error {
+	switch q.Format {
+	case ExportCSV, ExportNDJSON, ExportParquet:
+	default:
+		return controller.NewValidationError("invalid export format %s: use one of %s, %s or %s", q.Format, ExportCSV, ExportNDJSON, ExportParquet)
+	}
+	if q.Resolution.Duration() == 0 {
+		return controller.NewValidationError("invalid resolution of readings %s: use one of %s, %s or %s",
+			q.Resolution, controller.ResolutionFiveMinutes, controller.ResolutionHourly, controller.ResolutionDaily)
+	}
+	if !q.To.After(q.From) {
+		return controller.NewValidationError("invalid range: %s is not after %s", q.To.Format(time.RFC3339), q.From.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// ExportRecord is a record of an export, either a bucket of readings or a
+// change of the settings of a room. The fields that don't apply to the kind
+// of the record are nil.
+type ExportRecord struct {
+	Kind         string                   `json:"kind"`
+	Time         time.Time                `json:"time"`
+	Source       controller.ReadingSource `json:"source,omitempty"`
+	Room         string                   `json:"room,omitempty"`
+	Resolution   controller.Resolution    `json:"resolution,omitempty"`
+	Min          *float32                 `json:"min,omitempty"`
+	Max          *float32                 `json:"max,omitempty"`
+	Avg          *float32                 `json:"avg,omitempty"`
+	Count        *int                     `json:"count,omitempty"`
+	Enabled      *bool                    `json:"enabled,omitempty"`
+	ThresholdOn  *float32                 `json:"threshold_on,omitempty"`
+	ThresholdOff *float32                 `json:"threshold_off,omitempty"`
+	Version      *int64                   `json:"version,omitempty"`
+	Deleted      *bool                    `json:"deleted,omitempty"`
+}
+
+// exportColumns are the columns of the CSV and Parquet exports, in the order
+// of the values of ExportRecord
+var exportColumns = []utils.ParquetColumn{
+	{Name: "kind", Type: utils.ParquetString},
+	{Name: "time", Type: utils.ParquetTimestamp},
+	{Name: "source", Type: utils.ParquetString},
+	{Name: "room", Type: utils.ParquetString},
+	{Name: "resolution", Type: utils.ParquetString},
+	{Name: "min", Type: utils.ParquetDouble},
+	{Name: "max", Type: utils.ParquetDouble},
+	{Name: "avg", Type: utils.ParquetDouble},
+	{Name: "count", Type: utils.ParquetInt64},
+	{Name: "enabled", Type: utils.ParquetBoolean},
+	{Name: "threshold_on", Type: utils.ParquetDouble},
+	{Name: "threshold_off", Type: utils.ParquetDouble},
+	{Name: "version", Type: utils.ParquetInt64},
+	{Name: "deleted", Type: utils.ParquetBoolean},
+}
+
+// values returns the values of the columns of the record, nil if missing
+func (r ExportRecord) values() []interface{} {
+	values := []interface{}{r.Kind, r.Time, nil, nil, nil}
+	for i, s := range []string{string(r.Source), r.Room, string(r.Resolution)} {
+		if s != "" {
+			values[i+2] = s
+		}
+	}
+	for _, f := range []*float32{r.Min, r.Max, r.Avg} {
+		values = append(values, exportFloat(f))
+	}
+	if r.Count != nil {
+		values = append(values, int64(*r.Count))
+	} else {
+		values = append(values, nil)
+	}
+	if r.Enabled != nil {
+		values = append(values, *r.Enabled)
+	} else {
+		values = append(values, nil)
+	}
+	values = append(values, exportFloat(r.ThresholdOn), exportFloat(r.ThresholdOff))
+	if r.Version != nil {
+		values = append(values, *r.Version)
+	} else {
+		values = append(values, nil)
+	}
+	if r.Deleted != nil {
+		values = append(values, *r.Deleted)
+	} else {
+		values = append(values, nil)
+	}
+	return values
+}
+
+// exportFloat returns a temperature as the float64 with its shortest decimal
+// representation, so that 20.1 isn't exported as 20.100000381469727
+func exportFloat(f *float32) interface{} {
+	if f == nil {
+		return nil
+	}
+	v, _ := strconv.ParseFloat(strconv.FormatFloat(float64(*f), 'f', -1, 32), 64)
+	return v
+}
+
+// WriteExport writes the data of the query to w: the readings of every room
+// of the query, followed by those of the outside temperature, and then the
+// changes of the settings of the rooms. Readings are streamed as they're
+// got, so w may have been written to when an error is returned.
+func WriteExport(sh controller.SmartHomeInterface, w io.Writer, query ExportQuery) error {
+	if err := query.Validate(); err != nil {
+		return err
+	}
+	history, err := sh.RoomSettingsHistory(query.Rooms, query.From, query.To)
+	if err != nil {
+		return err
+	}
+
+	encoder := newExportEncoder(w, query.Format)
+	sources := []struct {
+		source controller.ReadingSource
+		rooms  []string
+	}{
+		{controller.SourceInside, query.Rooms},
+		{controller.SourceOutside, []string{""}},
+	}
+	for _, s := range sources {
+		for _, room := range s.rooms {
+			source := s.source
+			err := sh.ExportReadings(source, room, query.Resolution, query.From, query.To, func(bucket controller.ReadingBucket) error {
+				min, max, avg := query.Unit.FromCelsius(bucket.Min), query.Unit.FromCelsius(bucket.Max), query.Unit.FromCelsius(bucket.Avg)
+				count := bucket.Count
+				return encoder.Encode(ExportRecord{
+					Kind:       ExportKindReading,
+					Time:       bucket.Start,
+					Source:     source,
+					Room:       room,
+					Resolution: query.Resolution,
+					Min:        &min,
+					Max:        &max,
+					Avg:        &avg,
+					Count:      &count,
+				})
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, change := range history {
+		record := ExportRecord{Kind: ExportKindSetting, Time: change.Time, Room: change.Room}
+		version, deleted := change.Version, change.Deleted
+		record.Deleted = &deleted
+		if !deleted {
+			enabled := change.Enabled
+			on, off := query.Unit.FromCelsius(change.ThresholdOn), query.Unit.FromCelsius(change.ThresholdOff)
+			record.Enabled, record.ThresholdOn, record.ThresholdOff, record.Version = &enabled, &on, &off, &version
+		}
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+	}
+	return encoder.Close()
+}
+
+// exportEncoder writes the records of an export in a format
+type exportEncoder interface {
+	Encode(record ExportRecord) error
+	Close() error
+}
+
+func newExportEncoder(w io.Writer, format ExportFormat) exportEncoder {
+	switch format {
+	case ExportNDJSON:
+		return &ndjsonEncoder{json.NewEncoder(w)}
+	case ExportParquet:
+		return &parquetEncoder{utils.NewParquetWriter(w, exportColumns)}
+	}
+	return &csvEncoder{w: csv.NewWriter(w)}
+}
+
+type csvEncoder struct {
+	w      *csv.Writer
+	header bool
+}
+
+func (e *csvEncoder) Encode(record ExportRecord) error {
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+	values := record.values()
+	row := make([]string, len(values))
+	for i, value := range values {
+		switch v := value.(type) {
+		case string:
+			row[i] = v
+		case time.Time:
+			row[i] = v.UTC().Format(time.RFC3339)
+		case float64:
+			row[i] = strconv.FormatFloat(v, 'f', -1, 64)
+		case int64:
+			row[i] = strconv.FormatInt(v, 10)
+		case bool:
+			row[i] = strconv.FormatBool(v)
+		}
+	}
+	return e.w.Write(row)
+}
+
+func (e *csvEncoder) Close() error {
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// writeHeader writes the names of the columns, unless they've been written
+func (e *csvEncoder) writeHeader() error {
+	if e.header {
+		return nil
+	}
+	e.header = true
+	header := make([]string, len(exportColumns))
+	for i, column := range exportColumns {
+		header[i] = column.Name
+	}
+	return e.w.Write(header)
+}
+
+type ndjsonEncoder struct {
+	e *json.Encoder
+}
+
+func (e *ndjsonEncoder) Encode(record ExportRecord) error {
+	return e.e.Encode(record)
+}
+
+func (e *ndjsonEncoder) Close() error {
+	return nil
+}
+
+type parquetEncoder struct {
+	w *utils.ParquetWriter
+}
+
+func (e *parquetEncoder) Encode(record ExportRecord) error {
+	return e.w.Write(record.values())
+}
+
+func (e *parquetEncoder) Close() error {
+	return e.w.Close()
+}
+
+// Export exports the readings and the history of the settings of the rooms
+// between the from and to query parameters, which default to the last 30
+// days, in the format of the format query parameter. The rooms are those of
+// the room query parameters, or every room if none is set.
+func (cl *Client) Export(c echo.Context) error {
+	to, err := parseTimeParam(c, "to", time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	from, err := parseTimeParam(c, "from", to.Add(-DefaultExportRange))
+	if err != nil {
+		return err
+	}
+	unit, err := cl.temperatureUnit(c)
+	if err != nil {
+		return err
+	}
+	query := ExportQuery{
+		From:       from,
+		To:         to,
+		Resolution: controller.Resolution(c.QueryParam("resolution")),
+		Format:     ExportFormat(c.QueryParam("format")),
+		Rooms:      c.QueryParams()["room"],
+		Unit:       unit,
+	}
+	if query.Resolution == "" {
+		query.Resolution = DefaultExportResolution
+	}
+	if query.Format == "" {
+		query.Format = ExportCSV
+	}
+	if len(query.Rooms) == 0 {
+		query.Rooms = EveryRoom()
+	}
+	for _, room := range query.Rooms {
+		if room == AllRooms || !ValidRoom(room).IsValid() {
+			return NewInvalidRoomError(room)
+		}
+	}
+	if err := query.Validate(); err != nil {
+		return NewHTTPError(err, "Invalid export")
+	}
+
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentType, query.Format.ContentType())
+	header.Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="smarthome-%s-%s.%s"`,
+		from.Format("20060102T150405Z"), to.Format("20060102T150405Z"), query.Format))
+	err = WriteExport(cl.SmartHomeInterface, c.Response(), query)
+	if err != nil && !c.Response().Committed {
+		header.Del(echo.HeaderContentDisposition)
+		return NewHTTPError(err, "Error exporting data")
+	}
+	if err != nil {
+		// The status has already been sent, so the export is cut short
+		c.Logger().Errorf("error exporting data: %s", err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/igvaquero18/smarthome/controller"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExport(t *testing.T) {
+	from := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 0, 1)
+	sh := &mockSmartHome{
+		Readings: []controller.ReadingBucket{
+			{Room: "bedroom", Start: from, Min: 19, Max: 21, Avg: 20.1, Count: 12},
+			{Room: "livingroom", Start: from, Min: 18, Max: 18, Avg: 18, Count: 1},
+			{Start: from, Min: 5, Max: 7, Avg: 6, Count: 12},
+		},
+		History: []controller.RoomSettingsChange{
+			{Room: "bedroom", Time: from.Add(time.Hour), Enabled: true, ThresholdOn: 19, ThresholdOff: 20.5, Version: 2},
+			{Room: "livingroom", Time: from.Add(2 * time.Hour), Deleted: true},
+		},
+	}
+	testCases := []struct {
+		name                string
+		query               url.Values
+		smartHome           *mockSmartHome
+		expectedCode        int
+		expectedContentType string
+		expectedBody        string
+	}{
+		{
+			name:                "CSV of a room",
+			query:               url.Values{"from": {from.Format(time.RFC3339)}, "to": {to.Format(time.RFC3339)}, "room": {"bedroom"}},
+			smartHome:           sh,
+			expectedCode:        http.StatusOK,
+			expectedContentType: "text/csv; charset=utf-8",
+			expectedBody: "kind,time,source,room,resolution,min,max,avg,count,enabled,threshold_on,threshold_off,version,deleted\n" +
+				"reading,2021-01-01T00:00:00Z,inside,bedroom,1h,19,21,20.1,12,,,,,\n" +
+				"reading,2021-01-01T00:00:00Z,outside,,1h,5,7,6,12,,,,,\n" +
+				"setting,2021-01-01T01:00:00Z,,bedroom,,,,,,true,19,20.5,2,false\n",
+		},
+		{
+			name:                "NDJSON of every room in Fahrenheit",
+			query:               url.Values{"from": {from.Format(time.RFC3339)}, "to": {to.Format(time.RFC3339)}, "format": {"ndjson"}, "resolution": {"1d"}, "unit": {"F"}},
+			smartHome:           sh,
+			expectedCode:        http.StatusOK,
+			expectedContentType: "application/x-ndjson",
+			expectedBody: `{"kind":"reading","time":"2021-01-01T00:00:00Z","source":"inside","room":"bedroom","resolution":"1d","min":66.2,"max":69.8,"avg":68.2,"count":12}` + "\n" +
+				`{"kind":"reading","time":"2021-01-01T00:00:00Z","source":"inside","room":"livingroom","resolution":"1d","min":64.4,"max":64.4,"avg":64.4,"count":1}` + "\n" +
+				`{"kind":"reading","time":"2021-01-01T00:00:00Z","source":"outside","resolution":"1d","min":41,"max":44.6,"avg":42.8,"count":12}` + "\n" +
+				`{"kind":"setting","time":"2021-01-01T01:00:00Z","room":"bedroom","enabled":true,"threshold_on":66.2,"threshold_off":68.9,"version":2,"deleted":false}` + "\n" +
+				`{"kind":"setting","time":"2021-01-01T02:00:00Z","room":"livingroom","deleted":true}` + "\n",
+		},
+		{
+			name:                "Empty CSV",
+			query:               url.Values{"from": {from.Format(time.RFC3339)}, "to": {to.Format(time.RFC3339)}},
+			smartHome:           &mockSmartHome{},
+			expectedCode:        http.StatusOK,
+			expectedContentType: "text/csv; charset=utf-8",
+			expectedBody:        "kind,time,source,room,resolution,min,max,avg,count,enabled,threshold_on,threshold_off,version,deleted\n",
+		},
+		{
+			name:         "Invalid format",
+			query:        url.Values{"format": {"xlsx"}},
+			smartHome:    sh,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Raw resolution",
+			query:        url.Values{"resolution": {"raw"}},
+			smartHome:    sh,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Invalid room",
+			query:        url.Values{"room": {"attic"}},
+			smartHome:    sh,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Inverted range",
+			query:        url.Values{"from": {to.Format(time.RFC3339)}, "to": {from.Format(time.RFC3339)}},
+			smartHome:    sh,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Error getting history",
+			query:        url.Values{},
+			smartHome:    &mockSmartHome{Err: errors.New("unexpected error")},
+			expectedCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			ctx := &baseMockContext{Query: tc.query}
+			err := NewClient(JWTConfig{}, tc.smartHome).Export(ctx)
+			if tc.expectedCode != http.StatusOK {
+				httpErr, ok := err.(*echo.HTTPError)
+				if assert.True(tt, ok) {
+					assert.Equal(tt, tc.expectedCode, httpErr.Code)
+				}
+				return
+			}
+			assert.NoError(tt, err)
+			rec := ctx.Response().Writer.(*httptest.ResponseRecorder)
+			assert.Equal(tt, tc.expectedContentType, rec.Header().Get(echo.HeaderContentType))
+			extension := tc.query.Get("format")
+			if extension == "" {
+				extension = "csv"
+			}
+			assert.Equal(tt, `attachment; filename="smarthome-20210101T000000Z-20210102T000000Z.`+extension+`"`, rec.Header().Get(echo.HeaderContentDisposition))
+			assert.Equal(tt, tc.expectedBody, rec.Body.String())
+		})
+	}
+}
+
+func TestWriteExportParquet(t *testing.T) {
+	buf := &bytes.Buffer{}
+	err := WriteExport(&mockSmartHome{
+		Readings: []controller.ReadingBucket{{Room: "bedroom", Start: time.Now(), Min: 19, Max: 21, Avg: 20, Count: 12}},
+	}, buf, ExportQuery{
+		From:       time.Now().Add(-time.Hour),
+		To:         time.Now(),
+		Resolution: controller.ResolutionFiveMinutes,
+		Format:     ExportParquet,
+		Rooms:      []string{"bedroom"},
+		Unit:       controller.Celsius,
+	})
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(buf.String(), "PAR1"))
+	assert.True(t, strings.HasSuffix(buf.String(), "PAR1"))
+	assert.Contains(t, buf.String(), "threshold_on")
+}
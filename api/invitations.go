@@ -0,0 +1,102 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/igvaquero18/smarthome/controller"
+	"github.com/labstack/echo/v4"
+)
+
+// InvitationRequest is the payload for creating an invitation. ExpiresIn is a
+// duration such as "24h"; if empty, controller.DefaultInvitationExpiration is used.
+type InvitationRequest struct {
+	Role      string `json:"role"`
+	ExpiresIn string `json:"expires_in,omitempty"`
+}
+
+// CreateInvitation creates an invitation for a new user. The invitation code
+// is only returned in this response, for the invitee to accept it with
+// POST /v1/invitations/{code}/accept.
+func (cl *Client) CreateInvitation(c echo.Context) error {
+	createdBy, err := tokenSubject(c)
+	if err != nil {
+		return err
+	}
+	params := new(InvitationRequest)
+	if err := json.NewDecoder(c.Request().Body).Decode(&params); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid payload: %s", err.Error()))
+	}
+	if params.Role == "" {
+		params.Role = controller.RoleUser
+	}
+
+	expiresIn := controller.DefaultInvitationExpiration
+	if params.ExpiresIn != "" {
+		expiresIn, err = time.ParseDuration(params.ExpiresIn)
+		if err != nil || expiresIn <= 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid expiration %s", params.ExpiresIn))
+		}
+	}
+
+	invitation, code, err := cl.SmartHomeInterface.CreateInvitation(createdBy, params.Role, time.Now().Add(expiresIn))
+	if err != nil {
+		return NewHTTPError(err, "Error creating invitation")
+	}
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"code":       code,
+		"invitation": invitation,
+	})
+}
+
+// ListInvitations returns the invitations that haven't been accepted yet,
+// without their codes
+func (cl *Client) ListInvitations(c echo.Context) error {
+	invitations, err := cl.SmartHomeInterface.ListInvitations()
+	if err != nil {
+		return NewHTTPError(err, "Error listing invitations")
+	}
+	return c.JSON(http.StatusOK, invitations)
+}
+
+// DeleteInvitation revokes an invitation
+func (cl *Client) DeleteInvitation(c echo.Context) error {
+	id := c.Param("id")
+	if err := cl.SmartHomeInterface.DeleteInvitation(id); err != nil {
+		return NewHTTPError(err, "Error deleting invitation")
+	}
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "Successfully deleted invitation",
+		"id":      id,
+	})
+}
+
+// AcceptInvitation creates the user of the invitee, with the username and
+// password of its choice
+func (cl *Client) AcceptInvitation(c echo.Context) error {
+	authParams := new(Auth)
+	if err := json.NewDecoder(c.Request().Body).Decode(&authParams); err != nil {
+		return echo.NewHTTPError(
+			http.StatusBadRequest,
+			fmt.Sprintf("No valid username or password provided: %s", err.Error()),
+		)
+	}
+
+	if authParams.Username == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid payload: a username is required")
+	}
+
+	user, err := cl.SmartHomeInterface.AcceptInvitation(c.Param("code"), authParams.Username, authParams.Password)
+	if err != nil {
+		return NewHTTPError(err, "Error accepting the invitation")
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message":  "Successfully signed up",
+		"username": user.Username,
+		"role":     user.Role,
+	})
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/igvaquero18/smarthome/controller"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateInvitation(t *testing.T) {
+	user := &jwt.Token{Claims: jwt.MapClaims{"sub": "admin"}, Valid: true}
+	testCases := []struct {
+		name         string
+		body         string
+		expectedCode int
+		expectedRole string
+	}{
+		{
+			name:         "Valid invitation",
+			body:         `{"role": "admin", "expires_in": "24h"}`,
+			expectedCode: http.StatusCreated,
+			expectedRole: controller.RoleAdmin,
+		},
+		{
+			name:         "Default role",
+			body:         `{}`,
+			expectedCode: http.StatusCreated,
+			expectedRole: controller.RoleUser,
+		},
+		{
+			name:         "Invalid expiration",
+			body:         `{"role": "user", "expires_in": "-1h"}`,
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			ctx := &baseMockContext{User: user, Body: tc.body}
+			err := NewClient(JWTConfig{}, &mockSmartHome{}).CreateInvitation(ctx)
+			if tc.expectedCode != http.StatusCreated {
+				httpErr, ok := err.(*echo.HTTPError)
+				if assert.True(tt, ok) {
+					assert.Equal(tt, tc.expectedCode, httpErr.Code)
+				}
+				return
+			}
+			assert.NoError(tt, err)
+			payload := ctx.GetJSONPayload().(map[string]interface{})
+			assert.Equal(tt, "shi_1_secret", payload["code"])
+			assert.NotContains(tt, payload, "link")
+			assert.Equal(tt, tc.expectedRole, payload["invitation"].(*controller.Invitation).Role)
+		})
+	}
+}
+
+func TestAcceptInvitation(t *testing.T) {
+	testCases := []struct {
+		name         string
+		code         string
+		body         string
+		smartHome    *mockSmartHome
+		expectedCode int
+	}{
+		{
+			name:         "Valid invitation",
+			code:         "shi_1_secret",
+			body:         `{"username": "bob", "password": "correct horse battery"}`,
+			smartHome:    &mockSmartHome{},
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Invalid invitation",
+			code:         "shi_2_secret",
+			body:         `{"username": "bob", "password": "correct horse battery"}`,
+			smartHome:    &mockSmartHome{},
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "Missing username",
+			code:         "shi_1_secret",
+			body:         `{"password": "correct horse battery"}`,
+			smartHome:    &mockSmartHome{},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Existing user",
+			code:         "shi_1_secret",
+			body:         `{"username": "alice", "password": "correct horse battery"}`,
+			smartHome:    &mockSmartHome{Err: controller.ErrUserExists},
+			expectedCode: http.StatusConflict,
+		},
+		{
+			name:         "Weak password",
+			code:         "shi_1_secret",
+			body:         `{"username": "bob", "password": "short"}`,
+			smartHome:    &mockSmartHome{Err: controller.ErrWeakPassword},
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			ctx := &baseMockContext{Parameter: tc.code, Body: tc.body}
+			err := NewClient(JWTConfig{}, tc.smartHome).AcceptInvitation(ctx)
+			if tc.expectedCode == http.StatusOK {
+				assert.NoError(tt, err)
+				return
+			}
+			httpErr, ok := err.(*echo.HTTPError)
+			if assert.True(tt, ok) {
+				assert.Equal(tt, tc.expectedCode, httpErr.Code)
+			}
+		})
+	}
+}
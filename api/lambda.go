@@ -0,0 +1,63 @@
+package api
+
+import (
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/igvaquero18/smarthome/controller"
+	"github.com/igvaquero18/smarthome/utils"
+)
+
+// AuthorizeLambdaRequest authenticates a Lambda request with either an API
+// key granted the scope, or a JWT token. The error returned when the request
+// is not authorized is of the Unauthorized or Forbidden kind.
+func AuthorizeLambdaRequest(headers map[string]string, keys *utils.KeySet, sh controller.SmartHomeInterface, scope string) error {
+	if token := HeaderValue(headers, APIKeyHeader); token != "" {
+		key, err := sh.AuthenticateAPIKey(token)
+		if err != nil {
+			return controller.ErrInvalidAPIKey
+		}
+		if !key.HasScope(scope) {
+			return controller.NewForbiddenError("The API key lacks the %s scope", scope)
+		}
+		return nil
+	}
+
+	if err := utils.ValidateTokenFromHeader(HeaderValue(headers, "Authorization"), keys); err != nil {
+		return controller.NewForbiddenError("Authentication failure: %w", err)
+	}
+	return nil
+}
+
+// LambdaTokenSubject returns the user of the JWT token of a Lambda request, or
+// an empty string if the request is authenticated with an API key, or isn't
+// authenticated at all
+func LambdaTokenSubject(headers map[string]string, keys *utils.KeySet) string {
+	if HeaderValue(headers, APIKeyHeader) != "" || keys.Empty() {
+		return ""
+	}
+	token, err := utils.ParseTokenFromHeader(HeaderValue(headers, "Authorization"), keys)
+	if err != nil {
+		return ""
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return ""
+	}
+	sub, _ := claims["sub"].(string)
+	return sub
+}
+
+// HeaderValue looks up a header of a Lambda request case-insensitively, as
+// API Gateway passes them as sent by the client
+func HeaderValue(headers map[string]string, name string) string {
+	if v, ok := headers[name]; ok {
+		return v
+	}
+	for k, v := range headers {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return ""
+}
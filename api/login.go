@@ -3,21 +3,42 @@ package api
 import (
 	"encoding/json"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
+	"github.com/igvaquero18/smarthome/controller"
+	"github.com/igvaquero18/smarthome/utils"
 	"github.com/labstack/echo/v4"
 )
 
+const (
+	// ErrWrongCredentials is returned by Login whenever the credentials are not valid
+	ErrWrongCredentials = "Wrong username or password"
+
+	// ErrTooManyLoginAttempts is returned by Login while logins are throttled
+	ErrTooManyLoginAttempts = "Too many failed login attempts, try again later"
+
+	// ErrMFARequired is returned by Login when the credentials are valid but
+	// the user has to provide a two-factor authentication code
+	ErrMFARequired = "Two-factor authentication code required"
+)
+
 // Auth is a struct that holds the credentials (username and password)
 // of a particular user
 type Auth struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
+
+	// OTP is the TOTP or recovery code of users with two-factor authentication
+	OTP string `json:"otp,omitempty"`
 }
 
-// Login returns a valid JWT token
+// Login returns a valid JWT token. Failed attempts are throttled per user
+// and per client IP address, and the error returned is the same whatever the
+// reason, so that it doesn't reveal whether the user exists.
 func (cl *Client) Login(c echo.Context) error {
 	authParams := new(Auth)
 	if err := json.NewDecoder(c.Request().Body).Decode(&authParams); err != nil {
@@ -27,19 +48,48 @@ func (cl *Client) Login(c echo.Context) error {
 		)
 	}
 
-	if err := cl.Authenticate(authParams.Username, authParams.Password); err != nil {
-		return echo.NewHTTPError(
-			http.StatusForbidden,
-			fmt.Sprintf("Wrong username or password: %s", err.Error()),
-		)
+	ip := c.RealIP()
+	if err := cl.checkLoginAllowed(c, authParams.Username); err != nil {
+		return err
 	}
 
-	token := jwt.New(jwt.SigningMethodHS256)
-	claims := token.Claims.(jwt.MapClaims)
-	claims["sub"] = authParams.Username
-	claims["exp"] = time.Now().Add(cl.Config.JWTExpiration).Unix()
+	var user *controller.User
+	err := cl.Authenticate(authParams.Username, authParams.Password)
+	if err == nil {
+		user, err = cl.GetUser(authParams.Username)
+	}
+	if err != nil || user == nil {
+		if recordErr := cl.RecordLoginFailure(authParams.Username, ip); recordErr != nil {
+			err = recordErr
+		}
+		return &echo.HTTPError{
+			Code:     http.StatusForbidden,
+			Message:  ErrWrongCredentials,
+			Internal: err,
+		}
+	}
+
+	if user.MFAEnabled {
+		if authParams.OTP == "" {
+			return echo.NewHTTPError(http.StatusUnauthorized, ErrMFARequired)
+		}
+		if err := cl.VerifyMFA(authParams.Username, authParams.OTP); err != nil {
+			if recordErr := cl.RecordLoginFailure(authParams.Username, ip); recordErr != nil {
+				err = recordErr
+			}
+			return &echo.HTTPError{
+				Code:     http.StatusForbidden,
+				Message:  ErrWrongCredentials,
+				Internal: err,
+			}
+		}
+	}
+
+	if err := cl.ResetLoginFailures(authParams.Username); err != nil {
+		c.Logger().Errorf("error resetting failed login attempts: %s", err.Error())
+	}
 
-	t, err := token.SignedString([]byte(cl.Config.JWTSecret))
+	t, err := cl.issueToken(user)
 	if err != nil {
 		return echo.NewHTTPError(
 			http.StatusInternalServerError,
@@ -63,10 +113,7 @@ func (cl *Client) SignUp(c echo.Context) error {
 	}
 
 	if err := cl.SetCredentials(authParams.Username, authParams.Password); err != nil {
-		return echo.NewHTTPError(
-			http.StatusInternalServerError,
-			fmt.Sprintf("Error saving the credentials in the database: %s", err.Error()),
-		)
+		return NewHTTPError(err, "Error saving the credentials in the database")
 	}
 
 	return c.JSON(http.StatusOK, map[string]string{
@@ -74,6 +121,51 @@ func (cl *Client) SignUp(c echo.Context) error {
 	})
 }
 
+// PasswordChange is the payload for changing the password of a user
+type PasswordChange struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+// ChangePassword changes the password of the logged in user, which has to
+// provide its current password. Wrong passwords count as failed logins.
+func (cl *Client) ChangePassword(c echo.Context) error {
+	username, err := tokenSubject(c)
+	if err != nil {
+		return err
+	}
+	params := new(PasswordChange)
+	if err := json.NewDecoder(c.Request().Body).Decode(&params); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid payload: %s", err.Error()))
+	}
+
+	ip := c.RealIP()
+	if err := cl.checkLoginAllowed(c, username); err != nil {
+		return err
+	}
+
+	if err := cl.Authenticate(username, params.OldPassword); err != nil {
+		if recordErr := cl.RecordLoginFailure(username, ip); recordErr != nil {
+			err = recordErr
+		}
+		return &echo.HTTPError{
+			Code:     http.StatusForbidden,
+			Message:  ErrWrongCredentials,
+			Internal: err,
+		}
+	}
+
+	// The old password was just checked along with the lockout of the user,
+	// so it isn't checked again
+	if err := cl.SetPassword(username, params.NewPassword); err != nil {
+		return NewHTTPError(err, "Error changing the password")
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "Successfully changed password",
+	})
+}
+
 // DeleteUser is a method that allows to remove an admin user from SmartHome
 func (cl *Client) DeleteUser(c echo.Context) error {
 	authParams := new(Auth)
@@ -84,13 +176,64 @@ func (cl *Client) DeleteUser(c echo.Context) error {
 		)
 	}
 	if err := cl.SmartHomeInterface.DeleteUser(authParams.Username); err != nil {
+		return NewHTTPError(err, "Error when deleting user from DynamoDB")
+	}
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "Successfully deleted user",
+		"user":    authParams.Username,
+	})
+}
+
+// checkLoginAllowed returns a Too Many Requests error, telling the client how
+// long to wait, while the failed login attempts of the user or of the client
+// IP address are throttled
+func (cl *Client) checkLoginAllowed(c echo.Context, username string) error {
+	wait, err := cl.LoginAllowed(username, c.RealIP())
+	if err != nil {
+		return &echo.HTTPError{
+			Code:     http.StatusInternalServerError,
+			Message:  "Error checking failed login attempts",
+			Internal: err,
+		}
+	}
+	if wait > 0 {
+		c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		return echo.NewHTTPError(http.StatusTooManyRequests, ErrTooManyLoginAttempts)
+	}
+	return nil
+}
+
+// issueToken returns a signed JWT for the user, including its role
+func (cl *Client) issueToken(user *controller.User) (string, error) {
+	return cl.keys().Sign(jwt.MapClaims{
+		utils.TypeClaim: utils.AccessToken,
+		"sub":           user.Username,
+		"role":          user.Role,
+		"exp":           time.Now().Add(cl.jwtExpiration()).Unix(),
+	})
+}
+
+// UnlockUser forgets the failed login attempts of a user, so that it can
+// log in again right away
+func (cl *Client) UnlockUser(c echo.Context) error {
+	authParams := new(Auth)
+	if err := json.NewDecoder(c.Request().Body).Decode(&authParams); err != nil || authParams.Username == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid payload: a username is required")
+	}
+	if err := cl.ResetLoginFailures(authParams.Username); err != nil {
 		return echo.NewHTTPError(
 			http.StatusInternalServerError,
-			"Error when deleting user from DynamoDB: %s", err.Error(),
+			fmt.Sprintf("Error unlocking user: %s", err.Error()),
 		)
 	}
 	return c.JSON(http.StatusOK, map[string]string{
-		"message": "Successfully deleted user",
+		"message": "Successfully unlocked user",
 		"user":    authParams.Username,
 	})
 }
+
+// JWKS returns the public keys used for signing tokens as a JSON Web Key Set,
+// so that other services can verify them without knowing any secret.
+func (cl *Client) JWKS(c echo.Context) error {
+	return c.JSON(http.StatusOK, cl.keys().JWKS())
+}
@@ -2,9 +2,13 @@ package api
 
 import (
 	"fmt"
+	"net/http"
 	"testing"
 	"time"
 
+	"github.com/dgrijalva/jwt-go"
+	"github.com/igvaquero18/smarthome/controller"
+	"github.com/igvaquero18/smarthome/utils"
 	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/assert"
 )
@@ -60,6 +64,38 @@ func TestLogin(t *testing.T) {
 			),
 			errorExpected: true,
 		},
+		{
+			name: "Request throttled after failed attempts",
+			ctx: &baseMockContext{
+				Body: `{"username": "admin", "password": "admin"}`,
+			},
+			cl: NewClient(
+				JWTConfig{
+					JWTSecret:     "secret",
+					JWTExpiration: time.Minute,
+				},
+				&mockSmartHome{
+					LoginWait: 1500 * time.Millisecond,
+				},
+			),
+			errorExpected: true,
+		},
+		{
+			name: "Unknown user",
+			ctx: &baseMockContext{
+				Body: `{"username": "nobody", "password": "admin"}`,
+			},
+			cl: NewClient(
+				JWTConfig{
+					JWTSecret:     "secret",
+					JWTExpiration: time.Minute,
+				},
+				&mockSmartHome{
+					Users: map[string]*controller.User{},
+				},
+			),
+			errorExpected: true,
+		},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(tt *testing.T) {
@@ -118,6 +154,19 @@ func TestSignUp(t *testing.T) {
 			),
 			errorExpected: true,
 		},
+		{
+			name: "Request for an existing user",
+			ctx: &baseMockContext{
+				Body: `{"username": "admin", "password": "admin"}`,
+			},
+			cl: NewClient(
+				JWTConfig{},
+				&mockSmartHome{
+					Err: controller.ErrUserExists,
+				},
+			),
+			errorExpected: true,
+		},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(tt *testing.T) {
@@ -187,3 +236,163 @@ func TestDeleteUser(t *testing.T) {
 		})
 	}
 }
+
+func TestJWKS(t *testing.T) {
+	ctx := &baseMockContext{}
+	cl := NewClient(JWTConfig{JWTSecret: "secret"}, &mockSmartHome{})
+	err := cl.JWKS(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, utils.JWKS{Keys: []utils.JWK{}}, ctx.GetJSONPayload())
+}
+
+func TestLoginErrors(t *testing.T) {
+	testCases := []struct {
+		name               string
+		body               string
+		smartHome          *mockSmartHome
+		expectedCode       int
+		expectedMessage    string
+		expectedRetryAfter string
+	}{
+		{
+			name:            "Wrong password",
+			smartHome:       &mockSmartHome{Err: fmt.Errorf("crypto/bcrypt: hashedPassword is not the hash of the given password")},
+			expectedCode:    http.StatusForbidden,
+			expectedMessage: ErrWrongCredentials,
+		},
+		{
+			name:            "Unknown user",
+			smartHome:       &mockSmartHome{Users: map[string]*controller.User{}},
+			expectedCode:    http.StatusForbidden,
+			expectedMessage: ErrWrongCredentials,
+		},
+		{
+			name: "Missing two-factor authentication code",
+			smartHome: &mockSmartHome{Users: map[string]*controller.User{
+				"admin": {Username: "admin", Role: controller.RoleAdmin, MFAEnabled: true},
+			}},
+			expectedCode:    http.StatusUnauthorized,
+			expectedMessage: ErrMFARequired,
+		},
+		{
+			name: "Wrong two-factor authentication code",
+			body: `{"username": "admin", "password": "admin", "otp": "000000"}`,
+			smartHome: &mockSmartHome{MFACode: "123456", Users: map[string]*controller.User{
+				"admin": {Username: "admin", Role: controller.RoleAdmin, MFAEnabled: true},
+			}},
+			expectedCode:    http.StatusForbidden,
+			expectedMessage: ErrWrongCredentials,
+		},
+		{
+			name:               "Throttled",
+			smartHome:          &mockSmartHome{LoginWait: 1500 * time.Millisecond},
+			expectedCode:       http.StatusTooManyRequests,
+			expectedMessage:    ErrTooManyLoginAttempts,
+			expectedRetryAfter: "2",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			body := tc.body
+			if body == "" {
+				body = `{"username": "admin", "password": "admin"}`
+			}
+			ctx := &baseMockContext{Body: body}
+			cl := NewClient(JWTConfig{JWTSecret: "secret", JWTExpiration: time.Minute}, tc.smartHome)
+			err := cl.Login(ctx)
+			httpErr, ok := err.(*echo.HTTPError)
+			if !assert.True(tt, ok) {
+				return
+			}
+			assert.Equal(tt, tc.expectedCode, httpErr.Code)
+			assert.Equal(tt, tc.expectedMessage, httpErr.Message)
+			assert.Equal(tt, tc.expectedRetryAfter, ctx.Response().Header().Get("Retry-After"))
+		})
+	}
+}
+
+func TestUnlockUser(t *testing.T) {
+	testCases := []struct {
+		name          string
+		body          string
+		smartHome     *mockSmartHome
+		errorExpected bool
+	}{
+		{
+			name:      "Unlock user",
+			body:      `{"username": "admin"}`,
+			smartHome: &mockSmartHome{},
+		},
+		{
+			name:          "Missing username",
+			body:          `{}`,
+			smartHome:     &mockSmartHome{},
+			errorExpected: true,
+		},
+		{
+			name:          "Database error",
+			body:          `{"username": "admin"}`,
+			smartHome:     &mockSmartHome{Err: fmt.Errorf("Error")},
+			errorExpected: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			ctx := &baseMockContext{Body: tc.body}
+			err := NewClient(JWTConfig{}, tc.smartHome).UnlockUser(ctx)
+			if tc.errorExpected {
+				assert.Error(tt, err)
+				return
+			}
+			assert.NoError(tt, err)
+		})
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	user := &jwt.Token{Claims: jwt.MapClaims{"sub": "admin"}, Valid: true}
+	testCases := []struct {
+		name         string
+		ctx          *baseMockContext
+		smartHome    *mockSmartHome
+		expectedCode int
+	}{
+		{
+			name:         "Valid change",
+			ctx:          &baseMockContext{User: user, Body: `{"old_password": "old password", "new_password": "new long password"}`},
+			smartHome:    &mockSmartHome{},
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Wrong old password",
+			ctx:          &baseMockContext{User: user, Body: `{"old_password": "wrong", "new_password": "new long password"}`},
+			smartHome:    &mockSmartHome{Err: fmt.Errorf("crypto/bcrypt: hashedPassword is not the hash of the given password")},
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "Weak new password",
+			ctx:          &baseMockContext{User: user, Body: `{"old_password": "old password", "new_password": "short"}`},
+			smartHome:    &mockSmartHome{},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Not logged in",
+			ctx:          &baseMockContext{Body: `{"old_password": "old password", "new_password": "new long password"}`},
+			smartHome:    &mockSmartHome{},
+			expectedCode: http.StatusUnauthorized,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			err := NewClient(JWTConfig{}, tc.smartHome).ChangePassword(tc.ctx)
+			if tc.expectedCode == http.StatusOK {
+				assert.NoError(tt, err)
+				return
+			}
+			httpErr, ok := err.(*echo.HTTPError)
+			if assert.True(tt, ok) {
+				assert.Equal(tt, tc.expectedCode, httpErr.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/igvaquero18/smarthome/controller"
+	"github.com/igvaquero18/smarthome/utils"
+	"github.com/labstack/echo/v4"
+)
+
+// MFAIssuer is the issuer shown by authenticator apps for SmartHome codes
+const MFAIssuer = "SmartHome"
+
+// MFACode is the payload for confirming or disabling two-factor authentication
+type MFACode struct {
+	Code string `json:"code"`
+}
+
+// EnrollMFA starts enabling two-factor authentication for the logged in user,
+// returning the TOTP secret and the otpauth URI to add it to an authenticator app
+func (cl *Client) EnrollMFA(c echo.Context) error {
+	username, err := tokenSubject(c)
+	if err != nil {
+		return err
+	}
+
+	secret, err := cl.SmartHomeInterface.EnrollMFA(username)
+	if err != nil {
+		return NewHTTPError(err, "Error enrolling in two-factor authentication")
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"secret": secret,
+		"uri":    utils.TOTPURI(MFAIssuer, username, secret),
+	})
+}
+
+// ConfirmMFA enables two-factor authentication for the logged in user once
+// it provides a valid code, returning the recovery codes
+func (cl *Client) ConfirmMFA(c echo.Context) error {
+	username, err := tokenSubject(c)
+	if err != nil {
+		return err
+	}
+	params := new(MFACode)
+	if err := json.NewDecoder(c.Request().Body).Decode(&params); err != nil || params.Code == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid payload: a code is required")
+	}
+
+	codes, err := cl.SmartHomeInterface.ConfirmMFA(username, params.Code)
+	if err != nil {
+		return NewHTTPError(err, "Error enabling two-factor authentication")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message":        "Successfully enabled two-factor authentication",
+		"recovery_codes": codes,
+	})
+}
+
+// DisableMFA disables two-factor authentication for the logged in user,
+// which has to provide a valid code. Wrong codes count as failed logins.
+func (cl *Client) DisableMFA(c echo.Context) error {
+	username, err := tokenSubject(c)
+	if err != nil {
+		return err
+	}
+	params := new(MFACode)
+	if err := json.NewDecoder(c.Request().Body).Decode(&params); err != nil || params.Code == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid payload: a code is required")
+	}
+
+	if err := cl.checkLoginAllowed(c, username); err != nil {
+		return err
+	}
+	if err := cl.VerifyMFA(username, params.Code); err != nil {
+		if recordErr := cl.RecordLoginFailure(username, c.RealIP()); recordErr != nil {
+			err = recordErr
+		}
+		return &echo.HTTPError{
+			Code:     http.StatusForbidden,
+			Message:  controller.ErrInvalidMFACode.Error(),
+			Internal: err,
+		}
+	}
+	if err := cl.SmartHomeInterface.DisableMFA(username); err != nil {
+		return NewHTTPError(err, "Error disabling two-factor authentication")
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "Successfully disabled two-factor authentication",
+	})
+}
+
+// tokenSubject returns the user of the JWT token stored in the context by the JWT middleware
+func tokenSubject(c echo.Context) (string, error) {
+	token, ok := c.Get("user").(*jwt.Token)
+	if !ok {
+		return "", echo.NewHTTPError(http.StatusUnauthorized, "Authentication required")
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return "", echo.NewHTTPError(http.StatusUnauthorized, "Authentication required")
+	}
+	sub, _ := claims["sub"].(string)
+	if sub == "" {
+		return "", echo.NewHTTPError(http.StatusUnauthorized, "Authentication required")
+	}
+	return sub, nil
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMFA(t *testing.T) {
+	user := &jwt.Token{Claims: jwt.MapClaims{"sub": "admin"}, Valid: true}
+	testCases := []struct {
+		name         string
+		handler      func(cl *Client) echo.HandlerFunc
+		ctx          *baseMockContext
+		loginWait    time.Duration
+		expectedCode int
+	}{
+		{
+			name:         "Enroll",
+			handler:      func(cl *Client) echo.HandlerFunc { return cl.EnrollMFA },
+			ctx:          &baseMockContext{User: user},
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Enroll without token",
+			handler:      func(cl *Client) echo.HandlerFunc { return cl.EnrollMFA },
+			ctx:          &baseMockContext{},
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "Confirm with valid code",
+			handler:      func(cl *Client) echo.HandlerFunc { return cl.ConfirmMFA },
+			ctx:          &baseMockContext{User: user, Body: `{"code": "123456"}`},
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Confirm with invalid code",
+			handler:      func(cl *Client) echo.HandlerFunc { return cl.ConfirmMFA },
+			ctx:          &baseMockContext{User: user, Body: `{"code": "000000"}`},
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "Confirm without code",
+			handler:      func(cl *Client) echo.HandlerFunc { return cl.ConfirmMFA },
+			ctx:          &baseMockContext{User: user, Body: `{}`},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Disable with valid code",
+			handler:      func(cl *Client) echo.HandlerFunc { return cl.DisableMFA },
+			ctx:          &baseMockContext{User: user, Body: `{"code": "123456"}`},
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Disable with invalid code",
+			handler:      func(cl *Client) echo.HandlerFunc { return cl.DisableMFA },
+			ctx:          &baseMockContext{User: user, Body: `{"code": "000000"}`},
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "Disable while throttled",
+			handler:      func(cl *Client) echo.HandlerFunc { return cl.DisableMFA },
+			ctx:          &baseMockContext{User: user, Body: `{"code": "123456"}`},
+			loginWait:    time.Minute,
+			expectedCode: http.StatusTooManyRequests,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			cl := NewClient(JWTConfig{}, &mockSmartHome{MFACode: "123456", LoginWait: tc.loginWait})
+			err := tc.handler(cl)(tc.ctx)
+			if tc.expectedCode != http.StatusOK {
+				httpErr, ok := err.(*echo.HTTPError)
+				if assert.True(tt, ok) {
+					assert.Equal(tt, tc.expectedCode, httpErr.Code)
+				}
+				return
+			}
+			assert.NoError(tt, err)
+			assert.NotNil(tt, tc.ctx.GetJSONPayload())
+		})
+	}
+}
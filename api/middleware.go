@@ -0,0 +1,118 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/igvaquero18/smarthome/controller"
+	"github.com/igvaquero18/smarthome/utils"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+// JWT returns an echo middleware that only lets requests through if they
+// carry a valid JWT access token in the Authorization header, signed with any
+// of the keys of the KeySet. As the echo JWT middleware does, the parsed token
+// is stored in the context under the "user" key.
+func JWT(keys *utils.KeySet, skipper middleware.Skipper) echo.MiddlewareFunc {
+	if skipper == nil {
+		skipper = middleware.DefaultSkipper
+	}
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if skipper(c) {
+				return next(c)
+			}
+
+			auth := c.Request().Header.Get(echo.HeaderAuthorization)
+			// Anonymous requests are unauthorized, rather than the bad
+			// requests of echo's JWT middleware
+			if !strings.HasPrefix(auth, "Bearer ") {
+				return echo.NewHTTPError(http.StatusUnauthorized, middleware.ErrJWTMissing.Message)
+			}
+
+			token, err := keys.ParseAccessToken(strings.TrimPrefix(auth, "Bearer "))
+			if err != nil || !token.Valid {
+				return &echo.HTTPError{
+					Code:     middleware.ErrJWTInvalid.Code,
+					Message:  middleware.ErrJWTInvalid.Message,
+					Internal: err,
+				}
+			}
+
+			c.Set("user", token)
+			return next(c)
+		}
+	}
+}
+
+// RequireRole returns an echo middleware that only lets requests through if
+// the JWT token stored in the context by the JWT middleware has the role.
+func RequireRole(role string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			token, ok := c.Get("user").(*jwt.Token)
+			if !ok {
+				return echo.NewHTTPError(http.StatusForbidden, "Forbidden")
+			}
+			claims, ok := token.Claims.(jwt.MapClaims)
+			if r, _ := claims["role"].(string); !ok || r != role {
+				return echo.NewHTTPError(http.StatusForbidden, "Forbidden")
+			}
+			return next(c)
+		}
+	}
+}
+
+// APIKeyHeader is the header carrying the API key of devices and automations
+const APIKeyHeader = "X-API-Key"
+
+// apiKeyContextKey is where the APIKey middleware stores the authenticated key
+const apiKeyContextKey = "apikey"
+
+// APIKey returns an echo middleware that authenticates requests carrying an
+// API key in the X-API-Key header, storing it in the context. Requests
+// without the header are let through, to be authenticated by other means.
+func APIKey(authenticate func(token string) (*controller.APIKey, error)) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			token := c.Request().Header.Get(APIKeyHeader)
+			if token == "" {
+				return next(c)
+			}
+			key, err := authenticate(token)
+			if err != nil {
+				return &echo.HTTPError{
+					Code:     http.StatusUnauthorized,
+					Message:  controller.ErrInvalidAPIKey.Error(),
+					Internal: err,
+				}
+			}
+			c.Set(apiKeyContextKey, key)
+			return next(c)
+		}
+	}
+}
+
+// APIKeyAuthenticated returns true if the request was authenticated with an
+// API key by the APIKey middleware
+func APIKeyAuthenticated(c echo.Context) bool {
+	_, ok := c.Get(apiKeyContextKey).(*controller.APIKey)
+	return ok
+}
+
+// RequireScope returns an echo middleware that rejects requests authenticated
+// with an API key without the scope. Requests authenticated otherwise are
+// let through.
+func RequireScope(scope string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if key, ok := c.Get(apiKeyContextKey).(*controller.APIKey); ok && !key.HasScope(scope) {
+				return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("The API key lacks the %s scope", scope))
+			}
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/igvaquero18/smarthome/utils"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJWT(t *testing.T) {
+	keys := utils.NewHMACKeySet("secret")
+	valid, _ := keys.Sign(jwt.MapClaims{utils.TypeClaim: utils.AccessToken, "sub": "admin", "exp": time.Now().Add(time.Minute).Unix()})
+	expired, _ := keys.Sign(jwt.MapClaims{utils.TypeClaim: utils.AccessToken, "sub": "admin", "exp": time.Now().Add(-time.Minute).Unix()})
+	other, _ := utils.NewHMACKeySet("other").Sign(jwt.MapClaims{utils.TypeClaim: utils.AccessToken, "sub": "admin"})
+	untyped, _ := keys.Sign(jwt.MapClaims{"sub": "admin"})
+	anonymous, _ := keys.Sign(jwt.MapClaims{utils.TypeClaim: utils.AccessToken})
+	state, _ := keys.Sign(jwt.MapClaims{utils.TypeClaim: utils.OIDCStateToken, "state": "state", "nonce": "nonce"})
+
+	testCases := []struct {
+		name         string
+		header       string
+		skip         bool
+		expectedCode int
+	}{
+		{
+			name:         "Valid token",
+			header:       "Bearer " + valid,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Missing token",
+			header:       "",
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "Expired token",
+			header:       "Bearer " + expired,
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "Token signed with another secret",
+			header:       "Bearer " + other,
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "Token without type",
+			header:       "Bearer " + untyped,
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "Token without subject",
+			header:       "Bearer " + anonymous,
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "OpenID Connect login state",
+			header:       "Bearer " + state,
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "Skipped",
+			header:       "",
+			skip:         true,
+			expectedCode: http.StatusOK,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.header != "" {
+				req.Header.Set(echo.HeaderAuthorization, tc.header)
+			}
+			c := echo.New().NewContext(req, httptest.NewRecorder())
+			h := JWT(keys, func(echo.Context) bool { return tc.skip })(func(c echo.Context) error {
+				return c.NoContent(http.StatusOK)
+			})
+			err := h(c)
+			if tc.expectedCode != http.StatusOK {
+				assert.Error(tt, err)
+				assert.Equal(tt, tc.expectedCode, err.(*echo.HTTPError).Code)
+				return
+			}
+			assert.NoError(tt, err)
+		})
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	keys := utils.NewHMACKeySet("secret")
+	admin, _ := keys.Sign(jwt.MapClaims{utils.TypeClaim: utils.AccessToken, "sub": "admin", "role": "admin"})
+	user, _ := keys.Sign(jwt.MapClaims{utils.TypeClaim: utils.AccessToken, "sub": "alice", "role": "user"})
+	legacy, _ := keys.Sign(jwt.MapClaims{utils.TypeClaim: utils.AccessToken, "sub": "admin"})
+
+	testCases := []struct {
+		name         string
+		token        string
+		expectedCode int
+	}{
+		{
+			name:         "Admin token",
+			token:        admin,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "User token",
+			token:        user,
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "Token without role",
+			token:        legacy,
+			expectedCode: http.StatusForbidden,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			e := echo.New()
+			e.GET("/", func(c echo.Context) error {
+				return c.NoContent(http.StatusOK)
+			}, JWT(keys, nil), RequireRole("admin"))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+tc.token)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			assert.Equal(tt, tc.expectedCode, rec.Code)
+		})
+	}
+}
@@ -6,10 +6,14 @@ import (
 	"io/ioutil"
 	"mime/multipart"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/dgrijalva/jwt-go"
+	"github.com/igvaquero18/smarthome/controller"
+	"github.com/igvaquero18/smarthome/utils"
 	"github.com/labstack/echo/v4"
 )
 
@@ -22,8 +26,12 @@ type mockContext interface {
 
 type baseMockContext struct {
 	Body        string
+	Header      http.Header
 	Parameter   string
+	Query       url.Values
 	JSONPayload interface{}
+	User        *jwt.Token
+	response    *echo.Response
 }
 
 func (base *baseMockContext) GetToken(secret string) *jwt.Token {
@@ -51,7 +59,8 @@ func (base *baseMockContext) GetParameter() string {
 
 func (base *baseMockContext) Request() *http.Request {
 	return &http.Request{
-		Body: ioutil.NopCloser(bytes.NewReader([]byte(base.Body))),
+		Header: base.Header,
+		Body:   ioutil.NopCloser(bytes.NewReader([]byte(base.Body))),
 	}
 }
 
@@ -64,7 +73,10 @@ func (base *baseMockContext) SetResponse(r *echo.Response) {
 }
 
 func (base *baseMockContext) Response() *echo.Response {
-	return nil
+	if base.response == nil {
+		base.response = echo.NewResponse(httptest.NewRecorder(), nil)
+	}
+	return base.response
 }
 
 func (base *baseMockContext) IsTLS() bool {
@@ -112,10 +124,13 @@ func (base *baseMockContext) SetParamValues(values ...string) {
 }
 
 func (base *baseMockContext) QueryParam(name string) string {
-	return ""
+	return base.Query.Get(name)
 }
 
 func (base *baseMockContext) QueryParams() url.Values {
+	if base.Query != nil {
+		return base.Query
+	}
 	return url.Values{}
 }
 
@@ -152,6 +167,9 @@ func (base *baseMockContext) Cookies() []*http.Cookie {
 }
 
 func (base *baseMockContext) Get(key string) interface{} {
+	if key == "user" && base.User != nil {
+		return base.User
+	}
 	return nil
 }
 
@@ -275,6 +293,18 @@ func (base *baseMockContext) Reset(r *http.Request, w http.ResponseWriter) {
 type mockSmartHome struct {
 	BedroomOpts    map[string]types.AttributeValue
 	LivingRoomOpts map[string]types.AttributeValue
+	Users          map[string]*controller.User
+	LoginWait      time.Duration
+	MFACode        string
+	APIKeys        map[string]*controller.APIKey
+	RoomChanges    []controller.RoomChange
+	Zones          map[string]*controller.Zone
+	Readings       []controller.ReadingBucket
+	AddedReadings  []controller.Reading
+	History        []controller.RoomSettingsChange
+	Heating        []controller.HeatingTransition
+	Compensation   map[string]float32
+	EffectiveCalls int
 	Err            error
 }
 
@@ -284,9 +314,29 @@ func (m *mockSmartHome) Authenticate(username, password string) error {
 func (m *mockSmartHome) SetCredentials(username, password string) error {
 	return m.Err
 }
-func (m *mockSmartHome) SetRoomOptions(room string, enabled bool, thresholdOn, thresholdOff float32) error {
+func (m *mockSmartHome) GetUser(username string) (*controller.User, error) {
+	if m.Users != nil {
+		return m.Users[username], m.Err
+	}
+	return &controller.User{Username: username, Role: controller.RoleAdmin}, m.Err
+}
+func (m *mockSmartHome) CreateExternalUser(username, role string) error {
+	if m.Users != nil && m.Err == nil {
+		m.Users[username] = &controller.User{Username: username, Role: role}
+	}
+	return m.Err
+}
+func (m *mockSmartHome) SetTemperatureUnit(username string, unit controller.TemperatureUnit) error {
+	if m.Users != nil && m.Err == nil {
+		if user, ok := m.Users[username]; ok {
+			user.TemperatureUnit = unit
+		}
+	}
 	return m.Err
 }
+func (m *mockSmartHome) SetRoomOptions(room string, enabled bool, thresholdOn, thresholdOff float32, version int64) (int64, error) {
+	return version + 1, m.Err
+}
 func (m *mockSmartHome) GetRoomOptions(room string) (map[string]types.AttributeValue, error) {
 	opts := map[string]types.AttributeValue{}
 	if room == "bedroom" {
@@ -296,9 +346,204 @@ func (m *mockSmartHome) GetRoomOptions(room string) (map[string]types.AttributeV
 	}
 	return opts, m.Err
 }
-func (m *mockSmartHome) DeleteRoomOptions(room string) error {
+func (m *mockSmartHome) UpdateRoomOptions(room string, patch controller.RoomOptionsPatch, version int64) (map[string]types.AttributeValue, error) {
+	opts, err := m.GetRoomOptions(room)
+	if err == nil && opts == nil {
+		return nil, controller.NewNotFoundError("room %s not found", room)
+	}
+	return opts, err
+}
+func (m *mockSmartHome) DeleteRoomOptions(room string, version int64) error {
+	return m.Err
+}
+func (m *mockSmartHome) GetRoomsOptions(rooms []string) (map[string]map[string]types.AttributeValue, error) {
+	items := map[string]map[string]types.AttributeValue{}
+	for _, room := range rooms {
+		if opts, _ := m.GetRoomOptions(room); opts != nil {
+			items[room] = opts
+		}
+	}
+	return items, m.Err
+}
+func (m *mockSmartHome) ChangeRoomsOptions(changes []controller.RoomChange) error {
+	if m.Err == nil {
+		m.RoomChanges = append(m.RoomChanges, changes...)
+	}
 	return m.Err
 }
+func (m *mockSmartHome) CreateZone(name string, rooms []string) (*controller.Zone, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	if m.Zones[name] != nil {
+		return nil, controller.NewConflictError("zone %s already exists", name)
+	}
+	return &controller.Zone{Name: name, Rooms: rooms}, nil
+}
+func (m *mockSmartHome) GetZone(name string) (*controller.Zone, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	if m.Zones[name] == nil {
+		return nil, controller.NewNotFoundError("zone %s not found", name)
+	}
+	return m.Zones[name], nil
+}
+func (m *mockSmartHome) ListZones() ([]controller.Zone, error) {
+	zones := []controller.Zone{}
+	for _, zone := range m.Zones {
+		zones = append(zones, *zone)
+	}
+	return zones, m.Err
+}
+func (m *mockSmartHome) SetZoneRooms(name string, rooms []string) (*controller.Zone, error) {
+	if _, err := m.GetZone(name); err != nil {
+		return nil, err
+	}
+	return &controller.Zone{Name: name, Rooms: rooms}, nil
+}
+func (m *mockSmartHome) DeleteZone(name string) error {
+	_, err := m.GetZone(name)
+	return err
+}
 func (m *mockSmartHome) DeleteUser(username string) error {
 	return m.Err
 }
+func (m *mockSmartHome) AddReading(source controller.ReadingSource, reading controller.Reading) error {
+	if m.Err != nil {
+		return m.Err
+	}
+	m.AddedReadings = append(m.AddedReadings, reading)
+	return nil
+}
+func (m *mockSmartHome) GetReadings(source controller.ReadingSource, room string, from, to time.Time) (controller.Resolution, []controller.ReadingBucket, error) {
+	if m.Err != nil {
+		return "", nil, m.Err
+	}
+	resolution, err := controller.NewSmartHome().ReadingsResolution(from, to, time.Now())
+	return resolution, append([]controller.ReadingBucket{}, m.Readings...), err
+}
+func (m *mockSmartHome) RollupReadings(source controller.ReadingSource, now time.Time) (*controller.RollupResult, error) {
+	return &controller.RollupResult{}, m.Err
+}
+func (m *mockSmartHome) ExportReadings(source controller.ReadingSource, room string, resolution controller.Resolution, from, to time.Time, fn func(controller.ReadingBucket) error) error {
+	if m.Err != nil {
+		return m.Err
+	}
+	for _, bucket := range m.Readings {
+		if bucket.Room == room {
+			if err := fn(bucket); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+func (m *mockSmartHome) FetchWeather(provider utils.WeatherProvider, now time.Time) (*controller.WeatherResult, error) {
+	return &controller.WeatherResult{}, m.Err
+}
+func (m *mockSmartHome) RoomSettingsHistory(rooms []string, from, to time.Time) ([]controller.RoomSettingsChange, error) {
+	history := []controller.RoomSettingsChange{}
+	for _, change := range m.History {
+		if utils.Contains(rooms, change.Room) {
+			history = append(history, change)
+		}
+	}
+	return history, m.Err
+}
+func (m *mockSmartHome) RecordHeating(transition controller.HeatingTransition) error {
+	if m.Err != nil {
+		return m.Err
+	}
+	m.Heating = append(m.Heating, transition)
+	return nil
+}
+func (m *mockSmartHome) EnergyUsage(rooms []string, period controller.EnergyPeriod, from, to time.Time) (*controller.EnergyReport, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	return controller.DefaultEnergyConfig.Usage(m.Heating, rooms, period, from, to, time.Now())
+}
+func (m *mockSmartHome) EffectiveThresholds(rooms ...controller.RoomThresholds) []*controller.EffectiveThresholds {
+	m.EffectiveCalls++
+	thresholds := make([]*controller.EffectiveThresholds, len(rooms))
+	for i, r := range rooms {
+		if !r.Enabled {
+			frost := controller.DefaultFrostProtection
+			thresholds[i] = &controller.EffectiveThresholds{ThresholdOn: frost, ThresholdOff: frost, FrostProtection: true}
+			continue
+		}
+		if shift, ok := m.Compensation[r.Room]; ok {
+			thresholds[i] = &controller.EffectiveThresholds{ThresholdOn: r.ThresholdOn + shift, ThresholdOff: r.ThresholdOff + shift}
+		}
+	}
+	return thresholds
+}
+func (m *mockSmartHome) LoginAllowed(username, ip string) (time.Duration, error) {
+	return m.LoginWait, nil
+}
+func (m *mockSmartHome) RecordLoginFailure(username, ip string) error {
+	return nil
+}
+func (m *mockSmartHome) ResetLoginFailures(username string) error {
+	return m.Err
+}
+func (m *mockSmartHome) EnrollMFA(username string) (string, error) {
+	return "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", m.Err
+}
+func (m *mockSmartHome) ConfirmMFA(username, code string) ([]string, error) {
+	if code != m.MFACode {
+		return nil, controller.ErrInvalidMFACode
+	}
+	return []string{"abcd-efgh"}, m.Err
+}
+func (m *mockSmartHome) VerifyMFA(username, code string) error {
+	if code != m.MFACode {
+		return controller.ErrInvalidMFACode
+	}
+	return m.Err
+}
+func (m *mockSmartHome) DisableMFA(username string) error {
+	return m.Err
+}
+func (m *mockSmartHome) CreateAPIKey(owner, name string, scopes []string, expiresAt time.Time) (*controller.APIKey, string, error) {
+	return &controller.APIKey{ID: "1", Name: name, Owner: owner, Scopes: scopes, ExpiresAt: expiresAt}, "shk_1_secret", m.Err
+}
+func (m *mockSmartHome) ListAPIKeys(owner string) ([]controller.APIKey, error) {
+	keys := []controller.APIKey{}
+	for _, key := range m.APIKeys {
+		keys = append(keys, *key)
+	}
+	return keys, m.Err
+}
+func (m *mockSmartHome) DeleteAPIKey(owner, id string) error {
+	return m.Err
+}
+func (m *mockSmartHome) AuthenticateAPIKey(token string) (*controller.APIKey, error) {
+	key, ok := m.APIKeys[token]
+	if !ok {
+		return nil, controller.ErrInvalidAPIKey
+	}
+	return key, m.Err
+}
+func (m *mockSmartHome) SetPassword(username, newPassword string) error {
+	if len(newPassword) < controller.DefaultPasswordMinLength {
+		return controller.ErrWeakPassword
+	}
+	return m.Err
+}
+func (m *mockSmartHome) CreateInvitation(createdBy, role string, expiresAt time.Time) (*controller.Invitation, string, error) {
+	return &controller.Invitation{ID: "1", Role: role, CreatedBy: createdBy, ExpiresAt: expiresAt}, "shi_1_secret", m.Err
+}
+func (m *mockSmartHome) ListInvitations() ([]controller.Invitation, error) {
+	return []controller.Invitation{}, m.Err
+}
+func (m *mockSmartHome) DeleteInvitation(id string) error {
+	return m.Err
+}
+func (m *mockSmartHome) AcceptInvitation(code, username, password string) (*controller.User, error) {
+	if code != "shi_1_secret" {
+		return nil, controller.ErrInvalidInvitation
+	}
+	return &controller.User{Username: username, Role: controller.RoleUser}, m.Err
+}
@@ -0,0 +1,200 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/igvaquero18/smarthome/controller"
+	"github.com/igvaquero18/smarthome/utils"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// oidcStateCookie holds the state and nonce of an ongoing OpenID
+	// Connect login, signed with the keys used for the SmartHome tokens
+	// but typed so that it can't be used as an access token.
+	oidcStateCookie = "smarthome_oidc_state"
+	oidcStateTTL    = 10 * time.Minute
+)
+
+// OIDCConfig is the configuration for logging in through an
+// OpenID Connect identity provider.
+type OIDCConfig struct {
+	Provider *utils.OIDCProvider
+
+	// UsernameClaim is the claim of the ID token used as the local username.
+	// When it is "email", the email has to be verified by the provider.
+	UsernameClaim string
+
+	// DefaultRole is the role given to users logging in for the first time.
+	// If empty, only users already present in SmartHome can log in.
+	DefaultRole string
+
+	// PostLoginRedirect is where the user is redirected after logging in,
+	// with the token in the URL fragment. If empty, the token is returned as JSON.
+	PostLoginRedirect string
+}
+
+// OIDCLogin redirects the user to the identity provider for logging in
+func (cl *Client) OIDCLogin(c echo.Context) error {
+	if cl.OIDC == nil || cl.OIDC.Provider == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "OpenID Connect login is not configured")
+	}
+
+	state, err := utils.RandomString(32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	nonce, err := utils.RandomString(32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	expires := time.Now().Add(oidcStateTTL)
+	cookie, err := cl.keys().Sign(jwt.MapClaims{
+		utils.TypeClaim: utils.OIDCStateToken,
+		"state":         state,
+		"nonce":         nonce,
+		"exp":           expires.Unix(),
+	})
+	if err != nil {
+		return echo.NewHTTPError(
+			http.StatusInternalServerError,
+			fmt.Sprintf("Error signing login state: %s", err.Error()),
+		)
+	}
+
+	c.SetCookie(&http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    cookie,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   c.IsTLS(),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return c.Redirect(http.StatusFound, cl.OIDC.Provider.AuthCodeURL(state, nonce))
+}
+
+// OIDCCallback completes the login through the identity provider, mapping
+// the external user to a local one and returning a valid JWT token
+func (cl *Client) OIDCCallback(c echo.Context) error {
+	if cl.OIDC == nil || cl.OIDC.Provider == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "OpenID Connect login is not configured")
+	}
+
+	if e := c.QueryParam("error"); e != "" {
+		return echo.NewHTTPError(
+			http.StatusForbidden,
+			fmt.Sprintf("Login rejected by the identity provider: %s %s", e, c.QueryParam("error_description")),
+		)
+	}
+
+	nonce, err := cl.verifyOIDCState(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	c.SetCookie(&http.Cookie{
+		Name:     oidcStateCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+
+	claims, err := cl.OIDC.Provider.Exchange(c.Request().Context(), c.QueryParam("code"), nonce)
+	if err != nil {
+		return echo.NewHTTPError(
+			http.StatusForbidden,
+			fmt.Sprintf("Error logging in with the identity provider: %s", err.Error()),
+		)
+	}
+
+	username, err := cl.oidcUsername(claims)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	}
+
+	user, err := cl.GetUser(username)
+	if err != nil {
+		return echo.NewHTTPError(
+			http.StatusInternalServerError,
+			fmt.Sprintf("Error getting user %s: %s", username, err.Error()),
+		)
+	}
+	if user == nil {
+		if cl.OIDC.DefaultRole == "" {
+			return echo.NewHTTPError(
+				http.StatusForbidden,
+				fmt.Sprintf("User %s is not allowed to log in", username),
+			)
+		}
+		if err := cl.CreateExternalUser(username, cl.OIDC.DefaultRole); err != nil {
+			return echo.NewHTTPError(
+				http.StatusInternalServerError,
+				fmt.Sprintf("Error creating user %s: %s", username, err.Error()),
+			)
+		}
+		user = &controller.User{Username: username, Role: cl.OIDC.DefaultRole}
+	}
+
+	t, err := cl.issueToken(user)
+	if err != nil {
+		return echo.NewHTTPError(
+			http.StatusInternalServerError,
+			fmt.Sprintf("Error signing token: %s", err.Error()),
+		)
+	}
+
+	if cl.OIDC.PostLoginRedirect != "" {
+		return c.Redirect(
+			http.StatusFound,
+			cl.OIDC.PostLoginRedirect+"#"+url.Values{"token": {t}}.Encode(),
+		)
+	}
+	return c.JSON(http.StatusOK, map[string]string{
+		"token": t,
+	})
+}
+
+// verifyOIDCState checks that the state returned by the identity provider
+// matches the one stored in the cookie, and returns the expected nonce
+func (cl *Client) verifyOIDCState(c echo.Context) (string, error) {
+	cookie, err := c.Cookie(oidcStateCookie)
+	if err != nil {
+		return "", fmt.Errorf("No login in progress")
+	}
+	token, err := cl.keys().ParseType(cookie.Value, utils.OIDCStateToken, false)
+	if err != nil || !token.Valid {
+		return "", fmt.Errorf("Invalid or expired login state")
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return "", fmt.Errorf("Invalid login state")
+	}
+	state, _ := claims["state"].(string)
+	if state == "" || state != c.QueryParam("state") {
+		return "", fmt.Errorf("The login state doesn't match")
+	}
+	nonce, _ := claims["nonce"].(string)
+	return nonce, nil
+}
+
+func (cl *Client) oidcUsername(claims jwt.MapClaims) (string, error) {
+	claim := cl.OIDC.UsernameClaim
+	if claim == "" {
+		claim = "email"
+	}
+	username, _ := claims[claim].(string)
+	if username == "" {
+		return "", fmt.Errorf("The ID token has no %s claim", claim)
+	}
+	if claim == "email" {
+		if verified, _ := claims["email_verified"].(bool); !verified {
+			return "", fmt.Errorf("The email %s is not verified by the identity provider", username)
+		}
+	}
+	return username, nil
+}
@@ -0,0 +1,166 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/igvaquero18/smarthome/controller"
+	"github.com/igvaquero18/smarthome/utils"
+	"github.com/igvaquero18/smarthome/utils/oidctest"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOIDCLogin(t *testing.T) {
+	idp := oidctest.NewServer("smarthome", "client-secret")
+	defer idp.Close()
+
+	testCases := []struct {
+		name              string
+		claims            jwt.MapClaims
+		users             map[string]*controller.User
+		defaultRole       string
+		postLoginRedirect string
+		expectedCode      int
+		expectedRole      string
+	}{
+		{
+			name:         "Existing user",
+			claims:       jwt.MapClaims{"email": "admin@example.com", "email_verified": true},
+			users:        map[string]*controller.User{"admin@example.com": {Username: "admin@example.com", Role: controller.RoleAdmin}},
+			expectedCode: http.StatusOK,
+			expectedRole: controller.RoleAdmin,
+		},
+		{
+			name:         "Unknown user without default role",
+			claims:       jwt.MapClaims{"email": "guest@example.com", "email_verified": true},
+			users:        map[string]*controller.User{},
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "Unknown user with default role",
+			claims:       jwt.MapClaims{"email": "guest@example.com", "email_verified": true},
+			users:        map[string]*controller.User{},
+			defaultRole:  controller.RoleUser,
+			expectedCode: http.StatusOK,
+			expectedRole: controller.RoleUser,
+		},
+		{
+			name:         "Unverified email",
+			claims:       jwt.MapClaims{"email": "admin@example.com", "email_verified": false},
+			users:        map[string]*controller.User{"admin@example.com": {Username: "admin@example.com", Role: controller.RoleAdmin}},
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:              "Redirect after login",
+			claims:            jwt.MapClaims{"email": "admin@example.com", "email_verified": true},
+			users:             map[string]*controller.User{"admin@example.com": {Username: "admin@example.com", Role: controller.RoleAdmin}},
+			postLoginRedirect: "https://smarthome.local/app",
+			expectedCode:      http.StatusFound,
+			expectedRole:      controller.RoleAdmin,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			idp.Claims = tc.claims
+			e := echo.New()
+			srv := httptest.NewServer(e)
+			defer srv.Close()
+
+			provider, err := utils.NewOIDCProvider(context.Background(), idp.URL, "smarthome", "client-secret", srv.URL+"/callback")
+			if !assert.NoError(tt, err) {
+				return
+			}
+			cl := NewClient(JWTConfig{JWTSecret: "secret", JWTExpiration: time.Minute}, &mockSmartHome{Users: tc.users})
+			cl.OIDC = &OIDCConfig{
+				Provider:          provider,
+				DefaultRole:       tc.defaultRole,
+				PostLoginRedirect: tc.postLoginRedirect,
+			}
+			e.GET("/login", cl.OIDCLogin)
+			e.GET("/callback", cl.OIDCCallback)
+
+			jar, _ := cookiejar.New(nil)
+			client := &http.Client{
+				Jar: jar,
+				CheckRedirect: func(req *http.Request, via []*http.Request) error {
+					if req.URL.Host != "smarthome.local" {
+						return nil
+					}
+					return http.ErrUseLastResponse
+				},
+			}
+			resp, err := client.Get(srv.URL + "/login")
+			if !assert.NoError(tt, err) {
+				return
+			}
+			defer resp.Body.Close()
+			assert.Equal(tt, tc.expectedCode, resp.StatusCode)
+			if tc.expectedRole == "" {
+				return
+			}
+
+			var token string
+			if tc.postLoginRedirect != "" {
+				location, _ := url.Parse(resp.Header.Get("Location"))
+				fragment, _ := url.ParseQuery(location.Fragment)
+				token = fragment.Get("token")
+			} else {
+				body := map[string]string{}
+				json.NewDecoder(resp.Body).Decode(&body)
+				token = body["token"]
+			}
+			parsed, err := utils.NewHMACKeySet("secret").ParseAccessToken(token)
+			if !assert.NoError(tt, err) {
+				return
+			}
+			claims := parsed.Claims.(jwt.MapClaims)
+			assert.Equal(tt, tc.claims["email"], claims["sub"])
+			assert.Equal(tt, tc.expectedRole, claims["role"])
+		})
+	}
+}
+
+func TestOIDCCallbackInvalidState(t *testing.T) {
+	cl := NewClient(JWTConfig{JWTSecret: "secret", JWTExpiration: time.Minute}, &mockSmartHome{})
+	cl.OIDC = &OIDCConfig{Provider: &utils.OIDCProvider{}}
+	e := echo.New()
+	e.GET("/callback", cl.OIDCCallback)
+
+	forged, _ := utils.NewHMACKeySet("other").Sign(jwt.MapClaims{utils.TypeClaim: utils.OIDCStateToken, "state": "state", "nonce": "nonce"})
+	access, _ := utils.NewHMACKeySet("secret").Sign(jwt.MapClaims{utils.TypeClaim: utils.AccessToken, "sub": "admin", "state": "state", "nonce": "nonce"})
+	testCases := []struct {
+		name   string
+		cookie *http.Cookie
+	}{
+		{
+			name: "No cookie",
+		},
+		{
+			name:   "Cookie signed with other key",
+			cookie: &http.Cookie{Name: oidcStateCookie, Value: forged},
+		},
+		{
+			name:   "Access token as cookie",
+			cookie: &http.Cookie{Name: oidcStateCookie, Value: access},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/callback?code=code&state=state", nil)
+			if tc.cookie != nil {
+				req.AddCookie(tc.cookie)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			assert.Equal(tt, http.StatusBadRequest, rec.Code)
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/igvaquero18/smarthome/controller"
+	"github.com/igvaquero18/smarthome/utils"
+	"github.com/labstack/echo/v4"
+)
+
+var openAPISpec = struct {
+	once sync.Once
+	spec *utils.OpenAPI
+}{}
+
+// OpenAPISpec returns the parsed OpenAPI specification of the SmartHome API
+func OpenAPISpec() *utils.OpenAPI {
+	openAPISpec.once.Do(func() {
+		spec, err := utils.ParseOpenAPI([]byte(openAPIDocument))
+		if err != nil {
+			panic(fmt.Sprintf("invalid OpenAPI specification: %s", err.Error()))
+		}
+		openAPISpec.spec = spec
+	})
+	return openAPISpec.spec
+}
+
+// OpenAPI returns the OpenAPI specification of the SmartHome API
+func (cl *Client) OpenAPI(c echo.Context) error {
+	return c.JSONBlob(http.StatusOK, []byte(openAPIDocument))
+}
+
+// ValidateOpenAPI returns an echo middleware that validates the requests and
+// responses of the routes documented in the OpenAPI specification. In strict
+// mode, invalid requests are rejected with a 400 error and invalid responses
+// are replaced by a 500 error, so that tests catch any drift between the
+// handlers and the specification. Otherwise, mismatches are only logged, and
+// responses are streamed without keeping a copy of any body but JSON ones.
+func ValidateOpenAPI(spec *utils.OpenAPI, strict bool, logger controller.Logger) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			path := openAPIPath(c.Path())
+			op, ok := spec.Operation(c.Request().Method, path)
+			if !ok {
+				return next(c)
+			}
+
+			if err := validateOpenAPIRequest(spec, op, c); err != nil {
+				logger.Errorw("request doesn't match the OpenAPI specification",
+					"method", c.Request().Method, "path", path, "error", err.Error())
+				if strict {
+					return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid request: %s", err.Error()))
+				}
+			}
+
+			res := c.Response()
+			writer := res.Writer
+			recorder := &responseRecorder{ResponseWriter: writer, buffer: strict}
+			res.Writer = recorder
+			err := next(c)
+			res.Writer = writer
+
+			// Errors are written by the HTTP error handler once the middlewares
+			// return, so their response is validated as HTTPErrorHandler writes it
+			status, contentType, body := res.Status, res.Header().Get(echo.HeaderContentType), recorder.body.Bytes()
+			if err != nil && !res.Committed {
+				var response ErrorResponse
+				status, response = NewErrorResponse(err, requestID(c))
+				body, _ = json.Marshal(response)
+				contentType = echo.MIMEApplicationJSON
+			}
+
+			if validationErr := spec.ValidateResponse(op, status, contentType, body); validationErr != nil {
+				logger.Errorw("response doesn't match the OpenAPI specification",
+					"method", c.Request().Method, "path", path, "status", status, "error", validationErr.Error())
+				if strict {
+					res.Committed = false
+					res.Size = 0
+					return echo.NewHTTPError(
+						http.StatusInternalServerError,
+						fmt.Sprintf("Response doesn't match the OpenAPI specification: %s", validationErr.Error()),
+					)
+				}
+			}
+			if strict && res.Committed {
+				writer.WriteHeader(res.Status)
+				if _, writeErr := writer.Write(body); writeErr != nil {
+					return writeErr
+				}
+			}
+			return err
+		}
+	}
+}
+
+func validateOpenAPIRequest(spec *utils.OpenAPI, op *utils.Operation, c echo.Context) error {
+	params := map[string]string{}
+	values := c.ParamValues()
+	for i, name := range c.ParamNames() {
+		if i < len(values) {
+			params[name] = values[i]
+		}
+	}
+	if err := spec.ValidateParameters(op, params, c.QueryParams()); err != nil {
+		return err
+	}
+
+	req := c.Request()
+	if op.RequestBody == nil || req.Body == nil {
+		return nil
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return fmt.Errorf("error reading the request body: %w", err)
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return spec.ValidateRequestBody(op, req.Header.Get(echo.HeaderContentType), body)
+}
+
+// openAPIPath converts an echo route path, such as /v1/room/:room, to the
+// OpenAPI syntax, such as /v1/room/{room}
+func openAPIPath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") {
+			segments[i] = "{" + strings.TrimPrefix(segment, ":") + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// responseRecorder keeps a copy of the response body to validate it. If
+// buffer is true, the response is not written until the middleware decides
+// to. Otherwise only JSON bodies are copied, since bodies of other types
+// aren't validated, so that streamed responses such as exports aren't kept in
+// memory.
+type responseRecorder struct {
+	http.ResponseWriter
+	body   bytes.Buffer
+	buffer bool
+}
+
+func (r *responseRecorder) WriteHeader(code int) {
+	if !r.buffer {
+		r.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.buffer {
+		return r.body.Write(b)
+	}
+	if isJSON(r.Header().Get(echo.HeaderContentType)) {
+		r.body.Write(b)
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Flush sends the response written so far, unless it's buffered
+func (r *responseRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok && !r.buffer {
+		flusher.Flush()
+	}
+}
+
+// isJSON returns whether a content type is JSON, such as application/json or
+// application/problem+json, but not a stream of JSON lines
+func isJSON(contentType string) bool {
+	mediaType := strings.TrimSpace(strings.Split(contentType, ";")[0])
+	return mediaType == echo.MIMEApplicationJSON || strings.HasSuffix(mediaType, "+json")
+}
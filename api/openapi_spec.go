@@ -0,0 +1,1062 @@
+package api
+
+// openAPIDocument is the OpenAPI 3 specification of the SmartHome API. It is
+// maintained by hand and must be updated along with the handlers: the tests
+// validate the requests and responses of the handlers against it.
+const openAPIDocument = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "SmartHome API",
+    "description": "API for controlling the heating of the rooms of a home.",
+    "version": "1.0.0"
+  },
+  "servers": [{"url": "/"}],
+  "components": {
+    "securitySchemes": {
+      "bearerAuth": {"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+      "apiKeyAuth": {"type": "apiKey", "in": "header", "name": "X-API-Key"}
+    },
+    "parameters": {
+      "Unit": {"name": "unit", "in": "query", "description": "Unit of the thresholds of the request and the response. Defaults to the preference of the user, or celsius.", "schema": {"type": "string", "enum": ["celsius", "fahrenheit", "c", "f", "C", "F"]}}
+    },
+    "responses": {
+      "Error": {
+        "description": "Error",
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}
+      }
+    },
+    "schemas": {
+      "Error": {
+        "type": "object",
+        "required": ["code", "message"],
+        "properties": {
+          "code": {"type": "string", "description": "Machine-readable code of the error, such as not_found or too_many_requests"},
+          "message": {"type": "string"},
+          "details": {"type": "object"},
+          "request_id": {"type": "string"}
+        }
+      },
+      "Message": {
+        "type": "object",
+        "required": ["message"],
+        "properties": {
+          "message": {"type": "string"},
+          "user": {"type": "string"}
+        }
+      },
+      "Auth": {
+        "type": "object",
+        "required": ["username", "password"],
+        "properties": {
+          "username": {"type": "string", "minLength": 1},
+          "password": {"type": "string"},
+          "otp": {"type": "string", "description": "TOTP or recovery code of users with two-factor authentication"}
+        }
+      },
+      "Username": {
+        "type": "object",
+        "required": ["username"],
+        "properties": {
+          "username": {"type": "string", "minLength": 1}
+        }
+      },
+      "Token": {
+        "type": "object",
+        "required": ["token"],
+        "properties": {
+          "token": {"type": "string"}
+        }
+      },
+      "PasswordChange": {
+        "type": "object",
+        "required": ["old_password", "new_password"],
+        "properties": {
+          "old_password": {"type": "string"},
+          "new_password": {"type": "string"}
+        }
+      },
+      "MFACode": {
+        "type": "object",
+        "required": ["code"],
+        "properties": {
+          "code": {"type": "string", "minLength": 1}
+        }
+      },
+      "MFAEnrollment": {
+        "type": "object",
+        "required": ["secret", "uri"],
+        "properties": {
+          "secret": {"type": "string"},
+          "uri": {"type": "string"}
+        }
+      },
+      "RecoveryCodes": {
+        "type": "object",
+        "required": ["message", "recovery_codes"],
+        "properties": {
+          "message": {"type": "string"},
+          "recovery_codes": {"type": "array", "items": {"type": "string"}}
+        }
+      },
+      "APIKeyRequest": {
+        "type": "object",
+        "required": ["name", "scopes"],
+        "properties": {
+          "name": {"type": "string", "minLength": 1},
+          "scopes": {
+            "type": "array",
+            "items": {"type": "string", "enum": ["rooms:read", "rooms:write", "readings:read", "readings:write"]}
+          },
+          "expires_in": {"type": "string", "description": "Duration such as 720h. The key never expires if empty."}
+        }
+      },
+      "APIKey": {
+        "type": "object",
+        "required": ["id", "name", "owner", "scopes", "created_at"],
+        "properties": {
+          "id": {"type": "string"},
+          "name": {"type": "string"},
+          "owner": {"type": "string"},
+          "scopes": {"type": "array", "items": {"type": "string"}},
+          "created_at": {"type": "string", "format": "date-time"},
+          "expires_at": {"type": "string", "format": "date-time"},
+          "last_used_at": {"type": "string", "format": "date-time"}
+        }
+      },
+      "CreatedAPIKey": {
+        "type": "object",
+        "required": ["key", "api_key"],
+        "properties": {
+          "key": {"type": "string", "description": "The API key, which is only returned once"},
+          "api_key": {"$ref": "#/components/schemas/APIKey"}
+        }
+      },
+      "InvitationRequest": {
+        "type": "object",
+        "properties": {
+          "role": {"type": "string", "enum": ["admin", "user"]},
+          "expires_in": {"type": "string", "description": "Duration such as 24h. Defaults to 72h."}
+        }
+      },
+      "Invitation": {
+        "type": "object",
+        "required": ["id", "role", "created_by", "created_at", "expires_at"],
+        "properties": {
+          "id": {"type": "string"},
+          "role": {"type": "string", "enum": ["admin", "user"]},
+          "created_by": {"type": "string"},
+          "created_at": {"type": "string", "format": "date-time"},
+          "expires_at": {"type": "string", "format": "date-time"}
+        }
+      },
+      "CreatedInvitation": {
+        "type": "object",
+        "required": ["code", "invitation"],
+        "properties": {
+          "code": {"type": "string", "description": "The invitation code, which is only returned once. It is accepted with POST /v1/invitations/{code}/accept"},
+          "invitation": {"$ref": "#/components/schemas/Invitation"}
+        }
+      },
+      "AcceptedInvitation": {
+        "type": "object",
+        "required": ["message", "username", "role"],
+        "properties": {
+          "message": {"type": "string"},
+          "username": {"type": "string"},
+          "role": {"type": "string", "enum": ["admin", "user"]}
+        }
+      },
+      "RoomOptions": {
+        "type": "object",
+        "required": ["enabled", "threshold_on", "threshold_off"],
+        "properties": {
+          "name": {"type": "string"},
+          "enabled": {"type": "boolean"},
+          "threshold_on": {"type": "number"},
+          "threshold_off": {"type": "number"},
+          "version": {"type": "integer", "description": "Version of the options, also returned as their ETag. It's ignored in requests."},
+          "unit": {"type": "string", "enum": ["celsius", "fahrenheit"], "description": "Unit of the thresholds. It's ignored in requests."},
+          "effective": {"$ref": "#/components/schemas/EffectiveThresholds"}
+        }
+      },
+      "EffectiveThresholds": {
+        "type": "object",
+        "description": "Thresholds the room is heated with once shifted by its weather compensation and raised to its frost protection, only for rooms heated with other thresholds than theirs. It's ignored in requests.",
+        "required": ["threshold_on", "threshold_off"],
+        "properties": {
+          "threshold_on": {"type": "number"},
+          "threshold_off": {"type": "number"},
+          "outside_temperature": {"type": "number", "description": "Average outside temperature the thresholds are shifted for, missing if there are no recent outside readings"},
+          "frost_protection": {"type": "boolean", "description": "Whether the thresholds are raised to the frost protection, in which case the room is heated with them even if it's disabled"}
+        }
+      },
+      "ReadingBucket": {
+        "type": "object",
+        "required": ["start", "min", "max", "avg", "count"],
+        "properties": {
+          "room": {"type": "string"},
+          "start": {"type": "string", "format": "date-time"},
+          "min": {"type": "number"},
+          "max": {"type": "number"},
+          "avg": {"type": "number"},
+          "count": {"type": "integer"}
+        }
+      },
+      "Readings": {
+        "type": "object",
+        "required": ["source", "from", "to", "resolution", "unit", "buckets"],
+        "properties": {
+          "source": {"type": "string", "enum": ["inside", "outside"]},
+          "room": {"type": "string"},
+          "from": {"type": "string", "format": "date-time"},
+          "to": {"type": "string", "format": "date-time"},
+          "resolution": {"type": "string", "enum": ["5m", "1h", "1d"], "description": "Length of the buckets, chosen for the range: 5-minute buckets up to two days, hourly up to two months and daily otherwise, or coarser if the finer buckets of the range have expired"},
+          "unit": {"type": "string", "enum": ["celsius", "fahrenheit"]},
+          "buckets": {"type": "array", "items": {"$ref": "#/components/schemas/ReadingBucket"}}
+        }
+      },
+      "ReadingRequest": {
+        "type": "object",
+        "required": ["temperature"],
+        "properties": {
+          "source": {"type": "string", "enum": ["inside", "outside"], "default": "inside"},
+          "room": {"type": "string", "description": "Room of the reading, required for inside readings and not allowed for outside ones"},
+          "temperature": {"type": "number", "description": "Temperature in the unit of the request"},
+          "time": {"type": "string", "format": "date-time", "description": "Time of the reading. Defaults to that of the request."}
+        }
+      },
+      "Reading": {
+        "type": "object",
+        "required": ["source", "time", "temperature", "unit"],
+        "properties": {
+          "source": {"type": "string", "enum": ["inside", "outside"]},
+          "room": {"type": "string"},
+          "time": {"type": "string", "format": "date-time"},
+          "temperature": {"type": "number"},
+          "unit": {"type": "string", "enum": ["celsius", "fahrenheit"]}
+        }
+      },
+      "HeatingTransition": {
+        "type": "object",
+        "required": ["room", "heating", "time"],
+        "properties": {
+          "room": {"type": "string"},
+          "heating": {"type": "boolean", "description": "Whether the heater was switched on"},
+          "time": {"type": "string", "format": "date-time"}
+        }
+      },
+      "HeatingRequest": {
+        "type": "object",
+        "required": ["room", "heating"],
+        "properties": {
+          "room": {"type": "string"},
+          "heating": {"type": "boolean", "description": "Whether the heater was switched on"},
+          "time": {"type": "string", "format": "date-time", "description": "Time of the transition. Defaults to that of the request."}
+        }
+      },
+      "EnergyPeriodUsage": {
+        "type": "object",
+        "required": ["start", "runtime_seconds", "kwh", "cost"],
+        "properties": {
+          "start": {"type": "string", "format": "date-time"},
+          "runtime_seconds": {"type": "integer"},
+          "kwh": {"type": "number"},
+          "cost": {"type": "number"}
+        }
+      },
+      "RoomEnergy": {
+        "type": "object",
+        "required": ["room", "wattage", "runtime_seconds", "kwh", "cost", "periods"],
+        "properties": {
+          "room": {"type": "string"},
+          "wattage": {"type": "number", "description": "Power of the heater of the room, in watts"},
+          "runtime_seconds": {"type": "integer"},
+          "kwh": {"type": "number"},
+          "cost": {"type": "number"},
+          "periods": {"type": "array", "items": {"$ref": "#/components/schemas/EnergyPeriodUsage"}}
+        }
+      },
+      "EnergyReport": {
+        "type": "object",
+        "required": ["from", "to", "period", "timezone", "runtime_seconds", "kwh", "cost", "rooms"],
+        "properties": {
+          "from": {"type": "string", "format": "date-time"},
+          "to": {"type": "string", "format": "date-time"},
+          "period": {"type": "string", "enum": ["day", "week"]},
+          "timezone": {"type": "string", "description": "Time zone of the tariff, where periods start at midnight"},
+          "currency": {"type": "string"},
+          "runtime_seconds": {"type": "integer"},
+          "kwh": {"type": "number"},
+          "cost": {"type": "number"},
+          "rooms": {"type": "array", "items": {"$ref": "#/components/schemas/RoomEnergy"}}
+        }
+      },
+      "Preferences": {
+        "type": "object",
+        "required": ["temperature_unit"],
+        "properties": {
+          "temperature_unit": {"type": "string", "enum": ["celsius", "fahrenheit", "c", "f", "C", "F"]}
+        }
+      },
+      "SetRoomOptionsResponse": {
+        "type": "object",
+        "required": ["message", "status_code", "options"],
+        "properties": {
+          "message": {"type": "string"},
+          "status_code": {"type": "integer"},
+          "options": {"$ref": "#/components/schemas/RoomOptions"}
+        }
+      },
+      "RoomOptionsPatch": {
+        "type": "object",
+        "additionalProperties": false,
+        "properties": {
+          "name": {"type": "string"},
+          "enabled": {"type": "boolean"},
+          "threshold_on": {"type": "number"},
+          "threshold_off": {"type": "number"}
+        }
+      },
+      "PatchRoomOptionsResponse": {
+        "type": "object",
+        "required": ["message", "status_code", "options"],
+        "properties": {
+          "message": {"type": "string"},
+          "status_code": {"type": "integer"},
+          "options": {"oneOf": [
+            {"$ref": "#/components/schemas/RoomOptions"},
+            {"type": "array", "items": {"$ref": "#/components/schemas/RoomOptions"}}
+          ]}
+        }
+      },
+      "Zone": {
+        "type": "object",
+        "required": ["name", "rooms"],
+        "properties": {
+          "name": {"type": "string"},
+          "rooms": {"type": "array", "items": {"type": "string"}}
+        }
+      },
+      "ZoneRequest": {
+        "type": "object",
+        "required": ["rooms"],
+        "properties": {
+          "name": {"type": "string"},
+          "rooms": {"type": "array", "minItems": 1, "maxItems": 100, "items": {"type": "string"}}
+        }
+      },
+      "DeleteZoneResponse": {
+        "type": "object",
+        "required": ["message", "status_code", "zone"],
+        "properties": {
+          "message": {"type": "string"},
+          "status_code": {"type": "integer"},
+          "zone": {"type": "string"}
+        }
+      },
+      "RoomChanges": {
+        "type": "object",
+        "additionalProperties": false,
+        "required": ["changes"],
+        "properties": {
+          "changes": {"type": "array", "minItems": 1, "maxItems": 100, "items": {
+            "type": "object",
+            "additionalProperties": false,
+            "required": ["room", "action"],
+            "properties": {
+              "room": {"type": "string"},
+              "action": {"type": "string", "enum": ["set", "update", "delete"]},
+              "options": {"$ref": "#/components/schemas/RoomOptionsPatch"},
+              "version": {"type": "integer", "minimum": 0}
+            }
+          }}
+        }
+      },
+      "BatchRoomOptionsResponse": {
+        "type": "object",
+        "required": ["message", "status_code", "options"],
+        "properties": {
+          "message": {"type": "string"},
+          "status_code": {"type": "integer"},
+          "options": {"type": "array", "items": {"$ref": "#/components/schemas/RoomOptions"}}
+        }
+      },
+      "DeleteRoomOptionsResponse": {
+        "type": "object",
+        "required": ["message", "status_code", "room"],
+        "properties": {
+          "message": {"type": "string"},
+          "status_code": {"type": "integer"},
+          "room": {"type": "string"}
+        }
+      },
+      "JWKS": {
+        "type": "object",
+        "required": ["keys"],
+        "properties": {
+          "keys": {
+            "type": "array",
+            "items": {
+              "type": "object",
+              "required": ["kty", "kid"],
+              "properties": {
+                "kty": {"type": "string"},
+                "kid": {"type": "string"}
+              }
+            }
+          }
+        }
+      }
+    }
+  },
+  "paths": {
+    "/v1/openapi.json": {
+      "get": {
+        "operationId": "getOpenAPI",
+        "summary": "This specification",
+        "responses": {
+          "200": {"description": "OpenAPI document", "content": {"application/json": {"schema": {"type": "object"}}}}
+        }
+      }
+    },
+    "/.well-known/jwks.json": {
+      "get": {
+        "operationId": "getJWKS",
+        "summary": "Public keys used for signing the tokens",
+        "responses": {
+          "200": {"description": "JSON Web Key Set", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/JWKS"}}}}
+        }
+      }
+    },
+    "/v1/login": {
+      "post": {
+        "operationId": "login",
+        "summary": "Log in with a username and password",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Auth"}}}},
+        "responses": {
+          "200": {"description": "Token", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Token"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "429": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/signup": {
+      "post": {
+        "operationId": "signUp",
+        "summary": "Create an admin user. Only admins can create users",
+        "security": [{"bearerAuth": []}],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Auth"}}}},
+        "responses": {
+          "200": {"description": "User created", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Message"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/user": {
+      "delete": {
+        "operationId": "deleteUser",
+        "summary": "Delete a user. Only admins can delete users",
+        "security": [{"bearerAuth": []}],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Username"}}}},
+        "responses": {
+          "200": {"description": "User deleted", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Message"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/user/password": {
+      "put": {
+        "operationId": "changePassword",
+        "summary": "Change the password of the logged in user",
+        "security": [{"bearerAuth": []}],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/PasswordChange"}}}},
+        "responses": {
+          "200": {"description": "Password changed", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Message"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "429": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/user/preferences": {
+      "get": {
+        "operationId": "getPreferences",
+        "summary": "Get the preferences of the logged in user",
+        "security": [{"bearerAuth": []}],
+        "responses": {
+          "200": {"description": "Preferences", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Preferences"}}}},
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "put": {
+        "operationId": "setPreferences",
+        "summary": "Change the preferences of the logged in user, such as the unit of the thresholds",
+        "security": [{"bearerAuth": []}],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Preferences"}}}},
+        "responses": {
+          "200": {"description": "Preferences changed", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Preferences"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/user/unlock": {
+      "post": {
+        "operationId": "unlockUser",
+        "summary": "Forget the failed login attempts of a user",
+        "security": [{"bearerAuth": []}],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Username"}}}},
+        "responses": {
+          "200": {"description": "User unlocked", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Message"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/user/mfa": {
+      "post": {
+        "operationId": "enrollMFA",
+        "summary": "Start enabling two-factor authentication",
+        "security": [{"bearerAuth": []}],
+        "responses": {
+          "200": {"description": "TOTP secret", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/MFAEnrollment"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "delete": {
+        "operationId": "disableMFA",
+        "summary": "Disable two-factor authentication",
+        "security": [{"bearerAuth": []}],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/MFACode"}}}},
+        "responses": {
+          "200": {"description": "Two-factor authentication disabled", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Message"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "429": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/user/mfa/confirm": {
+      "post": {
+        "operationId": "confirmMFA",
+        "summary": "Enable two-factor authentication with a valid code",
+        "security": [{"bearerAuth": []}],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/MFACode"}}}},
+        "responses": {
+          "200": {"description": "Recovery codes", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/RecoveryCodes"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/apikeys": {
+      "post": {
+        "operationId": "createAPIKey",
+        "summary": "Create an API key for the logged in user",
+        "security": [{"bearerAuth": []}],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/APIKeyRequest"}}}},
+        "responses": {
+          "201": {"description": "API key created", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/CreatedAPIKey"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "get": {
+        "operationId": "listAPIKeys",
+        "summary": "List the API keys of the logged in user",
+        "security": [{"bearerAuth": []}],
+        "responses": {
+          "200": {"description": "API keys", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/APIKey"}}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/apikeys/{id}": {
+      "delete": {
+        "operationId": "deleteAPIKey",
+        "summary": "Revoke an API key of the logged in user",
+        "security": [{"bearerAuth": []}],
+        "parameters": [{"name": "id", "in": "path", "required": true, "schema": {"type": "string"}}],
+        "responses": {
+          "200": {"description": "API key revoked", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Message"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/invitations": {
+      "post": {
+        "operationId": "createInvitation",
+        "summary": "Invite a new user",
+        "security": [{"bearerAuth": []}],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/InvitationRequest"}}}},
+        "responses": {
+          "201": {"description": "Invitation created", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/CreatedInvitation"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "get": {
+        "operationId": "listInvitations",
+        "summary": "List the pending invitations",
+        "security": [{"bearerAuth": []}],
+        "responses": {
+          "200": {"description": "Invitations", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Invitation"}}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/invitations/{id}": {
+      "delete": {
+        "operationId": "deleteInvitation",
+        "summary": "Revoke an invitation",
+        "security": [{"bearerAuth": []}],
+        "parameters": [{"name": "id", "in": "path", "required": true, "schema": {"type": "string"}}],
+        "responses": {
+          "200": {"description": "Invitation revoked", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Message"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/invitations/{code}/accept": {
+      "post": {
+        "operationId": "acceptInvitation",
+        "summary": "Create a user with an invitation",
+        "parameters": [{"name": "code", "in": "path", "required": true, "schema": {"type": "string"}}],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Auth"}}}},
+        "responses": {
+          "200": {"description": "User created", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/AcceptedInvitation"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/auth/oidc/login": {
+      "get": {
+        "operationId": "oidcLogin",
+        "summary": "Log in through the OpenID Connect provider",
+        "responses": {
+          "302": {"description": "Redirection to the identity provider"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/auth/oidc/callback": {
+      "get": {
+        "operationId": "oidcCallback",
+        "summary": "Callback of the OpenID Connect provider",
+        "parameters": [
+          {"name": "code", "in": "query", "schema": {"type": "string"}},
+          {"name": "state", "in": "query", "schema": {"type": "string"}}
+        ],
+        "responses": {
+          "200": {"description": "Token", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Token"}}}},
+          "302": {"description": "Redirection to the application, with the token in the URL fragment"},
+          "400": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/room/{room}": {
+      "get": {
+        "operationId": "getRoomOptions",
+        "summary": "Get the options of a room, or of every room with the room all",
+        "security": [{"bearerAuth": []}, {"apiKeyAuth": []}],
+        "parameters": [
+          {"name": "room", "in": "path", "required": true, "schema": {"type": "string"}},
+          {"$ref": "#/components/parameters/Unit"}
+        ],
+        "responses": {
+          "200": {
+            "description": "Room options",
+            "headers": {"ETag": {"description": "Version of the options of the room", "schema": {"type": "string"}}},
+            "content": {"application/json": {"schema": {"oneOf": [
+              {"$ref": "#/components/schemas/RoomOptions"},
+              {"type": "array", "items": {"$ref": "#/components/schemas/RoomOptions"}}
+            ]}}}
+          },
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "post": {
+        "operationId": "setRoomOptions",
+        "summary": "Set the options of a room, or of every room with the room all",
+        "security": [{"bearerAuth": []}, {"apiKeyAuth": []}],
+        "parameters": [
+          {"name": "room", "in": "path", "required": true, "schema": {"type": "string"}},
+          {"name": "If-Match", "in": "header", "description": "ETag the options must have for the write to happen, or * for any", "schema": {"type": "string"}},
+          {"$ref": "#/components/parameters/Unit"}
+        ],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/RoomOptions"}}}},
+        "responses": {
+          "200": {"description": "Room options set", "headers": {"ETag": {"description": "Version of the options of the room", "schema": {"type": "string"}}}, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/SetRoomOptionsResponse"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"},
+          "412": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "patch": {
+        "operationId": "patchRoomOptions",
+        "summary": "Update some of the options of a room, or of every room with options with the room all, with a JSON merge patch",
+        "security": [{"bearerAuth": []}, {"apiKeyAuth": []}],
+        "parameters": [
+          {"name": "room", "in": "path", "required": true, "schema": {"type": "string"}},
+          {"name": "If-Match", "in": "header", "description": "ETag the options must have for the write to happen, or * for any", "schema": {"type": "string"}},
+          {"$ref": "#/components/parameters/Unit"}
+        ],
+        "requestBody": {"required": true, "content": {
+          "application/merge-patch+json": {"schema": {"$ref": "#/components/schemas/RoomOptionsPatch"}},
+          "application/json": {"schema": {"$ref": "#/components/schemas/RoomOptionsPatch"}}
+        }},
+        "responses": {
+          "200": {"description": "Room options updated", "headers": {"ETag": {"description": "Version of the options of the room", "schema": {"type": "string"}}}, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/PatchRoomOptionsResponse"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"},
+          "412": {"$ref": "#/components/responses/Error"},
+          "415": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "delete": {
+        "operationId": "deleteRoomOptions",
+        "summary": "Delete the options of a room, or of every room with the room all",
+        "security": [{"bearerAuth": []}, {"apiKeyAuth": []}],
+        "parameters": [
+          {"name": "room", "in": "path", "required": true, "schema": {"type": "string"}},
+          {"name": "If-Match", "in": "header", "description": "ETag the options must have for the write to happen, or * for any", "schema": {"type": "string"}}
+        ],
+        "responses": {
+          "200": {"description": "Room options deleted", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/DeleteRoomOptionsResponse"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"},
+          "412": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/zones": {
+      "get": {
+        "operationId": "listZones",
+        "summary": "List the zones",
+        "security": [{"bearerAuth": []}, {"apiKeyAuth": []}],
+        "responses": {
+          "200": {"description": "Zones", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Zone"}}}}},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "post": {
+        "operationId": "createZone",
+        "summary": "Create a zone with some of the rooms",
+        "security": [{"bearerAuth": []}, {"apiKeyAuth": []}],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/ZoneRequest"}}}},
+        "responses": {
+          "201": {"description": "Zone created", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Zone"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/zones/{zone}": {
+      "get": {
+        "operationId": "getZone",
+        "summary": "Get a zone with its rooms",
+        "security": [{"bearerAuth": []}, {"apiKeyAuth": []}],
+        "parameters": [{"name": "zone", "in": "path", "required": true, "schema": {"type": "string"}}],
+        "responses": {
+          "200": {"description": "Zone", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Zone"}}}},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "put": {
+        "operationId": "updateZone",
+        "summary": "Replace the rooms of a zone",
+        "security": [{"bearerAuth": []}, {"apiKeyAuth": []}],
+        "parameters": [{"name": "zone", "in": "path", "required": true, "schema": {"type": "string"}}],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/ZoneRequest"}}}},
+        "responses": {
+          "200": {"description": "Zone updated", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Zone"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "delete": {
+        "operationId": "deleteZone",
+        "summary": "Delete a zone, keeping the options of its rooms",
+        "security": [{"bearerAuth": []}, {"apiKeyAuth": []}],
+        "parameters": [{"name": "zone", "in": "path", "required": true, "schema": {"type": "string"}}],
+        "responses": {
+          "200": {"description": "Zone deleted", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/DeleteZoneResponse"}}}},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/zones/{zone}/options": {
+      "get": {
+        "operationId": "getZoneOptions",
+        "summary": "Get the options of the rooms of a zone",
+        "security": [{"bearerAuth": []}, {"apiKeyAuth": []}],
+        "parameters": [
+          {"name": "zone", "in": "path", "required": true, "schema": {"type": "string"}},
+          {"$ref": "#/components/parameters/Unit"}
+        ],
+        "responses": {
+          "200": {"description": "Options of the rooms of the zone", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/RoomOptions"}}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "post": {
+        "operationId": "setZoneOptions",
+        "summary": "Set the options of every room of a zone at once",
+        "security": [{"bearerAuth": []}, {"apiKeyAuth": []}],
+        "parameters": [
+          {"name": "zone", "in": "path", "required": true, "schema": {"type": "string"}},
+          {"$ref": "#/components/parameters/Unit"}
+        ],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/RoomOptions"}}}},
+        "responses": {
+          "200": {"description": "Room options set", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/SetRoomOptionsResponse"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "patch": {
+        "operationId": "patchZoneOptions",
+        "summary": "Update some of the options of the rooms of a zone with options at once, with a JSON merge patch",
+        "security": [{"bearerAuth": []}, {"apiKeyAuth": []}],
+        "parameters": [
+          {"name": "zone", "in": "path", "required": true, "schema": {"type": "string"}},
+          {"$ref": "#/components/parameters/Unit"}
+        ],
+        "requestBody": {"required": true, "content": {
+          "application/merge-patch+json": {"schema": {"$ref": "#/components/schemas/RoomOptionsPatch"}},
+          "application/json": {"schema": {"$ref": "#/components/schemas/RoomOptionsPatch"}}
+        }},
+        "responses": {
+          "200": {"description": "Room options updated", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/PatchRoomOptionsResponse"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"},
+          "415": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "delete": {
+        "operationId": "deleteZoneOptions",
+        "summary": "Delete the options of every room of a zone at once",
+        "security": [{"bearerAuth": []}, {"apiKeyAuth": []}],
+        "parameters": [{"name": "zone", "in": "path", "required": true, "schema": {"type": "string"}}],
+        "responses": {
+          "200": {"description": "Room options deleted", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/DeleteZoneResponse"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/rooms:batch": {
+      "post": {
+        "operationId": "batchRoomOptions",
+        "summary": "Set, update or delete the options of several rooms at once, applying either every change or none",
+        "security": [{"bearerAuth": []}, {"apiKeyAuth": []}],
+        "parameters": [{"$ref": "#/components/parameters/Unit"}],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/RoomChanges"}}}},
+        "responses": {
+          "200": {"description": "Room options changed", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/BatchRoomOptionsResponse"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"},
+          "412": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/readings": {
+      "get": {
+        "operationId": "getReadings",
+        "summary": "Get the minimum, maximum and average temperatures of a room, or outside, in buckets whose length depends on the range",
+        "security": [{"bearerAuth": []}, {"apiKeyAuth": []}],
+        "parameters": [
+          {"name": "source", "in": "query", "schema": {"type": "string", "enum": ["inside", "outside"], "default": "inside"}},
+          {"name": "room", "in": "query", "description": "Room of the readings, required for inside readings", "schema": {"type": "string"}},
+          {"name": "from", "in": "query", "description": "Start of the range. Defaults to one day before its end.", "schema": {"type": "string", "format": "date-time"}},
+          {"name": "to", "in": "query", "description": "End of the range. Defaults to now.", "schema": {"type": "string", "format": "date-time"}},
+          {"$ref": "#/components/parameters/Unit"}
+        ],
+        "responses": {
+          "200": {"description": "Readings of the range", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Readings"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "post": {
+        "operationId": "addReading",
+        "summary": "Store a temperature reading of a room, or outside, to be rolled up into the buckets of readings",
+        "description": "A reading that has already been rolled up isn't stored again.",
+        "security": [{"bearerAuth": []}, {"apiKeyAuth": []}],
+        "parameters": [{"$ref": "#/components/parameters/Unit"}],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/ReadingRequest"}}}},
+        "responses": {
+          "201": {"description": "Reading stored", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Reading"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/export": {
+      "get": {
+        "operationId": "export",
+        "summary": "Export the readings of the rooms and outside, and the history of the settings of the rooms, as a file",
+        "description": "Readings are exported room by room and then outside, followed by the changes of the settings, one record per row. Records have a kind, reading or setting, and the columns that don't apply to their kind are empty.",
+        "security": [{"bearerAuth": []}, {"apiKeyAuth": []}],
+        "parameters": [
+          {"name": "from", "in": "query", "description": "Start of the range. Defaults to 30 days before its end.", "schema": {"type": "string", "format": "date-time"}},
+          {"name": "to", "in": "query", "description": "End of the range. Defaults to now.", "schema": {"type": "string", "format": "date-time"}},
+          {"name": "format", "in": "query", "schema": {"type": "string", "enum": ["csv", "ndjson", "parquet"], "default": "csv"}},
+          {"name": "resolution", "in": "query", "description": "Length of the buckets of readings", "schema": {"type": "string", "enum": ["5m", "1h", "1d"], "default": "1h"}},
+          {"name": "room", "in": "query", "description": "Room to export, which can be repeated. Defaults to every room.", "schema": {"type": "string"}},
+          {"$ref": "#/components/parameters/Unit"}
+        ],
+        "responses": {
+          "200": {
+            "description": "Exported data",
+            "content": {
+              "text/csv": {"schema": {"type": "string"}},
+              "application/x-ndjson": {},
+              "application/vnd.apache.parquet": {"schema": {"type": "string", "format": "binary"}}
+            }
+          },
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/heating": {
+      "post": {
+        "operationId": "recordHeating",
+        "summary": "Record that the heater of a room was switched on or off",
+        "security": [{"bearerAuth": []}, {"apiKeyAuth": []}],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/HeatingRequest"}}}},
+        "responses": {
+          "201": {"description": "Transition recorded", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/HeatingTransition"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/energy": {
+      "get": {
+        "operationId": "getEnergy",
+        "summary": "Estimate how long the heaters ran, and the energy they used and its cost, by day or by week",
+        "description": "The energy is estimated from the heating transitions recorded and the wattage of the heater of every room, and priced with the tariff, whose time-of-use bands apply in its time zone. Heaters still on are counted as running until now.",
+        "security": [{"bearerAuth": []}, {"apiKeyAuth": []}],
+        "parameters": [
+          {"name": "from", "in": "query", "description": "Start of the range. Defaults to 7 days before its end.", "schema": {"type": "string", "format": "date-time"}},
+          {"name": "to", "in": "query", "description": "End of the range, up to 366 days after its start. Defaults to now.", "schema": {"type": "string", "format": "date-time"}},
+          {"name": "period", "in": "query", "schema": {"type": "string", "enum": ["day", "week"], "default": "day"}},
+          {"name": "room", "in": "query", "description": "Room to estimate, which can be repeated. Defaults to every room.", "schema": {"type": "string"}}
+        ],
+        "responses": {
+          "200": {"description": "Energy usage of the range", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/EnergyReport"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    }
+  }
+}`
@@ -0,0 +1,518 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/igvaquero18/smarthome/controller"
+	"github.com/igvaquero18/smarthome/utils"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingLogger struct {
+	controller.DefaultLogger
+	errors []string
+}
+
+func (l *recordingLogger) Errorw(msg string, keysAndValues ...interface{}) {
+	l.errors = append(l.errors, msg)
+}
+
+// newOpenAPITestServer returns a router with the same routes as the serve
+// command, validating every request and response against the specification
+func newOpenAPITestServer(strict bool, logger controller.Logger) (*echo.Echo, string) {
+	keys := utils.NewHMACKeySet("secret")
+	token, _ := keys.Sign(jwt.MapClaims{utils.TypeClaim: utils.AccessToken, "sub": "admin", "role": controller.RoleAdmin})
+	s := NewClient(JWTConfig{JWTSecret: "secret"}, &mockSmartHome{
+		BedroomOpts: map[string]types.AttributeValue{
+			"ThresholdOn":  &types.AttributeValueMemberN{Value: "19.3"},
+			"ThresholdOff": &types.AttributeValueMemberN{Value: "19.5"},
+			"Enabled":      &types.AttributeValueMemberBOOL{Value: true},
+		},
+		Zones:        map[string]*controller.Zone{"upstairs": {Name: "upstairs", Rooms: []string{"bedroom"}}},
+		Compensation: map[string]float32{"bedroom": 0.5},
+	})
+
+	e := echo.New()
+	e.HTTPErrorHandler = HTTPErrorHandler
+	e.Use(ValidateOpenAPI(OpenAPISpec(), strict, logger))
+	e.GET("/v1/openapi.json", s.OpenAPI)
+	e.GET("/.well-known/jwks.json", s.JWKS)
+	e.POST("/v1/login", s.Login)
+	e.POST("/v1/signup", s.SignUp, JWT(keys, nil), RequireRole(controller.RoleAdmin))
+	e.PUT("/v1/user/password", s.ChangePassword, JWT(keys, nil))
+	e.GET("/v1/user/preferences", s.GetPreferences, JWT(keys, nil))
+	e.PUT("/v1/user/preferences", s.SetPreferences, JWT(keys, nil))
+	e.POST("/v1/apikeys", s.CreateAPIKey, JWT(keys, nil))
+	e.GET("/v1/apikeys", s.ListAPIKeys, JWT(keys, nil))
+	e.POST("/v1/invitations", s.CreateInvitation, JWT(keys, nil), RequireRole(controller.RoleAdmin))
+	e.POST("/v1/invitations/:code/accept", s.AcceptInvitation)
+	e.POST("/v1/room/:room", s.SetRoomOptions, JWT(keys, nil))
+	e.PATCH("/v1/room/:room", s.PatchRoomOptions, JWT(keys, nil))
+	e.GET("/v1/room/:room", s.GetRoomOptions, JWT(keys, nil))
+	e.DELETE("/v1/room/:room", s.DeleteRoomOptions, JWT(keys, nil))
+	e.POST("/v1/rooms:batch", s.BatchRoomOptions, JWT(keys, nil))
+	e.POST("/v1/zones", s.CreateZone, JWT(keys, nil))
+	e.GET("/v1/zones", s.ListZones, JWT(keys, nil))
+	e.GET("/v1/zones/:zone", s.GetZone, JWT(keys, nil))
+	e.PUT("/v1/zones/:zone", s.UpdateZone, JWT(keys, nil))
+	e.DELETE("/v1/zones/:zone", s.DeleteZone, JWT(keys, nil))
+	e.GET("/v1/zones/:zone/options", s.GetZoneOptions, JWT(keys, nil))
+	e.POST("/v1/zones/:zone/options", s.SetZoneOptions, JWT(keys, nil))
+	e.PATCH("/v1/zones/:zone/options", s.PatchZoneOptions, JWT(keys, nil))
+	e.DELETE("/v1/zones/:zone/options", s.DeleteZoneOptions, JWT(keys, nil))
+	e.GET("/v1/readings", s.GetReadings, JWT(keys, nil))
+	e.POST("/v1/readings", s.AddReading, JWT(keys, nil))
+	e.GET("/v1/export", s.Export, JWT(keys, nil))
+	e.POST("/v1/heating", s.RecordHeating, JWT(keys, nil))
+	e.GET("/v1/energy", s.GetEnergy, JWT(keys, nil))
+	return e, token
+}
+
+func TestOpenAPISpec(t *testing.T) {
+	spec := OpenAPISpec()
+	for path, operations := range spec.Paths {
+		for method, op := range operations {
+			assert.NotEmpty(t, op.OperationID, "%s %s has no operationId", method, path)
+			assert.NotEmpty(t, op.Responses, "%s %s has no responses", method, path)
+		}
+	}
+
+	e, _ := newOpenAPITestServer(true, &recordingLogger{})
+	for _, route := range e.Routes() {
+		_, ok := spec.Operation(route.Method, openAPIPath(route.Path))
+		assert.True(t, ok, "%s %s is not documented", route.Method, route.Path)
+	}
+}
+
+func TestValidateOpenAPI(t *testing.T) {
+	testCases := []struct {
+		name         string
+		method       string
+		path         string
+		body         string
+		auth         bool
+		expectedCode int
+	}{
+		{
+			name:         "Specification",
+			method:       http.MethodGet,
+			path:         "/v1/openapi.json",
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "JWKS",
+			method:       http.MethodGet,
+			path:         "/.well-known/jwks.json",
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Login",
+			method:       http.MethodPost,
+			path:         "/v1/login",
+			body:         `{"username": "admin", "password": "admin"}`,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Login without password",
+			method:       http.MethodPost,
+			path:         "/v1/login",
+			body:         `{"username": "admin"}`,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Sign up",
+			method:       http.MethodPost,
+			path:         "/v1/signup",
+			body:         `{"username": "admin", "password": "correct horse battery"}`,
+			auth:         true,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Weak new password",
+			method:       http.MethodPut,
+			path:         "/v1/user/password",
+			body:         `{"old_password": "admin", "new_password": "short"}`,
+			auth:         true,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Create API key",
+			method:       http.MethodPost,
+			path:         "/v1/apikeys",
+			body:         `{"name": "thermometer", "scopes": ["readings:write"]}`,
+			auth:         true,
+			expectedCode: http.StatusCreated,
+		},
+		{
+			name:         "Create API key with an unknown scope",
+			method:       http.MethodPost,
+			path:         "/v1/apikeys",
+			body:         `{"name": "thermometer", "scopes": ["everything"]}`,
+			auth:         true,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "List API keys without a token",
+			method:       http.MethodGet,
+			path:         "/v1/apikeys",
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "Create invitation",
+			method:       http.MethodPost,
+			path:         "/v1/invitations",
+			body:         `{"role": "user"}`,
+			auth:         true,
+			expectedCode: http.StatusCreated,
+		},
+		{
+			name:         "Accept invitation",
+			method:       http.MethodPost,
+			path:         "/v1/invitations/shi_1_secret/accept",
+			body:         `{"username": "bob", "password": "correct horse battery"}`,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Get room options",
+			method:       http.MethodGet,
+			path:         "/v1/room/bedroom",
+			auth:         true,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Get room options in Fahrenheit",
+			method:       http.MethodGet,
+			path:         "/v1/room/bedroom?unit=fahrenheit",
+			auth:         true,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Get room options in an unknown unit",
+			method:       http.MethodGet,
+			path:         "/v1/room/bedroom?unit=kelvin",
+			auth:         true,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Get preferences",
+			method:       http.MethodGet,
+			path:         "/v1/user/preferences",
+			auth:         true,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Set preferences",
+			method:       http.MethodPut,
+			path:         "/v1/user/preferences",
+			body:         `{"temperature_unit": "fahrenheit"}`,
+			auth:         true,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Get options of every room",
+			method:       http.MethodGet,
+			path:         "/v1/room/all",
+			auth:         true,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Get options of an unknown room",
+			method:       http.MethodGet,
+			path:         "/v1/room/kitchen",
+			auth:         true,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Set room options",
+			method:       http.MethodPost,
+			path:         "/v1/room/bedroom",
+			body:         `{"enabled": true, "threshold_on": 19.5, "threshold_off": 20}`,
+			auth:         true,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Set room options with a wrong type",
+			method:       http.MethodPost,
+			path:         "/v1/room/bedroom",
+			body:         `{"enabled": "yes", "threshold_on": 19.5, "threshold_off": 20}`,
+			auth:         true,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Patch room options",
+			method:       http.MethodPatch,
+			path:         "/v1/room/bedroom",
+			body:         `{"enabled": false}`,
+			auth:         true,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Patch options of every room",
+			method:       http.MethodPatch,
+			path:         "/v1/room/all",
+			body:         `{"threshold_on": 19}`,
+			auth:         true,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Patch room options with an unknown field",
+			method:       http.MethodPatch,
+			path:         "/v1/room/bedroom",
+			body:         `{"temperature": 20}`,
+			auth:         true,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Delete room options",
+			method:       http.MethodDelete,
+			path:         "/v1/room/bedroom",
+			auth:         true,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Create a zone",
+			method:       http.MethodPost,
+			path:         "/v1/zones",
+			body:         `{"name": "downstairs", "rooms": ["livingroom"]}`,
+			auth:         true,
+			expectedCode: http.StatusCreated,
+		},
+		{
+			name:         "List zones",
+			method:       http.MethodGet,
+			path:         "/v1/zones",
+			auth:         true,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Get a zone not found",
+			method:       http.MethodGet,
+			path:         "/v1/zones/downstairs",
+			auth:         true,
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "Get the options of a zone",
+			method:       http.MethodGet,
+			path:         "/v1/zones/upstairs/options",
+			auth:         true,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Set the options of a zone",
+			method:       http.MethodPost,
+			path:         "/v1/zones/upstairs/options",
+			body:         `{"enabled": true, "threshold_on": 19.5, "threshold_off": 20}`,
+			auth:         true,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Patch the options of a zone",
+			method:       http.MethodPatch,
+			path:         "/v1/zones/upstairs/options",
+			body:         `{"enabled": false}`,
+			auth:         true,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Delete the options of a zone",
+			method:       http.MethodDelete,
+			path:         "/v1/zones/upstairs/options",
+			auth:         true,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Get readings",
+			method:       http.MethodGet,
+			path:         "/v1/readings?room=bedroom&from=2021-01-01T00:00:00Z&to=2021-01-02T00:00:00Z",
+			auth:         true,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Get readings of an unknown source",
+			method:       http.MethodGet,
+			path:         "/v1/readings?source=attic",
+			auth:         true,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Export as CSV",
+			method:       http.MethodGet,
+			path:         "/v1/export?from=2021-01-01T00:00:00Z&to=2021-01-02T00:00:00Z&format=csv&room=bedroom",
+			auth:         true,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Export as NDJSON",
+			method:       http.MethodGet,
+			path:         "/v1/export?format=ndjson&resolution=1d",
+			auth:         true,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Export in an unknown format",
+			method:       http.MethodGet,
+			path:         "/v1/export?format=xlsx",
+			auth:         true,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Add a reading",
+			method:       http.MethodPost,
+			path:         "/v1/readings",
+			body:         `{"room": "bedroom", "temperature": 20.5, "time": "2021-01-01T06:00:00Z"}`,
+			auth:         true,
+			expectedCode: http.StatusCreated,
+		},
+		{
+			name:         "Add a reading without its temperature",
+			method:       http.MethodPost,
+			path:         "/v1/readings",
+			body:         `{"room": "bedroom"}`,
+			auth:         true,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Record a heating transition",
+			method:       http.MethodPost,
+			path:         "/v1/heating",
+			body:         `{"room": "bedroom", "heating": true, "time": "2021-01-01T06:00:00Z"}`,
+			auth:         true,
+			expectedCode: http.StatusCreated,
+		},
+		{
+			name:         "Record a heating transition without its state",
+			method:       http.MethodPost,
+			path:         "/v1/heating",
+			body:         `{"room": "bedroom"}`,
+			auth:         true,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Get energy usage",
+			method:       http.MethodGet,
+			path:         "/v1/energy?from=2021-01-01T00:00:00Z&to=2021-01-08T00:00:00Z&period=week&room=bedroom",
+			auth:         true,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Batch of room changes",
+			method:       http.MethodPost,
+			path:         "/v1/rooms:batch",
+			body:         `{"changes": [{"room": "bedroom", "action": "update", "options": {"enabled": false}, "version": 1}, {"room": "livingroom", "action": "delete"}]}`,
+			auth:         true,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Batch of room changes with an invalid action",
+			method:       http.MethodPost,
+			path:         "/v1/rooms:batch",
+			body:         `{"changes": [{"room": "bedroom", "action": "rename"}]}`,
+			auth:         true,
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			logger := &recordingLogger{}
+			e, token := newOpenAPITestServer(true, logger)
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			if tc.auth {
+				req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			assert.Equal(tt, tc.expectedCode, rec.Code, rec.Body.String())
+			assert.NotContains(tt, rec.Body.String(), "OpenAPI specification")
+		})
+	}
+}
+
+func TestValidateOpenAPIResponse(t *testing.T) {
+	handler := func(c echo.Context) error {
+		return c.JSON(http.StatusOK, map[string]string{"jwt": "token"})
+	}
+
+	testCases := []struct {
+		name         string
+		strict       bool
+		expectedCode int
+	}{
+		{
+			name:         "Strict mode",
+			strict:       true,
+			expectedCode: http.StatusInternalServerError,
+		},
+		{
+			name:         "Logging mode",
+			strict:       false,
+			expectedCode: http.StatusOK,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			logger := &recordingLogger{}
+			e := echo.New()
+			e.HTTPErrorHandler = HTTPErrorHandler
+			e.Use(ValidateOpenAPI(OpenAPISpec(), tc.strict, logger))
+			e.POST("/v1/login", handler)
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/login", strings.NewReader(`{"username": "admin", "password": "admin"}`))
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			assert.Equal(tt, tc.expectedCode, rec.Code)
+			assert.Equal(tt, []string{"response doesn't match the OpenAPI specification"}, logger.errors)
+		})
+	}
+}
+
+func TestResponseRecorder(t *testing.T) {
+	testCases := []struct {
+		name            string
+		buffer          bool
+		contentType     string
+		expectedBody    string
+		expectedWritten string
+	}{
+		{
+			name:            "Buffered",
+			buffer:          true,
+			contentType:     "text/csv",
+			expectedBody:    "payload",
+			expectedWritten: "",
+		},
+		{
+			name:            "JSON",
+			contentType:     echo.MIMEApplicationJSONCharsetUTF8,
+			expectedBody:    "payload",
+			expectedWritten: "payload",
+		},
+		{
+			name:            "Streamed export",
+			contentType:     "application/x-ndjson",
+			expectedBody:    "",
+			expectedWritten: "payload",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			rec := httptest.NewRecorder()
+			recorder := &responseRecorder{ResponseWriter: rec, buffer: tc.buffer}
+			recorder.Header().Set(echo.HeaderContentType, tc.contentType)
+			n, err := recorder.Write([]byte("payload"))
+			assert.NoError(tt, err)
+			assert.Equal(tt, len("payload"), n)
+			recorder.Flush()
+			assert.Equal(tt, tc.expectedBody, recorder.body.String())
+			assert.Equal(tt, tc.expectedWritten, rec.Body.String())
+			assert.Equal(tt, !tc.buffer, rec.Flushed)
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/igvaquero18/smarthome/controller"
+	"github.com/labstack/echo/v4"
+)
+
+// DefaultReadingsRange is the range of the readings returned when the from
+// query parameter isn't set
+const DefaultReadingsRange = 24 * time.Hour
+
+// Readings are the buckets of temperature readings of a room in a range, at
+// the resolution chosen for the range
+type Readings struct {
+	Source     controller.ReadingSource   `json:"source"`
+	Room       string                     `json:"room,omitempty"`
+	From       time.Time                  `json:"from"`
+	To         time.Time                  `json:"to"`
+	Resolution controller.Resolution      `json:"resolution"`
+	Unit       controller.TemperatureUnit `json:"unit"`
+	Buckets    []controller.ReadingBucket `json:"buckets"`
+}
+
+// ReadingRequest is the payload of a temperature reading of a room, or of the
+// outside temperature, in the unit of the request. The source defaults to
+// inside, and the time to that of the request.
+type ReadingRequest struct {
+	Source      controller.ReadingSource `json:"source,omitempty"`
+	Room        string                   `json:"room,omitempty"`
+	Temperature *float32                 `json:"temperature"`
+	Time        *time.Time               `json:"time,omitempty"`
+}
+
+// Reading is a temperature reading stored
+type Reading struct {
+	Source      controller.ReadingSource   `json:"source"`
+	Room        string                     `json:"room,omitempty"`
+	Time        time.Time                  `json:"time"`
+	Temperature float32                    `json:"temperature"`
+	Unit        controller.TemperatureUnit `json:"unit"`
+}
+
+// AddReading stores a temperature reading sent by a thermometer, to be
+// rolled up into the buckets of readings
+func (cl *Client) AddReading(c echo.Context) error {
+	params := new(ReadingRequest)
+	if err := json.NewDecoder(c.Request().Body).Decode(&params); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid payload: %s", err.Error()))
+	}
+	if params.Source == "" {
+		params.Source = controller.SourceInside
+	}
+	switch params.Source {
+	case controller.SourceInside:
+		if params.Room == AllRooms || !ValidRoom(params.Room).IsValid() {
+			return NewInvalidRoomError(params.Room)
+		}
+	case controller.SourceOutside:
+		if params.Room != "" {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid payload: outside readings have no room")
+		}
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid payload: invalid source %s", params.Source))
+	}
+	if params.Temperature == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid payload: temperature is required")
+	}
+	unit, err := cl.temperatureUnit(c)
+	if err != nil {
+		return err
+	}
+
+	reading := Reading{Source: params.Source, Room: params.Room, Time: time.Now().UTC(), Temperature: *params.Temperature, Unit: unit}
+	if params.Time != nil {
+		reading.Time = params.Time.UTC()
+	}
+	err = cl.SmartHomeInterface.AddReading(params.Source, controller.Reading{
+		Room:        reading.Room,
+		Time:        reading.Time,
+		Temperature: unit.ToCelsius(reading.Temperature),
+	})
+	if err != nil {
+		return NewHTTPError(err, "Error storing reading")
+	}
+	return c.JSON(http.StatusCreated, reading)
+}
+
+// GetReadings returns the readings of a room, or of the outside temperature,
+// between the from and to query parameters, which default to the last day.
+// The longer the range, the coarser the buckets of readings returned.
+func (cl *Client) GetReadings(c echo.Context) error {
+	source := controller.ReadingSource(c.QueryParam("source"))
+	if source == "" {
+		source = controller.SourceInside
+	}
+	room := c.QueryParam("room")
+	if source == controller.SourceInside && (room == AllRooms || !ValidRoom(room).IsValid()) {
+		return NewInvalidRoomError(room)
+	}
+
+	to, err := parseTimeParam(c, "to", time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	from, err := parseTimeParam(c, "from", to.Add(-DefaultReadingsRange))
+	if err != nil {
+		return err
+	}
+	unit, err := cl.temperatureUnit(c)
+	if err != nil {
+		return err
+	}
+
+	resolution, buckets, err := cl.SmartHomeInterface.GetReadings(source, room, from, to)
+	if err != nil {
+		return NewHTTPError(err, "Error getting readings")
+	}
+	for i := range buckets {
+		buckets[i].Min = unit.FromCelsius(buckets[i].Min)
+		buckets[i].Max = unit.FromCelsius(buckets[i].Max)
+		buckets[i].Avg = unit.FromCelsius(buckets[i].Avg)
+	}
+	return c.JSON(http.StatusOK, Readings{
+		Source:     source,
+		Room:       room,
+		From:       from,
+		To:         to,
+		Resolution: resolution,
+		Unit:       unit,
+		Buckets:    buckets,
+	})
+}
+
+// parseTimeParam returns the RFC 3339 time of a query parameter, or def if
+// it isn't set
+func parseTimeParam(c echo.Context, name string, def time.Time) (time.Time, error) {
+	value := c.QueryParam(name)
+	if value == "" {
+		return def, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid %s time %s: it must be in RFC 3339 format", name, value))
+	}
+	return t.UTC(), nil
+}
@@ -0,0 +1,180 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/igvaquero18/smarthome/controller"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddReading(t *testing.T) {
+	at := time.Date(2021, 1, 1, 6, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		name         string
+		body         string
+		query        url.Values
+		smartHome    *mockSmartHome
+		expectedCode int
+		expected     *Reading
+		stored       controller.Reading
+	}{
+		{
+			name:         "Reading of a room",
+			body:         `{"room": "bedroom", "temperature": 20.5, "time": "2021-01-01T07:00:00+01:00"}`,
+			smartHome:    &mockSmartHome{},
+			expectedCode: http.StatusCreated,
+			expected:     &Reading{Source: controller.SourceInside, Room: "bedroom", Time: at, Temperature: 20.5, Unit: controller.Celsius},
+			stored:       controller.Reading{Room: "bedroom", Time: at, Temperature: 20.5},
+		},
+		{
+			name:         "Outside reading in Fahrenheit",
+			body:         `{"source": "outside", "temperature": 41, "time": "2021-01-01T06:00:00Z"}`,
+			query:        url.Values{"unit": {"F"}},
+			smartHome:    &mockSmartHome{},
+			expectedCode: http.StatusCreated,
+			expected:     &Reading{Source: controller.SourceOutside, Time: at, Temperature: 41, Unit: controller.Fahrenheit},
+			stored:       controller.Reading{Time: at, Temperature: 5},
+		},
+		{
+			name:         "Missing temperature",
+			body:         `{"room": "bedroom"}`,
+			smartHome:    &mockSmartHome{},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Inside reading without room",
+			body:         `{"temperature": 20}`,
+			smartHome:    &mockSmartHome{},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Outside reading of a room",
+			body:         `{"source": "outside", "room": "bedroom", "temperature": 5}`,
+			smartHome:    &mockSmartHome{},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Unknown source",
+			body:         `{"source": "attic", "temperature": 5}`,
+			smartHome:    &mockSmartHome{},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Invalid payload",
+			body:         `{"room": "bedroom", "temperature": "warm"}`,
+			smartHome:    &mockSmartHome{},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Error storing",
+			body:         `{"room": "bedroom", "temperature": 20}`,
+			smartHome:    &mockSmartHome{Err: errors.New("unexpected error")},
+			expectedCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			ctx := &baseMockContext{Body: tc.body, Query: tc.query}
+			err := NewClient(JWTConfig{}, tc.smartHome).AddReading(ctx)
+			if tc.expectedCode != http.StatusCreated {
+				assert.Error(tt, err)
+				assert.Equal(tt, tc.expectedCode, StatusCode(err))
+				return
+			}
+			assert.NoError(tt, err)
+			assert.Equal(tt, *tc.expected, ctx.GetJSONPayload())
+			assert.Equal(tt, []controller.Reading{tc.stored}, tc.smartHome.AddedReadings)
+		})
+	}
+}
+
+func TestGetReadings(t *testing.T) {
+	from := time.Now().UTC().Truncate(time.Hour).Add(-time.Hour)
+	to := from.Add(time.Hour)
+	readings := []controller.ReadingBucket{
+		{Room: "bedroom", Start: from, Min: 19, Max: 21, Avg: 20, Count: 12},
+	}
+	testCases := []struct {
+		name         string
+		query        url.Values
+		expectedCode int
+		expected     Readings
+	}{
+		{
+			name:         "Readings of a room",
+			query:        url.Values{"room": {"bedroom"}, "from": {from.Format(time.RFC3339)}, "to": {to.Format(time.RFC3339)}},
+			expectedCode: http.StatusOK,
+			expected: Readings{
+				Source:     controller.SourceInside,
+				Room:       "bedroom",
+				From:       from,
+				To:         to,
+				Resolution: controller.ResolutionFiveMinutes,
+				Unit:       controller.Celsius,
+				Buckets:    readings,
+			},
+		},
+		{
+			name:         "Readings of a week in Fahrenheit",
+			query:        url.Values{"room": {"bedroom"}, "from": {from.AddDate(0, 0, -7).Format(time.RFC3339)}, "to": {to.Format(time.RFC3339)}, "unit": {"F"}},
+			expectedCode: http.StatusOK,
+			expected: Readings{
+				Source:     controller.SourceInside,
+				Room:       "bedroom",
+				From:       from.AddDate(0, 0, -7),
+				To:         to,
+				Resolution: controller.ResolutionHourly,
+				Unit:       controller.Fahrenheit,
+				Buckets:    []controller.ReadingBucket{{Room: "bedroom", Start: from, Min: 66.2, Max: 69.8, Avg: 68, Count: 12}},
+			},
+		},
+		{
+			name:         "Outside readings without room",
+			query:        url.Values{"source": {"outside"}, "from": {from.Format(time.RFC3339)}, "to": {to.Format(time.RFC3339)}},
+			expectedCode: http.StatusOK,
+			expected: Readings{
+				Source:     controller.SourceOutside,
+				From:       from,
+				To:         to,
+				Resolution: controller.ResolutionFiveMinutes,
+				Unit:       controller.Celsius,
+				Buckets:    readings,
+			},
+		},
+		{
+			name:         "Inside readings without room",
+			query:        url.Values{},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Invalid time",
+			query:        url.Values{"room": {"bedroom"}, "from": {"yesterday"}},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Inverted range",
+			query:        url.Values{"room": {"bedroom"}, "from": {to.Format(time.RFC3339)}, "to": {from.Format(time.RFC3339)}},
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			ctx := &baseMockContext{Query: tc.query}
+			err := NewClient(JWTConfig{}, &mockSmartHome{Readings: readings}).GetReadings(ctx)
+			if tc.expectedCode != http.StatusOK {
+				httpErr, ok := err.(*echo.HTTPError)
+				if assert.True(tt, ok) {
+					assert.Equal(tt, tc.expectedCode, httpErr.Code)
+				}
+				return
+			}
+			assert.NoError(tt, err)
+			assert.Equal(tt, tc.expected, ctx.GetJSONPayload())
+		})
+	}
+}
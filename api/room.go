@@ -1,18 +1,72 @@
 package api
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/igvaquero18/smarthome/controller"
 	"github.com/igvaquero18/smarthome/utils"
 	"github.com/labstack/echo/v4"
 )
 
 const roomParam = "room"
 
-var ValidRooms = []string{"all", "bedroom", "livingroom"}
+// MIMEApplicationMergePatchJSON is the content type of JSON merge patches
+const MIMEApplicationMergePatchJSON = "application/merge-patch+json"
+
+const (
+	// HeaderETag is the header carrying the version of the options of a room
+	HeaderETag = "ETag"
+
+	// HeaderIfMatch is the header making a write of the options of a room
+	// conditional on their version
+	HeaderIfMatch = "If-Match"
+)
+
+// AllRooms is the name of the special room that refers to every other room
+const AllRooms = "all"
+
+// ValidRooms is the default list of rooms accepted by the API
+var ValidRooms = []string{AllRooms, "bedroom", "livingroom"}
+
+var registry = struct {
+	sync.RWMutex
+	rooms []string
+}{rooms: ValidRooms}
+
+// SetValidRooms replaces the list of rooms accepted by the API. The special
+// room "all" is always kept, so it doesn't need to be included in rooms.
+func SetValidRooms(rooms []string) {
+	r := []string{AllRooms}
+	for _, room := range rooms {
+		if room != AllRooms && !utils.Contains(r, room) {
+			r = append(r, room)
+		}
+	}
+	registry.Lock()
+	defer registry.Unlock()
+	registry.rooms = r
+}
+
+// GetValidRooms returns the list of rooms currently accepted by the API,
+// including the special room "all".
+func GetValidRooms() []string {
+	registry.RLock()
+	defer registry.RUnlock()
+	return append([]string{}, registry.rooms...)
+}
+
+// EveryRoom returns the rooms the special room "all" refers to
+func EveryRoom() []string {
+	return utils.AllButOne(GetValidRooms(), AllRooms)
+}
 
 // ValidRoom is an alias to string that allow us to check whether a particular room
 // name is valid
@@ -20,12 +74,19 @@ type ValidRoom string
 
 // IsValid checks whether the name of the room is valid
 func (r ValidRoom) IsValid() bool {
-	for _, room := range ValidRooms {
-		if string(r) == room {
-			return true
-		}
+	return utils.Contains(GetValidRooms(), string(r))
+}
+
+// NewInvalidRoomError returns the error for a room that isn't valid, listing the
+// valid rooms in its details
+func NewInvalidRoomError(room string) *echo.HTTPError {
+	rooms := GetValidRooms()
+	err := controller.NewValidationError("Invalid room name %s. Valid rooms: %v", room, rooms)
+	return &echo.HTTPError{
+		Code:     http.StatusBadRequest,
+		Message:  err.Error(),
+		Internal: err.WithDetails(map[string]interface{}{"valid_rooms": rooms}),
 	}
-	return false
 }
 
 // RoomOptions is a struct that represents the options available for a room
@@ -34,6 +95,69 @@ type RoomOptions struct {
 	Enabled      bool    `json:"enabled"`
 	ThresholdOn  float32 `json:"threshold_on"`
 	ThresholdOff float32 `json:"threshold_off"`
+
+	// Version is the version of the options, also returned as their ETag.
+	// It's ignored in requests.
+	Version int64 `json:"version,omitempty"`
+
+	// Unit is the unit of the thresholds in responses. It's ignored in
+	// requests, whose unit is set by the unit query parameter.
+	Unit controller.TemperatureUnit `json:"unit,omitempty"`
+
+	// Effective are the thresholds the room is heated with once shifted by
+	// its weather compensation and raised to its frost protection, if they
+	// aren't its own. It's ignored in requests.
+	Effective *controller.EffectiveThresholds `json:"effective,omitempty"`
+}
+
+// WithEffectiveThresholds returns the options of rooms with the thresholds
+// they are heated with, for the rooms heated with other thresholds than
+// theirs. They are all computed at once, so that the outside temperature is
+// only averaged once.
+func WithEffectiveThresholds(sh controller.SmartHomeInterface, options []RoomOptions) []RoomOptions {
+	if len(options) == 0 {
+		return options
+	}
+	rooms := make([]controller.RoomThresholds, len(options))
+	for i, r := range options {
+		rooms[i] = controller.RoomThresholds{Room: r.Name, Enabled: r.Enabled, ThresholdOn: r.ThresholdOn, ThresholdOff: r.ThresholdOff}
+	}
+	for i, effective := range sh.EffectiveThresholds(rooms...) {
+		options[i].Effective = effective
+	}
+	return options
+}
+
+// ETag returns the entity tag of a version of the options of a room
+func ETag(version int64) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+// ParseIfMatch returns the version of the options of a room that a write
+// requires according to its If-Match header: controller.AnyVersion without
+// the header, controller.ExistingVersion for *, or the version of its ETag.
+// Writes of every room can't be conditional, as each room has its version.
+func ParseIfMatch(room, ifMatch string) (int64, error) {
+	ifMatch = strings.TrimSpace(ifMatch)
+	switch {
+	case ifMatch == "":
+		return controller.AnyVersion, nil
+	case room == AllRooms:
+		return 0, echo.NewHTTPError(http.StatusBadRequest, "If-Match is not supported for every room")
+	case ifMatch == "*":
+		return controller.ExistingVersion, nil
+	case strings.Contains(ifMatch, ","):
+		return 0, echo.NewHTTPError(http.StatusBadRequest, "If-Match supports a single ETag")
+	}
+	// Weak ETags never match, and versions start at 1
+	version, err := strconv.ParseInt(strings.Trim(ifMatch, `"`), 10, 64)
+	if err != nil || version < 1 || !strings.HasPrefix(ifMatch, `"`) {
+		return 0, echo.NewHTTPError(
+			http.StatusPreconditionFailed,
+			fmt.Sprintf("The options of room %s don't match the ETag %s", room, ifMatch),
+		)
+	}
+	return version, nil
 }
 
 // SetRoomOptions can enable or disable automating temperature
@@ -42,16 +166,24 @@ func (cl *Client) SetRoomOptions(c echo.Context) error {
 	room := c.Param(roomParam)
 
 	if !ValidRoom(room).IsValid() {
-		return echo.NewHTTPError(
-			http.StatusBadRequest,
-			fmt.Sprintf("Invalid room name %s. Valid rooms: %v", room, ValidRooms),
-		)
+		return NewInvalidRoomError(room)
+	}
+
+	version, err := ParseIfMatch(room, c.Request().Header.Get(HeaderIfMatch))
+	if err != nil {
+		return err
+	}
+
+	unit, err := cl.temperatureUnit(c)
+	if err != nil {
+		return err
 	}
 
 	r := new(RoomOptions)
 	if err := json.NewDecoder(c.Request().Body).Decode(&r); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
+	r.Version, r.Effective = 0, nil
 
 	if r.ThresholdOn > r.ThresholdOff {
 		return echo.NewHTTPError(
@@ -63,17 +195,19 @@ func (cl *Client) SetRoomOptions(c echo.Context) error {
 			),
 		)
 	}
+	*r = r.ToCelsius(unit)
 
-	rooms := []string{room}
-
-	if room == "all" {
-		rooms = utils.AllButOne(ValidRooms, "all")
-	}
-
-	for _, roomName := range rooms {
-		if err := cl.SmartHomeInterface.SetRoomOptions(roomName, r.Enabled, r.ThresholdOn, r.ThresholdOff); err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	if room == AllRooms {
+		if err := SetRoomsOptions(cl.SmartHomeInterface, EveryRoom(), *r); err != nil {
+			return err
+		}
+	} else {
+		newVersion, err := cl.SmartHomeInterface.SetRoomOptions(room, r.Enabled, r.ThresholdOn, r.ThresholdOff, version)
+		if err != nil {
+			return NewHTTPError(err, fmt.Sprintf("Error setting room options for room %s", room))
 		}
+		r.Version = newVersion
+		c.Response().Header().Set(HeaderETag, ETag(newVersion))
 	}
 
 	return c.JSON(http.StatusOK, struct {
@@ -83,45 +217,379 @@ func (cl *Client) SetRoomOptions(c echo.Context) error {
 	}{
 		Message: "successfully set room options",
 		Code:    http.StatusOK,
-		Options: *r,
+		Options: r.InUnit(unit),
 	})
 }
 
-// GetRoomOptions Gets the current temperature options for a given valid room
-func (cl *Client) GetRoomOptions(c echo.Context) error {
+// PatchRoomOptions updates some of the options of a room, or of every room
+// with options, with a JSON merge patch (RFC 7396). Options missing from the
+// patch are left unchanged.
+func (cl *Client) PatchRoomOptions(c echo.Context) error {
 	room := c.Param(roomParam)
 
 	if !ValidRoom(room).IsValid() {
-		return echo.NewHTTPError(
-			http.StatusBadRequest,
-			fmt.Sprintf("Invalid room name %s. Valid rooms: %v", room, ValidRooms),
+		return NewInvalidRoomError(room)
+	}
+
+	body, err := ioutil.ReadAll(c.Request().Body)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	patch, err := ParseRoomOptionsPatch(room, c.Request().Header.Get(echo.HeaderContentType), body)
+	if err != nil {
+		return err
+	}
+
+	version, err := ParseIfMatch(room, c.Request().Header.Get(HeaderIfMatch))
+	if err != nil {
+		return err
+	}
+
+	unit, err := cl.temperatureUnit(c)
+	if err != nil {
+		return err
+	}
+
+	options, err := UpdateRoomOptions(cl.SmartHomeInterface, room, PatchToCelsius(patch, unit), version)
+	if err != nil {
+		return err
+	}
+	if room != AllRooms {
+		c.Response().Header().Set(HeaderETag, ETag(options[0].Version))
+	}
+
+	return c.JSON(http.StatusOK, NewPatchRoomOptionsResponse(room, RoomOptionsInUnit(options, unit)))
+}
+
+// PatchRoomOptionsResponse is the response to a PATCH of the options of a room
+type PatchRoomOptionsResponse struct {
+	Message string `json:"message"`
+	Code    int    `json:"status_code"`
+
+	// Options are the RoomOptions of the room after the patch, or a list of
+	// them if every room was patched
+	Options interface{} `json:"options"`
+}
+
+// NewPatchRoomOptionsResponse returns the response to a PATCH of the options
+// of a room, given the options returned by UpdateRoomOptions
+func NewPatchRoomOptionsResponse(room string, options []RoomOptions) PatchRoomOptionsResponse {
+	response := PatchRoomOptionsResponse{
+		Message: "successfully updated room options",
+		Code:    http.StatusOK,
+		Options: options,
+	}
+	if room != AllRooms && len(options) == 1 {
+		response.Options = options[0]
+	}
+	return response
+}
+
+// ParseRoomOptionsPatch parses a JSON merge patch of the options of a room.
+// Options can be changed but not removed, so null values are rejected, as
+// well as unknown fields and a name other than the name of the room.
+func ParseRoomOptionsPatch(room, contentType string, body []byte) (controller.RoomOptionsPatch, error) {
+	patch := controller.RoomOptionsPatch{}
+
+	mediaType := strings.TrimSpace(strings.Split(contentType, ";")[0])
+	if mediaType != "" && mediaType != MIMEApplicationMergePatchJSON && mediaType != echo.MIMEApplicationJSON {
+		return patch, echo.NewHTTPError(
+			http.StatusUnsupportedMediaType,
+			fmt.Sprintf("Unsupported content type %s. Use %s", mediaType, MIMEApplicationMergePatchJSON),
 		)
 	}
 
-	if room == "all" {
-		rooms := utils.AllButOne(ValidRooms, "all")
-		roomOpts := []RoomOptions{}
-		for _, roomName := range rooms {
-			item, err := cl.SmartHomeInterface.GetRoomOptions(roomName)
-			if err != nil {
-				return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(body, &fields); err != nil || fields == nil {
+		return patch, echo.NewHTTPError(http.StatusBadRequest, "The patch must be a JSON object")
+	}
+
+	for field, value := range fields {
+		if string(value) == "null" {
+			return patch, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("%s can't be removed", field))
+		}
+		var err error
+		switch field {
+		case "name":
+			var name string
+			if err = json.Unmarshal(value, &name); err == nil && name != room {
+				return patch, echo.NewHTTPError(http.StatusBadRequest, "The name of a room can't be changed")
 			}
-			if item == nil {
-				continue
+		case "enabled":
+			patch.Enabled = new(bool)
+			err = json.Unmarshal(value, patch.Enabled)
+		case "threshold_on":
+			patch.ThresholdOn = new(float32)
+			err = json.Unmarshal(value, patch.ThresholdOn)
+		case "threshold_off":
+			patch.ThresholdOff = new(float32)
+			err = json.Unmarshal(value, patch.ThresholdOff)
+		default:
+			return patch, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Unknown field %s", field))
+		}
+		if err != nil {
+			return patch, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid %s: %s", field, err.Error()))
+		}
+	}
+	return patch, nil
+}
+
+// UpdateRoomOptions applies a patch to the options of a room, or of every room
+// with options if room is "all", and returns the options after the patch.
+// version is the version required by the If-Match header, if any. Every room
+// is patched in a single transaction, so either all of them are or none is.
+func UpdateRoomOptions(sh controller.SmartHomeInterface, room string, patch controller.RoomOptionsPatch, version int64) ([]RoomOptions, error) {
+	if room != AllRooms {
+		item, err := sh.UpdateRoomOptions(room, patch, version)
+		if err != nil {
+			return nil, NewHTTPError(err, fmt.Sprintf("Error updating room options for room %s", room))
+		}
+		roomOpt := RoomOptions{Name: room}
+		if err = attributevalue.UnmarshalMap(item, &roomOpt); err != nil {
+			return nil, echo.NewHTTPError(
+				http.StatusInternalServerError,
+				fmt.Sprintf("Error unmarshalling DynamoDB item: %s", err.Error()),
+			)
+		}
+		return []RoomOptions{roomOpt}, nil
+	}
+
+	return UpdateRoomsOptions(sh, EveryRoom(), patch)
+}
+
+// UpdateRoomsOptions applies a patch to the options of the rooms that have
+// them in a single transaction, and returns the options after the patch. It
+// returns a 404 error if no room has options.
+func UpdateRoomsOptions(sh controller.SmartHomeInterface, rooms []string, patch controller.RoomOptionsPatch) ([]RoomOptions, error) {
+	roomOpts, err := ListRoomOptions(sh, rooms)
+	if err != nil {
+		return nil, err
+	}
+	changes := []RoomChange{}
+	for _, roomOpt := range roomOpts {
+		changes = append(changes, RoomChange{Room: roomOpt.Name, Action: controller.RoomActionUpdate, patch: patch})
+	}
+	return ChangeRoomsOptions(sh, changes)
+}
+
+// ListRoomOptions returns the options of the rooms that have them, in the
+// order of rooms, reading all of them at once. It returns a 404 error if no
+// room has options.
+func ListRoomOptions(sh controller.SmartHomeInterface, rooms []string) ([]RoomOptions, error) {
+	items, err := sh.GetRoomsOptions(rooms)
+	if err != nil {
+		return nil, NewHTTPError(err, "Error getting items from DynamoDB")
+	}
+
+	roomOpts := []RoomOptions{}
+	for _, roomName := range rooms {
+		item, ok := items[roomName]
+		if !ok {
+			continue
+		}
+		roomOpt := RoomOptions{Name: roomName}
+		if err = attributevalue.UnmarshalMap(item, &roomOpt); err != nil {
+			return nil, echo.NewHTTPError(
+				http.StatusInternalServerError,
+				fmt.Sprintf("Error unmarshalling DynamoDB item: %s", err.Error()),
+			)
+		}
+		roomOpts = append(roomOpts, roomOpt)
+	}
+	if len(roomOpts) == 0 {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "No rooms were found")
+	}
+	return roomOpts, nil
+}
+
+// SetRoomsOptions sets the same options for the rooms in a single
+// transaction, so either all of them are set or none is
+func SetRoomsOptions(sh controller.SmartHomeInterface, rooms []string, options RoomOptions) error {
+	patch := controller.RoomOptionsPatch{
+		Enabled:      &options.Enabled,
+		ThresholdOn:  &options.ThresholdOn,
+		ThresholdOff: &options.ThresholdOff,
+	}
+	changes := []controller.RoomChange{}
+	for _, room := range rooms {
+		changes = append(changes, controller.RoomChange{Room: room, Action: controller.RoomActionSet, Options: patch})
+	}
+	if err := sh.ChangeRoomsOptions(changes); err != nil {
+		return NewHTTPError(err, "Error setting room options")
+	}
+	return nil
+}
+
+// DeleteRoomsOptions deletes the options of the rooms in a single
+// transaction, so either all of them are deleted or none is
+func DeleteRoomsOptions(sh controller.SmartHomeInterface, rooms []string) error {
+	changes := []controller.RoomChange{}
+	for _, room := range rooms {
+		changes = append(changes, controller.RoomChange{Room: room, Action: controller.RoomActionDelete})
+	}
+	if err := sh.ChangeRoomsOptions(changes); err != nil {
+		return NewHTTPError(err, "Error deleting room options")
+	}
+	return nil
+}
+
+// RoomChange is a change of the options of a room in a POST /v1/rooms:batch
+type RoomChange struct {
+	Room string `json:"room"`
+
+	// Action is one of set, update or delete
+	Action string `json:"action"`
+
+	// Options are the options of the room for a set, or a JSON merge patch
+	// of them for an update
+	Options json.RawMessage `json:"options,omitempty"`
+
+	// Version is the version the room must have for the change to be
+	// applied, as with If-Match. Zero applies the change to any version.
+	Version int64 `json:"version,omitempty"`
+
+	patch controller.RoomOptionsPatch
+}
+
+// RoomChanges is the body of a POST /v1/rooms:batch
+type RoomChanges struct {
+	Changes []RoomChange `json:"changes"`
+}
+
+// ParseRoomChanges parses and validates the body of a POST /v1/rooms:batch
+func ParseRoomChanges(body []byte) ([]RoomChange, error) {
+	r := RoomChanges{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&r); err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if len(r.Changes) == 0 {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "The batch has no changes")
+	}
+
+	for i, change := range r.Changes {
+		if change.Room == AllRooms || !ValidRoom(change.Room).IsValid() {
+			return nil, NewInvalidRoomError(change.Room)
+		}
+		if change.Version < 0 {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid version %d for room %s", change.Version, change.Room))
+		}
+		switch change.Action {
+		case controller.RoomActionSet, controller.RoomActionUpdate:
+			patch, err := ParseRoomOptionsPatch(change.Room, "", change.Options)
+			if err != nil {
+				return nil, err
 			}
-			roomOpt := RoomOptions{Name: roomName}
-			if err = attributevalue.UnmarshalMap(item, &roomOpt); err != nil {
-				return echo.NewHTTPError(
-					http.StatusInternalServerError,
-					fmt.Sprintf("Error unmarshalling DynamoDB item: %s", err.Error()),
-				)
+			r.Changes[i].patch = patch
+		case controller.RoomActionDelete:
+			if len(change.Options) > 0 {
+				return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Deleting room %s takes no options", change.Room))
 			}
-			roomOpts = append(roomOpts, roomOpt)
+		default:
+			return nil, echo.NewHTTPError(
+				http.StatusBadRequest,
+				fmt.Sprintf("Invalid action %s for room %s. Valid actions: set, update, delete", change.Action, change.Room),
+			)
 		}
-		if len(roomOpts) == 0 {
-			return echo.NewHTTPError(http.StatusNotFound, "No rooms were found")
+	}
+	return r.Changes, nil
+}
+
+// ChangeRoomsOptions applies a batch of changes of the options of several
+// rooms in a single transaction, and returns the options of the rooms that
+// weren't deleted after the changes
+func ChangeRoomsOptions(sh controller.SmartHomeInterface, changes []RoomChange) ([]RoomOptions, error) {
+	controllerChanges := []controller.RoomChange{}
+	rooms := []string{}
+	for _, change := range changes {
+		controllerChanges = append(controllerChanges, controller.RoomChange{
+			Room:    change.Room,
+			Action:  change.Action,
+			Options: change.patch,
+			Version: change.Version,
+		})
+		if change.Action != controller.RoomActionDelete {
+			rooms = append(rooms, change.Room)
 		}
-		return c.JSON(http.StatusOK, roomOpts)
+	}
+	if err := sh.ChangeRoomsOptions(controllerChanges); err != nil {
+		return nil, NewHTTPError(err, "Error changing room options")
+	}
+	if len(rooms) == 0 {
+		return []RoomOptions{}, nil
+	}
+	return ListRoomOptions(sh, rooms)
+}
+
+// BatchRoomOptions applies a batch of changes of the options of several rooms
+// atomically: either every change is applied or none is
+func (cl *Client) BatchRoomOptions(c echo.Context) error {
+	// The route /v1/rooms:batch is a parameter for echo, so it also matches
+	// any other suffix of /v1/rooms
+	if c.Param("batch") != ":batch" {
+		return echo.ErrNotFound
+	}
+
+	body, err := ioutil.ReadAll(c.Request().Body)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	changes, err := ParseRoomChanges(body)
+	if err != nil {
+		return err
+	}
+
+	unit, err := cl.temperatureUnit(c)
+	if err != nil {
+		return err
+	}
+	for i := range changes {
+		changes[i].patch = PatchToCelsius(changes[i].patch, unit)
+	}
+
+	options, err := ChangeRoomsOptions(cl.SmartHomeInterface, changes)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, BatchRoomOptionsResponse{
+		Message: "successfully changed room options",
+		Code:    http.StatusOK,
+		Options: RoomOptionsInUnit(options, unit),
+	})
+}
+
+// BatchRoomOptionsResponse is the response to a POST /v1/rooms:batch
+type BatchRoomOptionsResponse struct {
+	Message string `json:"message"`
+	Code    int    `json:"status_code"`
+
+	// Options are the RoomOptions of the rooms set or updated by the batch
+	Options []RoomOptions `json:"options"`
+}
+
+// GetRoomOptions Gets the current temperature options for a given valid room
+func (cl *Client) GetRoomOptions(c echo.Context) error {
+	room := c.Param(roomParam)
+
+	if !ValidRoom(room).IsValid() {
+		return NewInvalidRoomError(room)
+	}
+
+	unit, err := cl.temperatureUnit(c)
+	if err != nil {
+		return err
+	}
+
+	if room == AllRooms {
+		roomOpts, err := ListRoomOptions(cl.SmartHomeInterface, EveryRoom())
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, RoomOptionsInUnit(WithEffectiveThresholds(cl.SmartHomeInterface, roomOpts), unit))
 	}
 
 	item, err := cl.SmartHomeInterface.GetRoomOptions(room)
@@ -140,27 +608,32 @@ func (cl *Client) GetRoomOptions(c echo.Context) error {
 			fmt.Sprintf("Error unmarshalling DynamoDB item: %s", err.Error()),
 		)
 	}
+	roomOpt.Effective = cl.SmartHomeInterface.EffectiveThresholds(controller.RoomThresholds{
+		Room: room, Enabled: roomOpt.Enabled, ThresholdOn: roomOpt.ThresholdOn, ThresholdOff: roomOpt.ThresholdOff,
+	})[0]
 
-	return c.JSON(http.StatusOK, roomOpt)
+	if roomOpt.Version > 0 {
+		c.Response().Header().Set(HeaderETag, ETag(roomOpt.Version))
+	}
+	return c.JSON(http.StatusOK, roomOpt.InUnit(unit))
 }
 
 func (cl *Client) DeleteRoomOptions(c echo.Context) error {
 	room := c.Param(roomParam)
 	if !ValidRoom(room).IsValid() {
-		return echo.NewHTTPError(
-			http.StatusBadRequest,
-			fmt.Sprintf("Invalid room name %s. Valid rooms: %v", room, ValidRooms),
-		)
+		return NewInvalidRoomError(room)
 	}
-	if room == "all" {
-		for _, r := range ValidRooms {
-			if err := cl.SmartHomeInterface.DeleteRoomOptions(r); err != nil {
-				return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
-			}
+	version, err := ParseIfMatch(room, c.Request().Header.Get(HeaderIfMatch))
+	if err != nil {
+		return err
+	}
+	if room == AllRooms {
+		if err := DeleteRoomsOptions(cl.SmartHomeInterface, EveryRoom()); err != nil {
+			return err
 		}
 	} else {
-		if err := cl.SmartHomeInterface.DeleteRoomOptions(room); err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		if err := cl.SmartHomeInterface.DeleteRoomOptions(room, version); err != nil {
+			return NewHTTPError(err, fmt.Sprintf("Error deleting room options for room %s", room))
 		}
 	}
 	return c.JSON(http.StatusOK, map[string]interface{}{
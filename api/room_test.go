@@ -2,9 +2,12 @@ package api
 
 import (
 	"fmt"
+	"net/http"
+	"net/url"
 	"testing"
 
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/igvaquero18/smarthome/controller"
 	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/assert"
 )
@@ -136,6 +139,182 @@ func TestSetRoomOptions(t *testing.T) {
 	}
 }
 
+func TestPatchRoomOptions(t *testing.T) {
+	bedroomOpts := map[string]types.AttributeValue{
+		"ThresholdOn":  &types.AttributeValueMemberN{Value: "19.3"},
+		"ThresholdOff": &types.AttributeValueMemberN{Value: "19.5"},
+		"Enabled":      &types.AttributeValueMemberBOOL{Value: true},
+	}
+	testCases := []struct {
+		name           string
+		ctx            mockContext
+		cl             *Client
+		expectedStatus int
+		expected       interface{}
+	}{
+		{
+			name: "Bedroom",
+			ctx: &baseMockContext{
+				Body:      `{"enabled": true}`,
+				Parameter: "bedroom",
+			},
+			cl:             NewClient(JWTConfig{}, &mockSmartHome{BedroomOpts: bedroomOpts}),
+			expectedStatus: http.StatusOK,
+			expected:       RoomOptions{Name: "bedroom", Enabled: true, ThresholdOn: 19.3, ThresholdOff: 19.5, Unit: controller.Celsius},
+		},
+		{
+			name: "All rooms, skipping the rooms not found",
+			ctx: &baseMockContext{
+				Body:      `{"threshold_on": 19.3}`,
+				Parameter: "all",
+			},
+			cl:             NewClient(JWTConfig{}, &mockSmartHome{BedroomOpts: bedroomOpts}),
+			expectedStatus: http.StatusOK,
+			expected:       []RoomOptions{{Name: "bedroom", Enabled: true, ThresholdOn: 19.3, ThresholdOff: 19.5, Unit: controller.Celsius}},
+		},
+		{
+			name: "All rooms, no rooms found",
+			ctx: &baseMockContext{
+				Body:      `{"enabled": false}`,
+				Parameter: "all",
+			},
+			cl:             NewClient(JWTConfig{}, &mockSmartHome{}),
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name: "Room not found",
+			ctx: &baseMockContext{
+				Body:      `{"enabled": false}`,
+				Parameter: "livingroom",
+			},
+			cl:             NewClient(JWTConfig{}, &mockSmartHome{BedroomOpts: bedroomOpts}),
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name: "Invalid room parameter",
+			ctx: &baseMockContext{
+				Body:      `{"enabled": false}`,
+				Parameter: "fakeroom",
+			},
+			cl:             NewClient(JWTConfig{}, &mockSmartHome{}),
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "Invalid patch",
+			ctx: &baseMockContext{
+				Body:      `{"enabled": null}`,
+				Parameter: "bedroom",
+			},
+			cl:             NewClient(JWTConfig{}, &mockSmartHome{BedroomOpts: bedroomOpts}),
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "Controller errors",
+			ctx: &baseMockContext{
+				Body:      `{"enabled": false}`,
+				Parameter: "bedroom",
+			},
+			cl:             NewClient(JWTConfig{}, &mockSmartHome{Err: fmt.Errorf("Error")}),
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			err := tc.cl.PatchRoomOptions(tc.ctx)
+			if tc.expectedStatus != http.StatusOK {
+				assert.Error(tt, err)
+				assert.IsType(tt, &echo.HTTPError{}, err)
+				assert.Equal(tt, tc.expectedStatus, StatusCode(err))
+				return
+			}
+			assert.NoError(tt, err)
+			response, ok := tc.ctx.GetJSONPayload().(PatchRoomOptionsResponse)
+			if !ok {
+				assert.Fail(tt, "Actual should be of type PatchRoomOptionsResponse")
+				return
+			}
+			assert.Equal(tt, tc.expected, response.Options)
+		})
+	}
+}
+
+func TestParseRoomOptionsPatch(t *testing.T) {
+	enabled, thresholdOn, thresholdOff := false, float32(19.5), float32(21)
+	testCases := []struct {
+		name           string
+		contentType    string
+		body           string
+		expected       controller.RoomOptionsPatch
+		expectedStatus int
+	}{
+		{
+			name:        "Merge patch",
+			contentType: MIMEApplicationMergePatchJSON,
+			body:        `{"enabled": false, "threshold_on": 19.5, "threshold_off": 21}`,
+			expected:    controller.RoomOptionsPatch{Enabled: &enabled, ThresholdOn: &thresholdOn, ThresholdOff: &thresholdOff},
+		},
+		{
+			name:        "JSON with the name of the room",
+			contentType: echo.MIMEApplicationJSONCharsetUTF8,
+			body:        `{"name": "bedroom", "threshold_on": 19.5}`,
+			expected:    controller.RoomOptionsPatch{ThresholdOn: &thresholdOn},
+		},
+		{
+			name:     "Empty patch",
+			body:     `{}`,
+			expected: controller.RoomOptionsPatch{},
+		},
+		{
+			name:           "Unsupported content type",
+			contentType:    echo.MIMETextPlain,
+			body:           `{"enabled": false}`,
+			expectedStatus: http.StatusUnsupportedMediaType,
+		},
+		{
+			name:           "Not an object",
+			body:           `null`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Invalid JSON",
+			body:           `{"enabled": false`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Removed option",
+			body:           `{"threshold_on": null}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Invalid type",
+			body:           `{"enabled": "no"}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Unknown field",
+			body:           `{"temperature": 20}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Another name",
+			body:           `{"name": "livingroom"}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			patch, err := ParseRoomOptionsPatch("bedroom", tc.contentType, []byte(tc.body))
+			if tc.expectedStatus != 0 {
+				assert.Error(tt, err)
+				assert.Equal(tt, tc.expectedStatus, StatusCode(err))
+				return
+			}
+			assert.NoError(tt, err)
+			assert.Equal(tt, tc.expected, patch)
+		})
+	}
+}
+
 func TestGetRoomOptions(t *testing.T) {
 	testCases := []struct {
 		name          string
@@ -170,12 +349,14 @@ func TestGetRoomOptions(t *testing.T) {
 					ThresholdOn:  19.3,
 					ThresholdOff: 19.5,
 					Enabled:      true,
+					Unit:         controller.Celsius,
 				},
 				{
 					Name:         "livingroom",
 					ThresholdOn:  19.3,
 					ThresholdOff: 19.5,
 					Enabled:      true,
+					Unit:         controller.Celsius,
 				},
 			},
 		},
@@ -206,6 +387,54 @@ func TestGetRoomOptions(t *testing.T) {
 				ThresholdOn:  19.3,
 				ThresholdOff: 19.5,
 				Enabled:      true,
+				Unit:         controller.Celsius,
+			},
+		},
+		{
+			name: "Bedroom with weather compensation, in Fahrenheit",
+			ctx: &baseMockContext{
+				Parameter: "bedroom",
+				Query:     url.Values{"unit": []string{"F"}},
+			},
+			cl: NewClient(JWTConfig{}, &mockSmartHome{
+				BedroomOpts: map[string]types.AttributeValue{
+					"Name":         &types.AttributeValueMemberS{Value: "bedroom"},
+					"ThresholdOn":  &types.AttributeValueMemberN{Value: "19.5"},
+					"ThresholdOff": &types.AttributeValueMemberN{Value: "21"},
+					"Enabled":      &types.AttributeValueMemberBOOL{Value: true},
+				},
+				Compensation: map[string]float32{"bedroom": 1},
+			}),
+			errorExpected: false,
+			expected: RoomOptions{
+				Name:         "bedroom",
+				ThresholdOn:  67.1,
+				ThresholdOff: 69.8,
+				Enabled:      true,
+				Unit:         controller.Fahrenheit,
+				Effective:    &controller.EffectiveThresholds{ThresholdOn: 68.9, ThresholdOff: 71.6},
+			},
+		},
+		{
+			name: "Disabled bedroom, heated up to the frost protection",
+			ctx: &baseMockContext{
+				Parameter: "bedroom",
+			},
+			cl: NewClient(JWTConfig{}, &mockSmartHome{
+				BedroomOpts: map[string]types.AttributeValue{
+					"Name":         &types.AttributeValueMemberS{Value: "bedroom"},
+					"ThresholdOn":  &types.AttributeValueMemberN{Value: "19.5"},
+					"ThresholdOff": &types.AttributeValueMemberN{Value: "21"},
+					"Enabled":      &types.AttributeValueMemberBOOL{Value: false},
+				},
+			}),
+			errorExpected: false,
+			expected: RoomOptions{
+				Name:         "bedroom",
+				ThresholdOn:  19.5,
+				ThresholdOff: 21,
+				Unit:         controller.Celsius,
+				Effective:    &controller.EffectiveThresholds{ThresholdOn: 5, ThresholdOff: 5, FrostProtection: true},
 			},
 		},
 		{
@@ -235,6 +464,7 @@ func TestGetRoomOptions(t *testing.T) {
 				ThresholdOn:  19.3,
 				ThresholdOff: 19.5,
 				Enabled:      true,
+				Unit:         controller.Celsius,
 			},
 		},
 		{
@@ -361,3 +591,349 @@ func TestDeleteRoomOptions(t *testing.T) {
 		})
 	}
 }
+
+func TestParseIfMatch(t *testing.T) {
+	testCases := []struct {
+		name            string
+		room            string
+		ifMatch         string
+		expectedVersion int64
+		expectedStatus  int
+	}{
+		{
+			name:            "No If-Match",
+			room:            "bedroom",
+			expectedVersion: controller.AnyVersion,
+		},
+		{
+			name:            "No If-Match for every room",
+			room:            AllRooms,
+			expectedVersion: controller.AnyVersion,
+		},
+		{
+			name:            "ETag",
+			room:            "bedroom",
+			ifMatch:         `"3"`,
+			expectedVersion: 3,
+		},
+		{
+			name:            "Any ETag",
+			room:            "bedroom",
+			ifMatch:         "*",
+			expectedVersion: controller.ExistingVersion,
+		},
+		{
+			name:           "Weak ETag",
+			room:           "bedroom",
+			ifMatch:        `W/"3"`,
+			expectedStatus: http.StatusPreconditionFailed,
+		},
+		{
+			name:           "Unquoted ETag",
+			room:           "bedroom",
+			ifMatch:        "3",
+			expectedStatus: http.StatusPreconditionFailed,
+		},
+		{
+			name:           "ETag of no version",
+			room:           "bedroom",
+			ifMatch:        `"0"`,
+			expectedStatus: http.StatusPreconditionFailed,
+		},
+		{
+			name:           "Several ETags",
+			room:           "bedroom",
+			ifMatch:        `"3", "4"`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Every room",
+			room:           AllRooms,
+			ifMatch:        `"3"`,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			version, err := ParseIfMatch(tc.room, tc.ifMatch)
+			if tc.expectedStatus != 0 {
+				assert.Error(tt, err)
+				assert.Equal(tt, tc.expectedStatus, StatusCode(err))
+				return
+			}
+			assert.NoError(tt, err)
+			assert.Equal(tt, tc.expectedVersion, version)
+		})
+	}
+}
+
+func TestRoomOptionsETag(t *testing.T) {
+	bedroomOpts := map[string]types.AttributeValue{
+		"ThresholdOn":  &types.AttributeValueMemberN{Value: "19.3"},
+		"ThresholdOff": &types.AttributeValueMemberN{Value: "19.5"},
+		"Enabled":      &types.AttributeValueMemberBOOL{Value: true},
+		"Version":      &types.AttributeValueMemberN{Value: "4"},
+	}
+	ifMatch := http.Header{HeaderIfMatch: []string{`"4"`}}
+	testCases := []struct {
+		name           string
+		handler        func(cl *Client) echo.HandlerFunc
+		ctx            *baseMockContext
+		smarthome      *mockSmartHome
+		expectedStatus int
+		expectedETag   string
+	}{
+		{
+			name:           "Get room options",
+			handler:        func(cl *Client) echo.HandlerFunc { return cl.GetRoomOptions },
+			ctx:            &baseMockContext{Parameter: "bedroom"},
+			smarthome:      &mockSmartHome{BedroomOpts: bedroomOpts},
+			expectedStatus: http.StatusOK,
+			expectedETag:   `"4"`,
+		},
+		{
+			name:    "Get room options without version",
+			handler: func(cl *Client) echo.HandlerFunc { return cl.GetRoomOptions },
+			ctx:     &baseMockContext{Parameter: "livingroom"},
+			smarthome: &mockSmartHome{LivingRoomOpts: map[string]types.AttributeValue{
+				"Enabled": &types.AttributeValueMemberBOOL{Value: true},
+			}},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:    "Set room options",
+			handler: func(cl *Client) echo.HandlerFunc { return cl.SetRoomOptions },
+			ctx: &baseMockContext{
+				Body:      `{"enabled": true, "threshold_on": 19.5, "threshold_off": 19.7}`,
+				Header:    ifMatch,
+				Parameter: "bedroom",
+			},
+			smarthome:      &mockSmartHome{},
+			expectedStatus: http.StatusOK,
+			expectedETag:   `"5"`,
+		},
+		{
+			name:    "Set room options with a stale ETag",
+			handler: func(cl *Client) echo.HandlerFunc { return cl.SetRoomOptions },
+			ctx: &baseMockContext{
+				Body:      `{"enabled": true, "threshold_on": 19.5, "threshold_off": 19.7}`,
+				Header:    ifMatch,
+				Parameter: "bedroom",
+			},
+			smarthome:      &mockSmartHome{Err: controller.NewPreconditionFailedError("room bedroom has been modified")},
+			expectedStatus: http.StatusPreconditionFailed,
+		},
+		{
+			name:    "Patch room options",
+			handler: func(cl *Client) echo.HandlerFunc { return cl.PatchRoomOptions },
+			ctx: &baseMockContext{
+				Body:      `{"enabled": false}`,
+				Header:    ifMatch,
+				Parameter: "bedroom",
+			},
+			smarthome:      &mockSmartHome{BedroomOpts: bedroomOpts},
+			expectedStatus: http.StatusOK,
+			expectedETag:   `"4"`,
+		},
+		{
+			name:    "Patch every room with an ETag",
+			handler: func(cl *Client) echo.HandlerFunc { return cl.PatchRoomOptions },
+			ctx: &baseMockContext{
+				Body:      `{"enabled": false}`,
+				Header:    ifMatch,
+				Parameter: AllRooms,
+			},
+			smarthome:      &mockSmartHome{BedroomOpts: bedroomOpts},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:    "Delete room options with a stale ETag",
+			handler: func(cl *Client) echo.HandlerFunc { return cl.DeleteRoomOptions },
+			ctx: &baseMockContext{
+				Header:    ifMatch,
+				Parameter: "bedroom",
+			},
+			smarthome:      &mockSmartHome{Err: controller.NewPreconditionFailedError("room bedroom has been modified")},
+			expectedStatus: http.StatusPreconditionFailed,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			err := tc.handler(NewClient(JWTConfig{}, tc.smarthome))(tc.ctx)
+			if tc.expectedStatus != http.StatusOK {
+				assert.Error(tt, err)
+				assert.Equal(tt, tc.expectedStatus, StatusCode(err))
+				return
+			}
+			assert.NoError(tt, err)
+			assert.Equal(tt, tc.expectedETag, tc.ctx.Response().Header().Get(HeaderETag))
+		})
+	}
+}
+
+func TestBatchRoomOptions(t *testing.T) {
+	bedroomOpts := map[string]types.AttributeValue{
+		"ThresholdOn":  &types.AttributeValueMemberN{Value: "19.3"},
+		"ThresholdOff": &types.AttributeValueMemberN{Value: "19.5"},
+		"Enabled":      &types.AttributeValueMemberBOOL{Value: true},
+		"Version":      &types.AttributeValueMemberN{Value: "2"},
+	}
+	enabled := true
+	testCases := []struct {
+		name            string
+		ctx             mockContext
+		sh              *mockSmartHome
+		expectedStatus  int
+		expected        []RoomOptions
+		expectedChanges []controller.RoomChange
+	}{
+		{
+			name: "Update and delete",
+			ctx: &baseMockContext{
+				Body:      `{"changes": [{"room": "bedroom", "action": "update", "options": {"enabled": true}, "version": 1}, {"room": "livingroom", "action": "delete"}]}`,
+				Parameter: ":batch",
+			},
+			sh:             &mockSmartHome{BedroomOpts: bedroomOpts},
+			expectedStatus: http.StatusOK,
+			expected:       []RoomOptions{{Name: "bedroom", Enabled: true, ThresholdOn: 19.3, ThresholdOff: 19.5, Version: 2, Unit: controller.Celsius}},
+			expectedChanges: []controller.RoomChange{
+				{Room: "bedroom", Action: controller.RoomActionUpdate, Options: controller.RoomOptionsPatch{Enabled: &enabled}, Version: 1},
+				{Room: "livingroom", Action: controller.RoomActionDelete},
+			},
+		},
+		{
+			name: "Only deletes",
+			ctx: &baseMockContext{
+				Body:      `{"changes": [{"room": "livingroom", "action": "delete"}]}`,
+				Parameter: ":batch",
+			},
+			sh:              &mockSmartHome{},
+			expectedStatus:  http.StatusOK,
+			expected:        []RoomOptions{},
+			expectedChanges: []controller.RoomChange{{Room: "livingroom", Action: controller.RoomActionDelete}},
+		},
+		{
+			name: "Another path",
+			ctx: &baseMockContext{
+				Body:      `{"changes": [{"room": "livingroom", "action": "delete"}]}`,
+				Parameter: "foo",
+			},
+			sh:             &mockSmartHome{},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name: "Every room",
+			ctx: &baseMockContext{
+				Body:      `{"changes": [{"room": "all", "action": "delete"}]}`,
+				Parameter: ":batch",
+			},
+			sh:             &mockSmartHome{},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "Invalid room",
+			ctx: &baseMockContext{
+				Body:      `{"changes": [{"room": "kitchen", "action": "delete"}]}`,
+				Parameter: ":batch",
+			},
+			sh:             &mockSmartHome{},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "Invalid action",
+			ctx: &baseMockContext{
+				Body:      `{"changes": [{"room": "bedroom", "action": "rename"}]}`,
+				Parameter: ":batch",
+			},
+			sh:             &mockSmartHome{},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "Delete with options",
+			ctx: &baseMockContext{
+				Body:      `{"changes": [{"room": "bedroom", "action": "delete", "options": {"enabled": true}}]}`,
+				Parameter: ":batch",
+			},
+			sh:             &mockSmartHome{},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "Invalid options",
+			ctx: &baseMockContext{
+				Body:      `{"changes": [{"room": "bedroom", "action": "update", "options": {"enabled": null}}]}`,
+				Parameter: ":batch",
+			},
+			sh:             &mockSmartHome{},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "No changes",
+			ctx: &baseMockContext{
+				Body:      `{"changes": []}`,
+				Parameter: ":batch",
+			},
+			sh:             &mockSmartHome{},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "Unknown field",
+			ctx: &baseMockContext{
+				Body:      `{"changes": [{"room": "bedroom", "action": "delete", "if_match": "*"}]}`,
+				Parameter: ":batch",
+			},
+			sh:             &mockSmartHome{},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "Modified room",
+			ctx: &baseMockContext{
+				Body:      `{"changes": [{"room": "bedroom", "action": "delete", "version": 1}]}`,
+				Parameter: ":batch",
+			},
+			sh:             &mockSmartHome{Err: controller.NewPreconditionFailedError("the options of room bedroom have been modified or deleted")},
+			expectedStatus: http.StatusPreconditionFailed,
+		},
+		{
+			name: "Controller errors",
+			ctx: &baseMockContext{
+				Body:      `{"changes": [{"room": "bedroom", "action": "delete"}]}`,
+				Parameter: ":batch",
+			},
+			sh:             &mockSmartHome{Err: fmt.Errorf("Error")},
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			err := NewClient(JWTConfig{}, tc.sh).BatchRoomOptions(tc.ctx)
+			if tc.expectedStatus != http.StatusOK {
+				assert.Error(tt, err)
+				assert.Equal(tt, tc.expectedStatus, StatusCode(err))
+				return
+			}
+			assert.NoError(tt, err)
+			response, ok := tc.ctx.GetJSONPayload().(BatchRoomOptionsResponse)
+			if !ok {
+				assert.Fail(tt, "Actual should be of type BatchRoomOptionsResponse")
+				return
+			}
+			assert.Equal(tt, tc.expected, response.Options)
+			assert.Equal(tt, tc.expectedChanges, tc.sh.RoomChanges)
+		})
+	}
+}
+
+func TestWithEffectiveThresholds(t *testing.T) {
+	sh := &mockSmartHome{Compensation: map[string]float32{"bedroom": 1}}
+	options := []RoomOptions{
+		{Name: "bedroom", ThresholdOn: 19, ThresholdOff: 20, Enabled: true},
+		{Name: "livingroom", ThresholdOn: 19, ThresholdOff: 20, Enabled: true},
+		{Name: "kids", ThresholdOn: 19, ThresholdOff: 20},
+	}
+	assert.Equal(t, []RoomOptions{
+		{Name: "bedroom", ThresholdOn: 19, ThresholdOff: 20, Enabled: true, Effective: &controller.EffectiveThresholds{ThresholdOn: 20, ThresholdOff: 21}},
+		{Name: "livingroom", ThresholdOn: 19, ThresholdOff: 20, Enabled: true},
+		{Name: "kids", ThresholdOn: 19, ThresholdOff: 20, Effective: &controller.EffectiveThresholds{ThresholdOn: 5, ThresholdOff: 5, FrostProtection: true}},
+	}, WithEffectiveThresholds(sh, options))
+	assert.Equal(t, 1, sh.EffectiveCalls, "the thresholds of every room are computed at once")
+}
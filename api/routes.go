@@ -0,0 +1,89 @@
+package api
+
+import (
+	"fmt"
+
+	"github.com/igvaquero18/smarthome/controller"
+	"github.com/igvaquero18/smarthome/utils"
+	"github.com/labstack/echo/v4"
+)
+
+// APIVersion is the prefix of the paths of the SmartHome API
+const APIVersion = "v1"
+
+// RegisterRoutes registers the routes of the SmartHome API. If keys is empty,
+// authentication is disabled, and only the routes of the rooms and the
+// specification are registered. The OpenID Connect routes are registered
+// only if cl.OIDC is set.
+func (cl *Client) RegisterRoutes(e *echo.Echo, keys *utils.KeySet) {
+	e.GET(fmt.Sprintf("%s/openapi.json", APIVersion), cl.OpenAPI)
+
+	auth := []echo.MiddlewareFunc{}
+	room := e.Group(fmt.Sprintf("%s/room", APIVersion))
+	if !keys.Empty() {
+		auth = append(auth, APIKey(cl.AuthenticateAPIKey), JWT(keys, func(c echo.Context) bool {
+			// Machine clients authenticated with a client certificate or an API key don't need a JWT
+			return utils.HasVerifiedClientCertificate(c.Request()) || APIKeyAuthenticated(c)
+		}))
+		room.Use(auth...)
+		e.GET("/.well-known/jwks.json", cl.JWKS)
+		e.POST(fmt.Sprintf("%s/login", APIVersion), cl.Login)
+		e.POST(fmt.Sprintf("%s/signup", APIVersion), cl.SignUp, JWT(keys, nil), RequireRole(controller.RoleAdmin))
+		e.DELETE(fmt.Sprintf("%s/user", APIVersion), cl.DeleteUser, JWT(keys, nil), RequireRole(controller.RoleAdmin))
+		e.PUT(fmt.Sprintf("%s/user/password", APIVersion), cl.ChangePassword, JWT(keys, nil))
+		e.POST(fmt.Sprintf("%s/user/unlock", APIVersion), cl.UnlockUser, JWT(keys, nil), RequireRole(controller.RoleAdmin))
+		e.GET(fmt.Sprintf("%s/user/preferences", APIVersion), cl.GetPreferences, JWT(keys, nil))
+		e.PUT(fmt.Sprintf("%s/user/preferences", APIVersion), cl.SetPreferences, JWT(keys, nil))
+
+		mfa := e.Group(fmt.Sprintf("%s/user/mfa", APIVersion), JWT(keys, nil))
+		mfa.POST("", cl.EnrollMFA)
+		mfa.POST("/confirm", cl.ConfirmMFA)
+		mfa.DELETE("", cl.DisableMFA)
+
+		apiKeys := e.Group(fmt.Sprintf("%s/apikeys", APIVersion), JWT(keys, nil))
+		apiKeys.POST("", cl.CreateAPIKey)
+		apiKeys.GET("", cl.ListAPIKeys)
+		apiKeys.DELETE("/:id", cl.DeleteAPIKey)
+
+		invitations := fmt.Sprintf("%s/invitations", APIVersion)
+		e.POST(invitations, cl.CreateInvitation, JWT(keys, nil), RequireRole(controller.RoleAdmin))
+		e.GET(invitations, cl.ListInvitations, JWT(keys, nil), RequireRole(controller.RoleAdmin))
+		e.DELETE(invitations+"/:id", cl.DeleteInvitation, JWT(keys, nil), RequireRole(controller.RoleAdmin))
+		e.POST(invitations+"/:code/accept", cl.AcceptInvitation)
+
+		if cl.OIDC != nil {
+			e.GET(fmt.Sprintf("%s/auth/oidc/login", APIVersion), cl.OIDCLogin)
+			e.GET(fmt.Sprintf("%s/auth/oidc/callback", APIVersion), cl.OIDCCallback)
+		}
+	}
+	room.POST("/:room", cl.SetRoomOptions, RequireScope(controller.ScopeRoomsWrite))
+	room.PATCH("/:room", cl.PatchRoomOptions, RequireScope(controller.ScopeRoomsWrite))
+	room.GET("/:room", cl.GetRoomOptions, RequireScope(controller.ScopeRoomsRead))
+	room.DELETE("/:room", cl.DeleteRoomOptions, RequireScope(controller.ScopeRoomsWrite))
+
+	zones := e.Group(fmt.Sprintf("%s/zones", APIVersion), auth...)
+	zones.POST("", cl.CreateZone, RequireScope(controller.ScopeRoomsWrite))
+	zones.GET("", cl.ListZones, RequireScope(controller.ScopeRoomsRead))
+	zones.GET("/:zone", cl.GetZone, RequireScope(controller.ScopeRoomsRead))
+	zones.PUT("/:zone", cl.UpdateZone, RequireScope(controller.ScopeRoomsWrite))
+	zones.DELETE("/:zone", cl.DeleteZone, RequireScope(controller.ScopeRoomsWrite))
+	zones.GET("/:zone/options", cl.GetZoneOptions, RequireScope(controller.ScopeRoomsRead))
+	zones.POST("/:zone/options", cl.SetZoneOptions, RequireScope(controller.ScopeRoomsWrite))
+	zones.PATCH("/:zone/options", cl.PatchZoneOptions, RequireScope(controller.ScopeRoomsWrite))
+	zones.DELETE("/:zone/options", cl.DeleteZoneOptions, RequireScope(controller.ScopeRoomsWrite))
+
+	// echo takes :batch as a parameter, which BatchRoomOptions checks
+	batch := append(auth, RequireScope(controller.ScopeRoomsWrite))
+	e.POST(fmt.Sprintf("%s/rooms:batch", APIVersion), cl.BatchRoomOptions, batch...)
+
+	readings := append(auth, RequireScope(controller.ScopeReadingsRead))
+	e.GET(fmt.Sprintf("%s/readings", APIVersion), cl.GetReadings, readings...)
+	e.GET(fmt.Sprintf("%s/energy", APIVersion), cl.GetEnergy, readings...)
+
+	heating := append(auth, RequireScope(controller.ScopeReadingsWrite))
+	e.POST(fmt.Sprintf("%s/readings", APIVersion), cl.AddReading, heating...)
+	e.POST(fmt.Sprintf("%s/heating", APIVersion), cl.RecordHeating, heating...)
+
+	export := append(auth, RequireScope(controller.ScopeReadingsRead), RequireScope(controller.ScopeRoomsRead))
+	e.GET(fmt.Sprintf("%s/export", APIVersion), cl.Export, export...)
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/igvaquero18/smarthome/controller"
+	"github.com/igvaquero18/smarthome/utils"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegisterRoutesUserManagement(t *testing.T) {
+	keys := utils.NewHMACKeySet("secret")
+	admin, _ := keys.Sign(jwt.MapClaims{utils.TypeClaim: utils.AccessToken, "sub": "admin", "role": controller.RoleAdmin})
+	user, _ := keys.Sign(jwt.MapClaims{utils.TypeClaim: utils.AccessToken, "sub": "bob", "role": controller.RoleUser})
+	e := echo.New()
+	e.HTTPErrorHandler = HTTPErrorHandler
+	NewClient(JWTConfig{}, &mockSmartHome{}).RegisterRoutes(e, keys)
+
+	testCases := []struct {
+		name         string
+		method       string
+		path         string
+		body         string
+		token        string
+		expectedCode int
+	}{
+		{
+			name:         "Anonymous sign up",
+			method:       http.MethodPost,
+			path:         "/v1/signup",
+			body:         `{"username": "mallory", "password": "correct horse battery"}`,
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "Sign up by a user",
+			method:       http.MethodPost,
+			path:         "/v1/signup",
+			body:         `{"username": "mallory", "password": "correct horse battery"}`,
+			token:        user,
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "Sign up by an admin",
+			method:       http.MethodPost,
+			path:         "/v1/signup",
+			body:         `{"username": "alice", "password": "correct horse battery"}`,
+			token:        admin,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Anonymous user deletion",
+			method:       http.MethodDelete,
+			path:         "/v1/user",
+			body:         `{"username": "admin"}`,
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "User deletion by a user",
+			method:       http.MethodDelete,
+			path:         "/v1/user",
+			body:         `{"username": "admin"}`,
+			token:        user,
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "User deletion by an admin",
+			method:       http.MethodDelete,
+			path:         "/v1/user",
+			body:         `{"username": "bob"}`,
+			token:        admin,
+			expectedCode: http.StatusOK,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			if tc.token != "" {
+				req.Header.Set(echo.HeaderAuthorization, "Bearer "+tc.token)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			assert.Equal(tt, tc.expectedCode, rec.Code, rec.Body.String())
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/igvaquero18/smarthome/controller"
+	"github.com/labstack/echo/v4"
+)
+
+// unitParam is the query parameter choosing the unit of the thresholds of a
+// request and its response
+const unitParam = "unit"
+
+// Preferences are the preferences of a user
+type Preferences struct {
+	// TemperatureUnit is the unit thresholds are shown and entered in when
+	// requests don't have the unit query parameter
+	TemperatureUnit controller.TemperatureUnit `json:"temperature_unit"`
+}
+
+// GetPreferences returns the preferences of the logged in user
+func (cl *Client) GetPreferences(c echo.Context) error {
+	username, err := tokenSubject(c)
+	if err != nil {
+		return err
+	}
+	user, err := cl.SmartHomeInterface.GetUser(username)
+	if err != nil {
+		return NewHTTPError(err, "Error getting user")
+	}
+	if user == nil {
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("User %s not found", username))
+	}
+	return c.JSON(http.StatusOK, userPreferences(user))
+}
+
+// SetPreferences changes the preferences of the logged in user
+func (cl *Client) SetPreferences(c echo.Context) error {
+	username, err := tokenSubject(c)
+	if err != nil {
+		return err
+	}
+
+	p := new(Preferences)
+	if err := json.NewDecoder(c.Request().Body).Decode(&p); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid payload: %s", err.Error()))
+	}
+	unit, err := controller.ParseTemperatureUnit(string(p.TemperatureUnit))
+	if err != nil {
+		return NewHTTPError(err, "Invalid temperature unit")
+	}
+
+	if err := cl.SmartHomeInterface.SetTemperatureUnit(username, unit); err != nil {
+		return NewHTTPError(err, "Error setting preferences")
+	}
+	return c.JSON(http.StatusOK, Preferences{TemperatureUnit: unit})
+}
+
+// userPreferences returns the preferences of a user, which are the defaults
+// unless the user changed them
+func userPreferences(user *controller.User) Preferences {
+	p := Preferences{TemperatureUnit: controller.Celsius}
+	if user.TemperatureUnit != "" {
+		p.TemperatureUnit = user.TemperatureUnit
+	}
+	return p
+}
+
+// ParseUnitParam returns the unit of the unit query parameter, which is
+// Celsius if it's empty
+func ParseUnitParam(value string) (controller.TemperatureUnit, error) {
+	if value == "" {
+		return controller.Celsius, nil
+	}
+	unit, err := controller.ParseTemperatureUnit(value)
+	if err != nil {
+		return "", NewHTTPError(err, "Invalid temperature unit")
+	}
+	return unit, nil
+}
+
+// temperatureUnit returns the unit of the thresholds of a request: the unit
+// query parameter if set, or else the preference of the logged in user.
+// Requests authenticated without a user, such as those with an API key, are
+// in Celsius by default.
+func (cl *Client) temperatureUnit(c echo.Context) (controller.TemperatureUnit, error) {
+	username, _ := tokenSubject(c)
+	return TemperatureUnit(cl.SmartHomeInterface, c.QueryParam(unitParam), username)
+}
+
+// TemperatureUnit returns the unit of the thresholds of a request given its
+// unit query parameter, or else the preference of its user if it has one.
+// Otherwise it is Celsius.
+func TemperatureUnit(sh controller.SmartHomeInterface, value, username string) (controller.TemperatureUnit, error) {
+	if value != "" {
+		return ParseUnitParam(value)
+	}
+	if username == "" {
+		return controller.Celsius, nil
+	}
+	user, err := sh.GetUser(username)
+	if err != nil {
+		return "", NewHTTPError(err, "Error getting user")
+	}
+	if user == nil {
+		return controller.Celsius, nil
+	}
+	return userPreferences(user).TemperatureUnit, nil
+}
+
+// InUnit returns the options, whose thresholds are in Celsius, with their
+// thresholds in the unit
+func (r RoomOptions) InUnit(unit controller.TemperatureUnit) RoomOptions {
+	r.ThresholdOn = unit.FromCelsius(r.ThresholdOn)
+	r.ThresholdOff = unit.FromCelsius(r.ThresholdOff)
+	r.Unit = unit
+	if r.Effective != nil {
+		effective := *r.Effective
+		effective.ThresholdOn = unit.FromCelsius(r.Effective.ThresholdOn)
+		effective.ThresholdOff = unit.FromCelsius(r.Effective.ThresholdOff)
+		if r.Effective.OutsideTemperature != nil {
+			outside := unit.FromCelsius(*r.Effective.OutsideTemperature)
+			effective.OutsideTemperature = &outside
+		}
+		r.Effective = &effective
+	}
+	return r
+}
+
+// ToCelsius returns the options, whose thresholds are in the unit, with
+// their thresholds in Celsius
+func (r RoomOptions) ToCelsius(unit controller.TemperatureUnit) RoomOptions {
+	r.ThresholdOn = unit.ToCelsius(r.ThresholdOn)
+	r.ThresholdOff = unit.ToCelsius(r.ThresholdOff)
+	r.Unit = controller.Celsius
+	return r
+}
+
+// RoomOptionsInUnit returns a list of options, whose thresholds are in
+// Celsius, with their thresholds in the unit
+func RoomOptionsInUnit(options []RoomOptions, unit controller.TemperatureUnit) []RoomOptions {
+	converted := make([]RoomOptions, 0, len(options))
+	for _, r := range options {
+		converted = append(converted, r.InUnit(unit))
+	}
+	return converted
+}
+
+// PatchToCelsius returns a patch of the options of a room, whose thresholds
+// are in the unit, with its thresholds in Celsius
+func PatchToCelsius(patch controller.RoomOptionsPatch, unit controller.TemperatureUnit) controller.RoomOptionsPatch {
+	if patch.ThresholdOn != nil {
+		thresholdOn := unit.ToCelsius(*patch.ThresholdOn)
+		patch.ThresholdOn = &thresholdOn
+	}
+	if patch.ThresholdOff != nil {
+		thresholdOff := unit.ToCelsius(*patch.ThresholdOff)
+		patch.ThresholdOff = &thresholdOff
+	}
+	return patch
+}
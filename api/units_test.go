@@ -0,0 +1,201 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/igvaquero18/smarthome/controller"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPreferences(t *testing.T) {
+	user := &jwt.Token{Claims: jwt.MapClaims{"sub": "admin"}, Valid: true}
+	testCases := []struct {
+		name         string
+		handler      func(cl *Client) echo.HandlerFunc
+		ctx          *baseMockContext
+		users        map[string]*controller.User
+		expectedCode int
+		expected     Preferences
+	}{
+		{
+			name:         "Get the default preferences",
+			handler:      func(cl *Client) echo.HandlerFunc { return cl.GetPreferences },
+			ctx:          &baseMockContext{User: user},
+			users:        map[string]*controller.User{"admin": {Username: "admin"}},
+			expectedCode: http.StatusOK,
+			expected:     Preferences{TemperatureUnit: controller.Celsius},
+		},
+		{
+			name:    "Get the preferences",
+			handler: func(cl *Client) echo.HandlerFunc { return cl.GetPreferences },
+			ctx:     &baseMockContext{User: user},
+			users: map[string]*controller.User{
+				"admin": {Username: "admin", TemperatureUnit: controller.Fahrenheit},
+			},
+			expectedCode: http.StatusOK,
+			expected:     Preferences{TemperatureUnit: controller.Fahrenheit},
+		},
+		{
+			name:         "Get the preferences of a user not found",
+			handler:      func(cl *Client) echo.HandlerFunc { return cl.GetPreferences },
+			ctx:          &baseMockContext{User: user},
+			users:        map[string]*controller.User{},
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "Get the preferences without token",
+			handler:      func(cl *Client) echo.HandlerFunc { return cl.GetPreferences },
+			ctx:          &baseMockContext{},
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "Set the temperature unit by its symbol",
+			handler:      func(cl *Client) echo.HandlerFunc { return cl.SetPreferences },
+			ctx:          &baseMockContext{User: user, Body: `{"temperature_unit": "°F"}`},
+			users:        map[string]*controller.User{"admin": {Username: "admin"}},
+			expectedCode: http.StatusOK,
+			expected:     Preferences{TemperatureUnit: controller.Fahrenheit},
+		},
+		{
+			name:         "Set an unknown temperature unit",
+			handler:      func(cl *Client) echo.HandlerFunc { return cl.SetPreferences },
+			ctx:          &baseMockContext{User: user, Body: `{"temperature_unit": "kelvin"}`},
+			users:        map[string]*controller.User{"admin": {Username: "admin"}},
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			sh := &mockSmartHome{Users: tc.users}
+			err := tc.handler(NewClient(JWTConfig{}, sh))(tc.ctx)
+			if tc.expectedCode != http.StatusOK {
+				httpErr, ok := err.(*echo.HTTPError)
+				if assert.True(tt, ok) {
+					assert.Equal(tt, tc.expectedCode, httpErr.Code)
+				}
+				return
+			}
+			assert.NoError(tt, err)
+			assert.Equal(tt, tc.expected, tc.ctx.GetJSONPayload())
+			assert.Equal(tt, tc.expected.TemperatureUnit, userPreferences(sh.Users["admin"]).TemperatureUnit)
+		})
+	}
+}
+
+func TestRoomOptionsUnit(t *testing.T) {
+	user := &jwt.Token{Claims: jwt.MapClaims{"sub": "admin"}, Valid: true}
+	testCases := []struct {
+		name         string
+		ctx          *baseMockContext
+		unit         controller.TemperatureUnit
+		expectedCode int
+		expected     RoomOptions
+	}{
+		{
+			name:         "Default unit",
+			ctx:          &baseMockContext{Parameter: "bedroom"},
+			expectedCode: http.StatusOK,
+			expected:     RoomOptions{Name: "bedroom", Enabled: true, ThresholdOn: 19.5, ThresholdOff: 21, Unit: controller.Celsius},
+		},
+		{
+			name:         "Unit query parameter",
+			ctx:          &baseMockContext{Parameter: "bedroom", Query: url.Values{"unit": []string{"F"}}},
+			expectedCode: http.StatusOK,
+			expected:     RoomOptions{Name: "bedroom", Enabled: true, ThresholdOn: 67.1, ThresholdOff: 69.8, Unit: controller.Fahrenheit},
+		},
+		{
+			name:         "Preference of the user",
+			ctx:          &baseMockContext{Parameter: "bedroom", User: user},
+			unit:         controller.Fahrenheit,
+			expectedCode: http.StatusOK,
+			expected:     RoomOptions{Name: "bedroom", Enabled: true, ThresholdOn: 67.1, ThresholdOff: 69.8, Unit: controller.Fahrenheit},
+		},
+		{
+			name:         "Unit query parameter overriding the preference of the user",
+			ctx:          &baseMockContext{Parameter: "bedroom", User: user, Query: url.Values{"unit": []string{"celsius"}}},
+			unit:         controller.Fahrenheit,
+			expectedCode: http.StatusOK,
+			expected:     RoomOptions{Name: "bedroom", Enabled: true, ThresholdOn: 19.5, ThresholdOff: 21, Unit: controller.Celsius},
+		},
+		{
+			name:         "Unknown unit",
+			ctx:          &baseMockContext{Parameter: "bedroom", Query: url.Values{"unit": []string{"kelvin"}}},
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			cl := NewClient(JWTConfig{}, &mockSmartHome{
+				BedroomOpts: map[string]types.AttributeValue{
+					"ThresholdOn":  &types.AttributeValueMemberN{Value: "19.5"},
+					"ThresholdOff": &types.AttributeValueMemberN{Value: "21.0"},
+					"Enabled":      &types.AttributeValueMemberBOOL{Value: true},
+				},
+				Users: map[string]*controller.User{"admin": {Username: "admin", TemperatureUnit: tc.unit}},
+			})
+			err := cl.GetRoomOptions(tc.ctx)
+			if tc.expectedCode != http.StatusOK {
+				httpErr, ok := err.(*echo.HTTPError)
+				if assert.True(tt, ok) {
+					assert.Equal(tt, tc.expectedCode, httpErr.Code)
+				}
+				return
+			}
+			assert.NoError(tt, err)
+			assert.Equal(tt, tc.expected, tc.ctx.GetJSONPayload())
+		})
+	}
+}
+
+func TestPatchToCelsius(t *testing.T) {
+	enabled, thresholdOn, thresholdOff := true, float32(68), float32(212)
+	patch := controller.RoomOptionsPatch{Enabled: &enabled, ThresholdOn: &thresholdOn, ThresholdOff: &thresholdOff}
+
+	celsius := PatchToCelsius(patch, controller.Fahrenheit)
+	assert.Equal(t, &enabled, celsius.Enabled)
+	assert.Equal(t, float32(20), *celsius.ThresholdOn)
+	assert.Equal(t, float32(100), *celsius.ThresholdOff)
+	// The patch is left unchanged
+	assert.Equal(t, float32(68), *patch.ThresholdOn)
+
+	assert.Equal(t, patch, PatchToCelsius(patch, controller.Celsius))
+	assert.Nil(t, PatchToCelsius(controller.RoomOptionsPatch{Enabled: &enabled}, controller.Fahrenheit).ThresholdOn)
+}
+
+func TestTemperatureUnit(t *testing.T) {
+	sh := &mockSmartHome{Users: map[string]*controller.User{
+		"admin": {Username: "admin", TemperatureUnit: controller.Fahrenheit},
+		"alice": {Username: "alice"},
+	}}
+	testCases := []struct {
+		name          string
+		value         string
+		username      string
+		expected      controller.TemperatureUnit
+		expectedError bool
+	}{
+		{name: "Unit query parameter", value: "F", expected: controller.Fahrenheit},
+		{name: "Preference of the user", username: "admin", expected: controller.Fahrenheit},
+		{name: "User without preference", username: "alice", expected: controller.Celsius},
+		{name: "Unknown user", username: "bob", expected: controller.Celsius},
+		{name: "No user", expected: controller.Celsius},
+		{name: "Unit query parameter overriding the preference of the user", value: "C", username: "admin", expected: controller.Celsius},
+		{name: "Unknown unit", value: "kelvin", username: "admin", expectedError: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			unit, err := TemperatureUnit(sh, tc.value, tc.username)
+			if tc.expectedError {
+				assert.Error(tt, err)
+				return
+			}
+			assert.NoError(tt, err)
+			assert.Equal(tt, tc.expected, unit)
+		})
+	}
+}
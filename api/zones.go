@@ -0,0 +1,231 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/igvaquero18/smarthome/utils"
+	"github.com/labstack/echo/v4"
+)
+
+const zoneParam = "zone"
+
+// ZoneRequest is the payload for creating a zone or replacing its rooms. The
+// name is only used when creating a zone.
+type ZoneRequest struct {
+	Name  string   `json:"name,omitempty"`
+	Rooms []string `json:"rooms"`
+}
+
+// CreateZone creates a zone with some of the valid rooms
+func (cl *Client) CreateZone(c echo.Context) error {
+	params, err := parseZoneRequest(c)
+	if err != nil {
+		return err
+	}
+	zone, err := cl.SmartHomeInterface.CreateZone(params.Name, params.Rooms)
+	if err != nil {
+		return NewHTTPError(err, "Error creating zone")
+	}
+	return c.JSON(http.StatusCreated, zone)
+}
+
+// ListZones returns every zone
+func (cl *Client) ListZones(c echo.Context) error {
+	zones, err := cl.SmartHomeInterface.ListZones()
+	if err != nil {
+		return NewHTTPError(err, "Error listing zones")
+	}
+	return c.JSON(http.StatusOK, zones)
+}
+
+// GetZone returns a zone with its rooms
+func (cl *Client) GetZone(c echo.Context) error {
+	zone, err := cl.SmartHomeInterface.GetZone(zoneName(c))
+	if err != nil {
+		return NewHTTPError(err, "Error getting zone")
+	}
+	return c.JSON(http.StatusOK, zone)
+}
+
+// UpdateZone replaces the rooms of a zone
+func (cl *Client) UpdateZone(c echo.Context) error {
+	params, err := parseZoneRequest(c)
+	if err != nil {
+		return err
+	}
+	name := zoneName(c)
+	if params.Name != "" && params.Name != name {
+		return echo.NewHTTPError(http.StatusBadRequest, "The name of a zone can't be changed")
+	}
+	zone, err := cl.SmartHomeInterface.SetZoneRooms(name, params.Rooms)
+	if err != nil {
+		return NewHTTPError(err, "Error updating zone")
+	}
+	return c.JSON(http.StatusOK, zone)
+}
+
+// DeleteZone deletes a zone, keeping the options of its rooms
+func (cl *Client) DeleteZone(c echo.Context) error {
+	name := zoneName(c)
+	if err := cl.SmartHomeInterface.DeleteZone(name); err != nil {
+		return NewHTTPError(err, "Error deleting zone")
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message":     "successfully deleted zone",
+		"status_code": http.StatusOK,
+		"zone":        name,
+	})
+}
+
+// GetZoneOptions returns the options of the rooms of a zone that have any,
+// the same way as the room "all" does for every room
+func (cl *Client) GetZoneOptions(c echo.Context) error {
+	rooms, err := cl.zoneRooms(c)
+	if err != nil {
+		return err
+	}
+	unit, err := cl.temperatureUnit(c)
+	if err != nil {
+		return err
+	}
+	roomOpts, err := ListRoomOptions(cl.SmartHomeInterface, rooms)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, RoomOptionsInUnit(WithEffectiveThresholds(cl.SmartHomeInterface, roomOpts), unit))
+}
+
+// SetZoneOptions sets the same options for every room of a zone at once
+func (cl *Client) SetZoneOptions(c echo.Context) error {
+	rooms, err := cl.zoneRooms(c)
+	if err != nil {
+		return err
+	}
+
+	unit, err := cl.temperatureUnit(c)
+	if err != nil {
+		return err
+	}
+
+	r := new(RoomOptions)
+	if err := json.NewDecoder(c.Request().Body).Decode(&r); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	r.Name, r.Version, r.Effective = "", 0, nil
+	*r = r.ToCelsius(unit)
+
+	if err := SetRoomsOptions(cl.SmartHomeInterface, rooms, *r); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, struct {
+		Message string      `json:"message"`
+		Code    int         `json:"status_code"`
+		Options RoomOptions `json:"options"`
+	}{
+		Message: "successfully set room options",
+		Code:    http.StatusOK,
+		Options: r.InUnit(unit),
+	})
+}
+
+// PatchZoneOptions updates some of the options of the rooms of a zone that
+// have any with a JSON merge patch (RFC 7396)
+func (cl *Client) PatchZoneOptions(c echo.Context) error {
+	rooms, err := cl.zoneRooms(c)
+	if err != nil {
+		return err
+	}
+
+	body, err := ioutil.ReadAll(c.Request().Body)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	patch, err := ParseRoomOptionsPatch(zoneName(c), c.Request().Header.Get(echo.HeaderContentType), body)
+	if err != nil {
+		return err
+	}
+
+	unit, err := cl.temperatureUnit(c)
+	if err != nil {
+		return err
+	}
+
+	options, err := UpdateRoomsOptions(cl.SmartHomeInterface, rooms, PatchToCelsius(patch, unit))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, NewPatchRoomOptionsResponse(AllRooms, RoomOptionsInUnit(options, unit)))
+}
+
+// DeleteZoneOptions deletes the options of every room of a zone at once
+func (cl *Client) DeleteZoneOptions(c echo.Context) error {
+	rooms, err := cl.zoneRooms(c)
+	if err != nil {
+		return err
+	}
+	if err := DeleteRoomsOptions(cl.SmartHomeInterface, rooms); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message":     "successfully deleted room options",
+		"status_code": http.StatusOK,
+		"zone":        zoneName(c),
+	})
+}
+
+// zoneRooms returns the rooms of the zone of the request that are still
+// valid. As with the room "all", writes can't be conditional.
+func (cl *Client) zoneRooms(c echo.Context) ([]string, error) {
+	name := zoneName(c)
+	if c.Request().Header.Get(HeaderIfMatch) != "" {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "If-Match is not supported for zones")
+	}
+
+	zone, err := cl.SmartHomeInterface.GetZone(name)
+	if err != nil {
+		return nil, NewHTTPError(err, "Error getting zone")
+	}
+
+	rooms := []string{}
+	for _, room := range zone.Rooms {
+		if utils.Contains(EveryRoom(), room) {
+			rooms = append(rooms, room)
+		}
+	}
+	if len(rooms) == 0 {
+		return nil, echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Zone %s has no valid rooms", name))
+	}
+	return rooms, nil
+}
+
+// zoneName returns the name of the zone of the request. echo doesn't unescape
+// path parameters, and zone names can have spaces.
+func zoneName(c echo.Context) string {
+	name, err := url.PathUnescape(c.Param(zoneParam))
+	if err != nil {
+		return c.Param(zoneParam)
+	}
+	return name
+}
+
+// parseZoneRequest parses the payload of a request creating or updating a
+// zone, checking that its rooms are valid
+func parseZoneRequest(c echo.Context) (*ZoneRequest, error) {
+	params := new(ZoneRequest)
+	if err := json.NewDecoder(c.Request().Body).Decode(&params); err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid payload: %s", err.Error()))
+	}
+	for _, room := range params.Rooms {
+		if !utils.Contains(EveryRoom(), room) {
+			return nil, NewInvalidRoomError(room)
+		}
+	}
+	return params, nil
+}
@@ -0,0 +1,222 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/igvaquero18/smarthome/controller"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateZone(t *testing.T) {
+	testCases := []struct {
+		name         string
+		body         string
+		expectedCode int
+		expected     *controller.Zone
+	}{
+		{
+			name:         "Valid zone",
+			body:         `{"name": "sleeping areas", "rooms": ["bedroom"]}`,
+			expectedCode: http.StatusCreated,
+			expected:     &controller.Zone{Name: "sleeping areas", Rooms: []string{"bedroom"}},
+		},
+		{
+			name:         "Existing zone",
+			body:         `{"name": "upstairs", "rooms": ["bedroom"]}`,
+			expectedCode: http.StatusConflict,
+		},
+		{
+			name:         "Invalid room",
+			body:         `{"name": "downstairs", "rooms": ["kitchen"]}`,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Every room",
+			body:         `{"name": "downstairs", "rooms": ["all"]}`,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Invalid payload",
+			body:         `{"name": "downstairs", "rooms": "livingroom"}`,
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			ctx := &baseMockContext{Body: tc.body}
+			sh := &mockSmartHome{Zones: map[string]*controller.Zone{"upstairs": {Name: "upstairs", Rooms: []string{"bedroom"}}}}
+			err := NewClient(JWTConfig{}, sh).CreateZone(ctx)
+			if tc.expectedCode != http.StatusCreated {
+				assert.Error(tt, err)
+				assert.Equal(tt, tc.expectedCode, StatusCode(err))
+				return
+			}
+			assert.NoError(tt, err)
+			assert.Equal(tt, tc.expected, ctx.GetJSONPayload())
+		})
+	}
+}
+
+func TestUpdateZone(t *testing.T) {
+	testCases := []struct {
+		name         string
+		zone         string
+		body         string
+		expectedCode int
+	}{
+		{
+			name:         "Valid zone",
+			zone:         "upstairs",
+			body:         `{"rooms": ["bedroom", "livingroom"]}`,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Same name",
+			zone:         "upstairs",
+			body:         `{"name": "upstairs", "rooms": ["bedroom"]}`,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Another name",
+			zone:         "upstairs",
+			body:         `{"name": "downstairs", "rooms": ["bedroom"]}`,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Zone not found",
+			zone:         "downstairs",
+			body:         `{"rooms": ["bedroom"]}`,
+			expectedCode: http.StatusNotFound,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			ctx := &baseMockContext{Body: tc.body, Parameter: tc.zone}
+			sh := &mockSmartHome{Zones: map[string]*controller.Zone{"upstairs": {Name: "upstairs", Rooms: []string{"bedroom"}}}}
+			err := NewClient(JWTConfig{}, sh).UpdateZone(ctx)
+			if tc.expectedCode != http.StatusOK {
+				assert.Error(tt, err)
+				assert.Equal(tt, tc.expectedCode, StatusCode(err))
+				return
+			}
+			assert.NoError(tt, err)
+		})
+	}
+}
+
+func TestZoneOptions(t *testing.T) {
+	bedroomOpts := map[string]types.AttributeValue{
+		"ThresholdOn":  &types.AttributeValueMemberN{Value: "19.3"},
+		"ThresholdOff": &types.AttributeValueMemberN{Value: "19.5"},
+		"Enabled":      &types.AttributeValueMemberBOOL{Value: true},
+	}
+	zones := map[string]*controller.Zone{
+		"upstairs": {Name: "upstairs", Rooms: []string{"bedroom"}},
+		"attic":    {Name: "attic", Rooms: []string{"removed"}},
+	}
+	enabled := false
+
+	testCases := []struct {
+		name            string
+		handler         func(*Client, echo.Context) error
+		ctx             mockContext
+		sh              *mockSmartHome
+		expectedCode    int
+		expectedChanges []controller.RoomChange
+	}{
+		{
+			name:         "Get",
+			handler:      (*Client).GetZoneOptions,
+			ctx:          &baseMockContext{Parameter: "upstairs"},
+			sh:           &mockSmartHome{BedroomOpts: bedroomOpts, Zones: zones},
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Get a zone without options",
+			handler:      (*Client).GetZoneOptions,
+			ctx:          &baseMockContext{Parameter: "upstairs"},
+			sh:           &mockSmartHome{Zones: zones},
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "Get a zone without valid rooms",
+			handler:      (*Client).GetZoneOptions,
+			ctx:          &baseMockContext{Parameter: "attic"},
+			sh:           &mockSmartHome{BedroomOpts: bedroomOpts, Zones: zones},
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "Get a zone not found",
+			handler:      (*Client).GetZoneOptions,
+			ctx:          &baseMockContext{Parameter: "downstairs"},
+			sh:           &mockSmartHome{BedroomOpts: bedroomOpts, Zones: zones},
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "Set",
+			handler:      (*Client).SetZoneOptions,
+			ctx:          &baseMockContext{Parameter: "upstairs", Body: `{"enabled": false, "threshold_on": 19, "threshold_off": 21}`},
+			sh:           &mockSmartHome{Zones: zones},
+			expectedCode: http.StatusOK,
+			expectedChanges: []controller.RoomChange{{
+				Room:    "bedroom",
+				Action:  controller.RoomActionSet,
+				Options: controller.RoomOptionsPatch{Enabled: &enabled, ThresholdOn: float32Ptr(19), ThresholdOff: float32Ptr(21)},
+			}},
+		},
+		{
+			name:         "Set with If-Match",
+			handler:      (*Client).SetZoneOptions,
+			ctx:          &baseMockContext{Parameter: "upstairs", Body: `{"enabled": false}`, Header: http.Header{HeaderIfMatch: []string{`"1"`}}},
+			sh:           &mockSmartHome{Zones: zones},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Patch",
+			handler:      (*Client).PatchZoneOptions,
+			ctx:          &baseMockContext{Parameter: "upstairs", Body: `{"enabled": false}`},
+			sh:           &mockSmartHome{BedroomOpts: bedroomOpts, Zones: zones},
+			expectedCode: http.StatusOK,
+			expectedChanges: []controller.RoomChange{{
+				Room:    "bedroom",
+				Action:  controller.RoomActionUpdate,
+				Options: controller.RoomOptionsPatch{Enabled: &enabled},
+			}},
+		},
+		{
+			name:            "Delete",
+			handler:         (*Client).DeleteZoneOptions,
+			ctx:             &baseMockContext{Parameter: "upstairs"},
+			sh:              &mockSmartHome{Zones: zones},
+			expectedCode:    http.StatusOK,
+			expectedChanges: []controller.RoomChange{{Room: "bedroom", Action: controller.RoomActionDelete}},
+		},
+		{
+			name:         "Controller errors",
+			handler:      (*Client).DeleteZoneOptions,
+			ctx:          &baseMockContext{Parameter: "upstairs"},
+			sh:           &mockSmartHome{Zones: zones, Err: fmt.Errorf("Error")},
+			expectedCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			err := tc.handler(NewClient(JWTConfig{}, tc.sh), tc.ctx)
+			if tc.expectedCode != http.StatusOK {
+				assert.Error(tt, err)
+				assert.Equal(tt, tc.expectedCode, StatusCode(err))
+				return
+			}
+			assert.NoError(tt, err)
+			assert.Equal(tt, tc.expectedChanges, tc.sh.RoomChanges)
+		})
+	}
+}
+
+func float32Ptr(f float32) *float32 {
+	return &f
+}
@@ -0,0 +1,679 @@
+// Package client is a Go client for the SmartHome API
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/igvaquero18/smarthome/api"
+	"github.com/igvaquero18/smarthome/controller"
+)
+
+const (
+	// DefaultMaxRetries is the number of times failed requests are retried
+	// unless configured otherwise
+	DefaultMaxRetries = 3
+
+	// DefaultMinBackoff is the wait before the first retry. It doubles on
+	// every retry, up to DefaultMaxBackoff.
+	DefaultMinBackoff = 100 * time.Millisecond
+
+	// DefaultMaxBackoff is the longest wait between retries. Requests
+	// throttled for longer than that are not retried.
+	DefaultMaxBackoff = 5 * time.Second
+
+	// tokenRefreshMargin is how long before its expiration a token is renewed
+	tokenRefreshMargin = 30 * time.Second
+)
+
+// Error is returned when the SmartHome API responds with an error
+type Error struct {
+	StatusCode int
+
+	// Code is the machine-readable code of the error, such as not_found
+	Code    string
+	Message string
+	Details map[string]interface{}
+
+	// RequestID identifies the request in the logs of the API
+	RequestID string
+
+	// RetryAfter is how long the API asked to wait before trying again, if it did
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("smarthome: %d %s", e.StatusCode, e.Message)
+}
+
+// Client is a client for the SmartHome API. It logs in with the configured
+// credentials when needed, caching the token until it's about to expire.
+// It's safe for concurrent use.
+type Client struct {
+	baseURL    *url.URL
+	httpClient *http.Client
+	apiKey     string
+	maxRetries int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	unit       controller.TemperatureUnit
+
+	mu          sync.Mutex
+	credentials *api.Auth
+	token       string
+	tokenExpiry time.Time
+}
+
+// Option is a function to apply settings to the Client
+type Option func(c *Client) Option
+
+// New returns a client for the SmartHome API listening at baseURL, such as
+// https://smarthome.example.com
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL %s: %w", baseURL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid base URL %s: the scheme must be http or https", baseURL)
+	}
+	c := &Client{
+		baseURL:    u,
+		httpClient: http.DefaultClient,
+		maxRetries: DefaultMaxRetries,
+		minBackoff: DefaultMinBackoff,
+		maxBackoff: DefaultMaxBackoff,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// SetHTTPClient sets the HTTP client used for the requests, e.g. for
+// configuring timeouts or client certificates
+func SetHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) Option {
+		prev := c.httpClient
+		if httpClient != nil {
+			c.httpClient = httpClient
+		}
+		return SetHTTPClient(prev)
+	}
+}
+
+// SetCredentials sets the username and password the client logs in with
+// whenever it needs a token
+func SetCredentials(username, password string) Option {
+	return func(c *Client) Option {
+		prev := c.credentials
+		c.credentials = &api.Auth{Username: username, Password: password}
+		return setAuth(prev)
+	}
+}
+
+func setAuth(auth *api.Auth) Option {
+	return func(c *Client) Option {
+		prev := c.credentials
+		c.credentials = auth
+		return setAuth(prev)
+	}
+}
+
+// SetAPIKey sets the API key sent in every request instead of a token. Only
+// the room endpoints accept API keys.
+func SetAPIKey(key string) Option {
+	return func(c *Client) Option {
+		prev := c.apiKey
+		c.apiKey = key
+		return SetAPIKey(prev)
+	}
+}
+
+// SetRetries sets how many times failed requests are retried, and the
+// minimum and maximum wait between retries
+func SetRetries(maxRetries int, minBackoff, maxBackoff time.Duration) Option {
+	return func(c *Client) Option {
+		prevRetries, prevMin, prevMax := c.maxRetries, c.minBackoff, c.maxBackoff
+		c.maxRetries, c.minBackoff, c.maxBackoff = maxRetries, minBackoff, maxBackoff
+		return SetRetries(prevRetries, prevMin, prevMax)
+	}
+}
+
+// SetTemperatureUnit sets the unit of the thresholds of the options of the
+// rooms sent and returned by the client. The preference of the logged in
+// user is used if it's empty.
+func SetTemperatureUnit(unit controller.TemperatureUnit) Option {
+	return func(c *Client) Option {
+		prev := c.unit
+		c.unit = unit
+		return SetTemperatureUnit(prev)
+	}
+}
+
+// Login logs in, caching the token for the next requests. The credentials
+// are kept for renewing the token when it expires, unless they include a
+// two-factor authentication code, which can't be reused.
+func (c *Client) Login(ctx context.Context, auth api.Auth) error {
+	token, err := c.login(ctx, auth)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if auth.OTP == "" {
+		c.credentials = &auth
+	}
+	c.setToken(token)
+	return nil
+}
+
+// Token returns the cached token, logging in with the configured credentials
+// if there is no token or it's about to expire
+func (c *Client) Token(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	token, expiry, credentials := c.token, c.tokenExpiry, c.credentials
+	c.mu.Unlock()
+	if token != "" && (expiry.IsZero() || time.Until(expiry) > tokenRefreshMargin) {
+		return token, nil
+	}
+	if credentials == nil {
+		if token != "" {
+			return token, nil
+		}
+		return "", fmt.Errorf("smarthome: not logged in and no credentials configured")
+	}
+	return c.refreshToken(ctx, *credentials)
+}
+
+func (c *Client) refreshToken(ctx context.Context, credentials api.Auth) (string, error) {
+	token, err := c.login(ctx, credentials)
+	if err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.setToken(token)
+	return token, nil
+}
+
+func (c *Client) login(ctx context.Context, auth api.Auth) (string, error) {
+	var response struct {
+		Token string `json:"token"`
+	}
+	if err := c.do(ctx, http.MethodPost, api.APIVersion+"/login", auth, nil, false, false, &response); err != nil {
+		return "", err
+	}
+	return response.Token, nil
+}
+
+// setToken caches the token with its expiration. It must be called with the mutex held.
+func (c *Client) setToken(token string) {
+	c.token = token
+	c.tokenExpiry = time.Time{}
+	claims := jwt.MapClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(token, claims); err != nil {
+		return
+	}
+	if exp, ok := claims["exp"].(float64); ok {
+		c.tokenExpiry = time.Unix(int64(exp), 0)
+	}
+}
+
+// SignUp creates a user. Only admins can create users.
+func (c *Client) SignUp(ctx context.Context, auth api.Auth) error {
+	return c.do(ctx, http.MethodPost, api.APIVersion+"/signup", auth, nil, true, false, nil)
+}
+
+// DeleteUser deletes a user. Only admins can delete users.
+func (c *Client) DeleteUser(ctx context.Context, username string) error {
+	return c.do(ctx, http.MethodDelete, api.APIVersion+"/user", api.Auth{Username: username}, nil, true, true, nil)
+}
+
+// ChangePassword changes the password of the logged in user, updating the
+// credentials used for renewing the token
+func (c *Client) ChangePassword(ctx context.Context, oldPassword, newPassword string) error {
+	change := api.PasswordChange{OldPassword: oldPassword, NewPassword: newPassword}
+	if err := c.do(ctx, http.MethodPut, api.APIVersion+"/user/password", change, nil, true, false, nil); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.credentials != nil {
+		c.credentials = &api.Auth{Username: c.credentials.Username, Password: newPassword}
+	}
+	return nil
+}
+
+// UnlockUser forgets the failed login attempts of a user. Only admins can unlock users.
+func (c *Client) UnlockUser(ctx context.Context, username string) error {
+	return c.do(ctx, http.MethodPost, api.APIVersion+"/user/unlock", api.Auth{Username: username}, nil, true, true, nil)
+}
+
+// GetPreferences returns the preferences of the logged in user
+func (c *Client) GetPreferences(ctx context.Context) (*api.Preferences, error) {
+	preferences := &api.Preferences{}
+	if err := c.do(ctx, http.MethodGet, api.APIVersion+"/user/preferences", nil, nil, true, true, preferences); err != nil {
+		return nil, err
+	}
+	return preferences, nil
+}
+
+// SetPreferences changes the preferences of the logged in user
+func (c *Client) SetPreferences(ctx context.Context, preferences api.Preferences) error {
+	return c.do(ctx, http.MethodPut, api.APIVersion+"/user/preferences", preferences, nil, true, true, nil)
+}
+
+// GetRoomOptions returns the options of a room
+func (c *Client) GetRoomOptions(ctx context.Context, room string) (*api.RoomOptions, error) {
+	options := &api.RoomOptions{}
+	if err := c.do(ctx, http.MethodGet, roomPath(room), nil, nil, true, true, options); err != nil {
+		return nil, err
+	}
+	return options, nil
+}
+
+// ListRoomOptions returns the options of every room that has any
+func (c *Client) ListRoomOptions(ctx context.Context) ([]api.RoomOptions, error) {
+	options := []api.RoomOptions{}
+	if err := c.do(ctx, http.MethodGet, roomPath(api.AllRooms), nil, nil, true, true, &options); err != nil {
+		return nil, err
+	}
+	return options, nil
+}
+
+// SetRoomOptions sets the options of a room, or of every room if room is
+// api.AllRooms. If options has the Version returned by GetRoomOptions, they're
+// only set if the room still has that version, failing with a 412 Error
+// otherwise, so that changes made by others aren't overwritten.
+func (c *Client) SetRoomOptions(ctx context.Context, room string, options api.RoomOptions) (*api.RoomOptions, error) {
+	var response struct {
+		Options api.RoomOptions `json:"options"`
+	}
+	if err := c.do(ctx, http.MethodPost, roomPath(room), options, ifMatch(options.Version), true, true, &response); err != nil {
+		return nil, err
+	}
+	return &response.Options, nil
+}
+
+// RoomOptionsPatch is a partial update of the options of a room, in which nil
+// fields are left unchanged
+type RoomOptionsPatch struct {
+	Enabled      *bool    `json:"enabled,omitempty"`
+	ThresholdOn  *float32 `json:"threshold_on,omitempty"`
+	ThresholdOff *float32 `json:"threshold_off,omitempty"`
+
+	// Version, if not 0, is the version the room must have for the patch
+	// to be applied, like the Version of api.RoomOptions for SetRoomOptions
+	Version int64 `json:"-"`
+}
+
+// PatchRoomOptions updates the options of a room set in the patch, leaving the
+// others unchanged, and returns the options of the room after the update
+func (c *Client) PatchRoomOptions(ctx context.Context, room string, patch RoomOptionsPatch) (*api.RoomOptions, error) {
+	var response struct {
+		Options api.RoomOptions `json:"options"`
+	}
+	if err := c.do(ctx, http.MethodPatch, roomPath(room), patch, ifMatch(patch.Version), true, true, &response); err != nil {
+		return nil, err
+	}
+	return &response.Options, nil
+}
+
+// PatchAllRoomOptions updates the options set in the patch of every room that
+// has any, and returns the options of the rooms after the update
+func (c *Client) PatchAllRoomOptions(ctx context.Context, patch RoomOptionsPatch) ([]api.RoomOptions, error) {
+	var response struct {
+		Options []api.RoomOptions `json:"options"`
+	}
+	if err := c.do(ctx, http.MethodPatch, roomPath(api.AllRooms), patch, nil, true, true, &response); err != nil {
+		return nil, err
+	}
+	return response.Options, nil
+}
+
+// DeleteRoomOptions deletes the options of a room, or of every room if room is api.AllRooms
+func (c *Client) DeleteRoomOptions(ctx context.Context, room string) error {
+	return c.do(ctx, http.MethodDelete, roomPath(room), nil, nil, true, true, nil)
+}
+
+// DeleteRoomOptionsVersion deletes the options of a room only if it has the
+// version, failing with a 412 Error otherwise
+func (c *Client) DeleteRoomOptionsVersion(ctx context.Context, room string, version int64) error {
+	return c.do(ctx, http.MethodDelete, roomPath(room), nil, ifMatch(version), true, true, nil)
+}
+
+// RoomChange is a change of the options of a room in a BatchRoomOptions
+type RoomChange struct {
+	Room string `json:"room"`
+
+	// Action is one of set, update or delete
+	Action string `json:"action"`
+
+	// Options are the options to set, all of which are required, or to
+	// update. Deletes take no options.
+	Options *RoomOptionsPatch `json:"options,omitempty"`
+
+	// Version, if not 0, is the version the room must have for the batch to
+	// be applied
+	Version int64 `json:"version,omitempty"`
+}
+
+// BatchRoomOptions applies the changes of the options of several rooms at
+// once: either all of them are applied or none is. It returns the options
+// of the rooms that were set or updated.
+func (c *Client) BatchRoomOptions(ctx context.Context, changes []RoomChange) ([]api.RoomOptions, error) {
+	var response struct {
+		Options []api.RoomOptions `json:"options"`
+	}
+	in := struct {
+		Changes []RoomChange `json:"changes"`
+	}{Changes: changes}
+	if err := c.do(ctx, http.MethodPost, api.APIVersion+"/rooms:batch", in, nil, true, true, &response); err != nil {
+		return nil, err
+	}
+	return response.Options, nil
+}
+
+// CreateZone creates a zone with some of the rooms
+func (c *Client) CreateZone(ctx context.Context, name string, rooms []string) (*controller.Zone, error) {
+	zone := &controller.Zone{}
+	in := api.ZoneRequest{Name: name, Rooms: rooms}
+	if err := c.do(ctx, http.MethodPost, zonesPath(), in, nil, true, false, zone); err != nil {
+		return nil, err
+	}
+	return zone, nil
+}
+
+// ListZones returns every zone
+func (c *Client) ListZones(ctx context.Context) ([]controller.Zone, error) {
+	zones := []controller.Zone{}
+	if err := c.do(ctx, http.MethodGet, zonesPath(), nil, nil, true, true, &zones); err != nil {
+		return nil, err
+	}
+	return zones, nil
+}
+
+// GetZone returns a zone with its rooms
+func (c *Client) GetZone(ctx context.Context, name string) (*controller.Zone, error) {
+	zone := &controller.Zone{}
+	if err := c.do(ctx, http.MethodGet, zonesPath(name), nil, nil, true, true, zone); err != nil {
+		return nil, err
+	}
+	return zone, nil
+}
+
+// UpdateZone replaces the rooms of a zone
+func (c *Client) UpdateZone(ctx context.Context, name string, rooms []string) (*controller.Zone, error) {
+	zone := &controller.Zone{}
+	in := api.ZoneRequest{Rooms: rooms}
+	if err := c.do(ctx, http.MethodPut, zonesPath(name), in, nil, true, true, zone); err != nil {
+		return nil, err
+	}
+	return zone, nil
+}
+
+// DeleteZone deletes a zone, keeping the options of its rooms
+func (c *Client) DeleteZone(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodDelete, zonesPath(name), nil, nil, true, true, nil)
+}
+
+// GetZoneOptions returns the options of the rooms of a zone that have any
+func (c *Client) GetZoneOptions(ctx context.Context, name string) ([]api.RoomOptions, error) {
+	options := []api.RoomOptions{}
+	if err := c.do(ctx, http.MethodGet, zonesPath(name, "options"), nil, nil, true, true, &options); err != nil {
+		return nil, err
+	}
+	return options, nil
+}
+
+// SetZoneOptions sets the same options for every room of a zone at once
+func (c *Client) SetZoneOptions(ctx context.Context, name string, options api.RoomOptions) error {
+	options.Version = 0
+	return c.do(ctx, http.MethodPost, zonesPath(name, "options"), options, nil, true, true, nil)
+}
+
+// PatchZoneOptions updates the options set in the patch of the rooms of a
+// zone that have any, and returns the options of the rooms after the update
+func (c *Client) PatchZoneOptions(ctx context.Context, name string, patch RoomOptionsPatch) ([]api.RoomOptions, error) {
+	var response struct {
+		Options []api.RoomOptions `json:"options"`
+	}
+	if err := c.do(ctx, http.MethodPatch, zonesPath(name, "options"), patch, nil, true, true, &response); err != nil {
+		return nil, err
+	}
+	return response.Options, nil
+}
+
+// DeleteZoneOptions deletes the options of every room of a zone at once
+func (c *Client) DeleteZoneOptions(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodDelete, zonesPath(name, "options"), nil, nil, true, true, nil)
+}
+
+// ReadingsQuery selects the readings returned by GetReadings. The source
+// defaults to inside, and the range to the last day.
+type ReadingsQuery struct {
+	Source controller.ReadingSource
+	Room   string
+	From   time.Time
+	To     time.Time
+}
+
+// GetReadings returns the readings of a room, or of the outside temperature,
+// in buckets whose resolution depends on the length of the range
+func (c *Client) GetReadings(ctx context.Context, query ReadingsQuery) (*api.Readings, error) {
+	params := url.Values{}
+	if query.Source != "" {
+		params.Set("source", string(query.Source))
+	}
+	if query.Room != "" {
+		params.Set("room", query.Room)
+	}
+	if !query.From.IsZero() {
+		params.Set("from", query.From.Format(time.RFC3339))
+	}
+	if !query.To.IsZero() {
+		params.Set("to", query.To.Format(time.RFC3339))
+	}
+	readings := &api.Readings{}
+	if err := c.do(ctx, http.MethodGet, api.APIVersion+"/readings?"+params.Encode(), nil, nil, true, true, readings); err != nil {
+		return nil, err
+	}
+	return readings, nil
+}
+
+// ifMatch returns the If-Match header requiring a version of the options of
+// a room, or no header for version 0
+func ifMatch(version int64) http.Header {
+	if version == 0 {
+		return nil
+	}
+	return http.Header{api.HeaderIfMatch: []string{api.ETag(version)}}
+}
+
+func roomPath(room string) string {
+	return api.APIVersion + "/room/" + url.PathEscape(room)
+}
+
+func zonesPath(segments ...string) string {
+	path := api.APIVersion + "/zones"
+	for _, segment := range segments {
+		path += "/" + url.PathEscape(segment)
+	}
+	return path
+}
+
+// do sends a request to the API, decoding the JSON response into out if it's
+// not nil. Authenticated requests carry the API key or a token, which is
+// renewed once if the API rejects it. Idempotent requests are retried on
+// network errors and when the API is unavailable, and any request is retried
+// when it's throttled for a short time, as it wasn't processed.
+func (c *Client) do(ctx context.Context, method, path string, in interface{}, header http.Header, authenticated, idempotent bool, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return fmt.Errorf("smarthome: error encoding the request: %w", err)
+		}
+	}
+
+	refreshed := false
+	for attempt := 0; ; attempt++ {
+		var token string
+		if authenticated && c.apiKey == "" {
+			var err error
+			if token, err = c.Token(ctx); err != nil {
+				return err
+			}
+		}
+
+		res, err := c.send(ctx, method, path, body, header, token)
+		if err != nil {
+			if ctx.Err() != nil || !idempotent || attempt >= c.maxRetries {
+				return fmt.Errorf("smarthome: %s %s: %w", method, path, err)
+			}
+			if err := c.wait(ctx, c.backoff(attempt)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		data, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return fmt.Errorf("smarthome: error reading the response: %w", err)
+		}
+		if res.StatusCode >= http.StatusOK && res.StatusCode < http.StatusMultipleChoices {
+			if out == nil || len(data) == 0 {
+				return nil
+			}
+			if err := json.Unmarshal(data, out); err != nil {
+				return fmt.Errorf("smarthome: error decoding the response: %w", err)
+			}
+			return nil
+		}
+
+		apiErr := responseError(res, data)
+		c.mu.Lock()
+		credentials := c.credentials
+		c.mu.Unlock()
+		if res.StatusCode == http.StatusUnauthorized && token != "" && credentials != nil && !refreshed {
+			refreshed = true
+			if _, err := c.refreshToken(ctx, *credentials); err != nil {
+				return err
+			}
+			attempt--
+			continue
+		}
+		if attempt >= c.maxRetries {
+			return apiErr
+		}
+		switch {
+		case res.StatusCode == http.StatusTooManyRequests && apiErr.RetryAfter <= c.maxBackoff:
+			wait := apiErr.RetryAfter
+			if wait == 0 {
+				wait = c.backoff(attempt)
+			}
+			if err := c.wait(ctx, wait); err != nil {
+				return err
+			}
+		case idempotent && (res.StatusCode == http.StatusBadGateway ||
+			res.StatusCode == http.StatusServiceUnavailable ||
+			res.StatusCode == http.StatusGatewayTimeout):
+			if err := c.wait(ctx, c.backoff(attempt)); err != nil {
+				return err
+			}
+		default:
+			return apiErr
+		}
+	}
+}
+
+func (c *Client) send(ctx context.Context, method, path string, body []byte, header http.Header, token string) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	u := *c.baseURL
+	query := url.Values{}
+	if i := strings.Index(path, "?"); i >= 0 {
+		query, _ = url.ParseQuery(path[i+1:])
+		path = path[:i]
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + path
+	if c.unit != "" {
+		query.Set("unit", string(c.unit))
+	}
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), reader)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.apiKey != "" {
+		req.Header.Set(api.APIKeyHeader, c.apiKey)
+	} else if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return c.httpClient.Do(req)
+}
+
+// backoff returns the exponential wait before the retry following the attempt
+func (c *Client) backoff(attempt int) time.Duration {
+	wait := c.minBackoff
+	for i := 0; i < attempt && wait < c.maxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > c.maxBackoff {
+		wait = c.maxBackoff
+	}
+	return wait
+}
+
+// wait sleeps for the duration, unless the context is done first
+func (c *Client) wait(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// responseError returns the error of a response, taking its fields from the
+// api.ErrorResponse written by the API when possible
+func responseError(res *http.Response, body []byte) *Error {
+	apiErr := &Error{StatusCode: res.StatusCode, Message: http.StatusText(res.StatusCode)}
+	var response api.ErrorResponse
+	if err := json.Unmarshal(body, &response); err == nil && response.Message != "" {
+		apiErr.Code = response.Code
+		apiErr.Message = response.Message
+		apiErr.Details = response.Details
+		apiErr.RequestID = response.RequestID
+	} else if text := strings.TrimSpace(string(body)); text != "" && len(text) < 512 {
+		apiErr.Message = text
+	}
+	if seconds, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		apiErr.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	return apiErr
+}
@@ -0,0 +1,519 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/igvaquero18/smarthome/api"
+	"github.com/igvaquero18/smarthome/controller"
+	"github.com/igvaquero18/smarthome/utils"
+	"github.com/igvaquero18/smarthome/utils/dynamotest"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/stretchr/testify/assert"
+)
+
+// testServer runs the SmartHome router backed by an in-memory database. The
+// first failures requests matching fail are answered with the status instead.
+type testServer struct {
+	*httptest.Server
+	api *api.Client
+
+	mu       sync.Mutex
+	requests map[string]int
+	fail     func(r *http.Request) bool
+	failures int
+	status   int
+	header   http.Header
+}
+
+func newTestServer() *testServer {
+	db := dynamotest.NewClient(map[string]string{
+		controller.DefaultAuthTable:          "Username",
+		controller.DefaultControlPlaneTable:  "Room",
+		controller.DefaultLoginAttemptsTable: "Key",
+		controller.DefaultTempInsideTable:    "Date",
+	})
+	db.AddIndex(controller.DefaultTempInsideTable, controller.PendingReadingsIndex, "Pending", "Time")
+	sh := controller.NewSmartHome(controller.SetDynamoDBClient(db), controller.SetConfig(&controller.SmartHomeConfig{
+		BcryptCost: 4,
+	}))
+	// Users are only created by admins, so the first one is seeded
+	if err := sh.SetCredentials("admin", "correct horse battery"); err != nil {
+		panic(err)
+	}
+	ts := &testServer{
+		api:      api.NewClient(api.JWTConfig{JWTSecret: "secret", JWTExpiration: time.Hour}, sh),
+		requests: map[string]int{},
+	}
+
+	e := echo.New()
+	e.HTTPErrorHandler = api.HTTPErrorHandler
+	e.Use(middleware.RequestID())
+	ts.api.RegisterRoutes(e, utils.NewHMACKeySet("secret"))
+	ts.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ts.mu.Lock()
+		ts.requests[r.Method+" "+r.URL.Path]++
+		fail := ts.failures > 0 && ts.fail != nil && ts.fail(r)
+		if fail {
+			ts.failures--
+		}
+		ts.mu.Unlock()
+		if fail {
+			for k, v := range ts.header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(ts.status)
+			return
+		}
+		e.ServeHTTP(w, r)
+	}))
+	return ts
+}
+
+func (ts *testServer) count(request string) int {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return ts.requests[request]
+}
+
+func TestNew(t *testing.T) {
+	testCases := []struct {
+		name        string
+		baseURL     string
+		expectedErr bool
+	}{
+		{name: "HTTPS", baseURL: "https://smarthome.example.com/"},
+		{name: "HTTP with a path", baseURL: "http://localhost:8080/smarthome"},
+		{name: "Missing scheme", baseURL: "smarthome.example.com", expectedErr: true},
+		{name: "Invalid URL", baseURL: "http://[::1", expectedErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			_, err := New(tc.baseURL)
+			assert.Equal(tt, tc.expectedErr, err != nil)
+		})
+	}
+}
+
+func TestClient(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+	ctx := context.Background()
+
+	c, err := New(ts.URL)
+	assert.NoError(t, err)
+
+	_, err = c.GetRoomOptions(ctx, "bedroom")
+	assert.EqualError(t, err, "smarthome: not logged in and no credentials configured")
+
+	err = c.SignUp(ctx, api.Auth{Username: "bob", Password: "correct horse battery"})
+	assert.EqualError(t, err, "smarthome: not logged in and no credentials configured")
+
+	var apiErr *Error
+	err = c.Login(ctx, api.Auth{Username: "admin", Password: "wrong"})
+	assert.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusForbidden, apiErr.StatusCode)
+	assert.Equal(t, "forbidden", apiErr.Code)
+	assert.Equal(t, api.ErrWrongCredentials, apiErr.Message)
+	assert.NotEmpty(t, apiErr.RequestID)
+
+	// The failed login throttles the next one for a second, which is retried
+	assert.NoError(t, c.Login(ctx, api.Auth{Username: "admin", Password: "correct horse battery"}))
+	assert.Equal(t, 3, ts.count("POST /v1/login"))
+
+	// Only admins, such as the one seeded in the test server, create users
+	err = c.SignUp(ctx, api.Auth{Username: "admin", Password: "correct horse battery"})
+	assert.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusConflict, apiErr.StatusCode)
+	assert.NoError(t, c.SignUp(ctx, api.Auth{Username: "bob", Password: "correct horse battery"}))
+
+	options, err := c.SetRoomOptions(ctx, "bedroom", api.RoomOptions{Enabled: true, ThresholdOn: 19.5, ThresholdOff: 20})
+	assert.NoError(t, err)
+	assert.Equal(t, &api.RoomOptions{Enabled: true, ThresholdOn: 19.5, ThresholdOff: 20, Version: 1, Unit: controller.Celsius}, options)
+
+	options, err = c.GetRoomOptions(ctx, "bedroom")
+	assert.NoError(t, err)
+	assert.Equal(t, &api.RoomOptions{Name: "bedroom", Enabled: true, ThresholdOn: 19.5, ThresholdOff: 20, Version: 1, Unit: controller.Celsius}, options)
+
+	list, err := c.ListRoomOptions(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []api.RoomOptions{{Name: "bedroom", Enabled: true, ThresholdOn: 19.5, ThresholdOff: 20, Version: 1, Unit: controller.Celsius}}, list)
+
+	disabled, thresholdOn := false, float32(21)
+	options, err = c.PatchRoomOptions(ctx, "bedroom", RoomOptionsPatch{Enabled: &disabled, Version: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, &api.RoomOptions{Name: "bedroom", Enabled: false, ThresholdOn: 19.5, ThresholdOff: 20, Version: 2, Unit: controller.Celsius}, options)
+
+	// Writes of a version that has been modified since fail
+	_, err = c.PatchRoomOptions(ctx, "bedroom", RoomOptionsPatch{Enabled: &disabled, Version: 1})
+	assert.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusPreconditionFailed, apiErr.StatusCode)
+	_, err = c.SetRoomOptions(ctx, "bedroom", api.RoomOptions{ThresholdOn: 19, ThresholdOff: 20, Version: 1, Unit: controller.Celsius})
+	assert.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusPreconditionFailed, apiErr.StatusCode)
+	err = c.DeleteRoomOptionsVersion(ctx, "bedroom", 1)
+	assert.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusPreconditionFailed, apiErr.StatusCode)
+
+	_, err = c.PatchRoomOptions(ctx, "bedroom", RoomOptionsPatch{ThresholdOn: &thresholdOn})
+	assert.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+
+	_, err = c.PatchRoomOptions(ctx, "livingroom", RoomOptionsPatch{Enabled: &disabled})
+	assert.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+
+	list, err = c.PatchAllRoomOptions(ctx, RoomOptionsPatch{Enabled: &disabled})
+	assert.NoError(t, err)
+	assert.Equal(t, []api.RoomOptions{{Name: "bedroom", Enabled: false, ThresholdOn: 19.5, ThresholdOff: 20, Version: 3, Unit: controller.Celsius}}, list)
+
+	// Batches are applied either entirely or not at all
+	thresholdOff := float32(22)
+	changes := []RoomChange{
+		{Room: "livingroom", Action: "set", Options: &RoomOptionsPatch{Enabled: &disabled, ThresholdOn: &thresholdOn, ThresholdOff: &thresholdOff}},
+		{Room: "bedroom", Action: "update", Options: &RoomOptionsPatch{ThresholdOff: &thresholdOff}, Version: 2},
+	}
+	_, err = c.BatchRoomOptions(ctx, changes)
+	assert.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusPreconditionFailed, apiErr.StatusCode)
+	_, err = c.GetRoomOptions(ctx, "livingroom")
+	assert.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+
+	changes[1].Version = 3
+	list, err = c.BatchRoomOptions(ctx, changes)
+	assert.NoError(t, err)
+	assert.Equal(t, []api.RoomOptions{
+		{Name: "livingroom", Enabled: false, ThresholdOn: 21, ThresholdOff: 22, Version: 1, Unit: controller.Celsius},
+		{Name: "bedroom", Enabled: false, ThresholdOn: 19.5, ThresholdOff: 22, Version: 4, Unit: controller.Celsius},
+	}, list)
+
+	list, err = c.BatchRoomOptions(ctx, []RoomChange{{Room: "livingroom", Action: "delete"}})
+	assert.NoError(t, err)
+	assert.Empty(t, list)
+
+	assert.NoError(t, c.DeleteRoomOptionsVersion(ctx, "bedroom", 4))
+	_, err = c.GetRoomOptions(ctx, "bedroom")
+	assert.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+
+	assert.NoError(t, c.DeleteRoomOptions(ctx, "bedroom"))
+	_, err = c.GetRoomOptions(ctx, "bedroom")
+	assert.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+
+	_, err = c.GetRoomOptions(ctx, "kitchen")
+	assert.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+	assert.Equal(t, "bad_request", apiErr.Code)
+	assert.Equal(t, map[string]interface{}{"valid_rooms": []interface{}{"all", "bedroom", "livingroom"}}, apiErr.Details)
+
+	assert.NoError(t, c.ChangePassword(ctx, "correct horse battery", "battery staple horse"))
+	assert.Equal(t, "battery staple horse", c.credentials.Password)
+	assert.NoError(t, c.UnlockUser(ctx, "admin"))
+	assert.NoError(t, c.DeleteUser(ctx, "admin"))
+	assert.Equal(t, 3, ts.count("POST /v1/login"), "the token should be cached")
+}
+
+func TestClientZones(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+	ctx := context.Background()
+
+	c, err := New(ts.URL, SetCredentials("admin", "correct horse battery"))
+	assert.NoError(t, err)
+
+	zone, err := c.CreateZone(ctx, "sleeping areas", []string{"livingroom", "bedroom"})
+	assert.NoError(t, err)
+	assert.Equal(t, &controller.Zone{Name: "sleeping areas", Rooms: []string{"bedroom", "livingroom"}}, zone)
+
+	var apiErr *Error
+	_, err = c.CreateZone(ctx, "sleeping areas", []string{"bedroom"})
+	assert.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusConflict, apiErr.StatusCode)
+
+	_, err = c.GetZoneOptions(ctx, "sleeping areas")
+	assert.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+
+	assert.NoError(t, c.SetZoneOptions(ctx, "sleeping areas", api.RoomOptions{Enabled: true, ThresholdOn: 19, ThresholdOff: 21}))
+	options, err := c.GetZoneOptions(ctx, "sleeping areas")
+	assert.NoError(t, err)
+	assert.Equal(t, []api.RoomOptions{
+		{Name: "bedroom", Enabled: true, ThresholdOn: 19, ThresholdOff: 21, Version: 1, Unit: controller.Celsius},
+		{Name: "livingroom", Enabled: true, ThresholdOn: 19, ThresholdOff: 21, Version: 1, Unit: controller.Celsius},
+	}, options)
+
+	zone, err = c.UpdateZone(ctx, "sleeping areas", []string{"bedroom"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"bedroom"}, zone.Rooms)
+
+	disabled := false
+	options, err = c.PatchZoneOptions(ctx, "sleeping areas", RoomOptionsPatch{Enabled: &disabled})
+	assert.NoError(t, err)
+	assert.Equal(t, []api.RoomOptions{{Name: "bedroom", Enabled: false, ThresholdOn: 19, ThresholdOff: 21, Version: 2, Unit: controller.Celsius}}, options)
+
+	assert.NoError(t, c.DeleteZoneOptions(ctx, "sleeping areas"))
+	list, err := c.ListRoomOptions(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []api.RoomOptions{{Name: "livingroom", Enabled: true, ThresholdOn: 19, ThresholdOff: 21, Version: 1, Unit: controller.Celsius}}, list)
+
+	zones, err := c.ListZones(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []controller.Zone{{Name: "sleeping areas", Rooms: []string{"bedroom"}}}, zones)
+
+	assert.NoError(t, c.DeleteZone(ctx, "sleeping areas"))
+	_, err = c.GetZone(ctx, "sleeping areas")
+	assert.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+}
+
+func TestClientToken(t *testing.T) {
+	testCases := []struct {
+		name           string
+		expiration     time.Duration
+		invalidate     bool
+		expectedLogins int
+	}{
+		{
+			name:           "Cached token",
+			expiration:     time.Hour,
+			expectedLogins: 1,
+		},
+		{
+			name:           "Token about to expire",
+			expiration:     10 * time.Second,
+			expectedLogins: 3,
+		},
+		{
+			name:           "Token rejected",
+			expiration:     time.Hour,
+			invalidate:     true,
+			expectedLogins: 2,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			ts := newTestServer()
+			defer ts.Close()
+			ctx := context.Background()
+			ts.api.SetJWTExpiration(tc.expiration)
+
+			c, err := New(ts.URL, SetCredentials("admin", "correct horse battery"))
+			assert.NoError(tt, err)
+
+			_, err = c.SetRoomOptions(ctx, "bedroom", api.RoomOptions{ThresholdOn: 19, ThresholdOff: 20})
+			assert.NoError(tt, err)
+			if tc.invalidate {
+				c.mu.Lock()
+				c.token = c.token + "x"
+				c.mu.Unlock()
+			}
+			_, err = c.GetRoomOptions(ctx, "bedroom")
+			assert.NoError(tt, err)
+			_, err = c.ListRoomOptions(ctx)
+			assert.NoError(tt, err)
+			assert.Equal(tt, tc.expectedLogins, ts.count("POST /v1/login"))
+		})
+	}
+}
+
+func TestClientRetries(t *testing.T) {
+	isRoom := func(r *http.Request) bool { return strings.HasPrefix(r.URL.Path, "/v1/room/") }
+	isSignUp := func(r *http.Request) bool { return r.URL.Path == "/v1/signup" }
+
+	testCases := []struct {
+		name             string
+		fail             func(r *http.Request) bool
+		failures         int
+		status           int
+		retryAfter       string
+		request          func(ctx context.Context, c *Client) error
+		expectedStatus   int
+		expectedRequests map[string]int
+	}{
+		{
+			name:     "Unavailable",
+			fail:     isRoom,
+			failures: 2,
+			status:   http.StatusServiceUnavailable,
+			request: func(ctx context.Context, c *Client) error {
+				_, err := c.GetRoomOptions(ctx, "bedroom")
+				return err
+			},
+			expectedRequests: map[string]int{"GET /v1/room/bedroom": 3},
+		},
+		{
+			name:     "Unavailable too many times",
+			fail:     isRoom,
+			failures: 5,
+			status:   http.StatusBadGateway,
+			request: func(ctx context.Context, c *Client) error {
+				return c.DeleteRoomOptions(ctx, "bedroom")
+			},
+			expectedStatus:   http.StatusBadGateway,
+			expectedRequests: map[string]int{"DELETE /v1/room/bedroom": 4},
+		},
+		{
+			name:     "Non idempotent request",
+			fail:     isSignUp,
+			failures: 1,
+			status:   http.StatusServiceUnavailable,
+			request: func(ctx context.Context, c *Client) error {
+				return c.SignUp(ctx, api.Auth{Username: "bob", Password: "correct horse battery"})
+			},
+			expectedStatus:   http.StatusServiceUnavailable,
+			expectedRequests: map[string]int{"POST /v1/signup": 1},
+		},
+		{
+			name:       "Throttled",
+			fail:       isSignUp,
+			failures:   1,
+			status:     http.StatusTooManyRequests,
+			retryAfter: "0",
+			request: func(ctx context.Context, c *Client) error {
+				return c.SignUp(ctx, api.Auth{Username: "bob", Password: "correct horse battery"})
+			},
+			expectedRequests: map[string]int{"POST /v1/signup": 2},
+		},
+		{
+			name:       "Throttled for too long",
+			fail:       isRoom,
+			failures:   1,
+			status:     http.StatusTooManyRequests,
+			retryAfter: "60",
+			request: func(ctx context.Context, c *Client) error {
+				_, err := c.GetRoomOptions(ctx, "bedroom")
+				return err
+			},
+			expectedStatus:   http.StatusTooManyRequests,
+			expectedRequests: map[string]int{"GET /v1/room/bedroom": 1},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			ts := newTestServer()
+			defer ts.Close()
+			ctx := context.Background()
+
+			c, err := New(ts.URL, SetCredentials("admin", "correct horse battery"), SetRetries(3, time.Millisecond, 10*time.Millisecond))
+			assert.NoError(tt, err)
+			_, err = c.SetRoomOptions(ctx, "bedroom", api.RoomOptions{ThresholdOn: 19, ThresholdOff: 20})
+			assert.NoError(tt, err)
+
+			ts.mu.Lock()
+			ts.requests = map[string]int{}
+			ts.fail, ts.failures, ts.status = tc.fail, tc.failures, tc.status
+			ts.header = http.Header{}
+			if tc.retryAfter != "" {
+				ts.header.Set("Retry-After", tc.retryAfter)
+			}
+			ts.mu.Unlock()
+
+			err = tc.request(ctx, c)
+			if tc.expectedStatus == 0 {
+				assert.NoError(tt, err)
+			} else {
+				var apiErr *Error
+				assert.True(tt, errors.As(err, &apiErr), "unexpected error %v", err)
+				assert.Equal(tt, tc.expectedStatus, apiErr.StatusCode)
+			}
+			for request, count := range tc.expectedRequests {
+				assert.Equal(tt, count, ts.count(request), request)
+			}
+		})
+	}
+}
+
+func TestClientContext(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+	ts.fail = func(r *http.Request) bool { return true }
+	ts.failures = 100
+	ts.status = http.StatusServiceUnavailable
+
+	c, err := New(ts.URL, SetAPIKey("shk_1_secret"), SetRetries(100, time.Hour, time.Hour))
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = c.GetRoomOptions(ctx, "bedroom")
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "unexpected error %v", err)
+	assert.Equal(t, 1, ts.count("GET /v1/room/bedroom"))
+}
+
+func TestClientTemperatureUnit(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+	ctx := context.Background()
+
+	c, err := New(ts.URL, SetCredentials("admin", "correct horse battery"))
+	assert.NoError(t, err)
+
+	preferences, err := c.GetPreferences(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, &api.Preferences{TemperatureUnit: controller.Celsius}, preferences)
+
+	// The unit of the client takes precedence over the preference of the user
+	fahrenheit, err := New(ts.URL, SetCredentials("admin", "correct horse battery"), SetTemperatureUnit(controller.Fahrenheit))
+	assert.NoError(t, err)
+	options, err := fahrenheit.SetRoomOptions(ctx, "bedroom", api.RoomOptions{Enabled: true, ThresholdOn: 68, ThresholdOff: 70})
+	assert.NoError(t, err)
+	assert.Equal(t, &api.RoomOptions{Enabled: true, ThresholdOn: 68, ThresholdOff: 70, Version: 1, Unit: controller.Fahrenheit}, options)
+
+	options, err = c.GetRoomOptions(ctx, "bedroom")
+	assert.NoError(t, err)
+	assert.Equal(t, &api.RoomOptions{Name: "bedroom", Enabled: true, ThresholdOn: 20, ThresholdOff: 21.1, Version: 1, Unit: controller.Celsius}, options)
+
+	assert.NoError(t, c.SetPreferences(ctx, api.Preferences{TemperatureUnit: controller.Fahrenheit}))
+	options, err = c.GetRoomOptions(ctx, "bedroom")
+	assert.NoError(t, err)
+	assert.Equal(t, &api.RoomOptions{Name: "bedroom", Enabled: true, ThresholdOn: 68, ThresholdOff: 70, Version: 1, Unit: controller.Fahrenheit}, options)
+
+	var apiErr *Error
+	err = c.SetPreferences(ctx, api.Preferences{TemperatureUnit: "kelvin"})
+	assert.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+}
+
+func TestClientGetReadings(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+	ctx := context.Background()
+
+	to := time.Now().UTC().Truncate(time.Hour)
+	from := to.Add(-time.Hour)
+	for i, temperature := range []float32{20, 22} {
+		reading := controller.Reading{Room: "bedroom", Time: from.Add(time.Duration(i) * time.Minute), Temperature: temperature}
+		assert.NoError(t, ts.api.SmartHomeInterface.AddReading(controller.SourceInside, reading))
+	}
+	_, err := ts.api.RollupReadings(controller.SourceInside, to)
+	assert.NoError(t, err)
+
+	c, err := New(ts.URL, SetCredentials("admin", "correct horse battery"), SetTemperatureUnit(controller.Fahrenheit))
+	assert.NoError(t, err)
+
+	readings, err := c.GetReadings(ctx, ReadingsQuery{Room: "bedroom", From: from, To: to})
+	assert.NoError(t, err)
+	assert.Equal(t, &api.Readings{
+		Source:     controller.SourceInside,
+		Room:       "bedroom",
+		From:       from,
+		To:         to,
+		Resolution: controller.ResolutionFiveMinutes,
+		Unit:       controller.Fahrenheit,
+		Buckets:    []controller.ReadingBucket{{Room: "bedroom", Start: from, Min: 68, Max: 71.6, Avg: 69.8, Count: 2}},
+	}, readings)
+
+	var apiErr *Error
+	_, err = c.GetReadings(ctx, ReadingsQuery{Room: "attic"})
+	assert.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+}
@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/igvaquero18/smarthome/controller"
+	"github.com/spf13/cobra"
+)
+
+// backupPassphraseEnv is the environment variable with the passphrase the
+// backups are encrypted with, unless the passphrase-file flag is set
+const backupPassphraseEnv = "SMARTHOME_BACKUP_PASSPHRASE"
+
+// backupCmd represents the backup command
+var backupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "backs up the tables of the smarthome",
+	Long: `Backs up the items of the authentication, control plane, inside and
+	outside temperature tables into a compressed archive, encrypted when a
+	passphrase is set in the SMARTHOME_BACKUP_PASSPHRASE environment variable
+	or in the file of the passphrase-file flag. For example:
+
+	smarthome backup --output smarthome.backup`,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		bindDynamoDBFlags(cmd)
+	},
+	Run: backup,
+}
+
+// restoreCmd represents the restore command
+var restoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "restores the tables of the smarthome from a backup",
+	Long: `Restores the items of an archive written by the backup command into
+	the tables of the DynamoDB endpoint, which may be a dynamodb-local one to
+	debug with a copy of the production data. For example:
+
+	smarthome restore --input smarthome.backup -d http://localhost:8000 --create-tables`,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		bindDynamoDBFlags(cmd)
+	},
+	Run: restore,
+}
+
+func backup(cmd *cobra.Command, args []string) {
+	pageSize, _ := cmd.Flags().GetInt32("page-size")
+	opts := controller.BackupOptions{
+		Passphrase: backupPassphrase(cmd),
+		PageSize:   pageSize,
+	}
+
+	var w io.Writer = os.Stdout
+	output, _ := cmd.Flags().GetString("output")
+	if output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			sugar.Fatalw("error creating output file", "file", output, "error", err.Error())
+		}
+		defer f.Close()
+		w = f
+	}
+	buffered := bufio.NewWriter(w)
+
+	sh := newDynamoDBSmartHome(&controller.SmartHomeConfig{})
+	items, err := sh.Backup(buffered, opts)
+	if err != nil {
+		sugar.Fatalw("error backing up tables", "error", err.Error())
+	}
+	if err := buffered.Flush(); err != nil {
+		sugar.Fatalw("error writing output", "error", err.Error())
+	}
+	sugar.Infow("backed up tables", "items", items, "encrypted", opts.Passphrase != "", "output", output)
+	sugar.Sync()
+}
+
+func restore(cmd *cobra.Command, args []string) {
+	tables, _ := cmd.Flags().GetStringSlice("tables")
+	createTables, _ := cmd.Flags().GetBool("create-tables")
+	opts := controller.BackupOptions{
+		Passphrase:   backupPassphrase(cmd),
+		Tables:       tables,
+		CreateTables: createTables,
+	}
+
+	var r io.Reader = os.Stdin
+	input, _ := cmd.Flags().GetString("input")
+	if input != "" {
+		f, err := os.Open(input)
+		if err != nil {
+			sugar.Fatalw("error opening input file", "file", input, "error", err.Error())
+		}
+		defer f.Close()
+		r = f
+	}
+
+	sh := newDynamoDBSmartHome(&controller.SmartHomeConfig{})
+	items, err := sh.Restore(bufio.NewReader(r), opts)
+	if err != nil {
+		sugar.Fatalw("error restoring tables", "error", err.Error(), "items", items)
+	}
+	sugar.Infow("restored tables", "items", items, "input", input)
+	sugar.Sync()
+}
+
+// backupPassphrase returns the passphrase of the file of the passphrase-file
+// flag, without its trailing newline, or else that of the environment. The
+// passphrase is never read from a flag, so that it isn't leaked to the list
+// of processes or to the history of the shell.
+func backupPassphrase(cmd *cobra.Command) string {
+	file, _ := cmd.Flags().GetString("passphrase-file")
+	if file == "" {
+		return os.Getenv(backupPassphraseEnv)
+	}
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		sugar.Fatalw("error reading passphrase file", "file", file, "error", err.Error())
+	}
+	return strings.TrimRight(string(content), "\r\n")
+}
+
+func init() {
+	rootCmd.AddCommand(backupCmd)
+	addDynamoDBFlags(backupCmd)
+	backupCmd.Flags().StringP("output", "o", "", "File to write the backup to. Defaults to the standard output.")
+	backupCmd.Flags().String("passphrase-file", "", "File with the passphrase to encrypt the backup with. Defaults to the "+backupPassphraseEnv+" environment variable.")
+	backupCmd.Flags().Int32("page-size", 0, "Maximum number of items read from DynamoDB per request. Defaults to no limit.")
+
+	rootCmd.AddCommand(restoreCmd)
+	addDynamoDBFlags(restoreCmd)
+	restoreCmd.Flags().StringP("input", "i", "", "File to read the backup from. Defaults to the standard input.")
+	restoreCmd.Flags().String("passphrase-file", "", "File with the passphrase the backup is encrypted with. Defaults to the "+backupPassphraseEnv+" environment variable.")
+	restoreCmd.Flags().StringSlice("tables", controller.BackupTables, "Comma-separated list of the tables to restore: "+strings.Join(controller.BackupTables, ", "))
+	restoreCmd.Flags().Bool("create-tables", false, "Create the tables missing in the target endpoint")
+}
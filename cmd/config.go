@@ -0,0 +1,203 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/igvaquero18/smarthome/api"
+	"github.com/igvaquero18/smarthome/utils"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+var roomNameRegexp = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// liveConfig holds the settings of the server that can be changed while it
+// is running, either by editing the config file or by sending a SIGHUP.
+type liveConfig struct {
+	Verbose       bool
+	Origins       []string
+	JWTExpiration time.Duration
+	Rooms         []string
+}
+
+// readLiveConfig builds a liveConfig from the settings of v, returning an
+// error if any of them is not valid.
+func readLiveConfig(v *viper.Viper) (*liveConfig, error) {
+	cfg := &liveConfig{
+		Verbose: v.GetBool(verboseFlag),
+		Origins: v.GetStringSlice(corsOriginsFlag),
+		Rooms:   v.GetStringSlice(roomsFlag),
+	}
+
+	if err := utils.ValidateOriginURLsFromArray(cfg.Origins); err != nil {
+		return nil, fmt.Errorf("invalid CORS URLs provided: %w", err)
+	}
+
+	expiration, err := time.ParseDuration(v.GetString(jwtExpirationFlag))
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWT expiration time %s: %w", v.GetString(jwtExpirationFlag), err)
+	}
+	if expiration <= 0 {
+		return nil, fmt.Errorf("invalid JWT expiration time %s: it must be positive", v.GetString(jwtExpirationFlag))
+	}
+	cfg.JWTExpiration = expiration
+
+	if len(cfg.Rooms) == 0 {
+		return nil, fmt.Errorf("at least one room must be configured")
+	}
+	for _, room := range cfg.Rooms {
+		if !roomNameRegexp.MatchString(room) {
+			return nil, fmt.Errorf("invalid room name %q: only lowercase letters, digits, '-' and '_' are allowed", room)
+		}
+	}
+
+	return cfg, nil
+}
+
+// configReloader re-reads the configuration file and hands the result over
+// to apply, as long as it is valid. Otherwise, the previous configuration
+// is kept.
+type configReloader struct {
+	mu    sync.Mutex
+	flags *pflag.FlagSet
+	apply func(*liveConfig)
+}
+
+// Reload reads the configuration file again and applies it. The file is
+// parsed and validated apart from the global viper settings, which are only
+// replaced with it once it's valid, so that a rejected file is never read.
+// Both the watcher of the file and SIGHUP reload through it, so reloads
+// never overlap.
+func (r *configReloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	file := viper.ConfigFileUsed()
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("error reading config file %s: %w", file, err)
+	}
+
+	v := newLiveViper(file, r.flags)
+	if err := v.ReadConfig(bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("error reading config file %s: %w", file, err)
+	}
+	cfg, err := readLiveConfig(v)
+	if err != nil {
+		return err
+	}
+
+	if err := viper.ReadConfig(bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("error reading config file %s: %w", file, err)
+	}
+	r.apply(cfg)
+	return nil
+}
+
+// newLiveViper returns a viper for the settings of liveConfig, which, like
+// the global one, are overridden by their flags and environment variables
+func newLiveViper(file string, flags *pflag.FlagSet) *viper.Viper {
+	v := viper.New()
+	v.SetConfigFile(file)
+	v.BindPFlag(verboseFlag, flags.Lookup("verbose"))
+	v.BindPFlag(corsOriginsFlag, flags.Lookup("cors-origins"))
+	v.BindPFlag(jwtExpirationFlag, flags.Lookup("jwt-expiration"))
+	v.BindPFlag(roomsFlag, flags.Lookup("rooms"))
+	v.BindEnv(corsOriginsFlag, corsOriginsEnv)
+	v.BindEnv(jwtExpirationFlag, jwtExpirationEnv)
+	v.BindEnv(roomsFlag, roomsEnv)
+	return v
+}
+
+// Watch reloads the configuration every time the config file changes, until
+// ctx is done. The directory of the file is watched rather than the file, so
+// that files replaced by editors, or by symlinks as in Kubernetes ConfigMaps,
+// are still followed. It doesn't use viper.WatchConfig, which reads the
+// changed file into the global viper before it can be validated.
+func (r *configReloader) Watch(ctx context.Context) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		sugar.Errorw("error watching config file, it's only reloaded on SIGHUP", "error", err.Error())
+		return
+	}
+	defer watcher.Close()
+
+	file := filepath.Clean(viper.ConfigFileUsed())
+	realFile, _ := filepath.EvalSymlinks(file)
+	if err := watcher.Add(filepath.Dir(file)); err != nil {
+		sugar.Errorw("error watching config file, it's only reloaded on SIGHUP", "file", file, "error", err.Error())
+		return
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			sugar.Errorw("error watching config file", "file", file, "error", err.Error())
+		case e, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			currentFile, _ := filepath.EvalSymlinks(file)
+			changed := filepath.Clean(e.Name) == file && e.Op&(fsnotify.Write|fsnotify.Create) != 0
+			if !changed && (currentFile == "" || currentFile == realFile) {
+				continue
+			}
+			realFile = currentFile
+			sugar.Infow("config file changed, reloading configuration", "file", e.Name, "operation", e.Op.String())
+			if err := r.Reload(); err != nil {
+				sugar.Errorw("error reloading configuration, keeping the previous one", "error", err.Error())
+				continue
+			}
+			sugar.Infow("configuration reloaded", "file", file)
+		}
+	}
+}
+
+// corsMiddleware is an echo middleware for CORS whose allowed origins can
+// be changed while the server is running.
+type corsMiddleware struct {
+	mu      sync.RWMutex
+	handler echo.MiddlewareFunc
+}
+
+// SetOrigins replaces the allowed origins. An empty list disables CORS.
+func (m *corsMiddleware) SetOrigins(origins []string) {
+	var handler echo.MiddlewareFunc
+	if len(origins) > 0 {
+		handler = middleware.CORSWithConfig(middleware.CORSConfig{
+			AllowOrigins:  origins,
+			ExposeHeaders: []string{api.HeaderETag},
+		})
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.handler = handler
+}
+
+// Middleware is the echo.MiddlewareFunc to be registered in the server
+func (m *corsMiddleware) Middleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		m.mu.RLock()
+		handler := m.handler
+		m.mu.RUnlock()
+		if handler == nil {
+			return next(c)
+		}
+		return handler(next)(c)
+	}
+}
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"github.com/igvaquero18/smarthome/controller"
+	"github.com/igvaquero18/smarthome/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// dynamoDBFlags maps the settings of DynamoDB to the flags of the commands
+// other than serve accessing the tables
+var dynamoDBFlags = map[string]string{
+	awsRegionFlag:             "aws-region",
+	dynamoDBEndpointFlag:      "dynamodb-endpoint",
+	dynamoDBAuthTableFlag:     "dynamodb-auth-table",
+	dynamoDBControlTableFlag:  "dynamodb-control-table",
+	dynamoDBOutsideTableFlag:  "dynamodb-outside-table",
+	dynamoDBInsideTableFlag:   "dynamodb-inside-table",
+	dynamoDBAttemptsTableFlag: "dynamodb-login-attempts-table",
+	dynamoDBHeatingTableFlag:  "dynamodb-heating-table",
+	dynamoDBHistoryTableFlag:  "dynamodb-history-table",
+}
+
+// addDynamoDBFlags adds the flags of the DynamoDB settings to a command
+func addDynamoDBFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP("aws-region", "r", "us-east-1", "AWS region for DynamoDB")
+	cmd.Flags().StringP("dynamodb-endpoint", "d", "", "DynamoDB endpoint")
+	cmd.Flags().String("dynamodb-auth-table", controller.DefaultAuthTable, "DynamoDB Authentication table name")
+	cmd.Flags().String("dynamodb-control-table", controller.DefaultControlPlaneTable, "DynamoDB Control Plane table name")
+	cmd.Flags().String("dynamodb-outside-table", controller.DefaultTempOutsideTable, "DynamoDB Temperature Outside table name")
+	cmd.Flags().String("dynamodb-inside-table", controller.DefaultTempInsideTable, "DynamoDB Temperature Inside table name")
+	cmd.Flags().String("dynamodb-login-attempts-table", controller.DefaultLoginAttemptsTable, "DynamoDB Login Attempts table name")
+	cmd.Flags().String("dynamodb-heating-table", controller.DefaultHeatingTable, "DynamoDB Heating Transitions table name")
+	cmd.Flags().String("dynamodb-history-table", controller.DefaultHistoryTable, "DynamoDB Room Settings History table name")
+}
+
+// bindDynamoDBFlags binds the DynamoDB flags of a command to their settings.
+// It must be called when the command runs, as serve binds the same settings
+// to its own flags.
+func bindDynamoDBFlags(cmd *cobra.Command) {
+	for key, name := range dynamoDBFlags {
+		viper.BindPFlag(key, cmd.Flags().Lookup(name))
+	}
+}
+
+// newDynamoDBSmartHome returns a SmartHome controller accessing the tables
+// of the settings, on top of the rest of the config
+func newDynamoDBSmartHome(config *controller.SmartHomeConfig) *controller.SmartHome {
+	region := viper.GetString(awsRegionFlag)
+	dynamoDBEndpoint := viper.GetString(dynamoDBEndpointFlag)
+	sugar.Infow("creating DynamoDB client", "region", region, "url", dynamoDBEndpoint)
+	dynamoClient, err := utils.InitDynamoClient(region, dynamoDBEndpoint)
+	if err != nil {
+		sugar.Fatalw("error creating DynamoDB client", "error", err.Error())
+	}
+
+	config.AuthTable = viper.GetString(dynamoDBAuthTableFlag)
+	config.ControlPlaneTable = viper.GetString(dynamoDBControlTableFlag)
+	config.TempOutsideTable = viper.GetString(dynamoDBOutsideTableFlag)
+	config.TempInsideTable = viper.GetString(dynamoDBInsideTableFlag)
+	config.LoginAttemptsTable = viper.GetString(dynamoDBAttemptsTableFlag)
+	config.HeatingTable = viper.GetString(dynamoDBHeatingTableFlag)
+	config.HistoryTable = viper.GetString(dynamoDBHistoryTableFlag)
+	return controller.NewSmartHome(
+		controller.SetLogger(sugar),
+		controller.SetDynamoDBClient(dynamoClient),
+		controller.SetConfig(config),
+	)
+}
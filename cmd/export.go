@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"time"
+
+	"github.com/igvaquero18/smarthome/api"
+	"github.com/igvaquero18/smarthome/controller"
+	"github.com/igvaquero18/smarthome/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// exportCmd represents the export command
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "exports the readings and the history of the settings",
+	Long: `Exports the temperature readings of the rooms and outside, and the
+	history of the settings of the rooms, as CSV, NDJSON or Parquet, like the
+	GET /v1/export endpoint does. For example:
+
+	smarthome export --from 2021-11-01T00:00:00Z --format parquet --output winter.parquet`,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		bindDynamoDBFlags(cmd)
+		viper.BindPFlag(roomsFlag, cmd.Flags().Lookup("rooms"))
+	},
+	Run: export,
+}
+
+func export(cmd *cobra.Command, args []string) {
+	to := time.Now().UTC()
+	if value, _ := cmd.Flags().GetString("to"); value != "" {
+		to = parseTimeFlag("to", value)
+	}
+	from := to.Add(-api.DefaultExportRange)
+	if value, _ := cmd.Flags().GetString("from"); value != "" {
+		from = parseTimeFlag("from", value)
+	}
+	format, _ := cmd.Flags().GetString("format")
+	resolution, _ := cmd.Flags().GetString("resolution")
+	unitValue, _ := cmd.Flags().GetString("unit")
+	unit, err := controller.ParseTemperatureUnit(unitValue)
+	if err != nil {
+		sugar.Fatalw("invalid temperature unit", "unit", unitValue)
+	}
+	query := api.ExportQuery{
+		From:       from,
+		To:         to,
+		Resolution: controller.Resolution(resolution),
+		Format:     api.ExportFormat(format),
+		Rooms:      viper.GetStringSlice(roomsFlag),
+		Unit:       unit,
+	}
+	if err := query.Validate(); err != nil {
+		sugar.Fatalw("invalid export", "error", err.Error())
+	}
+
+	var w io.Writer = os.Stdout
+	output, _ := cmd.Flags().GetString("output")
+	if output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			sugar.Fatalw("error creating output file", "file", output, "error", err.Error())
+		}
+		defer f.Close()
+		w = f
+	}
+	buffered := bufio.NewWriter(w)
+
+	sh := newDynamoDBSmartHome(&controller.SmartHomeConfig{})
+	if err := api.WriteExport(sh, buffered, query); err != nil {
+		sugar.Fatalw("error exporting data", "error", err.Error())
+	}
+	if err := buffered.Flush(); err != nil {
+		sugar.Fatalw("error writing output", "error", err.Error())
+	}
+	sugar.Infow("exported data", "from", from, "to", to, "format", format, "output", output)
+	sugar.Sync()
+}
+
+// parseTimeFlag returns the RFC 3339 time of a flag
+func parseTimeFlag(name, value string) time.Time {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		sugar.Fatalw("invalid time: it must be in RFC 3339 format", "flag", name, "time", value)
+	}
+	return t.UTC()
+}
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+	addDynamoDBFlags(exportCmd)
+	exportCmd.Flags().String("from", "", "Start of the range to export, in RFC 3339 format. Defaults to 30 days before its end.")
+	exportCmd.Flags().String("to", "", "End of the range to export, in RFC 3339 format. Defaults to now.")
+	exportCmd.Flags().StringP("format", "f", string(api.ExportCSV), "Format of the export: csv, ndjson or parquet")
+	exportCmd.Flags().String("resolution", string(api.DefaultExportResolution), "Length of the buckets of readings: 5m, 1h or 1d")
+	exportCmd.Flags().String("unit", string(controller.Celsius), "Unit of the temperatures: celsius or fahrenheit")
+	exportCmd.Flags().StringP("output", "o", "", "File to write the export to. Defaults to the standard output.")
+	exportCmd.Flags().StringSlice("rooms", utils.AllButOne(api.ValidRooms, api.AllRooms), "Comma-separated list of rooms to export")
+}
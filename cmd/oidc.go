@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/igvaquero18/smarthome/api"
+	"github.com/igvaquero18/smarthome/controller"
+	"github.com/igvaquero18/smarthome/utils"
+	"github.com/spf13/viper"
+)
+
+// newOIDCConfig discovers the configured OpenID Connect provider and returns
+// the configuration for logging in through it.
+func newOIDCConfig() (*api.OIDCConfig, error) {
+	issuer := viper.GetString(oidcIssuerFlag)
+	clientID := viper.GetString(oidcClientIDFlag)
+	redirectURL := viper.GetString(oidcRedirectURLFlag)
+	if clientID == "" || redirectURL == "" {
+		return nil, fmt.Errorf("the OpenID Connect client ID and redirect URL are required")
+	}
+
+	defaultRole := viper.GetString(oidcDefaultRoleFlag)
+	if defaultRole != "" && !utils.Contains(controller.ValidRoles, defaultRole) {
+		return nil, fmt.Errorf("invalid default role %s, must be one of %v", defaultRole, controller.ValidRoles)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	provider, err := utils.NewOIDCProvider(ctx, issuer, clientID, viper.GetString(oidcClientSecretFlag), redirectURL)
+	if err != nil {
+		return nil, err
+	}
+
+	sugar.Infow("OpenID Connect login enabled", "issuer", issuer, "client_id", clientID)
+	return &api.OIDCConfig{
+		Provider:          provider,
+		UsernameClaim:     viper.GetString(oidcUsernameClaimFlag),
+		DefaultRole:       defaultRole,
+		PostLoginRedirect: viper.GetString(oidcPostLoginURLFlag),
+	}, nil
+}
@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"context"
+	"time"
+
+	"github.com/igvaquero18/smarthome/controller"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// readingsRetentionFlags maps the settings of the retention of the readings
+// to their flags
+var readingsRetentionFlags = map[string]string{
+	retentionRawFlag:         "readings-retention-raw",
+	retentionFiveMinutesFlag: "readings-retention-five-minutes",
+	retentionHourlyFlag:      "readings-retention-hourly",
+	retentionDailyFlag:       "readings-retention-daily",
+}
+
+// rollupCmd represents the rollup command
+var rollupCmd = &cobra.Command{
+	Use:   "rollup",
+	Short: "rolls up the temperature readings",
+	Long: `Aggregates the raw temperature readings into 5-minute, hourly
+	and daily buckets, and sets the expiration of the raw readings rolled
+	up. The serve command does the same in the background.`,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		bindDynamoDBFlags(cmd)
+		for key, name := range readingsRetentionFlags {
+			viper.BindPFlag(key, cmd.Flags().Lookup(name))
+		}
+	},
+	Run: rollup,
+}
+
+func rollup(cmd *cobra.Command, args []string) {
+	retention := readReadingsRetention()
+	sh := newDynamoDBSmartHome(&controller.SmartHomeConfig{Retention: retention})
+	for _, source := range []controller.ReadingSource{controller.SourceInside, controller.SourceOutside} {
+		result, err := sh.RollupReadings(source, time.Now())
+		if err != nil {
+			sugar.Fatalw("error rolling up readings", "source", source, "error", err.Error())
+		}
+		sugar.Infow("rolled up readings", "source", source, "readings", result.Readings, "buckets", result.Buckets)
+	}
+	sugar.Sync()
+}
+
+// readReadingsRetention reads how long readings are kept at each resolution
+func readReadingsRetention() controller.ReadingsRetention {
+	durations := map[string]time.Duration{}
+	for key := range readingsRetentionFlags {
+		d, err := time.ParseDuration(viper.GetString(key))
+		if err != nil {
+			sugar.Fatalw("invalid retention of readings", "setting", key, "retention", viper.GetString(key))
+		}
+		durations[key] = d
+	}
+	retention := controller.ReadingsRetention{
+		Raw:         durations[retentionRawFlag],
+		FiveMinutes: durations[retentionFiveMinutesFlag],
+		Hourly:      durations[retentionHourlyFlag],
+		Daily:       durations[retentionDailyFlag],
+	}
+	if err := retention.Validate(); err != nil {
+		sugar.Fatalw("invalid retention of readings", "error", err.Error())
+	}
+	return retention
+}
+
+// rollupWorker rolls up the readings of every source periodically, until ctx
+// is done
+func rollupWorker(sh controller.SmartHomeInterface, interval time.Duration) func(ctx context.Context) {
+	return func(ctx context.Context) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				for _, source := range []controller.ReadingSource{controller.SourceInside, controller.SourceOutside} {
+					result, err := sh.RollupReadings(source, now)
+					if err != nil {
+						sugar.Errorw("error rolling up readings", "source", source, "error", err.Error())
+						continue
+					}
+					sugar.Debugw("rolled up readings", "source", source, "readings", result.Readings, "buckets", result.Buckets)
+				}
+			}
+		}
+	}
+}
+
+// addReadingsRetentionFlags adds the flags of the retention of the readings
+// to a command
+func addReadingsRetentionFlags(cmd *cobra.Command) {
+	retention := controller.DefaultReadingsRetention
+	cmd.Flags().String("readings-retention-raw", retention.Raw.String(), "How long raw readings are kept once rolled up, or 0 to keep them forever")
+	cmd.Flags().String("readings-retention-five-minutes", retention.FiveMinutes.String(), "How long 5-minute buckets of readings are kept, or 0 to keep them forever")
+	cmd.Flags().String("readings-retention-hourly", retention.Hourly.String(), "How long hourly buckets of readings are kept, or 0 to keep them forever")
+	cmd.Flags().String("readings-retention-daily", retention.Daily.String(), "How long daily buckets of readings are kept, or 0 to keep them forever")
+}
+
+func init() {
+	rootCmd.AddCommand(rollupCmd)
+	addDynamoDBFlags(rollupCmd)
+	addReadingsRetentionFlags(rollupCmd)
+}
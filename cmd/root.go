@@ -17,7 +17,8 @@ const (
 )
 
 var (
-	sugar *zap.SugaredLogger
+	sugar    *zap.SugaredLogger
+	logLevel zap.AtomicLevel
 )
 
 var rootCmd = &cobra.Command{
@@ -69,7 +70,8 @@ func initConfig() {
 		fmt.Printf("using config file %s\n", viper.ConfigFileUsed())
 	}
 
-	if sugar, err = utils.InitSugaredLogger(viper.GetBool(verboseFlag)); err != nil {
+	logLevel = utils.NewLogLevel(viper.GetBool(verboseFlag))
+	if sugar, err = utils.InitSugaredLoggerWithLevel(logLevel); err != nil {
 		fmt.Printf("%+v\n", err)
 		os.Exit(1)
 	}
@@ -1,11 +1,17 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"net/http"
 	"os"
-	"strings"
+	"os/signal"
+	"syscall"
 	"time"
 
+	// Time zones of the tariffs, which minimal images lack
+	_ "time/tzdata"
+
 	"github.com/igvaquero18/smarthome/api"
 	"github.com/igvaquero18/smarthome/controller"
 	"github.com/igvaquero18/smarthome/utils"
@@ -14,38 +20,135 @@ import (
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"golang.org/x/crypto/bcrypt"
 )
 
 const (
-	portEnv                 = "SMARTHOME_SERVER_PORT"
-	addressEnv              = "SMARTHOME_LISTEN_ADDRESS"
-	jwtSecretEnv            = "SMARTHOME_JWT_SECRET"
-	jwtExpirationEnv        = "SMARTHOME_JWT_EXPIRATION"
-	awsRegionEnv            = "SMARTHOME_AWS_REGION"
-	corsOriginsEnv          = "SMARTHOME_CORS_ORIGINS"
-	dynamoDBEndpointEnv     = "SMARTHOME_DYNAMODB_ENDPOINT"
-	dynamoDBAuthTableEnv    = "SMARTHOME_DYNAMODB_AUTH_TABLE"
-	dynamoDBControlTableEnv = "SMARTHOME_DYNAMODB_CONTROL_PLANE_TABLE"
-	dynamoDBOutsideTableEnv = "SMARTHOME_DYNAMODB_TEMPERATURE_OUTSIDE_TABLE"
-	dynamoDBInsideTableEnv  = "SMARTHOME_DYNAMODB_TEMPERATURE_INSIDE_TABLE"
+	portEnv                  = "SMARTHOME_SERVER_PORT"
+	addressEnv               = "SMARTHOME_LISTEN_ADDRESS"
+	jwtSecretEnv             = "SMARTHOME_JWT_SECRET"
+	jwtExpirationEnv         = "SMARTHOME_JWT_EXPIRATION"
+	jwtPrivateKeyEnv         = "SMARTHOME_JWT_PRIVATE_KEY"
+	jwtVerificationKeysEnv   = "SMARTHOME_JWT_VERIFICATION_KEYS"
+	awsRegionEnv             = "SMARTHOME_AWS_REGION"
+	corsOriginsEnv           = "SMARTHOME_CORS_ORIGINS"
+	dynamoDBEndpointEnv      = "SMARTHOME_DYNAMODB_ENDPOINT"
+	dynamoDBAuthTableEnv     = "SMARTHOME_DYNAMODB_AUTH_TABLE"
+	dynamoDBControlTableEnv  = "SMARTHOME_DYNAMODB_CONTROL_PLANE_TABLE"
+	dynamoDBOutsideTableEnv  = "SMARTHOME_DYNAMODB_TEMPERATURE_OUTSIDE_TABLE"
+	dynamoDBInsideTableEnv   = "SMARTHOME_DYNAMODB_TEMPERATURE_INSIDE_TABLE"
+	dynamoDBAttemptsTableEnv = "SMARTHOME_DYNAMODB_LOGIN_ATTEMPTS_TABLE"
+	dynamoDBHeatingTableEnv  = "SMARTHOME_DYNAMODB_HEATING_TABLE"
+	dynamoDBHistoryTableEnv  = "SMARTHOME_DYNAMODB_HISTORY_TABLE"
+	loginMaxAttemptsEnv      = "SMARTHOME_LOGIN_MAX_ATTEMPTS"
+	loginMaxAttemptsIPEnv    = "SMARTHOME_LOGIN_MAX_ATTEMPTS_PER_IP"
+	loginLockoutEnv          = "SMARTHOME_LOGIN_LOCKOUT_DURATION"
+	passwordMinLengthEnv     = "SMARTHOME_PASSWORD_MIN_LENGTH"
+	passwordBreachedListEnv  = "SMARTHOME_PASSWORD_BREACHED_LIST"
+	bcryptCostEnv            = "SMARTHOME_BCRYPT_COST"
+	roomsEnv                 = "SMARTHOME_ROOMS"
+	shutdownTimeoutEnv       = "SMARTHOME_SHUTDOWN_TIMEOUT"
+	tlsCertEnv               = "SMARTHOME_TLS_CERT"
+	tlsKeyEnv                = "SMARTHOME_TLS_KEY"
+	tlsClientCAEnv           = "SMARTHOME_TLS_CLIENT_CA"
+	tlsRedirectPortEnv       = "SMARTHOME_TLS_REDIRECT_PORT"
+	tlsReloadIntervalEnv     = "SMARTHOME_TLS_RELOAD_INTERVAL"
+	oidcIssuerEnv            = "SMARTHOME_OIDC_ISSUER"
+	oidcClientIDEnv          = "SMARTHOME_OIDC_CLIENT_ID"
+	oidcClientSecretEnv      = "SMARTHOME_OIDC_CLIENT_SECRET"
+	oidcRedirectURLEnv       = "SMARTHOME_OIDC_REDIRECT_URL"
+	oidcUsernameClaimEnv     = "SMARTHOME_OIDC_USERNAME_CLAIM"
+	oidcDefaultRoleEnv       = "SMARTHOME_OIDC_DEFAULT_ROLE"
+	oidcPostLoginURLEnv      = "SMARTHOME_OIDC_POST_LOGIN_REDIRECT"
+	openAPIValidationEnv     = "SMARTHOME_OPENAPI_VALIDATION"
+	safetyMinThresholdEnv    = "SMARTHOME_SAFETY_MIN_THRESHOLD"
+	safetyMaxThresholdEnv    = "SMARTHOME_SAFETY_MAX_THRESHOLD"
+	safetyMinHysteresisEnv   = "SMARTHOME_SAFETY_MIN_HYSTERESIS"
+	safetyFrostProtectionEnv = "SMARTHOME_SAFETY_FROST_PROTECTION"
+	thresholdPrecisionEnv    = "SMARTHOME_THRESHOLD_PRECISION"
+	rollupIntervalEnv        = "SMARTHOME_READINGS_ROLLUP_INTERVAL"
+	retentionRawEnv          = "SMARTHOME_READINGS_RETENTION_RAW"
+	retentionFiveMinutesEnv  = "SMARTHOME_READINGS_RETENTION_FIVE_MINUTES"
+	retentionHourlyEnv       = "SMARTHOME_READINGS_RETENTION_HOURLY"
+	retentionDailyEnv        = "SMARTHOME_READINGS_RETENTION_DAILY"
+	energyDefaultWattageEnv  = "SMARTHOME_ENERGY_DEFAULT_WATTAGE"
+	energyPriceEnv           = "SMARTHOME_ENERGY_PRICE"
+	energyCurrencyEnv        = "SMARTHOME_ENERGY_CURRENCY"
+	energyTimezoneEnv        = "SMARTHOME_ENERGY_TIMEZONE"
+	weatherProviderEnv       = "SMARTHOME_WEATHER_PROVIDER"
+	weatherIntervalEnv       = "SMARTHOME_WEATHER_INTERVAL"
+	weatherLatitudeEnv       = "SMARTHOME_WEATHER_LATITUDE"
+	weatherLongitudeEnv      = "SMARTHOME_WEATHER_LONGITUDE"
+	weatherURLEnv            = "SMARTHOME_WEATHER_URL"
+	weatherForecastHoursEnv  = "SMARTHOME_WEATHER_FORECAST_HOURS"
+	weatherFileEnv           = "SMARTHOME_WEATHER_FILE"
 )
 
 const (
-	portFlag                 = "server.port"
-	addressFlag              = "server.address"
-	jwtSecretFlag            = "server.jwt.secret"
-	jwtExpirationFlag        = "server.jwt.expiration"
-	awsRegionFlag            = "aws.region"
-	corsOriginsFlag          = "cors.origins"
-	dynamoDBEndpointFlag     = "aws.dynamodb.endpoint"
-	dynamoDBAuthTableFlag    = "aws.dynamodb.tables.auth"
-	dynamoDBControlTableFlag = "aws.dynamodb.tables.control"
-	dynamoDBOutsideTableFlag = "aws.dynamodb.tables.outside"
-	dynamoDBInsideTableFlag  = "aws.dynamodb.tables.inside"
+	portFlag                  = "server.port"
+	addressFlag               = "server.address"
+	jwtSecretFlag             = "server.jwt.secret"
+	jwtExpirationFlag         = "server.jwt.expiration"
+	jwtPrivateKeyFlag         = "server.jwt.private_key"
+	jwtVerificationKeysFlag   = "server.jwt.verification_keys"
+	awsRegionFlag             = "aws.region"
+	corsOriginsFlag           = "cors.origins"
+	dynamoDBEndpointFlag      = "aws.dynamodb.endpoint"
+	dynamoDBAuthTableFlag     = "aws.dynamodb.tables.auth"
+	dynamoDBControlTableFlag  = "aws.dynamodb.tables.control"
+	dynamoDBOutsideTableFlag  = "aws.dynamodb.tables.outside"
+	dynamoDBInsideTableFlag   = "aws.dynamodb.tables.inside"
+	dynamoDBAttemptsTableFlag = "aws.dynamodb.tables.login_attempts"
+	dynamoDBHeatingTableFlag  = "aws.dynamodb.tables.heating"
+	dynamoDBHistoryTableFlag  = "aws.dynamodb.tables.history"
+	loginMaxAttemptsFlag      = "login.max_attempts"
+	loginMaxAttemptsIPFlag    = "login.max_attempts_per_ip"
+	loginLockoutFlag          = "login.lockout_duration"
+	passwordMinLengthFlag     = "password.min_length"
+	passwordBreachedListFlag  = "password.breached_list"
+	bcryptCostFlag            = "password.bcrypt_cost"
+	roomsFlag                 = "rooms"
+	shutdownTimeoutFlag       = "server.shutdown.timeout"
+	tlsCertFlag               = "server.tls.cert"
+	tlsKeyFlag                = "server.tls.key"
+	tlsClientCAFlag           = "server.tls.client_ca"
+	tlsRedirectPortFlag       = "server.tls.redirect_port"
+	tlsReloadIntervalFlag     = "server.tls.reload_interval"
+	oidcIssuerFlag            = "oidc.issuer"
+	oidcClientIDFlag          = "oidc.client_id"
+	oidcClientSecretFlag      = "oidc.client_secret"
+	oidcRedirectURLFlag       = "oidc.redirect_url"
+	oidcUsernameClaimFlag     = "oidc.username_claim"
+	oidcDefaultRoleFlag       = "oidc.default_role"
+	oidcPostLoginURLFlag      = "oidc.post_login_redirect"
+	openAPIValidationFlag     = "server.openapi_validation"
+	safetyMinThresholdFlag    = "safety.min_threshold"
+	safetyMaxThresholdFlag    = "safety.max_threshold"
+	safetyMinHysteresisFlag   = "safety.min_hysteresis"
+	safetyFrostProtectionFlag = "safety.frost_protection"
+	safetyRoomsFlag           = "safety.rooms"
+	thresholdPrecisionFlag    = "thresholds.precision"
+	rollupIntervalFlag        = "readings.rollup_interval"
+	retentionRawFlag          = "readings.retention.raw"
+	retentionFiveMinutesFlag  = "readings.retention.five_minutes"
+	retentionHourlyFlag       = "readings.retention.hourly"
+	retentionDailyFlag        = "readings.retention.daily"
+	energyDefaultWattageFlag  = "energy.default_wattage"
+	energyWattageFlag         = "energy.wattage"
+	energyPriceFlag           = "energy.tariff.price"
+	energyCurrencyFlag        = "energy.tariff.currency"
+	energyTimezoneFlag        = "energy.tariff.timezone"
+	energyBandsFlag           = "energy.tariff.bands"
+	weatherCompensationFlag   = "weather_compensation.rooms"
+	weatherProviderFlag       = "weather.provider"
+	weatherIntervalFlag       = "weather.interval"
+	weatherLatitudeFlag       = "weather.latitude"
+	weatherLongitudeFlag      = "weather.longitude"
+	weatherURLFlag            = "weather.url"
+	weatherForecastHoursFlag  = "weather.forecast_hours"
+	weatherFileFlag           = "weather.file"
 )
 
-const apiVersion string = "v1"
-
 // serveCmd represents the serve command
 var (
 	serveCmd = &cobra.Command{
@@ -63,44 +166,127 @@ func serve(cmd *cobra.Command, args []string) {
 	jwtSecret := viper.GetString(jwtSecretFlag)
 	address := viper.GetString(addressFlag)
 	port := viper.GetInt(portFlag)
-	origins := strings.Split(viper.GetString(corsOriginsFlag), " ")
 	dynamoDBEndpoint := viper.GetString(dynamoDBEndpointFlag)
 	dynamoDBAuthTable := viper.GetString(dynamoDBAuthTableFlag)
 	dynamoDBControlTable := viper.GetString(dynamoDBControlTableFlag)
 	dynamoDBOutsiteTable := viper.GetString(dynamoDBOutsideTableFlag)
 	dynamoDBInsiteTable := viper.GetString(dynamoDBInsideTableFlag)
 
+	lockoutDuration, err := time.ParseDuration(viper.GetString(loginLockoutFlag))
+	if err != nil || lockoutDuration <= 0 {
+		sugar.Fatalw("invalid login lockout duration", "duration", viper.GetString(loginLockoutFlag))
+	}
+	lockout := controller.DefaultLoginLockout
+	lockout.MaxAttempts = viper.GetInt(loginMaxAttemptsFlag)
+	lockout.MaxAttemptsPerIP = viper.GetInt(loginMaxAttemptsIPFlag)
+	lockout.LockoutDuration = lockoutDuration
+	if lockout.MaxAttempts <= 0 || lockout.MaxAttemptsPerIP <= 0 {
+		sugar.Fatalw("the maximum number of login attempts must be positive",
+			"max_attempts", lockout.MaxAttempts, "max_attempts_per_ip", lockout.MaxAttemptsPerIP)
+	}
+
+	passwordPolicy := controller.PasswordPolicy{MinLength: viper.GetInt(passwordMinLengthFlag)}
+	if passwordPolicy.MinLength <= 0 {
+		sugar.Fatalw("the minimum password length must be positive", "min_length", passwordPolicy.MinLength)
+	}
+	if breachedList := viper.GetString(passwordBreachedListFlag); breachedList != "" {
+		passwordPolicy.Breached, err = utils.LoadPasswordList(breachedList)
+		if err != nil {
+			sugar.Fatalw("error loading breached passwords", "error", err.Error())
+		}
+		sugar.Infow("loaded breached passwords", "file", breachedList, "count", len(passwordPolicy.Breached))
+	}
+	bcryptCost := viper.GetInt(bcryptCostFlag)
+	if bcryptCost < bcrypt.MinCost || bcryptCost > bcrypt.MaxCost {
+		sugar.Fatalw("invalid bcrypt cost", "cost", bcryptCost, "min", bcrypt.MinCost, "max", bcrypt.MaxCost)
+	}
+
+	safety := readSafetyPolicy()
+	if err := safety.Validate(); err != nil {
+		sugar.Fatalw("invalid safety limits", "error", err.Error())
+	}
+	thresholdPrecision := float32(viper.GetFloat64(thresholdPrecisionFlag))
+	if err := controller.ValidateThresholdPrecision(thresholdPrecision); err != nil {
+		sugar.Fatalw("invalid threshold precision", "error", err.Error())
+	}
+
+	energy := readEnergyConfig()
+	if err := energy.Validate(); err != nil {
+		sugar.Fatalw("invalid energy settings", "error", err.Error())
+	}
+
+	compensation := readWeatherCompensation()
+	for room, policy := range compensation {
+		if err := policy.Validate(); err != nil {
+			sugar.Fatalw("invalid weather compensation", "room", room, "error", err.Error())
+		}
+	}
+
+	weather := readWeatherProvider()
+	weatherInterval, err := time.ParseDuration(viper.GetString(weatherIntervalFlag))
+	if err != nil || weatherInterval <= 0 {
+		sugar.Fatalw("invalid weather fetch interval", "interval", viper.GetString(weatherIntervalFlag))
+	}
+
+	retention := readReadingsRetention()
+	rollupInterval, err := time.ParseDuration(viper.GetString(rollupIntervalFlag))
+	if err != nil || rollupInterval < 0 {
+		sugar.Fatalw("invalid readings rollup interval", "interval", viper.GetString(rollupIntervalFlag))
+	}
+
 	sugar.Infow("creating DynamoDB client", "region", region, "url", dynamoDBEndpoint)
 	dynamoClient, err := utils.InitDynamoClient(region, dynamoDBEndpoint)
 	if err != nil {
 		sugar.Fatalw("error creating DynamoDB client", "error", err.Error())
 	}
 
-	expiration, err := time.ParseDuration(viper.GetString(jwtExpirationFlag))
+	live, err := readLiveConfig(viper.GetViper())
+	if err != nil {
+		sugar.Fatalw("invalid configuration", "error", err.Error())
+	}
+	api.SetValidRooms(live.Rooms)
 
+	keys, err := utils.LoadKeySet(jwtSecret, viper.GetString(jwtPrivateKeyFlag), utils.SplitList(viper.GetStringSlice(jwtVerificationKeysFlag)...))
 	if err != nil {
-		sugar.Fatalw("invalid parameters for the JWT expiration time", "expiration", viper.GetString(jwtExpirationFlag))
+		sugar.Fatalw("error loading JWT keys", "error", err.Error())
 	}
 
+	sh := controller.NewSmartHome(
+		controller.SetLogger(sugar),
+		controller.SetDynamoDBClient(dynamoClient),
+		controller.SetConfig(&controller.SmartHomeConfig{
+			AuthTable:         dynamoDBAuthTable,
+			ControlPlaneTable: dynamoDBControlTable,
+			TempOutsideTable:  dynamoDBOutsiteTable,
+			TempInsideTable:   dynamoDBInsiteTable,
+
+			LoginAttemptsTable: viper.GetString(dynamoDBAttemptsTableFlag),
+			HeatingTable:       viper.GetString(dynamoDBHeatingTableFlag),
+			HistoryTable:       viper.GetString(dynamoDBHistoryTableFlag),
+			LoginLockout:       lockout,
+			PasswordPolicy:     passwordPolicy,
+			BcryptCost:         bcryptCost,
+			Safety:             safety,
+			ThresholdPrecision: thresholdPrecision,
+			Retention:          retention,
+			Energy:             energy,
+
+			WeatherCompensation: compensation,
+		}),
+	)
 	s := api.NewClient(
 		api.JWTConfig{
 			JWTSecret:     jwtSecret,
-			JWTExpiration: expiration,
+			JWTExpiration: live.JWTExpiration,
+			Keys:          keys,
 		},
-		controller.NewSmartHome(
-			controller.SetLogger(sugar),
-			controller.SetDynamoDBClient(dynamoClient),
-			controller.SetConfig(&controller.SmartHomeConfig{
-				AuthTable:         dynamoDBAuthTable,
-				ControlPlaneTable: dynamoDBControlTable,
-				TempOutsideTable:  dynamoDBOutsiteTable,
-				TempInsideTable:   dynamoDBInsiteTable,
-			}),
-		),
+		sh,
 	)
 
 	e := echo.New()
+	e.HTTPErrorHandler = api.HTTPErrorHandler
 	e.Use(middleware.Recover())
+	e.Use(middleware.RequestID())
 	e.Use(middleware.LoggerWithConfig(middleware.LoggerConfig{
 		Format: `{"ts":"${time_unix}","id":"${id}","remote_ip":"${remote_ip}","host":"${host}",` +
 			`"method":"${method}","uri":"${uri}","status":${status},"error":"${error}","latency":${latency},` +
@@ -108,32 +294,207 @@ func serve(cmd *cobra.Command, args []string) {
 		Output: os.Stdout,
 	}))
 
-	if len(origins) > 0 {
-		if err = utils.ValidateOriginURLsFromArray(origins); err != nil {
-			sugar.Fatalw("invalid CORS URLs provided", "error", err.Error())
+	cors := &corsMiddleware{}
+	cors.SetOrigins(live.Origins)
+	e.Use(cors.Middleware)
+
+	switch validation := viper.GetString(openAPIValidationFlag); validation {
+	case "off":
+	case "log", "strict":
+		e.Use(api.ValidateOpenAPI(api.OpenAPISpec(), validation == "strict", sugar))
+	default:
+		sugar.Fatalw("invalid OpenAPI validation mode, must be one of off, log or strict", "mode", validation)
+	}
+
+	bg := newWorkers()
+	tlsConfig, err := newTLSConfig(bg)
+	if err != nil {
+		sugar.Fatalw("error configuring TLS", "error", err.Error())
+	}
+
+	if rollupInterval > 0 {
+		bg.Go("readings-rollup", rollupWorker(sh, rollupInterval))
+	}
+	if weather != nil {
+		bg.Go("weather-fetch", weatherWorker(sh, weather, weatherInterval))
+	}
+
+	if keys.Empty() {
+		sugar.Warn("no jwt secret or private key provided, disabling authentication")
+	} else if viper.GetString(oidcIssuerFlag) != "" {
+		s.OIDC, err = newOIDCConfig()
+		if err != nil {
+			sugar.Fatalw("error configuring OpenID Connect login", "error", err.Error())
 		}
-		e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
-			AllowOrigins: origins,
-		}))
-	}
-
-	room := e.Group(fmt.Sprintf("%s/room", apiVersion))
-	if jwtSecret != "" {
-		room.Use(middleware.JWT([]byte(jwtSecret)))
-		e.POST(fmt.Sprintf("%s/login", apiVersion), s.Login)
-		e.POST(fmt.Sprintf("%s/signup", apiVersion), s.SignUp)
-		e.DELETE(fmt.Sprintf("%s/user", apiVersion), s.DeleteUser)
-	} else {
-		sugar.Warn("no jwt secret provided, disabling authentication")
-	}
-	room.POST("/:room", s.SetRoomOptions)
-	room.GET("/:room", s.GetRoomOptions)
-	room.DELETE("/:room", s.DeleteRoomOptions)
+	}
+	s.RegisterRoutes(e, keys)
 	p := prometheus.NewPrometheus("smarthome", nil)
 	p.Use(e)
 
-	sugar.Infow("starting server", "address", address, "port", port)
-	sugar.Fatal(e.Start(fmt.Sprintf("%s:%d", address, port)))
+	shutdownTimeout, err := time.ParseDuration(viper.GetString(shutdownTimeoutFlag))
+	if err != nil {
+		sugar.Fatalw("invalid parameters for the shutdown timeout", "timeout", viper.GetString(shutdownTimeoutFlag))
+	}
+
+	reloader := &configReloader{
+		flags: cmd.Flags(),
+		apply: func(cfg *liveConfig) {
+			utils.SetVerbose(logLevel, cfg.Verbose)
+			cors.SetOrigins(cfg.Origins)
+			s.SetJWTExpiration(cfg.JWTExpiration)
+			api.SetValidRooms(cfg.Rooms)
+		},
+	}
+	if viper.ConfigFileUsed() != "" {
+		sugar.Infow("watching config file for changes", "file", viper.ConfigFileUsed())
+		bg.Go("config-watcher", reloader.Watch)
+	}
+
+	serverErrors := make(chan error, 2)
+	go func() {
+		listenAddress := fmt.Sprintf("%s:%d", address, port)
+		if tlsConfig == nil {
+			sugar.Infow("starting server", "address", address, "port", port)
+			serverErrors <- e.Start(listenAddress)
+			return
+		}
+		sugar.Infow("starting TLS server", "address", address, "port", port)
+		e.TLSServer.Addr = listenAddress
+		e.TLSServer.TLSConfig = tlsConfig
+		serverErrors <- e.StartServer(e.TLSServer)
+	}()
+
+	var redirect *http.Server
+	if redirectPort := viper.GetInt(tlsRedirectPortFlag); tlsConfig != nil && redirectPort != 0 {
+		redirect = &http.Server{
+			Addr:    fmt.Sprintf("%s:%d", address, redirectPort),
+			Handler: utils.HTTPSRedirectHandler(port),
+		}
+		go func() {
+			sugar.Infow("starting HTTP to HTTPS redirect server", "address", address, "port", redirectPort)
+			serverErrors <- redirect.ListenAndServe()
+		}()
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+wait:
+	for {
+		select {
+		case err := <-serverErrors:
+			if err != nil && err != http.ErrServerClosed {
+				bg.Stop(context.Background())
+				sugar.Fatalw("error starting server", "error", err.Error())
+			}
+			break wait
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				sugar.Infow("reloading configuration", "file", viper.ConfigFileUsed())
+				if err := reloader.Reload(); err != nil {
+					sugar.Errorw("error reloading configuration, keeping the previous one", "error", err.Error())
+				}
+				continue
+			}
+			sugar.Infow("shutting down server", "signal", sig.String(), "timeout", shutdownTimeout)
+			break wait
+		}
+	}
+	signal.Stop(signals)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if redirect != nil {
+		if err := redirect.Shutdown(ctx); err != nil {
+			sugar.Errorw("error shutting down HTTP to HTTPS redirect server", "error", err.Error())
+		}
+	}
+	if err := e.Shutdown(ctx); err != nil {
+		sugar.Errorw("error draining in-flight requests", "error", err.Error())
+	}
+	if err := bg.Stop(ctx); err != nil {
+		sugar.Errorw("error stopping background workers", "error", err.Error())
+	}
+	sugar.Info("server stopped")
+	sugar.Sync()
+}
+
+// readSafetyPolicy reads the safety limits of the thresholds of the rooms. The
+// limits of some rooms can be overridden in the configuration file, e.g.
+// safety.rooms.kids.min_threshold.
+func readSafetyPolicy() controller.SafetyPolicy {
+	policy := controller.SafetyPolicy{
+		SafetyLimits: controller.SafetyLimits{
+			MinThreshold:    float32(viper.GetFloat64(safetyMinThresholdFlag)),
+			MaxThreshold:    float32(viper.GetFloat64(safetyMaxThresholdFlag)),
+			MinHysteresis:   float32(viper.GetFloat64(safetyMinHysteresisFlag)),
+			FrostProtection: float32(viper.GetFloat64(safetyFrostProtectionFlag)),
+		},
+		Rooms: map[string]controller.SafetyOverride{},
+	}
+	for room := range viper.GetStringMap(safetyRoomsFlag) {
+		key := func(name string) string { return fmt.Sprintf("%s.%s.%s", safetyRoomsFlag, room, name) }
+		policy.Rooms[room] = controller.SafetyOverride{
+			MinThreshold:    optionalFloat32(key("min_threshold")),
+			MaxThreshold:    optionalFloat32(key("max_threshold")),
+			MinHysteresis:   optionalFloat32(key("min_hysteresis")),
+			FrostProtection: optionalFloat32(key("frost_protection")),
+		}
+	}
+	return policy
+}
+
+// optionalFloat32 returns the value of a setting, or nil if it isn't set
+func optionalFloat32(key string) *float32 {
+	if !viper.IsSet(key) {
+		return nil
+	}
+	value := float32(viper.GetFloat64(key))
+	return &value
+}
+
+// readEnergyConfig reads the wattage of the heaters and the tariff the energy
+// they use is estimated with. The wattage of some rooms and the time-of-use
+// bands of the tariff can be set in the configuration file, e.g.
+// energy.wattage.bedroom.
+func readEnergyConfig() controller.EnergyConfig {
+	location, err := time.LoadLocation(viper.GetString(energyTimezoneFlag))
+	if err != nil {
+		sugar.Fatalw("invalid time zone of the tariff", "timezone", viper.GetString(energyTimezoneFlag), "error", err.Error())
+	}
+	config := controller.EnergyConfig{
+		DefaultWattage: viper.GetFloat64(energyDefaultWattageFlag),
+		Wattage:        map[string]float64{},
+		Tariff: controller.Tariff{
+			Currency: viper.GetString(energyCurrencyFlag),
+			Price:    viper.GetFloat64(energyPriceFlag),
+			Location: location,
+		},
+	}
+	for room := range viper.GetStringMap(energyWattageFlag) {
+		config.Wattage[room] = viper.GetFloat64(fmt.Sprintf("%s.%s", energyWattageFlag, room))
+	}
+	if err := viper.UnmarshalKey(energyBandsFlag, &config.Tariff.Bands); err != nil {
+		sugar.Fatalw("invalid bands of the tariff", "error", err.Error())
+	}
+	return config
+}
+
+// readWeatherCompensation reads the weather compensation of the rooms that
+// have one in the configuration file, e.g. weather_compensation.rooms.bedroom
+// with its window and curve.
+func readWeatherCompensation() map[string]controller.WeatherCompensation {
+	compensation := map[string]controller.WeatherCompensation{}
+	for room := range viper.GetStringMap(weatherCompensationFlag) {
+		key := func(name string) string { return fmt.Sprintf("%s.%s.%s", weatherCompensationFlag, room, name) }
+		policy := controller.WeatherCompensation{Window: viper.GetDuration(key("window"))}
+		if err := viper.UnmarshalKey(key("curve"), &policy.Curve); err != nil {
+			sugar.Fatalw("invalid weather compensation curve", "room", room, "error", err.Error())
+		}
+		compensation[room] = policy
+	}
+	return compensation
 }
 
 func init() {
@@ -147,8 +508,52 @@ func init() {
 	serveCmd.Flags().String("dynamodb-control-table", controller.DefaultControlPlaneTable, "DynamoDB Control Plane table name")
 	serveCmd.Flags().String("dynamodb-outside-table", controller.DefaultTempOutsideTable, "DynamoDB Temperature Outside table name")
 	serveCmd.Flags().String("dynamodb-inside-table", controller.DefaultTempInsideTable, "DynamoDB Temperature Inside table name")
+	serveCmd.Flags().String("dynamodb-login-attempts-table", controller.DefaultLoginAttemptsTable, "DynamoDB Login Attempts table name")
+	serveCmd.Flags().String("dynamodb-heating-table", controller.DefaultHeatingTable, "DynamoDB Heating Transitions table name")
+	serveCmd.Flags().String("dynamodb-history-table", controller.DefaultHistoryTable, "DynamoDB Room Settings History table name")
+	serveCmd.Flags().Int("login-max-attempts", controller.DefaultLoginLockout.MaxAttempts, "Consecutive failed logins allowed for a user before locking it out")
+	serveCmd.Flags().Int("login-max-attempts-per-ip", controller.DefaultLoginLockout.MaxAttemptsPerIP, "Consecutive failed logins allowed from a client IP address before locking it out")
+	serveCmd.Flags().Int("password-min-length", controller.DefaultPasswordMinLength, "Minimum number of characters of the passwords")
+	serveCmd.Flags().String("password-breached-list", "", "File with one breached password per line, which can't be used")
+	serveCmd.Flags().Int("bcrypt-cost", bcrypt.DefaultCost, "Cost used for hashing passwords with bcrypt")
+	serveCmd.Flags().String("login-lockout-duration", controller.DefaultLoginLockout.LockoutDuration.String(), "How long logins are rejected after too many failed attempts")
+	serveCmd.Flags().String("jwt-private-key", "", "PEM file with the RSA or ECDSA private key used for signing JWT tokens. Takes precedence over the JWT secret")
+	serveCmd.Flags().StringSlice("jwt-verification-keys", []string{}, "Comma-separated list of PEM files with additional public keys accepted when verifying JWT tokens, e.g. previous signing keys")
 	serveCmd.Flags().String("jwt-expiration", "1h", "Expiration of JWT token. See https://golang.org/pkg/time/#ParseDuration for an example of how to set this parameter")
 	serveCmd.Flags().String("cors-origins", "", "Space-separated list of CORS Origin URLs")
+	serveCmd.Flags().StringSlice("rooms", utils.AllButOne(api.ValidRooms, api.AllRooms), "Comma-separated list of rooms in the home")
+	serveCmd.Flags().String("tls-cert", "", "TLS certificate file. If set along with --tls-key, the server listens on HTTPS")
+	serveCmd.Flags().String("tls-key", "", "TLS private key file")
+	serveCmd.Flags().String("tls-client-ca", "", "CA certificates file used to authenticate clients presenting a TLS certificate, as an alternative to JWT")
+	serveCmd.Flags().Int("tls-redirect-port", 0, "Port of an HTTP listener redirecting to HTTPS (disabled if 0)")
+	serveCmd.Flags().String("tls-reload-interval", "1m", "How often to check the TLS certificate and key files for changes")
+	serveCmd.Flags().String("oidc-issuer", "", "Issuer URL of an OpenID Connect provider users can log in with")
+	serveCmd.Flags().String("oidc-client-id", "", "Client ID registered in the OpenID Connect provider")
+	serveCmd.Flags().String("oidc-client-secret", "", "Client secret registered in the OpenID Connect provider")
+	serveCmd.Flags().String("oidc-redirect-url", "", "Public URL of the /v1/auth/oidc/callback endpoint registered in the OpenID Connect provider")
+	serveCmd.Flags().String("oidc-username-claim", "email", "ID token claim used as the SmartHome username")
+	serveCmd.Flags().String("oidc-default-role", "", "Role of the users logging in for the first time through OpenID Connect. If empty, only existing users can log in")
+	serveCmd.Flags().String("oidc-post-login-redirect", "", "URL where users are redirected with their token after logging in through OpenID Connect. If empty, the token is returned as JSON")
+	serveCmd.Flags().String("openapi-validation", "log", "Validation of requests and responses against the OpenAPI specification: off, log (only log mismatches) or strict (reject them)")
+	serveCmd.Flags().Float32("safety-min-threshold", controller.DefaultMinThreshold, "Lowest threshold allowed for any room")
+	serveCmd.Flags().Float32("safety-max-threshold", controller.DefaultMaxThreshold, "Highest threshold allowed for any room")
+	serveCmd.Flags().Float32("safety-min-hysteresis", 0, "Minimum gap between the thresholds on and off of any room")
+	serveCmd.Flags().Float32("safety-frost-protection", controller.DefaultFrostProtection, "Temperature below which rooms are heated even if they're disabled")
+	serveCmd.Flags().Float32("threshold-precision", controller.DefaultThresholdPrecision, "Step in Celsius the thresholds of the rooms are rounded to, such as 0.5")
+	serveCmd.Flags().String("readings-rollup-interval", "5m", "How often readings are rolled up in the background, or 0 to only roll them up with the rollup command")
+	addReadingsRetentionFlags(serveCmd)
+	serveCmd.Flags().Float64("energy-default-wattage", controller.DefaultHeaterWattage, "Power in watts of the heaters of the rooms whose wattage isn't set in the configuration file")
+	serveCmd.Flags().Float64("energy-price", 0, "Price of a kWh outside of the time-of-use bands of the tariff")
+	serveCmd.Flags().String("energy-currency", "", "Currency of the price of the energy, such as EUR")
+	serveCmd.Flags().String("energy-timezone", "UTC", "Time zone of the time-of-use bands of the tariff and of the days energy usage is broken down into, such as Europe/Madrid")
+	serveCmd.Flags().String("weather-provider", "", "Provider the outside temperature is fetched from in the background: open-meteo or file. If empty, it isn't fetched")
+	serveCmd.Flags().String("weather-interval", "15m", "How often the outside temperature is fetched from the weather provider")
+	serveCmd.Flags().Float64("weather-latitude", 0, "Latitude of the home, for the open-meteo weather provider")
+	serveCmd.Flags().Float64("weather-longitude", 0, "Longitude of the home, for the open-meteo weather provider")
+	serveCmd.Flags().String("weather-url", utils.DefaultOpenMeteoURL, "Base URL of the Open-Meteo API")
+	serveCmd.Flags().Int("weather-forecast-hours", utils.DefaultForecastHours, "How many hours ahead the forecast is fetched from the open-meteo weather provider")
+	serveCmd.Flags().String("weather-file", "", "Path or URL of the METAR reports or JSON read by the file weather provider")
+	serveCmd.Flags().String("shutdown-timeout", "30s", "Maximum time to wait for in-flight requests and background workers to finish when shutting down")
 	viper.BindPFlag(portFlag, serveCmd.Flags().Lookup("port"))
 	viper.BindPFlag(addressFlag, serveCmd.Flags().Lookup("address"))
 	viper.BindPFlag(awsRegionFlag, serveCmd.Flags().Lookup("aws-region"))
@@ -157,12 +562,60 @@ func init() {
 	viper.BindPFlag(dynamoDBControlTableFlag, serveCmd.Flags().Lookup("dynamodb-control-table"))
 	viper.BindPFlag(dynamoDBOutsideTableFlag, serveCmd.Flags().Lookup("dynamodb-outside-table"))
 	viper.BindPFlag(dynamoDBInsideTableFlag, serveCmd.Flags().Lookup("dynamodb-inside-table"))
+	viper.BindPFlag(dynamoDBAttemptsTableFlag, serveCmd.Flags().Lookup("dynamodb-login-attempts-table"))
+	viper.BindPFlag(dynamoDBHeatingTableFlag, serveCmd.Flags().Lookup("dynamodb-heating-table"))
+	viper.BindPFlag(dynamoDBHistoryTableFlag, serveCmd.Flags().Lookup("dynamodb-history-table"))
+	viper.BindPFlag(loginMaxAttemptsFlag, serveCmd.Flags().Lookup("login-max-attempts"))
+	viper.BindPFlag(loginMaxAttemptsIPFlag, serveCmd.Flags().Lookup("login-max-attempts-per-ip"))
+	viper.BindPFlag(loginLockoutFlag, serveCmd.Flags().Lookup("login-lockout-duration"))
+	viper.BindPFlag(passwordMinLengthFlag, serveCmd.Flags().Lookup("password-min-length"))
+	viper.BindPFlag(passwordBreachedListFlag, serveCmd.Flags().Lookup("password-breached-list"))
+	viper.BindPFlag(bcryptCostFlag, serveCmd.Flags().Lookup("bcrypt-cost"))
+	viper.BindPFlag(jwtPrivateKeyFlag, serveCmd.Flags().Lookup("jwt-private-key"))
+	viper.BindPFlag(jwtVerificationKeysFlag, serveCmd.Flags().Lookup("jwt-verification-keys"))
 	viper.BindPFlag(jwtExpirationFlag, serveCmd.Flags().Lookup("jwt-expiration"))
 	viper.BindPFlag(corsOriginsFlag, serveCmd.Flags().Lookup("cors-origins"))
+	viper.BindPFlag(roomsFlag, serveCmd.Flags().Lookup("rooms"))
+	viper.BindPFlag(tlsCertFlag, serveCmd.Flags().Lookup("tls-cert"))
+	viper.BindPFlag(tlsKeyFlag, serveCmd.Flags().Lookup("tls-key"))
+	viper.BindPFlag(tlsClientCAFlag, serveCmd.Flags().Lookup("tls-client-ca"))
+	viper.BindPFlag(tlsRedirectPortFlag, serveCmd.Flags().Lookup("tls-redirect-port"))
+	viper.BindPFlag(tlsReloadIntervalFlag, serveCmd.Flags().Lookup("tls-reload-interval"))
+	viper.BindPFlag(shutdownTimeoutFlag, serveCmd.Flags().Lookup("shutdown-timeout"))
+	viper.BindPFlag(oidcIssuerFlag, serveCmd.Flags().Lookup("oidc-issuer"))
+	viper.BindPFlag(oidcClientIDFlag, serveCmd.Flags().Lookup("oidc-client-id"))
+	viper.BindPFlag(oidcClientSecretFlag, serveCmd.Flags().Lookup("oidc-client-secret"))
+	viper.BindPFlag(oidcRedirectURLFlag, serveCmd.Flags().Lookup("oidc-redirect-url"))
+	viper.BindPFlag(oidcUsernameClaimFlag, serveCmd.Flags().Lookup("oidc-username-claim"))
+	viper.BindPFlag(oidcDefaultRoleFlag, serveCmd.Flags().Lookup("oidc-default-role"))
+	viper.BindPFlag(oidcPostLoginURLFlag, serveCmd.Flags().Lookup("oidc-post-login-redirect"))
+	viper.BindPFlag(openAPIValidationFlag, serveCmd.Flags().Lookup("openapi-validation"))
+	viper.BindPFlag(safetyMinThresholdFlag, serveCmd.Flags().Lookup("safety-min-threshold"))
+	viper.BindPFlag(safetyMaxThresholdFlag, serveCmd.Flags().Lookup("safety-max-threshold"))
+	viper.BindPFlag(safetyMinHysteresisFlag, serveCmd.Flags().Lookup("safety-min-hysteresis"))
+	viper.BindPFlag(safetyFrostProtectionFlag, serveCmd.Flags().Lookup("safety-frost-protection"))
+	viper.BindPFlag(thresholdPrecisionFlag, serveCmd.Flags().Lookup("threshold-precision"))
+	viper.BindPFlag(rollupIntervalFlag, serveCmd.Flags().Lookup("readings-rollup-interval"))
+	viper.BindPFlag(energyDefaultWattageFlag, serveCmd.Flags().Lookup("energy-default-wattage"))
+	viper.BindPFlag(energyPriceFlag, serveCmd.Flags().Lookup("energy-price"))
+	viper.BindPFlag(energyCurrencyFlag, serveCmd.Flags().Lookup("energy-currency"))
+	viper.BindPFlag(energyTimezoneFlag, serveCmd.Flags().Lookup("energy-timezone"))
+	viper.BindPFlag(weatherProviderFlag, serveCmd.Flags().Lookup("weather-provider"))
+	viper.BindPFlag(weatherIntervalFlag, serveCmd.Flags().Lookup("weather-interval"))
+	viper.BindPFlag(weatherLatitudeFlag, serveCmd.Flags().Lookup("weather-latitude"))
+	viper.BindPFlag(weatherLongitudeFlag, serveCmd.Flags().Lookup("weather-longitude"))
+	viper.BindPFlag(weatherURLFlag, serveCmd.Flags().Lookup("weather-url"))
+	viper.BindPFlag(weatherForecastHoursFlag, serveCmd.Flags().Lookup("weather-forecast-hours"))
+	viper.BindPFlag(weatherFileFlag, serveCmd.Flags().Lookup("weather-file"))
+	for key, name := range readingsRetentionFlags {
+		viper.BindPFlag(key, serveCmd.Flags().Lookup(name))
+	}
 	viper.BindEnv(portFlag, portEnv)
 	viper.BindEnv(addressFlag, addressEnv)
 	viper.BindEnv(jwtSecretFlag, jwtSecretEnv)
 	viper.BindEnv(jwtExpirationFlag, jwtExpirationEnv)
+	viper.BindEnv(jwtPrivateKeyFlag, jwtPrivateKeyEnv)
+	viper.BindEnv(jwtVerificationKeysFlag, jwtVerificationKeysEnv)
 	viper.BindEnv(awsRegionFlag, awsRegionEnv)
 	viper.BindEnv(corsOriginsFlag, corsOriginsEnv)
 	viper.BindEnv(dynamoDBEndpointFlag, dynamoDBEndpointEnv)
@@ -170,4 +623,49 @@ func init() {
 	viper.BindEnv(dynamoDBControlTableFlag, dynamoDBControlTableEnv)
 	viper.BindEnv(dynamoDBOutsideTableFlag, dynamoDBOutsideTableEnv)
 	viper.BindEnv(dynamoDBInsideTableFlag, dynamoDBInsideTableEnv)
+	viper.BindEnv(dynamoDBAttemptsTableFlag, dynamoDBAttemptsTableEnv)
+	viper.BindEnv(dynamoDBHeatingTableFlag, dynamoDBHeatingTableEnv)
+	viper.BindEnv(dynamoDBHistoryTableFlag, dynamoDBHistoryTableEnv)
+	viper.BindEnv(loginMaxAttemptsFlag, loginMaxAttemptsEnv)
+	viper.BindEnv(loginMaxAttemptsIPFlag, loginMaxAttemptsIPEnv)
+	viper.BindEnv(loginLockoutFlag, loginLockoutEnv)
+	viper.BindEnv(passwordMinLengthFlag, passwordMinLengthEnv)
+	viper.BindEnv(passwordBreachedListFlag, passwordBreachedListEnv)
+	viper.BindEnv(bcryptCostFlag, bcryptCostEnv)
+	viper.BindEnv(roomsFlag, roomsEnv)
+	viper.BindEnv(shutdownTimeoutFlag, shutdownTimeoutEnv)
+	viper.BindEnv(tlsCertFlag, tlsCertEnv)
+	viper.BindEnv(tlsKeyFlag, tlsKeyEnv)
+	viper.BindEnv(tlsClientCAFlag, tlsClientCAEnv)
+	viper.BindEnv(tlsRedirectPortFlag, tlsRedirectPortEnv)
+	viper.BindEnv(tlsReloadIntervalFlag, tlsReloadIntervalEnv)
+	viper.BindEnv(oidcIssuerFlag, oidcIssuerEnv)
+	viper.BindEnv(oidcClientIDFlag, oidcClientIDEnv)
+	viper.BindEnv(oidcClientSecretFlag, oidcClientSecretEnv)
+	viper.BindEnv(oidcRedirectURLFlag, oidcRedirectURLEnv)
+	viper.BindEnv(oidcUsernameClaimFlag, oidcUsernameClaimEnv)
+	viper.BindEnv(oidcDefaultRoleFlag, oidcDefaultRoleEnv)
+	viper.BindEnv(oidcPostLoginURLFlag, oidcPostLoginURLEnv)
+	viper.BindEnv(openAPIValidationFlag, openAPIValidationEnv)
+	viper.BindEnv(safetyMinThresholdFlag, safetyMinThresholdEnv)
+	viper.BindEnv(safetyMaxThresholdFlag, safetyMaxThresholdEnv)
+	viper.BindEnv(safetyMinHysteresisFlag, safetyMinHysteresisEnv)
+	viper.BindEnv(safetyFrostProtectionFlag, safetyFrostProtectionEnv)
+	viper.BindEnv(thresholdPrecisionFlag, thresholdPrecisionEnv)
+	viper.BindEnv(rollupIntervalFlag, rollupIntervalEnv)
+	viper.BindEnv(retentionRawFlag, retentionRawEnv)
+	viper.BindEnv(retentionFiveMinutesFlag, retentionFiveMinutesEnv)
+	viper.BindEnv(retentionHourlyFlag, retentionHourlyEnv)
+	viper.BindEnv(retentionDailyFlag, retentionDailyEnv)
+	viper.BindEnv(energyDefaultWattageFlag, energyDefaultWattageEnv)
+	viper.BindEnv(energyPriceFlag, energyPriceEnv)
+	viper.BindEnv(energyCurrencyFlag, energyCurrencyEnv)
+	viper.BindEnv(energyTimezoneFlag, energyTimezoneEnv)
+	viper.BindEnv(weatherProviderFlag, weatherProviderEnv)
+	viper.BindEnv(weatherIntervalFlag, weatherIntervalEnv)
+	viper.BindEnv(weatherLatitudeFlag, weatherLatitudeEnv)
+	viper.BindEnv(weatherLongitudeFlag, weatherLongitudeEnv)
+	viper.BindEnv(weatherURLFlag, weatherURLEnv)
+	viper.BindEnv(weatherForecastHoursFlag, weatherForecastHoursEnv)
+	viper.BindEnv(weatherFileFlag, weatherFileEnv)
 }
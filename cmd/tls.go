@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"time"
+
+	"github.com/igvaquero18/smarthome/utils"
+	"github.com/spf13/viper"
+)
+
+// newTLSConfig returns the TLS configuration of the server, or nil if no
+// certificate has been configured. The certificate and key files are
+// checked for changes by a background worker, and reloaded when needed.
+func newTLSConfig(bg *workers) (*tls.Config, error) {
+	certFile := viper.GetString(tlsCertFlag)
+	keyFile := viper.GetString(tlsKeyFlag)
+
+	if certFile == "" && keyFile == "" {
+		if viper.GetString(tlsClientCAFlag) != "" {
+			return nil, fmt.Errorf("a TLS certificate and key are required for client certificate authentication")
+		}
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("both the TLS certificate and key must be provided")
+	}
+
+	interval, err := time.ParseDuration(viper.GetString(tlsReloadIntervalFlag))
+	if err != nil || interval <= 0 {
+		return nil, fmt.Errorf("invalid TLS reload interval %s", viper.GetString(tlsReloadIntervalFlag))
+	}
+
+	reloader, err := utils.NewCertificateReloader(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := utils.NewTLSConfig(reloader, viper.GetString(tlsClientCAFlag))
+	if err != nil {
+		return nil, err
+	}
+
+	bg.Go("tls-certificate-reloader", func(ctx context.Context) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				reloaded, err := reloader.Reload()
+				if err != nil {
+					sugar.Errorw("error reloading TLS certificate, keeping the previous one", "error", err.Error())
+					continue
+				}
+				if reloaded {
+					sugar.Infow("TLS certificate reloaded", "cert", certFile, "key", keyFile)
+				}
+			}
+		}
+	})
+
+	return cfg, nil
+}
@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"context"
+	"time"
+
+	"github.com/igvaquero18/smarthome/controller"
+	"github.com/igvaquero18/smarthome/utils"
+	"github.com/spf13/viper"
+)
+
+// readWeatherProvider returns the provider the outside temperature is
+// fetched from, or nil if none is configured
+func readWeatherProvider() utils.WeatherProvider {
+	if viper.GetString(weatherProviderFlag) == "" {
+		return nil
+	}
+	provider, err := utils.NewWeatherProvider(utils.WeatherProviderConfig{
+		Provider:      viper.GetString(weatherProviderFlag),
+		Latitude:      viper.GetFloat64(weatherLatitudeFlag),
+		Longitude:     viper.GetFloat64(weatherLongitudeFlag),
+		URL:           viper.GetString(weatherURLFlag),
+		ForecastHours: viper.GetInt(weatherForecastHoursFlag),
+		Location:      viper.GetString(weatherFileFlag),
+	})
+	if err != nil {
+		sugar.Fatalw("invalid weather provider", "error", err.Error())
+	}
+	return provider
+}
+
+// weatherWorker fetches the weather from a provider right away and then
+// periodically, until ctx is done
+func weatherWorker(sh controller.SmartHomeInterface, provider utils.WeatherProvider, interval time.Duration) func(ctx context.Context) {
+	return func(ctx context.Context) {
+		fetch := func(now time.Time) {
+			result, err := sh.FetchWeather(provider, now)
+			if err != nil {
+				sugar.Errorw("error fetching weather", "provider", provider.Name(), "error", err.Error())
+				return
+			}
+			sugar.Debugw("fetched weather", "provider", provider.Name(), "observations", result.Observations, "forecasts", result.Forecasts)
+		}
+
+		fetch(time.Now())
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				fetch(now)
+			}
+		}
+	}
+}
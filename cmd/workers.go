@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// workers keeps track of the background goroutines started by a command,
+// so that they can be stopped and waited for before the process exits.
+type workers struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// newWorkers returns an empty set of background workers
+func newWorkers() *workers {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &workers{
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Go starts f in a new goroutine. The context passed to f is cancelled
+// when Stop is called, and f is expected to return soon after that.
+func (w *workers) Go(name string, f func(ctx context.Context)) {
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		sugar.Debugw("starting background worker", "worker", name)
+		f(w.ctx)
+		sugar.Debugw("background worker stopped", "worker", name)
+	}()
+}
+
+// Stop cancels the context of all the workers and waits for them to return,
+// or until ctx is done, whatever happens first.
+func (w *workers) Stop(ctx context.Context) error {
+	w.cancel()
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("timed out waiting for background workers: %w", ctx.Err())
+	}
+}
@@ -0,0 +1,259 @@
+package controller
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/igvaquero18/smarthome/utils"
+)
+
+const (
+	// ScopeRoomsRead allows reading the options of the rooms
+	ScopeRoomsRead = "rooms:read"
+
+	// ScopeRoomsWrite allows changing the options of the rooms
+	ScopeRoomsWrite = "rooms:write"
+
+	// ScopeReadingsRead allows reading temperature readings
+	ScopeReadingsRead = "readings:read"
+
+	// ScopeReadingsWrite allows storing temperature readings
+	ScopeReadingsWrite = "readings:write"
+
+	// apiKeyPrefix is the prefix of both the API keys and their items in the
+	// Authentication table, which can't be used by usernames.
+	apiKeyPrefix = "apikey#"
+
+	// apiKeyTokenPrefix identifies SmartHome API keys, e.g. in secret scanners
+	apiKeyTokenPrefix = "shk_"
+
+	// apiKeyLastUsedResolution limits how often the last use of a key is stored
+	apiKeyLastUsedResolution = time.Minute
+)
+
+// ValidScopes is the list of scopes an API key can be granted
+var ValidScopes = []string{ScopeRoomsRead, ScopeRoomsWrite, ScopeReadingsRead, ScopeReadingsWrite}
+
+// ErrInvalidAPIKey is returned when an API key doesn't exist, is wrong or has expired
+var ErrInvalidAPIKey error = NewUnauthorizedError("invalid API key")
+
+// APIKey is a long-lived credential for devices and automations, which can
+// only perform the actions allowed by its scopes.
+type APIKey struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	Owner      string    `json:"owner"`
+	Scopes     []string  `json:"scopes"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at,omitempty"`
+	LastUsedAt time.Time `json:"last_used_at,omitempty"`
+}
+
+// HasScope returns true if the API key has been granted the scope
+func (k *APIKey) HasScope(scope string) bool {
+	return utils.Contains(k.Scopes, scope)
+}
+
+// Expired returns true if the API key has an expiration in the past
+func (k *APIKey) Expired() bool {
+	return !k.ExpiresAt.IsZero() && time.Now().After(k.ExpiresAt)
+}
+
+// CreateAPIKey creates an API key for the owner with the scopes passed as a
+// parameter. A zero expiresAt means the key never expires. The key itself is
+// returned only once, as only its hash is stored.
+func (s *SmartHome) CreateAPIKey(owner, name string, scopes []string, expiresAt time.Time) (*APIKey, string, error) {
+	if len(scopes) == 0 {
+		return nil, "", fmt.Errorf("at least one scope is required")
+	}
+	for _, scope := range scopes {
+		if !utils.Contains(ValidScopes, scope) {
+			return nil, "", NewValidationError("invalid scope %s, must be one of %v", scope, ValidScopes)
+		}
+	}
+
+	id, err := randomHex(8)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := randomHex(24)
+	if err != nil {
+		return nil, "", err
+	}
+
+	key := &APIKey{
+		ID:        id,
+		Name:      name,
+		Owner:     owner,
+		Scopes:    scopes,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+		ExpiresAt: expiresAt.UTC().Truncate(time.Second),
+	}
+	item := map[string]types.AttributeValue{
+		"Username":   &types.AttributeValueMemberS{Value: apiKeyPrefix + id},
+		"Owner":      &types.AttributeValueMemberS{Value: owner},
+		"Name":       &types.AttributeValueMemberS{Value: name},
+		"Scopes":     &types.AttributeValueMemberSS{Value: scopes},
+		"SecretHash": &types.AttributeValueMemberS{Value: hashAPIKeySecret(secret)},
+		"CreatedAt":  unixAttribute(key.CreatedAt),
+	}
+	if !expiresAt.IsZero() {
+		item["ExpiresAt"] = unixAttribute(key.ExpiresAt)
+	}
+
+	s.Debugw("creating API key", "owner", owner, "id", id, "scopes", scopes)
+	_, err = s.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName:           &s.Config.AuthTable,
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(Username)"),
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("error storing API key: %w", err)
+	}
+	return key, apiKeyTokenPrefix + id + "_" + secret, nil
+}
+
+// ListAPIKeys returns the API keys of the owner, sorted by creation date
+func (s *SmartHome) ListAPIKeys(owner string) ([]APIKey, error) {
+	keys := []APIKey{}
+	input := &dynamodb.ScanInput{
+		TableName:        &s.Config.AuthTable,
+		FilterExpression: aws.String("begins_with(Username, :prefix) AND Owner = :owner"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":prefix": &types.AttributeValueMemberS{Value: apiKeyPrefix},
+			":owner":  &types.AttributeValueMemberS{Value: owner},
+		},
+	}
+	for {
+		output, err := s.Scan(context.TODO(), input)
+		if err != nil {
+			return nil, fmt.Errorf("error listing API keys of user %s: %w", owner, err)
+		}
+		for _, item := range output.Items {
+			keys = append(keys, *apiKeyFromItem(item))
+		}
+		if len(output.LastEvaluatedKey) == 0 {
+			break
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys, nil
+}
+
+// DeleteAPIKey revokes an API key of the owner
+func (s *SmartHome) DeleteAPIKey(owner, id string) error {
+	s.Debugw("deleting API key", "owner", owner, "id", id)
+	_, err := s.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		TableName:           &s.Config.AuthTable,
+		Key:                 map[string]types.AttributeValue{"Username": &types.AttributeValueMemberS{Value: apiKeyPrefix + id}},
+		ConditionExpression: aws.String("Owner = :owner"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":owner": &types.AttributeValueMemberS{Value: owner},
+		},
+	})
+	var conditionErr *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionErr) {
+		return NewNotFoundError("API key %s not found", id)
+	}
+	if err != nil {
+		return fmt.Errorf("error deleting API key %s: %w", id, err)
+	}
+	return nil
+}
+
+// AuthenticateAPIKey returns the API key if it is valid, recording its use
+func (s *SmartHome) AuthenticateAPIKey(token string) (*APIKey, error) {
+	parts := strings.Split(strings.TrimPrefix(token, apiKeyTokenPrefix), "_")
+	if !strings.HasPrefix(token, apiKeyTokenPrefix) || len(parts) != 2 {
+		return nil, ErrInvalidAPIKey
+	}
+	id, secret := parts[0], parts[1]
+
+	item, err := s.get("Username", apiKeyPrefix+id, s.Config.AuthTable)
+	if err != nil {
+		return nil, fmt.Errorf("error getting API key %s: %w", id, err)
+	}
+	hash, ok := item["SecretHash"].(*types.AttributeValueMemberS)
+	if !ok || subtle.ConstantTimeCompare([]byte(hash.Value), []byte(hashAPIKeySecret(secret))) != 1 {
+		return nil, ErrInvalidAPIKey
+	}
+	key := apiKeyFromItem(item)
+	if key.Expired() {
+		return nil, ErrInvalidAPIKey
+	}
+
+	now := time.Now().UTC()
+	if now.Sub(key.LastUsedAt) >= apiKeyLastUsedResolution {
+		key.LastUsedAt = now.Truncate(time.Second)
+		_, err := s.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+			TableName:        &s.Config.AuthTable,
+			Key:              map[string]types.AttributeValue{"Username": &types.AttributeValueMemberS{Value: apiKeyPrefix + id}},
+			UpdateExpression: aws.String("SET LastUsedAt = :now"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":now": unixAttribute(key.LastUsedAt),
+			},
+		})
+		if err != nil {
+			s.Errorw("error recording the use of API key", "id", id, "error", err.Error())
+		}
+	}
+	return key, nil
+}
+
+func apiKeyFromItem(item map[string]types.AttributeValue) *APIKey {
+	key := &APIKey{}
+	if username, ok := item["Username"].(*types.AttributeValueMemberS); ok {
+		key.ID = strings.TrimPrefix(username.Value, apiKeyPrefix)
+	}
+	if owner, ok := item["Owner"].(*types.AttributeValueMemberS); ok {
+		key.Owner = owner.Value
+	}
+	if name, ok := item["Name"].(*types.AttributeValueMemberS); ok {
+		key.Name = name.Value
+	}
+	if scopes, ok := item["Scopes"].(*types.AttributeValueMemberSS); ok {
+		key.Scopes = scopes.Value
+	}
+	key.CreatedAt = timeAttribute(item, "CreatedAt")
+	key.ExpiresAt = timeAttribute(item, "ExpiresAt")
+	key.LastUsedAt = timeAttribute(item, "LastUsedAt")
+	return key
+}
+
+func hashAPIKeySecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error generating API key: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func unixAttribute(t time.Time) types.AttributeValue {
+	return &types.AttributeValueMemberN{Value: strconv.FormatInt(t.Unix(), 10)}
+}
+
+func timeAttribute(item map[string]types.AttributeValue, name string) time.Time {
+	n := numberAttribute(item, name)
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(n, 0).UTC()
+}
@@ -0,0 +1,173 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+)
+
+type apiKeyDynamoClient struct {
+	mockDynamoClient
+	items map[string]map[string]types.AttributeValue
+}
+
+func (m *apiKeyDynamoClient) PutItem(ctx context.Context, input *dynamodb.PutItemInput, opts ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	m.items[input.Item["Username"].(*types.AttributeValueMemberS).Value] = input.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (m *apiKeyDynamoClient) GetItem(ctx context.Context, input *dynamodb.GetItemInput, opts ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	return &dynamodb.GetItemOutput{Item: m.items[input.Key["Username"].(*types.AttributeValueMemberS).Value]}, nil
+}
+
+func TestCreateAPIKey(t *testing.T) {
+	testCases := []struct {
+		name          string
+		scopes        []string
+		expectedError bool
+	}{
+		{
+			name:   "Valid scopes",
+			scopes: []string{ScopeReadingsWrite},
+		},
+		{
+			name:          "No scopes",
+			scopes:        []string{},
+			expectedError: true,
+		},
+		{
+			name:          "Invalid scope",
+			scopes:        []string{"rooms:delete"},
+			expectedError: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			sh := NewSmartHome(SetDynamoDBClient(&mockDynamoClient{}), SetLogger(mockLogger{}))
+			key, token, err := sh.CreateAPIKey("admin", "thermometer", tc.scopes, time.Time{})
+			if tc.expectedError {
+				assert.Error(tt, err)
+				return
+			}
+			assert.NoError(tt, err)
+			assert.True(tt, strings.HasPrefix(token, "shk_"+key.ID+"_"))
+			assert.Equal(tt, tc.scopes, key.Scopes)
+		})
+	}
+}
+
+func TestAuthenticateAPIKey(t *testing.T) {
+	client := &apiKeyDynamoClient{items: map[string]map[string]types.AttributeValue{}}
+	sh := NewSmartHome(SetDynamoDBClient(client), SetLogger(mockLogger{}))
+	_, valid, _ := sh.CreateAPIKey("admin", "thermometer", []string{ScopeReadingsWrite}, time.Time{})
+	_, expired, _ := sh.CreateAPIKey("admin", "old", []string{ScopeReadingsWrite}, time.Now().Add(-time.Hour))
+
+	testCases := []struct {
+		name          string
+		token         string
+		expectedError bool
+	}{
+		{
+			name:  "Valid key",
+			token: valid,
+		},
+		{
+			name:          "Expired key",
+			token:         expired,
+			expectedError: true,
+		},
+		{
+			name:          "Wrong secret",
+			token:         valid[:len(valid)-4] + "0000",
+			expectedError: true,
+		},
+		{
+			name:          "Unknown key",
+			token:         "shk_0000000000000000_0000",
+			expectedError: true,
+		},
+		{
+			name:          "Malformed key",
+			token:         "not-a-key",
+			expectedError: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			key, err := sh.AuthenticateAPIKey(tc.token)
+			if tc.expectedError {
+				assert.Equal(tt, ErrInvalidAPIKey, err)
+				return
+			}
+			assert.NoError(tt, err)
+			assert.Equal(tt, "admin", key.Owner)
+			assert.True(tt, key.HasScope(ScopeReadingsWrite))
+			assert.False(tt, key.HasScope(ScopeRoomsWrite))
+			assert.False(tt, key.LastUsedAt.IsZero())
+		})
+	}
+}
+
+func TestListAPIKeys(t *testing.T) {
+	client := &mockDynamoClient{scanOutput: &dynamodb.ScanOutput{
+		Items: []map[string]types.AttributeValue{
+			{
+				"Username":  &types.AttributeValueMemberS{Value: "apikey#2"},
+				"Owner":     &types.AttributeValueMemberS{Value: "admin"},
+				"Scopes":    &types.AttributeValueMemberSS{Value: []string{ScopeRoomsRead}},
+				"CreatedAt": &types.AttributeValueMemberN{Value: "200"},
+			},
+			{
+				"Username":  &types.AttributeValueMemberS{Value: "apikey#1"},
+				"Owner":     &types.AttributeValueMemberS{Value: "admin"},
+				"Scopes":    &types.AttributeValueMemberSS{Value: []string{ScopeReadingsWrite}},
+				"CreatedAt": &types.AttributeValueMemberN{Value: "100"},
+			},
+		},
+	}}
+	sh := NewSmartHome(SetDynamoDBClient(client), SetLogger(mockLogger{}))
+	keys, err := sh.ListAPIKeys("admin")
+	assert.NoError(t, err)
+	if assert.Len(t, keys, 2) {
+		assert.Equal(t, "1", keys[0].ID)
+		assert.Equal(t, "2", keys[1].ID)
+	}
+
+	sh = NewSmartHome(SetDynamoDBClient(&mockDynamoClient{err: fmt.Errorf("Error")}), SetLogger(mockLogger{}))
+	_, err = sh.ListAPIKeys("admin")
+	assert.Error(t, err)
+}
+
+func TestDeleteAPIKey(t *testing.T) {
+	testCases := []struct {
+		name          string
+		err           error
+		expectedError bool
+	}{
+		{
+			name: "Delete own key",
+		},
+		{
+			name:          "Key of another user",
+			err:           &types.ConditionalCheckFailedException{},
+			expectedError: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			sh := NewSmartHome(SetDynamoDBClient(&mockDynamoClient{err: tc.err}), SetLogger(mockLogger{}))
+			err := sh.DeleteAPIKey("admin", "1")
+			if tc.expectedError {
+				assert.Error(tt, err)
+				return
+			}
+			assert.NoError(tt, err)
+		})
+	}
+}
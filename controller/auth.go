@@ -2,13 +2,45 @@ package controller
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"golang.org/x/crypto/bcrypt"
 )
 
+const (
+	// RoleAdmin is the role of the users that can manage other users
+	// as well as the options of every room.
+	RoleAdmin = "admin"
+
+	// RoleUser is the role of the users that can only manage the
+	// options of the rooms.
+	RoleUser = "user"
+)
+
+// dummyPasswordHash is compared against when the user doesn't exist
+const dummyPasswordHash = "$2a$10$Xj/KDbv0lD/k0.WV7UxFq.tfHTEcnTCoowkKyMiIWCoj2cIobPF1C"
+
+// ValidRoles is the list of roles a user can have
+var ValidRoles = []string{RoleAdmin, RoleUser}
+
+// User holds the information of a SmartHome user, excluding its credentials
+type User struct {
+	Username string
+	Role     string
+
+	// MFAEnabled is true if the user has to provide a second factor when logging in
+	MFAEnabled bool
+
+	// TemperatureUnit is the unit the user prefers temperatures in. It's
+	// empty if the user has no preference.
+	TemperatureUnit TemperatureUnit
+}
+
 // Authenticate returns an error if the combination of the username and
 // password is incorrect.
 func (s *SmartHome) Authenticate(username, password string) error {
@@ -21,29 +53,45 @@ func (s *SmartHome) Authenticate(username, password string) error {
 	hashedPassword, ok := credentials["Password"].(*types.AttributeValueMemberS)
 
 	if !ok {
-		return fmt.Errorf("user %s not found", username)
+		// Compare anyway, so that the response time doesn't reveal whether the user exists
+		bcrypt.CompareHashAndPassword([]byte(dummyPasswordHash), []byte(password))
+		return NewNotFoundError("user %s not found", username)
 	}
 
 	s.Debugw("successfully retrieved credentials for user", "user", username)
 	return bcrypt.CompareHashAndPassword([]byte(hashedPassword.Value), []byte(password))
 }
 
-// SetCredentials stores the username and password for the user in the DynamoDB table.
-// It takes care of hashing the password using the bcrypt package before storing it.
+// SetCredentials creates an admin user with the username and password passed
+// as parameters. It takes care of hashing the password using the bcrypt package
+// before storing it. Existing users are never overwritten: ErrUserExists is
+// returned instead, and ChangePassword must be used for changing passwords.
 func (s *SmartHome) SetCredentials(username, password string) error {
 	s.Debugw("Storing credentials for user", "user", username)
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if isReservedUsername(username) || username == "" {
+		return NewValidationError("invalid username %s", username)
+	}
+	if err := s.Config.PasswordPolicy.Validate(username, password); err != nil {
+		return err
+	}
+	hashedPassword, err := s.hashPassword(password)
 	if err != nil {
-		return fmt.Errorf("error hashing the password: %w", err)
+		return err
 	}
 	_, err = s.PutItem(context.TODO(), &dynamodb.PutItemInput{
 		TableName: &s.Config.AuthTable,
 		Item: map[string]types.AttributeValue{
 			"Username": &types.AttributeValueMemberS{Value: username},
-			"Password": &types.AttributeValueMemberS{Value: string(hashedPassword)},
+			"Password": &types.AttributeValueMemberS{Value: hashedPassword},
+			"Role":     &types.AttributeValueMemberS{Value: RoleAdmin},
 		},
+		ConditionExpression: aws.String("attribute_not_exists(Username)"),
 	})
 
+	var conditionErr *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionErr) {
+		return ErrUserExists
+	}
 	if err != nil {
 		return fmt.Errorf("error storing the user and password in the database: %w", err)
 	}
@@ -55,9 +103,76 @@ func (s *SmartHome) SetCredentials(username, password string) error {
 // DeleteUser deletes a user from the DynamoDB table
 func (s *SmartHome) DeleteUser(username string) error {
 	s.Debugw("Deleting user", "user", username)
+	if isReservedUsername(username) {
+		return NewValidationError("invalid username %s", username)
+	}
 	if err := s.delete("Username", username, s.Config.AuthTable); err != nil {
 		return fmt.Errorf("error when deleting user %s from the DynamoDB table: %w", username, err)
 	}
 	s.Debugw("successfully deleted user from the DynamoDB table", "user", username)
 	return nil
 }
+
+// GetUser returns the user with the given username, or nil if it doesn't exist.
+// Users stored without a role are considered admins, as every user used to be.
+func (s *SmartHome) GetUser(username string) (*User, error) {
+	s.Debugw("getting user", "user", username)
+	if isReservedUsername(username) {
+		return nil, nil
+	}
+	item, err := s.get("Username", username, s.Config.AuthTable)
+	if err != nil {
+		return nil, fmt.Errorf("error getting user %s: %w", username, err)
+	}
+	if item == nil {
+		return nil, nil
+	}
+
+	user := &User{Username: username, Role: RoleAdmin}
+	if role, ok := item["Role"].(*types.AttributeValueMemberS); ok && role.Value != "" {
+		user.Role = role.Value
+	}
+	if enabled, ok := item["MFAEnabled"].(*types.AttributeValueMemberBOOL); ok {
+		user.MFAEnabled = enabled.Value
+	}
+	if unit, ok := item["TemperatureUnit"].(*types.AttributeValueMemberS); ok {
+		user.TemperatureUnit = TemperatureUnit(unit.Value)
+	}
+	s.Debugw("successfully retrieved user", "user", username, "role", user.Role)
+	return user, nil
+}
+
+// CreateExternalUser stores a user authenticated by an external identity
+// provider. The user has no password, so it can't log in with one. It returns
+// an error if the user already exists.
+func (s *SmartHome) CreateExternalUser(username, role string) error {
+	s.Debugw("creating external user", "user", username, "role", role)
+	if isReservedUsername(username) {
+		return NewValidationError("invalid username %s", username)
+	}
+	_, err := s.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: &s.Config.AuthTable,
+		Item: map[string]types.AttributeValue{
+			"Username": &types.AttributeValueMemberS{Value: username},
+			"Role":     &types.AttributeValueMemberS{Value: role},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(Username)"),
+	})
+
+	var conditionErr *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionErr) {
+		return fmt.Errorf("user %s already exists", username)
+	}
+	if err != nil {
+		return fmt.Errorf("error storing external user %s in the database: %w", username, err)
+	}
+
+	s.Debugw("successfully created external user", "user", username, "role", role)
+	return nil
+}
+
+// isReservedUsername returns true if the username belongs to the items of
+// the Authentication table that aren't users, such as API keys
+func isReservedUsername(username string) bool {
+	return strings.HasPrefix(username, apiKeyPrefix) || strings.HasPrefix(username, invitationPrefix)
+}
@@ -99,7 +99,7 @@ func TestSetCredentials(t *testing.T) {
 		{
 			name:          "Set valid username and password",
 			username:      "admin",
-			password:      "password",
+			password:      "correct horse battery",
 			client:        &mockDynamoClient{},
 			expectedError: false,
 		},
@@ -108,7 +108,23 @@ func TestSetCredentials(t *testing.T) {
 			username:      "admin",
 			password:      "",
 			client:        &mockDynamoClient{},
-			expectedError: false,
+			expectedError: true,
+		},
+		{
+			name:          "Set password equal to the username",
+			username:      "administrator",
+			password:      "Administrator",
+			client:        &mockDynamoClient{},
+			expectedError: true,
+		},
+		{
+			name:     "Set password of an existing user",
+			username: "admin",
+			password: "correct horse battery",
+			client: &mockDynamoClient{
+				err: &types.ConditionalCheckFailedException{},
+			},
+			expectedError: true,
 		},
 		{
 			name:     "Set empty username",
@@ -173,3 +189,101 @@ func TestDeleteUser(t *testing.T) {
 		})
 	}
 }
+
+func TestGetUser(t *testing.T) {
+	testCases := []struct {
+		name          string
+		client        DynamoDBInterface
+		expectedUser  *User
+		expectedError bool
+	}{
+		{
+			name: "User with role",
+			client: &mockDynamoClient{
+				getItemOutput: &dynamodb.GetItemOutput{
+					Item: map[string]types.AttributeValue{
+						"Username": &types.AttributeValueMemberS{Value: "alice"},
+						"Role":     &types.AttributeValueMemberS{Value: RoleUser},
+					},
+				},
+			},
+			expectedUser: &User{Username: "alice", Role: RoleUser},
+		},
+		{
+			name: "User without role",
+			client: &mockDynamoClient{
+				getItemOutput: &dynamodb.GetItemOutput{
+					Item: map[string]types.AttributeValue{
+						"Username": &types.AttributeValueMemberS{Value: "alice"},
+						"Password": &types.AttributeValueMemberS{Value: "hash"},
+					},
+				},
+			},
+			expectedUser: &User{Username: "alice", Role: RoleAdmin},
+		},
+		{
+			name: "User not found",
+			client: &mockDynamoClient{
+				getItemOutput: &dynamodb.GetItemOutput{},
+			},
+			expectedUser: nil,
+		},
+		{
+			name: "Client error",
+			client: &mockDynamoClient{
+				err: fmt.Errorf("Error"),
+			},
+			expectedError: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			sh := NewSmartHome(SetDynamoDBClient(tc.client), SetLogger(mockLogger{}))
+			user, err := sh.GetUser("alice")
+			if tc.expectedError {
+				assert.Error(tt, err)
+				return
+			}
+			assert.NoError(tt, err)
+			assert.Equal(tt, tc.expectedUser, user)
+		})
+	}
+}
+
+func TestCreateExternalUser(t *testing.T) {
+	testCases := []struct {
+		name          string
+		client        DynamoDBInterface
+		expectedError bool
+	}{
+		{
+			name:   "Create new user",
+			client: &mockDynamoClient{},
+		},
+		{
+			name: "User already exists",
+			client: &mockDynamoClient{
+				err: &types.ConditionalCheckFailedException{},
+			},
+			expectedError: true,
+		},
+		{
+			name: "Client error",
+			client: &mockDynamoClient{
+				err: fmt.Errorf("Error"),
+			},
+			expectedError: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			sh := NewSmartHome(SetDynamoDBClient(tc.client), SetLogger(mockLogger{}))
+			err := sh.CreateExternalUser("alice", RoleUser)
+			if tc.expectedError {
+				assert.Error(tt, err)
+				return
+			}
+			assert.NoError(tt, err)
+		})
+	}
+}
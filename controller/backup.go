@@ -0,0 +1,503 @@
+package controller
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/igvaquero18/smarthome/utils"
+)
+
+// BackupFormat identifies the archives written by Backup
+const BackupFormat = "smarthome-backup"
+
+// BackupVersion is the version of the format of the archives written by
+// Backup. Restore reads archives of this version or older ones.
+const BackupVersion = 1
+
+// Tables of a backup, identified by their role rather than their name, so
+// that an archive can be restored into tables with other names
+const (
+	// BackupAuthTable is the Authentication table
+	BackupAuthTable = "auth"
+
+	// BackupControlPlaneTable is the ControlPlane table
+	BackupControlPlaneTable = "control_plane"
+
+	// BackupTempInsideTable is the TemperatureInside table
+	BackupTempInsideTable = "inside"
+
+	// BackupTempOutsideTable is the TemperatureOutside table
+	BackupTempOutsideTable = "outside"
+
+	// BackupHeatingTable is the HeatingTransitions table
+	BackupHeatingTable = "heating"
+
+	// BackupHistoryTable is the RoomSettingsHistory table
+	BackupHistoryTable = "history"
+)
+
+// BackupTables are the tables backed up, in the order they're backed up. The
+// LoginAttempts table isn't, as its lockouts are short-lived.
+var BackupTables = []string{BackupAuthTable, BackupControlPlaneTable, BackupTempInsideTable, BackupTempOutsideTable, BackupHeatingTable, BackupHistoryTable}
+
+// maxBatchWriteItems is the maximum number of writes of a DynamoDB
+// BatchWriteItem
+const maxBatchWriteItems = 25
+
+// maxThrottledAttempts is the number of times a request throttled by
+// DynamoDB, or whose items are left unprocessed, is made before giving up
+const maxThrottledAttempts = 8
+
+// maxTableCreationWait is how long Restore waits for the tables it creates to
+// become active
+const maxTableCreationWait = 2 * time.Minute
+
+// BackupOptions are the options of a backup or a restore
+type BackupOptions struct {
+	// Passphrase encrypts the archive of a backup, or decrypts the archive
+	// to restore. Archives aren't encrypted if it's empty.
+	Passphrase string
+
+	// Tables are the tables to restore, all of those of the archive if it's
+	// empty. Backups always include every table.
+	Tables []string
+
+	// CreateTables creates the tables to restore that don't exist, with
+	// on-demand capacity, such as when restoring into DynamoDB Local
+	CreateTables bool
+
+	// PageSize is the number of items of every Scan of a backup, or 0 for
+	// pages of up to 1 MB
+	PageSize int32
+}
+
+// backupEnvelope is the first line of an archive, which isn't compressed nor
+// encrypted. It's authenticated along with the encrypted data, if any.
+type backupEnvelope struct {
+	Format      string            `json:"format"`
+	Version     int               `json:"version"`
+	Compression string            `json:"compression"`
+	Encryption  *backupEncryption `json:"encryption,omitempty"`
+}
+
+// backupEncryption are the parameters of the encryption of an archive with
+// a key derived from a passphrase
+type backupEncryption struct {
+	Algorithm   string `json:"algorithm"`
+	KDF         string `json:"kdf"`
+	Salt        []byte `json:"salt"`
+	N           int    `json:"n"`
+	R           int    `json:"r"`
+	P           int    `json:"p"`
+	NoncePrefix []byte `json:"nonce_prefix"`
+}
+
+// backupRecord is a line of the body of an archive: the header with the
+// tables, an item of a table, or the end of the archive with the number of
+// items of every table, which tells truncated archives apart
+type backupRecord struct {
+	Type      string          `json:"type"`
+	CreatedAt *time.Time      `json:"created_at,omitempty"`
+	Tables    []backupTable   `json:"tables,omitempty"`
+	Table     string          `json:"table,omitempty"`
+	Item      json.RawMessage `json:"item,omitempty"`
+	Items     map[string]int  `json:"items,omitempty"`
+}
+
+// Types of the records of an archive
+const (
+	backupHeader = "header"
+	backupItem   = "item"
+	backupEnd    = "end"
+)
+
+// backupTable is a table of an archive
+type backupTable struct {
+	Name    string `json:"name"`
+	Table   string `json:"table"`
+	HashKey string `json:"hash_key"`
+
+	// RangeKey is the number range key of the table, if it has one
+	RangeKey string `json:"range_key,omitempty"`
+
+	// readings is whether the table has readings, which are indexed by the
+	// PendingReadingsIndex when it's created
+	readings bool
+}
+
+// backupTables returns the tables of the config by their name in archives
+func (s *SmartHome) backupTables() map[string]backupTable {
+	return map[string]backupTable{
+		BackupAuthTable:         {Name: BackupAuthTable, Table: s.Config.AuthTable, HashKey: "Username"},
+		BackupControlPlaneTable: {Name: BackupControlPlaneTable, Table: s.Config.ControlPlaneTable, HashKey: "Room"},
+		BackupTempInsideTable:   {Name: BackupTempInsideTable, Table: s.Config.TempInsideTable, HashKey: "Date", readings: true},
+		BackupTempOutsideTable:  {Name: BackupTempOutsideTable, Table: s.Config.TempOutsideTable, HashKey: "Date", readings: true},
+		BackupHeatingTable:      {Name: BackupHeatingTable, Table: s.Config.HeatingTable, HashKey: "Room", RangeKey: "Time"},
+		BackupHistoryTable:      {Name: BackupHistoryTable, Table: s.Config.HistoryTable, HashKey: "Room", RangeKey: "Time"},
+	}
+}
+
+// Backup writes an archive with every item of the BackupTables to w, and
+// returns the number of items backed up by table. The archive is a line of
+// JSON with its format, followed by gzipped JSON lines, encrypted with
+// AES-256-GCM if a passphrase is set.
+func (s *SmartHome) Backup(w io.Writer, opts BackupOptions) (map[string]int, error) {
+	envelope := backupEnvelope{Format: BackupFormat, Version: BackupVersion, Compression: "gzip"}
+	var key []byte
+	if opts.Passphrase != "" {
+		envelope.Encryption = &backupEncryption{
+			Algorithm:   "AES-256-GCM",
+			KDF:         "scrypt",
+			Salt:        make([]byte, 16),
+			N:           utils.ScryptN,
+			R:           utils.ScryptR,
+			P:           utils.ScryptP,
+			NoncePrefix: make([]byte, utils.EncryptionNoncePrefixSize),
+		}
+		if _, err := rand.Read(envelope.Encryption.Salt); err != nil {
+			return nil, fmt.Errorf("error generating salt: %w", err)
+		}
+		if _, err := rand.Read(envelope.Encryption.NoncePrefix); err != nil {
+			return nil, fmt.Errorf("error generating nonce: %w", err)
+		}
+		var err error
+		if key, err = utils.DeriveKey(opts.Passphrase, envelope.Encryption.Salt); err != nil {
+			return nil, err
+		}
+	}
+	line, err := json.Marshal(envelope)
+	if err != nil {
+		return nil, err
+	}
+	line = append(line, '\n')
+	if _, err := w.Write(line); err != nil {
+		return nil, fmt.Errorf("error writing backup: %w", err)
+	}
+
+	body := w
+	var encrypter *utils.EncryptWriter
+	if key != nil {
+		if encrypter, err = utils.NewEncryptWriter(w, key, envelope.Encryption.NoncePrefix, line); err != nil {
+			return nil, err
+		}
+		body = encrypter
+	}
+	compressor := gzip.NewWriter(body)
+	encoder := json.NewEncoder(compressor)
+
+	tables := s.backupTables()
+	now := time.Now().UTC()
+	header := backupRecord{Type: backupHeader, CreatedAt: &now}
+	for _, name := range BackupTables {
+		header.Tables = append(header.Tables, tables[name])
+	}
+	if err := encoder.Encode(header); err != nil {
+		return nil, fmt.Errorf("error writing backup: %w", err)
+	}
+
+	counts := map[string]int{}
+	for _, name := range BackupTables {
+		table := tables[name]
+		s.Debugw("backing up table", "table", table.Table)
+		counts[name] = 0
+		err := s.scanTable(table.Table, opts.PageSize, func(item map[string]types.AttributeValue) error {
+			data, err := utils.MarshalItemJSON(item)
+			if err != nil {
+				return err
+			}
+			counts[name]++
+			return encoder.Encode(backupRecord{Type: backupItem, Table: name, Item: data})
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error backing up table %s: %w", table.Table, err)
+		}
+		s.Debugw("backed up table", "table", table.Table, "items", counts[name])
+	}
+
+	if err := encoder.Encode(backupRecord{Type: backupEnd, Items: counts}); err != nil {
+		return nil, fmt.Errorf("error writing backup: %w", err)
+	}
+	if err := compressor.Close(); err != nil {
+		return nil, fmt.Errorf("error writing backup: %w", err)
+	}
+	if encrypter != nil {
+		if err := encrypter.Close(); err != nil {
+			return nil, err
+		}
+	}
+	return counts, nil
+}
+
+// Restore writes the items of an archive written by Backup into the tables of
+// the config, overwriting the items with the same keys, and returns the
+// number of items restored by table. Items are written as they're read, so
+// some of them may have been restored when an error is returned.
+func (s *SmartHome) Restore(r io.Reader, opts BackupOptions) (map[string]int, error) {
+	reader := bufio.NewReader(r)
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		return nil, NewValidationError("invalid backup: missing header")
+	}
+	var envelope backupEnvelope
+	if err := json.Unmarshal(line, &envelope); err != nil || envelope.Format != BackupFormat {
+		return nil, NewValidationError("invalid backup: unknown format")
+	}
+	if envelope.Version < 1 || envelope.Version > BackupVersion {
+		return nil, NewValidationError("unsupported backup version %d: this version supports up to %d", envelope.Version, BackupVersion)
+	}
+	if envelope.Compression != "gzip" {
+		return nil, NewValidationError("unsupported backup compression %s", envelope.Compression)
+	}
+
+	var body io.Reader = reader
+	if encryption := envelope.Encryption; encryption != nil {
+		if opts.Passphrase == "" {
+			return nil, NewValidationError("the backup is encrypted: a passphrase is required")
+		}
+		if encryption.Algorithm != "AES-256-GCM" || encryption.KDF != "scrypt" ||
+			encryption.N != utils.ScryptN || encryption.R != utils.ScryptR || encryption.P != utils.ScryptP {
+			return nil, NewValidationError("unsupported backup encryption %s with %s", encryption.Algorithm, encryption.KDF)
+		}
+		key, err := utils.DeriveKey(opts.Passphrase, encryption.Salt)
+		if err != nil {
+			return nil, err
+		}
+		if body, err = utils.NewDecryptReader(reader, key, encryption.NoncePrefix, line); err != nil {
+			return nil, NewValidationError("invalid backup encryption: %s", err.Error())
+		}
+	}
+	decompressor, err := gzip.NewReader(body)
+	if err != nil {
+		return nil, NewValidationError("invalid backup: %s", err.Error())
+	}
+	decoder := json.NewDecoder(decompressor)
+
+	var header backupRecord
+	if err := decoder.Decode(&header); err != nil || header.Type != backupHeader {
+		return nil, NewValidationError("invalid backup: missing list of tables")
+	}
+	tables, err := s.restoreTables(header.Tables, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	read := map[string]int{}
+	restored := map[string]int{}
+	for name := range tables {
+		restored[name] = 0
+	}
+	pending := map[string][]types.WriteRequest{}
+	flush := func(name string) error {
+		if len(pending[name]) == 0 {
+			return nil
+		}
+		if err := s.batchWrite(tables[name].Table, pending[name]); err != nil {
+			return fmt.Errorf("error restoring table %s: %w", tables[name].Table, err)
+		}
+		restored[name] += len(pending[name])
+		pending[name] = nil
+		return nil
+	}
+	for {
+		var record backupRecord
+		err := decoder.Decode(&record)
+		if err == io.EOF {
+			return restored, NewValidationError("invalid backup: it's truncated")
+		}
+		if err != nil {
+			return restored, NewValidationError("invalid backup: %s", err.Error())
+		}
+
+		switch record.Type {
+		case backupItem:
+			read[record.Table]++
+			if _, ok := tables[record.Table]; !ok {
+				continue
+			}
+			item, err := utils.UnmarshalItemJSON(record.Item)
+			if err != nil {
+				return restored, NewValidationError("invalid backup item of table %s: %s", record.Table, err.Error())
+			}
+			pending[record.Table] = append(pending[record.Table], types.WriteRequest{PutRequest: &types.PutRequest{Item: item}})
+			if len(pending[record.Table]) == maxBatchWriteItems {
+				if err := flush(record.Table); err != nil {
+					return restored, err
+				}
+			}
+		case backupEnd:
+			for name := range tables {
+				if err := flush(name); err != nil {
+					return restored, err
+				}
+			}
+			for name, count := range record.Items {
+				if read[name] != count {
+					return restored, NewValidationError("invalid backup: table %s has %d items instead of %d", name, read[name], count)
+				}
+			}
+			return restored, nil
+		default:
+			return restored, NewValidationError("invalid backup: unknown record %s", record.Type)
+		}
+	}
+}
+
+// restoreTables returns the tables of the config to restore the tables of an
+// archive into, by their name in the archive, creating them if needed
+func (s *SmartHome) restoreTables(archived []backupTable, opts BackupOptions) (map[string]backupTable, error) {
+	config := s.backupTables()
+	tables := map[string]backupTable{}
+	for _, t := range archived {
+		if _, ok := config[t.Name]; !ok {
+			return nil, NewValidationError("invalid backup: unknown table %s", t.Name)
+		}
+		if len(opts.Tables) == 0 || utils.Contains(opts.Tables, t.Name) {
+			tables[t.Name] = config[t.Name]
+		}
+	}
+	for _, name := range opts.Tables {
+		if _, ok := tables[name]; !ok {
+			return nil, NewValidationError("table %s isn't in the backup", name)
+		}
+	}
+
+	if opts.CreateTables {
+		for _, name := range BackupTables {
+			if table, ok := tables[name]; ok {
+				if err := s.createTable(table); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return tables, nil
+}
+
+// createTable creates a table with on-demand capacity unless it exists, and
+// waits for it to become active
+func (s *SmartHome) createTable(table backupTable) error {
+	input := &dynamodb.CreateTableInput{
+		TableName:            aws.String(table.Table),
+		KeySchema:            []types.KeySchemaElement{{AttributeName: aws.String(table.HashKey), KeyType: types.KeyTypeHash}},
+		AttributeDefinitions: []types.AttributeDefinition{{AttributeName: aws.String(table.HashKey), AttributeType: types.ScalarAttributeTypeS}},
+		BillingMode:          types.BillingModePayPerRequest,
+	}
+	if table.RangeKey != "" {
+		input.KeySchema = append(input.KeySchema, types.KeySchemaElement{AttributeName: aws.String(table.RangeKey), KeyType: types.KeyTypeRange})
+		input.AttributeDefinitions = append(input.AttributeDefinitions,
+			types.AttributeDefinition{AttributeName: aws.String(table.RangeKey), AttributeType: types.ScalarAttributeTypeN})
+	}
+	if table.readings {
+		input.AttributeDefinitions = append(input.AttributeDefinitions,
+			types.AttributeDefinition{AttributeName: aws.String("Pending"), AttributeType: types.ScalarAttributeTypeS},
+			types.AttributeDefinition{AttributeName: aws.String("Time"), AttributeType: types.ScalarAttributeTypeN},
+		)
+		input.GlobalSecondaryIndexes = []types.GlobalSecondaryIndex{{
+			IndexName: aws.String(PendingReadingsIndex),
+			KeySchema: []types.KeySchemaElement{
+				{AttributeName: aws.String("Pending"), KeyType: types.KeyTypeHash},
+				{AttributeName: aws.String("Time"), KeyType: types.KeyTypeRange},
+			},
+			Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+		}}
+	}
+	_, err := s.CreateTable(context.TODO(), input)
+	var inUseErr *types.ResourceInUseException
+	if errors.As(err, &inUseErr) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error creating table %s: %w", table.Table, err)
+	}
+
+	s.Infow("created table", "table", table.Table)
+	for start := time.Now(); time.Since(start) < maxTableCreationWait; time.Sleep(time.Second) {
+		output, err := s.DescribeTable(context.TODO(), &dynamodb.DescribeTableInput{TableName: aws.String(table.Table)})
+		if err != nil {
+			return fmt.Errorf("error describing table %s: %w", table.Table, err)
+		}
+		if output.Table != nil && output.Table.TableStatus == types.TableStatusActive {
+			return nil
+		}
+	}
+	return fmt.Errorf("table %s isn't active after %s", table.Table, maxTableCreationWait)
+}
+
+// scanTable calls fn with every item of a table, a page at a time
+func (s *SmartHome) scanTable(table string, pageSize int32, fn func(map[string]types.AttributeValue) error) error {
+	input := &dynamodb.ScanInput{TableName: aws.String(table)}
+	if pageSize > 0 {
+		input.Limit = aws.Int32(pageSize)
+	}
+	for {
+		var output *dynamodb.ScanOutput
+		err := retryThrottled(func() (err error) {
+			output, err = s.Scan(context.TODO(), input)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		for _, item := range output.Items {
+			if err := fn(item); err != nil {
+				return err
+			}
+		}
+		if len(output.LastEvaluatedKey) == 0 {
+			return nil
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+}
+
+// batchWrite writes up to maxBatchWriteItems items to a table, writing again
+// those DynamoDB leaves unprocessed
+func (s *SmartHome) batchWrite(table string, requests []types.WriteRequest) error {
+	request := map[string][]types.WriteRequest{table: requests}
+	for attempt := 0; len(request) > 0; attempt++ {
+		if attempt == maxThrottledAttempts {
+			return fmt.Errorf("items still unprocessed after %d attempts", attempt)
+		}
+		if attempt > 0 {
+			time.Sleep(throttledBackoff(attempt))
+		}
+		var output *dynamodb.BatchWriteItemOutput
+		err := retryThrottled(func() (err error) {
+			output, err = s.BatchWriteItem(context.TODO(), &dynamodb.BatchWriteItemInput{RequestItems: request})
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		request = output.UnprocessedItems
+	}
+	return nil
+}
+
+// retryThrottled calls fn until it doesn't fail because DynamoDB throttled
+// the request, backing off exponentially, up to maxThrottledAttempts times
+func retryThrottled(fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		var throughputErr *types.ProvisionedThroughputExceededException
+		var limitErr *types.RequestLimitExceeded
+		if attempt == maxThrottledAttempts || !(errors.As(err, &throughputErr) || errors.As(err, &limitErr)) {
+			return err
+		}
+		time.Sleep(throttledBackoff(attempt))
+	}
+}
+
+func throttledBackoff(attempt int) time.Duration {
+	return time.Duration(1<<uint(attempt)) * 25 * time.Millisecond
+}
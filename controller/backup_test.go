@@ -0,0 +1,198 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/igvaquero18/smarthome/utils/dynamotest"
+	"github.com/stretchr/testify/assert"
+)
+
+// errMissingTable stands for the ResourceNotFoundException of the writes to
+// tables that don't exist
+var errMissingTable = errors.New("missing table")
+
+func newBackupSmartHome(client *dynamotest.Client, prefix string) *SmartHome {
+	return NewSmartHome(
+		SetDynamoDBClient(client),
+		SetLogger(mockLogger{}),
+		SetConfig(&SmartHomeConfig{
+			AuthTable:         prefix + DefaultAuthTable,
+			ControlPlaneTable: prefix + DefaultControlPlaneTable,
+			TempInsideTable:   prefix + DefaultTempInsideTable,
+			TempOutsideTable:  prefix + DefaultTempOutsideTable,
+			HeatingTable:      prefix + DefaultHeatingTable,
+			HistoryTable:      prefix + DefaultHistoryTable,
+		}),
+	)
+}
+
+// newBackupClient returns a client with the tables backed up
+func newBackupClient() *dynamotest.Client {
+	client := dynamotest.NewClient(map[string]string{
+		DefaultAuthTable:         "Username",
+		DefaultControlPlaneTable: "Room",
+		DefaultTempInsideTable:   "Date",
+		DefaultTempOutsideTable:  "Date",
+		DefaultHeatingTable:      "Room",
+		DefaultHistoryTable:      "Room",
+	})
+	client.SetRangeKey(DefaultHeatingTable, "Time")
+	client.SetRangeKey(DefaultHistoryTable, "Time")
+	return client
+}
+
+func TestBackupRestore(t *testing.T) {
+	source := newBackupClient()
+	sh := newBackupSmartHome(source, "")
+	assert.NoError(t, sh.SetCredentials("admin", "correct horse battery"))
+	_, err := sh.SetRoomOptions("bedroom", true, 19, 20.5, AnyVersion)
+	assert.NoError(t, err)
+	_, err = sh.CreateZone("upstairs", []string{"bedroom"})
+	assert.NoError(t, err)
+	assert.NoError(t, sh.RecordHeating(HeatingTransition{Room: "bedroom", Time: time.Date(2021, 1, 1, 6, 0, 0, 0, time.UTC), Heating: true}))
+	for i := 0; i < 60; i++ {
+		_, err := source.PutItem(context.TODO(), &dynamodb.PutItemInput{
+			TableName: aws.String(DefaultTempInsideTable),
+			Item: map[string]types.AttributeValue{
+				"Date":        &types.AttributeValueMemberS{Value: fmt.Sprintf("bedroom#2021-01-01T00:%02d:00Z", i)},
+				"Temperature": &types.AttributeValueMemberN{Value: "20.5"},
+			},
+		})
+		assert.NoError(t, err)
+	}
+
+	testCases := []struct {
+		name        string
+		backup      BackupOptions
+		restore     BackupOptions
+		expected    map[string]int
+		expectedErr error
+	}{
+		{
+			name:     "Every table",
+			backup:   BackupOptions{PageSize: 7},
+			restore:  BackupOptions{CreateTables: true},
+			expected: map[string]int{BackupAuthTable: 1, BackupControlPlaneTable: 2, BackupTempInsideTable: 60, BackupTempOutsideTable: 0, BackupHeatingTable: 1, BackupHistoryTable: 1},
+		},
+		{
+			name:     "Encrypted",
+			backup:   BackupOptions{Passphrase: "secret"},
+			restore:  BackupOptions{Passphrase: "secret", CreateTables: true},
+			expected: map[string]int{BackupAuthTable: 1, BackupControlPlaneTable: 2, BackupTempInsideTable: 60, BackupTempOutsideTable: 0, BackupHeatingTable: 1, BackupHistoryTable: 1},
+		},
+		{
+			name:     "Some tables",
+			restore:  BackupOptions{Tables: []string{BackupControlPlaneTable}, CreateTables: true},
+			expected: map[string]int{BackupControlPlaneTable: 2},
+		},
+		{
+			name:        "Wrong passphrase",
+			backup:      BackupOptions{Passphrase: "secret"},
+			restore:     BackupOptions{Passphrase: "guess", CreateTables: true},
+			expectedErr: ErrValidation,
+		},
+		{
+			name:        "Missing passphrase",
+			backup:      BackupOptions{Passphrase: "secret"},
+			restore:     BackupOptions{CreateTables: true},
+			expectedErr: ErrValidation,
+		},
+		{
+			name:        "Unknown table",
+			restore:     BackupOptions{Tables: []string{"attempts"}, CreateTables: true},
+			expectedErr: ErrValidation,
+		},
+		{
+			name:        "Missing tables",
+			expectedErr: errMissingTable,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			archive := &bytes.Buffer{}
+			source.Throttle(2)
+			counts, err := sh.Backup(archive, tc.backup)
+			assert.NoError(tt, err)
+			assert.Equal(tt, map[string]int{BackupAuthTable: 1, BackupControlPlaneTable: 2, BackupTempInsideTable: 60, BackupTempOutsideTable: 0, BackupHeatingTable: 1, BackupHistoryTable: 1}, counts)
+
+			target := dynamotest.NewClient(nil)
+			target.SetBatchWriteLimit(10)
+			target.Throttle(1)
+			restored, err := newBackupSmartHome(target, "Debug").Restore(archive, tc.restore)
+			if tc.expectedErr != nil {
+				var notFoundErr *types.ResourceNotFoundException
+				if tc.expectedErr == errMissingTable {
+					assert.True(tt, errors.As(err, &notFoundErr), err)
+				} else {
+					assert.True(tt, errors.Is(err, tc.expectedErr), err)
+				}
+				return
+			}
+			assert.NoError(tt, err)
+			assert.Equal(tt, tc.expected, restored)
+			for name, table := range map[string]string{
+				BackupAuthTable:         DefaultAuthTable,
+				BackupControlPlaneTable: DefaultControlPlaneTable,
+				BackupTempInsideTable:   DefaultTempInsideTable,
+				BackupHeatingTable:      DefaultHeatingTable,
+				BackupHistoryTable:      DefaultHistoryTable,
+			} {
+				if _, ok := tc.expected[name]; ok {
+					assert.Equal(tt, source.Items(table), target.Items("Debug"+table), table)
+				}
+			}
+			if _, ok := tc.expected[BackupTempInsideTable]; ok {
+				output, err := target.DescribeTable(context.TODO(), &dynamodb.DescribeTableInput{TableName: aws.String("Debug" + DefaultTempInsideTable)})
+				assert.NoError(tt, err)
+				if assert.Len(tt, output.Table.GlobalSecondaryIndexes, 1) {
+					assert.Equal(tt, PendingReadingsIndex, aws.ToString(output.Table.GlobalSecondaryIndexes[0].IndexName))
+				}
+			}
+		})
+	}
+}
+
+func TestRestoreInvalidBackup(t *testing.T) {
+	source := newBackupClient()
+	sh := newBackupSmartHome(source, "")
+	assert.NoError(t, sh.SetCredentials("admin", "correct horse battery"))
+	archive := &bytes.Buffer{}
+	_, err := sh.Backup(archive, BackupOptions{})
+	assert.NoError(t, err)
+	encrypted := &bytes.Buffer{}
+	_, err = sh.Backup(encrypted, BackupOptions{Passphrase: "secret"})
+	assert.NoError(t, err)
+
+	envelopeEnd := bytes.IndexByte(archive.Bytes(), '\n') + 1
+	testCases := []struct {
+		name    string
+		archive []byte
+		opts    BackupOptions
+	}{
+		{name: "Empty", archive: []byte{}},
+		{name: "Other format", archive: []byte(`{"format":"tar","version":1}` + "\n")},
+		{name: "Newer version", archive: []byte(`{"format":"smarthome-backup","version":2,"compression":"gzip"}` + "\n")},
+		{name: "Truncated", archive: archive.Bytes()[:len(archive.Bytes())-30]},
+		{name: "Envelope only", archive: archive.Bytes()[:envelopeEnd]},
+		{name: "Truncated encrypted", archive: encrypted.Bytes()[:len(encrypted.Bytes())-30], opts: BackupOptions{Passphrase: "secret"}},
+		{
+			name:    "Downgraded encryption",
+			archive: append([]byte(`{"format":"smarthome-backup","version":1,"compression":"gzip"}`+"\n"), encrypted.Bytes()[bytes.IndexByte(encrypted.Bytes(), '\n')+1:]...),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			target := newBackupClient()
+			_, err := newBackupSmartHome(target, "").Restore(bytes.NewReader(tc.archive), tc.opts)
+			assert.True(tt, errors.Is(err, ErrValidation), err)
+		})
+	}
+}
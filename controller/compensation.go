@@ -0,0 +1,199 @@
+package controller
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultCompensationWindow is how far back the outside temperature is
+// averaged for weather compensation unless configured otherwise
+const DefaultCompensationWindow = 3 * time.Hour
+
+// CompensationPoint is a point of a weather compensation curve: how much the
+// thresholds of a room are shifted when the outside temperature is Outside
+type CompensationPoint struct {
+	Outside float32 `json:"outside" mapstructure:"outside"`
+	Shift   float32 `json:"shift" mapstructure:"shift"`
+}
+
+// WeatherCompensation shifts the thresholds of a room depending on the recent
+// outside temperature, e.g. to start heating earlier when it's freezing
+// outside and the room cools faster
+type WeatherCompensation struct {
+	// Window is how far back the outside temperature is averaged. It
+	// defaults to DefaultCompensationWindow.
+	Window time.Duration
+
+	// Curve are the shifts at some outside temperatures, sorted by outside
+	// temperature. Shifts are interpolated linearly between its points,
+	// and are those of the first and last points beyond them.
+	Curve []CompensationPoint
+}
+
+// EffectiveThresholds are the thresholds a room is heated with, once shifted
+// by its weather compensation and raised to its frost protection.
+// OutsideTemperature is nil, and the thresholds aren't shifted, if there are
+// no recent outside readings. If FrostProtection is true, the room is heated
+// with these thresholds even if it's disabled.
+type EffectiveThresholds struct {
+	ThresholdOn        float32  `json:"threshold_on"`
+	ThresholdOff       float32  `json:"threshold_off"`
+	OutsideTemperature *float32 `json:"outside_temperature,omitempty"`
+	FrostProtection    bool     `json:"frost_protection,omitempty"`
+}
+
+// Validate returns an error unless the curve has points sorted by outside
+// temperature and the window is long enough to have outside readings
+func (w WeatherCompensation) Validate() error {
+	if w.Window != 0 && w.Window < ResolutionFiveMinutes.Duration() {
+		return fmt.Errorf("invalid weather compensation window %s: it must be at least %s", w.Window, ResolutionFiveMinutes.Duration())
+	}
+	if len(w.Curve) == 0 {
+		return fmt.Errorf("invalid weather compensation curve: it must have at least one point")
+	}
+	for i := 1; i < len(w.Curve); i++ {
+		if w.Curve[i].Outside <= w.Curve[i-1].Outside {
+			return fmt.Errorf("invalid weather compensation curve: the outside temperatures of its points must be increasing")
+		}
+	}
+	return nil
+}
+
+// Shift returns how much the thresholds are shifted at an outside temperature
+func (w WeatherCompensation) Shift(outside float32) float32 {
+	if len(w.Curve) == 0 {
+		return 0
+	}
+	if outside <= w.Curve[0].Outside {
+		return w.Curve[0].Shift
+	}
+	for i := 1; i < len(w.Curve); i++ {
+		a, b := w.Curve[i-1], w.Curve[i]
+		if outside <= b.Outside {
+			return a.Shift + (b.Shift-a.Shift)*(outside-a.Outside)/(b.Outside-a.Outside)
+		}
+	}
+	return w.Curve[len(w.Curve)-1].Shift
+}
+
+func (w WeatherCompensation) window() time.Duration {
+	if w.Window == 0 {
+		return DefaultCompensationWindow
+	}
+	return w.Window
+}
+
+// RoomThresholds are the thresholds of a room, and whether it's enabled
+type RoomThresholds struct {
+	Room         string
+	Enabled      bool
+	ThresholdOn  float32
+	ThresholdOff float32
+}
+
+// EffectiveThresholds returns the thresholds some rooms are heated with, in
+// the same order, or nil for those heated with their own: enabled rooms are
+// shifted by their weather compensation, and every room is raised to its
+// frost protection, as SafetyLimits.ShouldHeat does. The outside temperature
+// is averaged once for every window of the compensations of the rooms,
+// rather than once per room.
+func (s *SmartHome) EffectiveThresholds(rooms ...RoomThresholds) []*EffectiveThresholds {
+	now := time.Now()
+	averages := map[time.Duration]*float32{}
+	thresholds := make([]*EffectiveThresholds, len(rooms))
+	for i, r := range rooms {
+		limits := s.Config.Safety.Limits(r.Room)
+		thresholdOn, thresholdOff := r.ThresholdOn, r.ThresholdOff
+		var effective *EffectiveThresholds
+		if policy, ok := s.Config.WeatherCompensation[r.Room]; ok && r.Enabled {
+			outside, ok := averages[policy.window()]
+			if !ok {
+				outside = s.compensationOutsideTemperature(policy.window(), now)
+				averages[policy.window()] = outside
+			}
+			effective = s.compensatedThresholds(r.Room, policy, limits, outside, thresholdOn, thresholdOff)
+			thresholdOn, thresholdOff = effective.ThresholdOn, effective.ThresholdOff
+		}
+
+		thresholdOn, thresholdOff, raised := limits.HeatingThresholds(r.Enabled, thresholdOn, thresholdOff)
+		if raised {
+			if effective == nil {
+				effective = &EffectiveThresholds{}
+			}
+			effective.ThresholdOn = thresholdOn
+			effective.ThresholdOff = thresholdOff
+			effective.FrostProtection = true
+		}
+		thresholds[i] = effective
+	}
+	return thresholds
+}
+
+// compensationOutsideTemperature returns the average outside temperature
+// during the window before now, or nil if there are no outside readings.
+// Failing to get it returns nil too, so that rooms are still heated as
+// configured.
+func (s *SmartHome) compensationOutsideTemperature(window time.Duration, now time.Time) *float32 {
+	outside, err := s.averageOutsideTemperature(now.Add(-window), now)
+	if err != nil {
+		s.Errorw("error getting outside temperature, thresholds are not compensated", "window", window, "error", err.Error())
+		return nil
+	}
+	return outside
+}
+
+// compensatedThresholds returns the thresholds of a room shifted by its
+// weather compensation at an outside temperature. Both thresholds are
+// shifted alike, as far as the safety limits of the room allow. Without an
+// outside temperature, the thresholds aren't shifted.
+func (s *SmartHome) compensatedThresholds(room string, policy WeatherCompensation, limits SafetyLimits, outside *float32, thresholdOn, thresholdOff float32) *EffectiveThresholds {
+	effective := &EffectiveThresholds{ThresholdOn: thresholdOn, ThresholdOff: thresholdOff}
+	if outside == nil {
+		s.Debugw("no recent outside temperature, thresholds are not compensated", "room", room)
+		return effective
+	}
+
+	shift := RoundTemperature(policy.Shift(*outside), s.Config.ThresholdPrecision)
+	if thresholdOff+shift > limits.MaxThreshold {
+		shift = limits.MaxThreshold - thresholdOff
+	}
+	if thresholdOn+shift < limits.MinThreshold {
+		shift = limits.MinThreshold - thresholdOn
+	}
+	effective.ThresholdOn = thresholdOn + shift
+	effective.ThresholdOff = thresholdOff + shift
+	effective.OutsideTemperature = outside
+	return effective
+}
+
+// averageOutsideTemperature returns the average of the outside readings
+// between from and to, or nil if there are none. Both the readings rolled up
+// and those still pending are averaged, as readings aren't rolled up where
+// nothing runs RollupReadings, such as in the Lambdas. The buckets are got
+// before the pending readings, so that a reading rolled up in between is
+// left out rather than counted twice.
+func (s *SmartHome) averageOutsideTemperature(from, to time.Time) (*float32, error) {
+	var sum float64
+	count := 0
+	err := s.ExportReadings(SourceOutside, "", ResolutionFiveMinutes, from, to, func(bucket ReadingBucket) error {
+		sum += float64(bucket.Avg) * float64(bucket.Count)
+		count += bucket.Count
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	pending, err := s.pendingReadings(SourceOutside, from, to)
+	if err != nil {
+		return nil, err
+	}
+	for _, reading := range pending {
+		sum += float64(reading.Temperature)
+		count++
+	}
+	if count == 0 {
+		return nil, nil
+	}
+	average := RoundTemperature(float32(sum/float64(count)), 0.1)
+	return &average, nil
+}
@@ -0,0 +1,271 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/igvaquero18/smarthome/utils/dynamotest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWeatherCompensationShift(t *testing.T) {
+	policy := WeatherCompensation{Curve: []CompensationPoint{
+		{Outside: -5, Shift: 1.5},
+		{Outside: 5, Shift: 0.5},
+		{Outside: 15, Shift: 0},
+	}}
+	testCases := []struct {
+		name     string
+		policy   WeatherCompensation
+		outside  float32
+		expected float32
+	}{
+		{name: "Below the curve", policy: policy, outside: -12, expected: 1.5},
+		{name: "First point", policy: policy, outside: -5, expected: 1.5},
+		{name: "Between points", policy: policy, outside: 0, expected: 1},
+		{name: "Between other points", policy: policy, outside: 11, expected: 0.2},
+		{name: "Above the curve", policy: policy, outside: 30, expected: 0},
+		{name: "Single point", policy: WeatherCompensation{Curve: []CompensationPoint{{Outside: 0, Shift: 1}}}, outside: 10, expected: 1},
+		{name: "Empty curve", policy: WeatherCompensation{}, outside: 0, expected: 0},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			assert.InDelta(tt, tc.expected, tc.policy.Shift(tc.outside), 1e-5)
+		})
+	}
+}
+
+func TestWeatherCompensationValidate(t *testing.T) {
+	testCases := []struct {
+		name          string
+		policy        WeatherCompensation
+		expectedError bool
+	}{
+		{
+			name:   "Valid policy",
+			policy: WeatherCompensation{Window: time.Hour, Curve: []CompensationPoint{{Outside: -5, Shift: 1}, {Outside: 10, Shift: 0}}},
+		},
+		{
+			name:   "Default window",
+			policy: WeatherCompensation{Curve: []CompensationPoint{{Outside: 0, Shift: 1}}},
+		},
+		{
+			name:          "Empty curve",
+			policy:        WeatherCompensation{},
+			expectedError: true,
+		},
+		{
+			name:          "Unsorted curve",
+			policy:        WeatherCompensation{Curve: []CompensationPoint{{Outside: 10, Shift: 0}, {Outside: -5, Shift: 1}}},
+			expectedError: true,
+		},
+		{
+			name:          "Window too short",
+			policy:        WeatherCompensation{Window: time.Minute, Curve: []CompensationPoint{{Outside: 0, Shift: 1}}},
+			expectedError: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			err := tc.policy.Validate()
+			if tc.expectedError {
+				assert.Error(tt, err)
+			} else {
+				assert.NoError(tt, err)
+			}
+		})
+	}
+}
+
+func TestEffectiveThresholds(t *testing.T) {
+	newSmartHome := func(tables map[string]string) *SmartHome {
+		return NewSmartHome(
+			SetDynamoDBClient(newReadingsClient(tables)),
+			SetLogger(mockLogger{}),
+			SetConfig(&SmartHomeConfig{
+				Safety: SafetyPolicy{
+					SafetyLimits: SafetyLimits{MinThreshold: 5, MaxThreshold: 25, FrostProtection: 5},
+					Rooms:        map[string]SafetyOverride{"kids": {MaxThreshold: limit(22)}},
+				},
+				ThresholdPrecision: 0.5,
+				WeatherCompensation: map[string]WeatherCompensation{
+					"bedroom": {Curve: []CompensationPoint{{Outside: -5, Shift: 1.5}, {Outside: 5, Shift: 0.5}, {Outside: 15, Shift: 0}}},
+					"kids":    {Window: time.Hour, Curve: []CompensationPoint{{Outside: 0, Shift: 1.5}}},
+					"office":  {Curve: []CompensationPoint{{Outside: 0, Shift: -20}}},
+				},
+			}),
+		)
+	}
+	sh := newSmartHome(map[string]string{DefaultTempOutsideTable: "Date"})
+	now := time.Now()
+	for _, reading := range []Reading{
+		{Time: now.Add(-2 * time.Hour), Temperature: -2},
+		{Time: now.Add(-31 * time.Minute), Temperature: -1},
+		{Time: now.Add(-30 * time.Minute), Temperature: 1.4},
+	} {
+		assert.NoError(t, sh.AddReading(SourceOutside, reading))
+	}
+	_, err := sh.RollupReadings(SourceOutside, now)
+	assert.NoError(t, err)
+	pending := newSmartHome(map[string]string{DefaultTempOutsideTable: "Date"})
+	assert.NoError(t, pending.AddReading(SourceOutside, Reading{Time: now.Add(-40 * time.Minute), Temperature: -1}))
+	_, err = pending.RollupReadings(SourceOutside, now)
+	assert.NoError(t, err)
+	assert.NoError(t, pending.AddReading(SourceOutside, Reading{Time: now.Add(-10 * time.Minute), Temperature: 1.4}))
+
+	outside := func(t float32) *float32 { return &t }
+	testCases := []struct {
+		name         string
+		smartHome    *SmartHome
+		room         string
+		enabled      bool
+		thresholdOn  float32
+		thresholdOff float32
+		expected     *EffectiveThresholds
+	}{
+		{
+			name:         "Room without compensation",
+			smartHome:    sh,
+			room:         "livingroom",
+			enabled:      true,
+			thresholdOn:  19,
+			thresholdOff: 20,
+		},
+		{
+			name:         "Shift interpolated and rounded",
+			smartHome:    sh,
+			room:         "bedroom",
+			enabled:      true,
+			thresholdOn:  19,
+			thresholdOff: 20.5,
+			expected:     &EffectiveThresholds{ThresholdOn: 20, ThresholdOff: 21.5, OutsideTemperature: outside(-0.5)},
+		},
+		{
+			name:         "Shift limited by the maximum threshold",
+			smartHome:    sh,
+			room:         "kids",
+			enabled:      true,
+			thresholdOn:  20,
+			thresholdOff: 21,
+			expected:     &EffectiveThresholds{ThresholdOn: 21, ThresholdOff: 22, OutsideTemperature: outside(0.2)},
+		},
+		{
+			name:         "Shift limited by the minimum threshold",
+			smartHome:    sh,
+			room:         "office",
+			enabled:      true,
+			thresholdOn:  15,
+			thresholdOff: 16,
+			expected:     &EffectiveThresholds{ThresholdOn: 5, ThresholdOff: 6, OutsideTemperature: outside(-0.5)},
+		},
+		{
+			name:         "Outside readings not rolled up yet",
+			smartHome:    pending,
+			room:         "bedroom",
+			enabled:      true,
+			thresholdOn:  19,
+			thresholdOff: 20.5,
+			expected:     &EffectiveThresholds{ThresholdOn: 20, ThresholdOff: 21.5, OutsideTemperature: outside(0.2)},
+		},
+		{
+			name:         "No outside readings",
+			smartHome:    newSmartHome(map[string]string{DefaultTempOutsideTable: "Date"}),
+			room:         "bedroom",
+			enabled:      true,
+			thresholdOn:  19,
+			thresholdOff: 20.5,
+			expected:     &EffectiveThresholds{ThresholdOn: 19, ThresholdOff: 20.5},
+		},
+		{
+			name:         "Error getting outside readings",
+			smartHome:    newSmartHome(map[string]string{}),
+			room:         "bedroom",
+			enabled:      true,
+			thresholdOn:  19,
+			thresholdOff: 20.5,
+			expected:     &EffectiveThresholds{ThresholdOn: 19, ThresholdOff: 20.5},
+		},
+		{
+			name:         "Thresholds below the frost protection",
+			smartHome:    sh,
+			room:         "livingroom",
+			enabled:      true,
+			thresholdOn:  3,
+			thresholdOff: 6,
+			expected:     &EffectiveThresholds{ThresholdOn: 5, ThresholdOff: 6, FrostProtection: true},
+		},
+		{
+			name:         "Disabled room",
+			smartHome:    sh,
+			room:         "livingroom",
+			thresholdOn:  19,
+			thresholdOff: 20,
+			expected:     &EffectiveThresholds{ThresholdOn: 5, ThresholdOff: 5, FrostProtection: true},
+		},
+		{
+			name:         "Disabled room with compensation",
+			smartHome:    sh,
+			room:         "bedroom",
+			thresholdOn:  19,
+			thresholdOff: 20.5,
+			expected:     &EffectiveThresholds{ThresholdOn: 5, ThresholdOff: 5, FrostProtection: true},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			assert.Equal(tt, []*EffectiveThresholds{tc.expected}, tc.smartHome.EffectiveThresholds(RoomThresholds{
+				Room: tc.room, Enabled: tc.enabled, ThresholdOn: tc.thresholdOn, ThresholdOff: tc.thresholdOff,
+			}))
+		})
+	}
+}
+
+// batchGetCounter counts the BatchGetItem calls to a client
+type batchGetCounter struct {
+	*dynamotest.Client
+	calls int
+}
+
+func (c *batchGetCounter) BatchGetItem(ctx context.Context, input *dynamodb.BatchGetItemInput, opts ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error) {
+	c.calls++
+	return c.Client.BatchGetItem(ctx, input, opts...)
+}
+
+func TestEffectiveThresholdsOfRooms(t *testing.T) {
+	client := &batchGetCounter{Client: newReadingsClient(map[string]string{DefaultTempOutsideTable: "Date"})}
+	sh := NewSmartHome(
+		SetDynamoDBClient(client),
+		SetLogger(mockLogger{}),
+		SetConfig(&SmartHomeConfig{
+			ThresholdPrecision: 0.5,
+			WeatherCompensation: map[string]WeatherCompensation{
+				"bedroom": {Curve: []CompensationPoint{{Outside: 0, Shift: 1}}},
+				"office":  {Curve: []CompensationPoint{{Outside: 0, Shift: 0.5}}},
+			},
+		}),
+	)
+	now := time.Now()
+	assert.NoError(t, sh.AddReading(SourceOutside, Reading{Time: now.Add(-30 * time.Minute), Temperature: 2}))
+	_, err := sh.RollupReadings(SourceOutside, now)
+	assert.NoError(t, err)
+
+	client.calls = 0
+	sh.EffectiveThresholds(RoomThresholds{Room: "bedroom", Enabled: true, ThresholdOn: 19, ThresholdOff: 20})
+	single := client.calls
+	assert.NotZero(t, single)
+
+	client.calls = 0
+	outside := float32(2)
+	assert.Equal(t, []*EffectiveThresholds{
+		{ThresholdOn: 20, ThresholdOff: 21, OutsideTemperature: &outside},
+		nil,
+		{ThresholdOn: 19.5, ThresholdOff: 20.5, OutsideTemperature: &outside},
+	}, sh.EffectiveThresholds(
+		RoomThresholds{Room: "bedroom", Enabled: true, ThresholdOn: 19, ThresholdOff: 20},
+		RoomThresholds{Room: "livingroom", Enabled: true, ThresholdOn: 19, ThresholdOff: 20},
+		RoomThresholds{Room: "office", Enabled: true, ThresholdOn: 19, ThresholdOff: 20},
+	))
+	assert.Equal(t, single, client.calls, "the outside temperature is averaged once for every room")
+}
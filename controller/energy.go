@@ -0,0 +1,452 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// heatingPrefix is the prefix of the items of the heating transitions that
+// were stored in the ControlPlane table before the HeatingTransitions table,
+// which can't be used by rooms
+const heatingPrefix = "heating#"
+
+// EnergyPeriod is the length of the periods energy usage is broken down into
+type EnergyPeriod string
+
+const (
+	// EnergyDaily breaks energy usage down by day, from midnight in the
+	// time zone of the tariff
+	EnergyDaily EnergyPeriod = "day"
+
+	// EnergyWeekly breaks energy usage down by week, from Monday at
+	// midnight in the time zone of the tariff
+	EnergyWeekly EnergyPeriod = "week"
+)
+
+// MaxEnergyRange is the longest range energy usage is estimated for
+const MaxEnergyRange = 366 * 24 * time.Hour
+
+// HeatingRetention is how long heating transitions are kept, so that energy
+// usage can be estimated for any range of the last year along with the
+// transition before it
+const HeatingRetention = 2 * MaxEnergyRange
+
+// DefaultHeaterWattage is the power of the heaters of the rooms, in watts,
+// unless configured otherwise
+const DefaultHeaterWattage float64 = 1500
+
+// HeatingTransition is a change of the heating of a room, as reported by the
+// controller of its heater
+type HeatingTransition struct {
+	Room    string    `json:"room"`
+	Time    time.Time `json:"time"`
+	Heating bool      `json:"heating"`
+}
+
+// TariffBand is a time-of-use band of a tariff, e.g. the peak hours. Start
+// and End are local times such as 08:00, and the band wraps around midnight
+// if End is before Start.
+type TariffBand struct {
+	Name  string  `json:"name" mapstructure:"name"`
+	Start string  `json:"start" mapstructure:"start"`
+	End   string  `json:"end" mapstructure:"end"`
+	Price float64 `json:"price" mapstructure:"price"`
+}
+
+// Tariff is the price of the energy, per kWh. The price of the first band
+// containing a time applies, or Price if there's none.
+type Tariff struct {
+	Currency string
+	Price    float64
+	Bands    []TariffBand
+	Location *time.Location
+}
+
+// EnergyConfig defines how the energy used by the heaters is estimated
+type EnergyConfig struct {
+	// DefaultWattage is the power of the heaters of the rooms not in
+	// Wattage, in watts
+	DefaultWattage float64
+
+	// Wattage is the power of the heater of some rooms, in watts
+	Wattage map[string]float64
+
+	// Tariff is the price of the energy
+	Tariff Tariff
+}
+
+// DefaultEnergyConfig is the EnergyConfig used unless configured otherwise.
+// Its energy is free, so costs are only estimated once a price is set.
+var DefaultEnergyConfig = EnergyConfig{
+	DefaultWattage: DefaultHeaterWattage,
+	Tariff:         Tariff{Location: time.UTC},
+}
+
+// EnergyUsage is how long heaters ran, and the energy they used and its cost
+type EnergyUsage struct {
+	RuntimeSeconds int64   `json:"runtime_seconds"`
+	KWh            float64 `json:"kwh"`
+	Cost           float64 `json:"cost"`
+}
+
+// EnergyPeriodUsage is the EnergyUsage of a day or a week
+type EnergyPeriodUsage struct {
+	Start time.Time `json:"start"`
+	EnergyUsage
+}
+
+// RoomEnergy is the EnergyUsage of the heater of a room in a range, in total
+// and by period
+type RoomEnergy struct {
+	Room    string  `json:"room"`
+	Wattage float64 `json:"wattage"`
+	EnergyUsage
+	Periods []EnergyPeriodUsage `json:"periods"`
+}
+
+// EnergyReport is the EnergyUsage of the heaters of some rooms in a range
+type EnergyReport struct {
+	From     time.Time    `json:"from"`
+	To       time.Time    `json:"to"`
+	Period   EnergyPeriod `json:"period"`
+	Timezone string       `json:"timezone"`
+	Currency string       `json:"currency,omitempty"`
+	EnergyUsage
+	Rooms []RoomEnergy `json:"rooms"`
+}
+
+// RoomWattage returns the power of the heater of a room, in watts
+func (c EnergyConfig) RoomWattage(room string) float64 {
+	if wattage, ok := c.Wattage[room]; ok {
+		return wattage
+	}
+	return c.DefaultWattage
+}
+
+// Validate returns an error if a wattage or a price is negative, or a band
+// of the tariff is invalid
+func (c EnergyConfig) Validate() error {
+	if c.DefaultWattage < 0 {
+		return fmt.Errorf("invalid default heater wattage %g: it can't be negative", c.DefaultWattage)
+	}
+	for room, wattage := range c.Wattage {
+		if wattage < 0 {
+			return fmt.Errorf("invalid heater wattage %g of room %s: it can't be negative", wattage, room)
+		}
+	}
+	if c.Tariff.Price < 0 {
+		return fmt.Errorf("invalid energy price %g: it can't be negative", c.Tariff.Price)
+	}
+	_, err := c.Tariff.bands()
+	return err
+}
+
+// tariffBand is a TariffBand with its times in seconds since midnight
+type tariffBand struct {
+	start, end int
+	price      float64
+}
+
+func (b tariffBand) contains(second int) bool {
+	if b.start < b.end {
+		return second >= b.start && second < b.end
+	}
+	return second >= b.start || second < b.end
+}
+
+// bands returns the parsed bands of the tariff
+func (t Tariff) bands() ([]tariffBand, error) {
+	bands := make([]tariffBand, 0, len(t.Bands))
+	for _, band := range t.Bands {
+		start, err := time.Parse("15:04", band.Start)
+		if err != nil {
+			return nil, fmt.Errorf("invalid start %q of tariff band %s: use the HH:MM format", band.Start, band.Name)
+		}
+		end, err := time.Parse("15:04", band.End)
+		if err != nil {
+			return nil, fmt.Errorf("invalid end %q of tariff band %s: use the HH:MM format", band.End, band.Name)
+		}
+		if start.Equal(end) {
+			return nil, fmt.Errorf("invalid tariff band %s: it starts and ends at %s", band.Name, band.Start)
+		}
+		if band.Price < 0 {
+			return nil, fmt.Errorf("invalid price %g of tariff band %s: it can't be negative", band.Price, band.Name)
+		}
+		bands = append(bands, tariffBand{
+			start: start.Hour()*3600 + start.Minute()*60,
+			end:   end.Hour()*3600 + end.Minute()*60,
+			price: band.Price,
+		})
+	}
+	return bands, nil
+}
+
+func (t Tariff) location() *time.Location {
+	if t.Location == nil {
+		return time.UTC
+	}
+	return t.Location
+}
+
+// price returns the price of the energy at some time, and when it changes
+// next: at the start or the end of a band, or at midnight
+func (t Tariff) price(bands []tariffBand, at time.Time) (float64, time.Time) {
+	local := at.In(t.location())
+	year, month, day := local.Date()
+	second := local.Hour()*3600 + local.Minute()*60 + local.Second()
+	price, found := t.Price, false
+	next := 24 * 3600
+	for _, band := range bands {
+		if !found && band.contains(second) {
+			price, found = band.price, true
+		}
+		for _, boundary := range []int{band.start, band.end} {
+			if boundary > second && boundary < next {
+				next = boundary
+			}
+		}
+	}
+	until := time.Date(year, month, day, next/3600, next%3600/60, 0, 0, t.location())
+	if !until.After(at) {
+		// Local times are ambiguous when clocks go back
+		until = time.Date(year, month, day+1, 0, 0, 0, 0, t.location())
+	}
+	return price, until
+}
+
+// periodStart returns the start of the period of some time
+func (t Tariff) periodStart(period EnergyPeriod, at time.Time) time.Time {
+	local := at.In(t.location())
+	year, month, day := local.Date()
+	if period == EnergyWeekly {
+		day -= (int(local.Weekday()) + 6) % 7
+	}
+	return time.Date(year, month, day, 0, 0, 0, 0, t.location())
+}
+
+// nextPeriod returns the start of the period after the one starting at start
+func (t Tariff) nextPeriod(period EnergyPeriod, start time.Time) time.Time {
+	year, month, day := start.Date()
+	if period == EnergyWeekly {
+		return time.Date(year, month, day+7, 0, 0, 0, 0, t.location())
+	}
+	return time.Date(year, month, day+1, 0, 0, 0, 0, t.location())
+}
+
+func (u *EnergyUsage) add(runtime time.Duration, kwh, cost float64) {
+	u.RuntimeSeconds += int64(runtime / time.Second)
+	u.KWh += kwh
+	u.Cost += cost
+}
+
+// round rounds the energy to Wh and the cost to cents
+func (u *EnergyUsage) round() {
+	u.KWh = math.Round(u.KWh*1000) / 1000
+	u.Cost = math.Round(u.Cost*100) / 100
+}
+
+// Usage estimates the energy used by the heaters of the rooms between from
+// and to from their heating transitions, which must include the last one
+// before from. Heaters still on are counted as running until now, if it's
+// before to.
+func (c EnergyConfig) Usage(transitions []HeatingTransition, rooms []string, period EnergyPeriod, from, to, now time.Time) (*EnergyReport, error) {
+	if period != EnergyDaily && period != EnergyWeekly {
+		return nil, NewValidationError("invalid energy period %s: use %s or %s", period, EnergyDaily, EnergyWeekly)
+	}
+	if !to.After(from) {
+		return nil, NewValidationError("invalid range: %s is not after %s", to.Format(time.RFC3339), from.Format(time.RFC3339))
+	}
+	if to.Sub(from) > MaxEnergyRange {
+		return nil, NewValidationError("invalid range: energy usage is estimated for up to %d days", MaxEnergyRange/(24*time.Hour))
+	}
+	bands, err := c.Tariff.bands()
+	if err != nil {
+		return nil, err
+	}
+
+	transitions = append([]HeatingTransition{}, transitions...)
+	sort.SliceStable(transitions, func(i, j int) bool { return transitions[i].Time.Before(transitions[j].Time) })
+	end := to
+	if now.Before(end) {
+		end = now
+	}
+
+	report := &EnergyReport{
+		From:     from,
+		To:       to,
+		Period:   period,
+		Timezone: c.Tariff.location().String(),
+		Currency: c.Tariff.Currency,
+		Rooms:    make([]RoomEnergy, 0, len(rooms)),
+	}
+	for _, room := range rooms {
+		usage := RoomEnergy{Room: room, Wattage: c.RoomWattage(room), Periods: []EnergyPeriodUsage{}}
+		periods := map[int64]int{}
+		for start := c.Tariff.periodStart(period, from); start.Before(to); start = c.Tariff.nextPeriod(period, start) {
+			periods[start.Unix()] = len(usage.Periods)
+			usage.Periods = append(usage.Periods, EnergyPeriodUsage{Start: start.UTC()})
+		}
+
+		// run adds the energy used while the heater ran between on and off,
+		// split at every change of price, which includes midnight
+		run := func(on, off time.Time) {
+			if on.Before(from) {
+				on = from
+			}
+			if off.After(end) {
+				off = end
+			}
+			for on.Before(off) {
+				price, until := c.Tariff.price(bands, on)
+				if until.After(off) {
+					until = off
+				}
+				runtime := until.Sub(on)
+				kwh := usage.Wattage / 1000 * runtime.Hours()
+				usage.add(runtime, kwh, kwh*price)
+				usage.Periods[periods[c.Tariff.periodStart(period, on).Unix()]].add(runtime, kwh, kwh*price)
+				on = until
+			}
+		}
+
+		heating, since := false, time.Time{}
+		for _, transition := range transitions {
+			if transition.Room != room || !transition.Time.Before(to) || transition.Heating == heating {
+				continue
+			}
+			if heating {
+				run(since, transition.Time)
+			}
+			heating, since = transition.Heating, transition.Time
+		}
+		if heating {
+			run(since, end)
+		}
+
+		report.add(time.Duration(usage.RuntimeSeconds)*time.Second, usage.KWh, usage.Cost)
+		usage.round()
+		for i := range usage.Periods {
+			usage.Periods[i].round()
+		}
+		report.Rooms = append(report.Rooms, usage)
+	}
+	report.round()
+	return report, nil
+}
+
+// RecordHeating records that the heater of a room was switched on or off at
+// some time, in the HeatingTransitions table, whose items are the
+// transitions of a room by their Time and expire after the HeatingRetention
+func (s *SmartHome) RecordHeating(transition HeatingTransition) error {
+	if transition.Room == "" {
+		return NewValidationError("the room of a heating transition is required")
+	}
+	if transition.Time.IsZero() {
+		return NewValidationError("the time of a heating transition is required")
+	}
+
+	s.Debugw("recording heating transition", "room", transition.Room, "heating", transition.Heating, "time", transition.Time)
+	_, err := s.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: &s.Config.HeatingTable,
+		Item: map[string]types.AttributeValue{
+			"Room":      &types.AttributeValueMemberS{Value: transition.Room},
+			"Time":      unixAttribute(transition.Time),
+			"Heating":   &types.AttributeValueMemberBOOL{Value: transition.Heating},
+			"ExpiresAt": unixAttribute(transition.Time.Add(HeatingRetention)),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error recording heating transition of room %s: %w", transition.Room, err)
+	}
+	return nil
+}
+
+// EnergyUsage estimates the energy used by the heaters of the rooms between
+// from and to, broken down by period, from the heating transitions recorded
+func (s *SmartHome) EnergyUsage(rooms []string, period EnergyPeriod, from, to time.Time) (*EnergyReport, error) {
+	if !to.After(from) {
+		return nil, NewValidationError("invalid range: %s is not after %s", to.Format(time.RFC3339), from.Format(time.RFC3339))
+	}
+
+	s.Debugw("getting heating transitions", "rooms", rooms, "from", from, "to", to)
+	transitions := []HeatingTransition{}
+	for _, room := range rooms {
+		// The heater is in the state of the last transition before from
+		last, err := s.queryHeating(room, "#time < :from", map[string]types.AttributeValue{
+			":from": unixAttribute(from),
+		}, false, 1)
+		if err != nil {
+			return nil, err
+		}
+		during, err := s.queryHeating(room, "#time BETWEEN :from AND :to", map[string]types.AttributeValue{
+			":from": unixAttribute(from),
+			":to":   unixAttribute(to),
+		}, true, 0)
+		if err != nil {
+			return nil, err
+		}
+		transitions = append(append(transitions, last...), during...)
+	}
+	return s.Config.Energy.Usage(transitions, rooms, period, from, to, time.Now())
+}
+
+// queryHeating returns the heating transitions of a room whose Time matches a
+// condition, sorted by time, backwards unless forward is true. If limit isn't
+// zero, only that many transitions are returned.
+func (s *SmartHome) queryHeating(room, condition string, values map[string]types.AttributeValue, forward bool, limit int32) ([]HeatingTransition, error) {
+	values[":room"] = &types.AttributeValueMemberS{Value: room}
+	input := &dynamodb.QueryInput{
+		TableName:                 &s.Config.HeatingTable,
+		KeyConditionExpression:    aws.String("Room = :room AND " + condition),
+		ExpressionAttributeNames:  map[string]string{"#time": "Time"},
+		ExpressionAttributeValues: values,
+		ScanIndexForward:          aws.Bool(forward),
+	}
+	if limit > 0 {
+		input.Limit = aws.Int32(limit)
+	}
+
+	transitions := []HeatingTransition{}
+	for {
+		output, err := s.Query(context.TODO(), input)
+		if err != nil {
+			return nil, fmt.Errorf("error getting heating transitions of room %s: %w", room, err)
+		}
+		for _, item := range output.Items {
+			transition, err := heatingTransitionFromItem(item)
+			if err != nil {
+				s.Errorw("invalid heating transition", "item", item, "error", err.Error())
+				continue
+			}
+			transitions = append(transitions, *transition)
+		}
+		if len(output.LastEvaluatedKey) == 0 || limit > 0 {
+			break
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+	return transitions, nil
+}
+
+func heatingTransitionFromItem(item map[string]types.AttributeValue) (*HeatingTransition, error) {
+	room, ok := item["Room"].(*types.AttributeValueMemberS)
+	if !ok {
+		return nil, fmt.Errorf("missing room of heating transition")
+	}
+	t := timeAttribute(item, "Time")
+	if t.IsZero() {
+		return nil, fmt.Errorf("missing time of heating transition of room %s", room.Value)
+	}
+	heating, ok := item["Heating"].(*types.AttributeValueMemberBOOL)
+	if !ok {
+		return nil, fmt.Errorf("missing state of heating transition of room %s", room.Value)
+	}
+	return &HeatingTransition{Room: room.Value, Time: t, Heating: heating.Value}, nil
+}
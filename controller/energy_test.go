@@ -0,0 +1,282 @@
+package controller
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/igvaquero18/smarthome/utils/dynamotest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnergyUsage(t *testing.T) {
+	cet := time.FixedZone("CET", 3600)
+	config := EnergyConfig{
+		DefaultWattage: 1000,
+		Wattage:        map[string]float64{"livingroom": 2000},
+		Tariff: Tariff{
+			Currency: "EUR",
+			Price:    0.10,
+			Bands: []TariffBand{
+				{Name: "peak", Start: "18:00", End: "22:00", Price: 0.30},
+				{Name: "night", Start: "23:00", End: "07:00", Price: 0.05},
+			},
+			Location: cet,
+		},
+	}
+	monday := time.Date(2021, 1, 4, 0, 0, 0, 0, cet)
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2021, 1, day, hour, minute, 0, 0, cet)
+	}
+	future := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name          string
+		config        EnergyConfig
+		transitions   []HeatingTransition
+		rooms         []string
+		period        EnergyPeriod
+		from          time.Time
+		to            time.Time
+		now           time.Time
+		expected      *EnergyReport
+		expectedError error
+	}{
+		{
+			name:   "Daily usage with time-of-use bands",
+			config: config,
+			transitions: []HeatingTransition{
+				{Room: "bedroom", Time: at(4, 17, 0), Heating: true},
+				{Room: "bedroom", Time: at(4, 18, 0), Heating: true},
+				{Room: "bedroom", Time: at(4, 19, 0), Heating: false},
+				{Room: "bedroom", Time: at(5, 22, 30), Heating: true},
+				{Room: "livingroom", Time: at(3, 23, 0), Heating: true},
+				{Room: "livingroom", Time: at(4, 1, 0), Heating: false},
+				{Room: "livingroom", Time: at(6, 1, 0), Heating: true},
+			},
+			rooms:  []string{"bedroom", "livingroom", "kitchen"},
+			period: EnergyDaily,
+			from:   monday,
+			to:     monday.AddDate(0, 0, 2),
+			now:    future,
+			expected: &EnergyReport{
+				From:        monday,
+				To:          monday.AddDate(0, 0, 2),
+				Period:      EnergyDaily,
+				Timezone:    "CET",
+				Currency:    "EUR",
+				EnergyUsage: EnergyUsage{RuntimeSeconds: 16200, KWh: 5.5, Cost: 0.6},
+				Rooms: []RoomEnergy{
+					{
+						Room:        "bedroom",
+						Wattage:     1000,
+						EnergyUsage: EnergyUsage{RuntimeSeconds: 12600, KWh: 3.5, Cost: 0.5},
+						Periods: []EnergyPeriodUsage{
+							{Start: monday.UTC(), EnergyUsage: EnergyUsage{RuntimeSeconds: 7200, KWh: 2, Cost: 0.4}},
+							{Start: monday.AddDate(0, 0, 1).UTC(), EnergyUsage: EnergyUsage{RuntimeSeconds: 5400, KWh: 1.5, Cost: 0.1}},
+						},
+					},
+					{
+						Room:        "livingroom",
+						Wattage:     2000,
+						EnergyUsage: EnergyUsage{RuntimeSeconds: 3600, KWh: 2, Cost: 0.1},
+						Periods: []EnergyPeriodUsage{
+							{Start: monday.UTC(), EnergyUsage: EnergyUsage{RuntimeSeconds: 3600, KWh: 2, Cost: 0.1}},
+							{Start: monday.AddDate(0, 0, 1).UTC()},
+						},
+					},
+					{
+						Room:    "kitchen",
+						Wattage: 1000,
+						Periods: []EnergyPeriodUsage{
+							{Start: monday.UTC()},
+							{Start: monday.AddDate(0, 0, 1).UTC()},
+						},
+					},
+				},
+			},
+		},
+		{
+			name:   "Weekly usage across weeks",
+			config: config,
+			transitions: []HeatingTransition{
+				{Room: "livingroom", Time: at(10, 23, 30), Heating: true},
+				{Room: "livingroom", Time: at(11, 0, 30), Heating: false},
+			},
+			rooms:  []string{"livingroom"},
+			period: EnergyWeekly,
+			from:   at(6, 0, 0),
+			to:     at(12, 0, 0),
+			now:    future,
+			expected: &EnergyReport{
+				From:        at(6, 0, 0),
+				To:          at(12, 0, 0),
+				Period:      EnergyWeekly,
+				Timezone:    "CET",
+				Currency:    "EUR",
+				EnergyUsage: EnergyUsage{RuntimeSeconds: 3600, KWh: 2, Cost: 0.1},
+				Rooms: []RoomEnergy{
+					{
+						Room:        "livingroom",
+						Wattage:     2000,
+						EnergyUsage: EnergyUsage{RuntimeSeconds: 3600, KWh: 2, Cost: 0.1},
+						Periods: []EnergyPeriodUsage{
+							{Start: monday.UTC(), EnergyUsage: EnergyUsage{RuntimeSeconds: 1800, KWh: 1, Cost: 0.05}},
+							{Start: monday.AddDate(0, 0, 7).UTC(), EnergyUsage: EnergyUsage{RuntimeSeconds: 1800, KWh: 1, Cost: 0.05}},
+						},
+					},
+				},
+			},
+		},
+		{
+			name:        "Heater still on",
+			config:      config,
+			transitions: []HeatingTransition{{Room: "bedroom", Time: at(4, 10, 0), Heating: true}},
+			rooms:       []string{"bedroom"},
+			period:      EnergyDaily,
+			from:        monday,
+			to:          monday.AddDate(0, 0, 1),
+			now:         at(4, 12, 0),
+			expected: &EnergyReport{
+				From:        monday,
+				To:          monday.AddDate(0, 0, 1),
+				Period:      EnergyDaily,
+				Timezone:    "CET",
+				Currency:    "EUR",
+				EnergyUsage: EnergyUsage{RuntimeSeconds: 7200, KWh: 2, Cost: 0.2},
+				Rooms: []RoomEnergy{
+					{
+						Room:        "bedroom",
+						Wattage:     1000,
+						EnergyUsage: EnergyUsage{RuntimeSeconds: 7200, KWh: 2, Cost: 0.2},
+						Periods: []EnergyPeriodUsage{
+							{Start: monday.UTC(), EnergyUsage: EnergyUsage{RuntimeSeconds: 7200, KWh: 2, Cost: 0.2}},
+						},
+					},
+				},
+			},
+		},
+		{
+			name:          "Invalid period",
+			config:        config,
+			period:        "month",
+			from:          monday,
+			to:            monday.AddDate(0, 0, 1),
+			now:           future,
+			expectedError: ErrValidation,
+		},
+		{
+			name:          "Inverted range",
+			config:        config,
+			period:        EnergyDaily,
+			from:          monday,
+			to:            monday.Add(-time.Hour),
+			now:           future,
+			expectedError: ErrValidation,
+		},
+		{
+			name:          "Range too long",
+			config:        config,
+			period:        EnergyWeekly,
+			from:          monday,
+			to:            monday.AddDate(2, 0, 0),
+			now:           future,
+			expectedError: ErrValidation,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			report, err := tc.config.Usage(tc.transitions, tc.rooms, tc.period, tc.from, tc.to, tc.now)
+			if tc.expectedError != nil {
+				assert.True(tt, errors.Is(err, tc.expectedError))
+				return
+			}
+			assert.NoError(tt, err)
+			assert.Equal(tt, tc.expected, report)
+		})
+	}
+}
+
+func TestEnergyConfigValidate(t *testing.T) {
+	testCases := []struct {
+		name          string
+		config        EnergyConfig
+		expectedError bool
+	}{
+		{
+			name:   "Default config",
+			config: DefaultEnergyConfig,
+		},
+		{
+			name:   "Band wrapping around midnight",
+			config: EnergyConfig{Tariff: Tariff{Bands: []TariffBand{{Name: "night", Start: "22:00", End: "06:00"}}}},
+		},
+		{
+			name:          "Negative wattage",
+			config:        EnergyConfig{Wattage: map[string]float64{"bedroom": -1}},
+			expectedError: true,
+		},
+		{
+			name:          "Negative price",
+			config:        EnergyConfig{Tariff: Tariff{Price: -0.1}},
+			expectedError: true,
+		},
+		{
+			name:          "Invalid band time",
+			config:        EnergyConfig{Tariff: Tariff{Bands: []TariffBand{{Name: "peak", Start: "6pm", End: "22:00"}}}},
+			expectedError: true,
+		},
+		{
+			name:          "Empty band",
+			config:        EnergyConfig{Tariff: Tariff{Bands: []TariffBand{{Name: "peak", Start: "18:00", End: "18:00"}}}},
+			expectedError: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			err := tc.config.Validate()
+			if tc.expectedError {
+				assert.Error(tt, err)
+			} else {
+				assert.NoError(tt, err)
+			}
+		})
+	}
+}
+
+func TestRecordHeating(t *testing.T) {
+	client := dynamotest.NewClient(map[string]string{DefaultControlPlaneTable: "Room", DefaultHeatingTable: "Room"})
+	client.SetRangeKey(DefaultHeatingTable, "Time")
+	sh := NewSmartHome(
+		SetDynamoDBClient(client),
+		SetLogger(mockLogger{}),
+		SetConfig(&SmartHomeConfig{Energy: EnergyConfig{DefaultWattage: 2000, Tariff: Tariff{Price: 0.2}}}),
+	)
+	from := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// Only the last transition before the range is taken into account
+	assert.NoError(t, sh.RecordHeating(HeatingTransition{Room: "bedroom", Time: from.AddDate(0, 0, -30), Heating: true}))
+	assert.NoError(t, sh.RecordHeating(HeatingTransition{Room: "bedroom", Time: from.AddDate(0, 0, -29), Heating: false}))
+	assert.NoError(t, sh.RecordHeating(HeatingTransition{Room: "livingroom", Time: from, Heating: true}))
+	assert.NoError(t, sh.RecordHeating(HeatingTransition{Room: "bedroom", Time: from.Add(-time.Hour), Heating: true}))
+	assert.NoError(t, sh.RecordHeating(HeatingTransition{Room: "bedroom", Time: from.Add(time.Hour), Heating: false}))
+	assert.NoError(t, sh.RecordHeating(HeatingTransition{Room: "bedroom", Time: from.Add(48 * time.Hour), Heating: true}))
+	assert.True(t, errors.Is(sh.RecordHeating(HeatingTransition{Time: from, Heating: true}), ErrValidation))
+	assert.True(t, errors.Is(sh.RecordHeating(HeatingTransition{Room: "bedroom", Heating: true}), ErrValidation))
+	for _, item := range client.Items(DefaultHeatingTable) {
+		assert.Equal(t, timeAttribute(item, "Time").Add(HeatingRetention), timeAttribute(item, "ExpiresAt"))
+	}
+
+	report, err := sh.EnergyUsage([]string{"bedroom"}, EnergyDaily, from, from.AddDate(0, 0, 1))
+	assert.NoError(t, err)
+	assert.Equal(t, "UTC", report.Timezone)
+	assert.Equal(t, EnergyUsage{RuntimeSeconds: 3600, KWh: 2, Cost: 0.4}, report.EnergyUsage)
+
+	// Heaters still on are counted as running until now
+	report, err = sh.EnergyUsage([]string{"bedroom"}, EnergyDaily, from.AddDate(0, 0, 2), from.AddDate(0, 0, 3))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(24*3600), report.EnergyUsage.RuntimeSeconds)
+
+	_, err = sh.EnergyUsage([]string{"bedroom"}, EnergyDaily, from, from)
+	assert.True(t, errors.Is(err, ErrValidation))
+}
@@ -0,0 +1,99 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Kinds of the errors returned by the controller. The API maps each of them
+// to an HTTP status, so errors.Is(err, ErrNotFound) holds for any error of
+// the NotFound kind, whatever its message.
+var (
+	// ErrNotFound is the kind of the errors about something that doesn't exist
+	ErrNotFound = errors.New("not found")
+
+	// ErrConflict is the kind of the errors about something that conflicts
+	// with the current state, such as creating a user that already exists
+	ErrConflict = errors.New("conflict")
+
+	// ErrValidation is the kind of the errors about invalid input
+	ErrValidation = errors.New("validation failed")
+
+	// ErrUnauthorized is the kind of the errors about missing or invalid credentials
+	ErrUnauthorized = errors.New("unauthorized")
+
+	// ErrForbidden is the kind of the errors about valid credentials that
+	// don't grant access to something
+	ErrForbidden = errors.New("forbidden")
+
+	// ErrPreconditionFailed is the kind of the errors about a conditional
+	// write whose condition doesn't hold, such as a stale version
+	ErrPreconditionFailed = errors.New("precondition failed")
+)
+
+// Error is an error of one of the kinds above, with a message meant for the
+// users of the API and optional details about it
+type Error struct {
+	Kind    error
+	Message string
+	Details map[string]interface{}
+	Err     error
+}
+
+// Error returns the message of the error
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Unwrap returns the error wrapped by the message, if any
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is returns true if target is the kind of the error
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+// WithDetails returns a copy of the error with the details
+func (e *Error) WithDetails(details map[string]interface{}) *Error {
+	c := *e
+	c.Details = details
+	return &c
+}
+
+// NewNotFoundError returns an error of the NotFound kind. The message is
+// formatted like fmt.Errorf, so it can wrap an error with %w.
+func NewNotFoundError(format string, a ...interface{}) *Error {
+	return newError(ErrNotFound, format, a...)
+}
+
+// NewConflictError returns an error of the Conflict kind
+func NewConflictError(format string, a ...interface{}) *Error {
+	return newError(ErrConflict, format, a...)
+}
+
+// NewValidationError returns an error of the Validation kind
+func NewValidationError(format string, a ...interface{}) *Error {
+	return newError(ErrValidation, format, a...)
+}
+
+// NewUnauthorizedError returns an error of the Unauthorized kind
+func NewUnauthorizedError(format string, a ...interface{}) *Error {
+	return newError(ErrUnauthorized, format, a...)
+}
+
+// NewForbiddenError returns an error of the Forbidden kind
+func NewForbiddenError(format string, a ...interface{}) *Error {
+	return newError(ErrForbidden, format, a...)
+}
+
+// NewPreconditionFailedError returns an error of the PreconditionFailed kind
+func NewPreconditionFailedError(format string, a ...interface{}) *Error {
+	return newError(ErrPreconditionFailed, format, a...)
+}
+
+func newError(kind error, format string, a ...interface{}) *Error {
+	err := fmt.Errorf(format, a...)
+	return &Error{Kind: kind, Message: err.Error(), Err: errors.Unwrap(err)}
+}
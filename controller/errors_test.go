@@ -0,0 +1,83 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestError(t *testing.T) {
+	cause := errors.New("connection refused")
+	testCases := []struct {
+		name            string
+		err             error
+		expectedKind    error
+		expectedMessage string
+		expectedCause   error
+	}{
+		{
+			name:            "Not found",
+			err:             NewNotFoundError("user %s not found", "admin"),
+			expectedKind:    ErrNotFound,
+			expectedMessage: "user admin not found",
+		},
+		{
+			name:            "Conflict wrapping an error",
+			err:             NewConflictError("error claiming invitation: %w", cause),
+			expectedKind:    ErrConflict,
+			expectedMessage: "error claiming invitation: connection refused",
+			expectedCause:   cause,
+		},
+		{
+			name:            "Wrapped validation error",
+			err:             fmt.Errorf("%w: it must have at least 10 characters", ErrWeakPassword),
+			expectedKind:    ErrValidation,
+			expectedMessage: "the password doesn't comply with the password policy: it must have at least 10 characters",
+			expectedCause:   ErrWeakPassword,
+		},
+		{
+			name:            "Unauthorized",
+			err:             ErrInvalidAPIKey,
+			expectedKind:    ErrUnauthorized,
+			expectedMessage: "invalid API key",
+		},
+		{
+			name:            "Forbidden",
+			err:             ErrInvalidMFACode,
+			expectedKind:    ErrForbidden,
+			expectedMessage: "invalid two-factor authentication code",
+		},
+		{
+			name:            "Precondition failed",
+			err:             NewPreconditionFailedError("room %s has been modified", "bedroom"),
+			expectedKind:    ErrPreconditionFailed,
+			expectedMessage: "room bedroom has been modified",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			assert.EqualError(tt, tc.err, tc.expectedMessage)
+			for _, kind := range []error{ErrNotFound, ErrConflict, ErrValidation, ErrUnauthorized, ErrForbidden, ErrPreconditionFailed} {
+				assert.Equal(tt, kind == tc.expectedKind, errors.Is(tc.err, kind), "kind %v", kind)
+			}
+			if tc.expectedCause != nil {
+				assert.True(tt, errors.Is(tc.err, tc.expectedCause))
+			}
+		})
+	}
+}
+
+func TestErrorWithDetails(t *testing.T) {
+	err := NewValidationError("invalid room %s", "kitchen")
+	detailed := err.WithDetails(map[string]interface{}{"room": "kitchen"})
+
+	assert.Nil(t, err.Details)
+	assert.Equal(t, map[string]interface{}{"room": "kitchen"}, detailed.Details)
+	assert.True(t, errors.Is(detailed, ErrValidation))
+
+	var controllerErr *Error
+	assert.True(t, errors.As(fmt.Errorf("error setting room: %w", detailed), &controllerErr))
+	assert.Equal(t, detailed, controllerErr)
+}
@@ -0,0 +1,150 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// historyPrefix is the prefix of the items of the history of the options of
+// the rooms that were stored in the ControlPlane table before the
+// RoomSettingsHistory table, which can't be used by rooms
+const historyPrefix = "history#"
+
+// RoomSettingsChange is a change of the options of a room, as recorded in the
+// history of the settings. Deleted changes have no options.
+type RoomSettingsChange struct {
+	Room         string    `json:"room"`
+	Time         time.Time `json:"time"`
+	Deleted      bool      `json:"deleted,omitempty"`
+	Enabled      bool      `json:"enabled"`
+	ThresholdOn  float32   `json:"threshold_on"`
+	ThresholdOff float32   `json:"threshold_off"`
+	Version      int64     `json:"version"`
+}
+
+// RoomSettingsHistory returns the changes of the options of some rooms
+// between from and to, sorted by time. The changes of every room are queried
+// by time from the RoomSettingsHistory table, whose range key is the time of
+// the change in nanoseconds, so that changes made in the same second are
+// kept apart.
+func (s *SmartHome) RoomSettingsHistory(rooms []string, from, to time.Time) ([]RoomSettingsChange, error) {
+	if !to.After(from) {
+		return nil, NewValidationError("invalid range of history: %s is not after %s", to.Format(time.RFC3339), from.Format(time.RFC3339))
+	}
+
+	s.Debugw("getting history of room settings", "rooms", rooms, "from", from, "to", to)
+	changes := []RoomSettingsChange{}
+	for _, room := range rooms {
+		input := &dynamodb.QueryInput{
+			TableName:                &s.Config.HistoryTable,
+			KeyConditionExpression:   aws.String("Room = :room AND #time BETWEEN :from AND :to"),
+			ExpressionAttributeNames: map[string]string{"#time": "Time"},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":room": &types.AttributeValueMemberS{Value: room},
+				":from": nanoAttribute(from),
+				":to":   nanoAttribute(to.Add(-time.Nanosecond)),
+			},
+		}
+		for {
+			output, err := s.Query(context.TODO(), input)
+			if err != nil {
+				return nil, fmt.Errorf("error getting history of the settings of room %s: %w", room, err)
+			}
+			for _, item := range output.Items {
+				change, err := roomSettingsChangeFromItem(item)
+				if err != nil {
+					s.Errorw("invalid change of room settings", "item", item, "error", err.Error())
+					continue
+				}
+				changes = append(changes, *change)
+			}
+			if len(output.LastEvaluatedKey) == 0 {
+				break
+			}
+			input.ExclusiveStartKey = output.LastEvaluatedKey
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if !changes[i].Time.Equal(changes[j].Time) {
+			return changes[i].Time.Before(changes[j].Time)
+		}
+		if changes[i].Room != changes[j].Room {
+			return changes[i].Room < changes[j].Room
+		}
+		return changes[i].Version < changes[j].Version
+	})
+	return changes, nil
+}
+
+// recordRoomSettings adds the options of a room after a write to the history
+// of the settings. Failing to record them doesn't fail the write, which has
+// already been made, so errors are only logged.
+func (s *SmartHome) recordRoomSettings(room string, item map[string]types.AttributeValue, deleted bool) {
+	record := map[string]types.AttributeValue{
+		"Room": &types.AttributeValueMemberS{Value: room},
+		"Time": nanoAttribute(time.Now()),
+	}
+	if deleted {
+		record["Deleted"] = &types.AttributeValueMemberBOOL{Value: true}
+	}
+	for _, attribute := range []string{"Enabled", "ThresholdOn", "ThresholdOff", "Version"} {
+		if value, ok := item[attribute]; ok && !deleted {
+			record[attribute] = value
+		}
+	}
+
+	_, err := s.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: &s.Config.HistoryTable,
+		Item:      record,
+	})
+	if err != nil {
+		s.Errorw("error recording room settings in history", "room", room, "error", err.Error())
+	}
+}
+
+func roomSettingsChangeFromItem(item map[string]types.AttributeValue) (*RoomSettingsChange, error) {
+	room, ok := item["Room"].(*types.AttributeValueMemberS)
+	if !ok {
+		return nil, fmt.Errorf("missing room of change of room settings")
+	}
+	nanos := numberAttribute(item, "Time")
+	if nanos == 0 {
+		return nil, fmt.Errorf("missing time of change of settings of room %s", room.Value)
+	}
+
+	change := &RoomSettingsChange{
+		Room:    room.Value,
+		Time:    time.Unix(0, nanos).UTC(),
+		Version: RoomVersion(item),
+	}
+	if deleted, ok := item["Deleted"].(*types.AttributeValueMemberBOOL); ok {
+		change.Deleted = deleted.Value
+	}
+	if enabled, ok := item["Enabled"].(*types.AttributeValueMemberBOOL); ok {
+		change.Enabled = enabled.Value
+	}
+	for attribute, value := range map[string]*float32{"ThresholdOn": &change.ThresholdOn, "ThresholdOff": &change.ThresholdOff} {
+		n, ok := item[attribute].(*types.AttributeValueMemberN)
+		if !ok {
+			continue
+		}
+		threshold, err := strconv.ParseFloat(n.Value, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s of change of room settings %s: %w", attribute, n.Value, err)
+		}
+		*value = float32(threshold)
+	}
+	return change, nil
+}
+
+// nanoAttribute returns the attribute of a time in nanoseconds since the epoch
+func nanoAttribute(t time.Time) types.AttributeValue {
+	return &types.AttributeValueMemberN{Value: strconv.FormatInt(t.UnixNano(), 10)}
+}
@@ -0,0 +1,66 @@
+package controller
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/igvaquero18/smarthome/utils/dynamotest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoomSettingsHistory(t *testing.T) {
+	client := dynamotest.NewClient(map[string]string{DefaultControlPlaneTable: "Room", DefaultHistoryTable: "Room"})
+	client.SetRangeKey(DefaultHistoryTable, "Time")
+	sh := NewSmartHome(
+		SetDynamoDBClient(client),
+		SetLogger(mockLogger{}),
+		SetConfig(&SmartHomeConfig{}),
+	)
+	from := time.Now().Add(-time.Minute)
+
+	_, err := sh.SetRoomOptions("bedroom", true, 19, 20.5, AnyVersion)
+	assert.NoError(t, err)
+	on := float32(18.5)
+	_, err = sh.UpdateRoomOptions("bedroom", RoomOptionsPatch{ThresholdOn: &on}, ExistingVersion)
+	assert.NoError(t, err)
+	enabled := false
+	assert.NoError(t, sh.ChangeRoomsOptions([]RoomChange{
+		{Room: "livingroom", Action: RoomActionSet, Options: RoomOptionsPatch{Enabled: &enabled, ThresholdOn: &on, ThresholdOff: &on}},
+	}))
+	assert.Error(t, sh.ChangeRoomsOptions([]RoomChange{{Room: "kitchen", Action: RoomActionUpdate, Options: RoomOptionsPatch{Enabled: &enabled}}}))
+	assert.NoError(t, sh.DeleteRoomOptions("livingroom", AnyVersion))
+	_, err = sh.CreateZone("upstairs", []string{"bedroom"})
+	assert.NoError(t, err)
+
+	rooms := []string{"bedroom", "livingroom", "kitchen"}
+	history, err := sh.RoomSettingsHistory(rooms, from, time.Now().Add(time.Minute))
+	assert.NoError(t, err)
+	for i := range history {
+		assert.WithinDuration(t, time.Now(), history[i].Time, time.Minute)
+		history[i].Time = time.Time{}
+	}
+	assert.Equal(t, []RoomSettingsChange{
+		{Room: "bedroom", Enabled: true, ThresholdOn: 19, ThresholdOff: 20.5, Version: 1},
+		{Room: "bedroom", Enabled: true, ThresholdOn: 18.5, ThresholdOff: 20.5, Version: 2},
+		{Room: "livingroom", Enabled: false, ThresholdOn: 18.5, ThresholdOff: 18.5, Version: 1},
+		{Room: "livingroom", Deleted: true},
+	}, history)
+
+	// The history isn't stored in the ControlPlane table anymore
+	for _, item := range client.Items(DefaultControlPlaneTable) {
+		assert.NotContains(t, item["Room"].(*types.AttributeValueMemberS).Value, historyPrefix)
+	}
+
+	history, err = sh.RoomSettingsHistory([]string{"livingroom"}, from, time.Now().Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Len(t, history, 2)
+
+	history, err = sh.RoomSettingsHistory(rooms, from.Add(-time.Hour), from)
+	assert.NoError(t, err)
+	assert.Empty(t, history)
+
+	_, err = sh.RoomSettingsHistory(rooms, from, from)
+	assert.True(t, errors.Is(err, ErrValidation))
+}
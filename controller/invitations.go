@@ -0,0 +1,240 @@
+package controller
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/igvaquero18/smarthome/utils"
+)
+
+const (
+	// DefaultInvitationExpiration is how long invitations are valid unless
+	// requested otherwise
+	DefaultInvitationExpiration = 72 * time.Hour
+
+	// invitationPrefix is the prefix of the invitation items in the
+	// Authentication table, which can't be used by usernames.
+	invitationPrefix = "invite#"
+
+	// invitationCodePrefix identifies SmartHome invitation codes
+	invitationCodePrefix = "shi_"
+)
+
+// ErrInvalidInvitation is returned when an invitation doesn't exist, is wrong,
+// has expired or has already been accepted
+var ErrInvalidInvitation error = NewNotFoundError("invalid invitation")
+
+// Invitation allows someone to create their own user with the role chosen by
+// the admin that invited them. It can only be accepted once.
+type Invitation struct {
+	ID        string    `json:"id"`
+	Role      string    `json:"role"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Expired returns true if the invitation can't be accepted anymore
+func (i *Invitation) Expired() bool {
+	return time.Now().After(i.ExpiresAt)
+}
+
+// CreateInvitation creates an invitation for a user with the role passed as a
+// parameter. The invitation code is returned only once, as only its hash is stored.
+func (s *SmartHome) CreateInvitation(createdBy, role string, expiresAt time.Time) (*Invitation, string, error) {
+	if !utils.Contains(ValidRoles, role) {
+		return nil, "", NewValidationError("invalid role %s, must be one of %v", role, ValidRoles)
+	}
+	if !expiresAt.After(time.Now()) {
+		return nil, "", NewValidationError("the expiration of the invitation must be in the future")
+	}
+
+	id, err := randomHex(8)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := randomHex(24)
+	if err != nil {
+		return nil, "", err
+	}
+
+	invitation := &Invitation{
+		ID:        id,
+		Role:      role,
+		CreatedBy: createdBy,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+		ExpiresAt: expiresAt.UTC().Truncate(time.Second),
+	}
+
+	s.Debugw("creating invitation", "created_by", createdBy, "id", id, "role", role)
+	_, err = s.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: &s.Config.AuthTable,
+		Item: map[string]types.AttributeValue{
+			"Username":   &types.AttributeValueMemberS{Value: invitationPrefix + id},
+			"Role":       &types.AttributeValueMemberS{Value: role},
+			"CreatedBy":  &types.AttributeValueMemberS{Value: createdBy},
+			"SecretHash": &types.AttributeValueMemberS{Value: hashAPIKeySecret(secret)},
+			"CreatedAt":  unixAttribute(invitation.CreatedAt),
+			"ExpiresAt":  unixAttribute(invitation.ExpiresAt),
+		},
+		ConditionExpression: aws.String("attribute_not_exists(Username)"),
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("error storing invitation: %w", err)
+	}
+	return invitation, invitationCodePrefix + id + "_" + secret, nil
+}
+
+// ListInvitations returns the pending invitations, sorted by creation date
+func (s *SmartHome) ListInvitations() ([]Invitation, error) {
+	invitations := []Invitation{}
+	input := &dynamodb.ScanInput{
+		TableName:        &s.Config.AuthTable,
+		FilterExpression: aws.String("begins_with(Username, :prefix) AND attribute_not_exists(AcceptedBy)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":prefix": &types.AttributeValueMemberS{Value: invitationPrefix},
+		},
+	}
+	for {
+		output, err := s.Scan(context.TODO(), input)
+		if err != nil {
+			return nil, fmt.Errorf("error listing invitations: %w", err)
+		}
+		for _, item := range output.Items {
+			invitations = append(invitations, *invitationFromItem(item))
+		}
+		if len(output.LastEvaluatedKey) == 0 {
+			break
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+	sort.Slice(invitations, func(i, j int) bool { return invitations[i].CreatedAt.Before(invitations[j].CreatedAt) })
+	return invitations, nil
+}
+
+// DeleteInvitation revokes an invitation
+func (s *SmartHome) DeleteInvitation(id string) error {
+	s.Debugw("deleting invitation", "id", id)
+	_, err := s.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		TableName:           &s.Config.AuthTable,
+		Key:                 map[string]types.AttributeValue{"Username": &types.AttributeValueMemberS{Value: invitationPrefix + id}},
+		ConditionExpression: aws.String("attribute_exists(Username)"),
+	})
+	var conditionErr *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionErr) {
+		return NewNotFoundError("invitation %s not found", id)
+	}
+	if err != nil {
+		return fmt.Errorf("error deleting invitation %s: %w", id, err)
+	}
+	return nil
+}
+
+// AcceptInvitation creates a user with the username and password chosen by the
+// invitee and the role of the invitation, which is then deleted. If the user
+// already exists, ErrUserExists is returned and the invitation can still be
+// accepted with another username.
+func (s *SmartHome) AcceptInvitation(code, username, password string) (*User, error) {
+	parts := strings.Split(strings.TrimPrefix(code, invitationCodePrefix), "_")
+	if !strings.HasPrefix(code, invitationCodePrefix) || len(parts) != 2 {
+		return nil, ErrInvalidInvitation
+	}
+	id, secret := parts[0], parts[1]
+	key := map[string]types.AttributeValue{"Username": &types.AttributeValueMemberS{Value: invitationPrefix + id}}
+
+	item, err := s.get("Username", invitationPrefix+id, s.Config.AuthTable)
+	if err != nil {
+		return nil, fmt.Errorf("error getting invitation %s: %w", id, err)
+	}
+	hash, ok := item["SecretHash"].(*types.AttributeValueMemberS)
+	if !ok || subtle.ConstantTimeCompare([]byte(hash.Value), []byte(hashAPIKeySecret(secret))) != 1 {
+		return nil, ErrInvalidInvitation
+	}
+	invitation := invitationFromItem(item)
+	if invitation.Expired() {
+		return nil, ErrInvalidInvitation
+	}
+
+	if isReservedUsername(username) || username == "" {
+		return nil, NewValidationError("invalid username %s", username)
+	}
+	if err := s.Config.PasswordPolicy.Validate(username, password); err != nil {
+		return nil, err
+	}
+	hashedPassword, err := s.hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	// Claim the invitation first, so that it can't be accepted twice concurrently
+	var conditionErr *types.ConditionalCheckFailedException
+	_, err = s.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName:           &s.Config.AuthTable,
+		Key:                 key,
+		UpdateExpression:    aws.String("SET AcceptedBy = :username"),
+		ConditionExpression: aws.String("attribute_exists(Username) AND attribute_not_exists(AcceptedBy)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":username": &types.AttributeValueMemberS{Value: username},
+		},
+	})
+	if errors.As(err, &conditionErr) {
+		return nil, ErrInvalidInvitation
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error claiming invitation %s: %w", id, err)
+	}
+
+	_, err = s.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: &s.Config.AuthTable,
+		Item: map[string]types.AttributeValue{
+			"Username": &types.AttributeValueMemberS{Value: username},
+			"Password": &types.AttributeValueMemberS{Value: hashedPassword},
+			"Role":     &types.AttributeValueMemberS{Value: invitation.Role},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(Username)"),
+	})
+	if err != nil {
+		if _, releaseErr := s.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+			TableName:        &s.Config.AuthTable,
+			Key:              key,
+			UpdateExpression: aws.String("REMOVE AcceptedBy"),
+		}); releaseErr != nil {
+			s.Errorw("error releasing invitation", "id", id, "error", releaseErr.Error())
+		}
+		if errors.As(err, &conditionErr) {
+			return nil, ErrUserExists
+		}
+		return nil, fmt.Errorf("error storing invited user %s in the database: %w", username, err)
+	}
+
+	if err := s.delete("Username", invitationPrefix+id, s.Config.AuthTable); err != nil {
+		s.Errorw("error deleting accepted invitation", "id", id, "error", err.Error())
+	}
+
+	s.Infow("invitation accepted", "id", id, "user", username, "role", invitation.Role, "created_by", invitation.CreatedBy)
+	return &User{Username: username, Role: invitation.Role}, nil
+}
+
+func invitationFromItem(item map[string]types.AttributeValue) *Invitation {
+	invitation := &Invitation{}
+	if username, ok := item["Username"].(*types.AttributeValueMemberS); ok {
+		invitation.ID = strings.TrimPrefix(username.Value, invitationPrefix)
+	}
+	if role, ok := item["Role"].(*types.AttributeValueMemberS); ok {
+		invitation.Role = role.Value
+	}
+	if createdBy, ok := item["CreatedBy"].(*types.AttributeValueMemberS); ok {
+		invitation.CreatedBy = createdBy.Value
+	}
+	invitation.CreatedAt = timeAttribute(item, "CreatedAt")
+	invitation.ExpiresAt = timeAttribute(item, "ExpiresAt")
+	return invitation
+}
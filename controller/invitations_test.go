@@ -0,0 +1,157 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+)
+
+type invitationDynamoClient struct {
+	apiKeyDynamoClient
+}
+
+func (m *invitationDynamoClient) PutItem(ctx context.Context, input *dynamodb.PutItemInput, opts ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	if _, ok := m.items[input.Item["Username"].(*types.AttributeValueMemberS).Value]; ok {
+		return nil, &types.ConditionalCheckFailedException{}
+	}
+	return m.apiKeyDynamoClient.PutItem(ctx, input, opts...)
+}
+
+func (m *invitationDynamoClient) UpdateItem(ctx context.Context, input *dynamodb.UpdateItemInput, opts ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	item, ok := m.items[input.Key["Username"].(*types.AttributeValueMemberS).Value]
+	if !ok {
+		return nil, &types.ConditionalCheckFailedException{}
+	}
+	if strings.HasPrefix(*input.UpdateExpression, "REMOVE") {
+		delete(item, "AcceptedBy")
+		return &dynamodb.UpdateItemOutput{}, nil
+	}
+	if _, ok := item["AcceptedBy"]; ok {
+		return nil, &types.ConditionalCheckFailedException{}
+	}
+	item["AcceptedBy"] = input.ExpressionAttributeValues[":username"]
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+func (m *invitationDynamoClient) DeleteItem(ctx context.Context, input *dynamodb.DeleteItemInput, opts ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	delete(m.items, input.Key["Username"].(*types.AttributeValueMemberS).Value)
+	return &dynamodb.DeleteItemOutput{}, nil
+}
+
+func TestCreateInvitation(t *testing.T) {
+	testCases := []struct {
+		name          string
+		role          string
+		expiresAt     time.Time
+		expectedError bool
+	}{
+		{
+			name:      "Valid invitation",
+			role:      RoleUser,
+			expiresAt: time.Now().Add(time.Hour),
+		},
+		{
+			name:          "Invalid role",
+			role:          "guest",
+			expiresAt:     time.Now().Add(time.Hour),
+			expectedError: true,
+		},
+		{
+			name:          "Expiration in the past",
+			role:          RoleUser,
+			expiresAt:     time.Now().Add(-time.Hour),
+			expectedError: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			sh := NewSmartHome(SetDynamoDBClient(&mockDynamoClient{}), SetLogger(mockLogger{}))
+			invitation, code, err := sh.CreateInvitation("admin", tc.role, tc.expiresAt)
+			if tc.expectedError {
+				assert.Error(tt, err)
+				return
+			}
+			assert.NoError(tt, err)
+			assert.True(tt, strings.HasPrefix(code, "shi_"+invitation.ID+"_"))
+			assert.Equal(tt, tc.role, invitation.Role)
+		})
+	}
+}
+
+func TestAcceptInvitation(t *testing.T) {
+	client := &invitationDynamoClient{apiKeyDynamoClient{items: map[string]map[string]types.AttributeValue{
+		"alice": {"Username": &types.AttributeValueMemberS{Value: "alice"}},
+	}}}
+	sh := NewSmartHome(SetDynamoDBClient(client), SetLogger(mockLogger{}))
+	_, code, _ := sh.CreateInvitation("admin", RoleUser, time.Now().Add(time.Hour))
+	expired, expiredCode, _ := sh.CreateInvitation("admin", RoleUser, time.Now().Add(time.Hour))
+	client.items[invitationPrefix+expired.ID]["ExpiresAt"] = unixAttribute(time.Now().Add(-time.Minute))
+
+	testCases := []struct {
+		name,
+		code,
+		username,
+		password string
+		expectedError error
+	}{
+		{
+			name:          "Wrong code",
+			code:          code + "x",
+			username:      "bob",
+			password:      "correct horse battery",
+			expectedError: ErrInvalidInvitation,
+		},
+		{
+			name:          "Expired invitation",
+			code:          expiredCode,
+			username:      "bob",
+			password:      "correct horse battery",
+			expectedError: ErrInvalidInvitation,
+		},
+		{
+			name:          "Weak password",
+			code:          code,
+			username:      "bob",
+			password:      "short",
+			expectedError: ErrWeakPassword,
+		},
+		{
+			name:          "Existing user",
+			code:          code,
+			username:      "alice",
+			password:      "correct horse battery",
+			expectedError: ErrUserExists,
+		},
+		{
+			name:     "Valid invitation",
+			code:     code,
+			username: "bob",
+			password: "correct horse battery",
+		},
+		{
+			name:          "Invitation already accepted",
+			code:          code,
+			username:      "carol",
+			password:      "correct horse battery",
+			expectedError: ErrInvalidInvitation,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			user, err := sh.AcceptInvitation(tc.code, tc.username, tc.password)
+			if tc.expectedError != nil {
+				assert.True(tt, errors.Is(err, tc.expectedError), "unexpected error %v", err)
+				return
+			}
+			assert.NoError(tt, err)
+			assert.Equal(tt, &User{Username: tc.username, Role: RoleUser}, user)
+			assert.NoError(tt, sh.Authenticate(tc.username, tc.password))
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// LoginLockout configures how failed login attempts are throttled. After each
+// failure, the next attempt is delayed by BaseDelay, doubling up to MaxDelay.
+// Once the maximum number of attempts is reached, logins are rejected for
+// LockoutDuration, or until an admin unlocks the user.
+type LoginLockout struct {
+	// MaxAttempts is the number of consecutive failures allowed for a user
+	MaxAttempts int
+
+	// MaxAttemptsPerIP is the number of consecutive failures allowed from
+	// a client IP address, which may be shared by several users
+	MaxAttemptsPerIP int
+
+	// LockoutDuration is how long logins are rejected once locked out
+	LockoutDuration time.Duration
+
+	// BaseDelay is the delay enforced after the first failure
+	BaseDelay time.Duration
+
+	// MaxDelay is the maximum delay enforced between failures
+	MaxDelay time.Duration
+
+	// Retention is how long failures are remembered after the last one
+	Retention time.Duration
+}
+
+// DefaultLoginLockout is the LoginLockout used unless configured otherwise
+var DefaultLoginLockout = LoginLockout{
+	MaxAttempts:      5,
+	MaxAttemptsPerIP: 50,
+	LockoutDuration:  15 * time.Minute,
+	BaseDelay:        time.Second,
+	MaxDelay:         30 * time.Second,
+	Retention:        24 * time.Hour,
+}
+
+type loginAttempts struct {
+	Failures    int
+	NextAttempt time.Time
+}
+
+func userAttemptsKey(username string) string {
+	return "user#" + username
+}
+
+func ipAttemptsKey(ip string) string {
+	return "ip#" + ip
+}
+
+// LoginAllowed returns how long the client has to wait before trying to log
+// in again as the user, or zero if it can try right away. The IP address of
+// the client is optional.
+func (s *SmartHome) LoginAllowed(username, ip string) (time.Duration, error) {
+	keys := []string{userAttemptsKey(username)}
+	if ip != "" {
+		keys = append(keys, ipAttemptsKey(ip))
+	}
+
+	var wait time.Duration
+	for _, key := range keys {
+		attempts, err := s.getLoginAttempts(key)
+		if err != nil {
+			return 0, err
+		}
+		if w := time.Until(attempts.NextAttempt); w > wait {
+			wait = w
+		}
+	}
+	return wait, nil
+}
+
+// RecordLoginFailure records a failed login attempt for the user and for
+// the IP address of the client, if provided.
+func (s *SmartHome) RecordLoginFailure(username, ip string) error {
+	lockout := s.Config.LoginLockout
+	if err := s.recordLoginFailure(userAttemptsKey(username), lockout.MaxAttempts); err != nil {
+		return err
+	}
+	if ip == "" {
+		return nil
+	}
+	return s.recordLoginFailure(ipAttemptsKey(ip), lockout.MaxAttemptsPerIP)
+}
+
+// ResetLoginFailures forgets the failed login attempts of a user, unlocking it
+func (s *SmartHome) ResetLoginFailures(username string) error {
+	s.Debugw("resetting failed login attempts", "user", username)
+	if err := s.delete("Key", userAttemptsKey(username), s.Config.LoginAttemptsTable); err != nil {
+		return fmt.Errorf("error resetting failed login attempts of user %s: %w", username, err)
+	}
+	return nil
+}
+
+func (s *SmartHome) recordLoginFailure(key string, maxAttempts int) error {
+	lockout := s.Config.LoginLockout
+	attempts, err := s.getLoginAttempts(key)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	attempts.Failures++
+	if attempts.Failures >= maxAttempts {
+		attempts.NextAttempt = now.Add(lockout.LockoutDuration)
+		s.Infow("too many failed login attempts, locking out", "key", key, "failures", attempts.Failures, "until", attempts.NextAttempt)
+	} else {
+		delay := lockout.BaseDelay << uint(attempts.Failures-1)
+		if delay > lockout.MaxDelay || delay <= 0 {
+			delay = lockout.MaxDelay
+		}
+		attempts.NextAttempt = now.Add(delay)
+	}
+
+	_, err = s.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: &s.Config.LoginAttemptsTable,
+		Item: map[string]types.AttributeValue{
+			"Key":         &types.AttributeValueMemberS{Value: key},
+			"Failures":    &types.AttributeValueMemberN{Value: strconv.Itoa(attempts.Failures)},
+			"NextAttempt": &types.AttributeValueMemberN{Value: strconv.FormatInt(attempts.NextAttempt.UnixNano()/int64(time.Millisecond), 10)},
+			// ExpiresAt is the DynamoDB Time To Live attribute of the table
+			"ExpiresAt": &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Add(lockout.Retention).Unix(), 10)},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error storing failed login attempt: %w", err)
+	}
+	return nil
+}
+
+func (s *SmartHome) getLoginAttempts(key string) (loginAttempts, error) {
+	attempts := loginAttempts{}
+	item, err := s.get("Key", key, s.Config.LoginAttemptsTable)
+	if err != nil {
+		return attempts, fmt.Errorf("error getting failed login attempts: %w", err)
+	}
+
+	// DynamoDB deletes expired items eventually, so they may still be returned
+	if expiresAt := numberAttribute(item, "ExpiresAt"); expiresAt != 0 && expiresAt < time.Now().Unix() {
+		return attempts, nil
+	}
+	attempts.Failures = int(numberAttribute(item, "Failures"))
+	if next := numberAttribute(item, "NextAttempt"); next != 0 {
+		attempts.NextAttempt = time.Unix(0, next*int64(time.Millisecond))
+	}
+	return attempts, nil
+}
+
+func numberAttribute(item map[string]types.AttributeValue, name string) int64 {
+	attr, ok := item[name].(*types.AttributeValueMemberN)
+	if !ok {
+		return 0
+	}
+	n, _ := strconv.ParseInt(attr.Value, 10, 64)
+	return n
+}
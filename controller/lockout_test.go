@@ -0,0 +1,144 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingDynamoClient struct {
+	mockDynamoClient
+	items map[string]map[string]types.AttributeValue
+}
+
+func (m *recordingDynamoClient) PutItem(ctx context.Context, input *dynamodb.PutItemInput, opts ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	m.items[input.Item["Key"].(*types.AttributeValueMemberS).Value] = input.Item
+	return &dynamodb.PutItemOutput{}, m.err
+}
+
+func attemptsItem(failures int, next time.Time, expiresAt time.Time) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"Key":         &types.AttributeValueMemberS{Value: "user#admin"},
+		"Failures":    &types.AttributeValueMemberN{Value: strconv.Itoa(failures)},
+		"NextAttempt": &types.AttributeValueMemberN{Value: strconv.FormatInt(next.UnixNano()/int64(time.Millisecond), 10)},
+		"ExpiresAt":   &types.AttributeValueMemberN{Value: strconv.FormatInt(expiresAt.Unix(), 10)},
+	}
+}
+
+func TestLoginAllowed(t *testing.T) {
+	testCases := []struct {
+		name          string
+		client        DynamoDBInterface
+		expectedWait  bool
+		expectedError bool
+	}{
+		{
+			name:   "No failed attempts",
+			client: &mockDynamoClient{getItemOutput: &dynamodb.GetItemOutput{}},
+		},
+		{
+			name: "Delay already elapsed",
+			client: &mockDynamoClient{getItemOutput: &dynamodb.GetItemOutput{
+				Item: attemptsItem(2, time.Now().Add(-time.Second), time.Now().Add(time.Hour)),
+			}},
+		},
+		{
+			name: "Locked out",
+			client: &mockDynamoClient{getItemOutput: &dynamodb.GetItemOutput{
+				Item: attemptsItem(5, time.Now().Add(time.Minute), time.Now().Add(time.Hour)),
+			}},
+			expectedWait: true,
+		},
+		{
+			name: "Expired failed attempts",
+			client: &mockDynamoClient{getItemOutput: &dynamodb.GetItemOutput{
+				Item: attemptsItem(5, time.Now().Add(time.Minute), time.Now().Add(-time.Second)),
+			}},
+		},
+		{
+			name:          "Client error",
+			client:        &mockDynamoClient{err: fmt.Errorf("Error")},
+			expectedError: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			sh := NewSmartHome(SetDynamoDBClient(tc.client), SetLogger(mockLogger{}))
+			wait, err := sh.LoginAllowed("admin", "192.0.2.1")
+			if tc.expectedError {
+				assert.Error(tt, err)
+				return
+			}
+			assert.NoError(tt, err)
+			assert.Equal(tt, tc.expectedWait, wait > 0)
+		})
+	}
+}
+
+func TestRecordLoginFailure(t *testing.T) {
+	testCases := []struct {
+		name             string
+		previous         map[string]types.AttributeValue
+		expectedFailures string
+		minWait, maxWait time.Duration
+	}{
+		{
+			name:             "First failure",
+			expectedFailures: "1",
+			minWait:          0,
+			maxWait:          time.Second,
+		},
+		{
+			name:             "Progressive delay",
+			previous:         attemptsItem(3, time.Now(), time.Now().Add(time.Hour)),
+			expectedFailures: "4",
+			minWait:          7 * time.Second,
+			maxWait:          8 * time.Second,
+		},
+		{
+			name:             "Lockout",
+			previous:         attemptsItem(4, time.Now(), time.Now().Add(time.Hour)),
+			expectedFailures: "5",
+			minWait:          14 * time.Minute,
+			maxWait:          15 * time.Minute,
+		},
+		{
+			name:             "Expired failures are forgotten",
+			previous:         attemptsItem(4, time.Now(), time.Now().Add(-time.Second)),
+			expectedFailures: "1",
+			minWait:          0,
+			maxWait:          time.Second,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			client := &recordingDynamoClient{
+				mockDynamoClient: mockDynamoClient{getItemOutput: &dynamodb.GetItemOutput{Item: tc.previous}},
+				items:            map[string]map[string]types.AttributeValue{},
+			}
+			sh := NewSmartHome(SetDynamoDBClient(client), SetLogger(mockLogger{}))
+			assert.NoError(tt, sh.RecordLoginFailure("admin", "192.0.2.1"))
+
+			item := client.items["user#admin"]
+			assert.Equal(tt, tc.expectedFailures, item["Failures"].(*types.AttributeValueMemberN).Value)
+			next := time.Unix(0, numberAttribute(item, "NextAttempt")*int64(time.Millisecond))
+			wait := time.Until(next)
+			assert.True(tt, wait > tc.minWait && wait <= tc.maxWait, "unexpected wait %s", wait)
+			assert.Contains(tt, client.items, "ip#192.0.2.1")
+		})
+	}
+}
+
+func TestResetLoginFailures(t *testing.T) {
+	sh := NewSmartHome(SetDynamoDBClient(&mockDynamoClient{}), SetLogger(mockLogger{}))
+	assert.NoError(t, sh.ResetLoginFailures("admin"))
+
+	sh = NewSmartHome(SetDynamoDBClient(&mockDynamoClient{err: fmt.Errorf("Error")}), SetLogger(mockLogger{}))
+	assert.Error(t, sh.ResetLoginFailures("admin"))
+}
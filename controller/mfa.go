@@ -0,0 +1,217 @@
+package controller
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/igvaquero18/smarthome/utils"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// RecoveryCodes is the number of single-use recovery codes generated when a
+// user enables two-factor authentication, for logging in without the device.
+const RecoveryCodes = 10
+
+var (
+	// ErrInvalidMFACode is returned when a two-factor authentication code is
+	// wrong or has already been used
+	ErrInvalidMFACode error = NewForbiddenError("invalid two-factor authentication code")
+
+	// ErrMFAAlreadyEnabled is returned when enrolling a user that already
+	// has two-factor authentication enabled
+	ErrMFAAlreadyEnabled error = NewConflictError("two-factor authentication is already enabled")
+
+	// ErrMFANotEnrolled is returned when confirming two-factor authentication
+	// for a user that hasn't started enrolling
+	ErrMFANotEnrolled error = NewConflictError("two-factor authentication enrollment not started")
+)
+
+// EnrollMFA starts enabling TOTP two-factor authentication for a user, and
+// returns the TOTP secret. It is not required at login until confirmed with
+// ConfirmMFA, so that users can't lock themselves out.
+func (s *SmartHome) EnrollMFA(username string) (string, error) {
+	s.Debugw("enrolling user in two-factor authentication", "user", username)
+	user, err := s.GetUser(username)
+	if err != nil {
+		return "", err
+	}
+	if user == nil {
+		return "", NewNotFoundError("user %s not found", username)
+	}
+	if user.MFAEnabled {
+		return "", ErrMFAAlreadyEnabled
+	}
+
+	secret, err := utils.NewTOTPSecret()
+	if err != nil {
+		return "", err
+	}
+	_, err = s.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName:        &s.Config.AuthTable,
+		Key:              map[string]types.AttributeValue{"Username": &types.AttributeValueMemberS{Value: username}},
+		UpdateExpression: aws.String("SET MFASecret = :secret"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":secret": &types.AttributeValueMemberS{Value: secret},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("error storing the TOTP secret of user %s: %w", username, err)
+	}
+	return secret, nil
+}
+
+// ConfirmMFA enables two-factor authentication for a user once it proves
+// having the TOTP secret, returning its recovery codes. Only hashes of the
+// recovery codes are stored, so they can't be retrieved again.
+func (s *SmartHome) ConfirmMFA(username, code string) ([]string, error) {
+	s.Debugw("confirming two-factor authentication", "user", username)
+	item, err := s.get("Username", username, s.Config.AuthTable)
+	if err != nil {
+		return nil, fmt.Errorf("error getting user %s: %w", username, err)
+	}
+	if enabled, ok := item["MFAEnabled"].(*types.AttributeValueMemberBOOL); ok && enabled.Value {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	secret, ok := item["MFASecret"].(*types.AttributeValueMemberS)
+	if !ok {
+		return nil, ErrMFANotEnrolled
+	}
+	counter, ok := utils.ValidateTOTP(secret.Value, normalizeMFACode(code), time.Now())
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	codes := make([]string, RecoveryCodes)
+	hashes := make([]string, RecoveryCodes)
+	for i := range codes {
+		if codes[i], err = newRecoveryCode(); err != nil {
+			return nil, err
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(codes[i]), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, fmt.Errorf("error hashing recovery code: %w", err)
+		}
+		hashes[i] = string(hash)
+	}
+
+	_, err = s.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName:        &s.Config.AuthTable,
+		Key:              map[string]types.AttributeValue{"Username": &types.AttributeValueMemberS{Value: username}},
+		UpdateExpression: aws.String("SET MFAEnabled = :enabled, RecoveryCodes = :codes, MFALastCounter = :counter"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":enabled": &types.AttributeValueMemberBOOL{Value: true},
+			":codes":   &types.AttributeValueMemberSS{Value: hashes},
+			":counter": &types.AttributeValueMemberN{Value: strconv.FormatUint(counter, 10)},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error enabling two-factor authentication for user %s: %w", username, err)
+	}
+	s.Infow("two-factor authentication enabled", "user", username)
+	return codes, nil
+}
+
+// VerifyMFA checks a TOTP or recovery code of a user. Codes can only be used
+// once. Users without two-factor authentication enabled are always verified.
+func (s *SmartHome) VerifyMFA(username, code string) error {
+	item, err := s.get("Username", username, s.Config.AuthTable)
+	if err != nil {
+		return fmt.Errorf("error getting user %s: %w", username, err)
+	}
+	if enabled, ok := item["MFAEnabled"].(*types.AttributeValueMemberBOOL); !ok || !enabled.Value {
+		return nil
+	}
+
+	code = normalizeMFACode(code)
+	if secret, ok := item["MFASecret"].(*types.AttributeValueMemberS); ok {
+		if counter, ok := utils.ValidateTOTP(secret.Value, code, time.Now()); ok {
+			return s.useTOTPCounter(username, counter)
+		}
+	}
+
+	if hashes, ok := item["RecoveryCodes"].(*types.AttributeValueMemberSS); ok {
+		for _, hash := range hashes.Value {
+			if bcrypt.CompareHashAndPassword([]byte(hash), []byte(code)) == nil {
+				return s.useRecoveryCode(username, hash)
+			}
+		}
+	}
+	return ErrInvalidMFACode
+}
+
+// DisableMFA disables two-factor authentication for a user
+func (s *SmartHome) DisableMFA(username string) error {
+	_, err := s.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName:        &s.Config.AuthTable,
+		Key:              map[string]types.AttributeValue{"Username": &types.AttributeValueMemberS{Value: username}},
+		UpdateExpression: aws.String("REMOVE MFASecret, MFAEnabled, RecoveryCodes, MFALastCounter"),
+	})
+	if err != nil {
+		return fmt.Errorf("error disabling two-factor authentication for user %s: %w", username, err)
+	}
+	s.Infow("two-factor authentication disabled", "user", username)
+	return nil
+}
+
+// useTOTPCounter records the time step of the last TOTP code used, so that
+// the same code can't be replayed.
+func (s *SmartHome) useTOTPCounter(username string, counter uint64) error {
+	_, err := s.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName:           &s.Config.AuthTable,
+		Key:                 map[string]types.AttributeValue{"Username": &types.AttributeValueMemberS{Value: username}},
+		UpdateExpression:    aws.String("SET MFALastCounter = :counter"),
+		ConditionExpression: aws.String("attribute_not_exists(MFALastCounter) OR MFALastCounter < :counter"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":counter": &types.AttributeValueMemberN{Value: strconv.FormatUint(counter, 10)},
+		},
+	})
+	return mfaUpdateError(username, err)
+}
+
+func (s *SmartHome) useRecoveryCode(username, hash string) error {
+	s.Infow("recovery code used", "user", username)
+	_, err := s.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName:           &s.Config.AuthTable,
+		Key:                 map[string]types.AttributeValue{"Username": &types.AttributeValueMemberS{Value: username}},
+		UpdateExpression:    aws.String("DELETE RecoveryCodes :codes"),
+		ConditionExpression: aws.String("contains(RecoveryCodes, :code)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":codes": &types.AttributeValueMemberSS{Value: []string{hash}},
+			":code":  &types.AttributeValueMemberS{Value: hash},
+		},
+	})
+	return mfaUpdateError(username, err)
+}
+
+func mfaUpdateError(username string, err error) error {
+	var conditionErr *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionErr) {
+		return ErrInvalidMFACode
+	}
+	if err != nil {
+		return fmt.Errorf("error updating two-factor authentication of user %s: %w", username, err)
+	}
+	return nil
+}
+
+func newRecoveryCode() (string, error) {
+	b := make([]byte, 5)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error generating recovery code: %w", err)
+	}
+	code := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+	return code[:4] + "-" + code[4:], nil
+}
+
+func normalizeMFACode(code string) string {
+	return strings.ToLower(strings.Join(strings.Fields(code), ""))
+}
@@ -0,0 +1,184 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/igvaquero18/smarthome/utils"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const testTOTPSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestEnrollMFA(t *testing.T) {
+	testCases := []struct {
+		name          string
+		item          map[string]types.AttributeValue
+		expectedError bool
+	}{
+		{
+			name: "Enroll user",
+			item: map[string]types.AttributeValue{
+				"Username": &types.AttributeValueMemberS{Value: "admin"},
+			},
+		},
+		{
+			name: "Already enabled",
+			item: map[string]types.AttributeValue{
+				"Username":   &types.AttributeValueMemberS{Value: "admin"},
+				"MFAEnabled": &types.AttributeValueMemberBOOL{Value: true},
+			},
+			expectedError: true,
+		},
+		{
+			name:          "User not found",
+			expectedError: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			client := &mockDynamoClient{getItemOutput: &dynamodb.GetItemOutput{Item: tc.item}}
+			sh := NewSmartHome(SetDynamoDBClient(client), SetLogger(mockLogger{}))
+			secret, err := sh.EnrollMFA("admin")
+			if tc.expectedError {
+				assert.Error(tt, err)
+				return
+			}
+			assert.NoError(tt, err)
+			assert.NotEmpty(tt, secret)
+		})
+	}
+}
+
+func TestConfirmMFA(t *testing.T) {
+	code, _ := utils.TOTPCode(testTOTPSecret, time.Now())
+	testCases := []struct {
+		name          string
+		item          map[string]types.AttributeValue
+		code          string
+		expectedError error
+	}{
+		{
+			name: "Valid code",
+			item: map[string]types.AttributeValue{
+				"MFASecret": &types.AttributeValueMemberS{Value: testTOTPSecret},
+			},
+			code: code,
+		},
+		{
+			name: "Invalid code",
+			item: map[string]types.AttributeValue{
+				"MFASecret": &types.AttributeValueMemberS{Value: testTOTPSecret},
+			},
+			code:          "000000",
+			expectedError: ErrInvalidMFACode,
+		},
+		{
+			name:          "Not enrolled",
+			item:          map[string]types.AttributeValue{},
+			code:          code,
+			expectedError: ErrMFANotEnrolled,
+		},
+		{
+			name: "Already enabled",
+			item: map[string]types.AttributeValue{
+				"MFASecret":  &types.AttributeValueMemberS{Value: testTOTPSecret},
+				"MFAEnabled": &types.AttributeValueMemberBOOL{Value: true},
+			},
+			code:          code,
+			expectedError: ErrMFAAlreadyEnabled,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			client := &mockDynamoClient{getItemOutput: &dynamodb.GetItemOutput{Item: tc.item}}
+			sh := NewSmartHome(SetDynamoDBClient(client), SetLogger(mockLogger{}))
+			codes, err := sh.ConfirmMFA("admin", tc.code)
+			if tc.expectedError != nil {
+				assert.True(tt, errors.Is(err, tc.expectedError), "unexpected error %v", err)
+				return
+			}
+			assert.NoError(tt, err)
+			assert.Len(tt, codes, RecoveryCodes)
+		})
+	}
+}
+
+func TestVerifyMFA(t *testing.T) {
+	code, _ := utils.TOTPCode(testTOTPSecret, time.Now())
+	recoveryHash, _ := bcrypt.GenerateFromPassword([]byte("abcd-efgh"), bcrypt.MinCost)
+	enabled := map[string]types.AttributeValue{
+		"MFASecret":     &types.AttributeValueMemberS{Value: testTOTPSecret},
+		"MFAEnabled":    &types.AttributeValueMemberBOOL{Value: true},
+		"RecoveryCodes": &types.AttributeValueMemberSS{Value: []string{string(recoveryHash)}},
+	}
+	testCases := []struct {
+		name          string
+		item          map[string]types.AttributeValue
+		code          string
+		updateErr     error
+		expectedError bool
+	}{
+		{
+			name: "MFA not enabled",
+			item: map[string]types.AttributeValue{},
+		},
+		{
+			name: "Valid TOTP code",
+			item: enabled,
+			code: code,
+		},
+		{
+			name: "Valid recovery code",
+			item: enabled,
+			code: "ABCD-EFGH",
+		},
+		{
+			name:          "Invalid code",
+			item:          enabled,
+			code:          "000000",
+			expectedError: true,
+		},
+		{
+			name:          "Missing code",
+			item:          enabled,
+			expectedError: true,
+		},
+		{
+			name:          "Code already used",
+			item:          enabled,
+			code:          code,
+			updateErr:     &types.ConditionalCheckFailedException{},
+			expectedError: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			client := &mockDynamoClient{getItemOutput: &dynamodb.GetItemOutput{Item: tc.item}}
+			sh := NewSmartHome(SetDynamoDBClient(client), SetLogger(mockLogger{}))
+			if tc.updateErr != nil {
+				// The item was already read, so only the update fails
+				sh = NewSmartHome(SetDynamoDBClient(&failingUpdateClient{client, tc.updateErr}), SetLogger(mockLogger{}))
+			}
+			err := sh.VerifyMFA("admin", tc.code)
+			if tc.expectedError {
+				assert.Error(tt, err)
+				return
+			}
+			assert.NoError(tt, err)
+		})
+	}
+}
+
+func TestDisableMFA(t *testing.T) {
+	sh := NewSmartHome(SetDynamoDBClient(&mockDynamoClient{}), SetLogger(mockLogger{}))
+	assert.NoError(t, sh.DisableMFA("admin"))
+
+	sh = NewSmartHome(SetDynamoDBClient(&mockDynamoClient{err: fmt.Errorf("Error")}), SetLogger(mockLogger{}))
+	assert.Error(t, sh.DisableMFA("admin"))
+}
@@ -10,6 +10,12 @@ type mockDynamoClient struct {
 	getItemOutput    *dynamodb.GetItemOutput
 	putItemOutput    *dynamodb.PutItemOutput
 	deleteItemOutput *dynamodb.DeleteItemOutput
+	updateItemOutput *dynamodb.UpdateItemOutput
+	scanOutput       *dynamodb.ScanOutput
+	queryOutput      *dynamodb.QueryOutput
+	batchGetOutput   *dynamodb.BatchGetItemOutput
+	transactOutput   *dynamodb.TransactWriteItemsOutput
+	batchWriteOutput *dynamodb.BatchWriteItemOutput
 	err              error
 }
 
@@ -25,6 +31,38 @@ func (m *mockDynamoClient) DeleteItem(ctx context.Context, input *dynamodb.Delet
 	return m.deleteItemOutput, m.err
 }
 
+func (m *mockDynamoClient) UpdateItem(ctx context.Context, input *dynamodb.UpdateItemInput, opts ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	return m.updateItemOutput, m.err
+}
+
+func (m *mockDynamoClient) BatchGetItem(ctx context.Context, input *dynamodb.BatchGetItemInput, opts ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error) {
+	return m.batchGetOutput, m.err
+}
+
+func (m *mockDynamoClient) TransactWriteItems(ctx context.Context, input *dynamodb.TransactWriteItemsInput, opts ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+	return m.transactOutput, m.err
+}
+
+func (m *mockDynamoClient) Scan(ctx context.Context, input *dynamodb.ScanInput, opts ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	return m.scanOutput, m.err
+}
+
+func (m *mockDynamoClient) BatchWriteItem(ctx context.Context, input *dynamodb.BatchWriteItemInput, opts ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error) {
+	return m.batchWriteOutput, m.err
+}
+
+func (m *mockDynamoClient) CreateTable(ctx context.Context, input *dynamodb.CreateTableInput, opts ...func(*dynamodb.Options)) (*dynamodb.CreateTableOutput, error) {
+	return &dynamodb.CreateTableOutput{}, m.err
+}
+
+func (m *mockDynamoClient) Query(ctx context.Context, input *dynamodb.QueryInput, opts ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	return m.queryOutput, m.err
+}
+
+func (m *mockDynamoClient) DescribeTable(ctx context.Context, input *dynamodb.DescribeTableInput, opts ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error) {
+	return &dynamodb.DescribeTableOutput{}, m.err
+}
+
 type mockLogger struct{}
 
 func (m mockLogger) Debug(...interface{}) {
@@ -62,3 +100,12 @@ func (m mockLogger) Infof(string, ...interface{}) {
 func (m mockLogger) Infow(string, ...interface{}) {
 	return
 }
+
+type failingUpdateClient struct {
+	*mockDynamoClient
+	err error
+}
+
+func (m *failingUpdateClient) UpdateItem(ctx context.Context, input *dynamodb.UpdateItemInput, opts ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	return nil, m.err
+}
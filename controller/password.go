@@ -0,0 +1,109 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// DefaultPasswordMinLength is the minimum length of passwords unless configured otherwise
+	DefaultPasswordMinLength = 10
+
+	// passwordMaxLength is the maximum length of passwords, as bcrypt
+	// ignores anything after the first 72 bytes
+	passwordMaxLength = 72
+)
+
+var (
+	// ErrWeakPassword is returned when a password doesn't comply with the password policy
+	ErrWeakPassword error = NewValidationError("the password doesn't comply with the password policy")
+
+	// ErrUserExists is returned when signing up a user that already exists
+	ErrUserExists error = NewConflictError("the user already exists")
+)
+
+// PasswordPolicy defines the requirements of the passwords of the users
+type PasswordPolicy struct {
+	// MinLength is the minimum number of characters of a password
+	MinLength int
+
+	// Breached is a set of known breached passwords, in lowercase,
+	// that can't be used
+	Breached map[string]struct{}
+}
+
+// DefaultPasswordPolicy is the PasswordPolicy used unless configured otherwise
+var DefaultPasswordPolicy = PasswordPolicy{MinLength: DefaultPasswordMinLength}
+
+// Validate returns an error wrapping ErrWeakPassword if the password of the
+// user doesn't comply with the policy
+func (p PasswordPolicy) Validate(username, password string) error {
+	if n := len([]rune(password)); n < p.MinLength {
+		return fmt.Errorf("%w: it must have at least %d characters", ErrWeakPassword, p.MinLength)
+	}
+	if len(password) > passwordMaxLength {
+		return fmt.Errorf("%w: it must have at most %d bytes", ErrWeakPassword, passwordMaxLength)
+	}
+	if strings.EqualFold(password, username) {
+		return fmt.Errorf("%w: it can't be the username", ErrWeakPassword)
+	}
+	if _, ok := p.Breached[strings.ToLower(password)]; ok {
+		return fmt.Errorf("%w: it appears in a list of breached passwords", ErrWeakPassword)
+	}
+	return nil
+}
+
+// ChangePassword changes the password of a user, which has to provide its
+// current password
+func (s *SmartHome) ChangePassword(username, oldPassword, newPassword string) error {
+	if err := s.Authenticate(username, oldPassword); err != nil {
+		return err
+	}
+	return s.SetPassword(username, newPassword)
+}
+
+// SetPassword replaces the password of an existing user, as long as the new
+// one complies with the password policy. Callers must have authenticated the
+// user with its current password, as it isn't checked again.
+func (s *SmartHome) SetPassword(username, newPassword string) error {
+	s.Debugw("changing password", "user", username)
+	if err := s.Config.PasswordPolicy.Validate(username, newPassword); err != nil {
+		return err
+	}
+	hashedPassword, err := s.hashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName: &s.Config.AuthTable,
+		Key: map[string]types.AttributeValue{
+			"Username": &types.AttributeValueMemberS{Value: username},
+		},
+		UpdateExpression:    aws.String("SET Password = :password"),
+		ConditionExpression: aws.String("attribute_exists(Username)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":password": &types.AttributeValueMemberS{Value: hashedPassword},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error storing the new password of user %s: %w", username, err)
+	}
+
+	s.Infow("password changed", "user", username)
+	return nil
+}
+
+func (s *SmartHome) hashPassword(password string) (string, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), s.Config.BcryptCost)
+	if err != nil {
+		return "", fmt.Errorf("error hashing the password: %w", err)
+	}
+	return string(hashedPassword), nil
+}
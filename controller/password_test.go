@@ -0,0 +1,149 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPasswordPolicyValidate(t *testing.T) {
+	policy := PasswordPolicy{
+		MinLength: 10,
+		Breached:  map[string]struct{}{"password1234": {}},
+	}
+	testCases := []struct {
+		name     string
+		password string
+		valid    bool
+	}{
+		{
+			name:     "Valid password",
+			password: "correct horse battery",
+			valid:    true,
+		},
+		{
+			name:     "Too short",
+			password: "short",
+		},
+		{
+			name:     "Too long",
+			password: strings.Repeat("a", 73),
+		},
+		{
+			name:     "Same as the username",
+			password: "Administrator",
+		},
+		{
+			name:     "Breached password",
+			password: "Password1234",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			err := policy.Validate("administrator", tc.password)
+			if tc.valid {
+				assert.NoError(tt, err)
+				return
+			}
+			assert.True(tt, errors.Is(err, ErrWeakPassword), "unexpected error %v", err)
+		})
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("old password"), bcrypt.MinCost)
+	item := map[string]types.AttributeValue{
+		"Username": &types.AttributeValueMemberS{Value: "admin"},
+		"Password": &types.AttributeValueMemberS{Value: string(hash)},
+	}
+	testCases := []struct {
+		name          string
+		oldPassword   string
+		newPassword   string
+		client        *mockDynamoClient
+		expectedError bool
+	}{
+		{
+			name:        "Valid change",
+			oldPassword: "old password",
+			newPassword: "new long password",
+			client:      &mockDynamoClient{getItemOutput: &dynamodb.GetItemOutput{Item: item}},
+		},
+		{
+			name:          "Wrong old password",
+			oldPassword:   "wrong password",
+			newPassword:   "new long password",
+			client:        &mockDynamoClient{getItemOutput: &dynamodb.GetItemOutput{Item: item}},
+			expectedError: true,
+		},
+		{
+			name:          "Weak new password",
+			oldPassword:   "old password",
+			newPassword:   "short",
+			client:        &mockDynamoClient{getItemOutput: &dynamodb.GetItemOutput{Item: item}},
+			expectedError: true,
+		},
+		{
+			name:          "Client error",
+			oldPassword:   "old password",
+			newPassword:   "new long password",
+			client:        &mockDynamoClient{err: fmt.Errorf("Error")},
+			expectedError: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			sh := NewSmartHome(SetDynamoDBClient(tc.client), SetLogger(mockLogger{}))
+			err := sh.ChangePassword("admin", tc.oldPassword, tc.newPassword)
+			if tc.expectedError {
+				assert.Error(tt, err)
+				return
+			}
+			assert.NoError(tt, err)
+		})
+	}
+}
+
+func TestSetPassword(t *testing.T) {
+	testCases := []struct {
+		name          string
+		newPassword   string
+		client        *mockDynamoClient
+		expectedError bool
+	}{
+		{
+			name:        "Valid change, without the old password",
+			newPassword: "new long password",
+			client:      &mockDynamoClient{},
+		},
+		{
+			name:          "Weak new password",
+			newPassword:   "short",
+			client:        &mockDynamoClient{},
+			expectedError: true,
+		},
+		{
+			name:          "Client error",
+			newPassword:   "new long password",
+			client:        &mockDynamoClient{err: fmt.Errorf("Error")},
+			expectedError: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			sh := NewSmartHome(SetDynamoDBClient(tc.client), SetLogger(mockLogger{}))
+			err := sh.SetPassword("admin", tc.newPassword)
+			if tc.expectedError {
+				assert.Error(tt, err)
+				return
+			}
+			assert.NoError(tt, err)
+		})
+	}
+}
@@ -0,0 +1,743 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// ReadingSource is where temperature readings come from, each stored in its
+// own table
+type ReadingSource string
+
+const (
+	// SourceInside are the readings of the thermometers of the rooms,
+	// stored in the TemperatureInside table
+	SourceInside ReadingSource = "inside"
+
+	// SourceOutside are the readings of the outdoor temperature, stored in
+	// the TemperatureOutside table
+	SourceOutside ReadingSource = "outside"
+)
+
+const (
+	// PendingReadingsIndex is the sparse global secondary index of the
+	// readings tables with the raw readings not rolled up yet, and the
+	// 5-minute buckets whose hourly and daily buckets haven't been rolled up
+	// since they changed, by Time. Its hash key is the Pending attribute,
+	// which only those items have, so that rolling up doesn't scan the
+	// readings already rolled up.
+	PendingReadingsIndex = "PendingReadings"
+
+	// pendingReading is the value of the Pending attribute of the raw
+	// readings and 5-minute buckets pending to be rolled up
+	pendingReading = "pending"
+
+	// maxMergeAttempts is the number of times the readings of a 5-minute
+	// bucket are merged into it when the transaction is canceled
+	maxMergeAttempts = 3
+)
+
+// Resolution is the length of the buckets raw readings are aggregated into
+type Resolution string
+
+const (
+	// ResolutionRaw are the readings as they were stored
+	ResolutionRaw Resolution = "raw"
+
+	// ResolutionFiveMinutes aggregates readings in 5-minute buckets
+	ResolutionFiveMinutes Resolution = "5m"
+
+	// ResolutionHourly aggregates readings in hourly buckets
+	ResolutionHourly Resolution = "1h"
+
+	// ResolutionDaily aggregates readings in daily buckets, in UTC
+	ResolutionDaily Resolution = "1d"
+)
+
+// resolutions are the resolutions of the buckets, from the finest to the
+// coarsest, along with the longest range a query is answered with
+var resolutions = []struct {
+	Resolution
+	maxRange time.Duration
+}{
+	{ResolutionFiveMinutes, 2 * 24 * time.Hour},
+	{ResolutionHourly, 62 * 24 * time.Hour},
+	{ResolutionDaily, 10 * 366 * 24 * time.Hour},
+}
+
+// Duration returns the length of the buckets of the resolution
+func (r Resolution) Duration() time.Duration {
+	switch r {
+	case ResolutionFiveMinutes:
+		return 5 * time.Minute
+	case ResolutionHourly:
+		return time.Hour
+	case ResolutionDaily:
+		return 24 * time.Hour
+	}
+	return 0
+}
+
+// ReadingsRetention is how long readings are kept at each resolution. Raw
+// readings are only expired once they have been rolled up. A zero retention
+// keeps the readings forever.
+type ReadingsRetention struct {
+	Raw         time.Duration
+	FiveMinutes time.Duration
+	Hourly      time.Duration
+	Daily       time.Duration
+}
+
+// DefaultReadingsRetention keeps two days of raw readings, a month of 5-minute
+// buckets, two years of hourly buckets and the daily ones forever
+var DefaultReadingsRetention = ReadingsRetention{
+	Raw:         48 * time.Hour,
+	FiveMinutes: 31 * 24 * time.Hour,
+	Hourly:      2 * 366 * 24 * time.Hour,
+}
+
+// Of returns the retention of the readings of a resolution
+func (r ReadingsRetention) Of(resolution Resolution) time.Duration {
+	switch resolution {
+	case ResolutionRaw:
+		return r.Raw
+	case ResolutionFiveMinutes:
+		return r.FiveMinutes
+	case ResolutionHourly:
+		return r.Hourly
+	}
+	return r.Daily
+}
+
+// Validate returns an error unless raw readings are kept long enough to be
+// rolled up and no retention is negative
+func (r ReadingsRetention) Validate() error {
+	for _, resolution := range []Resolution{ResolutionRaw, ResolutionFiveMinutes, ResolutionHourly, ResolutionDaily} {
+		if r.Of(resolution) < 0 {
+			return NewValidationError("invalid retention %s of %s readings: it can't be negative", r.Of(resolution), resolution)
+		}
+	}
+	if r.Raw != 0 && r.Raw < time.Hour {
+		return NewValidationError("invalid retention %s of raw readings: it must be at least 1h", r.Raw)
+	}
+	return nil
+}
+
+// Reading is a temperature, in Celsius, measured at some time. Outside
+// readings may have no room.
+type Reading struct {
+	Room        string    `json:"room,omitempty"`
+	Time        time.Time `json:"time"`
+	Temperature float32   `json:"temperature"`
+}
+
+// ReadingBucket aggregates the readings of a room during some time
+type ReadingBucket struct {
+	Room  string    `json:"room,omitempty"`
+	Start time.Time `json:"start"`
+	Min   float32   `json:"min"`
+	Max   float32   `json:"max"`
+	Avg   float32   `json:"avg"`
+	Count int       `json:"count"`
+}
+
+// RollupResult summarises a run of the rollup of readings
+type RollupResult struct {
+	// Readings is the number of raw readings rolled up for the first time
+	Readings int
+
+	// Buckets is the number of buckets written, at any resolution
+	Buckets int
+}
+
+// readingsTable returns the table of the readings of a source
+func (s *SmartHome) readingsTable(source ReadingSource) (string, error) {
+	switch source {
+	case SourceInside:
+		return s.Config.TempInsideTable, nil
+	case SourceOutside:
+		return s.Config.TempOutsideTable, nil
+	}
+	return "", NewValidationError("invalid readings source %s. Valid sources: %s, %s", source, SourceInside, SourceOutside)
+}
+
+// readingKey returns the Date key of a reading, or of a bucket of readings
+// if the resolution isn't raw, e.g. bedroom#1h#2021-01-01T10:00:00Z. Buckets
+// have predictable keys, so that ranges of them are read without scanning.
+func readingKey(room string, resolution Resolution, t time.Time) string {
+	parts := []string{}
+	if room != "" {
+		parts = append(parts, room)
+	}
+	if resolution != ResolutionRaw {
+		parts = append(parts, string(resolution))
+	}
+	return strings.Join(append(parts, t.UTC().Format(time.RFC3339Nano)), "#")
+}
+
+// AddReading stores a raw temperature reading, pending to be rolled up. A
+// reading that has already been rolled up isn't stored again, so that it
+// isn't counted twice.
+func (s *SmartHome) AddReading(source ReadingSource, reading Reading) error {
+	table, err := s.readingsTable(source)
+	if err != nil {
+		return err
+	}
+	if reading.Time.IsZero() {
+		return NewValidationError("the time of the reading is required")
+	}
+	if source == SourceInside && reading.Room == "" {
+		return NewValidationError("the room of an inside reading is required")
+	}
+
+	item := map[string]types.AttributeValue{
+		"Date":        &types.AttributeValueMemberS{Value: readingKey(reading.Room, ResolutionRaw, reading.Time)},
+		"Time":        unixAttribute(reading.Time),
+		"Temperature": temperatureAttribute(reading.Temperature),
+		"Pending":     &types.AttributeValueMemberS{Value: pendingReading},
+	}
+	if reading.Room != "" {
+		item["Room"] = &types.AttributeValueMemberS{Value: reading.Room}
+	}
+	s.Debugw("storing reading", "source", source, "room", reading.Room, "time", reading.Time)
+	_, err = s.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName:           &table,
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(RolledUp)"),
+	})
+	var conditionErr *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionErr) {
+		s.Debugw("skipping reading already rolled up", "source", source, "room", reading.Room, "time", reading.Time)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error storing %s reading: %w", source, err)
+	}
+	return nil
+}
+
+// ReadingsResolution returns the resolution readings between from and to are
+// returned with: the finest one whose buckets aren't too many for the range
+// and are still kept at from
+func (s *SmartHome) ReadingsResolution(from, to, now time.Time) (Resolution, error) {
+	if !to.After(from) {
+		return "", NewValidationError("invalid range of readings: %s is not after %s", to.Format(time.RFC3339), from.Format(time.RFC3339))
+	}
+	for _, r := range resolutions {
+		retention := s.Config.Retention.Of(r.Resolution)
+		if to.Sub(from) <= r.maxRange && (retention == 0 || !from.Before(now.Add(-retention))) {
+			return r.Resolution, nil
+		}
+	}
+	return "", NewValidationError("invalid range of readings: it can't be longer than %d days nor start before the readings kept",
+		int(resolutions[len(resolutions)-1].maxRange.Hours()/24))
+}
+
+// GetReadings returns the buckets of readings of a room between from and to,
+// along with their resolution, which is chosen by ReadingsResolution. Only
+// readings that have been rolled up are returned.
+func (s *SmartHome) GetReadings(source ReadingSource, room string, from, to time.Time) (Resolution, []ReadingBucket, error) {
+	table, err := s.readingsTable(source)
+	if err != nil {
+		return "", nil, err
+	}
+	resolution, err := s.ReadingsResolution(from, to, time.Now())
+	if err != nil {
+		return "", nil, err
+	}
+
+	s.Debugw("getting readings", "source", source, "room", room, "from", from, "to", to, "resolution", resolution)
+	keys := []string{}
+	for start := from.UTC().Truncate(resolution.Duration()); start.Before(to); start = start.Add(resolution.Duration()) {
+		keys = append(keys, readingKey(room, resolution, start))
+	}
+	buckets, err := s.getBuckets(table, keys)
+	if err != nil {
+		return "", nil, err
+	}
+	return resolution, buckets, nil
+}
+
+// maxExportBuckets is the maximum number of buckets of readings of a room an
+// export can request, so that a range isn't exported at a finer resolution
+// than it makes sense to
+const maxExportBuckets = 100000
+
+// ExportReadings calls fn with the buckets of readings of a room between from
+// and to at a resolution, sorted by start. The buckets are got a batch at a
+// time, so that long ranges are exported without keeping them in memory. An
+// error returned by fn stops the export and is returned.
+func (s *SmartHome) ExportReadings(source ReadingSource, room string, resolution Resolution, from, to time.Time, fn func(ReadingBucket) error) error {
+	table, err := s.readingsTable(source)
+	if err != nil {
+		return err
+	}
+	if !to.After(from) {
+		return NewValidationError("invalid range of readings: %s is not after %s", to.Format(time.RFC3339), from.Format(time.RFC3339))
+	}
+	if resolution.Duration() == 0 {
+		return NewValidationError("invalid resolution of readings %s: use one of %s, %s or %s",
+			resolution, ResolutionFiveMinutes, ResolutionHourly, ResolutionDaily)
+	}
+	if to.Sub(from)/resolution.Duration() > maxExportBuckets {
+		return NewValidationError("invalid range of readings: it can't have more than %d buckets of %s", maxExportBuckets, resolution)
+	}
+
+	s.Debugw("exporting readings", "source", source, "room", room, "from", from, "to", to, "resolution", resolution)
+	start := from.UTC().Truncate(resolution.Duration())
+	for start.Before(to) {
+		keys := []string{}
+		for ; start.Before(to) && len(keys) < maxBatchGetItems; start = start.Add(resolution.Duration()) {
+			keys = append(keys, readingKey(room, resolution, start))
+		}
+		buckets, err := s.getBuckets(table, keys)
+		if err != nil {
+			return err
+		}
+		for _, bucket := range buckets {
+			if err := fn(bucket); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// RollupReadings aggregates the raw readings of a source into 5-minute,
+// hourly and daily buckets, and sets the expiration of the raw readings
+// rolled up. Only the readings pending to be rolled up are queried, from the
+// PendingReadingsIndex, and only those of 5-minute buckets that ended before
+// now. They are merged into their 5-minute buckets in the same transaction
+// that marks them as rolled up, so that rolling up is idempotent and late
+// readings are accounted for. The 5-minute buckets merged stay pending until
+// their hourly and daily buckets are rolled up, so that a run that fails
+// halfway is completed by the next one.
+func (s *SmartHome) RollupReadings(source ReadingSource, now time.Time) (*RollupResult, error) {
+	table, err := s.readingsTable(source)
+	if err != nil {
+		return nil, err
+	}
+
+	type bucketKey struct {
+		room  string
+		start time.Time
+	}
+	readings := map[bucketKey][]Reading{}
+	pending := map[bucketKey][]map[string]types.AttributeValue{}
+	// The 5-minute buckets whose hourly and daily buckets are rolled up,
+	// with their count
+	merged := map[bucketKey]int{}
+	input := &dynamodb.QueryInput{
+		TableName:                &table,
+		IndexName:                aws.String(PendingReadingsIndex),
+		KeyConditionExpression:   aws.String("Pending = :pending AND #time < :end"),
+		ExpressionAttributeNames: map[string]string{"#time": "Time"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pending": &types.AttributeValueMemberS{Value: pendingReading},
+			":end":     unixAttribute(now.UTC().Truncate(ResolutionFiveMinutes.Duration())),
+		},
+	}
+	for {
+		output, err := s.Query(context.TODO(), input)
+		if err != nil {
+			return nil, fmt.Errorf("error querying pending %s readings: %w", source, err)
+		}
+		for _, item := range output.Items {
+			if _, isBucket := item["Resolution"]; isBucket {
+				bucket, err := bucketFromItem(item)
+				if err != nil {
+					s.Errorw("skipping invalid bucket of readings", "source", source, "error", err.Error())
+					continue
+				}
+				merged[bucketKey{room: bucket.Room, start: bucket.Start}] = bucket.Count
+				continue
+			}
+			reading, err := readingFromItem(item)
+			if err != nil {
+				s.Errorw("skipping invalid reading", "source", source, "error", err.Error())
+				continue
+			}
+			key := bucketKey{room: reading.Room, start: reading.Time.UTC().Truncate(ResolutionFiveMinutes.Duration())}
+			readings[key] = append(readings[key], *reading)
+			pending[key] = append(pending[key], map[string]types.AttributeValue{"Date": item["Date"]})
+		}
+		if len(output.LastEvaluatedKey) == 0 {
+			break
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+
+	result := &RollupResult{}
+	for key, keys := range pending {
+		var bucket *ReadingBucket
+		// The bucket takes an item of every transaction
+		for start := 0; start < len(keys); start += maxTransactionItems - 1 {
+			end := start + maxTransactionItems - 1
+			if end > len(keys) {
+				end = len(keys)
+			}
+			mergedBucket, count, err := s.mergeReadings(table, key.room, key.start, readings[key][start:end], keys[start:end])
+			if err != nil {
+				return result, err
+			}
+			if mergedBucket != nil {
+				bucket = mergedBucket
+			}
+			result.Readings += count
+		}
+		if bucket != nil {
+			result.Buckets++
+			merged[key] = bucket.Count
+		}
+	}
+
+	hours := map[bucketKey]bool{}
+	for key := range merged {
+		hours[bucketKey{room: key.room, start: key.start.Truncate(time.Hour)}] = true
+	}
+	days := map[bucketKey]bool{}
+	for key := range hours {
+		if err := s.rollupBucket(table, key.room, ResolutionHourly, ResolutionFiveMinutes, key.start); err != nil {
+			return result, err
+		}
+		result.Buckets++
+		days[bucketKey{room: key.room, start: key.start.Truncate(24 * time.Hour)}] = true
+	}
+	for key := range days {
+		if err := s.rollupBucket(table, key.room, ResolutionDaily, ResolutionHourly, key.start); err != nil {
+			return result, err
+		}
+		result.Buckets++
+	}
+	for key, count := range merged {
+		if err := s.settleBucket(table, key.room, key.start, count); err != nil {
+			return result, err
+		}
+	}
+
+	s.Debugw("rolled up readings", "source", source, "readings", result.Readings, "buckets", result.Buckets)
+	return result, nil
+}
+
+// pendingReadings returns the raw readings of a source between from and to
+// that haven't been rolled up yet, from the PendingReadingsIndex
+func (s *SmartHome) pendingReadings(source ReadingSource, from, to time.Time) ([]Reading, error) {
+	table, err := s.readingsTable(source)
+	if err != nil {
+		return nil, err
+	}
+	input := &dynamodb.QueryInput{
+		TableName:                &table,
+		IndexName:                aws.String(PendingReadingsIndex),
+		KeyConditionExpression:   aws.String("Pending = :pending AND #time BETWEEN :from AND :to"),
+		ExpressionAttributeNames: map[string]string{"#time": "Time"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pending": &types.AttributeValueMemberS{Value: pendingReading},
+			":from":    unixAttribute(from),
+			":to":      unixAttribute(to),
+		},
+	}
+	readings := []Reading{}
+	for {
+		output, err := s.Query(context.TODO(), input)
+		if err != nil {
+			return nil, fmt.Errorf("error querying pending %s readings: %w", source, err)
+		}
+		for _, item := range output.Items {
+			// The 5-minute buckets pending are already rolled up
+			if _, isBucket := item["Resolution"]; isBucket {
+				continue
+			}
+			reading, err := readingFromItem(item)
+			if err != nil {
+				s.Errorw("skipping invalid reading", "source", source, "error", err.Error())
+				continue
+			}
+			readings = append(readings, *reading)
+		}
+		if len(output.LastEvaluatedKey) == 0 {
+			break
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+	return readings, nil
+}
+
+// mergeReadings merges raw readings into their 5-minute bucket and marks them
+// as rolled up, setting their DynamoDB TTL unless raw readings are kept
+// forever, in a single transaction. The bucket is only written if it hasn't
+// changed since it was read, and the readings only if they are still
+// pending, so that concurrent rollups don't count a reading twice. Readings
+// that aren't pending anymore were already merged, as the index may return
+// them for a while after, so they are left out and the rest are merged
+// again. It returns the bucket, or nil if every reading was already merged,
+// and the number of readings merged.
+func (s *SmartHome) mergeReadings(table, room string, start time.Time, readings []Reading, keys []map[string]types.AttributeValue) (*ReadingBucket, int, error) {
+	update := "SET RolledUp = :rolled_up"
+	values := map[string]types.AttributeValue{":rolled_up": &types.AttributeValueMemberBOOL{Value: true}}
+	if s.Config.Retention.Raw != 0 {
+		update += ", ExpiresAt = :expires_at"
+		values[":expires_at"] = unixAttribute(start.Add(s.Config.Retention.Raw))
+	}
+	update += " REMOVE Pending"
+
+	for attempt := 1; ; attempt++ {
+		if len(readings) == 0 {
+			return nil, 0, nil
+		}
+		existing, err := s.getBuckets(table, []string{readingKey(room, ResolutionFiveMinutes, start)})
+		if err != nil {
+			return nil, 0, err
+		}
+		bucket := aggregateReadings(room, start, readings)
+		put := &types.Put{TableName: &table, ConditionExpression: aws.String("attribute_not_exists(#count)")}
+		put.ExpressionAttributeNames = map[string]string{"#count": "Count"}
+		if len(existing) > 0 {
+			bucket = mergeBuckets(room, start, append(existing, bucket))
+			put.ConditionExpression = aws.String("#count = :count")
+			put.ExpressionAttributeValues = map[string]types.AttributeValue{
+				":count": &types.AttributeValueMemberN{Value: strconv.Itoa(existing[0].Count)},
+			}
+		}
+		// The bucket is pending until its hourly and daily buckets are rolled up
+		put.Item = s.bucketItem(ResolutionFiveMinutes, bucket)
+		put.Item["Pending"] = &types.AttributeValueMemberS{Value: pendingReading}
+
+		items := []types.TransactWriteItem{{Put: put}}
+		for _, key := range keys {
+			items = append(items, types.TransactWriteItem{Update: &types.Update{
+				TableName:                 &table,
+				Key:                       key,
+				UpdateExpression:          &update,
+				ConditionExpression:       aws.String("attribute_exists(Pending)"),
+				ExpressionAttributeValues: values,
+			}})
+		}
+		_, err = s.TransactWriteItems(context.TODO(), &dynamodb.TransactWriteItemsInput{TransactItems: items})
+		var canceledErr *types.TransactionCanceledException
+		if errors.As(err, &canceledErr) && attempt < maxMergeAttempts {
+			remainingReadings, remainingKeys := []Reading{}, []map[string]types.AttributeValue{}
+			for i := range keys {
+				if reason := canceledReason(canceledErr, i+1); reason == "ConditionalCheckFailed" {
+					s.Debugw("skipping reading already rolled up", "room", room, "time", readings[i].Time)
+					continue
+				}
+				remainingReadings = append(remainingReadings, readings[i])
+				remainingKeys = append(remainingKeys, keys[i])
+			}
+			readings, keys = remainingReadings, remainingKeys
+			continue
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("error rolling up %d readings of the 5m bucket %s: %w", len(keys), readingKey(room, ResolutionFiveMinutes, start), err)
+		}
+		return &bucket, len(readings), nil
+	}
+}
+
+// canceledReason returns the code of the reason an item of a canceled
+// transaction was canceled for, if any
+func canceledReason(err *types.TransactionCanceledException, i int) string {
+	if i >= len(err.CancellationReasons) || err.CancellationReasons[i].Code == nil {
+		return ""
+	}
+	return *err.CancellationReasons[i].Code
+}
+
+// settleBucket marks a 5-minute bucket whose hourly and daily buckets have
+// been rolled up as not pending anymore, unless it has changed since
+func (s *SmartHome) settleBucket(table, room string, start time.Time, count int) error {
+	_, err := s.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName: &table,
+		Key: map[string]types.AttributeValue{
+			"Date": &types.AttributeValueMemberS{Value: readingKey(room, ResolutionFiveMinutes, start)},
+		},
+		UpdateExpression:         aws.String("REMOVE Pending"),
+		ConditionExpression:      aws.String("#count = :count"),
+		ExpressionAttributeNames: map[string]string{"#count": "Count"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":count": &types.AttributeValueMemberN{Value: strconv.Itoa(count)},
+		},
+	})
+	var conditionErr *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionErr) {
+		s.Debugw("5m bucket changed while rolling it up, keeping it pending", "room", room, "start", start)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error settling the 5m bucket %s: %w", readingKey(room, ResolutionFiveMinutes, start), err)
+	}
+	return nil
+}
+
+// rollupBucket computes a bucket from the finer buckets it spans
+func (s *SmartHome) rollupBucket(table, room string, resolution, from Resolution, start time.Time) error {
+	keys := []string{}
+	for t := start; t.Before(start.Add(resolution.Duration())); t = t.Add(from.Duration()) {
+		keys = append(keys, readingKey(room, from, t))
+	}
+	buckets, err := s.getBuckets(table, keys)
+	if err != nil {
+		return err
+	}
+	if len(buckets) == 0 {
+		return nil
+	}
+	return s.putBucket(table, resolution, mergeBuckets(room, start, buckets))
+}
+
+// putBucket stores a bucket
+func (s *SmartHome) putBucket(table string, resolution Resolution, bucket ReadingBucket) error {
+	if _, err := s.PutItem(context.TODO(), &dynamodb.PutItemInput{TableName: &table, Item: s.bucketItem(resolution, bucket)}); err != nil {
+		return fmt.Errorf("error storing %s bucket of readings: %w", resolution, err)
+	}
+	return nil
+}
+
+// bucketItem returns the item of a bucket, which expires after the retention
+// of its resolution counting from its end
+func (s *SmartHome) bucketItem(resolution Resolution, bucket ReadingBucket) map[string]types.AttributeValue {
+	item := map[string]types.AttributeValue{
+		"Date":       &types.AttributeValueMemberS{Value: readingKey(bucket.Room, resolution, bucket.Start)},
+		"Resolution": &types.AttributeValueMemberS{Value: string(resolution)},
+		"Time":       unixAttribute(bucket.Start),
+		"Min":        temperatureAttribute(bucket.Min),
+		"Max":        temperatureAttribute(bucket.Max),
+		"Avg":        temperatureAttribute(bucket.Avg),
+		"Count":      &types.AttributeValueMemberN{Value: strconv.Itoa(bucket.Count)},
+	}
+	if bucket.Room != "" {
+		item["Room"] = &types.AttributeValueMemberS{Value: bucket.Room}
+	}
+	if retention := s.Config.Retention.Of(resolution); retention != 0 {
+		item["ExpiresAt"] = unixAttribute(bucket.Start.Add(resolution.Duration() + retention))
+	}
+	return item
+}
+
+// getBuckets returns the buckets with the keys that exist, sorted by start
+func (s *SmartHome) getBuckets(table string, keys []string) ([]ReadingBucket, error) {
+	buckets := []ReadingBucket{}
+	for start := 0; start < len(keys); start += maxBatchGetItems {
+		end := start + maxBatchGetItems
+		if end > len(keys) {
+			end = len(keys)
+		}
+		batch := make([]map[string]types.AttributeValue, 0, end-start)
+		for _, key := range keys[start:end] {
+			batch = append(batch, map[string]types.AttributeValue{"Date": &types.AttributeValueMemberS{Value: key}})
+		}
+		request := map[string]types.KeysAndAttributes{table: {Keys: batch}}
+		for attempt := 0; len(request) > 0; attempt++ {
+			if attempt == maxBatchGetAttempts {
+				return nil, fmt.Errorf("error getting readings: keys still unprocessed after %d attempts", attempt)
+			}
+			if attempt > 0 {
+				time.Sleep(time.Duration(1<<uint(attempt)) * 10 * time.Millisecond)
+			}
+			output, err := s.BatchGetItem(context.TODO(), &dynamodb.BatchGetItemInput{RequestItems: request})
+			if err != nil {
+				return nil, fmt.Errorf("error getting readings: %w", err)
+			}
+			for _, item := range output.Responses[table] {
+				bucket, err := bucketFromItem(item)
+				if err != nil {
+					return nil, err
+				}
+				buckets = append(buckets, *bucket)
+			}
+			request = output.UnprocessedKeys
+		}
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Start.Before(buckets[j].Start) })
+	return buckets, nil
+}
+
+// aggregateReadings returns the bucket starting at start with the readings
+func aggregateReadings(room string, start time.Time, readings []Reading) ReadingBucket {
+	bucket := ReadingBucket{Room: room, Start: start, Min: float32(math.Inf(1)), Max: float32(math.Inf(-1))}
+	var sum float64
+	for _, r := range readings {
+		bucket.Min = float32(math.Min(float64(bucket.Min), float64(r.Temperature)))
+		bucket.Max = float32(math.Max(float64(bucket.Max), float64(r.Temperature)))
+		sum += float64(r.Temperature)
+		bucket.Count++
+	}
+	bucket.Avg = float32(sum / float64(bucket.Count))
+	return bucket
+}
+
+// mergeBuckets returns the bucket starting at start with the readings of
+// some finer buckets, weighting their averages by their counts
+func mergeBuckets(room string, start time.Time, buckets []ReadingBucket) ReadingBucket {
+	merged := ReadingBucket{Room: room, Start: start, Min: float32(math.Inf(1)), Max: float32(math.Inf(-1))}
+	var sum float64
+	for _, b := range buckets {
+		merged.Min = float32(math.Min(float64(merged.Min), float64(b.Min)))
+		merged.Max = float32(math.Max(float64(merged.Max), float64(b.Max)))
+		sum += float64(b.Avg) * float64(b.Count)
+		merged.Count += b.Count
+	}
+	merged.Avg = float32(sum / float64(merged.Count))
+	return merged
+}
+
+func readingFromItem(item map[string]types.AttributeValue) (*Reading, error) {
+	t := timeAttribute(item, "Time")
+	if t.IsZero() {
+		return nil, fmt.Errorf("missing time of reading")
+	}
+	temperature, err := temperatureFromAttribute(item["Temperature"])
+	if err != nil {
+		return nil, err
+	}
+	reading := &Reading{Time: t, Temperature: temperature}
+	if room, ok := item["Room"].(*types.AttributeValueMemberS); ok {
+		reading.Room = room.Value
+	}
+	return reading, nil
+}
+
+func bucketFromItem(item map[string]types.AttributeValue) (*ReadingBucket, error) {
+	bucket := &ReadingBucket{Start: timeAttribute(item, "Time"), Count: int(numberAttribute(item, "Count"))}
+	if bucket.Start.IsZero() {
+		return nil, fmt.Errorf("missing start of bucket of readings")
+	}
+	for attribute, value := range map[string]*float32{"Min": &bucket.Min, "Max": &bucket.Max, "Avg": &bucket.Avg} {
+		t, err := temperatureFromAttribute(item[attribute])
+		if err != nil {
+			return nil, err
+		}
+		*value = t
+	}
+	if room, ok := item["Room"].(*types.AttributeValueMemberS); ok {
+		bucket.Room = room.Value
+	}
+	return bucket, nil
+}
+
+func temperatureAttribute(t float32) *types.AttributeValueMemberN {
+	return &types.AttributeValueMemberN{Value: strconv.FormatFloat(float64(t), 'f', -1, 32)}
+}
+
+func temperatureFromAttribute(attribute types.AttributeValue) (float32, error) {
+	n, ok := attribute.(*types.AttributeValueMemberN)
+	if !ok {
+		return 0, fmt.Errorf("missing temperature of reading")
+	}
+	t, err := strconv.ParseFloat(n.Value, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid temperature of reading %s: %w", n.Value, err)
+	}
+	return float32(t), nil
+}
@@ -0,0 +1,336 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/igvaquero18/smarthome/utils/dynamotest"
+	"github.com/stretchr/testify/assert"
+)
+
+// newReadingsClient returns a client with the readings tables of the keys,
+// and their index of pending readings
+func newReadingsClient(keys map[string]string) *dynamotest.Client {
+	client := dynamotest.NewClient(keys)
+	for _, table := range []string{DefaultTempInsideTable, DefaultTempOutsideTable} {
+		client.AddIndex(table, PendingReadingsIndex, "Pending", "Time")
+	}
+	return client
+}
+
+func newReadingsSmartHome() (*SmartHome, *dynamotest.Client) {
+	client := newReadingsClient(map[string]string{DefaultTempInsideTable: "Date", DefaultTempOutsideTable: "Date"})
+	return NewSmartHome(
+		SetDynamoDBClient(client),
+		SetLogger(mockLogger{}),
+		SetConfig(&SmartHomeConfig{}),
+	), client
+}
+
+func TestReadingKey(t *testing.T) {
+	at := time.Date(2021, 1, 1, 11, 0, 30, 0, time.FixedZone("CET", 3600))
+	assert.Equal(t, "bedroom#2021-01-01T10:00:30Z", readingKey("bedroom", ResolutionRaw, at))
+	assert.Equal(t, "bedroom#1h#2021-01-01T10:00:30Z", readingKey("bedroom", ResolutionHourly, at))
+	assert.Equal(t, "1d#2021-01-01T10:00:30Z", readingKey("", ResolutionDaily, at))
+}
+
+func TestReadingsResolution(t *testing.T) {
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		name     string
+		from     time.Time
+		to       time.Time
+		expected Resolution
+		err      error
+	}{
+		{name: "Last hours", from: now.Add(-6 * time.Hour), to: now, expected: ResolutionFiveMinutes},
+		{name: "Last two days", from: now.Add(-48 * time.Hour), to: now, expected: ResolutionFiveMinutes},
+		{name: "Last week", from: now.Add(-7 * 24 * time.Hour), to: now, expected: ResolutionHourly},
+		{name: "Some hours of last year", from: now.AddDate(-1, 0, 0), to: now.AddDate(-1, 0, 0).Add(time.Hour), expected: ResolutionHourly},
+		{name: "Some hours of three years ago", from: now.AddDate(-3, 0, 0), to: now.AddDate(-3, 0, 0).Add(time.Hour), expected: ResolutionDaily},
+		{name: "Last year", from: now.AddDate(-1, 0, 0), to: now, expected: ResolutionDaily},
+		{name: "Too long", from: now.AddDate(-20, 0, 0), to: now, err: ErrValidation},
+		{name: "Inverted", from: now, to: now.Add(-time.Hour), err: ErrValidation},
+	}
+
+	sh, _ := newReadingsSmartHome()
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			resolution, err := sh.ReadingsResolution(tc.from, tc.to, now)
+			if tc.err != nil {
+				assert.True(tt, errors.Is(err, tc.err))
+				return
+			}
+			assert.NoError(tt, err)
+			assert.Equal(tt, tc.expected, resolution)
+		})
+	}
+}
+
+func TestReadingsRetentionValidate(t *testing.T) {
+	assert.NoError(t, DefaultReadingsRetention.Validate())
+	assert.NoError(t, ReadingsRetention{}.Validate())
+	assert.True(t, errors.Is(ReadingsRetention{Raw: time.Minute}.Validate(), ErrValidation))
+	assert.True(t, errors.Is(ReadingsRetention{Hourly: -time.Hour}.Validate(), ErrValidation))
+}
+
+func TestAddReading(t *testing.T) {
+	sh, client := newReadingsSmartHome()
+	at := time.Date(2021, 1, 1, 10, 0, 30, 0, time.UTC)
+
+	assert.NoError(t, sh.AddReading(SourceInside, Reading{Room: "bedroom", Time: at, Temperature: 20.5}))
+	assert.NoError(t, sh.AddReading(SourceOutside, Reading{Time: at, Temperature: -2}))
+	assert.True(t, errors.Is(sh.AddReading(SourceInside, Reading{Time: at, Temperature: 20}), ErrValidation))
+	assert.True(t, errors.Is(sh.AddReading(SourceInside, Reading{Room: "bedroom", Temperature: 20}), ErrValidation))
+	assert.True(t, errors.Is(sh.AddReading("attic", Reading{Room: "bedroom", Time: at}), ErrValidation))
+
+	inside := client.Items(DefaultTempInsideTable)
+	if assert.Len(t, inside, 1) {
+		assert.Equal(t, &types.AttributeValueMemberS{Value: "bedroom#2021-01-01T10:00:30Z"}, inside[0]["Date"])
+		assert.Equal(t, &types.AttributeValueMemberN{Value: "20.5"}, inside[0]["Temperature"])
+		assert.Equal(t, &types.AttributeValueMemberS{Value: pendingReading}, inside[0]["Pending"])
+	}
+	outside := client.Items(DefaultTempOutsideTable)
+	if assert.Len(t, outside, 1) {
+		assert.Equal(t, &types.AttributeValueMemberS{Value: "2021-01-01T10:00:30Z"}, outside[0]["Date"])
+		assert.NotContains(t, outside[0], "Room")
+	}
+}
+
+func TestRollupReadings(t *testing.T) {
+	sh, client := newReadingsSmartHome()
+	start := time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC)
+	for i, temperature := range []float32{19, 20, 21, 22} {
+		// Two readings in each of the first two 5-minute buckets
+		assert.NoError(t, sh.AddReading(SourceInside, Reading{Room: "bedroom", Time: start.Add(time.Duration(i) * 150 * time.Second), Temperature: temperature}))
+	}
+	// A reading of a bucket that hasn't ended yet
+	assert.NoError(t, sh.AddReading(SourceInside, Reading{Room: "bedroom", Time: start.Add(11 * time.Minute), Temperature: 25}))
+	now := start.Add(12 * time.Minute)
+
+	result, err := sh.RollupReadings(SourceInside, now)
+	assert.NoError(t, err)
+	assert.Equal(t, &RollupResult{Readings: 4, Buckets: 4}, result)
+
+	buckets, err := sh.getBuckets(DefaultTempInsideTable, []string{
+		readingKey("bedroom", ResolutionFiveMinutes, start),
+		readingKey("bedroom", ResolutionFiveMinutes, start.Add(5*time.Minute)),
+		readingKey("bedroom", ResolutionFiveMinutes, start.Add(10*time.Minute)),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []ReadingBucket{
+		{Room: "bedroom", Start: start, Min: 19, Max: 20, Avg: 19.5, Count: 2},
+		{Room: "bedroom", Start: start.Add(5 * time.Minute), Min: 21, Max: 22, Avg: 21.5, Count: 2},
+	}, buckets)
+
+	buckets, err = sh.getBuckets(DefaultTempInsideTable, []string{
+		readingKey("bedroom", ResolutionHourly, start),
+		readingKey("bedroom", ResolutionDaily, start.Truncate(24*time.Hour)),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []ReadingBucket{
+		{Room: "bedroom", Start: start.Truncate(24 * time.Hour), Min: 19, Max: 22, Avg: 20.5, Count: 4},
+		{Room: "bedroom", Start: start, Min: 19, Max: 22, Avg: 20.5, Count: 4},
+	}, buckets)
+
+	// Raw readings rolled up expire after the retention
+	for _, item := range client.Items(DefaultTempInsideTable) {
+		if _, isBucket := item["Resolution"]; isBucket {
+			continue
+		}
+		if timeAttribute(item, "Time").Before(start.Add(10 * time.Minute)) {
+			assert.Equal(t, &types.AttributeValueMemberBOOL{Value: true}, item["RolledUp"])
+			assert.False(t, timeAttribute(item, "ExpiresAt").IsZero())
+		} else {
+			assert.NotContains(t, item, "ExpiresAt")
+		}
+	}
+
+	// Rolling up again doesn't change anything
+	result, err = sh.RollupReadings(SourceInside, now)
+	assert.NoError(t, err)
+	assert.Equal(t, &RollupResult{}, result)
+
+	// A late reading rolls up its buckets again
+	assert.NoError(t, sh.AddReading(SourceInside, Reading{Room: "bedroom", Time: start.Add(time.Minute), Temperature: 15}))
+	result, err = sh.RollupReadings(SourceInside, now)
+	assert.NoError(t, err)
+	assert.Equal(t, &RollupResult{Readings: 1, Buckets: 3}, result)
+	buckets, err = sh.getBuckets(DefaultTempInsideTable, []string{readingKey("bedroom", ResolutionHourly, start)})
+	assert.NoError(t, err)
+	assert.Equal(t, []ReadingBucket{{Room: "bedroom", Start: start, Min: 15, Max: 22, Avg: 19.4, Count: 5}}, buckets)
+
+	// A reading sent again once rolled up isn't counted twice
+	assert.NoError(t, sh.AddReading(SourceInside, Reading{Room: "bedroom", Time: start, Temperature: 19}))
+	result, err = sh.RollupReadings(SourceInside, now)
+	assert.NoError(t, err)
+	assert.Equal(t, &RollupResult{}, result)
+}
+
+func TestRollupReadingsTransactions(t *testing.T) {
+	sh, _ := newReadingsSmartHome()
+	start := time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC)
+	// More readings in a 5-minute bucket than fit in a transaction
+	for i := 0; i < 250; i++ {
+		assert.NoError(t, sh.AddReading(SourceInside, Reading{Room: "bedroom", Time: start.Add(time.Duration(i) * time.Second), Temperature: float32(i % 2)}))
+	}
+
+	result, err := sh.RollupReadings(SourceInside, start.Add(5*time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, &RollupResult{Readings: 250, Buckets: 3}, result)
+	buckets, err := sh.getBuckets(DefaultTempInsideTable, []string{readingKey("bedroom", ResolutionFiveMinutes, start)})
+	assert.NoError(t, err)
+	assert.Equal(t, []ReadingBucket{{Room: "bedroom", Start: start, Min: 0, Max: 1, Avg: 0.5, Count: 250}}, buckets)
+}
+
+// flakyReadingsClient fails the first puts of hourly buckets, and returns
+// some stale items from the index of pending readings along with the rest,
+// as eventually consistent reads may
+type flakyReadingsClient struct {
+	*dynamotest.Client
+	failedPuts int
+	stale      []map[string]types.AttributeValue
+}
+
+func (c *flakyReadingsClient) PutItem(ctx context.Context, input *dynamodb.PutItemInput, opts ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	if resolution, ok := input.Item["Resolution"].(*types.AttributeValueMemberS); ok && resolution.Value == string(ResolutionHourly) && c.failedPuts > 0 {
+		c.failedPuts--
+		return nil, fmt.Errorf("throttled")
+	}
+	return c.Client.PutItem(ctx, input, opts...)
+}
+
+func (c *flakyReadingsClient) Query(ctx context.Context, input *dynamodb.QueryInput, opts ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	output, err := c.Client.Query(ctx, input, opts...)
+	if err == nil && input.IndexName != nil {
+		output.Items = append(output.Items, c.stale...)
+	}
+	return output, err
+}
+
+func TestRollupReadingsInterrupted(t *testing.T) {
+	client := &flakyReadingsClient{Client: newReadingsClient(map[string]string{DefaultTempInsideTable: "Date"}), failedPuts: 1}
+	sh := NewSmartHome(SetDynamoDBClient(client), SetLogger(mockLogger{}), SetConfig(&SmartHomeConfig{}))
+	start := time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC)
+	assert.NoError(t, sh.AddReading(SourceInside, Reading{Room: "bedroom", Time: start, Temperature: 19}))
+	now := start.Add(5 * time.Minute)
+
+	// The 5-minute bucket is merged, but not its hourly and daily buckets
+	_, err := sh.RollupReadings(SourceInside, now)
+	assert.Error(t, err)
+	buckets, err := sh.getBuckets(DefaultTempInsideTable, []string{readingKey("bedroom", ResolutionHourly, start)})
+	assert.NoError(t, err)
+	assert.Empty(t, buckets)
+
+	// The next run rolls them up without new readings
+	result, err := sh.RollupReadings(SourceInside, now)
+	assert.NoError(t, err)
+	assert.Equal(t, &RollupResult{Buckets: 2}, result)
+	buckets, err = sh.getBuckets(DefaultTempInsideTable, []string{
+		readingKey("bedroom", ResolutionHourly, start),
+		readingKey("bedroom", ResolutionDaily, start.Truncate(24*time.Hour)),
+	})
+	assert.NoError(t, err)
+	assert.Len(t, buckets, 2)
+
+	// And then nothing is pending anymore
+	result, err = sh.RollupReadings(SourceInside, now)
+	assert.NoError(t, err)
+	assert.Equal(t, &RollupResult{}, result)
+}
+
+func TestRollupReadingsStaleIndex(t *testing.T) {
+	client := &flakyReadingsClient{Client: newReadingsClient(map[string]string{DefaultTempInsideTable: "Date"})}
+	sh := NewSmartHome(SetDynamoDBClient(client), SetLogger(mockLogger{}), SetConfig(&SmartHomeConfig{}))
+	start := time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC)
+	now := start.Add(5 * time.Minute)
+	assert.NoError(t, sh.AddReading(SourceInside, Reading{Room: "bedroom", Time: start, Temperature: 19}))
+	stale := client.Items(DefaultTempInsideTable)
+	_, err := sh.RollupReadings(SourceInside, now)
+	assert.NoError(t, err)
+	client.stale = stale
+
+	// The index still returns the reading already rolled up, which is skipped
+	assert.NoError(t, sh.AddReading(SourceInside, Reading{Room: "bedroom", Time: start.Add(time.Minute), Temperature: 21}))
+	result, err := sh.RollupReadings(SourceInside, now)
+	assert.NoError(t, err)
+	assert.Equal(t, &RollupResult{Readings: 1, Buckets: 3}, result)
+	buckets, err := sh.getBuckets(DefaultTempInsideTable, []string{readingKey("bedroom", ResolutionFiveMinutes, start)})
+	assert.NoError(t, err)
+	assert.Equal(t, []ReadingBucket{{Room: "bedroom", Start: start, Min: 19, Max: 21, Avg: 20, Count: 2}}, buckets)
+}
+
+func TestGetReadings(t *testing.T) {
+	sh, _ := newReadingsSmartHome()
+	start := time.Now().UTC().Truncate(time.Hour).Add(-2 * time.Hour)
+	for i := 0; i < 12; i++ {
+		assert.NoError(t, sh.AddReading(SourceOutside, Reading{Time: start.Add(time.Duration(i) * 10 * time.Minute), Temperature: float32(i)}))
+	}
+	_, err := sh.RollupReadings(SourceOutside, start.Add(2*time.Hour))
+	assert.NoError(t, err)
+
+	resolution, buckets, err := sh.GetReadings(SourceOutside, "", start, start.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, ResolutionFiveMinutes, resolution)
+	assert.Len(t, buckets, 6)
+	assert.Equal(t, ReadingBucket{Start: start, Min: 0, Max: 0, Avg: 0, Count: 1}, buckets[0])
+
+	resolution, buckets, err = sh.GetReadings(SourceOutside, "", start.AddDate(0, 0, -7), start.Add(2*time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, ResolutionHourly, resolution)
+	assert.Equal(t, []ReadingBucket{
+		{Start: start, Min: 0, Max: 5, Avg: 2.5, Count: 6},
+		{Start: start.Add(time.Hour), Min: 6, Max: 11, Avg: 8.5, Count: 6},
+	}, buckets)
+
+	_, buckets, err = sh.GetReadings(SourceInside, "bedroom", start, start.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Empty(t, buckets)
+
+	_, _, err = sh.GetReadings(SourceOutside, "", start, start)
+	assert.True(t, errors.Is(err, ErrValidation))
+}
+
+func TestExportReadings(t *testing.T) {
+	sh, _ := newReadingsSmartHome()
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 300; i++ {
+		assert.NoError(t, sh.AddReading(SourceInside, Reading{Room: "bedroom", Time: start.Add(time.Duration(i) * 5 * time.Minute), Temperature: 20}))
+	}
+	_, err := sh.RollupReadings(SourceInside, start.AddDate(0, 0, 2))
+	assert.NoError(t, err)
+
+	// More buckets than a batch of keys are exported in order
+	buckets := []ReadingBucket{}
+	err = sh.ExportReadings(SourceInside, "bedroom", ResolutionFiveMinutes, start, start.AddDate(0, 0, 1), func(b ReadingBucket) error {
+		buckets = append(buckets, b)
+		return nil
+	})
+	assert.NoError(t, err)
+	if assert.Len(t, buckets, 288) {
+		assert.Equal(t, ReadingBucket{Room: "bedroom", Start: start, Min: 20, Max: 20, Avg: 20, Count: 1}, buckets[0])
+		for i := 1; i < len(buckets); i++ {
+			assert.True(t, buckets[i].Start.After(buckets[i-1].Start))
+		}
+	}
+
+	// An error of the callback stops the export
+	stop := errors.New("stop")
+	calls := 0
+	err = sh.ExportReadings(SourceInside, "bedroom", ResolutionHourly, start, start.AddDate(0, 0, 1), func(b ReadingBucket) error {
+		calls++
+		return stop
+	})
+	assert.True(t, errors.Is(err, stop))
+	assert.Equal(t, 1, calls)
+
+	noop := func(ReadingBucket) error { return nil }
+	assert.True(t, errors.Is(sh.ExportReadings(SourceInside, "bedroom", ResolutionRaw, start, start.Add(time.Hour), noop), ErrValidation))
+	assert.True(t, errors.Is(sh.ExportReadings(SourceInside, "bedroom", ResolutionFiveMinutes, start, start.AddDate(2, 0, 0), noop), ErrValidation))
+	assert.True(t, errors.Is(sh.ExportReadings(SourceInside, "bedroom", ResolutionHourly, start, start, noop), ErrValidation))
+}
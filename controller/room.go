@@ -2,34 +2,80 @@ package controller
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 )
 
+// Every write of the options of a room increments their version, stored in
+// the Version attribute, and can be made conditional on it so that writers
+// don't overwrite changes they haven't seen. The version parameter of the
+// writes is either a version returned by RoomVersion or one of these values.
+const (
+	// AnyVersion makes a write unconditional
+	AnyVersion int64 = 0
+
+	// ExistingVersion makes a write conditional on the room existing,
+	// whatever its version
+	ExistingVersion int64 = -1
+)
+
+// RoomVersion returns the version of the options of a room, or 0 if they
+// don't have any because they were written before versions were introduced
+func RoomVersion(item map[string]types.AttributeValue) int64 {
+	n, ok := item["Version"].(*types.AttributeValueMemberN)
+	if !ok {
+		return 0
+	}
+	version, _ := strconv.ParseInt(n.Value, 10, 64)
+	return version
+}
+
 // SetRoomOptions can enable or disable automating temperature
-// adjust for a particular room or the whole home.
-func (s *SmartHome) SetRoomOptions(room string, enabled bool, thresholdOn, thresholdOff float32) error {
+// adjust for a particular room or the whole home. It returns the new
+// version of the options of the room.
+func (s *SmartHome) SetRoomOptions(room string, enabled bool, thresholdOn, thresholdOff float32, version int64) (int64, error) {
 	s.Debugw("saving item in DynamoDB",
 		"room", room,
 		"enabled", enabled,
 		"threshold_on", thresholdOn,
 		"threshold_off", thresholdOff,
+		"version", version,
 	)
 
-	_, err := s.PutItem(context.TODO(), &dynamodb.PutItemInput{
-		TableName: &s.Config.ControlPlaneTable,
-		Item: map[string]types.AttributeValue{
-			"Room":         &types.AttributeValueMemberS{Value: room},
-			"Enabled":      &types.AttributeValueMemberBOOL{Value: enabled},
-			"ThresholdOn":  &types.AttributeValueMemberN{Value: fmt.Sprintf("%.1f", thresholdOn)},
-			"ThresholdOff": &types.AttributeValueMemberN{Value: fmt.Sprintf("%.1f", thresholdOff)},
-		},
-	})
+	update, condition, values, err := s.roomUpdate(room, s.roundThresholds(RoomOptionsPatch{
+		Enabled:      &enabled,
+		ThresholdOn:  &thresholdOn,
+		ThresholdOff: &thresholdOff,
+	}), version, false)
+	if err != nil {
+		return 0, err
+	}
+	input := &dynamodb.UpdateItemInput{
+		TableName:                 &s.Config.ControlPlaneTable,
+		Key:                       roomKey(room),
+		UpdateExpression:          aws.String(update),
+		ExpressionAttributeValues: values,
+		ReturnValues:              types.ReturnValueAllNew,
+	}
+	if condition != "" {
+		input.ConditionExpression = aws.String(condition)
+	}
 
+	output, err := s.UpdateItem(context.TODO(), input)
+	var conditionErr *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionErr) {
+		return 0, s.roomConditionError(room, RoomOptionsPatch{}, version, false)
+	}
 	if err != nil {
-		return fmt.Errorf(
+		return 0, fmt.Errorf(
 			"error setting room %s with values Enabled=%t, ThresholdOn=%.1f, ThresholdOff=%.1f in DynamoDB: %w",
 			room,
 			enabled,
@@ -44,9 +90,11 @@ func (s *SmartHome) SetRoomOptions(room string, enabled bool, thresholdOn, thres
 		"enabled", enabled,
 		"threshold_on", thresholdOn,
 		"threshold_off", thresholdOff,
+		"version", RoomVersion(output.Attributes),
 	)
+	s.recordRoomSettings(room, output.Attributes, false)
 
-	return nil
+	return RoomVersion(output.Attributes), nil
 }
 
 // GetRoomOptions Gets the current temperature options for a given room
@@ -63,11 +111,428 @@ func (s *SmartHome) GetRoomOptions(room string) (map[string]types.AttributeValue
 }
 
 // DeleteRoomOptions Deletes all the options for a given room
-func (s *SmartHome) DeleteRoomOptions(room string) error {
-	s.Debugw("removing item from DynamoDB", "room", room)
-	if err := s.delete("Room", room, s.Config.ControlPlaneTable); err != nil {
+func (s *SmartHome) DeleteRoomOptions(room string, version int64) error {
+	s.Debugw("removing item from DynamoDB", "room", room, "version", version)
+	input := &dynamodb.DeleteItemInput{
+		TableName: &s.Config.ControlPlaneTable,
+		Key:       roomKey(room),
+	}
+	values := map[string]types.AttributeValue{}
+	if condition := versionCondition(version, values); condition != "" {
+		input.ConditionExpression = aws.String(condition)
+		if len(values) > 0 {
+			input.ExpressionAttributeValues = values
+		}
+	}
+	_, err := s.DeleteItem(context.TODO(), input)
+	var conditionErr *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionErr) {
+		return newVersionError(room)
+	}
+	if err != nil {
 		return fmt.Errorf("error when deleting room %s from DynamoDB: %w", room, err)
 	}
 	s.Debugw("successfully deleted item", "room", room)
+	s.recordRoomSettings(room, nil, true)
 	return nil
 }
+
+// RoomOptionsPatch is a partial update of the options of a room, in which nil
+// fields are left unchanged
+type RoomOptionsPatch struct {
+	Enabled      *bool
+	ThresholdOn  *float32
+	ThresholdOff *float32
+}
+
+// UpdateRoomOptions updates only the options of a room set in the patch, so
+// that concurrent updates of different options don't overwrite each other,
+// and returns the options of the room after the update. The room must exist,
+// and its threshold_on can't end up higher than its threshold_off.
+func (s *SmartHome) UpdateRoomOptions(room string, patch RoomOptionsPatch, version int64) (map[string]types.AttributeValue, error) {
+	s.Debugw("updating item in DynamoDB", "room", room, "version", version)
+
+	if patch.empty() {
+		item, err := s.GetRoomOptions(room)
+		if err != nil {
+			return nil, err
+		}
+		if item == nil {
+			return nil, NewNotFoundError("room %s not found", room)
+		}
+		if version > 0 && RoomVersion(item) != version {
+			return nil, newVersionError(room)
+		}
+		return item, nil
+	}
+
+	patch = s.roundThresholds(patch)
+	update, condition, values, err := s.roomUpdate(room, patch, version, true)
+	if err != nil {
+		return nil, err
+	}
+	output, err := s.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName:                 &s.Config.ControlPlaneTable,
+		Key:                       roomKey(room),
+		UpdateExpression:          aws.String(update),
+		ConditionExpression:       aws.String(condition),
+		ExpressionAttributeValues: values,
+		ReturnValues:              types.ReturnValueAllNew,
+	})
+	var conditionErr *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionErr) {
+		return nil, s.roomConditionError(room, patch, version, true)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error updating room %s in DynamoDB: %w", room, err)
+	}
+
+	s.Debugw("successfully updated item in DynamoDB", "room", room, "item", output.Attributes)
+	s.recordRoomSettings(room, output.Attributes, false)
+	return output.Attributes, nil
+}
+
+// Actions of the changes of a batch of rooms
+const (
+	// RoomActionSet sets every option of a room, creating it if needed
+	RoomActionSet = "set"
+
+	// RoomActionUpdate updates some of the options of an existing room
+	RoomActionUpdate = "update"
+
+	// RoomActionDelete deletes the options of a room
+	RoomActionDelete = "delete"
+)
+
+// maxTransactionItems is the maximum number of writes of a DynamoDB transaction
+const maxTransactionItems = 100
+
+// maxBatchGetItems is the maximum number of keys of a DynamoDB BatchGetItem
+const maxBatchGetItems = 100
+
+// maxBatchGetAttempts is the number of times the keys that DynamoDB leaves
+// unprocessed in a BatchGetItem are requested before giving up
+const maxBatchGetAttempts = 5
+
+// RoomChange is a change of the options of a room in a batch of changes
+type RoomChange struct {
+	Room string
+
+	// Action is one of RoomActionSet, RoomActionUpdate or RoomActionDelete
+	Action string
+
+	// Options are the options to set, all of which are required, or to update
+	Options RoomOptionsPatch
+
+	// Version is the version the room must have, as in SetRoomOptions
+	Version int64
+}
+
+// ChangeRoomsOptions applies a batch of changes of the options of several
+// rooms in a single transaction, so that either all of them or none are
+// applied. Each room can only be changed once in a batch.
+func (s *SmartHome) ChangeRoomsOptions(changes []RoomChange) error {
+	s.Debugw("changing rooms in DynamoDB", "changes", len(changes))
+	if len(changes) == 0 {
+		return nil
+	}
+	if len(changes) > maxTransactionItems {
+		return NewValidationError("a batch can't change more than %d rooms", maxTransactionItems)
+	}
+
+	// The thresholds of the changes are rounded in a copy of them
+	changes = append([]RoomChange{}, changes...)
+	items := []types.TransactWriteItem{}
+	changed := map[string]bool{}
+	for i, change := range changes {
+		if changed[change.Room] {
+			return NewValidationError("room %s is changed more than once", change.Room)
+		}
+		changed[change.Room] = true
+
+		switch change.Action {
+		case RoomActionSet, RoomActionUpdate:
+			if change.Action == RoomActionSet && !change.Options.complete() {
+				return NewValidationError("setting room %s requires enabled, threshold_on and threshold_off", change.Room)
+			}
+			if change.Options.empty() {
+				return NewValidationError("no options to update for room %s", change.Room)
+			}
+			change.Options = s.roundThresholds(change.Options)
+			changes[i].Options = change.Options
+			update, condition, values, err := s.roomUpdate(change.Room, change.Options, change.Version, change.Action == RoomActionUpdate)
+			if err != nil {
+				return err
+			}
+			item := &types.Update{
+				TableName:                 &s.Config.ControlPlaneTable,
+				Key:                       roomKey(change.Room),
+				UpdateExpression:          aws.String(update),
+				ExpressionAttributeValues: values,
+			}
+			if condition != "" {
+				item.ConditionExpression = aws.String(condition)
+			}
+			items = append(items, types.TransactWriteItem{Update: item})
+		case RoomActionDelete:
+			item := &types.Delete{
+				TableName: &s.Config.ControlPlaneTable,
+				Key:       roomKey(change.Room),
+			}
+			values := map[string]types.AttributeValue{}
+			if condition := versionCondition(change.Version, values); condition != "" {
+				item.ConditionExpression = aws.String(condition)
+				if len(values) > 0 {
+					item.ExpressionAttributeValues = values
+				}
+			}
+			items = append(items, types.TransactWriteItem{Delete: item})
+		default:
+			return NewValidationError("invalid action %s for room %s", change.Action, change.Room)
+		}
+	}
+
+	_, err := s.TransactWriteItems(context.TODO(), &dynamodb.TransactWriteItemsInput{TransactItems: items})
+	var canceledErr *types.TransactionCanceledException
+	if errors.As(err, &canceledErr) {
+		for i, reason := range canceledErr.CancellationReasons {
+			if i >= len(changes) || reason.Code == nil {
+				continue
+			}
+			switch *reason.Code {
+			case "ConditionalCheckFailed":
+				change := changes[i]
+				return s.roomConditionError(change.Room, change.Options, change.Version, change.Action == RoomActionUpdate)
+			case "TransactionConflict":
+				return NewConflictError("room %s is being changed by another request", changes[i].Room)
+			}
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("error changing %d rooms in DynamoDB: %w", len(changes), err)
+	}
+
+	s.Debugw("successfully changed rooms in DynamoDB", "changes", len(changes))
+	s.recordRoomsSettings(changes)
+	return nil
+}
+
+// recordRoomsSettings adds the options of the rooms of a batch of changes to
+// the history of the settings. Transactions don't return the items written,
+// so the options of the rooms set or updated are read again.
+func (s *SmartHome) recordRoomsSettings(changes []RoomChange) {
+	rooms := []string{}
+	for _, change := range changes {
+		if change.Action != RoomActionDelete {
+			rooms = append(rooms, change.Room)
+		}
+	}
+	items := map[string]map[string]types.AttributeValue{}
+	if len(rooms) > 0 {
+		var err error
+		if items, err = s.GetRoomsOptions(rooms); err != nil {
+			s.Errorw("error recording room settings in history", "rooms", rooms, "error", err.Error())
+			return
+		}
+	}
+	for _, change := range changes {
+		if change.Action == RoomActionDelete {
+			s.recordRoomSettings(change.Room, nil, true)
+		} else if item, ok := items[change.Room]; ok {
+			s.recordRoomSettings(change.Room, item, false)
+		}
+	}
+}
+
+// GetRoomsOptions gets the options of several rooms at once, by room. Rooms
+// without options are missing from the result.
+func (s *SmartHome) GetRoomsOptions(rooms []string) (map[string]map[string]types.AttributeValue, error) {
+	s.Debugw("getting items from DynamoDB", "rooms", rooms)
+
+	keys := []map[string]types.AttributeValue{}
+	requested := map[string]bool{}
+	for _, room := range rooms {
+		if !requested[room] {
+			requested[room] = true
+			keys = append(keys, roomKey(room))
+		}
+	}
+
+	items := map[string]map[string]types.AttributeValue{}
+	for start := 0; start < len(keys); start += maxBatchGetItems {
+		end := start + maxBatchGetItems
+		if end > len(keys) {
+			end = len(keys)
+		}
+		request := map[string]types.KeysAndAttributes{
+			s.Config.ControlPlaneTable: {Keys: keys[start:end], ConsistentRead: aws.Bool(true)},
+		}
+		for attempt := 0; len(request) > 0; attempt++ {
+			if attempt == maxBatchGetAttempts {
+				return nil, fmt.Errorf("error getting rooms: keys still unprocessed after %d attempts", attempt)
+			}
+			if attempt > 0 {
+				time.Sleep(time.Duration(1<<uint(attempt)) * 10 * time.Millisecond)
+			}
+			output, err := s.BatchGetItem(context.TODO(), &dynamodb.BatchGetItemInput{RequestItems: request})
+			if err != nil {
+				return nil, fmt.Errorf("error getting rooms %v: %w", rooms, err)
+			}
+			for _, item := range output.Responses[s.Config.ControlPlaneTable] {
+				if room, ok := item["Room"].(*types.AttributeValueMemberS); ok {
+					items[room.Value] = item
+				}
+			}
+			request = output.UnprocessedKeys
+		}
+	}
+
+	s.Debugw("successfully retrieved items from DynamoDB", "rooms", rooms, "found", len(items))
+	return items, nil
+}
+
+// empty returns true if the patch doesn't change any option
+func (p RoomOptionsPatch) empty() bool {
+	return p.Enabled == nil && p.ThresholdOn == nil && p.ThresholdOff == nil
+}
+
+// complete returns true if the patch changes every option
+func (p RoomOptionsPatch) complete() bool {
+	return p.Enabled != nil && p.ThresholdOn != nil && p.ThresholdOff != nil
+}
+
+// roundThresholds returns a copy of the patch with its thresholds rounded to
+// the configured precision, e.g. 21.1 (70°F) to 21.0 with 0.5 steps
+func (s *SmartHome) roundThresholds(patch RoomOptionsPatch) RoomOptionsPatch {
+	if patch.ThresholdOn != nil {
+		thresholdOn := RoundTemperature(*patch.ThresholdOn, s.Config.ThresholdPrecision)
+		patch.ThresholdOn = &thresholdOn
+	}
+	if patch.ThresholdOff != nil {
+		thresholdOff := RoundTemperature(*patch.ThresholdOff, s.Config.ThresholdPrecision)
+		patch.ThresholdOff = &thresholdOff
+	}
+	return patch
+}
+
+// roomUpdate returns the update expression, the condition expression and the
+// values of both for writing the options of a patch with the version. The
+// thresholds must be within the safety limits of the room. When only one
+// threshold changes, the condition checks that the gap between the thresholds
+// of the room is still big enough, which requires the room to exist.
+func (s *SmartHome) roomUpdate(room string, patch RoomOptionsPatch, version int64, mustExist bool) (string, string, map[string]types.AttributeValue, error) {
+	limits := s.Config.Safety.Limits(room)
+	updates := []string{}
+	conditions := []string{}
+	values := map[string]types.AttributeValue{":one": &types.AttributeValueMemberN{Value: "1"}}
+	if mustExist {
+		conditions = append(conditions, "attribute_exists(Room)")
+	}
+	if patch.Enabled != nil {
+		updates = append(updates, "Enabled = :enabled")
+		values[":enabled"] = &types.AttributeValueMemberBOOL{Value: *patch.Enabled}
+	}
+	if patch.ThresholdOn != nil {
+		updates = append(updates, "ThresholdOn = :threshold_on")
+		values[":threshold_on"] = &types.AttributeValueMemberN{Value: fmt.Sprintf("%.1f", *patch.ThresholdOn)}
+	}
+	if patch.ThresholdOff != nil {
+		updates = append(updates, "ThresholdOff = :threshold_off")
+		values[":threshold_off"] = &types.AttributeValueMemberN{Value: fmt.Sprintf("%.1f", *patch.ThresholdOff)}
+	}
+	switch {
+	case patch.ThresholdOn != nil && patch.ThresholdOff != nil:
+		if err := limits.ValidateThresholds(room, *patch.ThresholdOn, *patch.ThresholdOff); err != nil {
+			return "", "", nil, err
+		}
+	case patch.ThresholdOn != nil:
+		if err := limits.validateThreshold(room, "threshold_on", *patch.ThresholdOn); err != nil {
+			return "", "", nil, err
+		}
+		conditions = append(conditions, "ThresholdOff >= :min_threshold_off")
+		values[":min_threshold_off"] = &types.AttributeValueMemberN{Value: fmt.Sprintf("%.1f", *patch.ThresholdOn+limits.MinHysteresis)}
+	case patch.ThresholdOff != nil:
+		if err := limits.validateThreshold(room, "threshold_off", *patch.ThresholdOff); err != nil {
+			return "", "", nil, err
+		}
+		conditions = append(conditions, "ThresholdOn <= :max_threshold_on")
+		values[":max_threshold_on"] = &types.AttributeValueMemberN{Value: fmt.Sprintf("%.1f", *patch.ThresholdOff-limits.MinHysteresis)}
+	}
+	if condition := versionCondition(version, values); condition != "" && !(mustExist && version == ExistingVersion) {
+		conditions = append(conditions, condition)
+	}
+
+	update := "ADD Version :one"
+	if len(updates) > 0 {
+		update = "SET " + strings.Join(updates, ", ") + " " + update
+	}
+	return update, strings.Join(conditions, " AND "), values, nil
+}
+
+// roomConditionError returns the error of a write of the options of a room
+// whose condition didn't hold. The condition doesn't tell whether the room
+// is missing, its version has changed or its thresholds would be too close,
+// so its current options do.
+func (s *SmartHome) roomConditionError(room string, patch RoomOptionsPatch, version int64, mustExist bool) error {
+	item, err := s.GetRoomOptions(room)
+	if err != nil {
+		return err
+	}
+	if item == nil {
+		if mustExist {
+			return NewNotFoundError("room %s not found", room)
+		}
+		return newVersionError(room)
+	}
+	if version > 0 && RoomVersion(item) != version {
+		return newVersionError(room)
+	}
+
+	var current struct{ ThresholdOn, ThresholdOff float32 }
+	if err := attributevalue.UnmarshalMap(item, &current); err != nil {
+		return fmt.Errorf("error unmarshalling room %s: %w", room, err)
+	}
+	if patch.ThresholdOn != nil {
+		current.ThresholdOn = *patch.ThresholdOn
+	}
+	if patch.ThresholdOff != nil {
+		current.ThresholdOff = *patch.ThresholdOff
+	}
+	if patch.ThresholdOn != nil || patch.ThresholdOff != nil {
+		if err := s.Config.Safety.Limits(room).validateGap(room, current.ThresholdOn, current.ThresholdOff); err != nil {
+			return err
+		}
+	}
+	// The options changed between the write and the read
+	return newVersionError(room)
+}
+
+// roomKey returns the key of the options of a room in the ControlPlane table
+func roomKey(room string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{"Room": &types.AttributeValueMemberS{Value: room}}
+}
+
+// versionCondition returns the condition expression for the version of a
+// write, if any, adding the version to the values of the expression
+func versionCondition(version int64, values map[string]types.AttributeValue) string {
+	switch {
+	case version == ExistingVersion:
+		return "attribute_exists(Room)"
+	case version > 0:
+		values[":version"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(version, 10)}
+		return "Version = :version"
+	}
+	return ""
+}
+
+func newVersionError(room string) error {
+	return NewPreconditionFailedError("the options of room %s have been modified or deleted", room)
+}
+
+func newThresholdsError(thresholdOn, thresholdOff float32) error {
+	return NewValidationError(
+		"threshold_on should be lower or equal to threshold_off. However we have: threshold_on = %.1f; threshold_off = %.1f",
+		thresholdOn,
+		thresholdOff,
+	)
+}
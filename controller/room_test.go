@@ -1,11 +1,13 @@
 package controller
 
 import (
+	"errors"
 	"fmt"
 	"testing"
 
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/igvaquero18/smarthome/utils/dynamotest"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -26,7 +28,9 @@ func TestSetRoomOptions(t *testing.T) {
 			thresholdOn:  19.3,
 			thresholdOff: 19.5,
 			client: &mockDynamoClient{
-				putItemOutput: &dynamodb.PutItemOutput{},
+				updateItemOutput: &dynamodb.UpdateItemOutput{
+					Attributes: map[string]types.AttributeValue{"Version": &types.AttributeValueMemberN{Value: "2"}},
+				},
 			},
 			expectedErr: false,
 		},
@@ -45,12 +49,13 @@ func TestSetRoomOptions(t *testing.T) {
 	for _, tc := range testCases {
 		t.Run(tc.name, func(tt *testing.T) {
 			sh := NewSmartHome(SetDynamoDBClient(tc.client), SetLogger(mockLogger{}))
-			err := sh.SetRoomOptions(tc.room, tc.enabled, tc.thresholdOn, tc.thresholdOff)
+			version, err := sh.SetRoomOptions(tc.room, tc.enabled, tc.thresholdOn, tc.thresholdOff, AnyVersion)
 			if tc.expectedErr {
 				assert.Error(tt, err)
 				return
 			}
 			assert.NoError(tt, err)
+			assert.Equal(tt, int64(2), version)
 		})
 	}
 }
@@ -136,7 +141,7 @@ func TestDeleteRoomOptions(t *testing.T) {
 	for _, tc := range testCases {
 		t.Run(tc.name, func(tt *testing.T) {
 			sh := NewSmartHome(SetDynamoDBClient(tc.client), SetLogger(mockLogger{}))
-			err := sh.DeleteRoomOptions(tc.room)
+			err := sh.DeleteRoomOptions(tc.room, AnyVersion)
 			if tc.expectedErr {
 				assert.Error(tt, err)
 				return
@@ -145,3 +150,276 @@ func TestDeleteRoomOptions(t *testing.T) {
 		})
 	}
 }
+
+func TestUpdateRoomOptions(t *testing.T) {
+	enabled := false
+	thresholdOn, thresholdOff, highThreshold := float32(18.5), float32(21), float32(23)
+	testCases := []struct {
+		name        string
+		room        string
+		patch       RoomOptionsPatch
+		client      DynamoDBInterface
+		expected    map[string]types.AttributeValue
+		expectedErr error
+	}{
+		{
+			name:  "Update enabled only",
+			room:  "bedroom",
+			patch: RoomOptionsPatch{Enabled: &enabled},
+			expected: map[string]types.AttributeValue{
+				"Room":         &types.AttributeValueMemberS{Value: "bedroom"},
+				"Enabled":      &types.AttributeValueMemberBOOL{Value: false},
+				"ThresholdOn":  &types.AttributeValueMemberN{Value: "19.0"},
+				"ThresholdOff": &types.AttributeValueMemberN{Value: "22.0"},
+				"Version":      &types.AttributeValueMemberN{Value: "2"},
+			},
+		},
+		{
+			name:  "Update both thresholds",
+			room:  "bedroom",
+			patch: RoomOptionsPatch{ThresholdOn: &thresholdOn, ThresholdOff: &thresholdOff},
+			expected: map[string]types.AttributeValue{
+				"Room":         &types.AttributeValueMemberS{Value: "bedroom"},
+				"Enabled":      &types.AttributeValueMemberBOOL{Value: true},
+				"ThresholdOn":  &types.AttributeValueMemberN{Value: "18.5"},
+				"ThresholdOff": &types.AttributeValueMemberN{Value: "21.0"},
+				"Version":      &types.AttributeValueMemberN{Value: "2"},
+			},
+		},
+		{
+			name:  "Empty patch",
+			room:  "bedroom",
+			patch: RoomOptionsPatch{},
+			expected: map[string]types.AttributeValue{
+				"Room":         &types.AttributeValueMemberS{Value: "bedroom"},
+				"Enabled":      &types.AttributeValueMemberBOOL{Value: true},
+				"ThresholdOn":  &types.AttributeValueMemberN{Value: "19.0"},
+				"ThresholdOff": &types.AttributeValueMemberN{Value: "22.0"},
+				"Version":      &types.AttributeValueMemberN{Value: "1"},
+			},
+		},
+		{
+			name:        "Inverted thresholds in the patch",
+			room:        "bedroom",
+			patch:       RoomOptionsPatch{ThresholdOn: &thresholdOff, ThresholdOff: &thresholdOn},
+			expectedErr: ErrValidation,
+		},
+		{
+			name:        "Threshold on higher than the current threshold off",
+			room:        "bedroom",
+			patch:       RoomOptionsPatch{ThresholdOn: &highThreshold},
+			expectedErr: ErrValidation,
+		},
+		{
+			name:        "Threshold off lower than the current threshold on",
+			room:        "bedroom",
+			patch:       RoomOptionsPatch{ThresholdOff: &thresholdOn},
+			expectedErr: ErrValidation,
+		},
+		{
+			name:        "Room not found",
+			room:        "livingroom",
+			patch:       RoomOptionsPatch{Enabled: &enabled},
+			expectedErr: ErrNotFound,
+		},
+		{
+			name:        "Empty patch for a room not found",
+			room:        "livingroom",
+			patch:       RoomOptionsPatch{},
+			expectedErr: ErrNotFound,
+		},
+		{
+			name:   "Error from DynamoDB client",
+			room:   "bedroom",
+			patch:  RoomOptionsPatch{Enabled: &enabled},
+			client: &mockDynamoClient{err: fmt.Errorf("Error")},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			client := tc.client
+			if client == nil {
+				client = dynamotest.NewClient(map[string]string{DefaultControlPlaneTable: "Room"})
+			}
+			sh := NewSmartHome(SetDynamoDBClient(client), SetLogger(mockLogger{}))
+			if tc.client == nil {
+				_, err := sh.SetRoomOptions("bedroom", true, 19, 22, AnyVersion)
+				assert.NoError(tt, err)
+			}
+			actual, err := sh.UpdateRoomOptions(tc.room, tc.patch, AnyVersion)
+			if tc.client != nil {
+				assert.Error(tt, err)
+				return
+			}
+			if tc.expectedErr != nil {
+				assert.True(tt, errors.Is(err, tc.expectedErr))
+				return
+			}
+			assert.NoError(tt, err)
+			assert.Equal(tt, tc.expected, actual)
+		})
+	}
+}
+
+func TestRoomOptionsVersions(t *testing.T) {
+	enabled := false
+	sh := NewSmartHome(
+		SetDynamoDBClient(dynamotest.NewClient(map[string]string{DefaultControlPlaneTable: "Room"})),
+		SetLogger(mockLogger{}),
+	)
+
+	_, err := sh.SetRoomOptions("bedroom", true, 19, 22, ExistingVersion)
+	assert.True(t, errors.Is(err, ErrPreconditionFailed))
+
+	version, err := sh.SetRoomOptions("bedroom", true, 19, 22, AnyVersion)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), version)
+
+	version, err = sh.SetRoomOptions("bedroom", true, 19, 21, version)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), version)
+
+	_, err = sh.SetRoomOptions("bedroom", true, 19, 23, 1)
+	assert.True(t, errors.Is(err, ErrPreconditionFailed))
+
+	item, err := sh.UpdateRoomOptions("bedroom", RoomOptionsPatch{Enabled: &enabled}, version)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), RoomVersion(item))
+
+	_, err = sh.UpdateRoomOptions("bedroom", RoomOptionsPatch{Enabled: &enabled}, version)
+	assert.True(t, errors.Is(err, ErrPreconditionFailed))
+
+	_, err = sh.UpdateRoomOptions("bedroom", RoomOptionsPatch{}, version)
+	assert.True(t, errors.Is(err, ErrPreconditionFailed))
+
+	assert.True(t, errors.Is(sh.DeleteRoomOptions("bedroom", version), ErrPreconditionFailed))
+	assert.NoError(t, sh.DeleteRoomOptions("bedroom", 3))
+	assert.True(t, errors.Is(sh.DeleteRoomOptions("bedroom", ExistingVersion), ErrPreconditionFailed))
+	assert.NoError(t, sh.DeleteRoomOptions("bedroom", AnyVersion))
+}
+
+func TestRoomVersion(t *testing.T) {
+	assert.Equal(t, int64(0), RoomVersion(nil))
+	assert.Equal(t, int64(0), RoomVersion(map[string]types.AttributeValue{"Room": &types.AttributeValueMemberS{Value: "bedroom"}}))
+	assert.Equal(t, int64(7), RoomVersion(map[string]types.AttributeValue{"Version": &types.AttributeValueMemberN{Value: "7"}}))
+}
+
+func TestChangeRoomsOptions(t *testing.T) {
+	enabled, low, high := false, float32(18), float32(25)
+	testCases := []struct {
+		name     string
+		changes  []RoomChange
+		expected error
+		rooms    map[string]int64
+	}{
+		{
+			name: "Set, update and delete",
+			changes: []RoomChange{
+				{Room: "livingroom", Action: RoomActionSet, Options: RoomOptionsPatch{Enabled: &enabled, ThresholdOn: &low, ThresholdOff: &high}},
+				{Room: "bedroom", Action: RoomActionUpdate, Options: RoomOptionsPatch{ThresholdOn: &low}, Version: 1},
+				{Room: "kitchen", Action: RoomActionDelete, Version: 1},
+			},
+			rooms: map[string]int64{"bedroom": 2, "livingroom": 1},
+		},
+		{
+			name: "Wrong version",
+			changes: []RoomChange{
+				{Room: "livingroom", Action: RoomActionSet, Options: RoomOptionsPatch{Enabled: &enabled, ThresholdOn: &low, ThresholdOff: &high}},
+				{Room: "bedroom", Action: RoomActionUpdate, Options: RoomOptionsPatch{Enabled: &enabled}, Version: 2},
+			},
+			expected: ErrPreconditionFailed,
+			rooms:    map[string]int64{"bedroom": 1, "kitchen": 1},
+		},
+		{
+			name: "Update of a missing room",
+			changes: []RoomChange{
+				{Room: "kitchen", Action: RoomActionDelete},
+				{Room: "livingroom", Action: RoomActionUpdate, Options: RoomOptionsPatch{Enabled: &enabled}},
+			},
+			expected: ErrNotFound,
+			rooms:    map[string]int64{"bedroom": 1, "kitchen": 1},
+		},
+		{
+			name: "Inverted thresholds",
+			changes: []RoomChange{
+				{Room: "kitchen", Action: RoomActionDelete},
+				{Room: "bedroom", Action: RoomActionUpdate, Options: RoomOptionsPatch{ThresholdOn: &high}},
+			},
+			expected: ErrValidation,
+			rooms:    map[string]int64{"bedroom": 1, "kitchen": 1},
+		},
+		{
+			name: "Incomplete set",
+			changes: []RoomChange{
+				{Room: "livingroom", Action: RoomActionSet, Options: RoomOptionsPatch{Enabled: &enabled}},
+			},
+			expected: ErrValidation,
+			rooms:    map[string]int64{"bedroom": 1, "kitchen": 1},
+		},
+		{
+			name: "Room changed twice",
+			changes: []RoomChange{
+				{Room: "kitchen", Action: RoomActionDelete},
+				{Room: "kitchen", Action: RoomActionUpdate, Options: RoomOptionsPatch{Enabled: &enabled}},
+			},
+			expected: ErrValidation,
+			rooms:    map[string]int64{"bedroom": 1, "kitchen": 1},
+		},
+		{
+			name:     "Invalid action",
+			changes:  []RoomChange{{Room: "kitchen", Action: "rename"}},
+			expected: ErrValidation,
+			rooms:    map[string]int64{"bedroom": 1, "kitchen": 1},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			sh := NewSmartHome(
+				SetDynamoDBClient(dynamotest.NewClient(map[string]string{DefaultControlPlaneTable: "Room"})),
+				SetLogger(mockLogger{}),
+			)
+			for _, room := range []string{"bedroom", "kitchen"} {
+				_, err := sh.SetRoomOptions(room, true, 20, 22, AnyVersion)
+				assert.NoError(tt, err)
+			}
+
+			err := sh.ChangeRoomsOptions(tc.changes)
+			if tc.expected == nil {
+				assert.NoError(tt, err)
+			} else {
+				assert.True(tt, errors.Is(err, tc.expected), "unexpected error %v", err)
+			}
+
+			items, err := sh.GetRoomsOptions([]string{"bedroom", "kitchen", "livingroom"})
+			assert.NoError(tt, err)
+			rooms := map[string]int64{}
+			for room, item := range items {
+				rooms[room] = RoomVersion(item)
+			}
+			assert.Equal(tt, tc.rooms, rooms)
+		})
+	}
+}
+
+func TestGetRoomsOptions(t *testing.T) {
+	sh := NewSmartHome(
+		SetDynamoDBClient(dynamotest.NewClient(map[string]string{DefaultControlPlaneTable: "Room"})),
+		SetLogger(mockLogger{}),
+	)
+	_, err := sh.SetRoomOptions("bedroom", true, 19, 22, AnyVersion)
+	assert.NoError(t, err)
+
+	items, err := sh.GetRoomsOptions([]string{"bedroom", "livingroom", "bedroom"})
+	assert.NoError(t, err)
+	assert.Len(t, items, 1)
+	assert.Equal(t, &types.AttributeValueMemberN{Value: "19.0"}, items["bedroom"]["ThresholdOn"])
+
+	items, err = sh.GetRoomsOptions(nil)
+	assert.NoError(t, err)
+	assert.Empty(t, items)
+
+	sh = NewSmartHome(SetDynamoDBClient(&mockDynamoClient{err: errors.New("unavailable")}), SetLogger(mockLogger{}))
+	_, err = sh.GetRoomsOptions([]string{"bedroom"})
+	assert.EqualError(t, err, "error getting rooms [bedroom]: unavailable")
+}
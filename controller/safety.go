@@ -0,0 +1,176 @@
+package controller
+
+import "fmt"
+
+const (
+	// DefaultMinThreshold is the lowest threshold a room can have unless
+	// configured otherwise
+	DefaultMinThreshold float32 = 5
+
+	// DefaultMaxThreshold is the highest threshold a room can have unless
+	// configured otherwise
+	DefaultMaxThreshold float32 = 30
+
+	// DefaultFrostProtection is the temperature below which rooms are heated
+	// even if they're disabled, unless configured otherwise
+	DefaultFrostProtection float32 = 5
+)
+
+// SafetyLimits are the limits of the thresholds of a room
+type SafetyLimits struct {
+	// MinThreshold is the lowest threshold_on and threshold_off allowed
+	MinThreshold float32 `json:"min_threshold"`
+
+	// MaxThreshold is the highest threshold_on and threshold_off allowed
+	MaxThreshold float32 `json:"max_threshold"`
+
+	// MinHysteresis is the minimum gap between threshold_on and
+	// threshold_off, which keeps the boiler from switching too often
+	MinHysteresis float32 `json:"min_hysteresis"`
+
+	// FrostProtection is the temperature the room is never left below, even
+	// if it's disabled
+	FrostProtection float32 `json:"frost_protection"`
+}
+
+// SafetyOverride overrides some of the limits of a room. The fields that are
+// nil are taken from the policy, so that limits such as a frost protection
+// of 0 can be set.
+type SafetyOverride struct {
+	MinThreshold    *float32 `json:"min_threshold,omitempty"`
+	MaxThreshold    *float32 `json:"max_threshold,omitempty"`
+	MinHysteresis   *float32 `json:"min_hysteresis,omitempty"`
+	FrostProtection *float32 `json:"frost_protection,omitempty"`
+}
+
+// SafetyPolicy defines the limits enforced on every write of the thresholds
+// of the rooms, so that a typo such as 210 instead of 21.0 is rejected
+type SafetyPolicy struct {
+	SafetyLimits
+
+	// Rooms overrides the limits of some rooms
+	Rooms map[string]SafetyOverride `json:"rooms,omitempty"`
+}
+
+// DefaultSafetyPolicy is the SafetyPolicy used unless configured otherwise
+var DefaultSafetyPolicy = SafetyPolicy{SafetyLimits: SafetyLimits{
+	MinThreshold:    DefaultMinThreshold,
+	MaxThreshold:    DefaultMaxThreshold,
+	FrostProtection: DefaultFrostProtection,
+}}
+
+// Limits returns the limits of a room, applying its override if any
+func (p SafetyPolicy) Limits(room string) SafetyLimits {
+	limits := p.SafetyLimits
+	override, ok := p.Rooms[room]
+	if !ok {
+		return limits
+	}
+	if override.MinThreshold != nil {
+		limits.MinThreshold = *override.MinThreshold
+	}
+	if override.MaxThreshold != nil {
+		limits.MaxThreshold = *override.MaxThreshold
+	}
+	if override.MinHysteresis != nil {
+		limits.MinHysteresis = *override.MinHysteresis
+	}
+	if override.FrostProtection != nil {
+		limits.FrostProtection = *override.FrostProtection
+	}
+	return limits
+}
+
+// Validate returns an error if the limits of any room are inconsistent
+func (p SafetyPolicy) Validate() error {
+	rooms := []string{""}
+	for room := range p.Rooms {
+		rooms = append(rooms, room)
+	}
+	for _, room := range rooms {
+		limits := p.Limits(room)
+		if limits.MinHysteresis < 0 {
+			return fmt.Errorf("invalid safety limits of room %q: the minimum hysteresis can't be negative", room)
+		}
+		if limits.MinThreshold+limits.MinHysteresis > limits.MaxThreshold {
+			return fmt.Errorf(
+				"invalid safety limits of room %q: the minimum threshold %.1f plus the minimum hysteresis %.1f is higher than the maximum threshold %.1f",
+				room, limits.MinThreshold, limits.MinHysteresis, limits.MaxThreshold,
+			)
+		}
+		if limits.FrostProtection > limits.MaxThreshold {
+			return fmt.Errorf(
+				"invalid safety limits of room %q: the frost protection %.1f is higher than the maximum threshold %.1f",
+				room, limits.FrostProtection, limits.MaxThreshold,
+			)
+		}
+	}
+	return nil
+}
+
+// ValidateThresholds returns a Validation error if the thresholds of a room
+// are out of the limits
+func (l SafetyLimits) ValidateThresholds(room string, thresholdOn, thresholdOff float32) error {
+	if err := l.validateThreshold(room, "threshold_on", thresholdOn); err != nil {
+		return err
+	}
+	if err := l.validateThreshold(room, "threshold_off", thresholdOff); err != nil {
+		return err
+	}
+	return l.validateGap(room, thresholdOn, thresholdOff)
+}
+
+// validateThreshold returns a Validation error if a threshold of a room is
+// out of the limits
+func (l SafetyLimits) validateThreshold(room, name string, threshold float32) error {
+	if threshold < l.MinThreshold || threshold > l.MaxThreshold {
+		return NewValidationError(
+			"%s of room %s must be between %.1f and %.1f. However we have: %s = %.1f",
+			name, room, l.MinThreshold, l.MaxThreshold, name, threshold,
+		)
+	}
+	return nil
+}
+
+// validateGap returns a Validation error if the thresholds of a room are
+// inverted or closer than the minimum hysteresis
+func (l SafetyLimits) validateGap(room string, thresholdOn, thresholdOff float32) error {
+	// Thresholds have a resolution of 0.1 degrees, so smaller differences
+	// are rounding errors
+	if l.MinHysteresis > 0 && thresholdOff-thresholdOn < l.MinHysteresis-0.01 {
+		return NewValidationError(
+			"threshold_off of room %s must be at least %.1f higher than threshold_on. However we have: threshold_on = %.1f; threshold_off = %.1f",
+			room, l.MinHysteresis, thresholdOn, thresholdOff,
+		)
+	}
+	if thresholdOn > thresholdOff {
+		return newThresholdsError(thresholdOn, thresholdOff)
+	}
+	return nil
+}
+
+// HeatingThresholds returns the thresholds a room is heated with given its
+// options, and whether they were raised to the frost protection. Rooms are
+// heated below the frost protection even if they're disabled or their
+// thresholds are lower.
+func (l SafetyLimits) HeatingThresholds(enabled bool, thresholdOn, thresholdOff float32) (float32, float32, bool) {
+	if !enabled || thresholdOff < l.FrostProtection {
+		return l.FrostProtection, l.FrostProtection + l.MinHysteresis, true
+	}
+	if thresholdOn < l.FrostProtection {
+		return l.FrostProtection, thresholdOff, true
+	}
+	return thresholdOn, thresholdOff, false
+}
+
+// ShouldHeat is the control logic of a room: it returns whether the room
+// should be heated given its options, its temperature and whether it's being
+// heated. Heating starts below thresholdOn and stops at thresholdOff, once
+// raised to the frost protection by HeatingThresholds.
+func (l SafetyLimits) ShouldHeat(enabled bool, thresholdOn, thresholdOff, temperature float32, heating bool) bool {
+	thresholdOn, thresholdOff, _ = l.HeatingThresholds(enabled, thresholdOn, thresholdOff)
+	if heating {
+		return temperature < thresholdOff
+	}
+	return temperature < thresholdOn
+}
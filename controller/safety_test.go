@@ -0,0 +1,307 @@
+package controller
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/igvaquero18/smarthome/utils/dynamotest"
+	"github.com/stretchr/testify/assert"
+)
+
+var testSafetyPolicy = SafetyPolicy{
+	SafetyLimits: SafetyLimits{
+		MinThreshold:    5,
+		MaxThreshold:    30,
+		MinHysteresis:   1,
+		FrostProtection: 5,
+	},
+	Rooms: map[string]SafetyOverride{
+		"kids":     {MinThreshold: limit(16), MaxThreshold: limit(24)},
+		"bathroom": {FrostProtection: limit(10)},
+		"garage":   {MinThreshold: limit(0), FrostProtection: limit(0)},
+	},
+}
+
+func limit(value float32) *float32 {
+	return &value
+}
+
+func TestSafetyPolicyLimits(t *testing.T) {
+	testCases := []struct {
+		name     string
+		room     string
+		expected SafetyLimits
+	}{
+		{
+			name:     "Room without overrides",
+			room:     "bedroom",
+			expected: testSafetyPolicy.SafetyLimits,
+		},
+		{
+			name:     "Room overriding the thresholds",
+			room:     "kids",
+			expected: SafetyLimits{MinThreshold: 16, MaxThreshold: 24, MinHysteresis: 1, FrostProtection: 5},
+		},
+		{
+			name:     "Room overriding the frost protection",
+			room:     "bathroom",
+			expected: SafetyLimits{MinThreshold: 5, MaxThreshold: 30, MinHysteresis: 1, FrostProtection: 10},
+		},
+		{
+			name:     "Room overriding limits with zero",
+			room:     "garage",
+			expected: SafetyLimits{MinThreshold: 0, MaxThreshold: 30, MinHysteresis: 1, FrostProtection: 0},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			assert.Equal(tt, tc.expected, testSafetyPolicy.Limits(tc.room))
+		})
+	}
+}
+
+func TestSafetyPolicyValidate(t *testing.T) {
+	testCases := []struct {
+		name   string
+		policy SafetyPolicy
+		valid  bool
+	}{
+		{
+			name:   "Default policy",
+			policy: DefaultSafetyPolicy,
+			valid:  true,
+		},
+		{
+			name:   "Policy with overrides",
+			policy: testSafetyPolicy,
+			valid:  true,
+		},
+		{
+			name:   "Negative hysteresis",
+			policy: SafetyPolicy{SafetyLimits: SafetyLimits{MinThreshold: 5, MaxThreshold: 30, MinHysteresis: -1}},
+		},
+		{
+			name:   "Hysteresis wider than the range",
+			policy: SafetyPolicy{SafetyLimits: SafetyLimits{MinThreshold: 20, MaxThreshold: 21, MinHysteresis: 2}},
+		},
+		{
+			name: "Override with an inverted range",
+			policy: SafetyPolicy{
+				SafetyLimits: DefaultSafetyPolicy.SafetyLimits,
+				Rooms:        map[string]SafetyOverride{"kids": {MinThreshold: limit(35)}},
+			},
+		},
+		{
+			name:   "Frost protection above the maximum threshold",
+			policy: SafetyPolicy{SafetyLimits: SafetyLimits{MinThreshold: 5, MaxThreshold: 30, FrostProtection: 31}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			err := tc.policy.Validate()
+			if tc.valid {
+				assert.NoError(tt, err)
+			} else {
+				assert.Error(tt, err)
+			}
+		})
+	}
+}
+
+func TestValidateThresholds(t *testing.T) {
+	testCases := []struct {
+		name         string
+		room         string
+		thresholdOn  float32
+		thresholdOff float32
+		valid        bool
+	}{
+		{
+			name:         "Thresholds within the limits",
+			room:         "bedroom",
+			thresholdOn:  19,
+			thresholdOff: 21,
+			valid:        true,
+		},
+		{
+			name:         "Thresholds at the limits",
+			room:         "bedroom",
+			thresholdOn:  5,
+			thresholdOff: 30,
+			valid:        true,
+		},
+		{
+			name:         "Gap equal to the hysteresis",
+			room:         "bedroom",
+			thresholdOn:  20.1,
+			thresholdOff: 21.1,
+			valid:        true,
+		},
+		{
+			name:         "Typo in the threshold off",
+			room:         "bedroom",
+			thresholdOn:  19,
+			thresholdOff: 210,
+		},
+		{
+			name:         "Threshold on below the minimum",
+			room:         "bedroom",
+			thresholdOn:  4.9,
+			thresholdOff: 21,
+		},
+		{
+			name:         "Threshold above the maximum of the room",
+			room:         "kids",
+			thresholdOn:  20,
+			thresholdOff: 25,
+		},
+		{
+			name:         "Gap smaller than the hysteresis",
+			room:         "bedroom",
+			thresholdOn:  20.5,
+			thresholdOff: 21,
+		},
+		{
+			name:         "Inverted thresholds",
+			room:         "bedroom",
+			thresholdOn:  22,
+			thresholdOff: 20,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			err := testSafetyPolicy.Limits(tc.room).ValidateThresholds(tc.room, tc.thresholdOn, tc.thresholdOff)
+			if tc.valid {
+				assert.NoError(tt, err)
+			} else {
+				assert.True(tt, errors.Is(err, ErrValidation))
+			}
+		})
+	}
+}
+
+func TestShouldHeat(t *testing.T) {
+	testCases := []struct {
+		name         string
+		room         string
+		enabled      bool
+		thresholdOn  float32
+		thresholdOff float32
+		temperature  float32
+		heating      bool
+		expected     bool
+	}{
+		{
+			name:         "Below threshold on",
+			room:         "bedroom",
+			enabled:      true,
+			thresholdOn:  19,
+			thresholdOff: 21,
+			temperature:  18.5,
+			expected:     true,
+		},
+		{
+			name:         "Between thresholds while idle",
+			room:         "bedroom",
+			enabled:      true,
+			thresholdOn:  19,
+			thresholdOff: 21,
+			temperature:  20,
+		},
+		{
+			name:         "Between thresholds while heating",
+			room:         "bedroom",
+			enabled:      true,
+			thresholdOn:  19,
+			thresholdOff: 21,
+			temperature:  20,
+			heating:      true,
+			expected:     true,
+		},
+		{
+			name:         "At threshold off while heating",
+			room:         "bedroom",
+			enabled:      true,
+			thresholdOn:  19,
+			thresholdOff: 21,
+			temperature:  21,
+			heating:      true,
+		},
+		{
+			name:         "Disabled room above the frost protection",
+			room:         "bedroom",
+			thresholdOn:  19,
+			thresholdOff: 21,
+			temperature:  12,
+		},
+		{
+			name:         "Disabled room below the frost protection",
+			room:         "bedroom",
+			thresholdOn:  19,
+			thresholdOff: 21,
+			temperature:  4,
+			expected:     true,
+		},
+		{
+			name:         "Disabled room heated up to the frost protection plus the hysteresis",
+			room:         "bedroom",
+			thresholdOn:  19,
+			thresholdOff: 21,
+			temperature:  5.5,
+			heating:      true,
+			expected:     true,
+		},
+		{
+			name:         "Room with its own frost protection",
+			room:         "bathroom",
+			thresholdOn:  19,
+			thresholdOff: 21,
+			temperature:  9,
+			expected:     true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			limits := testSafetyPolicy.Limits(tc.room)
+			assert.Equal(tt, tc.expected, limits.ShouldHeat(tc.enabled, tc.thresholdOn, tc.thresholdOff, tc.temperature, tc.heating))
+		})
+	}
+}
+
+func TestRoomOptionsSafetyLimits(t *testing.T) {
+	sh := NewSmartHome(
+		SetDynamoDBClient(dynamotest.NewClient(map[string]string{DefaultControlPlaneTable: "Room"})),
+		SetLogger(mockLogger{}),
+		SetConfig(&SmartHomeConfig{Safety: testSafetyPolicy}),
+	)
+
+	_, err := sh.SetRoomOptions("bedroom", true, 19, 210, AnyVersion)
+	assert.True(t, errors.Is(err, ErrValidation))
+
+	_, err = sh.SetRoomOptions("kids", true, 15, 20, AnyVersion)
+	assert.True(t, errors.Is(err, ErrValidation))
+
+	_, err = sh.SetRoomOptions("bedroom", true, 19, 21, AnyVersion)
+	assert.NoError(t, err)
+
+	thresholdOn, thresholdOff := float32(20.5), float32(40)
+	_, err = sh.UpdateRoomOptions("bedroom", RoomOptionsPatch{ThresholdOn: &thresholdOn}, AnyVersion)
+	assert.True(t, errors.Is(err, ErrValidation))
+
+	_, err = sh.UpdateRoomOptions("bedroom", RoomOptionsPatch{ThresholdOff: &thresholdOff}, AnyVersion)
+	assert.True(t, errors.Is(err, ErrValidation))
+
+	thresholdOn = 20
+	item, err := sh.UpdateRoomOptions("bedroom", RoomOptionsPatch{ThresholdOn: &thresholdOn}, AnyVersion)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), RoomVersion(item))
+
+	err = sh.ChangeRoomsOptions([]RoomChange{
+		{Room: "bedroom", Action: RoomActionUpdate, Options: RoomOptionsPatch{ThresholdOff: &thresholdOn}},
+	})
+	assert.True(t, errors.Is(err, ErrValidation))
+}
@@ -2,9 +2,12 @@ package controller
 
 import (
 	"context"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/igvaquero18/smarthome/utils"
+	"golang.org/x/crypto/bcrypt"
 )
 
 const (
@@ -23,16 +26,64 @@ const (
 	// DefaultTempInsideTable is the default table name
 	// for the Temperature Inside DynamoDB table.
 	DefaultTempInsideTable = "TemperatureInside"
+
+	// DefaultLoginAttemptsTable is the default table name
+	// for the LoginAttempts DynamoDB table.
+	DefaultLoginAttemptsTable = "LoginAttempts"
+
+	// DefaultHeatingTable is the default table name
+	// for the HeatingTransitions DynamoDB table.
+	DefaultHeatingTable = "HeatingTransitions"
+
+	// DefaultHistoryTable is the default table name
+	// for the RoomSettingsHistory DynamoDB table.
+	DefaultHistoryTable = "RoomSettingsHistory"
 )
 
 // SmartHomeInterface is the interface implemented by the SmartHome Controller
 type SmartHomeInterface interface {
 	Authenticate(username, password string) error
 	SetCredentials(username, password string) error
-	SetRoomOptions(room string, enabled bool, thresholdOn, thresholdOff float32) error
+	GetUser(username string) (*User, error)
+	CreateExternalUser(username, role string) error
+	SetTemperatureUnit(username string, unit TemperatureUnit) error
+	SetRoomOptions(room string, enabled bool, thresholdOn, thresholdOff float32, version int64) (int64, error)
 	GetRoomOptions(room string) (map[string]types.AttributeValue, error)
-	DeleteRoomOptions(room string) error
+	UpdateRoomOptions(room string, patch RoomOptionsPatch, version int64) (map[string]types.AttributeValue, error)
+	DeleteRoomOptions(room string, version int64) error
+	GetRoomsOptions(rooms []string) (map[string]map[string]types.AttributeValue, error)
+	EffectiveThresholds(rooms ...RoomThresholds) []*EffectiveThresholds
+	ChangeRoomsOptions(changes []RoomChange) error
+	CreateZone(name string, rooms []string) (*Zone, error)
+	GetZone(name string) (*Zone, error)
+	ListZones() ([]Zone, error)
+	SetZoneRooms(name string, rooms []string) (*Zone, error)
+	DeleteZone(name string) error
 	DeleteUser(username string) error
+	AddReading(source ReadingSource, reading Reading) error
+	GetReadings(source ReadingSource, room string, from, to time.Time) (Resolution, []ReadingBucket, error)
+	RollupReadings(source ReadingSource, now time.Time) (*RollupResult, error)
+	ExportReadings(source ReadingSource, room string, resolution Resolution, from, to time.Time, fn func(ReadingBucket) error) error
+	FetchWeather(provider utils.WeatherProvider, now time.Time) (*WeatherResult, error)
+	RoomSettingsHistory(rooms []string, from, to time.Time) ([]RoomSettingsChange, error)
+	RecordHeating(transition HeatingTransition) error
+	EnergyUsage(rooms []string, period EnergyPeriod, from, to time.Time) (*EnergyReport, error)
+	LoginAllowed(username, ip string) (time.Duration, error)
+	RecordLoginFailure(username, ip string) error
+	ResetLoginFailures(username string) error
+	SetPassword(username, newPassword string) error
+	EnrollMFA(username string) (string, error)
+	ConfirmMFA(username, code string) ([]string, error)
+	VerifyMFA(username, code string) error
+	DisableMFA(username string) error
+	CreateAPIKey(owner, name string, scopes []string, expiresAt time.Time) (*APIKey, string, error)
+	ListAPIKeys(owner string) ([]APIKey, error)
+	DeleteAPIKey(owner, id string) error
+	AuthenticateAPIKey(token string) (*APIKey, error)
+	CreateInvitation(createdBy, role string, expiresAt time.Time) (*Invitation, string, error)
+	ListInvitations() ([]Invitation, error)
+	DeleteInvitation(id string) error
+	AcceptInvitation(code, username, password string) (*User, error)
 }
 
 // DynamoDBInterface is an interface implemented by the dynamodb.Client that allow
@@ -41,6 +92,14 @@ type DynamoDBInterface interface {
 	GetItem(context.Context, *dynamodb.GetItemInput, ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
 	PutItem(context.Context, *dynamodb.PutItemInput, ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
 	DeleteItem(context.Context, *dynamodb.DeleteItemInput, ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
+	UpdateItem(context.Context, *dynamodb.UpdateItemInput, ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+	BatchGetItem(context.Context, *dynamodb.BatchGetItemInput, ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error)
+	TransactWriteItems(context.Context, *dynamodb.TransactWriteItemsInput, ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error)
+	Scan(context.Context, *dynamodb.ScanInput, ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error)
+	Query(context.Context, *dynamodb.QueryInput, ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+	BatchWriteItem(context.Context, *dynamodb.BatchWriteItemInput, ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error)
+	CreateTable(context.Context, *dynamodb.CreateTableInput, ...func(*dynamodb.Options)) (*dynamodb.CreateTableOutput, error)
+	DescribeTable(context.Context, *dynamodb.DescribeTableInput, ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error)
 }
 
 // SmartHome is a struct that defines the API actions for
@@ -65,6 +124,40 @@ type SmartHomeConfig struct {
 
 	// TempInsideTable is the name of the TemperatureInside table in DynamoDB
 	TempInsideTable string
+
+	// LoginAttemptsTable is the name of the LoginAttempts table in DynamoDB
+	LoginAttemptsTable string
+
+	// HeatingTable is the name of the HeatingTransitions table in DynamoDB
+	HeatingTable string
+
+	// HistoryTable is the name of the RoomSettingsHistory table in DynamoDB
+	HistoryTable string
+
+	// LoginLockout configures the throttling of failed login attempts
+	LoginLockout LoginLockout
+
+	// PasswordPolicy defines the requirements of the passwords of the users
+	PasswordPolicy PasswordPolicy
+
+	// BcryptCost is the cost used for hashing passwords
+	BcryptCost int
+
+	// Safety defines the limits of the thresholds of the rooms
+	Safety SafetyPolicy
+
+	// ThresholdPrecision is the step thresholds are rounded to, in Celsius
+	ThresholdPrecision float32
+
+	// Retention is how long temperature readings are kept at each resolution
+	Retention ReadingsRetention
+
+	// Energy defines how the energy used by the heaters is estimated
+	Energy EnergyConfig
+
+	// WeatherCompensation shifts the thresholds of some rooms depending on
+	// the outside temperature
+	WeatherCompensation map[string]WeatherCompensation
 }
 
 // Option is a function to apply settings to Scraper structure
@@ -79,6 +172,17 @@ func NewSmartHome(opts ...Option) *SmartHome {
 			ControlPlaneTable: DefaultControlPlaneTable,
 			TempOutsideTable:  DefaultTempOutsideTable,
 			TempInsideTable:   DefaultTempInsideTable,
+
+			LoginAttemptsTable: DefaultLoginAttemptsTable,
+			HeatingTable:       DefaultHeatingTable,
+			HistoryTable:       DefaultHistoryTable,
+			LoginLockout:       DefaultLoginLockout,
+			PasswordPolicy:     DefaultPasswordPolicy,
+			BcryptCost:         bcrypt.DefaultCost,
+			Safety:             DefaultSafetyPolicy,
+			ThresholdPrecision: DefaultThresholdPrecision,
+			Retention:          DefaultReadingsRetention,
+			Energy:             DefaultEnergyConfig,
 		},
 	}
 	for _, opt := range opts {
@@ -128,6 +232,50 @@ func SetConfig(c *SmartHomeConfig) Option {
 			c.TempInsideTable = DefaultTempInsideTable
 		}
 
+		if c.LoginAttemptsTable == "" {
+			c.LoginAttemptsTable = DefaultLoginAttemptsTable
+		}
+
+		if c.HeatingTable == "" {
+			c.HeatingTable = DefaultHeatingTable
+		}
+
+		if c.HistoryTable == "" {
+			c.HistoryTable = DefaultHistoryTable
+		}
+
+		if c.LoginLockout == (LoginLockout{}) {
+			c.LoginLockout = DefaultLoginLockout
+		}
+
+		if c.PasswordPolicy.MinLength == 0 {
+			c.PasswordPolicy.MinLength = DefaultPasswordMinLength
+		}
+
+		if c.BcryptCost == 0 {
+			c.BcryptCost = bcrypt.DefaultCost
+		}
+
+		if c.Safety.SafetyLimits == (SafetyLimits{}) {
+			c.Safety.SafetyLimits = DefaultSafetyPolicy.SafetyLimits
+		}
+
+		if c.ThresholdPrecision == 0 {
+			c.ThresholdPrecision = DefaultThresholdPrecision
+		}
+
+		if c.Retention == (ReadingsRetention{}) {
+			c.Retention = DefaultReadingsRetention
+		}
+
+		if c.Energy.DefaultWattage == 0 {
+			c.Energy.DefaultWattage = DefaultEnergyConfig.DefaultWattage
+		}
+
+		if c.Energy.Tariff.Location == nil {
+			c.Energy.Tariff.Location = DefaultEnergyConfig.Tariff.Location
+		}
+
 		s.Config = c
 		return SetConfig(prev)
 	}
@@ -10,6 +10,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
 )
 
 var defaultConfig *SmartHomeConfig = &SmartHomeConfig{
@@ -17,6 +18,17 @@ var defaultConfig *SmartHomeConfig = &SmartHomeConfig{
 	ControlPlaneTable: DefaultControlPlaneTable,
 	TempOutsideTable:  DefaultTempOutsideTable,
 	TempInsideTable:   DefaultTempInsideTable,
+
+	LoginAttemptsTable: DefaultLoginAttemptsTable,
+	HeatingTable:       DefaultHeatingTable,
+	HistoryTable:       DefaultHistoryTable,
+	LoginLockout:       DefaultLoginLockout,
+	PasswordPolicy:     DefaultPasswordPolicy,
+	BcryptCost:         bcrypt.DefaultCost,
+	Safety:             DefaultSafetyPolicy,
+	ThresholdPrecision: DefaultThresholdPrecision,
+	Retention:          DefaultReadingsRetention,
+	Energy:             DefaultEnergyConfig,
 }
 
 func getLocalClient() *dynamodb.Client {
@@ -87,6 +99,17 @@ func TestSetConfig(t *testing.T) {
 					ControlPlaneTable: "Control",
 					TempOutsideTable:  "Outside",
 					TempInsideTable:   "Inside",
+
+					LoginAttemptsTable: DefaultLoginAttemptsTable,
+					HeatingTable:       DefaultHeatingTable,
+					HistoryTable:       DefaultHistoryTable,
+					LoginLockout:       DefaultLoginLockout,
+					PasswordPolicy:     DefaultPasswordPolicy,
+					BcryptCost:         bcrypt.DefaultCost,
+					Safety:             DefaultSafetyPolicy,
+					ThresholdPrecision: DefaultThresholdPrecision,
+					Retention:          DefaultReadingsRetention,
+					Energy:             DefaultEnergyConfig,
 				},
 			},
 		},
@@ -105,6 +128,17 @@ func TestSetConfig(t *testing.T) {
 					ControlPlaneTable: DefaultControlPlaneTable,
 					TempOutsideTable:  DefaultTempOutsideTable,
 					TempInsideTable:   DefaultTempInsideTable,
+
+					LoginAttemptsTable: DefaultLoginAttemptsTable,
+					HeatingTable:       DefaultHeatingTable,
+					HistoryTable:       DefaultHistoryTable,
+					LoginLockout:       DefaultLoginLockout,
+					PasswordPolicy:     DefaultPasswordPolicy,
+					BcryptCost:         bcrypt.DefaultCost,
+					Safety:             DefaultSafetyPolicy,
+					ThresholdPrecision: DefaultThresholdPrecision,
+					Retention:          DefaultReadingsRetention,
+					Energy:             DefaultEnergyConfig,
 				},
 			},
 		},
@@ -123,6 +157,17 @@ func TestSetConfig(t *testing.T) {
 					ControlPlaneTable: DefaultControlPlaneTable,
 					TempOutsideTable:  DefaultTempOutsideTable,
 					TempInsideTable:   DefaultTempInsideTable,
+
+					LoginAttemptsTable: DefaultLoginAttemptsTable,
+					HeatingTable:       DefaultHeatingTable,
+					HistoryTable:       DefaultHistoryTable,
+					LoginLockout:       DefaultLoginLockout,
+					PasswordPolicy:     DefaultPasswordPolicy,
+					BcryptCost:         bcrypt.DefaultCost,
+					Safety:             DefaultSafetyPolicy,
+					ThresholdPrecision: DefaultThresholdPrecision,
+					Retention:          DefaultReadingsRetention,
+					Energy:             DefaultEnergyConfig,
 				},
 			},
 		},
@@ -141,6 +186,17 @@ func TestSetConfig(t *testing.T) {
 					ControlPlaneTable: DefaultControlPlaneTable,
 					TempOutsideTable:  DefaultTempOutsideTable,
 					TempInsideTable:   DefaultTempInsideTable,
+
+					LoginAttemptsTable: DefaultLoginAttemptsTable,
+					HeatingTable:       DefaultHeatingTable,
+					HistoryTable:       DefaultHistoryTable,
+					LoginLockout:       DefaultLoginLockout,
+					PasswordPolicy:     DefaultPasswordPolicy,
+					BcryptCost:         bcrypt.DefaultCost,
+					Safety:             DefaultSafetyPolicy,
+					ThresholdPrecision: DefaultThresholdPrecision,
+					Retention:          DefaultReadingsRetention,
+					Energy:             DefaultEnergyConfig,
 				},
 			},
 		},
@@ -159,6 +215,17 @@ func TestSetConfig(t *testing.T) {
 					ControlPlaneTable: DefaultControlPlaneTable,
 					TempOutsideTable:  DefaultTempOutsideTable,
 					TempInsideTable:   DefaultTempInsideTable,
+
+					LoginAttemptsTable: DefaultLoginAttemptsTable,
+					HeatingTable:       DefaultHeatingTable,
+					HistoryTable:       DefaultHistoryTable,
+					LoginLockout:       DefaultLoginLockout,
+					PasswordPolicy:     DefaultPasswordPolicy,
+					BcryptCost:         bcrypt.DefaultCost,
+					Safety:             DefaultSafetyPolicy,
+					ThresholdPrecision: DefaultThresholdPrecision,
+					Retention:          DefaultReadingsRetention,
+					Energy:             DefaultEnergyConfig,
 				},
 			},
 		},
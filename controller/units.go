@@ -0,0 +1,110 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// TemperatureUnit is the unit temperatures are shown and entered in.
+// Temperatures are always stored in Celsius.
+type TemperatureUnit string
+
+const (
+	// Celsius is the unit temperatures are stored in
+	Celsius TemperatureUnit = "celsius"
+
+	// Fahrenheit is the unit used by users in the US
+	Fahrenheit TemperatureUnit = "fahrenheit"
+)
+
+// DefaultThresholdPrecision is the step thresholds are rounded to unless
+// configured otherwise
+const DefaultThresholdPrecision float32 = 0.1
+
+// ParseTemperatureUnit returns the unit named by s, which can be its name or
+// its symbol in any case, e.g. fahrenheit, F or °F
+func ParseTemperatureUnit(s string) (TemperatureUnit, error) {
+	switch strings.TrimPrefix(strings.ToLower(strings.TrimSpace(s)), "°") {
+	case "c", string(Celsius):
+		return Celsius, nil
+	case "f", string(Fahrenheit):
+		return Fahrenheit, nil
+	}
+	return "", NewValidationError("invalid temperature unit %s. Valid units: %s, %s", s, Celsius, Fahrenheit)
+}
+
+// FromCelsius converts a temperature in Celsius to the unit, rounded to one
+// decimal
+func (u TemperatureUnit) FromCelsius(t float32) float32 {
+	if u == Fahrenheit {
+		t = t*9/5 + 32
+	}
+	return RoundTemperature(t, 0.1)
+}
+
+// ToCelsius converts a temperature in the unit to Celsius
+func (u TemperatureUnit) ToCelsius(t float32) float32 {
+	if u == Fahrenheit {
+		return (t - 32) * 5 / 9
+	}
+	return t
+}
+
+// RoundTemperature rounds a temperature to the nearest multiple of step
+func RoundTemperature(t, step float32) float32 {
+	if step <= 0 {
+		return t
+	}
+	// Steps such as 0.1 can't be represented exactly as float32, so they're
+	// converted to the closest float64 to their decimal value
+	s, _ := strconv.ParseFloat(strconv.FormatFloat(float64(step), 'g', -1, 32), 64)
+	return float32(math.Round(float64(t)/s) * s)
+}
+
+// ValidateThresholdPrecision returns an error unless the precision is a
+// positive multiple of 0.1 up to 1, such as 0.1 or 0.5, as thresholds are
+// stored with one decimal
+func ValidateThresholdPrecision(precision float32) error {
+	tenths := math.Round(float64(precision) * 10)
+	if precision <= 0 || precision > 1 || math.Abs(float64(precision)*10-tenths) > 1e-4 {
+		return NewValidationError("invalid threshold precision %.2f: it must be a multiple of 0.1 up to 1, such as 0.5", precision)
+	}
+	return nil
+}
+
+// SetTemperatureUnit stores the unit a user prefers temperatures in. The
+// user must exist.
+func (s *SmartHome) SetTemperatureUnit(username string, unit TemperatureUnit) error {
+	s.Debugw("setting temperature unit", "user", username, "unit", unit)
+	if unit != Celsius && unit != Fahrenheit {
+		return NewValidationError("invalid temperature unit %s. Valid units: %s, %s", unit, Celsius, Fahrenheit)
+	}
+	if isReservedUsername(username) {
+		return NewNotFoundError("user %s not found", username)
+	}
+	_, err := s.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName:           &s.Config.AuthTable,
+		Key:                 map[string]types.AttributeValue{"Username": &types.AttributeValueMemberS{Value: username}},
+		UpdateExpression:    aws.String("SET TemperatureUnit = :unit"),
+		ConditionExpression: aws.String("attribute_exists(Username)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":unit": &types.AttributeValueMemberS{Value: string(unit)},
+		},
+	})
+	var conditionErr *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionErr) {
+		return NewNotFoundError("user %s not found", username)
+	}
+	if err != nil {
+		return fmt.Errorf("error setting the temperature unit of user %s: %w", username, err)
+	}
+	return nil
+}
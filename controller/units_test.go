@@ -0,0 +1,141 @@
+package controller
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/igvaquero18/smarthome/utils/dynamotest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTemperatureUnit(t *testing.T) {
+	testCases := []struct {
+		name     string
+		unit     string
+		expected TemperatureUnit
+		err      error
+	}{
+		{name: "Celsius", unit: "celsius", expected: Celsius},
+		{name: "Celsius symbol", unit: "C", expected: Celsius},
+		{name: "Fahrenheit", unit: "Fahrenheit", expected: Fahrenheit},
+		{name: "Fahrenheit symbol with degrees", unit: " °F ", expected: Fahrenheit},
+		{name: "Kelvin", unit: "K", err: ErrValidation},
+		{name: "Empty", unit: "", err: ErrValidation},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			unit, err := ParseTemperatureUnit(tc.unit)
+			if tc.err != nil {
+				assert.True(tt, errors.Is(err, tc.err))
+				return
+			}
+			assert.NoError(tt, err)
+			assert.Equal(tt, tc.expected, unit)
+		})
+	}
+}
+
+func TestTemperatureUnitConversions(t *testing.T) {
+	testCases := []struct {
+		name    string
+		unit    TemperatureUnit
+		celsius float32
+		inUnit  float32
+	}{
+		{name: "Celsius", unit: Celsius, celsius: 21.5, inUnit: 21.5},
+		{name: "Fahrenheit freezing point", unit: Fahrenheit, celsius: 0, inUnit: 32},
+		{name: "Fahrenheit boiling point", unit: Fahrenheit, celsius: 100, inUnit: 212},
+		{name: "Fahrenheit negative", unit: Fahrenheit, celsius: -40, inUnit: -40},
+		{name: "Fahrenheit rounded to one decimal", unit: Fahrenheit, celsius: 19.5, inUnit: 67.1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			assert.Equal(tt, tc.inUnit, tc.unit.FromCelsius(tc.celsius))
+			assert.InDelta(tt, tc.celsius, tc.unit.ToCelsius(tc.inUnit), 0.05)
+		})
+	}
+}
+
+func TestRoundTemperature(t *testing.T) {
+	testCases := []struct {
+		name        string
+		temperature float32
+		step        float32
+		expected    float32
+	}{
+		{name: "Tenths", temperature: 21.1111, step: 0.1, expected: 21.1},
+		{name: "Halves rounded down", temperature: 21.1111, step: 0.5, expected: 21},
+		{name: "Halves rounded up", temperature: 21.3, step: 0.5, expected: 21.5},
+		{name: "Whole degrees", temperature: 20.5, step: 1, expected: 21},
+		{name: "No step", temperature: 21.1111, step: 0, expected: 21.1111},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			assert.Equal(tt, tc.expected, RoundTemperature(tc.temperature, tc.step))
+		})
+	}
+}
+
+func TestValidateThresholdPrecision(t *testing.T) {
+	for _, precision := range []float32{0.1, 0.2, 0.5, 1} {
+		assert.NoError(t, ValidateThresholdPrecision(precision), precision)
+	}
+	for _, precision := range []float32{0, -0.5, 0.25, 0.05, 2} {
+		assert.True(t, errors.Is(ValidateThresholdPrecision(precision), ErrValidation), precision)
+	}
+}
+
+func TestSetTemperatureUnit(t *testing.T) {
+	sh := NewSmartHome(
+		SetDynamoDBClient(dynamotest.NewClient(map[string]string{DefaultAuthTable: "Username"})),
+		SetLogger(mockLogger{}),
+		SetConfig(&SmartHomeConfig{BcryptCost: 4}),
+	)
+	assert.NoError(t, sh.SetCredentials("admin", "correct horse battery"))
+
+	user, err := sh.GetUser("admin")
+	assert.NoError(t, err)
+	assert.Equal(t, TemperatureUnit(""), user.TemperatureUnit)
+
+	assert.NoError(t, sh.SetTemperatureUnit("admin", Fahrenheit))
+	user, err = sh.GetUser("admin")
+	assert.NoError(t, err)
+	assert.Equal(t, Fahrenheit, user.TemperatureUnit)
+
+	assert.True(t, errors.Is(sh.SetTemperatureUnit("admin", "kelvin"), ErrValidation))
+	assert.True(t, errors.Is(sh.SetTemperatureUnit("nobody", Celsius), ErrNotFound))
+}
+
+func TestThresholdPrecision(t *testing.T) {
+	sh := NewSmartHome(
+		SetDynamoDBClient(dynamotest.NewClient(map[string]string{DefaultControlPlaneTable: "Room"})),
+		SetLogger(mockLogger{}),
+		SetConfig(&SmartHomeConfig{ThresholdPrecision: 0.5}),
+	)
+
+	// 68°F and 70°F
+	_, err := sh.SetRoomOptions("bedroom", true, Fahrenheit.ToCelsius(68), Fahrenheit.ToCelsius(70), AnyVersion)
+	assert.NoError(t, err)
+	item, err := sh.GetRoomOptions("bedroom")
+	assert.NoError(t, err)
+	assert.Equal(t, "20.0", item["ThresholdOn"].(*types.AttributeValueMemberN).Value)
+	assert.Equal(t, "21.0", item["ThresholdOff"].(*types.AttributeValueMemberN).Value)
+
+	thresholdOff := float32(21.8)
+	item, err = sh.UpdateRoomOptions("bedroom", RoomOptionsPatch{ThresholdOff: &thresholdOff}, AnyVersion)
+	assert.NoError(t, err)
+	assert.Equal(t, "22.0", item["ThresholdOff"].(*types.AttributeValueMemberN).Value)
+	assert.Equal(t, float32(21.8), thresholdOff)
+
+	thresholdOn := float32(19.2)
+	assert.NoError(t, sh.ChangeRoomsOptions([]RoomChange{
+		{Room: "bedroom", Action: RoomActionUpdate, Options: RoomOptionsPatch{ThresholdOn: &thresholdOn}},
+	}))
+	item, err = sh.GetRoomOptions("bedroom")
+	assert.NoError(t, err)
+	assert.Equal(t, "19.0", item["ThresholdOn"].(*types.AttributeValueMemberN).Value)
+}
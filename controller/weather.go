@@ -0,0 +1,81 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/igvaquero18/smarthome/utils"
+)
+
+// forecastPrefix is the prefix of the Date of the forecasts in the
+// TemperatureOutside table, so that they aren't mistaken for readings
+const forecastPrefix = "forecast#"
+
+// maxObservationSkew is how far in the future an observation of a weather
+// provider can be, for clocks that aren't in sync
+const maxObservationSkew = 5 * time.Minute
+
+// WeatherResult summarises a fetch of the weather
+type WeatherResult struct {
+	// Observations is the number of observations stored as outside readings
+	Observations int
+
+	// Forecasts is the number of forecasts stored
+	Forecasts int
+}
+
+// FetchWeather fetches the weather from a provider, storing its observations
+// as raw outside readings and its forecasts for times after now. Forecasts
+// replace earlier forecasts for the same time, and aren't rolled up.
+func (s *SmartHome) FetchWeather(provider utils.WeatherProvider, now time.Time) (*WeatherResult, error) {
+	report, err := provider.Fetch(context.TODO())
+	if err != nil {
+		return nil, err
+	}
+
+	result := &WeatherResult{}
+	for _, observation := range report.Observations {
+		if observation.Time.After(now.Add(maxObservationSkew)) {
+			s.Debugw("skipping observation in the future", "provider", provider.Name(), "time", observation.Time)
+			continue
+		}
+		if err := s.AddReading(SourceOutside, Reading{Time: observation.Time, Temperature: observation.Temperature}); err != nil {
+			return result, err
+		}
+		result.Observations++
+	}
+	for _, forecast := range report.Forecasts {
+		if !forecast.Time.After(now) {
+			continue
+		}
+		if err := s.addForecast(forecast, now); err != nil {
+			return result, err
+		}
+		result.Forecasts++
+	}
+
+	s.Debugw("fetched weather", "provider", provider.Name(), "observations", result.Observations, "forecasts", result.Forecasts)
+	return result, nil
+}
+
+// addForecast stores a forecast issued at some time, which expires after the
+// retention of raw readings once its time has passed
+func (s *SmartHome) addForecast(forecast utils.WeatherPoint, issuedAt time.Time) error {
+	item := map[string]types.AttributeValue{
+		"Date":        &types.AttributeValueMemberS{Value: forecastPrefix + forecast.Time.UTC().Format(time.RFC3339Nano)},
+		"Forecast":    &types.AttributeValueMemberBOOL{Value: true},
+		"Time":        unixAttribute(forecast.Time),
+		"Temperature": temperatureAttribute(forecast.Temperature),
+		"IssuedAt":    unixAttribute(issuedAt),
+	}
+	if s.Config.Retention.Raw != 0 {
+		item["ExpiresAt"] = unixAttribute(forecast.Time.Add(s.Config.Retention.Raw))
+	}
+	if _, err := s.PutItem(context.TODO(), &dynamodb.PutItemInput{TableName: &s.Config.TempOutsideTable, Item: item}); err != nil {
+		return fmt.Errorf("error storing forecast: %w", err)
+	}
+	return nil
+}
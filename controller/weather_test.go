@@ -0,0 +1,110 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/igvaquero18/smarthome/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockWeatherProvider struct {
+	report *utils.WeatherReport
+	err    error
+}
+
+func (m mockWeatherProvider) Name() string {
+	return "mock"
+}
+
+func (m mockWeatherProvider) Fetch(ctx context.Context) (*utils.WeatherReport, error) {
+	return m.report, m.err
+}
+
+func TestFetchWeather(t *testing.T) {
+	now := time.Date(2021, 1, 1, 10, 7, 0, 0, time.UTC)
+	testCases := []struct {
+		name             string
+		provider         mockWeatherProvider
+		expected         *WeatherResult
+		expectedBuckets  []ReadingBucket
+		expectedForecast map[string]string
+		expectedError    bool
+	}{
+		{
+			name: "Observations and forecasts",
+			provider: mockWeatherProvider{report: &utils.WeatherReport{
+				Observations: []utils.WeatherPoint{
+					{Time: now.Add(-6 * time.Minute), Temperature: -1},
+					{Time: now.Add(-4 * time.Minute), Temperature: 1.5},
+					{Time: now.Add(time.Hour), Temperature: 3},
+				},
+				Forecasts: []utils.WeatherPoint{
+					{Time: now.Add(-7 * time.Minute), Temperature: 0},
+					{Time: now.Add(53 * time.Minute), Temperature: 2.5},
+					{Time: now.Add(113 * time.Minute), Temperature: 4},
+				},
+			}},
+			expected: &WeatherResult{Observations: 2, Forecasts: 2},
+			expectedBuckets: []ReadingBucket{
+				{Start: time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC), Min: -1, Max: 1.5, Avg: 0.25, Count: 2},
+			},
+			expectedForecast: map[string]string{
+				"forecast#2021-01-01T11:00:00Z": "2.5",
+				"forecast#2021-01-01T12:00:00Z": "4",
+			},
+		},
+		{
+			name:            "Empty report",
+			provider:        mockWeatherProvider{report: &utils.WeatherReport{}},
+			expected:        &WeatherResult{},
+			expectedBuckets: []ReadingBucket{},
+		},
+		{
+			name:          "Error fetching",
+			provider:      mockWeatherProvider{err: errors.New("unexpected error")},
+			expectedError: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			client := newReadingsClient(map[string]string{DefaultTempOutsideTable: "Date"})
+			sh := NewSmartHome(SetDynamoDBClient(client), SetLogger(mockLogger{}))
+
+			result, err := sh.FetchWeather(tc.provider, now)
+			if tc.expectedError {
+				assert.Error(tt, err)
+				return
+			}
+			assert.NoError(tt, err)
+			assert.Equal(tt, tc.expected, result)
+
+			// Forecasts aren't rolled up as readings
+			rollup, err := sh.RollupReadings(SourceOutside, now.Add(10*time.Minute))
+			assert.NoError(tt, err)
+			assert.Equal(tt, tc.expected.Observations, rollup.Readings)
+			buckets := []ReadingBucket{}
+			err = sh.ExportReadings(SourceOutside, "", ResolutionFiveMinutes, now.Add(-time.Hour), now.Add(3*time.Hour), func(b ReadingBucket) error {
+				buckets = append(buckets, b)
+				return nil
+			})
+			assert.NoError(tt, err)
+			assert.Equal(tt, tc.expectedBuckets, buckets)
+
+			for key, temperature := range tc.expectedForecast {
+				output, err := client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+					TableName: &sh.Config.TempOutsideTable,
+					Key:       map[string]types.AttributeValue{"Date": &types.AttributeValueMemberS{Value: key}},
+				})
+				if assert.NoError(tt, err) && assert.NotNil(tt, output.Item, key) {
+					assert.Equal(tt, temperature, output.Item["Temperature"].(*types.AttributeValueMemberN).Value)
+					assert.Equal(tt, &types.AttributeValueMemberBOOL{Value: true}, output.Item["Forecast"])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/igvaquero18/smarthome/utils"
+)
+
+// zonePrefix is the prefix of the zone items in the ControlPlane table, which
+// can't be used by rooms.
+const zonePrefix = "zone#"
+
+// zoneNamePattern is the pattern zone names must match, such as "upstairs"
+// or "sleeping areas"
+var zoneNamePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9 _-]{0,62}[a-z0-9])?$`)
+
+// Zone is a named group of rooms whose options can be changed as a unit, such
+// as the rooms heated by the same boiler
+type Zone struct {
+	Name  string   `json:"name"`
+	Rooms []string `json:"rooms"`
+}
+
+// CreateZone creates a zone with the rooms passed as a parameter. The rooms
+// aren't checked, as the controller doesn't know which rooms are valid.
+func (s *SmartHome) CreateZone(name string, rooms []string) (*Zone, error) {
+	zone, err := newZone(name, rooms)
+	if err != nil {
+		return nil, err
+	}
+
+	s.Debugw("creating zone", "zone", name, "rooms", zone.Rooms)
+	_, err = s.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName:           &s.Config.ControlPlaneTable,
+		Item:                zone.item(),
+		ConditionExpression: aws.String("attribute_not_exists(Room)"),
+	})
+	var conditionErr *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionErr) {
+		return nil, NewConflictError("zone %s already exists", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error storing zone %s: %w", name, err)
+	}
+	return zone, nil
+}
+
+// GetZone returns a zone, or a NotFound error if it doesn't exist
+func (s *SmartHome) GetZone(name string) (*Zone, error) {
+	item, err := s.get("Room", zonePrefix+name, s.Config.ControlPlaneTable)
+	if err != nil {
+		return nil, fmt.Errorf("error getting zone %s: %w", name, err)
+	}
+	if item == nil {
+		return nil, NewNotFoundError("zone %s not found", name)
+	}
+	return zoneFromItem(item), nil
+}
+
+// ListZones returns every zone, sorted by name
+func (s *SmartHome) ListZones() ([]Zone, error) {
+	zones := []Zone{}
+	input := &dynamodb.ScanInput{
+		TableName:        &s.Config.ControlPlaneTable,
+		FilterExpression: aws.String("begins_with(Room, :prefix)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":prefix": &types.AttributeValueMemberS{Value: zonePrefix},
+		},
+	}
+	for {
+		output, err := s.Scan(context.TODO(), input)
+		if err != nil {
+			return nil, fmt.Errorf("error listing zones: %w", err)
+		}
+		for _, item := range output.Items {
+			zones = append(zones, *zoneFromItem(item))
+		}
+		if len(output.LastEvaluatedKey) == 0 {
+			break
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+	sort.Slice(zones, func(i, j int) bool { return zones[i].Name < zones[j].Name })
+	return zones, nil
+}
+
+// SetZoneRooms replaces the rooms of an existing zone
+func (s *SmartHome) SetZoneRooms(name string, rooms []string) (*Zone, error) {
+	zone, err := newZone(name, rooms)
+	if err != nil {
+		return nil, err
+	}
+
+	s.Debugw("updating zone", "zone", name, "rooms", zone.Rooms)
+	_, err = s.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName:           &s.Config.ControlPlaneTable,
+		Item:                zone.item(),
+		ConditionExpression: aws.String("attribute_exists(Room)"),
+	})
+	var conditionErr *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionErr) {
+		return nil, NewNotFoundError("zone %s not found", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error updating zone %s: %w", name, err)
+	}
+	return zone, nil
+}
+
+// DeleteZone deletes a zone. The options of its rooms are kept.
+func (s *SmartHome) DeleteZone(name string) error {
+	s.Debugw("deleting zone", "zone", name)
+	_, err := s.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		TableName:           &s.Config.ControlPlaneTable,
+		Key:                 roomKey(zonePrefix + name),
+		ConditionExpression: aws.String("attribute_exists(Room)"),
+	})
+	var conditionErr *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionErr) {
+		return NewNotFoundError("zone %s not found", name)
+	}
+	if err != nil {
+		return fmt.Errorf("error deleting zone %s: %w", name, err)
+	}
+	return nil
+}
+
+// newZone validates the name and the rooms of a zone, sorting its rooms and
+// removing duplicates. The options of every room of a zone are changed in a
+// single transaction, which limits the number of rooms.
+func newZone(name string, rooms []string) (*Zone, error) {
+	if !zoneNamePattern.MatchString(name) {
+		return nil, NewValidationError(
+			"invalid zone name %s: use up to 64 lowercase letters, digits, spaces, dashes and underscores",
+			name,
+		)
+	}
+
+	zone := &Zone{Name: name, Rooms: []string{}}
+	for _, room := range rooms {
+		if room == "" || strings.HasPrefix(room, zonePrefix) || strings.HasPrefix(room, historyPrefix) || strings.HasPrefix(room, heatingPrefix) {
+			return nil, NewValidationError("invalid room name %s", room)
+		}
+		if !utils.Contains(zone.Rooms, room) {
+			zone.Rooms = append(zone.Rooms, room)
+		}
+	}
+	if len(zone.Rooms) == 0 {
+		return nil, NewValidationError("zone %s must have at least one room", name)
+	}
+	if len(zone.Rooms) > maxTransactionItems {
+		return nil, NewValidationError("zone %s can't have more than %d rooms", name, maxTransactionItems)
+	}
+	sort.Strings(zone.Rooms)
+	return zone, nil
+}
+
+func (z *Zone) item() map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"Room":  &types.AttributeValueMemberS{Value: zonePrefix + z.Name},
+		"Rooms": &types.AttributeValueMemberSS{Value: z.Rooms},
+	}
+}
+
+func zoneFromItem(item map[string]types.AttributeValue) *Zone {
+	zone := &Zone{Rooms: []string{}}
+	if room, ok := item["Room"].(*types.AttributeValueMemberS); ok {
+		zone.Name = strings.TrimPrefix(room.Value, zonePrefix)
+	}
+	if rooms, ok := item["Rooms"].(*types.AttributeValueMemberSS); ok {
+		zone.Rooms = append(zone.Rooms, rooms.Value...)
+	}
+	sort.Strings(zone.Rooms)
+	return zone
+}
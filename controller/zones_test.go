@@ -0,0 +1,114 @@
+package controller
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/igvaquero18/smarthome/utils/dynamotest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateZone(t *testing.T) {
+	testCases := []struct {
+		name     string
+		zone     string
+		rooms    []string
+		expected *Zone
+		err      error
+	}{
+		{
+			name:     "Zone",
+			zone:     "sleeping areas",
+			rooms:    []string{"bedroom", "kids", "bedroom"},
+			expected: &Zone{Name: "sleeping areas", Rooms: []string{"bedroom", "kids"}},
+		},
+		{
+			name:  "Existing zone",
+			zone:  "upstairs",
+			rooms: []string{"bedroom"},
+			err:   ErrConflict,
+		},
+		{
+			name:  "Invalid name",
+			zone:  "Upstairs ",
+			rooms: []string{"bedroom"},
+			err:   ErrValidation,
+		},
+		{
+			name: "No rooms",
+			zone: "downstairs",
+			err:  ErrValidation,
+		},
+		{
+			name:  "Invalid room",
+			zone:  "downstairs",
+			rooms: []string{"zone#upstairs"},
+			err:   ErrValidation,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			sh := NewSmartHome(
+				SetDynamoDBClient(dynamotest.NewClient(map[string]string{DefaultControlPlaneTable: "Room"})),
+				SetLogger(mockLogger{}),
+			)
+			_, err := sh.CreateZone("upstairs", []string{"bedroom", "kids"})
+			assert.NoError(tt, err)
+
+			zone, err := sh.CreateZone(tc.zone, tc.rooms)
+			if tc.err != nil {
+				assert.True(tt, errors.Is(err, tc.err), "unexpected error %v", err)
+				return
+			}
+			assert.NoError(tt, err)
+			assert.Equal(tt, tc.expected, zone)
+
+			zone, err = sh.GetZone(tc.zone)
+			assert.NoError(tt, err)
+			assert.Equal(tt, tc.expected, zone)
+		})
+	}
+}
+
+func TestZones(t *testing.T) {
+	sh := NewSmartHome(
+		SetDynamoDBClient(dynamotest.NewClient(map[string]string{DefaultControlPlaneTable: "Room"})),
+		SetLogger(mockLogger{}),
+	)
+	_, err := sh.SetRoomOptions("bedroom", true, 19, 22, AnyVersion)
+	assert.NoError(t, err)
+
+	zones, err := sh.ListZones()
+	assert.NoError(t, err)
+	assert.Equal(t, []Zone{}, zones)
+
+	_, err = sh.CreateZone("upstairs", []string{"kids", "bedroom"})
+	assert.NoError(t, err)
+	_, err = sh.CreateZone("downstairs", []string{"livingroom"})
+	assert.NoError(t, err)
+
+	zones, err = sh.ListZones()
+	assert.NoError(t, err)
+	assert.Equal(t, []Zone{
+		{Name: "downstairs", Rooms: []string{"livingroom"}},
+		{Name: "upstairs", Rooms: []string{"bedroom", "kids"}},
+	}, zones)
+
+	zone, err := sh.SetZoneRooms("downstairs", []string{"livingroom", "kitchen"})
+	assert.NoError(t, err)
+	assert.Equal(t, &Zone{Name: "downstairs", Rooms: []string{"kitchen", "livingroom"}}, zone)
+
+	_, err = sh.SetZoneRooms("attic", []string{"kitchen"})
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	assert.NoError(t, sh.DeleteZone("downstairs"))
+	assert.True(t, errors.Is(sh.DeleteZone("downstairs"), ErrNotFound))
+	_, err = sh.GetZone("downstairs")
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	// Zones don't affect the options of their rooms
+	item, err := sh.GetRoomOptions("bedroom")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), RoomVersion(item))
+}
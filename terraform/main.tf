@@ -23,6 +23,9 @@ module "smarthome_table" {
 
   table_name = each.value.name
   hash_key = each.value.hash_key
+  range_key = each.value.range_key
+  ttl_attribute = each.value.ttl_attribute
 
   attributes = each.value.attributes
+  global_secondary_indexes = each.value.global_secondary_indexes
 }
@@ -10,6 +10,7 @@ resource "aws_dynamodb_table" "table" {
   read_capacity = 1
   write_capacity = 1
   hash_key = var.hash_key
+  range_key = var.range_key == "" ? null : var.range_key
 
   dynamic "attribute" {
     for_each = var.attributes
@@ -18,4 +19,28 @@ resource "aws_dynamodb_table" "table" {
       type = attribute.value["type"]
     }
   }
+
+  # Indexes are sparse: only the items with their keys are in them, e.g.
+  # the temperature readings that haven't been rolled up yet
+  dynamic "global_secondary_index" {
+    for_each = var.global_secondary_indexes
+    content {
+      name = global_secondary_index.value["name"]
+      hash_key = global_secondary_index.value["hash_key"]
+      range_key = global_secondary_index.value["range_key"]
+      projection_type = "ALL"
+      read_capacity = 1
+      write_capacity = 1
+    }
+  }
+
+  # Items expire once the time in this attribute, in seconds since the
+  # epoch, has passed, e.g. temperature readings that have been rolled up
+  dynamic "ttl" {
+    for_each = var.ttl_attribute == "" ? [] : [var.ttl_attribute]
+    content {
+      attribute_name = ttl.value
+      enabled = true
+    }
+  }
 }
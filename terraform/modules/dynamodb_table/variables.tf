@@ -16,6 +16,28 @@ variable "hash_key" {
   description = "The Hash Key for the DynamoDB table"
 }
 
+variable "range_key" {
+  type = string
+  default = ""
+  description = "The Range Key for the DynamoDB table. Defaults to none."
+}
+
+variable "ttl_attribute" {
+  type = string
+  default = ""
+  description = "The attribute with the expiration time of the items. Defaults to no expiration."
+}
+
+variable "global_secondary_indexes" {
+  type = list(object({
+    name = string
+    hash_key = string
+    range_key = string
+  }))
+  default = []
+  description = "The global secondary indexes of the DynamoDB table, which project every attribute. Defaults to none."
+}
+
 variable "read_capacity" {
   type = number
   default = 1
@@ -14,16 +14,25 @@ variable "dynamo_db_tables" {
   type = list(object({
     name = string
     hash_key = string
+    range_key = string
+    ttl_attribute = string
     attributes = list(object({
       name = string
       type = string
     }))
+    global_secondary_indexes = list(object({
+      name = string
+      hash_key = string
+      range_key = string
+    }))
   }))
 
   default = [
     {
       name = "ControlPlane"
       hash_key = "Room"
+      range_key = ""
+      ttl_attribute = ""
 
       attributes = [
         {
@@ -31,38 +40,125 @@ variable "dynamo_db_tables" {
           type = "S"
         }
       ]
+      global_secondary_indexes = []
     },
     {
       name = "Authentication"
       hash_key = "Username"
+      range_key = ""
+      ttl_attribute = ""
       attributes = [
         {
           name = "Username"
           type = "S"
         }
       ]
+      global_secondary_indexes = []
+    },
+    {
+      name = "LoginAttempts"
+      hash_key = "Key"
+      range_key = ""
+      ttl_attribute = "ExpiresAt"
+      attributes = [
+        {
+          name = "Key"
+          type = "S"
+        }
+      ]
+      global_secondary_indexes = []
     },
     {
       name = "TemperatureOutside"
       hash_key = "Date"
+      range_key = ""
+      ttl_attribute = "ExpiresAt"
 
       attributes = [
         {
           name = "Date"
           type = "S"
+        },
+        {
+          name = "Pending"
+          type = "S"
+        },
+        {
+          name = "Time"
+          type = "N"
+        }
+      ]
+      global_secondary_indexes = [
+        {
+          name = "PendingReadings"
+          hash_key = "Pending"
+          range_key = "Time"
         }
       ]
     },
     {
       name = "TemperatureInside"
       hash_key = "Date"
+      range_key = ""
+      ttl_attribute = "ExpiresAt"
 
       attributes = [
         {
           name = "Date"
           type = "S"
+        },
+        {
+          name = "Pending"
+          type = "S"
+        },
+        {
+          name = "Time"
+          type = "N"
+        }
+      ]
+      global_secondary_indexes = [
+        {
+          name = "PendingReadings"
+          hash_key = "Pending"
+          range_key = "Time"
+        }
+      ]
+    },
+    {
+      name = "HeatingTransitions"
+      hash_key = "Room"
+      range_key = "Time"
+      ttl_attribute = "ExpiresAt"
+
+      attributes = [
+        {
+          name = "Room"
+          type = "S"
+        },
+        {
+          name = "Time"
+          type = "N"
+        }
+      ]
+      global_secondary_indexes = []
+    },
+    {
+      name = "RoomSettingsHistory"
+      hash_key = "Room"
+      range_key = "Time"
+      ttl_attribute = ""
+
+      attributes = [
+        {
+          name = "Room"
+          type = "S"
+        },
+        {
+          name = "Time"
+          type = "N"
         }
       ]
+      global_secondary_indexes = []
     }
   ]
 }
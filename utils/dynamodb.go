@@ -2,11 +2,13 @@ package utils
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 )
 
 // InitDynamoClient returns a DynamoDB Client for the region and url specified
@@ -38,3 +40,149 @@ func InitDynamoClient(region, url string) (*dynamodb.Client, error) {
 	}
 	return dynamodb.NewFromConfig(cfg), nil
 }
+
+// MarshalItemJSON returns an item in the DynamoDB JSON format of the AWS CLI
+// and the DynamoDB exports, in which every value is an object whose only key
+// is its type, e.g. {"Room": {"S": "bedroom"}, "Enabled": {"BOOL": true}}
+func MarshalItemJSON(item map[string]types.AttributeValue) ([]byte, error) {
+	values, err := itemToJSON(item)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(values)
+}
+
+// UnmarshalItemJSON returns the item of a DynamoDB JSON object
+func UnmarshalItemJSON(data []byte) (map[string]types.AttributeValue, error) {
+	values := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("invalid DynamoDB JSON item: %w", err)
+	}
+	return itemFromJSON(values)
+}
+
+func itemToJSON(item map[string]types.AttributeValue) (map[string]interface{}, error) {
+	values := make(map[string]interface{}, len(item))
+	for name, attribute := range item {
+		value, err := attributeToJSON(attribute)
+		if err != nil {
+			return nil, fmt.Errorf("invalid attribute %s: %w", name, err)
+		}
+		values[name] = value
+	}
+	return values, nil
+}
+
+func attributeToJSON(attribute types.AttributeValue) (map[string]interface{}, error) {
+	switch v := attribute.(type) {
+	case *types.AttributeValueMemberS:
+		return map[string]interface{}{"S": v.Value}, nil
+	case *types.AttributeValueMemberN:
+		return map[string]interface{}{"N": v.Value}, nil
+	case *types.AttributeValueMemberB:
+		return map[string]interface{}{"B": v.Value}, nil
+	case *types.AttributeValueMemberBOOL:
+		return map[string]interface{}{"BOOL": v.Value}, nil
+	case *types.AttributeValueMemberNULL:
+		return map[string]interface{}{"NULL": v.Value}, nil
+	case *types.AttributeValueMemberSS:
+		return map[string]interface{}{"SS": v.Value}, nil
+	case *types.AttributeValueMemberNS:
+		return map[string]interface{}{"NS": v.Value}, nil
+	case *types.AttributeValueMemberBS:
+		return map[string]interface{}{"BS": v.Value}, nil
+	case *types.AttributeValueMemberL:
+		list := make([]interface{}, len(v.Value))
+		for i, element := range v.Value {
+			value, err := attributeToJSON(element)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = value
+		}
+		return map[string]interface{}{"L": list}, nil
+	case *types.AttributeValueMemberM:
+		m, err := itemToJSON(v.Value)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"M": m}, nil
+	}
+	return nil, fmt.Errorf("unsupported attribute value %T", attribute)
+}
+
+func itemFromJSON(values map[string]json.RawMessage) (map[string]types.AttributeValue, error) {
+	item := make(map[string]types.AttributeValue, len(values))
+	for name, value := range values {
+		attribute, err := attributeFromJSON(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid attribute %s: %w", name, err)
+		}
+		item[name] = attribute
+	}
+	return item, nil
+}
+
+func attributeFromJSON(data json.RawMessage) (types.AttributeValue, error) {
+	typed := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &typed); err != nil {
+		return nil, err
+	}
+	if len(typed) != 1 {
+		return nil, fmt.Errorf("attribute values must have exactly one type")
+	}
+	for kind, value := range typed {
+		switch kind {
+		case "S":
+			v := &types.AttributeValueMemberS{}
+			return v, json.Unmarshal(value, &v.Value)
+		case "N":
+			v := &types.AttributeValueMemberN{}
+			return v, json.Unmarshal(value, &v.Value)
+		case "B":
+			v := &types.AttributeValueMemberB{}
+			return v, json.Unmarshal(value, &v.Value)
+		case "BOOL":
+			v := &types.AttributeValueMemberBOOL{}
+			return v, json.Unmarshal(value, &v.Value)
+		case "NULL":
+			v := &types.AttributeValueMemberNULL{}
+			return v, json.Unmarshal(value, &v.Value)
+		case "SS":
+			v := &types.AttributeValueMemberSS{}
+			return v, json.Unmarshal(value, &v.Value)
+		case "NS":
+			v := &types.AttributeValueMemberNS{}
+			return v, json.Unmarshal(value, &v.Value)
+		case "BS":
+			v := &types.AttributeValueMemberBS{}
+			return v, json.Unmarshal(value, &v.Value)
+		case "L":
+			elements := []json.RawMessage{}
+			if err := json.Unmarshal(value, &elements); err != nil {
+				return nil, err
+			}
+			v := &types.AttributeValueMemberL{Value: make([]types.AttributeValue, len(elements))}
+			for i, element := range elements {
+				attribute, err := attributeFromJSON(element)
+				if err != nil {
+					return nil, err
+				}
+				v.Value[i] = attribute
+			}
+			return v, nil
+		case "M":
+			values := map[string]json.RawMessage{}
+			if err := json.Unmarshal(value, &values); err != nil {
+				return nil, err
+			}
+			m, err := itemFromJSON(values)
+			if err != nil {
+				return nil, err
+			}
+			return &types.AttributeValueMemberM{Value: m}, nil
+		}
+		return nil, fmt.Errorf("unknown attribute type %s", kind)
+	}
+	return nil, nil
+}
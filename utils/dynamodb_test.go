@@ -7,6 +7,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -66,3 +67,31 @@ func TestInitDynamoClient(t *testing.T) {
 		})
 	}
 }
+
+func TestItemJSON(t *testing.T) {
+	item := map[string]types.AttributeValue{
+		"Room":     &types.AttributeValueMemberS{Value: "bedroom"},
+		"Version":  &types.AttributeValueMemberN{Value: "3"},
+		"Enabled":  &types.AttributeValueMemberBOOL{Value: true},
+		"Secret":   &types.AttributeValueMemberB{Value: []byte{0, 1, 2}},
+		"Nothing":  &types.AttributeValueMemberNULL{Value: true},
+		"Rooms":    &types.AttributeValueMemberSS{Value: []string{"bedroom", "kitchen"}},
+		"Codes":    &types.AttributeValueMemberNS{Value: []string{"1", "2"}},
+		"Hashes":   &types.AttributeValueMemberBS{Value: [][]byte{{1}, {2}}},
+		"Readings": &types.AttributeValueMemberL{Value: []types.AttributeValue{&types.AttributeValueMemberN{Value: "20.5"}}},
+		"Options":  &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{"Enabled": &types.AttributeValueMemberBOOL{Value: false}}},
+	}
+	data, err := MarshalItemJSON(item)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"Room":{"S":"bedroom"}`)
+	assert.Contains(t, string(data), `"Options":{"M":{"Enabled":{"BOOL":false}}}`)
+
+	decoded, err := UnmarshalItemJSON(data)
+	assert.NoError(t, err)
+	assert.Equal(t, item, decoded)
+
+	for _, invalid := range []string{`[]`, `{"Room": "bedroom"}`, `{"Room": {"S": "a", "N": "1"}}`, `{"Room": {"X": "a"}}`, `{"Room": {"N": 1}}`} {
+		_, err := UnmarshalItemJSON([]byte(invalid))
+		assert.Error(t, err, invalid)
+	}
+}
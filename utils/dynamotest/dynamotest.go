@@ -0,0 +1,915 @@
+// Package dynamotest provides an in-memory DynamoDB client for tests
+package dynamotest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Client is an in-memory DynamoDB client implementing the item, batch, query
+// and transaction operations used by the SmartHome controller. It understands the subset of condition
+// and update expressions the controller uses: AND/OR of attribute_exists,
+// attribute_not_exists, begins_with, contains, comparisons and BETWEEN in conditions,
+// and SET, REMOVE, ADD (of numbers) and DELETE clauses in updates, with
+// attribute names that may be placeholders of ExpressionAttributeNames.
+type Client struct {
+	mu      sync.Mutex
+	keys    map[string]string
+	ranges  map[string]string
+	indexes map[string]map[string]keySchema
+	tables  map[string]map[string]map[string]types.AttributeValue
+
+	// throttled is the number of requests left to fail with a
+	// ProvisionedThroughputExceededException
+	throttled int
+
+	// batchWriteLimit is the maximum number of writes processed by a
+	// BatchWriteItem request, or 0 for no limit
+	batchWriteLimit int
+}
+
+// keySchema are the hash key and the optional range key of a table or index
+type keySchema struct {
+	hash string
+	rng  string
+}
+
+// NewClient returns an empty client. keys maps every table name to the name
+// of its hash key, which must be a string attribute.
+func NewClient(keys map[string]string) *Client {
+	return &Client{
+		keys:    keys,
+		ranges:  map[string]string{},
+		indexes: map[string]map[string]keySchema{},
+		tables:  map[string]map[string]map[string]types.AttributeValue{},
+	}
+}
+
+// SetRangeKey gives a table a range key, which must be a string or number
+// attribute. It has to be called before any item is stored in the table.
+func (c *Client) SetRangeKey(table, rangeKey string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ranges[table] = rangeKey
+}
+
+// AddIndex adds a global secondary index to a table, to be queried with
+// Query. Items without the keys of the index aren't in it, as in DynamoDB.
+// The range key is optional.
+func (c *Client) AddIndex(table, index, hashKey, rangeKey string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.indexes[table] == nil {
+		c.indexes[table] = map[string]keySchema{}
+	}
+	c.indexes[table][index] = keySchema{hash: hashKey, rng: rangeKey}
+}
+
+// Throttle makes the next n Scan and BatchWriteItem requests fail with a
+// ProvisionedThroughputExceededException, as DynamoDB does when the
+// capacity of a table is exceeded
+func (c *Client) Throttle(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.throttled = n
+}
+
+// SetBatchWriteLimit makes BatchWriteItem process at most n writes, leaving
+// the rest unprocessed
+func (c *Client) SetBatchWriteLimit(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.batchWriteLimit = n
+}
+
+// throttle returns an error if the request must be throttled
+func (c *Client) throttle() error {
+	if c.throttled == 0 {
+		return nil
+	}
+	c.throttled--
+	return &types.ProvisionedThroughputExceededException{Message: aws.String("throughput exceeded")}
+}
+
+// Items returns a copy of the items of a table, sorted by their key
+func (c *Client) Items(table string) []map[string]types.AttributeValue {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.items(table)
+}
+
+func (c *Client) items(table string) []map[string]types.AttributeValue {
+	keys := []string{}
+	for k := range c.tables[table] {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	items := []map[string]types.AttributeValue{}
+	for _, k := range keys {
+		items = append(items, copyItem(c.tables[table][k]))
+	}
+	return items
+}
+
+// GetItem returns the item with the key of the input, if any
+func (c *Client) GetItem(ctx context.Context, input *dynamodb.GetItemInput, opts ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	table, key, err := c.key(input.TableName, input.Key)
+	if err != nil {
+		return nil, err
+	}
+	item, ok := table[key]
+	if !ok {
+		return &dynamodb.GetItemOutput{}, nil
+	}
+	return &dynamodb.GetItemOutput{Item: copyItem(item)}, nil
+}
+
+// PutItem stores the item of the input, replacing any item with the same key
+func (c *Client) PutItem(ctx context.Context, input *dynamodb.PutItemInput, opts ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	table, key, err := c.key(input.TableName, input.Item)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkCondition(withNames(input.ConditionExpression, input.ExpressionAttributeNames), table[key], input.ExpressionAttributeValues); err != nil {
+		return nil, err
+	}
+	table[key] = copyItem(input.Item)
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+// DeleteItem deletes the item with the key of the input, if any
+func (c *Client) DeleteItem(ctx context.Context, input *dynamodb.DeleteItemInput, opts ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	table, key, err := c.key(input.TableName, input.Key)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkCondition(withNames(input.ConditionExpression, input.ExpressionAttributeNames), table[key], input.ExpressionAttributeValues); err != nil {
+		return nil, err
+	}
+	delete(table, key)
+	return &dynamodb.DeleteItemOutput{}, nil
+}
+
+// UpdateItem updates the item with the key of the input, creating it if
+// needed, and returns the updated item if ReturnValues is ALL_NEW
+func (c *Client) UpdateItem(ctx context.Context, input *dynamodb.UpdateItemInput, opts ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	table, key, err := c.key(input.TableName, input.Key)
+	if err != nil {
+		return nil, err
+	}
+	item, exists := table[key]
+	if err := checkCondition(withNames(input.ConditionExpression, input.ExpressionAttributeNames), item, input.ExpressionAttributeValues); err != nil {
+		return nil, err
+	}
+	if !exists {
+		item = copyItem(input.Key)
+	} else {
+		item = copyItem(item)
+	}
+	if input.UpdateExpression != nil {
+		if err := update(*withNames(input.UpdateExpression, input.ExpressionAttributeNames), item, input.ExpressionAttributeValues); err != nil {
+			return nil, err
+		}
+	}
+	table[key] = item
+	if input.ReturnValues == types.ReturnValueAllNew {
+		return &dynamodb.UpdateItemOutput{Attributes: copyItem(item)}, nil
+	}
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+// BatchGetItem returns the items with the keys of the input, processing all
+// of them at once
+func (c *Client) BatchGetItem(ctx context.Context, input *dynamodb.BatchGetItemInput, opts ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	output := &dynamodb.BatchGetItemOutput{Responses: map[string][]map[string]types.AttributeValue{}}
+	for tableName, keys := range input.RequestItems {
+		tableName := tableName
+		for _, k := range keys.Keys {
+			table, key, err := c.key(&tableName, k)
+			if err != nil {
+				return nil, err
+			}
+			if item, ok := table[key]; ok {
+				output.Responses[tableName] = append(output.Responses[tableName], copyItem(item))
+			}
+		}
+	}
+	return output, nil
+}
+
+// TransactWriteItems applies all the writes of the input or none of them. If
+// any condition doesn't hold, it returns a TransactionCanceledException whose
+// cancellation reasons tell which one.
+func (c *Client) TransactWriteItems(ctx context.Context, input *dynamodb.TransactWriteItemsInput, opts ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	type write struct {
+		table map[string]map[string]types.AttributeValue
+		key   string
+		item  map[string]types.AttributeValue
+	}
+	writes := []write{}
+	seen := map[string]bool{}
+	reasons := make([]types.CancellationReason, len(input.TransactItems))
+	canceled := false
+	for i, t := range input.TransactItems {
+		var (
+			tableName, condition *string
+			keyItem, values      map[string]types.AttributeValue
+			names                map[string]string
+		)
+		switch {
+		case t.ConditionCheck != nil:
+			tableName, keyItem = t.ConditionCheck.TableName, t.ConditionCheck.Key
+			condition, values = t.ConditionCheck.ConditionExpression, t.ConditionCheck.ExpressionAttributeValues
+			names = t.ConditionCheck.ExpressionAttributeNames
+		case t.Put != nil:
+			tableName, keyItem = t.Put.TableName, t.Put.Item
+			condition, values = t.Put.ConditionExpression, t.Put.ExpressionAttributeValues
+			names = t.Put.ExpressionAttributeNames
+		case t.Delete != nil:
+			tableName, keyItem = t.Delete.TableName, t.Delete.Key
+			condition, values = t.Delete.ConditionExpression, t.Delete.ExpressionAttributeValues
+			names = t.Delete.ExpressionAttributeNames
+		case t.Update != nil:
+			tableName, keyItem = t.Update.TableName, t.Update.Key
+			condition, values = t.Update.ConditionExpression, t.Update.ExpressionAttributeValues
+			names = t.Update.ExpressionAttributeNames
+		default:
+			return nil, fmt.Errorf("empty transaction item %d", i)
+		}
+		table, key, err := c.key(tableName, keyItem)
+		if err != nil {
+			return nil, err
+		}
+		if seen[*tableName+"/"+key] {
+			return nil, fmt.Errorf("transaction request cannot include multiple operations on one item")
+		}
+		seen[*tableName+"/"+key] = true
+
+		reasons[i].Code = aws.String("None")
+		var conditionErr *types.ConditionalCheckFailedException
+		if err := checkCondition(withNames(condition, names), table[key], values); errors.As(err, &conditionErr) {
+			reasons[i].Code = aws.String("ConditionalCheckFailed")
+			canceled = true
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case t.Put != nil:
+			writes = append(writes, write{table: table, key: key, item: copyItem(t.Put.Item)})
+		case t.Delete != nil:
+			writes = append(writes, write{table: table, key: key})
+		case t.Update != nil:
+			item, exists := table[key]
+			if exists {
+				item = copyItem(item)
+			} else {
+				item = copyItem(t.Update.Key)
+			}
+			if err := update(*withNames(t.Update.UpdateExpression, names), item, values); err != nil {
+				return nil, err
+			}
+			writes = append(writes, write{table: table, key: key, item: item})
+		}
+	}
+	if canceled {
+		return nil, &types.TransactionCanceledException{
+			Message:             aws.String("Transaction cancelled, please refer cancellation reasons for specific reasons"),
+			CancellationReasons: reasons,
+		}
+	}
+
+	for _, w := range writes {
+		if w.item == nil {
+			delete(w.table, w.key)
+		} else {
+			w.table[w.key] = w.item
+		}
+	}
+	return &dynamodb.TransactWriteItemsOutput{}, nil
+}
+
+// Scan returns the items of the table matching the filter of the input, by
+// their key. Items are returned in pages of Limit items, if set.
+func (c *Client) Scan(ctx context.Context, input *dynamodb.ScanInput, opts ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	if input.TableName == nil {
+		return nil, fmt.Errorf("missing table name")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.keys[*input.TableName]; !ok {
+		return nil, &types.ResourceNotFoundException{Message: input.TableName}
+	}
+	if err := c.throttle(); err != nil {
+		return nil, err
+	}
+
+	items := c.items(*input.TableName)
+	if input.ExclusiveStartKey != nil {
+		start, err := c.itemKey(*input.TableName, input.ExclusiveStartKey)
+		if err != nil {
+			return nil, err
+		}
+		i := sort.Search(len(items), func(i int) bool {
+			key, _ := c.itemKey(*input.TableName, items[i])
+			return key > start
+		})
+		items = items[i:]
+	}
+	output := &dynamodb.ScanOutput{}
+	filter := withNames(input.FilterExpression, input.ExpressionAttributeNames)
+	for i, item := range items {
+		if input.Limit != nil && int32(i) == *input.Limit {
+			output.LastEvaluatedKey = c.keyAttributes(*input.TableName, keySchema{}, items[i-1])
+			break
+		}
+		if filter != nil {
+			ok, err := evaluate(*filter, item, input.ExpressionAttributeValues)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+		}
+		output.Items = append(output.Items, item)
+	}
+	output.Count = int32(len(output.Items))
+	return output, nil
+}
+
+// Query returns the items of the table, or of one of its indexes, matching
+// the key condition and the filter of the input, sorted by their range key.
+// Items are returned in pages of Limit items, if set.
+func (c *Client) Query(ctx context.Context, input *dynamodb.QueryInput, opts ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	if input.TableName == nil {
+		return nil, fmt.Errorf("missing table name")
+	}
+	if input.KeyConditionExpression == nil {
+		return nil, fmt.Errorf("missing key condition")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	hashKey, ok := c.keys[*input.TableName]
+	if !ok {
+		return nil, &types.ResourceNotFoundException{Message: input.TableName}
+	}
+	schema := keySchema{hash: hashKey, rng: c.ranges[*input.TableName]}
+	var index keySchema
+	if input.IndexName != nil {
+		if index, ok = c.indexes[*input.TableName][*input.IndexName]; !ok {
+			return nil, &types.ResourceNotFoundException{Message: input.IndexName}
+		}
+		schema = index
+	}
+	if err := c.throttle(); err != nil {
+		return nil, err
+	}
+
+	condition := *withNames(input.KeyConditionExpression, input.ExpressionAttributeNames)
+	if !strings.HasPrefix(condition, schema.hash+" = ") {
+		return nil, fmt.Errorf("the key condition %s must start with an equality on the hash key %s", condition, schema.hash)
+	}
+	items := []map[string]types.AttributeValue{}
+	for _, item := range c.items(*input.TableName) {
+		if _, ok := item[schema.hash]; !ok {
+			continue
+		}
+		if _, ok := item[schema.rng]; schema.rng != "" && !ok {
+			continue
+		}
+		ok, err := evaluate(condition, item, input.ExpressionAttributeValues)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			items = append(items, item)
+		}
+	}
+	if schema.rng != "" {
+		sort.SliceStable(items, func(i, j int) bool {
+			cmp, _ := compare(items[i][schema.rng], items[j][schema.rng])
+			if input.ScanIndexForward != nil && !*input.ScanIndexForward {
+				return cmp > 0
+			}
+			return cmp < 0
+		})
+	}
+	if input.ExclusiveStartKey != nil {
+		start, err := c.itemKey(*input.TableName, input.ExclusiveStartKey)
+		if err != nil {
+			return nil, err
+		}
+		for i, item := range items {
+			if key, _ := c.itemKey(*input.TableName, item); key == start {
+				items = items[i+1:]
+				break
+			}
+		}
+	}
+
+	output := &dynamodb.QueryOutput{}
+	filter := withNames(input.FilterExpression, input.ExpressionAttributeNames)
+	for i, item := range items {
+		if input.Limit != nil && int32(i) == *input.Limit {
+			output.LastEvaluatedKey = c.keyAttributes(*input.TableName, index, items[i-1])
+			break
+		}
+		if filter != nil {
+			ok, err := evaluate(*filter, item, input.ExpressionAttributeValues)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+		}
+		output.Items = append(output.Items, item)
+	}
+	output.Count = int32(len(output.Items))
+	return output, nil
+}
+
+// BatchWriteItem applies the puts and deletes of the input, returning those
+// beyond the limit set by SetBatchWriteLimit as unprocessed
+func (c *Client) BatchWriteItem(ctx context.Context, input *dynamodb.BatchWriteItemInput, opts ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.throttle(); err != nil {
+		return nil, err
+	}
+
+	output := &dynamodb.BatchWriteItemOutput{UnprocessedItems: map[string][]types.WriteRequest{}}
+	processed := 0
+	tableNames := []string{}
+	for tableName := range input.RequestItems {
+		tableNames = append(tableNames, tableName)
+	}
+	sort.Strings(tableNames)
+	for _, tableName := range tableNames {
+		tableName := tableName
+		for _, request := range input.RequestItems[tableName] {
+			if c.batchWriteLimit > 0 && processed == c.batchWriteLimit {
+				output.UnprocessedItems[tableName] = append(output.UnprocessedItems[tableName], request)
+				continue
+			}
+			switch {
+			case request.PutRequest != nil:
+				table, key, err := c.key(&tableName, request.PutRequest.Item)
+				if err != nil {
+					return nil, err
+				}
+				table[key] = copyItem(request.PutRequest.Item)
+			case request.DeleteRequest != nil:
+				table, key, err := c.key(&tableName, request.DeleteRequest.Key)
+				if err != nil {
+					return nil, err
+				}
+				delete(table, key)
+			default:
+				return nil, fmt.Errorf("empty write request")
+			}
+			processed++
+		}
+	}
+	return output, nil
+}
+
+// CreateTable creates an empty table with the hash key, the optional range
+// key and the global secondary indexes of the input
+func (c *Client) CreateTable(ctx context.Context, input *dynamodb.CreateTableInput, opts ...func(*dynamodb.Options)) (*dynamodb.CreateTableOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	schema, ok := schemaOf(input.KeySchema)
+	if input.TableName == nil || !ok {
+		return nil, fmt.Errorf("a table name and a hash key are required")
+	}
+	if _, ok := c.keys[*input.TableName]; ok {
+		return nil, &types.ResourceInUseException{Message: aws.String("Table already exists: " + *input.TableName)}
+	}
+	indexes := map[string]keySchema{}
+	for _, index := range input.GlobalSecondaryIndexes {
+		indexSchema, ok := schemaOf(index.KeySchema)
+		if index.IndexName == nil || !ok {
+			return nil, fmt.Errorf("an index name and a hash key are required")
+		}
+		indexes[*index.IndexName] = indexSchema
+	}
+	if c.keys == nil {
+		c.keys = map[string]string{}
+	}
+	c.keys[*input.TableName] = schema.hash
+	if schema.rng != "" {
+		c.ranges[*input.TableName] = schema.rng
+	}
+	if len(indexes) > 0 {
+		c.indexes[*input.TableName] = indexes
+	}
+	return &dynamodb.CreateTableOutput{}, nil
+}
+
+// DescribeTable returns the name, the key schema, the global secondary
+// indexes and the status of a table, which is always active
+func (c *Client) DescribeTable(ctx context.Context, input *dynamodb.DescribeTableInput, opts ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	hashKey, ok := c.keys[aws.ToString(input.TableName)]
+	if !ok {
+		return nil, &types.ResourceNotFoundException{Message: input.TableName}
+	}
+	description := &types.TableDescription{
+		TableName:   input.TableName,
+		KeySchema:   keySchema{hash: hashKey, rng: c.ranges[*input.TableName]}.elements(),
+		TableStatus: types.TableStatusActive,
+	}
+	names := []string{}
+	for name := range c.indexes[*input.TableName] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		description.GlobalSecondaryIndexes = append(description.GlobalSecondaryIndexes, types.GlobalSecondaryIndexDescription{
+			IndexName:   aws.String(name),
+			KeySchema:   c.indexes[*input.TableName][name].elements(),
+			IndexStatus: types.IndexStatusActive,
+		})
+	}
+	return &dynamodb.DescribeTableOutput{Table: description}, nil
+}
+
+// schemaOf returns the keys of a key schema, which must have a hash key
+func schemaOf(elements []types.KeySchemaElement) (keySchema, bool) {
+	schema := keySchema{}
+	for _, e := range elements {
+		switch {
+		case e.AttributeName == nil:
+			return schema, false
+		case e.KeyType == types.KeyTypeHash:
+			schema.hash = *e.AttributeName
+		case e.KeyType == types.KeyTypeRange:
+			schema.rng = *e.AttributeName
+		}
+	}
+	return schema, schema.hash != ""
+}
+
+// elements returns the key schema of the keys
+func (k keySchema) elements() []types.KeySchemaElement {
+	elements := []types.KeySchemaElement{{AttributeName: aws.String(k.hash), KeyType: types.KeyTypeHash}}
+	if k.rng != "" {
+		elements = append(elements, types.KeySchemaElement{AttributeName: aws.String(k.rng), KeyType: types.KeyTypeRange})
+	}
+	return elements
+}
+
+func (c *Client) key(tableName *string, item map[string]types.AttributeValue) (map[string]map[string]types.AttributeValue, string, error) {
+	if tableName == nil {
+		return nil, "", fmt.Errorf("missing table name")
+	}
+	key, err := c.itemKey(*tableName, item)
+	if err != nil {
+		return nil, "", err
+	}
+	if c.tables[*tableName] == nil {
+		c.tables[*tableName] = map[string]map[string]types.AttributeValue{}
+	}
+	return c.tables[*tableName], key, nil
+}
+
+// itemKey returns the key an item is stored with in a table: the value of
+// its hash key, followed by the value of its range key if the table has one
+func (c *Client) itemKey(table string, item map[string]types.AttributeValue) (string, error) {
+	hashKey, ok := c.keys[table]
+	if !ok {
+		return "", &types.ResourceNotFoundException{Message: aws.String(table)}
+	}
+	key, ok := item[hashKey].(*types.AttributeValueMemberS)
+	if !ok {
+		return "", fmt.Errorf("missing string key %s of table %s", hashKey, table)
+	}
+	rangeKey, ok := c.ranges[table]
+	if !ok {
+		return key.Value, nil
+	}
+	switch value := item[rangeKey].(type) {
+	case *types.AttributeValueMemberS:
+		return key.Value + "\x00" + value.Value, nil
+	case *types.AttributeValueMemberN:
+		return key.Value + "\x00" + value.Value, nil
+	}
+	return "", fmt.Errorf("missing range key %s of table %s", rangeKey, table)
+}
+
+// keyAttributes returns the attributes of the keys of an item in a table
+// and in one of its indexes, as in a LastEvaluatedKey
+func (c *Client) keyAttributes(table string, index keySchema, item map[string]types.AttributeValue) map[string]types.AttributeValue {
+	key := map[string]types.AttributeValue{}
+	for _, attribute := range []string{c.keys[table], c.ranges[table], index.hash, index.rng} {
+		if value, ok := item[attribute]; ok && attribute != "" {
+			key[attribute] = value
+		}
+	}
+	return key
+}
+
+// withNames returns an expression with the placeholders of attribute names
+// replaced by the names, longest placeholders first so that #a doesn't
+// replace the start of #ab
+func withNames(expression *string, names map[string]string) *string {
+	if expression == nil || len(names) == 0 {
+		return expression
+	}
+	placeholders := make([]string, 0, len(names))
+	for placeholder := range names {
+		placeholders = append(placeholders, placeholder)
+	}
+	sort.Slice(placeholders, func(i, j int) bool { return len(placeholders[i]) > len(placeholders[j]) })
+	replaced := *expression
+	for _, placeholder := range placeholders {
+		replaced = strings.ReplaceAll(replaced, placeholder, names[placeholder])
+	}
+	return &replaced
+}
+
+func checkCondition(condition *string, item map[string]types.AttributeValue, values map[string]types.AttributeValue) error {
+	if condition == nil {
+		return nil
+	}
+	ok, err := evaluate(*condition, item, values)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		message := "The conditional request failed"
+		return &types.ConditionalCheckFailedException{Message: &message}
+	}
+	return nil
+}
+
+// evaluate evaluates a condition without parentheses, in which AND takes
+// precedence over OR
+func evaluate(expression string, item map[string]types.AttributeValue, values map[string]types.AttributeValue) (bool, error) {
+	for _, disjunct := range strings.Split(expression, " OR ") {
+		matches := true
+		terms := strings.Split(disjunct, " AND ")
+		for i := 0; i < len(terms); i++ {
+			term := strings.TrimSpace(terms[i])
+			// The AND of a BETWEEN separates its bounds rather than terms
+			if bounds := strings.SplitN(term, " BETWEEN ", 2); len(bounds) == 2 && i+1 < len(terms) {
+				i++
+				term = fmt.Sprintf("%s >= %s AND %s <= %s", bounds[0], bounds[1], bounds[0], strings.TrimSpace(terms[i]))
+				ok, err := evaluate(term, item, values)
+				if err != nil {
+					return false, err
+				}
+				matches = matches && ok
+				continue
+			}
+			ok, err := evaluateTerm(term, item, values)
+			if err != nil {
+				return false, err
+			}
+			matches = matches && ok
+		}
+		if matches {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func evaluateTerm(term string, item map[string]types.AttributeValue, values map[string]types.AttributeValue) (bool, error) {
+	if open := strings.Index(term, "("); open > 0 && strings.HasSuffix(term, ")") {
+		args := strings.Split(term[open+1:len(term)-1], ",")
+		for i := range args {
+			args[i] = strings.TrimSpace(args[i])
+		}
+		attribute, exists := item[args[0]]
+		switch function := term[:open]; {
+		case function == "attribute_exists" && len(args) == 1:
+			return exists, nil
+		case function == "attribute_not_exists" && len(args) == 1:
+			return !exists, nil
+		case function == "begins_with" && len(args) == 2:
+			prefix, ok := values[args[1]].(*types.AttributeValueMemberS)
+			s, isString := attribute.(*types.AttributeValueMemberS)
+			return ok && isString && strings.HasPrefix(s.Value, prefix.Value), nil
+		case function == "contains" && len(args) == 2:
+			return contains(attribute, values[args[1]]), nil
+		default:
+			return false, fmt.Errorf("unsupported condition %s", term)
+		}
+	}
+
+	for _, operator := range []string{"<=", ">=", "<>", "=", "<", ">"} {
+		parts := strings.SplitN(term, " "+operator+" ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		cmp, ok := compare(item[parts[0]], values[strings.TrimSpace(parts[1])])
+		if !ok {
+			return operator == "<>", nil
+		}
+		switch operator {
+		case "=":
+			return cmp == 0, nil
+		case "<>":
+			return cmp != 0, nil
+		case "<":
+			return cmp < 0, nil
+		case "<=":
+			return cmp <= 0, nil
+		case ">":
+			return cmp > 0, nil
+		default:
+			return cmp >= 0, nil
+		}
+	}
+	return false, fmt.Errorf("unsupported condition %s", term)
+}
+
+// update applies the SET, REMOVE, ADD and DELETE clauses of an update expression
+func update(expression string, item map[string]types.AttributeValue, values map[string]types.AttributeValue) error {
+	var clause string
+	for _, token := range strings.Fields(expression) {
+		switch token {
+		case "SET", "REMOVE", "ADD", "DELETE":
+			if err := applyClause(clause, item, values); err != nil {
+				return err
+			}
+			clause = token
+		default:
+			clause += " " + token
+		}
+	}
+	return applyClause(clause, item, values)
+}
+
+func applyClause(clause string, item map[string]types.AttributeValue, values map[string]types.AttributeValue) error {
+	fields := strings.SplitN(clause, " ", 2)
+	if len(fields) < 2 {
+		return nil
+	}
+	for _, action := range strings.Split(fields[1], ",") {
+		action = strings.TrimSpace(action)
+		switch fields[0] {
+		case "SET":
+			parts := strings.SplitN(action, "=", 2)
+			value, ok := values[strings.TrimSpace(parts[len(parts)-1])]
+			if len(parts) != 2 || !ok {
+				return fmt.Errorf("unsupported update %s", action)
+			}
+			item[strings.TrimSpace(parts[0])] = value
+		case "REMOVE":
+			delete(item, action)
+		case "ADD":
+			parts := strings.Fields(action)
+			if len(parts) != 2 {
+				return fmt.Errorf("unsupported update %s", action)
+			}
+			increment, ok := values[parts[1]].(*types.AttributeValueMemberN)
+			if !ok {
+				return fmt.Errorf("unsupported update %s", action)
+			}
+			sum, err := strconv.ParseFloat(increment.Value, 64)
+			if err != nil {
+				return err
+			}
+			if current, ok := item[parts[0]].(*types.AttributeValueMemberN); ok {
+				value, err := strconv.ParseFloat(current.Value, 64)
+				if err != nil {
+					return err
+				}
+				sum += value
+			}
+			item[parts[0]] = &types.AttributeValueMemberN{Value: strconv.FormatFloat(sum, 'f', -1, 64)}
+		case "DELETE":
+			parts := strings.Fields(action)
+			if len(parts) != 2 {
+				return fmt.Errorf("unsupported update %s", action)
+			}
+			set, ok := item[parts[0]].(*types.AttributeValueMemberSS)
+			remove, isSet := values[parts[1]].(*types.AttributeValueMemberSS)
+			if !ok || !isSet {
+				continue
+			}
+			remaining := []string{}
+			for _, v := range set.Value {
+				if !containsString(remove.Value, v) {
+					remaining = append(remaining, v)
+				}
+			}
+			if len(remaining) == 0 {
+				delete(item, parts[0])
+			} else {
+				item[parts[0]] = &types.AttributeValueMemberSS{Value: remaining}
+			}
+		}
+	}
+	return nil
+}
+
+// compare compares two string or number attributes of the same type
+func compare(a, b types.AttributeValue) (int, bool) {
+	switch a := a.(type) {
+	case *types.AttributeValueMemberS:
+		b, ok := b.(*types.AttributeValueMemberS)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(a.Value, b.Value), true
+	case *types.AttributeValueMemberN:
+		b, ok := b.(*types.AttributeValueMemberN)
+		if !ok {
+			return 0, false
+		}
+		// Integers are compared exactly, as large ones such as times in
+		// nanoseconds don't fit in a float64
+		if x, errA := strconv.ParseInt(a.Value, 10, 64); errA == nil {
+			if y, errB := strconv.ParseInt(b.Value, 10, 64); errB == nil {
+				switch {
+				case x < y:
+					return -1, true
+				case x > y:
+					return 1, true
+				}
+				return 0, true
+			}
+		}
+		x, errA := strconv.ParseFloat(a.Value, 64)
+		y, errB := strconv.ParseFloat(b.Value, 64)
+		if errA != nil || errB != nil {
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	case *types.AttributeValueMemberBOOL:
+		b, ok := b.(*types.AttributeValueMemberBOOL)
+		if !ok || a.Value != b.Value {
+			return 1, ok
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+func contains(attribute, value types.AttributeValue) bool {
+	v, ok := value.(*types.AttributeValueMemberS)
+	if !ok {
+		return false
+	}
+	switch a := attribute.(type) {
+	case *types.AttributeValueMemberS:
+		return strings.Contains(a.Value, v.Value)
+	case *types.AttributeValueMemberSS:
+		return containsString(a.Value, v.Value)
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// copyItem returns a shallow copy of an item, so that callers can't modify the
+// stored item by modifying the map
+func copyItem(item map[string]types.AttributeValue) map[string]types.AttributeValue {
+	c := make(map[string]types.AttributeValue, len(item))
+	for k, v := range item {
+		c[k] = v
+	}
+	return c
+}
@@ -0,0 +1,216 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Parameters of the scrypt key derivation of DeriveKey, as recommended for
+// interactive logins in 2017
+const (
+	ScryptN = 1 << 15
+	ScryptR = 8
+	ScryptP = 1
+)
+
+// EncryptionKeySize is the size of the AES-256 keys of the encrypted streams
+const EncryptionKeySize = 32
+
+// EncryptionNoncePrefixSize is the size of the random prefix of the nonces of
+// an encrypted stream, which must be unique for every stream with the same key
+const EncryptionNoncePrefixSize = 8
+
+// encryptionChunkSize is the size of the plaintext of the chunks of an
+// encrypted stream, but the last one
+const encryptionChunkSize = 64 * 1024
+
+// ErrDecryption is returned when an encrypted stream can't be decrypted,
+// because the key is wrong or the stream has been modified or truncated
+var ErrDecryption = errors.New("error decrypting: wrong passphrase, or modified or truncated data")
+
+// DeriveKey returns the AES-256 key of a passphrase with the salt, using
+// scrypt with the ScryptN, ScryptR and ScryptP parameters
+func DeriveKey(passphrase string, salt []byte) ([]byte, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, ScryptN, ScryptR, ScryptP, EncryptionKeySize)
+	if err != nil {
+		return nil, fmt.Errorf("error deriving key: %w", err)
+	}
+	return key, nil
+}
+
+// An encrypted stream is a sequence of chunks encrypted with AES-256-GCM, each
+// one prefixed by a byte that tells whether it's the last one and the length
+// of its ciphertext. The nonce of every chunk is the prefix of the stream
+// followed by the number of the chunk, and the flag is authenticated along
+// with the additional data of the stream, so that chunks can't be reordered,
+// dropped or truncated.
+type encryptedStream struct {
+	aead    cipher.AEAD
+	prefix  []byte
+	aad     []byte
+	counter uint32
+}
+
+func newEncryptedStream(key, noncePrefix, aad []byte) (*encryptedStream, error) {
+	if len(noncePrefix) != EncryptionNoncePrefixSize {
+		return nil, fmt.Errorf("invalid nonce prefix of %d bytes", len(noncePrefix))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptedStream{aead: aead, prefix: noncePrefix, aad: aad}, nil
+}
+
+// next returns the nonce and the additional data of the next chunk
+func (s *encryptedStream) next(last bool) ([]byte, []byte, error) {
+	if s.counter == ^uint32(0) {
+		return nil, nil, fmt.Errorf("encrypted stream too long")
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	copy(nonce, s.prefix)
+	binary.BigEndian.PutUint32(nonce[len(nonce)-4:], s.counter)
+	s.counter++
+	flag := byte(0)
+	if last {
+		flag = 1
+	}
+	return nonce, append(append([]byte{}, s.aad...), flag), nil
+}
+
+// EncryptWriter encrypts what is written to it into an encrypted stream. It
+// must be closed to write the last chunk.
+type EncryptWriter struct {
+	w      io.Writer
+	stream *encryptedStream
+	buf    []byte
+}
+
+// NewEncryptWriter returns a writer encrypting to w with an AES-256 key. The
+// nonce prefix must be random, and the additional data, which is
+// authenticated but not encrypted, must be the same when decrypting.
+func NewEncryptWriter(w io.Writer, key, noncePrefix, aad []byte) (*EncryptWriter, error) {
+	stream, err := newEncryptedStream(key, noncePrefix, aad)
+	if err != nil {
+		return nil, err
+	}
+	return &EncryptWriter{w: w, stream: stream, buf: make([]byte, 0, encryptionChunkSize)}, nil
+}
+
+func (e *EncryptWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		// A full chunk is only written once there's more data, as the
+		// last chunk must be flagged as such
+		if len(e.buf) == encryptionChunkSize {
+			if err := e.writeChunk(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(e.buf[len(e.buf):encryptionChunkSize], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// Close writes the last chunk. It doesn't close the underlying writer.
+func (e *EncryptWriter) Close() error {
+	return e.writeChunk(true)
+}
+
+func (e *EncryptWriter) writeChunk(last bool) error {
+	nonce, aad, err := e.stream.next(last)
+	if err != nil {
+		return err
+	}
+	sealed := e.stream.aead.Seal(nil, nonce, e.buf, aad)
+	header := make([]byte, 5)
+	header[0] = aad[len(aad)-1]
+	binary.BigEndian.PutUint32(header[1:], uint32(len(sealed)))
+	e.buf = e.buf[:0]
+	if _, err := e.w.Write(header); err != nil {
+		return fmt.Errorf("error writing encrypted data: %w", err)
+	}
+	if _, err := e.w.Write(sealed); err != nil {
+		return fmt.Errorf("error writing encrypted data: %w", err)
+	}
+	return nil
+}
+
+// DecryptReader decrypts an encrypted stream written by an EncryptWriter.
+// Every chunk is authenticated before its plaintext is returned, and reading
+// fails with ErrDecryption if the stream ends before its last chunk.
+type DecryptReader struct {
+	r      io.Reader
+	stream *encryptedStream
+	buf    []byte
+	done   bool
+}
+
+// NewDecryptReader returns a reader decrypting r with the key, nonce prefix
+// and additional data of the stream
+func NewDecryptReader(r io.Reader, key, noncePrefix, aad []byte) (*DecryptReader, error) {
+	stream, err := newEncryptedStream(key, noncePrefix, aad)
+	if err != nil {
+		return nil, err
+	}
+	return &DecryptReader{r: r, stream: stream}, nil
+}
+
+func (d *DecryptReader) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.readChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+func (d *DecryptReader) readChunk() error {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(d.r, header); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return ErrDecryption
+		}
+		return fmt.Errorf("error reading encrypted data: %w", err)
+	}
+	length := binary.BigEndian.Uint32(header[1:])
+	if header[0] > 1 || length > encryptionChunkSize+uint32(d.stream.aead.Overhead()) {
+		return ErrDecryption
+	}
+	sealed := make([]byte, length)
+	if _, err := io.ReadFull(d.r, sealed); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return ErrDecryption
+		}
+		return fmt.Errorf("error reading encrypted data: %w", err)
+	}
+	nonce, aad, err := d.stream.next(header[0] == 1)
+	if err != nil {
+		return err
+	}
+	plaintext, err := d.stream.aead.Open(nil, nonce, sealed, aad)
+	if err != nil {
+		return ErrDecryption
+	}
+	d.buf = plaintext
+	d.done = header[0] == 1
+	return nil
+}
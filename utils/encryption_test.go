@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncryptedStream(t *testing.T) {
+	key, err := DeriveKey("correct horse battery staple", []byte("salt"))
+	assert.NoError(t, err)
+	assert.Len(t, key, EncryptionKeySize)
+	prefix := []byte("12345678")
+	aad := []byte("header")
+
+	plaintext := make([]byte, 2*encryptionChunkSize+100)
+	rand.Read(plaintext)
+	encrypt := func(data []byte) []byte {
+		buf := &bytes.Buffer{}
+		w, err := NewEncryptWriter(buf, key, prefix, aad)
+		assert.NoError(t, err)
+		// Writes of any size end up in the same chunks
+		for len(data) > 0 {
+			n := 1000
+			if n > len(data) {
+				n = len(data)
+			}
+			_, err := w.Write(data[:n])
+			assert.NoError(t, err)
+			data = data[n:]
+		}
+		assert.NoError(t, w.Close())
+		return buf.Bytes()
+	}
+	encrypted := encrypt(plaintext)
+	empty := encrypt(nil)
+	otherKey, _ := DeriveKey("wrong", []byte("salt"))
+
+	testCases := []struct {
+		name      string
+		data      []byte
+		key       []byte
+		aad       []byte
+		expected  []byte
+		decrypted bool
+	}{
+		{name: "Several chunks", data: encrypted, key: key, aad: aad, expected: plaintext, decrypted: true},
+		{name: "Empty", data: empty, key: key, aad: aad, expected: []byte{}, decrypted: true},
+		{name: "Wrong key", data: encrypted, key: otherKey, aad: aad},
+		{name: "Other additional data", data: encrypted, key: key, aad: []byte("other")},
+		{name: "Truncated", data: encrypted[:len(encrypted)-200], key: key, aad: aad},
+		{name: "Last chunk dropped", data: encrypted[:2*(5+encryptionChunkSize+16)], key: key, aad: aad},
+		{name: "Modified", data: append(append([]byte{}, encrypted[:100]...), append([]byte{encrypted[100] ^ 1}, encrypted[101:]...)...), key: key, aad: aad},
+		{name: "Nothing", data: []byte{}, key: key, aad: aad},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			r, err := NewDecryptReader(bytes.NewReader(tc.data), tc.key, prefix, tc.aad)
+			assert.NoError(tt, err)
+			decrypted, err := ioutil.ReadAll(r)
+			if !tc.decrypted {
+				assert.True(tt, errors.Is(err, ErrDecryption))
+				return
+			}
+			assert.NoError(tt, err)
+			assert.Equal(tt, tc.expected, decrypted)
+		})
+	}
+}
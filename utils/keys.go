@@ -0,0 +1,433 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	// TypeClaim is the claim telling apart the kinds of tokens signed with a
+	// KeySet, so that a token can't be used for something it wasn't issued for
+	TypeClaim = "typ"
+
+	// AccessToken is the type of the tokens authenticating API requests
+	AccessToken = "access"
+
+	// OIDCStateToken is the type of the tokens holding the state and nonce of
+	// an ongoing OpenID Connect login
+	OIDCStateToken = "oidc_state"
+)
+
+// KeySet holds the keys used for signing and verifying JWT tokens. Tokens
+// are signed either with an HMAC secret (HS256), or with an RSA (RS256) or
+// ECDSA (ES256, ES384, ES512) private key. Asymmetric keys are identified by
+// their kid, which is the RFC 7638 thumbprint of the public key, so that
+// tokens signed with previous keys can still be verified during a rotation.
+type KeySet struct {
+	secret     []byte
+	signingKey crypto.Signer
+	signingKID string
+	publicKeys map[string]crypto.PublicKey
+}
+
+// JWK is a JSON Web Key, as defined in RFC 7517. Only the fields needed
+// for publishing RSA and EC public keys are included.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set, as defined in RFC 7517
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewHMACKeySet returns a KeySet that signs and verifies tokens with the
+// HS256 algorithm, using the secret passed as a parameter. If the secret is
+// empty, the KeySet is empty.
+func NewHMACKeySet(secret string) *KeySet {
+	return &KeySet{
+		secret:     []byte(secret),
+		publicKeys: map[string]crypto.PublicKey{},
+	}
+}
+
+// LoadKeySet returns a KeySet with the HMAC secret, the private key used for
+// signing tokens, and a list of additional keys that are only used for
+// verifying tokens, such as the public keys of previous signing keys.
+// Each key can be either PEM encoded, or the path to a file containing
+// one or more PEM encoded keys. Empty values are ignored.
+func LoadKeySet(secret, privateKey string, verificationKeys []string) (*KeySet, error) {
+	k := NewHMACKeySet(secret)
+
+	if privateKey != "" {
+		keys, err := loadKeys(privateKey)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := keys[0].(crypto.Signer)
+		if !ok || len(keys) != 1 {
+			return nil, fmt.Errorf("the signing key must contain a single RSA or ECDSA private key")
+		}
+		if _, err := signingMethod(signer.Public()); err != nil {
+			return nil, err
+		}
+		k.signingKey = signer
+		if k.signingKID, err = k.addPublicKey(signer.Public()); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, v := range verificationKeys {
+		if v == "" {
+			continue
+		}
+		keys, err := loadKeys(v)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			if signer, ok := key.(crypto.Signer); ok {
+				key = signer.Public()
+			}
+			if _, err := k.addPublicKey(key); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return k, nil
+}
+
+// Empty returns true if the KeySet has no keys at all, meaning that
+// authentication is disabled.
+func (k *KeySet) Empty() bool {
+	return k == nil || (len(k.secret) == 0 && k.signingKey == nil && len(k.publicKeys) == 0)
+}
+
+// Sign returns a signed token with the claims passed as a parameter. The
+// private key is preferred over the HMAC secret if both are available.
+func (k *KeySet) Sign(claims jwt.MapClaims) (string, error) {
+	if k.signingKey != nil {
+		method, _ := signingMethod(k.signingKey.Public())
+		token := jwt.NewWithClaims(method, claims)
+		token.Header["kid"] = k.signingKID
+		return token.SignedString(k.signingKey)
+	}
+	if len(k.secret) == 0 {
+		return "", fmt.Errorf("no signing key available")
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(k.secret)
+}
+
+// Keyfunc returns the key for verifying the token passed as a parameter.
+// It is meant to be used with jwt.Parse.
+func (k *KeySet) Keyfunc(t *jwt.Token) (interface{}, error) {
+	if _, ok := t.Method.(*jwt.SigningMethodHMAC); ok {
+		if len(k.secret) == 0 || t.Method != jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("unexpected signing method %s", t.Method.Alg())
+		}
+		return k.secret, nil
+	}
+
+	var key crypto.PublicKey
+	if kid, ok := t.Header["kid"].(string); ok {
+		if key, ok = k.publicKeys[kid]; !ok {
+			return nil, fmt.Errorf("unknown key id %s", kid)
+		}
+	} else if len(k.publicKeys) == 1 {
+		for _, only := range k.publicKeys {
+			key = only
+		}
+	} else {
+		return nil, fmt.Errorf("the token doesn't have a key id")
+	}
+
+	method, err := signingMethod(key)
+	if err != nil {
+		return nil, err
+	}
+	if method.Alg() != t.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s", t.Method.Alg())
+	}
+	return key, nil
+}
+
+// Parse parses and validates a token against the keys of the KeySet
+func (k *KeySet) Parse(token string) (*jwt.Token, error) {
+	return jwt.Parse(token, k.Keyfunc)
+}
+
+// ParseAccessToken parses and validates an access token, rejecting tokens of
+// any other type and tokens without a subject
+func (k *KeySet) ParseAccessToken(token string) (*jwt.Token, error) {
+	return k.ParseType(token, AccessToken, true)
+}
+
+// ParseType parses and validates a token of the type passed as a parameter.
+// If subject is true, the token must also have a subject.
+func (k *KeySet) ParseType(token, typ string, subject bool) (*jwt.Token, error) {
+	t, err := k.Parse(token)
+	if err != nil {
+		return nil, err
+	}
+	claims, ok := t.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, fmt.Errorf("unexpected claims")
+	}
+	if claimed, _ := claims[TypeClaim].(string); claimed != typ {
+		return nil, fmt.Errorf("the token is not of type %s", typ)
+	}
+	if sub, _ := claims["sub"].(string); subject && sub == "" {
+		return nil, fmt.Errorf("the token doesn't have a subject")
+	}
+	return t, nil
+}
+
+// JWKS returns the public keys of the KeySet as a JSON Web Key Set
+func (k *KeySet) JWKS() JWKS {
+	jwks := JWKS{Keys: []JWK{}}
+	if k == nil {
+		return jwks
+	}
+	for kid, key := range k.publicKeys {
+		jwk, _ := publicJWK(key)
+		jwk.KeyID = kid
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	sort.Slice(jwks.Keys, func(i, j int) bool { return jwks.Keys[i].KeyID < jwks.Keys[j].KeyID })
+	return jwks
+}
+
+func (k *KeySet) addPublicKey(key crypto.PublicKey) (string, error) {
+	jwk, err := publicJWK(key)
+	if err != nil {
+		return "", err
+	}
+	kid, err := thumbprint(jwk)
+	if err != nil {
+		return "", err
+	}
+	k.publicKeys[kid] = key
+	return kid, nil
+}
+
+func signingMethod(key crypto.PublicKey) (jwt.SigningMethod, error) {
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		return jwt.SigningMethodRS256, nil
+	case *ecdsa.PublicKey:
+		switch pub.Curve {
+		case elliptic.P256():
+			return jwt.SigningMethodES256, nil
+		case elliptic.P384():
+			return jwt.SigningMethodES384, nil
+		case elliptic.P521():
+			return jwt.SigningMethodES512, nil
+		}
+		return nil, fmt.Errorf("unsupported elliptic curve %s", pub.Curve.Params().Name)
+	}
+	return nil, fmt.Errorf("unsupported key type %T", key)
+}
+
+func publicJWK(key crypto.PublicKey) (JWK, error) {
+	method, err := signingMethod(key)
+	if err != nil {
+		return JWK{}, err
+	}
+	jwk := JWK{Use: "sig", Algorithm: method.Alg()}
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		jwk.KeyType = "EC"
+		jwk.Curve = pub.Curve.Params().Name
+		jwk.X = base64.RawURLEncoding.EncodeToString(padded(pub.X.Bytes(), size))
+		jwk.Y = base64.RawURLEncoding.EncodeToString(padded(pub.Y.Bytes(), size))
+	}
+	return jwk, nil
+}
+
+func padded(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	return append(make([]byte, size-len(b)), b...)
+}
+
+// thumbprint returns the RFC 7638 thumbprint of a JWK, which only includes
+// the required members of the key in lexicographic order.
+func thumbprint(jwk JWK) (string, error) {
+	var members interface{}
+	if jwk.KeyType == "RSA" {
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.KeyType, jwk.N}
+	} else {
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Curve, jwk.KeyType, jwk.X, jwk.Y}
+	}
+	b, err := json.Marshal(members)
+	if err != nil {
+		return "", fmt.Errorf("error computing key thumbprint: %w", err)
+	}
+	sum := sha256.Sum256(b)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// loadKeys parses all the PEM encoded keys in value, which is either PEM
+// data or the path to a file containing it.
+func loadKeys(value string) ([]interface{}, error) {
+	data := []byte(value)
+	if !strings.Contains(value, "-----BEGIN") {
+		var err error
+		if data, err = ioutil.ReadFile(value); err != nil {
+			return nil, fmt.Errorf("error reading key file %s: %w", value, err)
+		}
+	}
+
+	keys := []interface{}{}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		key, err := parsePEMBlock(block)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no PEM encoded keys found")
+	}
+	return keys, nil
+}
+
+func parsePEMBlock(block *pem.Block) (interface{}, error) {
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+			key = cert.PublicKey
+		}
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %s", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", strings.ToLower(block.Type), err)
+	}
+	return key, nil
+}
+
+// PublicKey returns the RSA or ECDSA public key represented by the JWK
+func (j JWK) PublicKey() (crypto.PublicKey, error) {
+	switch j.KeyType {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(j.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus in key %s: %w", j.KeyID, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(j.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponent in key %s: %w", j.KeyID, err)
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch j.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported elliptic curve %s in key %s", j.Curve, j.KeyID)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(j.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x coordinate in key %s: %w", j.KeyID, err)
+		}
+		y, err := base64.RawURLEncoding.DecodeString(j.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y coordinate in key %s: %w", j.KeyID, err)
+		}
+		return &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s in key %s", j.KeyType, j.KeyID)
+}
+
+// NewJWKSKeySet returns a KeySet for verifying tokens signed with any of the
+// keys in the JSON Web Key Set. Keys that aren't meant for signatures, or
+// whose type isn't supported, are ignored.
+func NewJWKSKeySet(jwks JWKS) *KeySet {
+	k := NewHMACKeySet("")
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.PublicKey()
+		if err != nil {
+			continue
+		}
+		kid := jwk.KeyID
+		if kid == "" {
+			if kid, err = thumbprint(jwk); err != nil {
+				continue
+			}
+		}
+		k.publicKeys[kid] = key
+	}
+	return k
+}
@@ -0,0 +1,188 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func rsaKeyPEM(t *testing.T) string {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+}
+
+func ecKeyPEM(t *testing.T) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+	pub, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub}))
+}
+
+func TestLoadKeySet(t *testing.T) {
+	ecPrivate, ecPublic := ecKeyPEM(t)
+	keyFile := filepath.Join(t.TempDir(), "key.pem")
+	ioutil.WriteFile(keyFile, []byte(ecPrivate), 0600)
+
+	testCases := []struct {
+		name             string
+		secret           string
+		privateKey       string
+		verificationKeys []string
+		expectedKeys     int
+		expectedEmpty    bool
+		expectedError    bool
+	}{
+		{
+			name:          "No keys at all",
+			expectedEmpty: true,
+		},
+		{
+			name:   "HMAC secret",
+			secret: "secret",
+		},
+		{
+			name:         "RSA private key in PEM",
+			privateKey:   rsaKeyPEM(t),
+			expectedKeys: 1,
+		},
+		{
+			name:         "EC private key file",
+			privateKey:   keyFile,
+			expectedKeys: 1,
+		},
+		{
+			name:             "Private key plus previous public keys",
+			privateKey:       rsaKeyPEM(t),
+			verificationKeys: []string{ecPublic, ""},
+			expectedKeys:     2,
+		},
+		{
+			name:             "Verification key is the same as the signing key",
+			privateKey:       keyFile,
+			verificationKeys: []string{ecPublic},
+			expectedKeys:     1,
+		},
+		{
+			name:          "Public key as the signing key",
+			privateKey:    ecPublic,
+			expectedError: true,
+		},
+		{
+			name:          "Missing key file",
+			privateKey:    filepath.Join(t.TempDir(), "missing.pem"),
+			expectedError: true,
+		},
+		{
+			name:             "Invalid PEM",
+			verificationKeys: []string{"-----BEGIN PUBLIC KEY-----\ninvalid\n-----END PUBLIC KEY-----\n"},
+			expectedError:    true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			k, err := LoadKeySet(tc.secret, tc.privateKey, tc.verificationKeys)
+			if tc.expectedError {
+				assert.Error(tt, err)
+				return
+			}
+			assert.NoError(tt, err)
+			assert.Equal(tt, tc.expectedEmpty, k.Empty())
+			assert.Len(tt, k.JWKS().Keys, tc.expectedKeys)
+		})
+	}
+}
+
+func TestKeySetSignAndParse(t *testing.T) {
+	oldKey := rsaKeyPEM(t)
+	newKey, _ := ecKeyPEM(t)
+	claims := jwt.MapClaims{"sub": "admin", "exp": time.Now().Add(time.Minute).Unix()}
+
+	old, _ := LoadKeySet("", oldKey, nil)
+	rotated, _ := LoadKeySet("", newKey, []string{oldKey})
+	hmac := NewHMACKeySet("secret")
+	unrelated, _ := LoadKeySet("", rsaKeyPEM(t), nil)
+
+	oldToken, err := old.Sign(claims)
+	assert.NoError(t, err)
+	newToken, err := rotated.Sign(claims)
+	assert.NoError(t, err)
+	hmacToken, err := hmac.Sign(claims)
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		name          string
+		keys          *KeySet
+		token         string
+		expectedError bool
+	}{
+		{
+			name:  "Token signed with the current key",
+			keys:  rotated,
+			token: newToken,
+		},
+		{
+			name:  "Token signed with a previous key",
+			keys:  rotated,
+			token: oldToken,
+		},
+		{
+			name:          "Token signed with a key that is no longer trusted",
+			keys:          old,
+			token:         newToken,
+			expectedError: true,
+		},
+		{
+			name:          "Token signed with an unknown key",
+			keys:          unrelated,
+			token:         oldToken,
+			expectedError: true,
+		},
+		{
+			name:  "HMAC token",
+			keys:  hmac,
+			token: hmacToken,
+		},
+		{
+			name:          "HMAC token without a secret",
+			keys:          rotated,
+			token:         hmacToken,
+			expectedError: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			tok, err := tc.keys.Parse(tc.token)
+			if tc.expectedError {
+				assert.Error(tt, err)
+				return
+			}
+			assert.NoError(tt, err)
+			assert.True(tt, tok.Valid)
+		})
+	}
+}
+
+func TestKeySetRejectsPublicKeyAsHMACSecret(t *testing.T) {
+	_, public := ecKeyPEM(t)
+	k, _ := LoadKeySet("", "", []string{public})
+	forged, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "admin"}).SignedString([]byte(public))
+	_, err := k.Parse(forged)
+	assert.Error(t, err)
+}
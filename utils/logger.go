@@ -8,7 +8,31 @@ import (
 
 // InitSugaredLogger is a helper function for initializing a *zap.SugaredLogger
 func InitSugaredLogger(verbose bool) (*zap.SugaredLogger, error) {
-	var zl *zap.Logger
+	return InitSugaredLoggerWithLevel(NewLogLevel(verbose))
+}
+
+// NewLogLevel returns a zap.AtomicLevel set to Debug if verbose is true,
+// or to Info otherwise.
+func NewLogLevel(verbose bool) zap.AtomicLevel {
+	if verbose {
+		return zap.NewAtomicLevelAt(zap.DebugLevel)
+	}
+	return zap.NewAtomicLevelAt(zap.InfoLevel)
+}
+
+// SetVerbose changes the level of an existing zap.AtomicLevel, so that every
+// logger built with it starts (or stops) logging at the Debug level.
+func SetVerbose(level zap.AtomicLevel, verbose bool) {
+	if verbose {
+		level.SetLevel(zap.DebugLevel)
+		return
+	}
+	level.SetLevel(zap.InfoLevel)
+}
+
+// InitSugaredLoggerWithLevel initializes a *zap.SugaredLogger whose level can
+// be changed at runtime through the zap.AtomicLevel passed as a parameter.
+func InitSugaredLoggerWithLevel(level zap.AtomicLevel) (*zap.SugaredLogger, error) {
 	cfg := zap.Config{
 		Development: false,
 		Sampling: &zap.SamplingConfig{
@@ -19,11 +43,7 @@ func InitSugaredLogger(verbose bool) (*zap.SugaredLogger, error) {
 		EncoderConfig:    zap.NewProductionEncoderConfig(),
 		OutputPaths:      []string{"stderr"},
 		ErrorOutputPaths: []string{"stderr"},
-	}
-	if verbose {
-		cfg.Level = zap.NewAtomicLevelAt(zap.DebugLevel)
-	} else {
-		cfg.Level = zap.NewAtomicLevelAt(zap.InfoLevel)
+		Level:            level,
 	}
 	zl, err := cfg.Build()
 
@@ -0,0 +1,225 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// OIDCProvider is a minimal OpenID Connect relying party, implementing the
+// authorization code flow against a single identity provider.
+type OIDCProvider struct {
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+
+	issuer                string
+	authorizationEndpoint string
+	tokenEndpoint         string
+	jwksURI               string
+	httpClient            *http.Client
+
+	mu   sync.RWMutex
+	keys *KeySet
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// NewOIDCProvider discovers the endpoints of the identity provider from
+// its issuer URL, and returns an OIDCProvider for the client passed as a parameter.
+func NewOIDCProvider(ctx context.Context, issuer, clientID, clientSecret, redirectURL string) (*OIDCProvider, error) {
+	p := &OIDCProvider{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		Scopes:       []string{"openid", "email", "profile"},
+		httpClient:   &http.Client{Timeout: 10 * time.Second},
+	}
+
+	discovery := oidcDiscovery{}
+	wellKnown := strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+	if err := p.getJSON(ctx, wellKnown, &discovery); err != nil {
+		return nil, fmt.Errorf("error discovering OpenID Connect provider %s: %w", issuer, err)
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != strings.TrimSuffix(issuer, "/") {
+		return nil, fmt.Errorf("issuer %s returned by the provider doesn't match %s", discovery.Issuer, issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("the OpenID Connect provider %s is missing required endpoints", issuer)
+	}
+
+	p.issuer = discovery.Issuer
+	p.authorizationEndpoint = discovery.AuthorizationEndpoint
+	p.tokenEndpoint = discovery.TokenEndpoint
+	p.jwksURI = discovery.JWKSURI
+	return p, nil
+}
+
+// AuthCodeURL returns the URL of the identity provider where the user has to
+// be redirected for logging in.
+func (p *OIDCProvider) AuthCodeURL(state, nonce string) string {
+	v := url.Values{
+		"response_type": {"code"},
+		"client_id":     {p.ClientID},
+		"redirect_uri":  {p.RedirectURL},
+		"scope":         {strings.Join(p.Scopes, " ")},
+		"state":         {state},
+		"nonce":         {nonce},
+	}
+	sep := "?"
+	if strings.Contains(p.authorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return p.authorizationEndpoint + sep + v.Encode()
+}
+
+// Exchange exchanges an authorization code for tokens, and returns the
+// claims of the ID token once it has been verified.
+func (p *OIDCProvider) Exchange(ctx context.Context, code, nonce string) (jwt.MapClaims, error) {
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {p.RedirectURL},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("error creating token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error exchanging authorization code: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("error exchanging authorization code: status %d: %s", resp.StatusCode, body)
+	}
+
+	tokens := struct {
+		IDToken string `json:"id_token"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return nil, fmt.Errorf("error decoding token response: %w", err)
+	}
+	if tokens.IDToken == "" {
+		return nil, fmt.Errorf("the token response doesn't include an ID token")
+	}
+
+	return p.Verify(ctx, tokens.IDToken, nonce)
+}
+
+// Verify checks the signature, issuer, audience, expiration and nonce of an
+// ID token, returning its claims.
+func (p *OIDCProvider) Verify(ctx context.Context, idToken, nonce string) (jwt.MapClaims, error) {
+	token, err := p.currentKeys().Parse(idToken)
+	if err != nil {
+		// The provider may have rotated its keys, so fetch them again once
+		if err = p.refreshKeys(ctx); err != nil {
+			return nil, err
+		}
+		if token, err = p.currentKeys().Parse(idToken); err != nil {
+			return nil, fmt.Errorf("invalid ID token: %w", err)
+		}
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, fmt.Errorf("invalid ID token")
+	}
+	if !claims.VerifyIssuer(p.issuer, true) {
+		return nil, fmt.Errorf("unexpected issuer %v in ID token", claims["iss"])
+	}
+	if !claims.VerifyAudience(p.ClientID, true) && !containsAudience(claims["aud"], p.ClientID) {
+		return nil, fmt.Errorf("the ID token was not issued for client %s", p.ClientID)
+	}
+	if _, ok := claims["exp"]; !ok {
+		return nil, fmt.Errorf("the ID token has no expiration")
+	}
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return nil, fmt.Errorf("unexpected nonce in ID token")
+	}
+	return claims, nil
+}
+
+func (p *OIDCProvider) currentKeys() *KeySet {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.keys == nil {
+		return NewHMACKeySet("")
+	}
+	return p.keys
+}
+
+func (p *OIDCProvider) refreshKeys(ctx context.Context) error {
+	jwks := JWKS{}
+	if err := p.getJSON(ctx, p.jwksURI, &jwks); err != nil {
+		return fmt.Errorf("error fetching the keys of the OpenID Connect provider: %w", err)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keys = NewJWKSKeySet(jwks)
+	return nil
+}
+
+func (p *OIDCProvider) getJSON(ctx context.Context, u string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d from %s", resp.StatusCode, u)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// containsAudience handles the aud claim being an array, which the
+// jwt-go MapClaims verification doesn't support.
+func containsAudience(aud interface{}, clientID string) bool {
+	auds, ok := aud.([]interface{})
+	if !ok {
+		return false
+	}
+	for _, a := range auds {
+		if s, ok := a.(string); ok && s == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+// RandomString returns a URL-safe random string with n bytes of entropy
+func RandomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error generating random string: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,116 @@
+package utils_test
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/igvaquero18/smarthome/utils"
+	"github.com/igvaquero18/smarthome/utils/oidctest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewOIDCProvider(t *testing.T) {
+	idp := oidctest.NewServer("smarthome", "secret")
+	defer idp.Close()
+
+	_, err := utils.NewOIDCProvider(context.TODO(), idp.URL, "smarthome", "secret", "http://localhost/callback")
+	assert.NoError(t, err)
+
+	_, err = utils.NewOIDCProvider(context.TODO(), idp.URL+"/other", "smarthome", "secret", "http://localhost/callback")
+	assert.Error(t, err)
+}
+
+func TestOIDCProviderExchange(t *testing.T) {
+	idp := oidctest.NewServer("smarthome", "secret")
+	defer idp.Close()
+
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	authorize := func(p *utils.OIDCProvider, nonce string) string {
+		resp, err := noRedirect.Get(p.AuthCodeURL("state", nonce))
+		if err != nil {
+			t.Fatal(err)
+		}
+		location, _ := url.Parse(resp.Header.Get("Location"))
+		assert.Equal(t, "state", location.Query().Get("state"))
+		return location.Query().Get("code")
+	}
+
+	testCases := []struct {
+		name          string
+		clientSecret  string
+		nonce         string
+		expectedNonce string
+		invalidCode   bool
+		expectedError bool
+	}{
+		{
+			name:          "Valid code and nonce",
+			clientSecret:  "secret",
+			nonce:         "nonce",
+			expectedNonce: "nonce",
+		},
+		{
+			name:          "Nonce mismatch",
+			clientSecret:  "secret",
+			nonce:         "nonce",
+			expectedNonce: "other",
+			expectedError: true,
+		},
+		{
+			name:          "Wrong client secret",
+			clientSecret:  "wrong",
+			nonce:         "nonce",
+			expectedNonce: "nonce",
+			expectedError: true,
+		},
+		{
+			name:          "Invalid code",
+			clientSecret:  "secret",
+			nonce:         "nonce",
+			expectedNonce: "nonce",
+			invalidCode:   true,
+			expectedError: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			p, err := utils.NewOIDCProvider(context.TODO(), idp.URL, "smarthome", tc.clientSecret, "http://localhost/callback")
+			assert.NoError(tt, err)
+			code := authorize(p, tc.nonce)
+			if tc.invalidCode {
+				code = "invalid"
+			}
+			claims, err := p.Exchange(context.TODO(), code, tc.expectedNonce)
+			if tc.expectedError {
+				assert.Error(tt, err)
+				return
+			}
+			assert.NoError(tt, err)
+			assert.Equal(tt, "admin@example.com", claims["email"])
+		})
+	}
+}
+
+func TestOIDCProviderVerify(t *testing.T) {
+	idp := oidctest.NewServer("smarthome", "secret")
+	defer idp.Close()
+	other := oidctest.NewServer("smarthome", "secret")
+	defer other.Close()
+
+	p, _ := utils.NewOIDCProvider(context.TODO(), idp.URL, "smarthome", "secret", "http://localhost/callback")
+	forOtherClient, _ := utils.NewOIDCProvider(context.TODO(), idp.URL, "other", "secret", "http://localhost/callback")
+
+	_, err := p.Verify(context.TODO(), idp.IDToken("nonce"), "nonce")
+	assert.NoError(t, err)
+
+	_, err = p.Verify(context.TODO(), other.IDToken("nonce"), "nonce")
+	assert.Error(t, err, "token signed by another provider")
+
+	_, err = forOtherClient.Verify(context.TODO(), idp.IDToken("nonce"), "nonce")
+	assert.Error(t, err, "token issued for another client")
+}
@@ -0,0 +1,388 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// OpenAPI is the subset of an OpenAPI 3 document needed for validating
+// requests and responses against it
+type OpenAPI struct {
+	OpenAPI    string                           `json:"openapi"`
+	Paths      map[string]map[string]*Operation `json:"paths"`
+	Components struct {
+		Schemas   map[string]*Schema   `json:"schemas"`
+		Responses map[string]*Response `json:"responses"`
+	} `json:"components"`
+}
+
+// Operation is an operation of a path, such as GET /v1/room/{room}
+type Operation struct {
+	OperationID string               `json:"operationId"`
+	Parameters  []Parameter          `json:"parameters"`
+	RequestBody *RequestBody         `json:"requestBody"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+// Parameter is a path, query or header parameter of an operation
+type Parameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required"`
+	Schema   *Schema `json:"schema"`
+}
+
+// RequestBody describes the payload of an operation
+type RequestBody struct {
+	Required bool                  `json:"required"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+// Response describes a response of an operation, or refers to one of the
+// components with Ref
+type Response struct {
+	Ref         string                `json:"$ref"`
+	Description string                `json:"description"`
+	Content     map[string]*MediaType `json:"content"`
+}
+
+// MediaType holds the schema of a request or response body
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Schema is the subset of JSON Schema supported by the validation
+type Schema struct {
+	Ref                  string             `json:"$ref"`
+	Type                 string             `json:"type"`
+	Format               string             `json:"format"`
+	Nullable             bool               `json:"nullable"`
+	Enum                 []interface{}      `json:"enum"`
+	Properties           map[string]*Schema `json:"properties"`
+	Required             []string           `json:"required"`
+	AdditionalProperties *bool              `json:"additionalProperties"`
+	Items                *Schema            `json:"items"`
+	OneOf                []*Schema          `json:"oneOf"`
+	MinLength            *int               `json:"minLength"`
+	MaxLength            *int               `json:"maxLength"`
+	Minimum              *float64           `json:"minimum"`
+	Maximum              *float64           `json:"maximum"`
+}
+
+// ParseOpenAPI parses an OpenAPI 3 document in JSON, checking that every
+// schema reference can be resolved
+func ParseOpenAPI(data []byte) (*OpenAPI, error) {
+	spec := &OpenAPI{}
+	if err := json.Unmarshal(data, spec); err != nil {
+		return nil, fmt.Errorf("error parsing OpenAPI document: %w", err)
+	}
+	if !strings.HasPrefix(spec.OpenAPI, "3.") {
+		return nil, fmt.Errorf("unsupported OpenAPI version %q", spec.OpenAPI)
+	}
+
+	var check func(s *Schema) error
+	check = func(s *Schema) error {
+		if s == nil {
+			return nil
+		}
+		if s.Ref != "" {
+			_, err := spec.resolve(s)
+			return err
+		}
+		for _, p := range s.Properties {
+			if err := check(p); err != nil {
+				return err
+			}
+		}
+		for _, o := range s.OneOf {
+			if err := check(o); err != nil {
+				return err
+			}
+		}
+		return check(s.Items)
+	}
+	for _, schema := range spec.Components.Schemas {
+		if err := check(schema); err != nil {
+			return nil, err
+		}
+	}
+	for path, operations := range spec.Paths {
+		for method, op := range operations {
+			var schemas []*Schema
+			for _, p := range op.Parameters {
+				schemas = append(schemas, p.Schema)
+			}
+			if op.RequestBody != nil {
+				for _, m := range op.RequestBody.Content {
+					schemas = append(schemas, m.Schema)
+				}
+			}
+			for status, r := range op.Responses {
+				r, err := spec.resolveResponse(r)
+				if err != nil {
+					return nil, fmt.Errorf("%s %s %s: %w", strings.ToUpper(method), path, status, err)
+				}
+				for _, m := range r.Content {
+					schemas = append(schemas, m.Schema)
+				}
+			}
+			for _, s := range schemas {
+				if err := check(s); err != nil {
+					return nil, fmt.Errorf("%s %s: %w", strings.ToUpper(method), path, err)
+				}
+			}
+		}
+	}
+	return spec, nil
+}
+
+// Operation returns the operation of the method and path template, which
+// uses the OpenAPI syntax for parameters, e.g. /v1/room/{room}
+func (o *OpenAPI) Operation(method, path string) (*Operation, bool) {
+	op, ok := o.Paths[path][strings.ToLower(method)]
+	return op, ok
+}
+
+// ValidateParameters validates the path and query parameters of a request
+func (o *OpenAPI) ValidateParameters(op *Operation, pathParams map[string]string, query url.Values) error {
+	for _, p := range op.Parameters {
+		var value string
+		var present bool
+		switch p.In {
+		case "path":
+			value, present = pathParams[p.Name]
+		case "query":
+			_, present = query[p.Name]
+			value = query.Get(p.Name)
+		default:
+			continue
+		}
+		if !present {
+			if p.Required {
+				return fmt.Errorf("missing required %s parameter %s", p.In, p.Name)
+			}
+			continue
+		}
+		if p.Schema == nil {
+			continue
+		}
+		if err := o.validate(p.Schema, parameterValue(o, p.Schema, value), p.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateRequestBody validates the JSON body of a request
+func (o *OpenAPI) ValidateRequestBody(op *Operation, contentType string, body []byte) error {
+	if op.RequestBody == nil {
+		return nil
+	}
+	if len(body) == 0 {
+		if op.RequestBody.Required {
+			return fmt.Errorf("missing required request body")
+		}
+		return nil
+	}
+	return o.validateContent(op.RequestBody.Content, contentType, body, "body")
+}
+
+// ValidateResponse validates the status code and JSON body of a response
+func (o *OpenAPI) ValidateResponse(op *Operation, status int, contentType string, body []byte) error {
+	response, ok := op.Responses[strconv.Itoa(status)]
+	if !ok {
+		response, ok = op.Responses["default"]
+	}
+	if !ok {
+		return fmt.Errorf("undocumented response status %d", status)
+	}
+	response, err := o.resolveResponse(response)
+	if err != nil {
+		return err
+	}
+	if len(response.Content) == 0 {
+		return nil
+	}
+	return o.validateContent(response.Content, contentType, body, "body")
+}
+
+func (o *OpenAPI) validateContent(content map[string]*MediaType, contentType string, body []byte, name string) error {
+	mediaType := strings.TrimSpace(strings.Split(contentType, ";")[0])
+	if mediaType == "" {
+		mediaType = "application/json"
+	}
+	m, ok := content[mediaType]
+	if !ok {
+		return fmt.Errorf("unexpected content type %s of the %s", mediaType, name)
+	}
+	if m.Schema == nil || !strings.HasSuffix(mediaType, "json") {
+		return nil
+	}
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return fmt.Errorf("invalid JSON in the %s: %w", name, err)
+	}
+	return o.validate(m.Schema, value, name)
+}
+
+func (o *OpenAPI) resolve(s *Schema) (*Schema, error) {
+	for s.Ref != "" {
+		name := strings.TrimPrefix(s.Ref, "#/components/schemas/")
+		resolved, ok := o.Components.Schemas[name]
+		if name == s.Ref || !ok {
+			return nil, fmt.Errorf("unresolved schema reference %s", s.Ref)
+		}
+		s = resolved
+	}
+	return s, nil
+}
+
+func (o *OpenAPI) resolveResponse(r *Response) (*Response, error) {
+	if r.Ref == "" {
+		return r, nil
+	}
+	name := strings.TrimPrefix(r.Ref, "#/components/responses/")
+	resolved, ok := o.Components.Responses[name]
+	if name == r.Ref || !ok || resolved.Ref != "" {
+		return nil, fmt.Errorf("unresolved response reference %s", r.Ref)
+	}
+	return resolved, nil
+}
+
+// validate validates a value decoded from JSON against the schema
+func (o *OpenAPI) validate(s *Schema, value interface{}, path string) error {
+	s, err := o.resolve(s)
+	if err != nil {
+		return err
+	}
+
+	if value == nil {
+		if s.Nullable || (s.Type == "" && len(s.OneOf) == 0) {
+			return nil
+		}
+		return fmt.Errorf("%s can't be null", path)
+	}
+
+	if len(s.OneOf) > 0 {
+		matches := 0
+		for _, option := range s.OneOf {
+			if o.validate(option, value, path) == nil {
+				matches++
+			}
+		}
+		if matches != 1 {
+			return fmt.Errorf("%s must match exactly one schema, but matches %d", path, matches)
+		}
+		return nil
+	}
+
+	if len(s.Enum) > 0 && !containsValue(s.Enum, value) {
+		return fmt.Errorf("%s must be one of %v", path, s.Enum)
+	}
+
+	switch s.Type {
+	case "":
+		return nil
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s must be an object", path)
+		}
+		for _, name := range s.Required {
+			if _, ok := object[name]; !ok {
+				return fmt.Errorf("%s.%s is required", path, name)
+			}
+		}
+		for name, v := range object {
+			property, ok := s.Properties[name]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					return fmt.Errorf("%s.%s is not allowed", path, name)
+				}
+				continue
+			}
+			if err := o.validate(property, v, path+"."+name); err != nil {
+				return err
+			}
+		}
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s must be an array", path)
+		}
+		if s.Items != nil {
+			for i, v := range array {
+				if err := o.validate(s.Items, v, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s must be a string", path)
+		}
+		n := len([]rune(str))
+		if s.MinLength != nil && n < *s.MinLength {
+			return fmt.Errorf("%s must have at least %d characters", path, *s.MinLength)
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			return fmt.Errorf("%s must have at most %d characters", path, *s.MaxLength)
+		}
+	case "number", "integer":
+		number, ok := value.(float64)
+		if !ok {
+			return fmt.Errorf("%s must be a %s", path, s.Type)
+		}
+		if s.Type == "integer" && number != math.Trunc(number) {
+			return fmt.Errorf("%s must be an integer", path)
+		}
+		if s.Minimum != nil && number < *s.Minimum {
+			return fmt.Errorf("%s must be greater than or equal to %v", path, *s.Minimum)
+		}
+		if s.Maximum != nil && number > *s.Maximum {
+			return fmt.Errorf("%s must be lower than or equal to %v", path, *s.Maximum)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s must be a boolean", path)
+		}
+	default:
+		return fmt.Errorf("unsupported schema type %s", s.Type)
+	}
+	return nil
+}
+
+// parameterValue converts the string value of a parameter to the type of its
+// schema, so that it can be validated as if it came from JSON
+func parameterValue(o *OpenAPI, s *Schema, value string) interface{} {
+	s, err := o.resolve(s)
+	if err != nil {
+		return value
+	}
+	switch s.Type {
+	case "number", "integer":
+		if n, err := strconv.ParseFloat(value, 64); err == nil {
+			return n
+		}
+	case "boolean":
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return value
+}
+
+func containsValue(values []interface{}, value interface{}) bool {
+	for _, v := range values {
+		if reflect.DeepEqual(v, value) {
+			return true
+		}
+	}
+	return false
+}
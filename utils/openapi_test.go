@@ -0,0 +1,168 @@
+package utils
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testOpenAPIDocument = `{
+  "openapi": "3.0.3",
+  "components": {
+    "schemas": {
+      "Options": {
+        "type": "object",
+        "required": ["enabled"],
+        "additionalProperties": false,
+        "properties": {
+          "enabled": {"type": "boolean"},
+          "threshold": {"type": "number", "minimum": 5, "maximum": 30},
+          "mode": {"type": "string", "enum": ["eco", "comfort"]},
+          "tags": {"type": "array", "items": {"type": "string", "minLength": 1}}
+        }
+      }
+    }
+  },
+  "paths": {
+    "/rooms/{room}": {
+      "post": {
+        "parameters": [
+          {"name": "room", "in": "path", "required": true, "schema": {"type": "string"}},
+          {"name": "limit", "in": "query", "schema": {"type": "integer", "minimum": 1}}
+        ],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Options"}}}},
+        "responses": {
+          "200": {"description": "OK", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Options"}}}},
+          "204": {"description": "No content"}
+        }
+      }
+    }
+  }
+}`
+
+func TestParseOpenAPI(t *testing.T) {
+	testCases := []struct {
+		name          string
+		document      string
+		expectedError bool
+	}{
+		{
+			name:     "Valid document",
+			document: testOpenAPIDocument,
+		},
+		{
+			name:          "Invalid JSON",
+			document:      `{"openapi": `,
+			expectedError: true,
+		},
+		{
+			name:          "Unsupported version",
+			document:      `{"swagger": "2.0"}`,
+			expectedError: true,
+		},
+		{
+			name:          "Unresolved reference",
+			document:      `{"openapi": "3.0.3", "components": {"schemas": {"A": {"$ref": "#/components/schemas/B"}}}}`,
+			expectedError: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			_, err := ParseOpenAPI([]byte(tc.document))
+			if tc.expectedError {
+				assert.Error(tt, err)
+				return
+			}
+			assert.NoError(tt, err)
+		})
+	}
+}
+
+func TestValidateRequestBody(t *testing.T) {
+	spec, err := ParseOpenAPI([]byte(testOpenAPIDocument))
+	if !assert.NoError(t, err) {
+		return
+	}
+	op, ok := spec.Operation("POST", "/rooms/{room}")
+	if !assert.True(t, ok) {
+		return
+	}
+
+	testCases := []struct {
+		name          string
+		body          string
+		expectedError bool
+	}{
+		{
+			name: "Valid body",
+			body: `{"enabled": true, "threshold": 20.5, "mode": "eco", "tags": ["upstairs"]}`,
+		},
+		{
+			name:          "Empty body",
+			body:          ``,
+			expectedError: true,
+		},
+		{
+			name:          "Missing required property",
+			body:          `{"threshold": 20}`,
+			expectedError: true,
+		},
+		{
+			name:          "Wrong type",
+			body:          `{"enabled": "true"}`,
+			expectedError: true,
+		},
+		{
+			name:          "Number out of range",
+			body:          `{"enabled": true, "threshold": 210}`,
+			expectedError: true,
+		},
+		{
+			name:          "Value not in enum",
+			body:          `{"enabled": true, "mode": "turbo"}`,
+			expectedError: true,
+		},
+		{
+			name:          "Invalid array item",
+			body:          `{"enabled": true, "tags": [""]}`,
+			expectedError: true,
+		},
+		{
+			name:          "Additional property",
+			body:          `{"enabled": true, "colour": "red"}`,
+			expectedError: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			err := spec.ValidateRequestBody(op, "application/json; charset=UTF-8", []byte(tc.body))
+			if tc.expectedError {
+				assert.Error(tt, err)
+				return
+			}
+			assert.NoError(tt, err)
+		})
+	}
+}
+
+func TestValidateParameters(t *testing.T) {
+	spec, _ := ParseOpenAPI([]byte(testOpenAPIDocument))
+	op, _ := spec.Operation("POST", "/rooms/{room}")
+
+	assert.NoError(t, spec.ValidateParameters(op, map[string]string{"room": "bedroom"}, url.Values{"limit": {"10"}}))
+	assert.Error(t, spec.ValidateParameters(op, map[string]string{}, url.Values{}))
+	assert.Error(t, spec.ValidateParameters(op, map[string]string{"room": "bedroom"}, url.Values{"limit": {"0"}}))
+	assert.Error(t, spec.ValidateParameters(op, map[string]string{"room": "bedroom"}, url.Values{"limit": {"1.5"}}))
+}
+
+func TestValidateResponse(t *testing.T) {
+	spec, _ := ParseOpenAPI([]byte(testOpenAPIDocument))
+	op, _ := spec.Operation("POST", "/rooms/{room}")
+
+	assert.NoError(t, spec.ValidateResponse(op, 200, "application/json", []byte(`{"enabled": false}`)))
+	assert.NoError(t, spec.ValidateResponse(op, 204, "", nil))
+	assert.Error(t, spec.ValidateResponse(op, 200, "application/json", []byte(`{"enabled": 1}`)))
+	assert.Error(t, spec.ValidateResponse(op, 200, "text/plain", []byte(`enabled`)))
+	assert.Error(t, spec.ValidateResponse(op, 500, "application/json", []byte(`{"message": "error"}`)))
+}
@@ -0,0 +1,394 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// ParquetType is the type of the values of a column of a Parquet file
+type ParquetType int
+
+const (
+	// ParquetString columns have UTF-8 strings
+	ParquetString ParquetType = iota
+
+	// ParquetInt64 columns have int64 values
+	ParquetInt64
+
+	// ParquetDouble columns have float64 values
+	ParquetDouble
+
+	// ParquetBoolean columns have bool values
+	ParquetBoolean
+
+	// ParquetTimestamp columns have time.Time values, stored in milliseconds
+	ParquetTimestamp
+)
+
+// ParquetColumn is a column of a Parquet file. Every column is optional, so
+// any value can be nil.
+type ParquetColumn struct {
+	Name string
+	Type ParquetType
+}
+
+// Physical types, encodings and other enums of the Parquet format, see
+// https://github.com/apache/parquet-format/blob/master/src/main/thrift/parquet.thrift
+const (
+	parquetMagic = "PAR1"
+
+	parquetBoolean   = 0
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetConvertedUTF8            = 0
+	parquetConvertedTimestampMillis = 9
+
+	parquetOptional     = 1
+	parquetPlain        = 0
+	parquetRLE          = 3
+	parquetUncompressed = 0
+	parquetDataPage     = 0
+)
+
+// DefaultParquetRowGroupSize is the number of rows of the row groups of the
+// files written by a ParquetWriter, which keeps one row group in memory
+const DefaultParquetRowGroupSize = 10000
+
+// ParquetWriter writes rows to a Parquet file, uncompressed and with plain
+// encoding, so that it can be read by tools such as pandas or Spark. Rows
+// are written in row groups as they're added, and the footer when the
+// writer is closed.
+type ParquetWriter struct {
+	w            io.Writer
+	columns      []ParquetColumn
+	rowGroupSize int
+	offset       int64
+	rows         [][]interface{}
+	rowGroups    [][]byte
+	numRows      int64
+}
+
+// NewParquetWriter returns a writer of a Parquet file with the columns
+func NewParquetWriter(w io.Writer, columns []ParquetColumn) *ParquetWriter {
+	return &ParquetWriter{w: w, columns: columns, rowGroupSize: DefaultParquetRowGroupSize}
+}
+
+// Write adds a row, with one value per column
+func (p *ParquetWriter) Write(row []interface{}) error {
+	if len(row) != len(p.columns) {
+		return fmt.Errorf("invalid Parquet row with %d values for %d columns", len(row), len(p.columns))
+	}
+	for i, value := range row {
+		if value != nil && !p.columns[i].Type.accepts(value) {
+			return fmt.Errorf("invalid value %v of Parquet column %s", value, p.columns[i].Name)
+		}
+	}
+	p.rows = append(p.rows, row)
+	if len(p.rows) >= p.rowGroupSize {
+		return p.flush()
+	}
+	return nil
+}
+
+// Close writes the rows left and the footer of the file. It doesn't close
+// the underlying writer.
+func (p *ParquetWriter) Close() error {
+	if err := p.flush(); err != nil {
+		return err
+	}
+	if err := p.writeHeader(); err != nil {
+		return err
+	}
+
+	footer := &thriftWriter{}
+	footer.i32(1, 1)
+	footer.listBegin(2, thriftStruct, len(p.columns)+1)
+	footer.structBegin()
+	footer.binary(4, []byte("schema"))
+	footer.i32(5, int32(len(p.columns)))
+	footer.structEnd()
+	for _, column := range p.columns {
+		footer.structBegin()
+		footer.i32(1, column.Type.physical())
+		footer.i32(3, parquetOptional)
+		footer.binary(4, []byte(column.Name))
+		if converted, ok := column.Type.converted(); ok {
+			footer.i32(6, converted)
+		}
+		footer.structEnd()
+	}
+	footer.i64(3, p.numRows)
+	footer.listBegin(4, thriftStruct, len(p.rowGroups))
+	for _, rowGroup := range p.rowGroups {
+		footer.buf.Write(rowGroup)
+	}
+	footer.binary(6, []byte("smarthome"))
+	footer.stop()
+
+	length := make([]byte, 4)
+	binary.LittleEndian.PutUint32(length, uint32(footer.buf.Len()))
+	return p.write(footer.buf.Bytes(), length, []byte(parquetMagic))
+}
+
+// flush writes the rows added since the last row group as a new one
+func (p *ParquetWriter) flush() error {
+	if len(p.rows) == 0 {
+		return nil
+	}
+	if err := p.writeHeader(); err != nil {
+		return err
+	}
+
+	rowGroup := &thriftWriter{}
+	rowGroup.structBegin()
+	rowGroup.listBegin(1, thriftStruct, len(p.columns))
+	var totalSize int64
+	for i, column := range p.columns {
+		page := p.page(i)
+		header := &thriftWriter{}
+		header.i32(1, parquetDataPage)
+		header.i32(2, int32(len(page)))
+		header.i32(3, int32(len(page)))
+		header.structField(5)
+		header.i32(1, int32(len(p.rows)))
+		header.i32(2, parquetPlain)
+		header.i32(3, parquetRLE)
+		header.i32(4, parquetRLE)
+		header.structEnd()
+		header.stop()
+
+		pageOffset := p.offset
+		if err := p.write(header.buf.Bytes(), page); err != nil {
+			return err
+		}
+		size := int64(header.buf.Len() + len(page))
+		totalSize += size
+
+		rowGroup.structBegin()
+		rowGroup.i64(2, pageOffset)
+		rowGroup.structField(3)
+		rowGroup.i32(1, column.Type.physical())
+		rowGroup.listBegin(2, thriftI32, 2)
+		rowGroup.varint(zigzag(parquetPlain))
+		rowGroup.varint(zigzag(parquetRLE))
+		rowGroup.listBegin(3, thriftBinary, 1)
+		rowGroup.varint(uint64(len(column.Name)))
+		rowGroup.buf.WriteString(column.Name)
+		rowGroup.i32(4, parquetUncompressed)
+		rowGroup.i64(5, int64(len(p.rows)))
+		rowGroup.i64(6, size)
+		rowGroup.i64(7, size)
+		rowGroup.i64(9, pageOffset)
+		rowGroup.structEnd()
+		rowGroup.structEnd()
+	}
+	rowGroup.i64(2, totalSize)
+	rowGroup.i64(3, int64(len(p.rows)))
+	rowGroup.structEnd()
+
+	p.rowGroups = append(p.rowGroups, rowGroup.buf.Bytes())
+	p.numRows += int64(len(p.rows))
+	p.rows = p.rows[:0]
+	return nil
+}
+
+// page returns a data page with the values of a column of the rows: their
+// definition levels, telling which ones aren't null, and the values that
+// aren't null
+func (p *ParquetWriter) page(column int) []byte {
+	levels := make([]bool, len(p.rows))
+	values := &bytes.Buffer{}
+	booleans := []bool{}
+	for i, row := range p.rows {
+		if row[column] == nil {
+			continue
+		}
+		levels[i] = true
+		switch v := row[column].(type) {
+		case string:
+			binary.Write(values, binary.LittleEndian, uint32(len(v)))
+			values.WriteString(v)
+		case int64:
+			binary.Write(values, binary.LittleEndian, v)
+		case float64:
+			binary.Write(values, binary.LittleEndian, math.Float64bits(v))
+		case bool:
+			booleans = append(booleans, v)
+		case time.Time:
+			binary.Write(values, binary.LittleEndian, v.UnixNano()/int64(time.Millisecond))
+		}
+	}
+	if p.columns[column].Type == ParquetBoolean {
+		values.Write(bitPack(booleans))
+	}
+
+	// Levels are encoded as bit-packed runs of the RLE/bit-packing hybrid
+	// encoding, prefixed by their length
+	encoded := &thriftWriter{}
+	encoded.varint(uint64((len(levels)+7)/8)<<1 | 1)
+	encoded.buf.Write(bitPack(levels))
+	page := make([]byte, 4, 4+encoded.buf.Len()+values.Len())
+	binary.LittleEndian.PutUint32(page, uint32(encoded.buf.Len()))
+	page = append(page, encoded.buf.Bytes()...)
+	return append(page, values.Bytes()...)
+}
+
+// writeHeader writes the magic number the file starts with, unless it has
+// already been written
+func (p *ParquetWriter) writeHeader() error {
+	if p.offset > 0 {
+		return nil
+	}
+	return p.write([]byte(parquetMagic))
+}
+
+func (p *ParquetWriter) write(chunks ...[]byte) error {
+	for _, chunk := range chunks {
+		n, err := p.w.Write(chunk)
+		p.offset += int64(n)
+		if err != nil {
+			return fmt.Errorf("error writing Parquet file: %w", err)
+		}
+	}
+	return nil
+}
+
+func (t ParquetType) accepts(value interface{}) bool {
+	switch value.(type) {
+	case string:
+		return t == ParquetString
+	case int64:
+		return t == ParquetInt64
+	case float64:
+		return t == ParquetDouble
+	case bool:
+		return t == ParquetBoolean
+	case time.Time:
+		return t == ParquetTimestamp
+	}
+	return false
+}
+
+func (t ParquetType) physical() int32 {
+	switch t {
+	case ParquetString:
+		return parquetByteArray
+	case ParquetDouble:
+		return parquetDouble
+	case ParquetBoolean:
+		return parquetBoolean
+	}
+	return parquetInt64
+}
+
+func (t ParquetType) converted() (int32, bool) {
+	switch t {
+	case ParquetString:
+		return parquetConvertedUTF8, true
+	case ParquetTimestamp:
+		return parquetConvertedTimestampMillis, true
+	}
+	return 0, false
+}
+
+// bitPack packs booleans in bytes, from the least significant bit
+func bitPack(values []bool) []byte {
+	packed := make([]byte, (len(values)+7)/8)
+	for i, v := range values {
+		if v {
+			packed[i/8] |= 1 << uint(i%8)
+		}
+	}
+	return packed
+}
+
+// Types of the Thrift compact protocol, which the metadata of Parquet files
+// is encoded with
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes structs with the Thrift compact protocol, keeping
+// track of the id of the last field of each nested struct
+type thriftWriter struct {
+	buf    bytes.Buffer
+	fields []int
+	last   int
+}
+
+func (t *thriftWriter) field(id, kind int) {
+	if delta := id - t.last; delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta<<4 | kind))
+	} else {
+		t.buf.WriteByte(byte(kind))
+		t.varint(zigzag(int64(id)))
+	}
+	t.last = id
+}
+
+func (t *thriftWriter) i32(id int, v int32) {
+	t.field(id, thriftI32)
+	t.varint(zigzag(int64(v)))
+}
+
+func (t *thriftWriter) i64(id int, v int64) {
+	t.field(id, thriftI64)
+	t.varint(zigzag(v))
+}
+
+func (t *thriftWriter) binary(id int, v []byte) {
+	t.field(id, thriftBinary)
+	t.varint(uint64(len(v)))
+	t.buf.Write(v)
+}
+
+func (t *thriftWriter) listBegin(id, kind, size int) {
+	t.field(id, thriftList)
+	if size < 15 {
+		t.buf.WriteByte(byte(size<<4 | kind))
+		return
+	}
+	t.buf.WriteByte(byte(0xf0 | kind))
+	t.varint(uint64(size))
+}
+
+// structField begins a struct that is a field of the current struct
+func (t *thriftWriter) structField(id int) {
+	t.field(id, thriftStruct)
+	t.structBegin()
+}
+
+// structBegin begins a struct, either a field or an element of a list
+func (t *thriftWriter) structBegin() {
+	t.fields = append(t.fields, t.last)
+	t.last = 0
+}
+
+func (t *thriftWriter) structEnd() {
+	t.stop()
+	t.last = t.fields[len(t.fields)-1]
+	t.fields = t.fields[:len(t.fields)-1]
+}
+
+func (t *thriftWriter) stop() {
+	t.buf.WriteByte(0)
+}
+
+func (t *thriftWriter) varint(v uint64) {
+	b := make([]byte, binary.MaxVarintLen64)
+	t.buf.Write(b[:binary.PutUvarint(b, v)])
+}
+
+func zigzag(v int64) uint64 {
+	return uint64((v << 1) ^ (v >> 63))
+}
@@ -0,0 +1,173 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// thriftReader decodes structs of the Thrift compact protocol into maps of
+// their fields by id, to check the metadata written by ParquetWriter
+type thriftReader struct {
+	r *bytes.Reader
+}
+
+func (t *thriftReader) readStruct() map[int]interface{} {
+	fields := map[int]interface{}{}
+	last := 0
+	for {
+		header, _ := t.r.ReadByte()
+		if header == 0 {
+			return fields
+		}
+		id := last + int(header>>4)
+		if header>>4 == 0 {
+			v, _ := binary.ReadUvarint(t.r)
+			id = int(unzigzag(v))
+		}
+		last = id
+		fields[id] = t.readValue(int(header & 0x0f))
+	}
+}
+
+func (t *thriftReader) readValue(kind int) interface{} {
+	switch kind {
+	case thriftI32, thriftI64:
+		v, _ := binary.ReadUvarint(t.r)
+		return unzigzag(v)
+	case thriftBinary:
+		n, _ := binary.ReadUvarint(t.r)
+		b := make([]byte, n)
+		t.r.Read(b)
+		return string(b)
+	case thriftList:
+		header, _ := t.r.ReadByte()
+		size := int(header >> 4)
+		if size == 15 {
+			n, _ := binary.ReadUvarint(t.r)
+			size = int(n)
+		}
+		list := make([]interface{}, size)
+		for i := range list {
+			list[i] = t.readValue(int(header & 0x0f))
+		}
+		return list
+	case thriftStruct:
+		return t.readStruct()
+	}
+	return nil
+}
+
+func unzigzag(v uint64) int64 {
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func TestParquetWriter(t *testing.T) {
+	columns := []ParquetColumn{
+		{Name: "room", Type: ParquetString},
+		{Name: "time", Type: ParquetTimestamp},
+		{Name: "avg", Type: ParquetDouble},
+		{Name: "count", Type: ParquetInt64},
+		{Name: "enabled", Type: ParquetBoolean},
+	}
+	at := time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC)
+	rows := [][]interface{}{
+		{"bedroom", at, 20.5, int64(12), nil},
+		{"livingroom", at.Add(time.Hour), nil, nil, true},
+		{nil, at.Add(2 * time.Hour), -1.25, int64(3), false},
+	}
+
+	buf := &bytes.Buffer{}
+	p := NewParquetWriter(buf, columns)
+	p.rowGroupSize = 2
+	for _, row := range rows {
+		assert.NoError(t, p.Write(row))
+	}
+	assert.Error(t, p.Write([]interface{}{"bedroom"}))
+	assert.Error(t, p.Write([]interface{}{int64(1), nil, nil, nil, nil}))
+	assert.NoError(t, p.Close())
+
+	file := buf.Bytes()
+	assert.Equal(t, parquetMagic, string(file[:4]))
+	assert.Equal(t, parquetMagic, string(file[len(file)-4:]))
+	footerLength := int(binary.LittleEndian.Uint32(file[len(file)-8:]))
+	footer := (&thriftReader{r: bytes.NewReader(file[len(file)-8-footerLength : len(file)-8])}).readStruct()
+
+	assert.Equal(t, int64(3), footer[3])
+	schema := footer[2].([]interface{})
+	if assert.Len(t, schema, len(columns)+1) {
+		assert.Equal(t, int64(len(columns)), schema[0].(map[int]interface{})[5])
+		for i, column := range columns {
+			assert.Equal(t, column.Name, schema[i+1].(map[int]interface{})[4])
+		}
+	}
+
+	// Read back the values of every column of every row group
+	values := make([][]interface{}, len(columns))
+	rowGroups := footer[4].([]interface{})
+	assert.Len(t, rowGroups, 2)
+	for _, rg := range rowGroups {
+		chunks := rg.(map[int]interface{})[1].([]interface{})
+		numRows := int(rg.(map[int]interface{})[3].(int64))
+		for i, chunk := range chunks {
+			meta := chunk.(map[int]interface{})[3].(map[int]interface{})
+			r := bytes.NewReader(file[meta[9].(int64):])
+			header := (&thriftReader{r: r}).readStruct()
+			page := make([]byte, header[2].(int64))
+			r.Read(page)
+
+			levelsLength := int(binary.LittleEndian.Uint32(page))
+			levels := page[4+1 : 4+levelsLength]
+			data := bytes.NewReader(page[4+levelsLength:])
+			booleans := page[4+levelsLength:]
+			present := 0
+			for row := 0; row < numRows; row++ {
+				if levels[row/8]&(1<<uint(row%8)) == 0 {
+					values[i] = append(values[i], nil)
+					continue
+				}
+				var v interface{}
+				switch columns[i].Type {
+				case ParquetString:
+					var n uint32
+					binary.Read(data, binary.LittleEndian, &n)
+					b := make([]byte, n)
+					data.Read(b)
+					v = string(b)
+				case ParquetTimestamp:
+					var ms int64
+					binary.Read(data, binary.LittleEndian, &ms)
+					v = time.Unix(0, ms*int64(time.Millisecond)).UTC()
+				case ParquetDouble:
+					var bits uint64
+					binary.Read(data, binary.LittleEndian, &bits)
+					v = math.Float64frombits(bits)
+				case ParquetInt64:
+					var n int64
+					binary.Read(data, binary.LittleEndian, &n)
+					v = n
+				case ParquetBoolean:
+					v = booleans[present/8]&(1<<uint(present%8)) != 0
+				}
+				present++
+				values[i] = append(values[i], v)
+			}
+		}
+	}
+	for row := range rows {
+		for i := range columns {
+			assert.Equal(t, rows[row][i], values[i][row], "row %d column %s", row, columns[i].Name)
+		}
+	}
+}
+
+func TestParquetWriterEmpty(t *testing.T) {
+	buf := &bytes.Buffer{}
+	assert.NoError(t, NewParquetWriter(buf, []ParquetColumn{{Name: "room", Type: ParquetString}}).Close())
+	assert.Equal(t, parquetMagic, buf.String()[:4])
+	assert.Equal(t, parquetMagic, buf.String()[buf.Len()-4:])
+}
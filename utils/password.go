@@ -0,0 +1,33 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// LoadPasswordList reads a file with one password per line, such as a list
+// of breached passwords, and returns them lowercased as a set. Empty lines
+// and lines starting with # are ignored.
+func LoadPasswordList(path string) (map[string]struct{}, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening password list %s: %w", path, err)
+	}
+	defer f.Close()
+
+	passwords := map[string]struct{}{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		passwords[strings.ToLower(line)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading password list %s: %w", path, err)
+	}
+	return passwords, nil
+}
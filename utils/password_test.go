@@ -0,0 +1,21 @@
+package utils
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadPasswordList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	ioutil.WriteFile(path, []byte("# Most common passwords\n123456\n\nPassword\n  qwerty  \n"), 0600)
+
+	passwords, err := LoadPasswordList(path)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]struct{}{"123456": {}, "password": {}, "qwerty": {}}, passwords)
+
+	_, err = LoadPasswordList(filepath.Join(t.TempDir(), "missing.txt"))
+	assert.Error(t, err)
+}
@@ -1,5 +1,10 @@
 package utils
 
+import (
+	"strings"
+	"unicode"
+)
+
 // AllButOne receives an array of strings as a parameter and returns the same
 // array without one of its items, which is passed as the second parameter.
 func AllButOne(items []string, item string) []string {
@@ -12,3 +17,25 @@ func AllButOne(items []string, item string) []string {
 	}
 	return result
 }
+
+// Contains returns true if item is one of the elements of items.
+func Contains(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
+
+// SplitList splits a list of values separated by commas or whitespace, as
+// they're usually passed in environment variables, skipping empty values.
+func SplitList(values ...string) []string {
+	result := []string{}
+	for _, value := range values {
+		result = append(result, strings.FieldsFunc(value, func(r rune) bool {
+			return r == ',' || unicode.IsSpace(r)
+		})...)
+	}
+	return result
+}
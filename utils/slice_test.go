@@ -46,3 +46,74 @@ func TestAllButOne(t *testing.T) {
 		})
 	}
 }
+
+func TestContains(t *testing.T) {
+	testCases := []struct {
+		name     string
+		items    []string
+		item     string
+		expected bool
+	}{
+		{
+			name:     "Matching element",
+			items:    []string{"one", "two", "three"},
+			item:     "two",
+			expected: true,
+		},
+		{
+			name:     "Non-matching element",
+			items:    []string{"one", "two", "three"},
+			item:     "four",
+			expected: false,
+		},
+		{
+			name:     "No items in array",
+			items:    []string{},
+			item:     "one",
+			expected: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			actual := Contains(tc.items, tc.item)
+			assert.Equal(tt, tc.expected, actual)
+		})
+	}
+}
+
+func TestSplitList(t *testing.T) {
+	testCases := []struct {
+		name     string
+		values   []string
+		expected []string
+	}{
+		{
+			name:     "Single value",
+			values:   []string{"current.pem"},
+			expected: []string{"current.pem"},
+		},
+		{
+			name:     "Comma separated values",
+			values:   []string{"current.pem,previous.pem"},
+			expected: []string{"current.pem", "previous.pem"},
+		},
+		{
+			name:     "Whitespace and commas",
+			values:   []string{" current.pem, previous.pem ", "old.pem"},
+			expected: []string{"current.pem", "previous.pem", "old.pem"},
+		},
+		{
+			name:     "Empty value",
+			values:   []string{""},
+			expected: []string{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			actual := SplitList(tc.values...)
+			assert.Equal(tt, tc.expected, actual)
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeCertificate(t *testing.T, dir, commonName string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	return certFile, keyFile
+}
+
+func TestCertificateReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeCertificate(t, dir, "first")
+
+	_, err := NewCertificateReloader(filepath.Join(dir, "missing.pem"), keyFile)
+	assert.Error(t, err)
+
+	r, err := NewCertificateReloader(certFile, keyFile)
+	assert.NoError(t, err)
+
+	reloaded, err := r.Reload()
+	assert.NoError(t, err)
+	assert.False(t, reloaded)
+
+	first, _ := r.GetCertificate(nil)
+	writeCertificate(t, dir, "second")
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+
+	reloaded, err = r.Reload()
+	assert.NoError(t, err)
+	assert.True(t, reloaded)
+	second, _ := r.GetCertificate(nil)
+	assert.NotEqual(t, first.Certificate, second.Certificate)
+
+	ioutil.WriteFile(keyFile, []byte("invalid"), 0600)
+	evenLater := later.Add(time.Minute)
+	os.Chtimes(keyFile, evenLater, evenLater)
+	_, err = r.Reload()
+	assert.Error(t, err)
+	current, _ := r.GetCertificate(nil)
+	assert.Equal(t, second, current)
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeCertificate(t, dir, "server")
+	r, _ := NewCertificateReloader(certFile, keyFile)
+	invalidCA := filepath.Join(dir, "invalid.pem")
+	ioutil.WriteFile(invalidCA, []byte("invalid"), 0600)
+
+	testCases := []struct {
+		name               string
+		clientCAFile       string
+		expectedClientAuth tls.ClientAuthType
+		expectedError      bool
+	}{
+		{
+			name:               "No client CA",
+			clientCAFile:       "",
+			expectedClientAuth: tls.NoClientCert,
+		},
+		{
+			name:               "Valid client CA",
+			clientCAFile:       certFile,
+			expectedClientAuth: tls.VerifyClientCertIfGiven,
+		},
+		{
+			name:          "Missing client CA file",
+			clientCAFile:  filepath.Join(dir, "missing.pem"),
+			expectedError: true,
+		},
+		{
+			name:          "Client CA file without certificates",
+			clientCAFile:  invalidCA,
+			expectedError: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			cfg, err := NewTLSConfig(r, tc.clientCAFile)
+			if tc.expectedError {
+				assert.Error(tt, err)
+				return
+			}
+			assert.NoError(tt, err)
+			assert.Equal(tt, tc.expectedClientAuth, cfg.ClientAuth)
+		})
+	}
+}
+
+func TestHTTPSRedirectHandler(t *testing.T) {
+	testCases := []struct {
+		name     string
+		port     int
+		target   string
+		expected string
+	}{
+		{
+			name:     "Default HTTPS port",
+			port:     443,
+			target:   "http://smarthome.local:8080/v1/room/bedroom?unit=C",
+			expected: "https://smarthome.local/v1/room/bedroom?unit=C",
+		},
+		{
+			name:     "Custom HTTPS port",
+			port:     8443,
+			target:   "http://smarthome.local/v1/login",
+			expected: "https://smarthome.local:8443/v1/login",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			rec := httptest.NewRecorder()
+			HTTPSRedirectHandler(tc.port).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.target, nil))
+			assert.Equal(tt, http.StatusMovedPermanently, rec.Code)
+			assert.Equal(tt, tc.expected, rec.Header().Get("Location"))
+		})
+	}
+}
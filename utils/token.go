@@ -7,30 +7,36 @@ import (
 	"github.com/dgrijalva/jwt-go"
 )
 
-// ValidateTokenFromHeader validates a JWT token from an Authorization header, with
-// the secret used to sign the token.
-func ValidateTokenFromHeader(header, secret string) error {
-	if secret == "" {
+// ValidateTokenFromHeader validates a JWT access token from an Authorization
+// header, against the keys that may have been used to sign the token. If the
+// key set is empty, authentication is disabled and no error is returned.
+func ValidateTokenFromHeader(header string, keys *KeySet) error {
+	if keys.Empty() {
 		return nil
 	}
+	_, err := ParseTokenFromHeader(header, keys)
+	return err
+}
 
+// ParseTokenFromHeader parses and validates a JWT access token from an
+// Authorization header, against the keys that may have been used to sign
+// the token
+func ParseTokenFromHeader(header string, keys *KeySet) (*jwt.Token, error) {
 	splitToken := strings.Split(header, "Bearer")
 
 	if len(splitToken) != 2 {
-		return fmt.Errorf("error getting token from Authorization header: header is not in proper format")
+		return nil, fmt.Errorf("error getting token from Authorization header: header is not in proper format")
 	}
 
-	tok, err := jwt.Parse(strings.Trim(splitToken[1], " "), func(t *jwt.Token) (interface{}, error) {
-		return []byte(secret), nil
-	})
+	tok, err := keys.ParseAccessToken(strings.Trim(splitToken[1], " "))
 
 	if err != nil {
-		return fmt.Errorf("error parsing token: %w", err)
+		return nil, fmt.Errorf("error parsing token: %w", err)
 	}
 
 	if !tok.Valid {
-		return fmt.Errorf("token is invalid")
+		return nil, fmt.Errorf("token is invalid")
 	}
 
-	return nil
+	return tok, nil
 }
@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHOTP(t *testing.T) {
+	// Test vectors from RFC 4226, appendix D
+	key := []byte("12345678901234567890")
+	expected := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	for counter, code := range expected {
+		assert.Equal(t, code, hotp(key, uint64(counter)))
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(59, 0)
+	code, err := TOTPCode(secret, now)
+	assert.NoError(t, err)
+	// RFC 6238 test vector for SHA1 at T=59, truncated to 6 digits
+	assert.Equal(t, "287082", code)
+
+	testCases := []struct {
+		name     string
+		code     string
+		at       time.Time
+		expected bool
+	}{
+		{
+			name:     "Current code",
+			code:     code,
+			at:       now,
+			expected: true,
+		},
+		{
+			name:     "Code from the previous time step",
+			code:     code,
+			at:       now.Add(TOTPPeriod),
+			expected: true,
+		},
+		{
+			name:     "Code too old",
+			code:     code,
+			at:       now.Add(3 * TOTPPeriod),
+			expected: false,
+		},
+		{
+			name:     "Wrong code",
+			code:     "000000",
+			at:       now,
+			expected: false,
+		},
+		{
+			name:     "Code with wrong length",
+			code:     "28708",
+			at:       now,
+			expected: false,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			counter, ok := ValidateTOTP(secret, tc.code, tc.at)
+			assert.Equal(tt, tc.expected, ok)
+			if ok {
+				assert.Equal(tt, uint64(1), counter)
+			}
+		})
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	secret, err := NewTOTPSecret()
+	assert.NoError(t, err)
+	uri := TOTPURI("SmartHome", "admin@example.com", secret)
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/SmartHome:admin@example.com?"))
+	assert.Contains(t, uri, "secret="+secret)
+}
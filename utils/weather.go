@@ -0,0 +1,344 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// WeatherProviderOpenMeteo is the name of the Open-Meteo weather provider
+	WeatherProviderOpenMeteo = "open-meteo"
+
+	// WeatherProviderFile is the name of the weather provider reading METAR
+	// reports or JSON from a file or URL
+	WeatherProviderFile = "file"
+
+	// DefaultOpenMeteoURL is the base URL of the Open-Meteo API
+	DefaultOpenMeteoURL = "https://api.open-meteo.com"
+
+	// DefaultForecastHours is how many hours ahead forecasts are fetched
+	DefaultForecastHours = 24
+)
+
+// WeatherPoint is an outside temperature, in Celsius, observed or forecast at
+// some time
+type WeatherPoint struct {
+	Time        time.Time `json:"time"`
+	Temperature float32   `json:"temperature"`
+}
+
+// WeatherReport are the temperatures fetched from a weather provider
+type WeatherReport struct {
+	Observations []WeatherPoint `json:"observations"`
+	Forecasts    []WeatherPoint `json:"forecasts"`
+}
+
+// WeatherProvider is a source of outside temperatures
+type WeatherProvider interface {
+	// Name returns the name of the provider, for logging
+	Name() string
+
+	// Fetch returns the latest observations, and the forecasts if the
+	// provider has any
+	Fetch(ctx context.Context) (*WeatherReport, error)
+}
+
+// WeatherProviderConfig are the settings a weather provider is created with
+type WeatherProviderConfig struct {
+	// Provider is either WeatherProviderOpenMeteo or WeatherProviderFile
+	Provider string
+
+	// Latitude and Longitude are the location of the home, for Open-Meteo
+	Latitude  float64
+	Longitude float64
+
+	// URL is the base URL of the Open-Meteo API, DefaultOpenMeteoURL if empty
+	URL string
+
+	// ForecastHours is how many hours ahead Open-Meteo forecasts are
+	// fetched, DefaultForecastHours if zero
+	ForecastHours int
+
+	// Location is the path or http(s) URL read by the file provider
+	Location string
+}
+
+// NewWeatherProvider returns the weather provider of a configuration
+func NewWeatherProvider(config WeatherProviderConfig) (WeatherProvider, error) {
+	switch config.Provider {
+	case WeatherProviderOpenMeteo:
+		return NewOpenMeteoProvider(config.URL, config.Latitude, config.Longitude, config.ForecastHours)
+	case WeatherProviderFile:
+		return NewFileWeatherProvider(config.Location)
+	}
+	return nil, fmt.Errorf("unknown weather provider %s. Valid providers: %s, %s", config.Provider, WeatherProviderOpenMeteo, WeatherProviderFile)
+}
+
+// OpenMeteoProvider fetches the current temperature and the hourly forecast
+// of a location from the Open-Meteo API
+type OpenMeteoProvider struct {
+	baseURL       string
+	latitude      float64
+	longitude     float64
+	forecastHours int
+	httpClient    *http.Client
+}
+
+// NewOpenMeteoProvider returns an OpenMeteoProvider for a location
+func NewOpenMeteoProvider(baseURL string, latitude, longitude float64, forecastHours int) (*OpenMeteoProvider, error) {
+	if latitude < -90 || latitude > 90 || longitude < -180 || longitude > 180 {
+		return nil, fmt.Errorf("invalid location %f, %f", latitude, longitude)
+	}
+	if forecastHours < 0 {
+		return nil, fmt.Errorf("invalid forecast hours %d: it can't be negative", forecastHours)
+	}
+	if baseURL == "" {
+		baseURL = DefaultOpenMeteoURL
+	}
+	if forecastHours == 0 {
+		forecastHours = DefaultForecastHours
+	}
+	return &OpenMeteoProvider{
+		baseURL:       strings.TrimSuffix(baseURL, "/"),
+		latitude:      latitude,
+		longitude:     longitude,
+		forecastHours: forecastHours,
+		httpClient:    &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// Name returns the name of the provider
+func (p *OpenMeteoProvider) Name() string {
+	return WeatherProviderOpenMeteo
+}
+
+type openMeteoResponse struct {
+	Current struct {
+		Time        int64    `json:"time"`
+		Temperature *float32 `json:"temperature_2m"`
+	} `json:"current"`
+	Hourly struct {
+		Time        []int64    `json:"time"`
+		Temperature []*float32 `json:"temperature_2m"`
+	} `json:"hourly"`
+}
+
+// Fetch returns the current temperature as an observation, and the hourly
+// temperatures after it as forecasts
+func (p *OpenMeteoProvider) Fetch(ctx context.Context) (*WeatherReport, error) {
+	v := url.Values{
+		"latitude":         {strconv.FormatFloat(p.latitude, 'f', -1, 64)},
+		"longitude":        {strconv.FormatFloat(p.longitude, 'f', -1, 64)},
+		"current":          {"temperature_2m"},
+		"hourly":           {"temperature_2m"},
+		"forecast_hours":   {strconv.Itoa(p.forecastHours)},
+		"temperature_unit": {"celsius"},
+		"timeformat":       {"unixtime"},
+	}
+	body, err := getBody(ctx, p.httpClient, p.baseURL+"/v1/forecast?"+v.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("error fetching weather from Open-Meteo: %w", err)
+	}
+
+	response := openMeteoResponse{}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("error decoding Open-Meteo response: %w", err)
+	}
+	if len(response.Hourly.Time) != len(response.Hourly.Temperature) {
+		return nil, fmt.Errorf("invalid Open-Meteo response: %d hourly times but %d temperatures",
+			len(response.Hourly.Time), len(response.Hourly.Temperature))
+	}
+
+	report := &WeatherReport{Observations: []WeatherPoint{}, Forecasts: []WeatherPoint{}}
+	current := time.Unix(response.Current.Time, 0).UTC()
+	if response.Current.Time != 0 && response.Current.Temperature != nil {
+		report.Observations = append(report.Observations, WeatherPoint{Time: current, Temperature: *response.Current.Temperature})
+	}
+	for i, t := range response.Hourly.Time {
+		forecast := time.Unix(t, 0).UTC()
+		if response.Hourly.Temperature[i] == nil || !forecast.After(current) {
+			continue
+		}
+		report.Forecasts = append(report.Forecasts, WeatherPoint{Time: forecast, Temperature: *response.Hourly.Temperature[i]})
+	}
+	return report, nil
+}
+
+// FileWeatherProvider reads the weather from a file or an http(s) URL, which
+// either has METAR reports, one per line, or a JSON WeatherReport. Lines of
+// dates, such as those of the METAR files of the NOAA, are used to know the
+// month of the reports that follow them.
+type FileWeatherProvider struct {
+	location   string
+	httpClient *http.Client
+}
+
+// NewFileWeatherProvider returns a FileWeatherProvider for a path or URL
+func NewFileWeatherProvider(location string) (*FileWeatherProvider, error) {
+	if location == "" {
+		return nil, fmt.Errorf("the location of the weather file is required")
+	}
+	return &FileWeatherProvider{
+		location:   location,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// Name returns the name of the provider
+func (p *FileWeatherProvider) Name() string {
+	return WeatherProviderFile
+}
+
+// Fetch reads the file and returns its observations and forecasts
+func (p *FileWeatherProvider) Fetch(ctx context.Context) (*WeatherReport, error) {
+	var body []byte
+	var err error
+	if strings.HasPrefix(p.location, "http://") || strings.HasPrefix(p.location, "https://") {
+		body, err = getBody(ctx, p.httpClient, p.location)
+	} else {
+		body, err = ioutil.ReadFile(p.location)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading weather from %s: %w", p.location, err)
+	}
+
+	body = bytes.TrimSpace(body)
+	if bytes.HasPrefix(body, []byte("{")) {
+		report := &WeatherReport{}
+		if err := json.Unmarshal(body, report); err != nil {
+			return nil, fmt.Errorf("error decoding weather from %s: %w", p.location, err)
+		}
+		return report, nil
+	}
+	return ParseMETARReports(bytes.NewReader(body), time.Now())
+}
+
+// metarDateLine matches the lines of dates of the METAR files of the NOAA,
+// e.g. 2021/01/01 10:30
+var metarDateLine = regexp.MustCompile(`^\d{4}/\d{2}/\d{2} \d{2}:\d{2}$`)
+
+// ParseMETARReports parses METAR reports, one per line, as observations.
+// Reports without temperature are skipped. The month of the reports is
+// worked out from the line of date before them, or from now.
+func ParseMETARReports(r io.Reader, now time.Time) (*WeatherReport, error) {
+	report := &WeatherReport{Observations: []WeatherPoint{}, Forecasts: []WeatherPoint{}}
+	reference := now
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if metarDateLine.MatchString(line) {
+			t, err := time.Parse("2006/01/02 15:04", line)
+			if err != nil {
+				return nil, fmt.Errorf("invalid date of METAR reports %s: %w", line, err)
+			}
+			reference = t
+			continue
+		}
+		point, err := ParseMETAR(line, reference)
+		if err != nil {
+			return nil, err
+		}
+		if point != nil {
+			report.Observations = append(report.Observations, *point)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading METAR reports: %w", err)
+	}
+	return report, nil
+}
+
+var (
+	metarTime        = regexp.MustCompile(`^(\d{2})(\d{2})(\d{2})Z$`)
+	metarTemperature = regexp.MustCompile(`^(M?\d{2})/(M?\d{2}|//)?$`)
+	metarRemarkTemp  = regexp.MustCompile(`^T([01])(\d{3})(?:[01]\d{3})?$`)
+)
+
+// ParseMETAR parses the time and the temperature of a METAR report, or
+// returns nil if it has no temperature. The report only has the day of the
+// month, so its month and year are the latest ones that don't place it more
+// than a day after reference. The temperature in tenths of the remarks is
+// used if present.
+func ParseMETAR(report string, reference time.Time) (*WeatherPoint, error) {
+	var point *WeatherPoint
+	var observed time.Time
+	remarks := false
+	for _, group := range strings.Fields(report) {
+		if group == "RMK" {
+			remarks = true
+			continue
+		}
+		if remarks {
+			if m := metarRemarkTemp.FindStringSubmatch(group); m != nil && point != nil {
+				tenths, _ := strconv.Atoi(m[2])
+				point.Temperature = float32(tenths) / 10
+				if m[1] == "1" {
+					point.Temperature = -point.Temperature
+				}
+			}
+			continue
+		}
+		if m := metarTime.FindStringSubmatch(group); m != nil && observed.IsZero() {
+			day, _ := strconv.Atoi(m[1])
+			hour, _ := strconv.Atoi(m[2])
+			minute, _ := strconv.Atoi(m[3])
+			if day < 1 || day > 31 || hour > 23 || minute > 59 {
+				return nil, fmt.Errorf("invalid time %s of METAR report %s", group, report)
+			}
+			// Starting from the month after the reference, as a report can be
+			// up to a day after it, e.g. just after midnight of the last day
+			reference = reference.UTC()
+			for month := 1; ; month-- {
+				observed = time.Date(reference.Year(), reference.Month()+time.Month(month), day, hour, minute, 0, 0, time.UTC)
+				if observed.Day() == day && !observed.After(reference.Add(24*time.Hour)) {
+					break
+				}
+			}
+			continue
+		}
+		if m := metarTemperature.FindStringSubmatch(group); m != nil && point == nil {
+			temperature, _ := strconv.Atoi(strings.Replace(m[1], "M", "-", 1))
+			point = &WeatherPoint{Temperature: float32(temperature)}
+		}
+	}
+	if point == nil {
+		return nil, nil
+	}
+	if observed.IsZero() {
+		return nil, fmt.Errorf("missing time of METAR report %s", report)
+	}
+	point.Time = observed
+	return point, nil
+}
+
+// getBody returns the body of a GET request, or an error unless it succeeds
+func getBody(ctx context.Context, client *http.Client, u string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("status %d: %s", resp.StatusCode, body)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
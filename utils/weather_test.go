@@ -0,0 +1,315 @@
+package utils
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenMeteoProviderFetch(t *testing.T) {
+	current := time.Date(2021, 1, 1, 10, 15, 0, 0, time.UTC)
+	testCases := []struct {
+		name          string
+		status        int
+		body          string
+		expected      *WeatherReport
+		expectedError bool
+	}{
+		{
+			name:   "Current temperature and forecast",
+			status: http.StatusOK,
+			body: `{
+				"current": {"time": 1609496100, "interval": 900, "temperature_2m": -1.5},
+				"hourly": {"time": [1609495200, 1609498800, 1609502400, 1609506000], "temperature_2m": [-1.8, -0.5, null, 2.1]}
+			}`,
+			expected: &WeatherReport{
+				Observations: []WeatherPoint{{Time: current, Temperature: -1.5}},
+				Forecasts: []WeatherPoint{
+					{Time: current.Add(45 * time.Minute), Temperature: -0.5},
+					{Time: current.Add(165 * time.Minute), Temperature: 2.1},
+				},
+			},
+		},
+		{
+			name:   "No current temperature",
+			status: http.StatusOK,
+			body:   `{"current": {"time": 1609496100, "temperature_2m": null}, "hourly": {"time": [], "temperature_2m": []}}`,
+			expected: &WeatherReport{
+				Observations: []WeatherPoint{},
+				Forecasts:    []WeatherPoint{},
+			},
+		},
+		{
+			name:          "Mismatched hourly temperatures",
+			status:        http.StatusOK,
+			body:          `{"current": {"time": 1609496100, "temperature_2m": 1}, "hourly": {"time": [1609498800], "temperature_2m": []}}`,
+			expectedError: true,
+		},
+		{
+			name:          "Error response",
+			status:        http.StatusBadRequest,
+			body:          `{"error": true, "reason": "Latitude must be in range of -90 to 90°"}`,
+			expectedError: true,
+		},
+		{
+			name:          "Invalid JSON",
+			status:        http.StatusOK,
+			body:          `not json`,
+			expectedError: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			var query map[string][]string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(tt, "/v1/forecast", r.URL.Path)
+				query = r.URL.Query()
+				w.WriteHeader(tc.status)
+				w.Write([]byte(tc.body))
+			}))
+			defer server.Close()
+
+			p, err := NewOpenMeteoProvider(server.URL+"/", 40.4, -3.7, 12)
+			if !assert.NoError(tt, err) {
+				return
+			}
+			report, err := p.Fetch(context.TODO())
+			assert.Equal(tt, []string{"40.4"}, query["latitude"])
+			assert.Equal(tt, []string{"-3.7"}, query["longitude"])
+			assert.Equal(tt, []string{"12"}, query["forecast_hours"])
+			if tc.expectedError {
+				assert.Error(tt, err)
+				return
+			}
+			assert.NoError(tt, err)
+			assert.Equal(tt, tc.expected, report)
+		})
+	}
+}
+
+func TestNewWeatherProvider(t *testing.T) {
+	testCases := []struct {
+		name          string
+		config        WeatherProviderConfig
+		expectedName  string
+		expectedError bool
+	}{
+		{
+			name:         "Open-Meteo",
+			config:       WeatherProviderConfig{Provider: WeatherProviderOpenMeteo, Latitude: 40.4, Longitude: -3.7},
+			expectedName: WeatherProviderOpenMeteo,
+		},
+		{
+			name:          "Open-Meteo with an invalid location",
+			config:        WeatherProviderConfig{Provider: WeatherProviderOpenMeteo, Latitude: 120},
+			expectedError: true,
+		},
+		{
+			name:         "File",
+			config:       WeatherProviderConfig{Provider: WeatherProviderFile, Location: "weather.json"},
+			expectedName: WeatherProviderFile,
+		},
+		{
+			name:          "File without location",
+			config:        WeatherProviderConfig{Provider: WeatherProviderFile},
+			expectedError: true,
+		},
+		{
+			name:          "Unknown provider",
+			config:        WeatherProviderConfig{Provider: "aemet"},
+			expectedError: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			p, err := NewWeatherProvider(tc.config)
+			if tc.expectedError {
+				assert.Error(tt, err)
+				return
+			}
+			assert.NoError(tt, err)
+			assert.Equal(tt, tc.expectedName, p.Name())
+		})
+	}
+}
+
+func TestFileWeatherProviderFetch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "weather")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	metar := "2021/01/01 10:30\nLEMD 011030Z 24005KT CAVOK M02/M05 Q1025 NOSIG\n"
+	json := `{"observations": [{"time": "2021-01-01T10:00:00Z", "temperature": 3.5}], "forecasts": [{"time": "2021-01-01T12:00:00Z", "temperature": 6}]}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/LEMD.TXT" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(metar))
+	}))
+	defer server.Close()
+
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	observed := time.Date(2021, 1, 1, 10, 30, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name          string
+		location      string
+		expected      *WeatherReport
+		expectedError bool
+	}{
+		{
+			name:     "METAR file",
+			location: write("LEMD.TXT", metar),
+			expected: &WeatherReport{Observations: []WeatherPoint{{Time: observed, Temperature: -2}}, Forecasts: []WeatherPoint{}},
+		},
+		{
+			name:     "METAR URL",
+			location: server.URL + "/LEMD.TXT",
+			expected: &WeatherReport{Observations: []WeatherPoint{{Time: observed, Temperature: -2}}, Forecasts: []WeatherPoint{}},
+		},
+		{
+			name:     "JSON file",
+			location: write("weather.json", json),
+			expected: &WeatherReport{
+				Observations: []WeatherPoint{{Time: observed.Add(-30 * time.Minute), Temperature: 3.5}},
+				Forecasts:    []WeatherPoint{{Time: observed.Add(90 * time.Minute), Temperature: 6}},
+			},
+		},
+		{
+			name:          "Invalid JSON file",
+			location:      write("invalid.json", `{"observations": 1}`),
+			expectedError: true,
+		},
+		{
+			name:          "Missing file",
+			location:      filepath.Join(dir, "missing.txt"),
+			expectedError: true,
+		},
+		{
+			name:          "URL not found",
+			location:      server.URL + "/LEBL.TXT",
+			expectedError: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			p, err := NewFileWeatherProvider(tc.location)
+			if !assert.NoError(tt, err) {
+				return
+			}
+			report, err := p.Fetch(context.TODO())
+			if tc.expectedError {
+				assert.Error(tt, err)
+				return
+			}
+			assert.NoError(tt, err)
+			assert.Equal(tt, tc.expected, report)
+		})
+	}
+}
+
+func TestParseMETAR(t *testing.T) {
+	reference := time.Date(2021, 3, 2, 12, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		name          string
+		report        string
+		expected      *WeatherPoint
+		expectedError bool
+	}{
+		{
+			name:     "Positive temperature",
+			report:   "METAR LEMD 021030Z 24005KT 9999 FEW040 12/03 Q1020",
+			expected: &WeatherPoint{Time: time.Date(2021, 3, 2, 10, 30, 0, 0, time.UTC), Temperature: 12},
+		},
+		{
+			name:     "Negative temperature",
+			report:   "KJFK 020951Z 31012KT 10SM CLR M05/M18 A3012",
+			expected: &WeatherPoint{Time: time.Date(2021, 3, 2, 9, 51, 0, 0, time.UTC), Temperature: -5},
+		},
+		{
+			name:     "Temperature in tenths in the remarks",
+			report:   "KJFK 020951Z 31012KT 10SM CLR M05/M18 A3012 RMK AO2 SLP199 T10501178",
+			expected: &WeatherPoint{Time: time.Date(2021, 3, 2, 9, 51, 0, 0, time.UTC), Temperature: -5.0},
+		},
+		{
+			name:     "Positive temperature in tenths",
+			report:   "KJFK 020951Z 31012KT 10SM CLR 04/M02 A3012 RMK AO2 T00441017",
+			expected: &WeatherPoint{Time: time.Date(2021, 3, 2, 9, 51, 0, 0, time.UTC), Temperature: 4.4},
+		},
+		{
+			name:     "Report of the previous month",
+			report:   "LEMD 282330Z 24005KT CAVOK 07/M01 Q1025",
+			expected: &WeatherPoint{Time: time.Date(2021, 2, 28, 23, 30, 0, 0, time.UTC), Temperature: 7},
+		},
+		{
+			name:     "Day missing in the previous month",
+			report:   "LEMD 301200Z 24005KT CAVOK 15/05 Q1025",
+			expected: &WeatherPoint{Time: time.Date(2021, 1, 30, 12, 0, 0, 0, time.UTC), Temperature: 15},
+		},
+		{
+			name:     "Missing dew point",
+			report:   "LEMD 021000Z 24005KT CAVOK 10/ Q1025",
+			expected: &WeatherPoint{Time: time.Date(2021, 3, 2, 10, 0, 0, 0, time.UTC), Temperature: 10},
+		},
+		{
+			name:   "Missing temperature",
+			report: "LEMD 021000Z 24005KT CAVOK /////// Q1025",
+		},
+		{
+			name:          "Missing time",
+			report:        "LEMD 24005KT CAVOK 10/02 Q1025",
+			expectedError: true,
+		},
+		{
+			name:          "Invalid time",
+			report:        "LEMD 022560Z 24005KT CAVOK 10/02 Q1025",
+			expectedError: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			point, err := ParseMETAR(tc.report, reference)
+			if tc.expectedError {
+				assert.Error(tt, err)
+				return
+			}
+			assert.NoError(tt, err)
+			assert.Equal(tt, tc.expected, point)
+		})
+	}
+}
+
+func TestParseMETARReports(t *testing.T) {
+	reports := strings.Join([]string{
+		"2021/01/31 23:30",
+		"LEMD 312330Z 24005KT CAVOK 01/M03 Q1025",
+		"",
+		"LEMD 010000Z 24005KT CAVOK 00/M03 Q1025",
+	}, "\n")
+	report, err := ParseMETARReports(strings.NewReader(reports), time.Date(2021, 3, 15, 0, 0, 0, 0, time.UTC))
+	assert.NoError(t, err)
+	assert.Equal(t, []WeatherPoint{
+		{Time: time.Date(2021, 1, 31, 23, 30, 0, 0, time.UTC), Temperature: 1},
+		{Time: time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC), Temperature: 0},
+	}, report.Observations)
+
+	_, err = ParseMETARReports(strings.NewReader("LEMD CAVOK 01/M03"), time.Now())
+	assert.Error(t, err)
+}